@@ -80,6 +80,19 @@ func Add(c *git.Client, args []string) error {
 	for i := range remaining {
 		files[i] = git.File(remaining[i])
 	}
-	_, err := git.Add(c, opts, files)
-	return err
+	changes, err := git.Add(c, opts, files)
+	if err != nil {
+		return err
+	}
+	if opts.DryRun {
+		// git says that it would add both new and modified files.
+		for _, change := range changes {
+			action := "add"
+			if change.Action == git.ChangeRemove {
+				action = "remove"
+			}
+			fmt.Printf("%s '%v'\n", action, change.Path)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/driusan/dgit/git"
+)
+
+// Tests that add -n and mv -n say what they would do in the same words as
+// git, without doing it.
+func TestDryRunOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cmddryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if _, err := git.Init(nil, git.InitOptions{Quiet: true}, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	c, err := git.NewClient(filepath.Join(dir, ".git"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"g", "gone"} {
+		if err := ioutil.WriteFile(name, []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Add(c, []string{"g", "gone"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("f", []byte("f\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("g", []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	add := func() error { return Add(c, []string{"-n", "f", "g", "gone"}) }
+	if got, want := captureStdout(t, add), "add 'f'\nadd 'g'\nremove 'gone'\n"; got != want {
+		t.Errorf("Unexpected add -n output: got %q want %q", got, want)
+	}
+	if err := os.Mkdir("d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	mv := func() error { return Mv(c, []string{"-n", "g", "d"}) }
+	if got, want := captureStdout(t, mv), "Checking rename of 'g' to 'd/g'\nRenaming g to d/g\n"; got != want {
+		t.Errorf("Unexpected mv -n output: got %q want %q", got, want)
+	}
+
+	files := func() error { return LsFiles(c, nil) }
+	if got, want := captureStdout(t, files), "g\ngone\n"; got != want {
+		t.Errorf("Dry run changed the index: got %q want %q", got, want)
+	}
+	if !git.File("g").Exists() {
+		t.Error("mv -n moved g")
+	}
+}
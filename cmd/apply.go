@@ -49,7 +49,7 @@ func Apply(c *git.Client, args []string) error {
 	flags.StringVar(&opts.IncludePattern, "include", "", "Only apply to files matching the given pattern")
 
 	flags.BoolVar(&opts.InaccurateEof, "inaccurate-eof", false, "Apply patches from diffs with inaccurate EOFs")
-	whitespace := flags.String("whitespace", "warn", "Determine how to handle patches with whitespace errors")
+	flags.StringVar(&opts.Whitespace, "whitespace", "", "How to handle whitespace errors in the patches: nowarn, warn, fix, error or error-all")
 
 	flags.BoolVar(&opts.Verbose, "verbose", false, "Report progress to stderr")
 	flags.BoolVar(&opts.Verbose, "v", false, "Alias of --verbose")
@@ -63,14 +63,9 @@ func Apply(c *git.Client, args []string) error {
 	flags.Parse(args)
 	args = flags.Args()
 
-	switch *whitespace {
-	case "nowarn", "warn", "fix", "error", "error-all":
-		opts.Whitespace = *whitespace
-	default:
-		return fmt.Errorf("Invalid option for --whitespace")
-	}
 	if opts.ThreeWay {
-		opts.Index = false
+		// As in git, --3way implies --index.
+		opts.Index = true
 		if opts.Reject || opts.Cached {
 			fmt.Fprintf(flag.CommandLine.Output(), "--3way is incompatible with --reject and --cached\n")
 			flags.Usage()
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Blame(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("blame", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	var opts git.BlameOptions
+	incremental := flags.Bool("incremental", false, "Show the result incrementally, in a format meant for machine consumption")
+	flags.BoolVar(&opts.ShowRoot, "root", c.GetConfig("blame.showRoot") == "true", "Do not treat root commits as boundaries")
+
+	// -M and -C take an optional score glued to them, and -C can be
+	// repeated, which the flag package can't parse.
+	var adjusted []string
+	for i, a := range args {
+		if a == "--" {
+			adjusted = append(adjusted, args[i:]...)
+			break
+		}
+		if len(a) < 2 || (a[:2] != "-M" && a[:2] != "-C") {
+			adjusted = append(adjusted, a)
+			continue
+		}
+		score := 0
+		if a[2:] != "" {
+			n, err := strconv.Atoi(a[2:])
+			if err != nil || n < 0 {
+				return fmt.Errorf("Invalid score for %v", a[:2])
+			}
+			score = n
+		}
+		if a[:2] == "-M" {
+			opts.MoveScore = git.DefaultBlameMoveScore
+			if score > 0 {
+				opts.MoveScore = score
+			}
+		} else {
+			opts.CopyLevel++
+			if score > 0 {
+				opts.CopyScore = score
+			}
+		}
+	}
+	flags.Parse(adjusted)
+	args = flags.Args()
+
+	var rev, file string
+	switch {
+	case len(args) == 1:
+		file = args[0]
+	case len(args) == 2 && args[0] == "--":
+		file = args[1]
+	case len(args) == 2:
+		rev, file = args[0], args[1]
+	case len(args) == 3 && args[1] == "--":
+		rev, file = args[0], args[2]
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	if rev != "" {
+		cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, rev)
+		if err != nil {
+			return err
+		}
+		opts.Revision = cmt
+	}
+	path, err := git.File(file).IndexPath(c)
+	if err != nil {
+		return err
+	}
+
+	if *incremental {
+		opts.Incremental = git.BlameIncrementalWriter(c, os.Stdout)
+		_, err := git.Blame(c, opts, path)
+		return err
+	}
+
+	boundaries := make(map[git.CommitID]bool)
+	opts.Incremental = func(g git.BlameGroup) error {
+		boundaries[g.Commit] = g.Boundary
+		return nil
+	}
+	lines, err := git.Blame(c, opts, path)
+	if err != nil {
+		return err
+	}
+
+	// The columns are padded to the widest value, as git does.
+	authors := make(map[git.CommitID]string)
+	dates := make(map[git.CommitID]string)
+	showPath := false
+	authorWidth, pathWidth := 0, 0
+	for _, l := range lines {
+		if _, ok := authors[l.Commit]; !ok {
+			author, err := l.Commit.GetAuthor(c)
+			if err != nil {
+				return err
+			}
+			date, err := l.Commit.GetDate(c)
+			if err != nil {
+				return err
+			}
+			authors[l.Commit] = author.Name
+			dates[l.Commit] = date.Format("2006-01-02 15:04:05 -0700")
+		}
+		if n := len(authors[l.Commit]); n > authorWidth {
+			authorWidth = n
+		}
+		if l.Path != path {
+			showPath = true
+		}
+		if n := len(l.Path); n > pathWidth {
+			pathWidth = n
+		}
+	}
+	lineWidth := len(fmt.Sprint(len(lines)))
+	for _, l := range lines {
+		id := l.Commit.String()[:8]
+		if boundaries[l.Commit] {
+			id = "^" + id[:7]
+		}
+		var name string
+		if showPath {
+			name = " " + l.Path.String() + strings.Repeat(" ", pathWidth-len(l.Path))
+		}
+		fmt.Printf("%v%v (%-*v %v %*d) %v\n", id, name, authorWidth, authors[l.Commit], dates[l.Commit], lineWidth, l.FinalLine, l.Line)
+	}
+	return nil
+}
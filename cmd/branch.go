@@ -3,8 +3,10 @@ package cmd
 import (
 	"flag"
 	"fmt"
-	"github.com/driusan/dgit/git"
 	"os"
+	"strings"
+
+	"github.com/driusan/dgit/git"
 )
 
 func Branch(c *git.Client, args []string) error {
@@ -20,10 +22,10 @@ func Branch(c *git.Client, args []string) error {
 	opts := git.BranchOptions{}
 
 	// These flags can be moved out of these lists and below as proper flags as they are implemented
-	for _, bf := range []string{"create-reflog", "M", "c", "copy", "C", "no-color", "i", "ignore-case", "no-column", "r", "remotes", "v", "vv", "verbose", "no-abbrev", "no-track", "unset-upstream", "edit-description"} {
+	for _, bf := range []string{"create-reflog", "M", "c", "copy", "C", "no-color", "i", "ignore-case", "no-column", "no-abbrev", "no-track", "unset-upstream", "edit-description"} {
 		flags.Var(newNotimplBoolValue(), bf, "Not implemented")
 	}
-	for _, sf := range []string{"color", "abbrev", "column", "sort", "no-merged", "contains", "no-contains", "points-at", "format", "set-upstream-to", "u"} {
+	for _, sf := range []string{"color", "abbrev", "column", "sort", "no-contains", "points-at", "format", "set-upstream-to", "u"} {
 		flags.Var(newNotimplStringValue(), sf, "Not implemented")
 	}
 
@@ -31,7 +33,7 @@ func Branch(c *git.Client, args []string) error {
 	flags.BoolVar(&opts.Force, "force", false, "Alias of -f")
 	flags.BoolVar(&opts.All, "all", false, "Show remote branches too")
 	flags.BoolVar(&opts.All, "a", false, "Alias of --all")
-	flags.BoolVar(&opts.Quiet, "quiet", false, "Do not print branches")
+	flags.BoolVar(&opts.Quiet, "quiet", false, "Suppress messages when creating or deleting branches")
 	flags.BoolVar(&opts.Quiet, "q", false, "Alias of --quiet")
 	flags.BoolVar(&opts.Move, "m", false, "Move/rename a branch")
 	flags.BoolVar(&opts.Move, "move", false, "Alias of -m")
@@ -41,8 +43,40 @@ func Branch(c *git.Client, args []string) error {
 	list := false
 	flags.BoolVar(&list, "l", false, "List branches")
 	flags.BoolVar(&list, "list", false, "Alias of -l")
+
+	listopts := git.BranchListOptions{}
+	flags.BoolVar(&listopts.Remotes, "r", false, "List remote branches")
+	flags.BoolVar(&listopts.Remotes, "remotes", false, "Alias of -r")
+	verbose := flags.Bool("v", false, "Show the commit and subject of each branch, and its relationship to its upstream")
+	flags.BoolVar(verbose, "verbose", false, "Alias of -v")
+	veryverbose := flags.Bool("vv", false, "Like -v, but also show the name of the upstream branch")
+	merged := flags.String("merged", "", "Only list branches reachable from the given commit")
+	nomerged := flags.String("no-merged", "", "Only list branches not reachable from the given commit")
+	contains := flags.String("contains", "", "Only list branches which contain the given commit")
 	flags.Parse(args)
 
+	listopts.All = opts.All
+	for _, filter := range []struct {
+		arg string
+		c   *git.Commitish
+	}{{*merged, &listopts.Merged}, {*nomerged, &listopts.NoMerged}, {*contains, &listopts.Contains}} {
+		if filter.arg == "" {
+			continue
+		}
+		cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, filter.arg)
+		if err != nil {
+			return err
+		}
+		*filter.c = cmt
+	}
+	listing := *verbose || *veryverbose || listopts.Remotes || listopts.Merged != nil || listopts.NoMerged != nil || listopts.Contains != nil
+	if listing && flags.NArg() != 0 && !list {
+		flags.Usage()
+	}
+	if listing || (!opts.Delete && (list || flags.NArg() == 0)) {
+		return listBranches(c, listopts, *verbose || *veryverbose, *veryverbose)
+	}
+
 	if opts.Delete {
 		for idx := range flags.Args() {
 			branch, err := git.GetBranch(c, flags.Arg(idx))
@@ -56,15 +90,7 @@ func Branch(c *git.Client, args []string) error {
 		return nil
 	}
 
-	if list {
-		_, err := git.BranchList(c, os.Stdout, opts, nil)
-		return err
-	}
-
 	switch flags.NArg() {
-	case 0:
-		_, err := git.BranchList(c, os.Stdout, opts, nil)
-		return err
 	case 1:
 		headref, err := git.SymbolicRefGet(c, git.SymbolicRefOptions{}, "HEAD")
 		if err != nil {
@@ -107,3 +133,70 @@ func Branch(c *git.Client, args []string) error {
 	return nil
 
 }
+
+// Prints the branches like "git branch", with the commit and tracking
+// information of each branch if verbose is set, and the name of its
+// upstream if upstream is set.
+func listBranches(c *git.Client, opts git.BranchListOptions, verbose, upstream bool) error {
+	branches, err := git.ListBranches(c, opts)
+	if err != nil {
+		return err
+	}
+	name := func(b git.BranchInfo) string {
+		if b.Detached {
+			return fmt.Sprintf("(HEAD detached at %s)", b.Commit.String()[:7])
+		}
+		n := b.Branch.BranchName()
+		if opts.Remotes {
+			n = strings.TrimPrefix(n, "remotes/")
+		}
+		return n
+	}
+	width := 0
+	for _, b := range branches {
+		if n := len(name(b)); n > width {
+			width = n
+		}
+	}
+	for _, b := range branches {
+		marker := " "
+		if b.Head {
+			marker = "*"
+		}
+		if b.Target != "" {
+			fmt.Printf("%s %s -> %s\n", marker, name(b), strings.TrimPrefix(b.Target.BranchName(), "remotes/"))
+			continue
+		}
+		if !verbose {
+			fmt.Printf("%s %s\n", marker, name(b))
+			continue
+		}
+
+		var tracking []string
+		if upstream && b.Upstream != "" {
+			tracking = append(tracking, strings.TrimPrefix(b.Upstream.BranchName(), "remotes/"))
+		}
+		var state []string
+		switch {
+		case b.UpstreamGone:
+			state = append(state, "gone")
+		case b.Ahead > 0 && b.Behind > 0:
+			state = append(state, fmt.Sprintf("ahead %d, behind %d", b.Ahead, b.Behind))
+		case b.Ahead > 0:
+			state = append(state, fmt.Sprintf("ahead %d", b.Ahead))
+		case b.Behind > 0:
+			state = append(state, fmt.Sprintf("behind %d", b.Behind))
+		}
+		var annotation string
+		switch {
+		case len(tracking) > 0 && len(state) > 0:
+			annotation = "[" + tracking[0] + ": " + state[0] + "] "
+		case len(tracking) > 0:
+			annotation = "[" + tracking[0] + "] "
+		case len(state) > 0:
+			annotation = "[" + state[0] + "] "
+		}
+		fmt.Printf("%s %-*s %s %s%s\n", marker, width, name(b), b.Commit.String()[:7], annotation, b.Subject)
+	}
+	return nil
+}
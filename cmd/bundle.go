@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Bundle(c *git.Client, args []string) error {
+	flags := newFlagSet("bundle")
+	quiet := flags.Bool("q", false, "Do not show progress")
+	flags.BoolVar(quiet, "quiet", false, "Alias of -q")
+	flags.Parse(args)
+	args = flags.Args()
+
+	if len(args) < 2 {
+		flags.Usage()
+		return fmt.Errorf("Must provide subcommand and bundle file")
+	}
+	opts := git.BundleOptions{Progress: !*quiet}
+	subcommand, file, args := args[0], args[1], args[2:]
+	switch subcommand {
+	case "create":
+		if len(args) == 0 {
+			flags.Usage()
+			return fmt.Errorf("Must provide revisions to bundle")
+		}
+		out := os.Stdout
+		if file != "-" {
+			f, err := os.Create(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := git.BundleCreate(c, opts, out, args); err != nil {
+			if file != "-" {
+				os.Remove(file)
+			}
+			return err
+		}
+		return nil
+	case "verify", "list-heads", "unbundle":
+	default:
+		flags.Usage()
+		return fmt.Errorf("Unknown bundle subcommand %v", subcommand)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch subcommand {
+	case "verify":
+		header, err := git.BundleVerify(c, opts, f)
+		if err != nil {
+			return err
+		}
+		if len(header.Prerequisites) == 0 {
+			fmt.Println("The bundle records a complete history.")
+		} else {
+			fmt.Printf("The bundle requires these %d refs:\n", len(header.Prerequisites))
+			for _, p := range header.Prerequisites {
+				fmt.Printf("%v %v\n", p.Commit, p.Comment)
+			}
+		}
+		fmt.Printf("%v is okay\n", file)
+		return nil
+	case "list-heads":
+		header, err := git.BundleVerify(c, opts, f)
+		if err != nil {
+			return err
+		}
+		for _, ref := range header.Refs {
+			fmt.Printf("%v %v\n", ref.Value, ref.Name)
+		}
+		return nil
+	default:
+		refs, err := git.BundleUnbundle(c, opts, f)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			fmt.Printf("%v %v\n", ref.Value, ref.Name)
+		}
+		return nil
+	}
+}
@@ -118,5 +118,6 @@ func Checkout(c *git.Client, args []string) error {
 		gfiles[i] = git.File(f)
 	}
 
-	return git.Checkout(c, options, thing, gfiles)
+	_, err := git.Checkout(c, options, thing, gfiles)
+	return err
 }
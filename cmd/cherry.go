@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Cherry(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("cherry", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	verbose := flags.Bool("v", false, "Show the subjects of the commits")
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) > 3 {
+		flags.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+
+	var upstream, head, limit git.Commitish
+	if len(args) > 0 {
+		cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, args[0])
+		if err != nil {
+			return err
+		}
+		upstream = cmt
+	} else {
+		branch := c.GetHeadBranch().Upstream(c)
+		if branch == "" {
+			return fmt.Errorf("could not find a tracked remote branch, please specify <upstream> manually")
+		}
+		upstream = branch
+	}
+	headName := "HEAD"
+	if len(args) > 1 {
+		headName = args[1]
+	}
+	cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, headName)
+	if err != nil {
+		return err
+	}
+	head = cmt
+	if len(args) > 2 {
+		if limit, err = git.RevParseCommitish(c, &git.RevParseOptions{}, args[2]); err != nil {
+			return err
+		}
+	}
+
+	entries, err := git.Cherry(c, upstream, head)
+	if err != nil {
+		return err
+	}
+	// The commits reachable from the limit aren't shown.
+	excluded := make(map[git.CommitID]struct{})
+	if limit != nil {
+		if err := git.RevListCallback(c, git.RevListOptions{Quiet: true}, []git.Commitish{limit}, nil, func(s git.Sha1) error {
+			excluded[git.CommitID(s)] = struct{}{}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		if _, ok := excluded[e.Commit]; ok {
+			continue
+		}
+		if !*verbose {
+			fmt.Printf("%c %v\n", e.Status, e.Commit)
+			continue
+		}
+		msg, err := e.Commit.GetCommitMessage(c)
+		if err != nil {
+			return err
+		}
+		title := strings.SplitN(strings.TrimSpace(msg.String()), "\n", 2)[0]
+		fmt.Printf("%c %v %v\n", e.Status, e.Commit, title)
+	}
+	return nil
+}
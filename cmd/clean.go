@@ -50,5 +50,6 @@ func Clean(c *git.Client, args []string) error {
 	for _, p := range paths {
 		files = append(files, git.File(p))
 	}
-	return git.Clean(c, opts, files)
+	_, err = git.Clean(c, opts, files)
+	return err
 }
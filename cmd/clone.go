@@ -24,12 +24,23 @@ func Clone(c *git.Client, args []string) error {
 	flags.BoolVar(&initOpts.Bare, "bare", false, "Make a bare Git repository.")
 	template := ""
 	flags.StringVar(&template, "template", "", "Specify the directory from which templates will be used.")
+	flags.BoolVar(&opts.Shared, "shared", false, "Borrow the objects from a local repository instead of copying them.")
+	flags.BoolVar(&opts.Shared, "s", false, "Alias of --shared")
+	reference := ""
+	flags.StringVar(&reference, "reference", "", "Borrow objects from the local reference repository.")
+	referenceIfAble := ""
+	flags.StringVar(&referenceIfAble, "reference-if-able", "", "Like --reference, but ignore the reference repository if it doesn't exist.")
+	flags.BoolVar(&opts.Dissociate, "dissociate", false, "Copy borrowed objects into the clone and stop borrowing them.")
+	flags.Var(newInt32Value(&opts.Depth, 0), "depth", "Make a shallow clone with the history truncated to the specified number of commits")
+	flags.Var(newDateValue(&opts.ShallowSince), "shallow-since", "Make a shallow clone with the history after the date")
+	flags.Var(NewMultiStringValue(&opts.ShallowExclude), "shallow-exclude", "Make a shallow clone with the history excluding commits reachable from the ref")
+	flags.StringVar(&opts.Filter, "filter", "", "Make a partial clone, omitting the objects excluded by the filter-spec (blob:none, blob:limit=<n> or tree:<depth>)")
 
 	// These flags can be moved out of these lists and below as proper flags as they are implemented
-	for _, bf := range []string{"l", "s", "no-hardlinks", "n", "mirror", "dissociate", "single-branch", "no-single-branch", "no-tags", "shallow-submodules", "no-shallow-submodules"} {
+	for _, bf := range []string{"l", "no-hardlinks", "n", "mirror", "single-branch", "no-single-branch", "no-tags", "shallow-submodules", "no-shallow-submodules"} {
 		flags.Var(newNotimplBoolValue(), bf, "Not implemented")
 	}
-	for _, sf := range []string{"o", "b", "u", "reference", "separate-git-dir", "depth", "recurse-submodules", "jobs"} {
+	for _, sf := range []string{"o", "b", "u", "separate-git-dir", "recurse-submodules", "jobs"} {
 		flags.Var(newNotimplStringValue(), sf, "Not implemented")
 	}
 
@@ -38,6 +49,12 @@ func Clone(c *git.Client, args []string) error {
 	if template != "" {
 		initOpts.Template = git.File(template)
 	}
+	if reference != "" {
+		opts.Reference = git.File(reference)
+	} else if referenceIfAble != "" {
+		opts.Reference = git.File(referenceIfAble)
+		opts.ReferenceIfAble = true
+	}
 
 	opts.InitOptions = initOpts
 	var repoid git.Remote
@@ -26,6 +26,29 @@ After doing this, you may fix the identity used for this commit with:
 `, committer)
 }
 
+// Removes the -S[<keyid>] and --gpg-sign[=<keyid>] options, which the flag
+// package can't parse, from args and sets sign and key from them.
+func parseGPGSignArgs(args []string, sign *bool, key *git.GPGKeyId) []string {
+	var adjusted []string
+	for i, a := range args {
+		switch {
+		case a == "--":
+			return append(adjusted, args[i:]...)
+		case a == "-S", a == "--gpg-sign":
+			*sign = true
+		case strings.HasPrefix(a, "--gpg-sign="):
+			*sign = true
+			*key = git.GPGKeyId(strings.TrimPrefix(a, "--gpg-sign="))
+		case strings.HasPrefix(a, "-S"):
+			*sign = true
+			*key = git.GPGKeyId(a[2:])
+		default:
+			adjusted = append(adjusted, a)
+		}
+	}
+	return adjusted
+}
+
 // Commit implements the command "git commit" in the repository pointed
 // to by c.
 func Commit(c *git.Client, args []string) (string, error) {
@@ -68,8 +91,21 @@ func Commit(c *git.Client, args []string) (string, error) {
 	flags.BoolVar(&opts.All, "all", false, "")
 	flags.BoolVar(&opts.All, "a", false, "Alias for --all")
 
+	flags.BoolVar(&opts.NoVerify, "no-verify", false, "Bypass the pre-commit and commit-msg hooks")
+	flags.BoolVar(&opts.NoVerify, "n", false, "Alias for --no-verify")
+
+	flags.BoolVar(&opts.Only, "only", false, "Only commit the paths given, or only change the message with none")
+	flags.BoolVar(&opts.Only, "o", false, "Alias for --only")
+
+	var fixup, squash string
+	flags.StringVar(&fixup, "fixup", "", "Make a fixup! commit for the given commit, or an amend! commit with amend:<commit> or reword:<commit>")
+	flags.StringVar(&squash, "squash", "", "Make a squash! commit for the given commit")
+
+	flags.BoolVar(&opts.GPGSign, "S", false, "GPG-sign the commit, optionally with the key given as -S<keyid>")
+	flags.BoolVar(&opts.NoGPGSign, "no-gpg-sign", false, "Don't GPG-sign the commit, even if commit.gpgSign is set")
+
 	adjustedArgs := []string{}
-	for _, a := range args {
+	for _, a := range parseGPGSignArgs(args, &opts.GPGSign, &opts.GPGKey) {
 		// Unglue any glued -m arguments
 		if strings.HasPrefix(a, "-m") && a != "-m" {
 			adjustedArgs = append(adjustedArgs, "-m", a[2:])
@@ -90,17 +126,68 @@ func Commit(c *git.Client, args []string) (string, error) {
 		message = append(message, string(f))
 	}
 
-	if len(message) == 0 || edit {
+	var fixupMessage git.CommitMessage
+	plainFixup := false
+	if fixup != "" || squash != "" {
+		if fixup != "" && squash != "" {
+			return "", fmt.Errorf("Only one of --fixup or --squash can be used")
+		}
+		kind, target := "squash", squash
+		if fixup != "" {
+			kind, target = "fixup", fixup
+			if i := strings.Index(fixup, ":"); i > 0 && (fixup[:i] == "amend" || fixup[:i] == "reword") {
+				kind, target = fixup[:i], fixup[i+1:]
+			}
+		}
+		switch kind {
+		case "amend", "reword":
+			if len(message) != 0 {
+				return "", fmt.Errorf("--fixup=%v: can not be used with -m or -F", kind)
+			}
+			if kind == "reword" {
+				if len(flags.Args()) != 0 || opts.All {
+					return "", fmt.Errorf("--fixup=reword: can not be used with paths or -a")
+				}
+				opts.Only = true
+				opts.AllowEmpty = true
+			}
+		}
+		cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, target)
+		if err != nil {
+			return "", err
+		}
+		fixupMessage, err = git.FixupMessage(c, kind, cmt)
+		if err != nil {
+			return "", err
+		}
+		// A fixup! commit's message is thrown away by rebase, so
+		// the editor isn't started for it without --edit.
+		plainFixup = kind == "fixup"
+	}
+
+	if (len(message) == 0 && !plainFixup) || edit {
 		opts.NoEdit = false
 	}
 
 	finalMessage := strings.Join(message, "\n\n") + "\n"
+	if fixupMessage != "" {
+		if len(message) == 0 {
+			finalMessage = fixupMessage.String()
+		} else {
+			finalMessage = fixupMessage.String() + "\n" + finalMessage
+		}
+	}
 
 	if !opts.NoEdit {
+		prefix, err := statusPathPrefix(c)
+		if err != nil {
+			return "", err
+		}
 		s, err := git.StatusLong(
 			c,
 			nil,
 			git.StatusUntrackedAll,
+			prefix,
 			"# ",
 		)
 		if err != nil {
@@ -130,6 +217,7 @@ func Commit(c *git.Client, args []string) (string, error) {
 		printNoUserMessage(committer)
 		fallthrough
 	case nil:
+		autoGC(c)
 		if opts.Quiet {
 			return "", nil
 		}
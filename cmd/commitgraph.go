@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/driusan/dgit/git"
+)
+
+func CommitGraph(c *git.Client, args []string) error {
+	flags := newFlagSet("commit-graph")
+	flags.Parse(args)
+	args = flags.Args()
+
+	if len(args) != 1 {
+		flags.Usage()
+		return fmt.Errorf("Must provide subcommand")
+	}
+	switch args[0] {
+	case "write":
+		return git.WriteCommitGraph(c)
+	default:
+		flags.Usage()
+		return fmt.Errorf("Unknown subcommand %v", args[0])
+	}
+}
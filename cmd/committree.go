@@ -26,6 +26,11 @@ func CommitTree(c *git.Client, args []string) (git.CommitID, error) {
 	messageFile := ""
 	flags.StringVar(&messageFile, "F", "", "Read the commit log message from the given file.")
 
+	var opts git.CommitTreeOptions
+	flags.BoolVar(&opts.GPGSign, "S", false, "GPG-sign the commit, optionally with the key given as -S<keyid>")
+	flags.BoolVar(&opts.NoGPGSign, "no-gpg-sign", false, "Don't GPG-sign the commit, even if commit.gpgSign is set")
+	args = parseGPGSignArgs(args, &opts.GPGSign, &opts.GPGKey)
+
 	// Commit-tree allows flags to go after the tree but flag package doesnt support it
 	// We shift them to the beginning of the arguments list and parse again.
 	extraFlags := []string{}
@@ -100,5 +105,5 @@ func CommitTree(c *git.Client, args []string) (git.CommitID, error) {
 		finalMessage = "\n" + string(m)
 	}
 
-	return git.CommitTree(c, git.CommitTreeOptions{}, tree, parents, strings.TrimSpace(finalMessage))
+	return git.CommitTree(c, opts, tree, parents, strings.TrimSpace(finalMessage))
 }
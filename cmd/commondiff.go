@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/driusan/dgit/git"
 )
@@ -25,10 +27,54 @@ func parseCommonDiffFlags(c *git.Client, options *git.DiffCommonOptions, default
 	U0 := flags.Bool("U0", false, "Alias of -U 0. (This is primarily for test compatibility)")
 	flags.BoolVar(&options.Raw, "raw", true, "Generate the diff in raw format")
 	flags.BoolVar(&options.ExitCode, "exit-code", false, "Exit with an exit code of 1 if there are any diffs")
+	flags.BoolVar(&options.NullTerminate, "z", false, "Terminate raw output with NUL, not LF, and do not quote paths")
+	flags.BoolVar(&options.Relative, "relative", false, "Only show changes in the current directory, relative to it")
+	color := flags.String("color", "", "Color the patch: always, never or auto")
+	algorithm := flags.String("diff-algorithm", "", "Use the diff algorithm myers (the default), patience or histogram")
+	patience := flags.Bool("patience", false, "Generate the patch with the patience algorithm")
+	histogram := flags.Bool("histogram", false, "Generate the patch with the histogram algorithm")
+	minimal := flags.Bool("minimal", false, "Generate the patch with the myers algorithm")
+	nocolor := flags.Bool("no-color", false, "Equivalent to --color=never")
 
-	flags.Parse(args)
+	adjustedArgs := make([]string, 0, len(args))
+	for _, a := range args {
+		if a == "--color" {
+			a = "--color=always"
+		}
+		adjustedArgs = append(adjustedArgs, a)
+	}
+	flags.Parse(adjustedArgs)
 	args = flags.Args()
 
+	if *nocolor {
+		*color = "never"
+	}
+	// Only the porcelain commands, which generate a patch by default,
+	// use the color config.
+	colorconfig := ""
+	if defaultPatch {
+		colorconfig = "diff"
+	}
+	if options.Color, err = git.ColorEnabled(c, colorconfig, *color, os.Stdout); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case *patience:
+		*algorithm = "patience"
+	case *histogram:
+		*algorithm = "histogram"
+	case *minimal:
+		*algorithm = "minimal"
+	case *algorithm == "" && defaultPatch:
+		// Like the color config, diff.algorithm is only used by the
+		// porcelain commands.
+		*algorithm = c.GetConfig("diff.algorithm")
+	}
+	if options.Algorithm, err = git.ParseDiffAlgorithm(*algorithm); err != nil {
+		return nil, err
+	}
+
 	if *patch || *p || *u {
 		options.Patch = true
 		options.Raw = false
@@ -66,3 +112,40 @@ func printDiffs(c *git.Client, options git.DiffCommonOptions, diffs []git.HashDi
 	}
 	return nil
 }
+
+// Parses the rename and copy detection options out of args. They're parsed
+// by hand, since the similarity is attached to the flag (as in -M90%), which
+// the flag package doesn't support. The remaining arguments are returned.
+func parseRenameFlags(args []string, opts *git.DiffRenameOptions) ([]string, error) {
+	var remaining []string
+	for i, a := range args {
+		var err error
+		switch {
+		case a == "--":
+			return append(remaining, args[i:]...), nil
+		case a == "--find-copies-harder":
+			opts.FindCopiesHarder = true
+		case strings.HasPrefix(a, "--find-renames"):
+			opts.FindRenames, err = git.ParseSimilarity(strings.TrimPrefix(strings.TrimPrefix(a, "--find-renames"), "="))
+		case strings.HasPrefix(a, "--find-copies"):
+			opts.FindCopies, err = git.ParseSimilarity(strings.TrimPrefix(strings.TrimPrefix(a, "--find-copies"), "="))
+		case strings.HasPrefix(a, "-M"):
+			opts.FindRenames, err = git.ParseSimilarity(a[2:])
+		case strings.HasPrefix(a, "-C"):
+			// As in git, giving -C twice is the same as
+			// --find-copies-harder.
+			if opts.FindCopies > 0 {
+				opts.FindCopiesHarder = true
+			}
+			opts.FindCopies, err = git.ParseSimilarity(a[2:])
+		case strings.HasPrefix(a, "-l") && len(a) > 2:
+			opts.RenameLimit, err = strconv.Atoi(a[2:])
+		default:
+			remaining = append(remaining, a)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return remaining, nil
+}
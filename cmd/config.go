@@ -19,10 +19,13 @@ func Config(c *git.Client, args []string) error {
 	}
 
 	get := flags.Bool("get", false, "Get the value for a given key")
+	getall := flags.Bool("get-all", false, "Get all of the values for a multi-valued key, optionally matching a value pattern")
+	getregexp := flags.Bool("get-regexp", false, "Get the keys which match a regular expression and their values")
 	unset := flags.Bool("unset", false, "Remove the line matching the key")
 	unsetall := flags.Bool("unset-all", false, "Remove all lines matching the key")
 	list := flags.Bool("list", false, "List all variables along with their values")
 	global := flags.Bool("global", false, "For writing options: write to global file rather than respository")
+	noIncludes := flags.Bool("no-includes", false, "Do not read the files included by include.path and includeIf.*.path")
 
 	// Type canonicalization isn't currently supported
 	//  and so we just allow them and return the raw value
@@ -52,6 +55,10 @@ func Config(c *git.Client, args []string) error {
 	var action string
 	if *get {
 		action = "get"
+	} else if *getall {
+		action = "getall"
+	} else if *getregexp {
+		action = "getregexp"
 	} else if *unset {
 		action = "unset"
 	} else if *unsetall {
@@ -64,6 +71,16 @@ func Config(c *git.Client, args []string) error {
 		action = "set"
 	}
 
+	switch action {
+	case "get", "getall", "getregexp", "list":
+		// The included files are read, but never written to.
+		if !*noIncludes {
+			if config, err = config.WithIncludes(c); err != nil {
+				return err
+			}
+		}
+	}
+
 	switch action {
 	case "get":
 		if flags.NArg() < 1 {
@@ -82,6 +99,40 @@ func Config(c *git.Client, args []string) error {
 		}
 		fmt.Printf("%s\n", val)
 		return nil
+	case "getall":
+		if flags.NArg() < 1 {
+			fmt.Fprintf(flag.CommandLine.Output(), "Missing value to get\n")
+			flags.Usage()
+			os.Exit(2)
+		}
+		values, err := config.GetAll(flags.Arg(0), flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			os.Exit(1)
+		}
+		for _, val := range values {
+			fmt.Printf("%s\n", val)
+		}
+		return nil
+	case "getregexp":
+		if flags.NArg() < 1 {
+			fmt.Fprintf(flag.CommandLine.Output(), "Missing pattern to get\n")
+			flags.Usage()
+			os.Exit(2)
+		}
+		entries, err := config.GetRegexp(flags.Arg(0), flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			os.Exit(1)
+		}
+		for _, entry := range entries {
+			fmt.Printf("%s\n", entry)
+		}
+		return nil
 	case "set":
 		if flags.NArg() < 2 {
 			fmt.Fprintf(flag.CommandLine.Output(), "Missing value to set config to\n")
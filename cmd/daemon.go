@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/driusan/dgit/git"
+)
+
+// Daemon implements "git daemon", which serves the repositories under the
+// base path over the git:// protocol.
+func Daemon(args []string) error {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	opts := git.DaemonOptions{}
+	flags.StringVar(&opts.BasePath, "base-path", "", "Serve the requested paths relative to this directory")
+	flags.BoolVar(&opts.ExportAll, "export-all", false, "Serve repositories without a git-daemon-export-ok file")
+	listen := flags.String("listen", "", "Listen on this host or IP address")
+	port := flags.Int("port", 9418, "Listen on this port")
+	enable := flags.String("enable", "", "Enable the service (only receive-pack can be enabled)")
+	flags.Parse(args)
+
+	switch *enable {
+	case "":
+	case "receive-pack":
+		opts.ReceivePack = true
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown service %v\n", *enable)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(*listen, strconv.Itoa(*port)))
+	if err != nil {
+		return err
+	}
+	return git.Serve(l, opts)
+}
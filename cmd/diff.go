@@ -26,6 +26,9 @@ func Diff(c *git.Client, args []string) error {
 	flags.BoolVar(&options.NoIndex, "no-index", false, "Use diff to display difference between files on the filesystem")
 
 	args, err := parseCommonDiffFlags(c, &options.DiffCommonOptions, true, flags, args)
+	if err != nil {
+		return err
+	}
 
 	if staged || cached {
 		options.Staged = true
@@ -30,6 +30,15 @@ func DiffTree(c *git.Client, args []string) error {
 	flags.BoolVar(&options.Raw, "raw", true, "Generate the diff in raw format")
 	flags.BoolVar(&options.Recurse, "r", false, "Recurse into subtrees")
 
+	flags.BoolVar(&options.NoRenames, "no-renames", false, "Turn off rename detection")
+	flags.BoolVar(&options.NameOnly, "name-only", false, "Only show the names of the changed files")
+	flags.BoolVar(&options.NameStatus, "name-status", false, "Only show the status and names of the changed files")
+	root := flags.Bool("root", false, "Show a root commit as adding all of its files")
+
+	args, err := parseRenameFlags(args, &options.DiffRenameOptions)
+	if err != nil {
+		return err
+	}
 	adjustedArgs := []string{}
 	for _, a := range args {
 		if strings.HasPrefix(a, "-U") && a != "-U" {
@@ -59,21 +68,65 @@ func DiffTree(c *git.Client, args []string) error {
 
 	options.NumContextLines = 3
 
-	if len(args) < 2 {
+	if len(args) < 1 {
 		flags.Usage()
-		return fmt.Errorf("Must provide two <tree-ish>es. (One not yet supported)")
+		return fmt.Errorf("Must provide a commit or two <tree-ish>es.")
 	}
-	treeish, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[0])
-	if err != nil {
-		return err
-	}
-	treeish2, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[1])
-	if err != nil {
-		return err
+
+	var diffs []git.HashDiff
+	if len(args) == 1 {
+		// A single commit is compared to its first parent, after a
+		// line with the commit.
+		cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, args[0])
+		if err != nil {
+			return err
+		}
+		cid, err := cmt.CommitID(c)
+		if err != nil {
+			return err
+		}
+		parents, err := cid.Parents(c)
+		if err != nil {
+			return err
+		}
+		if len(parents) == 0 && !*root {
+			return nil
+		}
+		var parent git.Treeish
+		if len(parents) > 0 {
+			parent = parents[0]
+		}
+		if diffs, err = git.DiffTree(c, &options, parent, cid, nil); err != nil {
+			return err
+		}
+		fmt.Println(cid)
+	} else {
+		treeish, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[0])
+		if err != nil {
+			return err
+		}
+		treeish2, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[1])
+		if err != nil {
+			return err
+		}
+		if diffs, err = git.DiffTree(c, &options, treeish, treeish2, args[2:]); err != nil {
+			return err
+		}
 	}
-	diffs, err := git.DiffTree(c, &options, treeish, treeish2, args[2:])
 	for _, diff := range diffs {
-		fmt.Printf("%v\n", diff)
+		if options.Recurse && (diff.Src.FileMode == git.ModeTree || diff.Dst.FileMode == git.ModeTree) {
+			// As in git, -r shows the files in the subtrees
+			// instead of the subtrees.
+			continue
+		}
+		switch {
+		case options.NameOnly:
+			fmt.Printf("%v\n", diff.Name)
+		case options.NameStatus:
+			fmt.Printf("%v\n", diff.NameStatus())
+		default:
+			fmt.Printf("%v\n", diff)
+		}
 	}
-	return err
+	return nil
 }
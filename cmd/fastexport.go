@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func FastExport(c *git.Client, args []string) error {
+	flags := newFlagSet("fast-export")
+	nodata := flags.Bool("no-data", false, "Do not output blobs, refer to them by their object name instead")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("Must provide references to export")
+	}
+	var revs []git.Commitish
+	for _, arg := range flags.Args() {
+		if arg == "HEAD" {
+			ref, err := git.SymbolicRefGet(c, git.SymbolicRefOptions{}, "HEAD")
+			if err != nil {
+				return err
+			}
+			revs = append(revs, ref)
+			continue
+		}
+		rev, err := git.RevParseCommitish(c, &git.RevParseOptions{}, arg)
+		if err != nil {
+			return err
+		}
+		revs = append(revs, rev)
+	}
+	return git.FastExport(c, git.FastExportOptions{NoData: *nodata}, revs, os.Stdout)
+}
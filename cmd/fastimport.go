@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func FastImport(c *git.Client, args []string) error {
+	flags := newFlagSet("fast-import")
+	force := flags.Bool("force", false, "Update modified existing branches, even if it loses commits")
+	flags.Parse(args)
+
+	return git.FastImport(c, git.FastImportOptions{Force: *force}, os.Stdin)
+}
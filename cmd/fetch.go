@@ -3,6 +3,7 @@ package cmd
 import (
 	"flag"
 	"fmt"
+	"strconv"
 
 	"github.com/driusan/dgit/git"
 )
@@ -10,16 +11,39 @@ import (
 // These options can be shared with other subcommands that fetch, such as pull
 func addSharedFetchFlags(flags *flag.FlagSet, options *git.FetchOptions) {
 	// These flags can be moved out of these lists and below as proper flags as they are implemented
-	for _, bf := range []string{"all", "a", "append", "unshallow", "update-shallow", "dry-run", "k", "keep", "multiple", "p", "prune", "P", "prune-tags", "n", "no-tags", "t", "tags", "no-recurse-submodules", "u", "update-head-ok", "q", "quiet", "v", "verbose", "progress", "4", "ipv4", "ipv6"} {
+	for _, bf := range []string{"all", "a", "append", "update-shallow", "dry-run", "k", "keep", "multiple", "p", "prune", "P", "prune-tags", "n", "no-tags", "t", "tags", "no-recurse-submodules", "u", "update-head-ok", "q", "quiet", "v", "verbose", "progress", "4", "ipv4", "ipv6"} {
 		flags.Var(newNotimplBoolValue(), bf, "Not implemented")
 	}
-	for _, sf := range []string{"deepend", "shallow-since", "shallow-exclude", "refmap", "recurse-submodules", "j", "jobs", "submodule-prefix", "recurse-submodules-default", "upload-pack", "o", "server-option"} {
+	for _, sf := range []string{"refmap", "recurse-submodules", "j", "jobs", "submodule-prefix", "recurse-submodules-default", "upload-pack", "o", "server-option"} {
 		flags.Var(newNotimplStringValue(), sf, "Not implemented")
 	}
 
-	options.Depth = int32(*flags.Int("depth", 0, "Limit fetching to the specified number of commits. This is current a no-op."))
+	flags.Var(newInt32Value(&options.Depth, 0), "depth", "Limit fetching to the specified number of commits from the tip of each branch")
+	flags.Var(&deepenValue{options}, "deepen", "Deepen the history of a shallow repository by the specified number of commits")
+	flags.Var(newDateValue(&options.ShallowSince), "shallow-since", "Deepen or shorten the history of a shallow repository to include commits after the date")
+	flags.Var(NewMultiStringValue(&options.ShallowExclude), "shallow-exclude", "Deepen or shorten the history of a shallow repository to exclude commits reachable from the ref")
+	flags.BoolVar(&options.Unshallow, "unshallow", false, "Convert a shallow repository to a complete one")
+	flags.StringVar(&options.Filter, "filter", "", "Omit the objects excluded by the filter-spec (blob:none, blob:limit=<n> or tree:<depth>)")
 }
 
+// A flag value for --deepen, which sets the depth relative to the current
+// shallow boundary.
+type deepenValue struct {
+	opts *git.FetchOptions
+}
+
+func (d *deepenValue) Set(val string) error {
+	n, err := strconv.ParseInt(val, 10, 32)
+	if err != nil {
+		return err
+	}
+	d.opts.Depth = int32(n)
+	d.opts.DeepenRelative = true
+	return nil
+}
+
+func (d *deepenValue) String() string { return "" }
+
 func Fetch(c *git.Client, args []string) error {
 	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
 	flags.SetOutput(flag.CommandLine.Output())
@@ -51,5 +75,9 @@ func Fetch(c *git.Client, args []string) error {
 			refspecs = append(refspecs, git.RefSpec(ref))
 		}
 	}
-	return git.Fetch(c, opts, repository, refspecs)
+	if err := git.Fetch(c, opts, repository, refspecs); err != nil {
+		return err
+	}
+	autoGC(c)
+	return nil
 }
@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 )
 
 // A string value compatible with a flag var
@@ -47,6 +49,63 @@ func (s *multiStringValue) Get() interface{} { return []string(*s) }
 
 func (s *multiStringValue) String() string { return fmt.Sprintf("%v\n", *s) }
 
+// An int32 value compatible with a flag var, for options such as depths
+//  which are int32s in the git package.
+type int32Value int32
+
+func newInt32Value(p *int32, val int32) *int32Value {
+	*p = val
+	return (*int32Value)(p)
+}
+
+func (i *int32Value) Set(val string) error {
+	n, err := strconv.ParseInt(val, 10, 32)
+	if err != nil {
+		return err
+	}
+	*i = int32Value(n)
+	return nil
+}
+
+func (i *int32Value) Get() interface{} { return int32(*i) }
+
+func (i *int32Value) String() string { return strconv.Itoa(int(*i)) }
+
+// A time value compatible with a flag var. It accepts a date
+//  in the form YYYY-MM-DD, a unix timestamp, or a relative time
+//  in the form "<n>.<unit>.ago".
+type dateValue time.Time
+
+func newDateValue(p *time.Time) *dateValue {
+	return (*dateValue)(p)
+}
+
+func (d *dateValue) Set(val string) error {
+	if t, err := time.ParseInLocation("2006-01-02", val, time.Local); err == nil {
+		*d = dateValue(t)
+		return nil
+	}
+	if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+		*d = dateValue(time.Unix(n, 0))
+		return nil
+	}
+	t, err := parseExpiry(val)
+	if err != nil {
+		return fmt.Errorf("Unsupported date: %v", val)
+	}
+	*d = dateValue(t)
+	return nil
+}
+
+func (d *dateValue) Get() interface{} { return time.Time(*d) }
+
+func (d *dateValue) String() string {
+	if time.Time(*d).IsZero() {
+		return ""
+	}
+	return time.Time(*d).Format("2006-01-02")
+}
+
 // A string value that indicates that it is not yet implemented if it's used.
 type notimplStringValue string
 
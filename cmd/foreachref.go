@@ -4,7 +4,6 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/driusan/dgit/git"
 )
@@ -18,34 +17,15 @@ func ForEachRef(c *git.Client, args []string) error {
 		flags.PrintDefaults()
 	}
 
-	// These flags can be moved out of these lists and below as proper flags as they are implemented
-	for _, sf := range []string{"format"} {
-		flags.Var(newNotimplStringValue(), sf, "Not implemented")
-	}
+	opts := git.ForEachRefOptions{}
+	flags.StringVar(&opts.Format, "format", "", "Format each ref with the given format string")
+	flags.Var(NewMultiStringValue(&opts.Sort), "sort", "Sort by the given field (may be given multiple times)")
+	flags.IntVar(&opts.Count, "count", 0, "Show at most the given number of refs")
 
 	flags.Parse(args)
-	refs, err := git.ShowRef(c, git.ShowRefOptions{}, []string{})
+	lines, err := git.ForEachRef(c, opts, flags.Args())
 	if err != nil {
 		return err
 	}
-
-	patterns := flags.Args()
-
-	for _, ref := range refs {
-		if len(patterns) == 0 {
-			fmt.Printf("%s %s\t%s\n", ref.Value, "commit", ref.Name) // FIXME check if commit or other object type
-		} else {
-			for _, pattern := range patterns {
-				if strings.HasPrefix(ref.Name, pattern) { // FIXME support actual patterns and match only on path segments
-					fmt.Printf("%s %s\t%s\n", ref.Value, "commit", ref.Name) // FIXME check if commit or other object type
-					break
-				}
-			}
-		}
-	}
-
-	if len(refs) == 0 {
-		os.Exit(1)
-	}
-	return nil
+	return git.ForEachRefOutput(lines).Render(os.Stdout, git.OutputHuman)
 }
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/driusan/dgit/git"
+)
+
+func GC(c *git.Client, args []string) error {
+	flags := newFlagSet("gc")
+	opts := git.GCOptions{}
+	flags.BoolVar(&opts.Auto, "auto", false, "Only clean up if there are more loose objects than gc.auto")
+	flags.BoolVar(&opts.Quiet, "quiet", false, "Suppress progress reporting")
+	flags.BoolVar(&opts.Quiet, "q", false, "Alias of --quiet")
+	for _, bf := range []string{"aggressive", "force", "keep-largest-pack", "no-prune"} {
+		flags.Var(newNotimplBoolValue(), bf, "Not implemented")
+	}
+	flags.Var(newNotimplStringValue(), "prune", "Not implemented")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("Unexpected arguments")
+	}
+	return git.GC(c, opts)
+}
+
+// Runs gc --auto after a command which may have created many loose objects
+// succeeded. The command's work is already done, so a failed gc is only
+// logged.
+func autoGC(c *git.Client) {
+	if err := git.AutoGC(c); err != nil {
+		log.Printf("gc --auto failed: %v\n", err)
+	}
+}
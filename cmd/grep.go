@@ -221,6 +221,7 @@ func Grep(c *git.Client, args []string) error {
 	if len(args) > 0 {
 		if t, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[0]); err == nil {
 			tree = t
+			opts.TreeName = args[0]
 			args = args[1:]
 		}
 	}
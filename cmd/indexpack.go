@@ -35,7 +35,6 @@ func IndexPack(c *git.Client, args []string) (err error) {
 
 	// Determine where to read the pack file based on command line options.
 	var packfile io.ReadSeeker
-	var idx git.PackfileIndex
 
 	if options.Stdin {
 		packfile = os.Stdin
@@ -78,8 +77,12 @@ func IndexPack(c *git.Client, args []string) (err error) {
 			//  because otherwise we'll need an extra pointless copy
 			//  on some operating systems, where mv can't move between
 			// directories.)
-			_, err := git.IndexAndCopyPack(c, options, os.Stdin)
-			return err
+			sha, err := git.IndexAndCopyPack(c, options, os.Stdin)
+			if err != nil {
+				return err
+			}
+			fmt.Println(sha)
+			return nil
 		}
 	} else {
 		// Guess based on the pack name.
@@ -97,10 +100,10 @@ func IndexPack(c *git.Client, args []string) (err error) {
 		defer f.Close()
 		options.Output = f
 	}
-	idx, err = git.IndexPack(c, options, packfile)
+	sha, err := git.IndexPack(c, options, packfile)
 	if err != nil {
 		return err
 	}
-
-	return idx.WriteIndex(options.Output)
+	fmt.Println(sha)
+	return nil
 }
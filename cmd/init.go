@@ -25,6 +25,10 @@ func Init(c *git.Client, args []string) error {
 	flags.BoolVar(&opts.Quiet, "q", false, "Alias of --quiet")
 	var template string
 	flags.StringVar(&template, "template", "", "Specify the template directory that will be used")
+	flags.StringVar(&opts.InitialBranch, "initial-branch", "", "Use the specified name for the initial branch")
+	flags.StringVar(&opts.InitialBranch, "b", "", "Alias of --initial-branch")
+	var separate string
+	flags.StringVar(&separate, "separate-git-dir", "", "Create the git directory in the given directory instead of .git")
 
 	flags.Parse(args)
 	args = flags.Args()
@@ -51,6 +55,8 @@ func Init(c *git.Client, args []string) error {
 		opts.Template = git.File(template)
 	}
 
+	opts.SeparateGitDir = git.File(separate)
+
 	_, err := git.Init(c, opts, dir)
 	return err
 }
@@ -3,7 +3,6 @@ package cmd
 import (
 	"flag"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 
@@ -19,7 +18,8 @@ func Log(c *git.Client, args []string) error {
 		flags.PrintDefaults()
 	}
 
-	flags.Var(newNotimplBoolValue(), "follow", "Not implemented")
+	var follow bool
+	flags.BoolVar(&follow, "follow", false, "Continue the history of a single file beyond renames")
 	flags.Var(newNotimplBoolValue(), "no-decorate", "Not implemented")
 	flags.Var(newNotimplStringValue(), "decorate", "Not implemented")
 	flags.Var(newNotimplStringValue(), "decorate-refs", "Not implemented")
@@ -34,6 +34,17 @@ func Log(c *git.Client, args []string) error {
 	flags.IntVar(&maxCount, "max-count", -1, "Alias for -n")
 	format := "medium" // The default
 	flags.StringVar(&format, "format", "medium", "Pretty print the commit logs")
+	var noNotes bool
+	flags.BoolVar(&noNotes, "no-notes", false, "Do not show notes")
+	var fullHistory bool
+	flags.BoolVar(&fullHistory, "full-history", false, "Walk every parent of a merge when limited to paths")
+	var topoOrder, graph bool
+	flags.BoolVar(&topoOrder, "topo-order", false, "Show no parents before all of their children")
+	flags.BoolVar(&graph, "graph", false, "Draw the history as a graph next to the commits, implies --topo-order")
+	var raw, combined, noMerges bool
+	flags.BoolVar(&raw, "raw", false, "List the files changed by each commit in the raw diff format")
+	flags.BoolVar(&combined, "c", false, "List the files that merges changed from all of their parents")
+	flags.BoolVar(&noMerges, "no-merges", false, "Do not show merge commits")
 
 	adjustedArgs := []string{}
 	for _, a := range args {
@@ -52,52 +63,145 @@ func Log(c *git.Client, args []string) error {
 
 	flags.Parse(adjustedArgs)
 
-	if flags.NArg() > 1 {
-		fmt.Fprintf(flag.CommandLine.Output(), "Paths are not yet implemented, just the revision")
-		flags.Usage()
-		os.Exit(2)
-	}
-
+	// The revision is optional. As in git, anything before a "--" must be
+	// one, and otherwise an argument which isn't one must be a file in
+	// the work tree for it to start the paths.
 	var commit git.Commitish
-	var err error
-	if flags.NArg() == 0 {
+	args = flags.Args()
+	dashdash := false
+	for _, a := range args {
+		if a == "--" {
+			dashdash = true
+			break
+		}
+	}
+	if len(args) > 0 && args[0] != "--" {
+		if cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, args[0]); err == nil {
+			commit = cmt
+			args = args[1:]
+		} else if dashdash || !git.File(args[0]).Exists() {
+			return fmt.Errorf("bad revision '%v'", args[0])
+		}
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	} else if dashdash {
+		return fmt.Errorf("bad revision '%v'", args[0])
+	}
+	if commit == nil {
+		var err error
 		commit, err = git.RevParseCommitish(c, &git.RevParseOptions{}, "HEAD")
-	} else {
-		commit, err = git.RevParseCommitish(c, &git.RevParseOptions{}, flags.Arg(0))
+		if err != nil {
+			return err
+		}
 	}
-	if err != nil {
-		return err
+	var paths []git.IndexPath
+	for _, a := range args {
+		p, err := git.File(a).IndexPath(c)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
 	}
 
-	opts := git.RevListOptions{Quiet: true}
+	opts := git.LogOptions{Follow: follow, FullHistory: fullHistory, TopoOrder: topoOrder || graph, NoMerges: noMerges}
 	if maxCount >= 0 {
 		mc := uint(maxCount)
 		opts.MaxCount = &mc
 	}
 
-	var commitPrinter func(s git.Sha1) error
+	// Each format returns the output for a commit, and whether the
+	// commits are separated by a blank line at the end of it.
+	var commitFormatter func(cmt git.CommitID) (string, bool, error)
 
 	if format == "medium" {
-		commitPrinter = func(s git.Sha1) error {
-			output, err := git.CommitID(s).FormatMedium(c)
-			if err != nil {
+		var notes git.Notes
+		if !noNotes {
+			var err error
+			if notes, err = git.ReadNotes(c, git.NotesOptions{}); err != nil {
 				return err
 			}
-			fmt.Printf("%s", output)
-			return nil
+		}
+		commitFormatter = func(cmt git.CommitID) (string, bool, error) {
+			output, err := cmt.FormatMedium(c)
+			if err != nil {
+				return "", false, err
+			}
+			if !noNotes {
+				formatted, err := cmt.FormatNotes(c, notes)
+				if err != nil {
+					return "", false, err
+				}
+				output += formatted
+			}
+			return output, true, nil
 		}
 	} else if strings.HasPrefix(format, "format:") {
-		commitPrinter = func(s git.Sha1) error {
-			output, err := git.CommitID(s).Format(c, format[7:])
+		commitFormatter = func(cmt git.CommitID) (string, bool, error) {
+			output, err := cmt.Format(c, format[7:])
 			if err != nil {
-				return err
+				return "", false, err
 			}
-			fmt.Printf("%s\n", output)
-			return nil
+			return output + "\n", false, nil
 		}
 	} else {
 		return fmt.Errorf("Format %s is not supported\n", format)
 	}
 
-	return git.RevListCallback(c, opts, []git.Commitish{commit}, nil, commitPrinter)
+	if raw {
+		format := commitFormatter
+		commitFormatter = func(cmt git.CommitID) (string, bool, error) {
+			output, separated, err := format(cmt)
+			if err != nil {
+				return "", false, err
+			}
+			lines, err := git.LogRaw(c, cmt, combined)
+			if err != nil || len(lines) == 0 {
+				return output, separated, err
+			}
+			if !separated {
+				output += "\n"
+			}
+			output += strings.Join(lines, "\n") + "\n"
+			if separated {
+				output += "\n"
+			}
+			return output, separated, nil
+		}
+	}
+
+	var lg *git.LogGraph
+	if graph {
+		lg = git.NewLogGraph()
+	}
+	shown := false
+	commitPrinter := func(cmt git.CommitID) error {
+		output, separated, err := commitFormatter(cmt)
+		if err != nil {
+			return err
+		}
+		if lg == nil {
+			fmt.Print(output)
+			return nil
+		}
+
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return err
+		}
+		lg.Update(cmt, parents)
+		if separated {
+			// The blank line goes between the commits, so that
+			// the graph continues through it.
+			output = strings.TrimSuffix(output, "\n")
+			if shown {
+				fmt.Printf("%s\n", lg.Padding())
+			}
+		}
+		fmt.Print(lg.Show(output))
+		shown = true
+		return nil
+	}
+
+	return git.Log(c, opts, []git.Commitish{commit}, paths, commitPrinter)
 }
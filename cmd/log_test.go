@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/driusan/dgit/git"
@@ -17,3 +18,27 @@ func BenchmarkLogHead(b *testing.B) {
 		}
 	}
 }
+
+func TestLogBadRevision(t *testing.T) {
+	c, err := git.NewClient("../.git", ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"no-such-revision"},
+		{"no-such-revision", "--"},
+		{"no-such-revision", "--", "log.go"},
+		// Files before a "--" are still meant to be revisions.
+		{"log.go", "--"},
+	} {
+		err := Log(c, args)
+		if err == nil || !strings.Contains(err.Error(), "bad revision") {
+			t.Errorf("Unexpected error for %v: got %v want bad revision", args, err)
+		}
+	}
+
+	// A file in the work tree starts the paths without a "--".
+	if err := Log(c, []string{"-n", "0", "log.go"}); err != nil {
+		t.Errorf("Unexpected error for a file: %v", err)
+	}
+}
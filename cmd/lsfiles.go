@@ -3,6 +3,7 @@ package cmd
 import (
 	"flag"
 	"fmt"
+	"os"
 
 	"github.com/driusan/dgit/git"
 )
@@ -59,6 +60,10 @@ func LsFiles(c *git.Client, args []string) error {
 
 	flags.Var(NewMultiStringValue(&excludeperdirectory), "exclude-per-directory", "Read additional exclude patterns for each directory.")
 
+	flags.BoolVar(&options.Sparse, "sparse", false, "Show sparse directories in a sparse index instead of the files in them")
+
+	z := flags.Bool("z", false, "Terminate entries with NUL, not LF, and do not quote paths")
+
 	flags.BoolVar(&options.ErrorUnmatch, "error-unmatch", false, "Exit with an error if any unmatched paths are specified on the command line")
 
 	flags.Parse(args)
@@ -111,26 +116,13 @@ func LsFiles(c *git.Client, args []string) error {
 		return err
 	}
 
-	// LsFiles converted them to IndexEntries so that it could return the
-	// stage, sha1, and mode if --stage was passed. We need to convert them
-	// back to relative paths.
-	for _, file := range files {
-		path, err := file.PathName.FilePath(c)
-		if err != nil {
-			return err
-		}
-		if options.Status {
-			fmt.Printf("%c ", file.StatusCode)
-		}
-		if options.Stage {
-			fmt.Printf("%o %v %v\t%v\n", file.Mode, file.Sha1, file.Stage(), path)
-		} else {
-			if options.Directory && path.IsDir() {
-				fmt.Println(path + "/")
-			} else {
-				fmt.Println(path)
-			}
-		}
+	output, err := git.NewLsFilesOutput(c, options, files)
+	if err != nil {
+		return err
+	}
+	format := git.OutputHuman
+	if *z {
+		format = git.OutputNullTerminated
 	}
-	return err
+	return output.Render(os.Stdout, format)
 }
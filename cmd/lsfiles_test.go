@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/driusan/dgit/git"
+)
+
+// Returns what f prints to stdout.
+func captureStdout(t *testing.T, f func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- b
+	}()
+	ferr := f()
+	os.Stdout = stdout
+	w.Close()
+	b := <-out
+	r.Close()
+	if ferr != nil {
+		t.Fatal(ferr)
+	}
+	return string(b)
+}
+
+// Tests that the files in the sparse directories of a sparse index are
+// listed by ls-files and can be removed with rm, as the index were full.
+func TestLsFilesSparseIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cmdlsfilessparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if _, err := git.Init(nil, git.InitOptions{Quiet: true}, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GIT_COMMITTER_NAME", "John Smith")
+	os.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	os.Setenv("GIT_AUTHOR_NAME", "John Smith")
+	os.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+
+	config, err := ioutil.ReadFile(".git/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = append(config, "[core]\n\tsparseCheckout = true\n\tsparseCheckoutCone = true\n[index]\n\tsparse = true\n"...)
+	if err := ioutil.WriteFile(".git/config", config, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(".git/info", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(".git/info/sparse-checkout", []byte("/*\n!/*/\n/in/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := git.NewClient(filepath.Join(dir, ".git"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"in/a", "out/b", "out/deep/c"} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Add(c, []string{"in", "out"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git.Commit(c, git.CommitOptions{}, "Initial commit", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReadTree(c, []string{"-m", "-u", "HEAD"}); err != nil {
+		t.Fatal(err)
+	}
+	if idx, err := c.GitDir.ReadIndex(); err != nil {
+		t.Fatal(err)
+	} else if !idx.IsSparse() {
+		t.Fatal("Index is not sparse after read-tree")
+	}
+	if git.File("out/b").Exists() {
+		t.Fatal("out/b was not removed from the work tree")
+	}
+
+	lsfiles := func(args ...string) string {
+		return captureStdout(t, func() error { return LsFiles(c, args) })
+	}
+	if got, want := lsfiles(), "in/a\nout/b\nout/deep/c\n"; got != want {
+		t.Errorf("Unexpected ls-files: got %q want %q", got, want)
+	}
+	if got, want := lsfiles("--sparse"), "in/a\nout/\n"; got != want {
+		t.Errorf("Unexpected ls-files --sparse: got %q want %q", got, want)
+	}
+	if got, want := lsfiles("out/deep"), "out/deep/c\n"; got != want {
+		t.Errorf("Unexpected ls-files out/deep: got %q want %q", got, want)
+	}
+	if got, want := lsfiles("-t", "out/b"), "S out/b\n"; got != want {
+		t.Errorf("Unexpected ls-files -t out/b: got %q want %q", got, want)
+	}
+	if got := lsfiles("-m", "-d"); got != "" {
+		t.Errorf("Files which aren't checked out are modified or deleted: %q", got)
+	}
+
+	if got, want := captureStdout(t, func() error { return Rm(c, []string{"--cached", "out/b"}) }), "rm 'out/b'\n"; got != want {
+		t.Errorf("Unexpected rm output: got %q want %q", got, want)
+	}
+	if got, want := lsfiles(), "in/a\nout/deep/c\n"; got != want {
+		t.Errorf("Unexpected ls-files after rm: got %q want %q", got, want)
+	}
+
+	// What's left of out is still outside of the cone, so it's
+	// collapsed again.
+	if got, want := lsfiles("--sparse"), "in/a\nout/\n"; got != want {
+		t.Errorf("Unexpected ls-files --sparse after rm: got %q want %q", got, want)
+	}
+}
@@ -47,5 +47,9 @@ func Merge(c *git.Client, args []string) error {
 		}
 		others = append(others, c)
 	}
-	return git.Merge(c, options, others)
+	if err := git.Merge(c, options, others); err != nil {
+		return err
+	}
+	autoGC(c)
+	return nil
 }
@@ -1,77 +1,87 @@
 package cmd
 
 import (
-	"flag"
+	"bytes"
 	"fmt"
-	"github.com/driusan/dgit/git"
 	"io"
+	"io/ioutil"
 	"os"
+
+	"github.com/driusan/dgit/git"
 )
 
 func MergeFile(c *git.Client, args []string) error {
-	flags := flag.NewFlagSet("merge-file", flag.ExitOnError)
-	flags.SetOutput(flag.CommandLine.Output())
+	flags := newFlagSet("merge-file")
 	options := git.MergeFileOptions{}
 
+	var labels []string
+	flags.Var(NewMultiStringValue(&labels), "L", "Use the label instead of the file name in conflict markers (up to three times)")
+	flags.BoolVar(&options.Stdout, "p", false, "Send the results to standard output instead of overwriting the current file")
+	flags.BoolVar(&options.Quiet, "q", false, "Do not warn about conflicts")
+	flags.BoolVar(&options.Diff3, "diff3", false, "Show the base version in conflicts")
+	ours := flags.Bool("ours", false, "Resolve conflicts in favour of the current file")
+	theirs := flags.Bool("theirs", false, "Resolve conflicts in favour of the other file")
+	union := flags.Bool("union", false, "Resolve conflicts by keeping both sides")
+	flags.IntVar(&options.MarkerSize, "marker-size", 0, "The length of conflict markers")
 	flags.Parse(args)
 	args = flags.Args()
 
-	if len(args) <= 1 {
-		flag.Usage()
+	if len(args) != 3 {
+		flags.Usage()
 		return fmt.Errorf("Invalid usage of merge-file")
 	}
+	if len(labels) > 3 {
+		flags.Usage()
+		return fmt.Errorf("May only specify -L up to three times.")
+	}
+	// Files without a label are labelled with their name.
+	labels = append(labels, args[len(labels):]...)
+	options.CurrentLabel, options.BaseLabel, options.OtherLabel = labels[0], labels[1], labels[2]
+	switch {
+	case *ours:
+		options.Favor = git.MergeFavorOurs
+	case *theirs:
+		options.Favor = git.MergeFavorTheirs
+	case *union:
+		options.Favor = git.MergeFavorUnion
+	}
 
-	// -L may be specified multiple times, so we need to manually look for it instead of
-	// using flag
-	nEls := 0
-LabelLoop:
-	for args[0] == "-L" {
-		if len(args) < 2 {
-			break LabelLoop
+	var files [3]io.Reader
+	for i, name := range args {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
 		}
-		switch nEls {
-		case 0:
-			options.Current.Label = args[1]
-		case 1:
-			options.Base.Label = args[1]
-
-		case 2:
-			options.Other.Label = args[1]
-		default:
-			flag.Usage()
-			return fmt.Errorf("May only specify -L up to three times.")
+		// Like git, files with a NUL in the first 8000 bytes are
+		// binary, and aren't merged.
+		head := content
+		if len(head) > 8000 {
+			head = head[:8000]
 		}
-		nEls++
+		if bytes.IndexByte(head, 0) >= 0 {
+			if !options.Quiet {
+				fmt.Fprintf(os.Stderr, "error: Cannot merge binary files: %v\n", args[0])
+			}
+			os.Exit(255)
+		}
+		files[i] = bytes.NewReader(content)
 	}
-	if len(args) != 3 {
-		flag.Usage()
-		return fmt.Errorf("Invalid usage of merge-file")
+	merged, conflicts, err := git.MergeFile(files[0], files[1], files[2], options)
+	if err != nil {
+		return err
 	}
-	for i, file := range args {
-		switch i {
-		case 0:
-			options.Current.Filename = git.File(file)
-		case 1:
-			options.Base.Filename = git.File(file)
-		case 2:
-			options.Other.Filename = git.File(file)
-		}
+	if options.Stdout {
+		os.Stdout.Write(merged)
+	} else if err := ioutil.WriteFile(args[0], merged, 0644); err != nil {
+		return err
 	}
-	newcontent, err := git.MergeFile(c, options)
-	if newcontent != nil {
-		if options.Stdout {
-			io.Copy(os.Stdout, newcontent)
-		} else {
-			f, err := os.Create(options.Current.Filename.String())
-			if err != nil {
-				println("Error opening")
-				return err
-			}
-
-			io.Copy(f, newcontent)
-
-			f.Close()
+	if conflicts > 0 {
+		// Like git, the exit status is the number of conflicts, up
+		// to 127.
+		if conflicts > 127 {
+			conflicts = 127
 		}
+		os.Exit(conflicts)
 	}
-	return err
+	return nil
 }
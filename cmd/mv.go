@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Mv(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("mv", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	var opts git.MvOptions
+	flags.BoolVar(&opts.Force, "force", false, "Force renaming or moving even if the target exists")
+	flags.BoolVar(&opts.Force, "f", false, "Alias of --force")
+	flags.BoolVar(&opts.SkipErrors, "k", false, "Skip move or rename actions which would lead to an error")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "Only show what would happen")
+	flags.BoolVar(&opts.DryRun, "n", false, "Alias of --dry-run")
+	flags.BoolVar(&opts.Verbose, "verbose", false, "Report the names of files as they are moved")
+	flags.BoolVar(&opts.Verbose, "v", false, "Alias of --verbose")
+
+	flags.Parse(args)
+	sfiles := flags.Args()
+	if len(sfiles) < 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	sources := make([]git.File, 0, len(sfiles)-1)
+	for _, f := range sfiles[:len(sfiles)-1] {
+		sources = append(sources, git.File(f))
+	}
+	_, err := git.Mv(c, opts, sources, git.File(sfiles[len(sfiles)-1]))
+	return err
+}
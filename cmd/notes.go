@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Notes(c *git.Client, args []string) error {
+	flags := newFlagSet("notes")
+	opts := git.NotesOptions{}
+	flags.StringVar(&opts.Ref, "ref", "", "Manipulate the notes tree in <ref>")
+	flags.Parse(args)
+	args = flags.Args()
+
+	subcommand := "list"
+	if len(args) > 0 {
+		subcommand = args[0]
+		args = args[1:]
+	}
+
+	// Resolves the object argument to a subcommand, defaulting to HEAD.
+	object := func(args []string) (git.Sha1, error) {
+		name := "HEAD"
+		if len(args) > 0 {
+			name = args[0]
+		}
+		revs, err := git.RevParse(c, git.RevParseOptions{}, []string{name})
+		if err != nil {
+			return git.Sha1{}, err
+		}
+		if len(revs) != 1 {
+			return git.Sha1{}, fmt.Errorf("Failed to resolve '%v' as a valid ref.", name)
+		}
+		return revs[0].Id, nil
+	}
+
+	switch subcommand {
+	case "list":
+		notes, err := git.NotesList(c, opts)
+		if err != nil {
+			return err
+		}
+		if len(args) > 0 {
+			obj, err := object(args)
+			if err != nil {
+				return err
+			}
+			for _, note := range notes {
+				if note.Object == obj {
+					fmt.Println(note.Blob)
+					return nil
+				}
+			}
+			return fmt.Errorf("error: no note found for object %v.", obj)
+		}
+		for _, note := range notes {
+			fmt.Println(note.Blob, note.Object)
+		}
+		return nil
+	case "add":
+		aflags := newFlagSet("notes-add")
+		var message string
+		aflags.StringVar(&message, "m", "", "Use the given note message")
+		aflags.BoolVar(&opts.Force, "f", false, "Overwrite existing notes")
+		aflags.BoolVar(&opts.Force, "force", false, "Alias of -f")
+		aflags.Parse(args)
+		if message == "" {
+			fmt.Fprintln(os.Stderr, "Editing notes is not implemented. Use -m")
+			aflags.Usage()
+			os.Exit(2)
+		}
+		obj, err := object(aflags.Args())
+		if err != nil {
+			return err
+		}
+		return git.NotesAdd(c, opts, obj, message)
+	case "show":
+		obj, err := object(args)
+		if err != nil {
+			return err
+		}
+		note, err := git.NotesShow(c, opts, obj)
+		if err != nil {
+			return err
+		}
+		fmt.Print(note)
+		return nil
+	case "remove":
+		obj, err := object(args)
+		if err != nil {
+			return err
+		}
+		return git.NotesRemove(c, opts, obj)
+	default:
+		return fmt.Errorf("Notes subcommand %v not implemented", subcommand)
+	}
+}
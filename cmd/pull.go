@@ -3,7 +3,6 @@ package cmd
 import (
 	"flag"
 	"fmt"
-	"os"
 
 	"github.com/driusan/dgit/git"
 )
@@ -20,36 +19,20 @@ func Pull(c *git.Client, args []string) error {
 	opts := git.PullOptions{}
 	addSharedFetchFlags(flags, &opts.FetchOptions)
 	addSharedMergeFlags(flags, &opts.MergeOptions)
+	flags.BoolVar(&opts.Rebase, "rebase", false, "Rebase the current branch on top of the upstream branch after fetching")
+	flags.BoolVar(&opts.Rebase, "r", false, "Alias of --rebase")
+	flags.BoolVar(&opts.NoRebase, "no-rebase", false, "Merge the upstream branch into the current branch after fetching")
 	flags.Parse(args)
 
 	var repository git.Remote
 	var remotebranches []string
-	config, err := git.LoadLocalConfig(c)
-	if err != nil {
-		return err
-	}
-
-	if flags.NArg() < 1 {
-		// TODO simplistic, probably won't work in all cases
-		// Instead the branch information should be retrieved from the merge config
-		headbranch := c.GetHeadBranch().BranchName()
-		r, _ := config.GetConfig("branch." + headbranch + ".remote")
-		repository = git.Remote(r)
-		//mergeremote, _ := config.GetConfig("branch." + head + ".merge")
-		remotebranches = []string{fmt.Sprintf("%s/%s", repository, headbranch)}
-	} else if flags.NArg() == 1 {
+	if flags.NArg() >= 1 {
 		repository = git.Remote(flags.Arg(0))
-		// Instead the branch information should be retrieved from the merge config
-		headbranch := c.GetHeadBranch().BranchName()
-		//mergeremote, _ := config.GetConfig("branch." + headbranch + ".merge")
-		remotebranches = []string{fmt.Sprintf("%s/%s", repository, headbranch)}
-	} else if flags.NArg() >= 2 {
-		repository = git.Remote(flag.Arg(0))
-		remotebranches = flag.Args()[1:]
-	} else {
-		flags.Usage()
-		os.Exit(1)
+		remotebranches = flags.Args()[1:]
 	}
-
-	return git.Pull(c, opts, repository, remotebranches)
+	if err := git.Pull(c, opts, repository, remotebranches); err != nil {
+		return err
+	}
+	autoGC(c)
+	return nil
 }
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/driusan/dgit/git"
+)
+
+func RangeDiff(c *git.Client, args []string) error {
+	flags := flag.NewFlagSet("range-diff", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+
+	var opts git.RangeDiffOptions
+	flags.IntVar(&opts.CreationFactor, "creation-factor", 60, "Percentage of a commit which may change before it's shown as a new commit")
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) != 2 {
+		flags.Usage()
+		return fmt.Errorf("need two commit ranges")
+	}
+
+	var commits [4]git.Commitish
+	for i, rng := range args {
+		pieces := strings.SplitN(rng, "..", 2)
+		if len(pieces) != 2 {
+			return fmt.Errorf("not a commit range: %v", rng)
+		}
+		for j, rev := range pieces {
+			if rev == "" {
+				rev = "HEAD"
+			}
+			cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, rev)
+			if err != nil {
+				return err
+			}
+			commits[i*2+j] = cmt
+		}
+	}
+
+	entries, err := git.RangeDiff(c, opts, commits[0], commits[1], commits[2], commits[3])
+	if err != nil {
+		return err
+	}
+	// The indexes are padded to the width of the largest one.
+	width := 1
+	for _, e := range entries {
+		for _, idx := range []int{e.OldIndex, e.NewIndex} {
+			if w := len(fmt.Sprint(idx)); w > width {
+				width = w
+			}
+		}
+	}
+	side := func(cmt git.CommitID, idx int) string {
+		if idx == 0 {
+			return fmt.Sprintf("%*s:  -------", width, "-")
+		}
+		return fmt.Sprintf("%*d:  %.7s", width, idx, cmt)
+	}
+	for _, e := range entries {
+		// The subject is the old commit's unless it was added.
+		subject := e.Old
+		if e.Status == '>' {
+			subject = e.New
+		}
+		msg, err := subject.GetCommitMessage(c)
+		if err != nil {
+			return err
+		}
+		title := strings.SplitN(strings.TrimSpace(msg.String()), "\n", 2)[0]
+		fmt.Printf("%v %c %v %v\n", side(e.Old, e.OldIndex), e.Status, side(e.New, e.NewIndex), title)
+		if e.InterDiff != "" {
+			for _, line := range strings.SplitAfter(e.InterDiff, "\n") {
+				if line != "" {
+					fmt.Printf("    %v", line)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -68,63 +68,18 @@ func ReadTree(c *git.Client, args []string) error {
 		return fmt.Errorf("Can only specify --exclude-per-directory once")
 	}
 
-	switch len(args) {
-	case 0:
-		if !options.Empty {
-			flags.Usage()
-			os.Exit(2)
-		}
-		_, err := git.ReadTree(c, options, nil)
-		if err != nil {
-			return err
-		}
-	case 1:
-		treeish, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[0])
-		if err != nil {
-			return err
-		}
-		_, err = git.ReadTree(c, options, treeish)
-		if err != nil {
-			return err
-		}
-	case 2:
-		parent, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[0])
-		if err != nil {
-			return err
-		}
-		dst, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[1])
-		if err != nil {
-			return err
-		}
-		_, err = git.ReadTreeFastForward(c, options, parent, dst)
-		if err != nil {
-			return err
-		}
-	default:
-		// The last test in the t1000-read-tree-m-3way.sh test suite calls
-		// "git read-tree -m $tree0 $tree1 $tree1 $tree0" and expects it to
-		// succeed.
-		//
-		// git-read-tree(1) doesn't really have any guidance on how to interpret
-		// a command that looks like that, so we just treat everything that
-		// has >= 3 trees as a 3-way merge, discarding trees after the first
-		// three and hope for the best.
-		stage1, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[0])
-		if err != nil {
-			return err
-		}
-		stage2, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[1])
-		if err != nil {
-			return err
-		}
-		stage3, err := git.RevParseTreeish(c, &git.RevParseOptions{}, args[2])
-		if err != nil {
-			return err
-		}
-		_, err = git.ReadTreeThreeWay(c, options, stage1, stage2, stage3)
+	if len(args) == 0 && !options.Empty {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var trees []git.Treeish
+	for _, arg := range args {
+		treeish, err := git.RevParseTreeish(c, &git.RevParseOptions{}, arg)
 		if err != nil {
 			return err
 		}
+		trees = append(trees, treeish)
 	}
-	return nil
+	_, err = git.ReadTrees(c, options, trees...)
+	return err
 }
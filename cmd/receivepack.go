@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/driusan/dgit/git"
+)
+
+// ReceivePack implements "git receive-pack <directory>", which serves a
+// push to the repository in directory over stdin and stdout.
+func ReceivePack(args []string) error {
+	flags := flag.NewFlagSet("receive-pack", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	opts := git.ReceivePackOptions{}
+	flags.BoolVar(&opts.AdvertiseRefs, "advertise-refs", false, "Only advertise the refs and exit")
+	flags.BoolVar(&opts.StatelessRPC, "stateless-rpc", false, "Read the commands without advertising the refs")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	dir := flags.Arg(0)
+	if st, err := os.Stat(filepath.Join(dir, ".git")); err == nil && st.IsDir() {
+		dir = filepath.Join(dir, ".git")
+	}
+	c, err := git.NewClient(dir, "")
+	if err != nil {
+		return err
+	}
+	return git.ReceivePack(c, os.Stdin, os.Stdout, opts)
+}
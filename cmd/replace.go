@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Replace(c *git.Client, args []string) error {
+	flags := newFlagSet("replace")
+	list := flags.Bool("l", false, "List replace refs")
+	flags.BoolVar(list, "list", false, "Alias of -l")
+	del := flags.Bool("d", false, "Delete existing replace refs for the given objects")
+	flags.BoolVar(del, "delete", false, "Alias of -d")
+	force := flags.Bool("f", false, "Replace the object even if a replace ref already exists")
+	flags.BoolVar(force, "force", false, "Alias of -f")
+	flags.Parse(args)
+	args = flags.Args()
+
+	// Resolves the command line arguments without substituting any
+	// existing replacements, since that's what we're manipulating.
+	c.NoReplaceObjects = true
+	resolve := func(args []string) ([]git.Sha1, error) {
+		revs, err := git.RevParse(c, git.RevParseOptions{}, args)
+		if err != nil {
+			return nil, err
+		}
+		objs := make([]git.Sha1, 0, len(revs))
+		for _, r := range revs {
+			objs = append(objs, r.Id)
+		}
+		return objs, nil
+	}
+
+	switch {
+	case *del:
+		if len(args) == 0 {
+			flags.Usage()
+			return fmt.Errorf("Must provide object to delete replace ref for")
+		}
+		objs, err := resolve(args)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			if err := git.ReplaceDelete(c, obj); err != nil {
+				return err
+			}
+			fmt.Printf("Deleted replace ref '%v'\n", obj)
+		}
+		return nil
+	case *list || len(args) == 0:
+		refs, err := git.ReplaceList(c)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			fmt.Println(ref.Name[len("refs/replace/"):])
+		}
+		return nil
+	case len(args) != 2:
+		flags.Usage()
+		return fmt.Errorf("Must provide object and replacement")
+	}
+	objs, err := resolve(args)
+	if err != nil {
+		return err
+	}
+	if *force && c.GitDir.File(git.File("refs/replace/"+objs[0].String())).Exists() {
+		if err := git.ReplaceDelete(c, objs[0]); err != nil {
+			return err
+		}
+	}
+	return git.ReplaceRef(c, objs[0], objs[1])
+}
@@ -33,5 +33,6 @@ func Reset(c *git.Client, args []string) error {
 	for _, f := range args {
 		files = append(files, git.File(f))
 	}
-	return git.Reset(c, opts, files)
+	_, err := git.Reset(c, opts, files)
+	return err
 }
@@ -19,9 +19,26 @@ func RevList(c *git.Client, args []string) error {
 	opts := git.RevListOptions{}
 	flags.BoolVar(&opts.Objects, "objects", false, "include non-commit objects in output")
 	flags.BoolVar(&opts.Quiet, "quiet", false, "prevent printing of revisions")
+	flags.BoolVar(&opts.FullHistory, "full-history", false, "walk every parent of a merge when limited to paths")
 	flags.Parse(args)
 	args = flags.Args()
 
+	// The paths to limit the history to come after a "--".
+	for i, a := range args {
+		if a != "--" {
+			continue
+		}
+		for _, p := range args[i+1:] {
+			ip, err := git.File(p).IndexPath(c)
+			if err != nil {
+				return err
+			}
+			opts.Paths = append(opts.Paths, ip)
+		}
+		args = args[:i]
+		break
+	}
+
 	// First get a map of excluded commitIDs
 	var excludes []git.Commitish
 	var includes []git.Commitish
@@ -93,7 +93,11 @@ func Revert(c *git.Client, args []string) error {
 
 	editmessage := fmt.Sprintf("Revert \"%v\"\n\nThis reverts commit %v\n", cmessage.Subject(), cid.String())
 
-	st, err := git.StatusLong(c, nil, git.StatusUntrackedAll, "# ")
+	prefix, err := statusPathPrefix(c)
+	if err != nil {
+		return err
+	}
+	st, err := git.StatusLong(c, nil, git.StatusUntrackedAll, prefix, "# ")
 	if err != nil {
 		return err
 	}
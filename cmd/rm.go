@@ -38,5 +38,6 @@ func Rm(c *git.Client, args []string) error {
 	for _, f := range sfiles {
 		files = append(files, git.File(f))
 	}
-	return git.Rm(c, opts, files)
+	_, err := git.Rm(c, opts, files)
+	return err
 }
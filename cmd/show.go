@@ -19,8 +19,14 @@ func Show(c *git.Client, args []string) error {
 	opts := git.ShowOptions{}
 	flags.Var(newAliasedStringValue((*string)(&opts.Format), ""), "format", "Print the contents of commit logs in a specified format")
 	flags.Var(newAliasedStringValue((*string)(&opts.Format), ""), "pretty", "Alias for --format")
-	flags.Parse(args)
-
-	objects := flags.Args()
+	flags.BoolVar(&opts.PerParentDiffs, "m", false, "Show the diff of merge commits against each parent")
+	flags.BoolVar(&opts.NoNotes, "no-notes", false, "Do not show notes")
+	objects, err := parseCommonDiffFlags(c, &opts.DiffCommonOptions, true, flags, args)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		objects = []string{"HEAD"}
+	}
 	return git.Show(c, opts, objects)
 }
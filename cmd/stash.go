@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/driusan/dgit/git"
+)
+
+// Returns the stash argument to a stash subcommand, or the empty string
+// for the most recent stash.
+func stashArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return ""
+}
+
+func printDropped(name string, dropped git.StashEntry) {
+	if name == "" {
+		name = "refs/" + dropped.Name
+	}
+	fmt.Printf("Dropped %v (%v)\n", name, dropped.Commit)
+}
+
+func Stash(c *git.Client, args []string) error {
+	subcommand := "push"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		subcommand = args[0]
+		args = args[1:]
+	}
+
+	switch subcommand {
+	case "push", "save":
+		flags := newFlagSet("stash-" + subcommand)
+		opts := git.StashPushOptions{}
+		flags.StringVar(&opts.Message, "m", "", "Use the given message to describe the stash")
+		flags.StringVar(&opts.Message, "message", "", "Alias of -m")
+		flags.BoolVar(&opts.KeepIndex, "k", false, "Keep the staged changes in the index and work tree")
+		flags.BoolVar(&opts.KeepIndex, "keep-index", false, "Alias of -k")
+		noKeepIndex := flags.Bool("no-keep-index", false, "Reset the staged changes too (the default)")
+		flags.BoolVar(&opts.IncludeUntracked, "u", false, "Also stash the untracked files")
+		flags.BoolVar(&opts.IncludeUntracked, "include-untracked", false, "Alias of -u")
+		flags.Parse(args)
+		if *noKeepIndex {
+			opts.KeepIndex = false
+		}
+		if subcommand == "save" && flags.NArg() > 0 {
+			opts.Message = strings.Join(flags.Args(), " ")
+		}
+		stash, err := git.StashPush(c, opts)
+		if err != nil {
+			return err
+		}
+		if stash == (git.CommitID{}) {
+			fmt.Println("No local changes to save")
+			return nil
+		}
+		msg, err := stash.GetCommitMessage(c)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Saved working directory and index state %v\n", strings.TrimSpace(msg.String()))
+		return nil
+	case "list":
+		stashes, err := git.StashList(c)
+		if err != nil {
+			return err
+		}
+		for _, s := range stashes {
+			fmt.Printf("%v: %v\n", s.Name, s.Message)
+		}
+		return nil
+	case "show":
+		flags := newFlagSet("stash-show")
+		opts := git.StashShowOptions{}
+		flags.BoolVar(&opts.Patch, "p", false, "Show the stash as a patch")
+		flags.BoolVar(&opts.Patch, "patch", false, "Alias of -p")
+		stat := flags.Bool("stat", false, "Show a diffstat of the stash (the default)")
+		flags.Parse(args)
+		if *stat {
+			opts.Patch = false
+		}
+		return git.StashShow(c, opts, stashArg(flags.Args()))
+	case "apply", "pop":
+		flags := newFlagSet("stash-" + subcommand)
+		opts := git.StashApplyOptions{}
+		flags.BoolVar(&opts.Index, "index", false, "Restore the index as well as the work tree")
+		flags.Parse(args)
+		name := stashArg(flags.Args())
+		if err := git.StashApply(c, opts, name); err != nil {
+			return err
+		}
+		if subcommand == "apply" {
+			return nil
+		}
+		dropped, err := git.StashDrop(c, name)
+		if err != nil {
+			return err
+		}
+		printDropped(name, dropped)
+		return nil
+	case "drop":
+		name := stashArg(args)
+		dropped, err := git.StashDrop(c, name)
+		if err != nil {
+			return err
+		}
+		printDropped(name, dropped)
+		return nil
+	case "branch":
+		if len(args) < 1 {
+			fmt.Fprintf(os.Stderr, "usage: %v stash branch <branchname> [<stash>]\n", os.Args[0])
+			os.Exit(2)
+		}
+		name := stashArg(args[1:])
+		dropped, err := git.StashBranch(c, args[0], name)
+		if err != nil {
+			return err
+		}
+		printDropped(name, dropped)
+		return nil
+	default:
+		return fmt.Errorf("Stash subcommand %v not implemented", subcommand)
+	}
+}
@@ -27,7 +27,7 @@ func Status(c *git.Client, args []string) error {
 
 	flags.BoolVar(&opts.ShowStash, "show-stash", false, "Show the number of entries currently stashed")
 
-	porcelain := flags.Int("porcelain", 0, "Give the output in a porcelain format")
+	porcelain := flags.String("porcelain", "", "Give the output in a porcelain format: v1 (the default) or v2")
 
 	flags.BoolVar(&opts.Long, "long", true, "Give the output in long format")
 
@@ -48,10 +48,14 @@ func Status(c *git.Client, args []string) error {
 
 	nocolumn := flags.Bool("no-column", false, "Equivalent to --column=never")
 
+	color := flags.String("color", "", "Color the output: always, never or auto")
+
+	fullname := flags.Bool("full-name", false, "Show paths relative to the top of the work tree, not the current directory")
+
 	adjustedArgs := []string{}
 	for _, a := range args {
 		if a == "--porcelain" {
-			a = "--porcelain=1"
+			a = "--porcelain=v1"
 		}
 		if a == "--ignore-submodules" {
 			a = "--ignore-submodules=all"
@@ -59,16 +63,22 @@ func Status(c *git.Client, args []string) error {
 		if a == "--column" {
 			a = "--column=always"
 		}
+		if a == "--color" {
+			a = "--color=always"
+		}
 		adjustedArgs = append(adjustedArgs, a)
 	}
 
 	flags.Parse(adjustedArgs)
 
 	switch *porcelain {
-	case 0, 1, 2:
-		opts.Porcelain = uint8(*porcelain)
+	case "":
+	case "v1", "1":
+		opts.Porcelain = 1
+	case "v2", "2":
+		opts.Porcelain = 2
 	default:
-		fmt.Fprintf(flag.CommandLine.Output(), "Invalid value for --porcelain, must be 0, 1 or 2\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Invalid value for --porcelain, must be v1 or v2\n")
 		flags.Usage()
 		os.Exit(2)
 	}
@@ -105,6 +115,21 @@ func Status(c *git.Client, args []string) error {
 		opts.Column = "never"
 	}
 
+	// Porcelain output is always relative to the top of the work tree,
+	// so that it's stable for scripts.
+	if !*fullname && opts.Porcelain == 0 && !opts.NullTerminate {
+		prefix, err := statusPathPrefix(c)
+		if err != nil {
+			return err
+		}
+		opts.PathPrefix = prefix
+	}
+
+	var err error
+	if opts.Color, err = git.ColorEnabled(c, "status", *color, os.Stdout); err != nil {
+		return err
+	}
+
 	status, err := git.Status(c, opts, nil)
 	if err != nil {
 		return err
@@ -112,3 +137,13 @@ func Status(c *git.Client, args []string) error {
 	fmt.Print(status)
 	return nil
 }
+
+// Returns the directory that paths in status output should be displayed
+// relative to, which is the current working directory unless the
+// status.relativePaths config is false.
+func statusPathPrefix(c *git.Client) (git.IndexPath, error) {
+	if c.GetConfig("status.relativePaths") == "false" {
+		return "", nil
+	}
+	return c.CwdPrefix()
+}
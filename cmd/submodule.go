@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,28 +9,57 @@ import (
 )
 
 func Submodule(c *git.Client, args []string) error {
-	flags := flag.NewFlagSet("submodule", flag.ExitOnError)
-	flags.SetOutput(flag.CommandLine.Output())
-	flags.Usage = func() {
-		flag.Usage()
-		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
-		flags.PrintDefaults()
-	}
+	flags := newFlagSet("submodule")
+	quiet := flags.Bool("quiet", false, "Only print error messages")
+	flags.BoolVar(quiet, "q", false, "Alias of --quiet")
+	flags.Parse(args)
+	args = flags.Args()
 
-	if len(args) < 1 || args[0] != "update" {
-		flags.Usage()
-		os.Exit(1)
+	subcommand := "status"
+	if len(args) > 0 {
+		subcommand, args = args[0], args[1:]
 	}
-
-	//flags.Parse(args)
-
-	// Scan for any .gitmodules files and fail with an error since none of this
-	//  is supported yet.
-	workdir := string(c.WorkDir)
-	return filepath.Walk(workdir, func(path string, info os.FileInfo, err error) error {
-		if filepath.Base(path) == ".gitmodules" {
-			return fmt.Errorf("Submodules are not yet supported")
+	switch subcommand {
+	case "status":
+		states, err := git.SubmoduleStatus(c)
+		if err != nil {
+			return err
+		}
+		if *quiet {
+			return nil
+		}
+		for _, s := range states {
+			fmt.Println(s)
 		}
 		return nil
-	})
+	case "init":
+		paths := make([]git.File, 0, len(args))
+		for _, arg := range args {
+			paths = append(paths, git.File(arg))
+		}
+		registered, err := git.SubmoduleInit(c, paths)
+		if err != nil {
+			return err
+		}
+		if *quiet {
+			return nil
+		}
+		for _, sm := range registered {
+			fmt.Printf("Submodule '%v' (%v) registered for path '%v'\n", sm.Name, sm.URL, sm.Path)
+		}
+		return nil
+	case "update":
+		// Scan for any .gitmodules files and fail with an error since
+		// updating isn't supported yet.
+		workdir := string(c.WorkDir)
+		return filepath.Walk(workdir, func(path string, info os.FileInfo, err error) error {
+			if filepath.Base(path) == ".gitmodules" {
+				return fmt.Errorf("Submodules are not yet supported")
+			}
+			return nil
+		})
+	default:
+		flags.Usage()
+		return fmt.Errorf("Unsupported submodule subcommand %v", subcommand)
+	}
 }
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/driusan/dgit/git"
+)
+
+func Subtree(c *git.Client, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("Must provide a subtree subcommand")
+	}
+	flags := newFlagSet("subtree-" + args[0])
+	prefix := flags.String("prefix", "", "The path in the repository to the subtree")
+	flags.StringVar(prefix, "P", "", "Alias of --prefix")
+
+	switch args[0] {
+	case "split":
+		opts := git.SubtreeSplitOptions{}
+		flags.StringVar(&opts.Branch, "branch", "", "Create or update a branch with the split history")
+		flags.StringVar(&opts.Branch, "b", "", "Alias of --branch")
+		flags.Parse(args[1:])
+		if flags.NArg() > 1 {
+			return fmt.Errorf("Can only split one commit")
+		} else if flags.NArg() == 1 {
+			cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, flags.Arg(0))
+			if err != nil {
+				return err
+			}
+			opts.Commit = cmt
+		}
+		cmt, err := git.SubtreeSplit(c, *prefix, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Println(cmt)
+		return nil
+	case "add", "merge":
+		opts := git.SubtreeMergeOptions{}
+		flags.StringVar(&opts.Message, "message", "", "Use the given message for the merge commit")
+		flags.StringVar(&opts.Message, "m", "", "Alias of --message")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			return fmt.Errorf("Must provide exactly one commit to %v", args[0])
+		}
+		if opts.Message != "" {
+			opts.Message += "\n"
+		}
+		cmt, err := git.RevParseCommitish(c, &git.RevParseOptions{}, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		if args[0] == "add" {
+			_, err = git.SubtreeAdd(c, *prefix, opts, cmt)
+		} else {
+			_, err = git.SubtreeMerge(c, *prefix, opts, cmt)
+		}
+		return err
+	default:
+		return fmt.Errorf("Subtree subcommand %v not implemented", args[0])
+	}
+}
@@ -38,6 +38,11 @@ func Tag(c *git.Client, args []string) error {
 
 	flags.BoolVar(&options.Delete, "d", false, "Delete the given tag")
 
+	flags.BoolVar(&options.Sign, "sign", false, "Create a GPG-signed tag with the default key")
+	flags.BoolVar(&options.Sign, "s", false, "Alias of --sign")
+	localuser := flags.String("local-user", "", "Create a GPG-signed tag with the given key")
+	flags.StringVar(localuser, "u", "", "Alias of --local-user")
+
 	var message []string
 	flags.Var(NewMultiStringValue(&message), "message", "Use the given message for the annotated tag")
 	flags.Var(NewMultiStringValue(&message), "m", "Alias of --message")
@@ -63,7 +68,8 @@ func Tag(c *git.Client, args []string) error {
 		}
 		message = append(message, string(f))
 	}
-	if len(message) > 0 || messageFile != "" {
+	options.LocalUser = git.GPGKeyId(*localuser)
+	if len(message) > 0 || messageFile != "" || options.Sign || options.LocalUser != "" {
 		options.Annotated = true
 	}
 	var finalMessage string
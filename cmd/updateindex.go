@@ -24,7 +24,7 @@ func parseCacheInfo(input string) (git.CacheInfo, error) {
 		ret.Mode = git.ModeSymlink
 	case "160000":
 		// A commit may be part of a tree if dealing with submodules
-		ret.Mode = git.ModeCommit
+		ret.Mode = git.ModeGitlink
 	default:
 		return git.CacheInfo{}, fmt.Errorf("Invalid EntryMode: %v", pieces[0])
 	}
@@ -73,7 +73,7 @@ func UpdateIndex(c *git.Client, args []string) error {
 	flags.BoolVar(&opts.Replace, "replace", false, "When a path exists in the index, allow a file with the same name to replace it")
 	stdin := flags.Bool("stdin", false, "Instead of reading paths from the command line, read them from stdin")
 	flags.BoolVar(&opts.Verbose, "verbose", false, "Report what is being added and removed from the index")
-	flags.IntVar(&opts.IndexVersion, "index-version", 0, "Write the resulting index using index version. Only 2 is supported.")
+	flags.IntVar(&opts.IndexVersion, "index-version", 0, "Write the resulting index using index version 2, 3 or 4.")
 	flags.BoolVar(&opts.NullTerminate, "z", false, "Use nil instead of newline to terminate paths from stdin")
 
 	splitindex := flags.Bool("split-index", false, "Use a split index. Unimplemented.")
@@ -156,10 +156,11 @@ func UpdateIndex(c *git.Client, args []string) error {
 	files := make([]git.File, 0, len(vals))
 
 	// Load the index file and call UpdateIndex on it.
-	idx, err := c.GitDir.ReadIndex()
+	idx, lock, err := c.GitDir.LockIndex()
 	if err != nil {
 		return err
 	}
+	defer lock.Rollback()
 
 	for _, val := range vals {
 		// This is a hack to handle commands like "git update-index --add foo bar --force-remove baz"
@@ -207,11 +208,14 @@ func UpdateIndex(c *git.Client, args []string) error {
 		}
 	}
 
-	// Write the index file back to disk if there were no errors.
-	f, err := c.GitDir.Create(git.File("index"))
-	if err != nil {
-		return err
+	idx.UseConfiguredVersion(c)
+	if opts.IndexVersion != 0 {
+		if opts.IndexVersion < 2 || opts.IndexVersion > 4 {
+			return fmt.Errorf("index-version %d not in range: 2..4", opts.IndexVersion)
+		}
+		idx.Version = uint32(opts.IndexVersion)
 	}
-	defer f.Close()
-	return idx.WriteIndex(f)
+
+	// Write the index file back to disk if there were no errors.
+	return idx.CommitLocked(lock)
 }
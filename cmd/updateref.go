@@ -31,7 +31,12 @@ func UpdateRef(c *git.Client, args []string) error {
 	vals := flags.Args()
 
 	if *stdin {
+		if len(vals) != 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
 		opts.Stdin = os.Stdin
+		return git.UpdateRef(c, opts, "", git.CommitID{}, *reason)
 	}
 
 	switch len(vals) {
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/driusan/dgit/git"
+)
+
+// UploadPack implements "git upload-pack <directory>", which serves a
+// fetch from the repository in directory over stdin and stdout.
+func UploadPack(args []string) error {
+	flags := flag.NewFlagSet("upload-pack", flag.ExitOnError)
+	flags.SetOutput(flag.CommandLine.Output())
+	flags.Usage = func() {
+		flag.Usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	opts := git.UploadPackOptions{GitProtocol: os.Getenv("GIT_PROTOCOL")}
+	flags.BoolVar(&opts.AdvertiseRefs, "advertise-refs", false, "Only advertise the refs and exit")
+	flags.BoolVar(&opts.StatelessRPC, "stateless-rpc", false, "Handle a single request without advertising the refs")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	dir := flags.Arg(0)
+	if st, err := os.Stat(filepath.Join(dir, ".git")); err == nil && st.IsDir() {
+		dir = filepath.Join(dir, ".git")
+	}
+	c, err := git.NewClient(dir, "")
+	if err != nil {
+		return err
+	}
+	return git.UploadPack(c, os.Stdin, os.Stdout, opts)
+}
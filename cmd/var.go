@@ -55,16 +55,11 @@ func getLogVar(c *git.Client, key string, envVar string) string {
 
 		return "ed"
 	} else if key == "GIT_PAGER" {
-		corePager := c.GetConfig("core.pager")
-		if corePager != "" {
-			return corePager
+		pager := c.PagerCommand()
+		if pager == "" {
+			return "cat"
 		}
-
-		pager := os.Getenv("PAGER")
-		if pager != "" {
-			return pager
-		}
-		return "less"
+		return pager
 	}
 
 	return ""
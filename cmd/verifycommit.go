@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func VerifyCommit(c *git.Client, args []string) error {
+	flags := newFlagSet("verify-commit")
+	verbose := flags.Bool("verbose", false, "Print the contents of the commit before validating it")
+	flags.BoolVar(verbose, "v", false, "Alias of --verbose")
+	flags.Parse(args)
+	args = flags.Args()
+
+	if len(args) == 0 {
+		flags.Usage()
+		return fmt.Errorf("Must provide a commit to verify")
+	}
+	var failed error
+	for _, arg := range args {
+		cmt, err := git.RevParseCommit(c, &git.RevParseOptions{}, arg)
+		if err != nil {
+			return err
+		}
+		if *verbose {
+			obj, err := c.GetCommitObject(cmt)
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(obj.GetContent()))
+		}
+		sig, err := git.VerifyCommit(c, cmt)
+		fmt.Fprint(os.Stderr, sig.Output)
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
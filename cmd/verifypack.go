@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/driusan/dgit/git"
+)
+
+func VerifyPack(c *git.Client, args []string) error {
+	flags := newFlagSet("verify-pack")
+	opts := git.VerifyPackOptions{}
+	flags.BoolVar(&opts.Verbose, "v", false, "Show the objects in the pack and a histogram of delta chain lengths")
+	flags.BoolVar(&opts.Verbose, "verbose", false, "Alias of -v")
+	flags.BoolVar(&opts.StatOnly, "s", false, "Only show the histogram of delta chain lengths")
+	flags.BoolVar(&opts.StatOnly, "stat-only", false, "Alias of -s")
+	flags.Parse(args)
+	args = flags.Args()
+
+	if len(args) == 0 {
+		flags.Usage()
+		return fmt.Errorf("Must provide pack index to verify")
+	}
+	if opts.StatOnly {
+		opts.Verbose = false
+	}
+	for _, idx := range args {
+		if _, err := git.VerifyPack(c, opts, git.File(idx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
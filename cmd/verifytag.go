@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/driusan/dgit/git"
+)
+
+func VerifyTag(c *git.Client, args []string) error {
+	flags := newFlagSet("verify-tag")
+	verbose := flags.Bool("verbose", false, "Print the contents of the tag object before validating it")
+	flags.BoolVar(verbose, "v", false, "Alias of --verbose")
+	flags.Parse(args)
+	args = flags.Args()
+
+	if len(args) == 0 {
+		flags.Usage()
+		return fmt.Errorf("Must provide a tag to verify")
+	}
+	var failed error
+	for _, arg := range args {
+		parsed, err := git.RevParse(c, git.RevParseOptions{Verify: true}, []string{arg})
+		if err != nil {
+			return err
+		}
+		tag := parsed[0].Id
+		if *verbose {
+			obj, err := c.GetObject(tag)
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(obj.GetContent()))
+		}
+		sig, err := git.VerifyTag(c, tag)
+		fmt.Fprint(os.Stderr, sig.Output)
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
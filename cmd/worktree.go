@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/driusan/dgit/git"
+)
+
+// Parses an expiry time in the form "now" or "<n>.<unit>.ago".
+func parseExpiry(s string) (time.Time, error) {
+	if s == "now" {
+		return time.Now(), nil
+	}
+	pieces := strings.Split(s, ".")
+	if len(pieces) == 3 && pieces[2] == "ago" {
+		n, err := strconv.Atoi(pieces[0])
+		if err == nil {
+			units := map[string]time.Duration{
+				"second": time.Second,
+				"minute": time.Minute,
+				"hour":   time.Hour,
+				"day":    24 * time.Hour,
+				"week":   7 * 24 * time.Hour,
+			}
+			if unit, ok := units[strings.TrimSuffix(pieces[1], "s")]; ok {
+				return time.Now().Add(-time.Duration(n) * unit), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("Unsupported expiry time: %v", s)
+}
+
+func Worktree(c *git.Client, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("Must provide a worktree subcommand")
+	}
+	switch args[0] {
+	case "prune":
+		flags := newFlagSet("worktree-prune")
+		opts := git.WorktreePruneOptions{}
+		flags.BoolVar(&opts.DryRun, "n", false, "Do not remove anything, only report what would be removed")
+		flags.BoolVar(&opts.DryRun, "dry-run", false, "Alias of -n")
+		verbose := flags.Bool("v", false, "Report all removals")
+		flags.BoolVar(verbose, "verbose", false, "Alias of -v")
+		expire := flags.String("expire", "", "Only expire unused worktrees older than <time>")
+		flags.Parse(args[1:])
+		if *expire != "" {
+			t, err := parseExpiry(*expire)
+			if err != nil {
+				return err
+			}
+			opts.Expire = t
+		}
+		pruned, err := git.WorktreePrune(c, opts)
+		if *verbose || opts.DryRun {
+			for _, msg := range pruned {
+				fmt.Println(msg)
+			}
+		}
+		return err
+	case "repair":
+		flags := newFlagSet("worktree-repair")
+		flags.Parse(args[1:])
+		var paths []git.File
+		for _, p := range flags.Args() {
+			paths = append(paths, git.File(p))
+		}
+		repairs, err := git.WorktreeRepair(c, paths)
+		for _, msg := range repairs {
+			fmt.Println(msg)
+		}
+		return err
+	default:
+		return fmt.Errorf("Worktree subcommand %v not implemented", args[0])
+	}
+}
@@ -23,9 +23,9 @@ func WriteTree(c *git.Client, args []string) (string, error) {
 	flags.StringVar(&opts.Prefix, "prefix", "", "write tree object for a subdirectory <prefix>")
 	flags.Parse(args)
 
-	sha1, err := git.WriteTree(c, opts)
+	tree, err := git.WriteTreeID(c, opts)
 	if err != nil {
 		return "", err
 	}
-	return sha1.String(), nil
+	return tree.String(), nil
 }
@@ -25,8 +25,9 @@ type AddOptions struct {
 	Chmod              BitSetter
 }
 
-// Add implements the "git add" plumbing command.
-func Add(c *Client, opts AddOptions, files []File) (*Index, error) {
+// Add implements the "git add" plumbing command. It returns the changes
+// made to the index, or that would have been made if opts.DryRun is set.
+func Add(c *Client, opts AddOptions, files []File) ([]PlannedChange, error) {
 	if opts.Patch {
 		diffs, err := DiffFiles(c, DiffFilesOptions{}, files)
 		if err != nil {
@@ -60,7 +61,9 @@ func Add(c *Client, opts AddOptions, files []File) (*Index, error) {
 			}
 
 		}
-		return c.GitDir.ReadIndex()
+		// The hunks that were staged are parts of files, so there's
+		// no change to report for them.
+		return nil, nil
 	}
 
 	if len(files) == 0 {
@@ -93,17 +96,34 @@ func Add(c *Client, opts AddOptions, files []File) (*Index, error) {
 		lsOpts.Deleted = false
 	}
 
+	idx, lock, err := c.GitDir.LockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Rollback()
 	fileIdxs, err := LsFiles(c, lsOpts, files)
 	if err != nil {
 		return nil, err
 	}
+	imap := idx.GetMap()
 	fles := make([]File, len(fileIdxs), len(fileIdxs))
+	changes := make([]PlannedChange, len(fileIdxs), len(fileIdxs))
 	for i, f := range fileIdxs {
 		file, err := f.PathName.FilePath(c)
 		if err != nil {
 			return nil, err
 		}
 		fles[i] = file
+		changes[i] = PlannedChange{Action: ChangeUpdate, Path: file, IndexOnly: true}
+		if !file.Exists() {
+			changes[i].Action = ChangeRemove
+		} else if _, ok := imap[f.PathName]; !ok {
+			changes[i].Action = ChangeAdd
+		}
+	}
+	if opts.DryRun {
+		// Updating the index would write the blobs, so stop here.
+		return changes, nil
 	}
 
 	updateIndexOpts := UpdateIndexOptions{
@@ -114,24 +134,16 @@ func Add(c *Client, opts AddOptions, files []File) (*Index, error) {
 		Refresh: opts.Refresh,
 		Chmod:   opts.Chmod,
 
+		// As in git, add --refresh doesn't say which files need to
+		// be updated.
+		Quiet: true,
+
 		correctRemoveMsg: true,
 	}
-	idx, err := c.GitDir.ReadIndex()
-	if err != nil {
-		return nil, err
-	}
 	newidx, err := UpdateIndex(c, idx, updateIndexOpts, fles)
 	if err != nil {
 		return nil, err
 	}
-
-	if !opts.DryRun {
-		f, err := c.GitDir.Create(File("index"))
-		if err != nil {
-			return nil, err
-		}
-		defer f.Close()
-		return newidx, newidx.WriteIndex(f)
-	}
-	return newidx, nil
+	newidx.UseConfiguredVersion(c)
+	return changes, newidx.CommitLocked(lock)
 }
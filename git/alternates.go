@@ -0,0 +1,240 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The maximum depth of alternates of alternates that will be followed,
+// the same as the official git client.
+const maxAlternateDepth = 5
+
+// Returns the object directories that objects may be found in. The
+// repository's own objects directory is always first, followed by the
+// directories listed in GIT_ALTERNATE_OBJECT_DIRECTORIES and
+// objects/info/alternates (recursively.) A quarantined client's temporary
+// objects directory comes before all of them, and the pending quarantines
+// of a client with ReadQuarantine set come after.
+//
+// Each directory is only included once, so alternates which refer back
+// to a repository that has already been included are ignored.
+func (c *Client) objectDirs() ([]File, error) {
+	if c.objectDirsCache != nil {
+		return c.withQuarantines(c.objectDirsCache), nil
+	}
+	seen := make(map[string]struct{})
+	var dirs []File
+	var add func(dir File, depth int) error
+	add = func(dir File, depth int) error {
+		abs, err := filepath.Abs(dir.String())
+		if err != nil {
+			return err
+		}
+		abs = filepath.Clean(abs)
+		if _, ok := seen[abs]; ok {
+			return nil
+		}
+		seen[abs] = struct{}{}
+		if depth > 0 && !File(abs).IsDir() {
+			// git warns about alternates that don't exist,
+			// but they're otherwise ignored.
+			return nil
+		}
+		dirs = append(dirs, dir)
+		if depth >= maxAlternateDepth {
+			return nil
+		}
+		alternates, err := readAlternates(dir)
+		if err != nil {
+			return err
+		}
+		for _, alt := range alternates {
+			if err := add(alt, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if c.quarantine != "" {
+		// Objects being quarantined take precedence, so that they
+		// can be read back before being committed.
+		if err := add(c.quarantine, 0); err != nil {
+			return nil, err
+		}
+	}
+	objdir := c.GitDir.File("objects")
+	if err := add(objdir, 0); err != nil {
+		return nil, err
+	}
+	if env := os.Getenv("GIT_ALTERNATE_OBJECT_DIRECTORIES"); env != "" {
+		for _, alt := range filepath.SplitList(env) {
+			if alt == "" {
+				continue
+			}
+			if err := add(File(alt), 1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	c.objectDirsCache = dirs
+	return c.withQuarantines(dirs), nil
+}
+
+// Returns dirs followed by the pending quarantines of c if ReadQuarantine
+// is set. They're not cached with the other directories, since
+// ReadQuarantine may be changed at any time.
+func (c *Client) withQuarantines(dirs []File) []File {
+	if !c.ReadQuarantine || len(c.quarantines) == 0 {
+		return dirs
+	}
+	return append(dirs[:len(dirs):len(dirs)], c.quarantines...)
+}
+
+// Reads the list of alternate object directories from the
+// info/alternates file in the object directory dir. Relative
+// paths are relative to dir.
+func readAlternates(dir File) ([]File, error) {
+	f, err := os.Open(filepath.Join(dir.String(), "info", "alternates"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var alternates []File
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(dir.String(), line)
+		}
+		alternates = append(alternates, File(line))
+	}
+	return alternates, scanner.Err()
+}
+
+// Returns the absolute path of the objects directory of the local
+// repository at path, which may either be a bare repository or have a .git
+// directory.
+func localObjectDir(path File) (File, error) {
+	abs, err := filepath.Abs(path.String())
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range []string{filepath.Join(abs, ".git", "objects"), filepath.Join(abs, "objects")} {
+		if File(dir).IsDir() {
+			return File(dir), nil
+		}
+	}
+	return "", fmt.Errorf("reference repository '%v' is not a local repository.", path)
+}
+
+// Appends dirs to the objects/info/alternates file of the repository
+// for c.
+func writeAlternates(c *Client, dirs []File) error {
+	if err := os.MkdirAll(c.GitDir.File("objects/info").String(), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(c.GitDir.File("objects/info/alternates").String(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, dir := range dirs {
+		if _, err := fmt.Fprintln(f, dir); err != nil {
+			return err
+		}
+	}
+	c.objectDirsCache = nil
+	return nil
+}
+
+// Copies every object from the alternates of c into its own objects
+// directory and then removes the alternates, so that the repository no
+// longer depends on them.
+func dissociate(c *Client) error {
+	dirs, err := c.objectDirs()
+	if err != nil {
+		return err
+	}
+	local := c.GitDir.File("objects")
+	for _, dir := range dirs[1:] {
+		err := filepath.Walk(dir.String(), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir.String(), path)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if rel == "info" {
+					// Don't inherit the alternate's alternates
+					// or other metadata.
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasPrefix(filepath.Base(path), ".") {
+				// Temporary files from an in progress operation.
+				return nil
+			}
+			dst := filepath.Join(local.String(), rel)
+			if File(dst).Exists() {
+				return nil
+			}
+			return copyObjectFile(path, dst)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(c.GitDir.File("objects/info/alternates").String()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c.objectDirsCache = nil
+	c.objectCache = make(map[Sha1]objectLocation)
+	return nil
+}
+
+// Copies the file src to dst, creating the directory for dst if needed.
+// The file is written to a temporary file first so that an object is
+// never partially written.
+func copyObjectFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(filepath.Dir(dst), ".tmpobject")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	if err := os.Chmod(out.Name(), 0444); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
@@ -0,0 +1,78 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that objects are found through a chain of alternates, and that a
+// cycle of alternates doesn't cause an infinite loop.
+func TestAlternates(t *testing.T) {
+	c, cleanup := testRepo(t, "gitalternates")
+	defer cleanup()
+	testCommitFile(t, c, "foo.txt", "bar\n", "Initial commit")
+	blob, err := RevParsePath(c, &RevParseOptions{}, "HEAD:foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "gitalternatesborrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	middle, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "middle.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	borrower, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "borrower.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := borrower.GetObject(Sha1(blob)); err == nil {
+		t.Fatal("Object found before adding alternates")
+	}
+
+	// borrower uses a relative path to middle, which uses an absolute
+	// path to the original repo, which points back at borrower.
+	if err := os.MkdirAll(borrower.GitDir.File("objects/info").String(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := borrower.GitDir.WriteFile("objects/info/alternates", []byte("../../middle.git/objects\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(middle.GitDir.File("objects/info").String(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	abs, err := filepath.Abs(c.GitDir.File("objects").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := middle.GitDir.WriteFile("objects/info/alternates", []byte("# comment\n"+abs+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GitDir.WriteFile("objects/info/alternates", []byte(borrower.GitDir.File("objects").String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	borrower, err = NewClient(borrower.GitDir.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := borrower.GetObject(Sha1(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(obj.GetContent()) != "bar\n" {
+		t.Errorf("Unexpected content from alternate: got %q want %q", obj.GetContent(), "bar\n")
+	}
+	dirs, err := borrower.objectDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 3 {
+		t.Errorf("Unexpected object directories: %v", dirs)
+	}
+}
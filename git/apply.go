@@ -1,12 +1,15 @@
 package git
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -45,6 +48,10 @@ type ApplyOptions struct {
 
 	UnsafePaths bool
 
+	// What to do with the whitespace errors in the lines added by the
+	// patches, according to core.whitespace. One of "nowarn", "warn",
+	// "fix" (or "strip"), "error" or "error-all". The empty string uses
+	// the apply.whitespace config, or "warn" if it's not set.
 	Whitespace string
 }
 
@@ -64,13 +71,63 @@ func Apply(c *Client, opts ApplyOptions, patches []File) error {
 		opts.Index = true
 	}
 
+	wsMode := opts.Whitespace
+	if wsMode == "" {
+		wsMode = c.GetConfig("apply.whitespace")
+	}
+	switch wsMode {
+	case "":
+		wsMode = "warn"
+	case "strip":
+		wsMode = "fix"
+	case "nowarn", "warn", "fix", "error", "error-all":
+	default:
+		return fmt.Errorf("unrecognized whitespace option '%v'", wsMode)
+	}
+	wsRule, err := whitespaceRule(c)
+	if err != nil {
+		return err
+	}
+	ws := whitespaceChecker{
+		Rule:    wsRule,
+		Fix:     wsMode == "fix",
+		Reverse: opts.Reverse,
+		W:       os.Stderr,
+		// As in git, only the first 5 errors are reported unless
+		// every error was asked for.
+		Max: 5,
+	}
+	if wsMode == "error-all" {
+		ws.Max = 0
+	}
+
 	// First pass, parse the patches to figure out which files are involved
+	// and check them for whitespace errors.
 	files := make(map[IndexPath]bool)
-	for _, patch := range patches {
-		patch, err := ioutil.ReadFile(patch.String())
+	patches = append([]File(nil), patches...)
+	for i, patchfile := range patches {
+		patch, err := ioutil.ReadFile(patchfile.String())
 		if err != nil {
 			return err
 		}
+		if wsMode != "nowarn" {
+			if fixed := ws.checkPatch(patchfile.String(), string(patch)); fixed != string(patch) {
+				// Apply the fixed patch instead of the original.
+				f, err := ioutil.TempFile("", "gitapplyfixed")
+				if err != nil {
+					return err
+				}
+				defer os.Remove(f.Name())
+				_, err = f.WriteString(fixed)
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+				if err != nil {
+					return err
+				}
+				patches[i] = File(f.Name())
+			}
+		}
 		hunks, err := splitPatch(string(patch), true)
 		if err != nil {
 			return err
@@ -83,12 +140,14 @@ func Apply(c *Client, opts ApplyOptions, patches []File) error {
 	// Copy all of the files. We do this in a second pass to avoid
 	// needlessly recopying the same files multiple times.
 	var idx *Index
+	var lock *LockFile
 	if opts.Index {
-		idx2, err := c.GitDir.ReadIndex()
+		idx2, lock2, err := c.GitDir.LockIndex()
 		if err != nil {
 			return err
 		}
-		idx = idx2
+		defer lock2.Rollback()
+		idx, lock = idx2, lock2
 	}
 	for file := range files {
 		f, err := file.FilePath(c)
@@ -108,13 +167,29 @@ func Apply(c *Client, opts ApplyOptions, patches []File) error {
 		}
 	}
 
+	if ws.Errors > 0 && (wsMode == "error" || wsMode == "error-all") {
+		ws.printSquelched()
+		return fmt.Errorf("%d %v whitespace errors.", ws.Errors, plural(ws.Errors, "line adds", "lines add"))
+	}
+
 	var patchDirection string
 	if opts.Reverse {
 		patchDirection = "-R"
 	} else {
 		patchDirection = "-N"
 	}
+	var conflicts []applyConflict
 	for _, patch := range patches {
+		if opts.ThreeWay {
+			// Each file is patched separately, so that only the
+			// ones which don't apply fall back on a merge.
+			pconflicts, err := applyThreeWay(c, patch, patchdir, patchDirection)
+			if err != nil {
+				return err
+			}
+			conflicts = append(conflicts, pconflicts...)
+			continue
+		}
 		patchcmd := exec.Command(posixPatch, "--directory", patchdir, "-i", patch.String(), patchDirection, "-p1", "-F", "0")
 		patchcmd.Stderr = os.Stderr
 		_, err := patchcmd.Output()
@@ -122,15 +197,33 @@ func Apply(c *Client, opts ApplyOptions, patches []File) error {
 			return err
 		}
 	}
+	// The work tree is updated first, so that the stat info in the index
+	// is for the patched files.
+	if !opts.Cached {
+		if err := copyApplyDir(c, patchdir); err != nil {
+			return err
+		}
+	}
 	if opts.Index {
-		if err := updateApplyIndex(c, idx, patchdir); err != nil {
+		if err := updateApplyIndex(c, idx, lock, patchdir, conflicts); err != nil {
 			return err
 		}
-		if opts.Cached {
-			return nil
+	}
+	if len(conflicts) > 0 {
+		for _, conflict := range conflicts {
+			fmt.Fprintf(os.Stderr, "U %v\n", conflict.Path)
 		}
+		return fmt.Errorf("%d %v applied with conflicts", len(conflicts), plural(len(conflicts), "file was", "files were"))
 	}
-	return copyApplyDir(c, patchdir)
+	if ws.Errors > 0 {
+		ws.printSquelched()
+		if ws.Fixed > 0 {
+			fmt.Fprintf(os.Stderr, "warning: %d %v after fixing whitespace errors.\n", ws.Fixed, plural(ws.Fixed, "line applied", "lines applied"))
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: %d %v whitespace errors.\n", ws.Errors, plural(ws.Errors, "line adds", "lines add"))
+		}
+	}
+	return nil
 
 }
 
@@ -150,12 +243,22 @@ func copyApplyDir(c *Client, dir string) error {
 
 // RestoreDir takes the directory dir, which is the directory that apply did
 // its work in, and copies it back into the workdir.
-func updateApplyIndex(c *Client, idx *Index, dir string) error {
+//
+// The files which were merged with conflicts are added to the index at
+// stages 1 to 3 instead.
+func updateApplyIndex(c *Client, idx *Index, lock *LockFile, dir string, conflicts []applyConflict) error {
+	isConflicted := make(map[IndexPath]bool)
+	for _, conflict := range conflicts {
+		isConflicted[conflict.Path] = true
+	}
 	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if info.IsDir() {
 			return nil
 		}
 		relpath := strings.TrimPrefix(path, dir+"/")
+		if isConflicted[IndexPath(relpath)] {
+			return nil
+		}
 		contents, err := ioutil.ReadFile(path)
 		if err != nil {
 			return err
@@ -172,6 +275,8 @@ func updateApplyIndex(c *Client, idx *Index, dir string) error {
 				continue
 			}
 			log.Printf("Refreshing %v: %v", ipath, sha1)
+			idx.invalidateCacheTree(ipath)
+			idx.fsmonitor.invalidate(ipath)
 			entry.Sha1 = sha1
 			if err := entry.RefreshStat(c); err != nil {
 				return err
@@ -180,13 +285,25 @@ func updateApplyIndex(c *Client, idx *Index, dir string) error {
 		}
 		return nil
 	})
-	// Write the index that the callback modified
-	f, err := c.GitDir.Create(File("index"))
-	if err != nil {
-		return err
+	for _, conflict := range conflicts {
+		mode := ModeBlob
+		for _, entry := range idx.Objects {
+			if entry.PathName == conflict.Path {
+				mode = entry.Mode
+				break
+			}
+		}
+		idx.RemoveFile(conflict.Path)
+		for i, sha := range []Sha1{conflict.Base, conflict.Ours, conflict.Theirs} {
+			if err := idx.AddStage(c, conflict.Path, mode, sha, Stage(i+1), 0, 0, UpdateIndexOptions{Add: true}); err != nil {
+				return err
+			}
+		}
 	}
-	defer f.Close()
-	return idx.WriteIndex(f)
+
+	// Write the index that the callback modified
+	idx.UseConfiguredVersion(c)
+	return idx.CommitLocked(lock)
 }
 
 func copyFile(src, dst string) error {
@@ -232,3 +349,147 @@ func copyFromIndex(c *Client, idx *Index, file IndexPath, dst string) error {
 
 	return ioutil.WriteFile(dst, obj.GetContent(), 0644)
 }
+
+// A file which apply --3way merged with conflicts, and the blobs of the
+// stages to add to the index for it.
+type applyConflict struct {
+	Path               IndexPath
+	Base, Ours, Theirs Sha1
+}
+
+// Matches the line of a patch with the blobs before and after it.
+var applyIndexRE = regexp.MustCompile(`(?m)^index ([0-9a-f]+)\.\.([0-9a-f]+)`)
+
+// Applies each file in patch to its copy in patchdir. If the patch doesn't
+// apply to a file, the blob the patch was made from is looked up from its
+// index line, patched instead, and the result is merged into the file in
+// the same way as git apply --3way. The files which had conflicts are
+// returned.
+func applyThreeWay(c *Client, patch File, patchdir, direction string) ([]applyConflict, error) {
+	content, err := ioutil.ReadFile(patch.String())
+	if err != nil {
+		return nil, err
+	}
+	files, err := splitPatch(string(content), true)
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []applyConflict
+	for _, file := range files {
+		dst := filepath.Join(patchdir, file.File.String())
+		ours, err := ioutil.ReadFile(dst)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		exists := err == nil
+		if patched, err := patchFile(file, ours, exists, direction); err == nil {
+			if err := writeFileAll(dst, patched); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "error: %v: patch does not apply\n", file.File)
+
+		m := applyIndexRE.FindStringSubmatch(file.Hunk)
+		if m == nil || !exists {
+			return nil, fmt.Errorf("%v: patch does not apply", file.File)
+		}
+		pre := m[1]
+		if direction == "-R" {
+			pre = m[2]
+		}
+		baseSha, err := blobFromAbbrev(c, pre)
+		if err != nil {
+			return nil, fmt.Errorf("repository lacks the necessary blob to perform 3-way merge: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Falling back to three-way merge...\n")
+		obj, err := c.GetObject(baseSha)
+		if err != nil {
+			return nil, err
+		}
+		base := obj.GetContent()
+		theirs, err := patchFile(file, base, true, direction)
+		if err != nil {
+			return nil, fmt.Errorf("%v: patch does not apply to %v", file.File, pre)
+		}
+		merged, nconflicts, err := MergeFile(bytes.NewReader(ours), bytes.NewReader(base), bytes.NewReader(theirs), MergeFileOptions{
+			CurrentLabel: "ours",
+			BaseLabel:    "base",
+			OtherLabel:   "theirs",
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAll(dst, merged); err != nil {
+			return nil, err
+		}
+		if nconflicts == 0 {
+			fmt.Fprintf(os.Stderr, "Applied patch to '%v' cleanly.\n", file.File)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Applied patch to '%v' with conflicts.\n", file.File)
+		conflict := applyConflict{Path: file.File, Base: baseSha}
+		if conflict.Ours, err = c.WriteObject("blob", ours); err != nil {
+			return nil, err
+		}
+		if conflict.Theirs, err = c.WriteObject("blob", theirs); err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts, nil
+}
+
+// Applies the part of a patch for file to content, in a temporary
+// directory so that nothing is changed if it doesn't apply, and returns the
+// patched content.
+func patchFile(file patchHunk, content []byte, exists bool, direction string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "gitapply3way")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, file.File.String())
+	if exists {
+		if err := writeFileAll(dst, content); err != nil {
+			return nil, err
+		}
+	}
+	patch := filepath.Join(dir, ".patch")
+	if err := ioutil.WriteFile(patch, []byte(file.Hunk), 0644); err != nil {
+		return nil, err
+	}
+	patchcmd := exec.Command(posixPatch, "--directory", dir, "-i", patch, direction, "-p1", "-F", "0")
+	if out, err := patchcmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, out)
+	}
+	return ioutil.ReadFile(dst)
+}
+
+// Writes content to the file dst, creating the directories that it's in.
+func writeFileAll(dst string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, content, 0644)
+}
+
+// Returns the blob that abbrev, from the index line of a patch, is an
+// abbreviation of.
+func blobFromAbbrev(c *Client, abbrev string) (Sha1, error) {
+	cmt, err := RevParseCommitish(c, &RevParseOptions{}, abbrev)
+	if err != nil {
+		return Sha1{}, err
+	}
+	id, ok := cmt.(CommitID)
+	if !ok {
+		return Sha1{}, fmt.Errorf("%v is not an object", abbrev)
+	}
+	if typ, err := c.ObjectType(Sha1(id)); err != nil {
+		return Sha1{}, err
+	} else if typ != "blob" {
+		return Sha1{}, fmt.Errorf("%v is a %v, not a blob", abbrev, typ)
+	}
+	return Sha1(id), nil
+}
@@ -1,8 +1,10 @@
 package git
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -276,3 +278,103 @@ diff --git a/qux/qux.txt b/qux/qux.txt
 		t.Errorf("Did not apply --cached patch correctly. Got %v want %v", idx[0].Sha1, want)
 	}
 }
+
+// Tests that applying a patch with --whitespace=fix removes the whitespace
+// errors from the lines that it adds, and that --whitespace=error doesn't
+// apply it.
+func TestApplyWhitespaceFix(t *testing.T) {
+	c, cleanup := testRepo(t, "gitapplywhitespace")
+	defer cleanup()
+
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patch, err := ioutil.TempFile("", "applytestpatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(patch.Name())
+	if _, err := patch.WriteString("diff --git a/foo.txt b/foo.txt\n--- a/foo.txt\n+++ b/foo.txt\n@@ -1 +1,4 @@\n foo\n+bar  \n+ \tbaz\n+\n"); err != nil {
+		t.Fatal(err)
+	}
+	patch.Close()
+
+	if err := Apply(c, ApplyOptions{Whitespace: "error"}, []File{File(patch.Name())}); err == nil {
+		t.Error("A patch with whitespace errors was applied with --whitespace=error")
+	}
+	if file, err := ioutil.ReadFile("foo.txt"); err != nil || string(file) != "foo\n" {
+		t.Errorf("Unexpected foo.txt after --whitespace=error: %q (%v)", file, err)
+	}
+
+	if err := Apply(c, ApplyOptions{Whitespace: "fix"}, []File{File(patch.Name())}); err != nil {
+		t.Fatal(err)
+	}
+	if file, err := ioutil.ReadFile("foo.txt"); err != nil || string(file) != "foo\nbar\n\tbaz\n" {
+		t.Errorf("Unexpected foo.txt after --whitespace=fix: %q (%v)", file, err)
+	}
+}
+
+// Tests that a patch which doesn't apply to a file after it drifted is
+// merged into it with --3way, using the blob from the patch's index line.
+func TestApplyThreeWay(t *testing.T) {
+	c, cleanup := testRepo(t, "gitapply3way")
+	defer cleanup()
+
+	base := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	testCommitFile(t, c, "foo.txt", base, "Add foo")
+	after := strings.Replace(base, "8\n", "eight\n", 1)
+	patch, err := ioutil.TempFile("", "applytestpatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(patch.Name())
+	if _, err := fmt.Fprintf(patch, "diff --git a/foo.txt b/foo.txt\nindex %v..%v 100644\n--- a/foo.txt\n+++ b/foo.txt\n@@ -5,5 +5,5 @@\n 5\n 6\n 7\n-8\n+eight\n 9\n", hashString(base).String()[:7], hashString(after).String()[:7]); err != nil {
+		t.Fatal(err)
+	}
+	patch.Close()
+
+	// The context of the patch changed, but not the line that it
+	// changes.
+	drifted := strings.Replace(base, "6\n", "six\n", 1)
+	testCommitFile(t, c, "foo.txt", drifted, "Change the context")
+	if err := Apply(c, ApplyOptions{}, []File{File(patch.Name())}); err == nil {
+		t.Fatal("Expected an error applying the patch without --3way")
+	}
+	if err := Apply(c, ApplyOptions{ThreeWay: true, Index: true}, []File{File(patch.Name())}); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(drifted, "8\n", "eight\n", 1)
+	if file, err := ioutil.ReadFile("foo.txt"); err != nil || string(file) != want {
+		t.Errorf("Unexpected foo.txt after a clean 3-way apply: got %q (%v) want %q", file, err, want)
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Objects) != 1 || idx.Objects[0].Stage() != Stage0 || idx.Objects[0].Sha1 != hashString(want) {
+		t.Errorf("The result of the 3-way apply was not staged: %v", idx.Objects)
+	}
+
+	// A change to the same line conflicts.
+	conflicting := strings.Replace(base, "8\n", "ocho\n", 1)
+	testCommitFile(t, c, "foo.txt", conflicting, "Change the same line")
+	if err := Apply(c, ApplyOptions{ThreeWay: true, Index: true}, []File{File(patch.Name())}); err == nil {
+		t.Error("Expected an error for a 3-way apply with conflicts")
+	}
+	want = "1\n2\n3\n4\n5\n6\n7\n<<<<<<< ours\nocho\n=======\neight\n>>>>>>> theirs\n9\n"
+	if file, err := ioutil.ReadFile("foo.txt"); err != nil || string(file) != want {
+		t.Errorf("Unexpected foo.txt after a conflicted 3-way apply: got %q (%v) want %q", file, err, want)
+	}
+	if idx, err = c.GitDir.ReadIndex(); err != nil {
+		t.Fatal(err)
+	}
+	wantStages := []Sha1{hashString(base), hashString(conflicting), hashString(after)}
+	if len(idx.Objects) != 3 {
+		t.Fatalf("Unexpected index after a conflicted 3-way apply: %v", idx.Objects)
+	}
+	for i, entry := range idx.Objects {
+		if entry.Stage() != Stage(i+1) || entry.Sha1 != wantStages[i] {
+			t.Errorf("Unexpected stage %d: got %v %v want %v", i+1, entry.Stage(), entry.Sha1, wantStages[i])
+		}
+	}
+}
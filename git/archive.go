@@ -70,14 +70,18 @@ func createTarArchive(c *Client, opts ArchiveOptions, tgz bool, sha Sha1, mtime
 	}
 
 	for _, e := range entries {
-		o, err := c.GetObject(e.Sha1)
-		if err != nil {
-			return err
+		if e.Mode == ModeTree {
+			continue
 		}
-		if obj, ok := o.(GitBlobObject); ok {
+		if err := func() error {
+			r, size, err := c.BlobReader(e.Sha1)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
 			hdr := &tar.Header{}
 			hdr.Name = opts.BasePrefix + e.PathName.String()
-			hdr.Size = int64(obj.GetSize())
+			hdr.Size = size
 			hdr.ModTime = mtime
 
 			// TODO: Mask the mode. by default the mask is 0002 (turn off write bit)
@@ -94,9 +98,10 @@ func createTarArchive(c *Client, opts ArchiveOptions, tgz bool, sha Sha1, mtime
 			if err := tw.WriteHeader(hdr); err != nil {
 				return err
 			}
-			if _, err := tw.Write(o.GetContent()); err != nil {
-				return err
-			}
+			_, err = io.Copy(tw, r)
+			return err
+		}(); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -122,11 +127,15 @@ func createZipArchive(c *Client, opts ArchiveOptions, sha Sha1, mtime time.Time,
 	zw.SetComment(sha.String())
 
 	for _, e := range entries {
-		o, err := c.GetObject(e.Sha1)
-		if err != nil {
-			return err
+		if e.Mode == ModeTree {
+			continue
 		}
-		if obj, ok := o.(GitBlobObject); ok {
+		if err := func() error {
+			r, _, err := c.BlobReader(e.Sha1)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
 			hdr := &zip.FileHeader{
 				Name:     opts.BasePrefix + e.PathName.String(),
 				Modified: mtime,
@@ -138,9 +147,10 @@ func createZipArchive(c *Client, opts ArchiveOptions, sha Sha1, mtime time.Time,
 				return nil
 			}
 
-			if _, err := f.Write(obj.GetContent()); err != nil {
-				return err
-			}
+			_, err = io.Copy(f, r)
+			return err
+		}(); err != nil {
+			return err
 		}
 	}
 
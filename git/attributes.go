@@ -0,0 +1,141 @@
+package git
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+)
+
+// The values of attributes which are set or unset without a value, as
+// they're printed by git check-attr.
+const (
+	attributeSet   = "set"
+	attributeUnset = "unset"
+)
+
+// A line of a gitattributes file, which applies attrs to the paths
+// matching pattern in the directory scope.
+type attributeRule struct {
+	pattern string
+	scope   string
+	attrs   []attributeAssignment
+}
+
+// An assignment of an attribute in a gitattributes line. An empty value
+// means that the attribute is unspecified by the line, which undoes any
+// earlier assignment.
+type attributeAssignment struct {
+	name, value string
+}
+
+// The builtin attribute macros, which can be used as attributes in any
+// gitattributes file.
+var attributeMacros = map[string][]attributeAssignment{
+	"binary": {{"diff", attributeUnset}, {"merge", attributeUnset}, {"text", attributeUnset}},
+}
+
+// Parses the gitattributes file name, whose patterns apply to the paths
+// under scope. A file that doesn't exist has no rules.
+func parseAttributesFile(name File, scope string) ([]attributeRule, error) {
+	f, err := os.Open(name.String())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var rules []attributeRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "!") {
+			// Negative patterns are forbidden in gitattributes
+			// files, and are ignored like git does.
+			continue
+		}
+		rule := attributeRule{pattern: fields[0], scope: scope}
+		for _, attr := range fields[1:] {
+			var a attributeAssignment
+			switch {
+			case strings.HasPrefix(attr, "-"):
+				a = attributeAssignment{attr[1:], attributeUnset}
+			case strings.HasPrefix(attr, "!"):
+				a = attributeAssignment{attr[1:], ""}
+			case strings.Contains(attr, "="):
+				eq := strings.IndexByte(attr, '=')
+				a = attributeAssignment{attr[:eq], attr[eq+1:]}
+			default:
+				a = attributeAssignment{attr, attributeSet}
+			}
+			rule.attrs = append(rule.attrs, a)
+			if a.value == attributeSet {
+				rule.attrs = append(rule.attrs, attributeMacros[a.name]...)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// Returns true if the rule applies to the path p.
+func (r attributeRule) matches(p IndexPath) bool {
+	name := p.String()
+	if r.scope != "" {
+		if !strings.HasPrefix(name, r.scope+"/") {
+			return false
+		}
+		name = name[len(r.scope)+1:]
+	}
+	return matchesGlob("/"+name, false, r.pattern)
+}
+
+// Returns the attributes of p from the gitattributes files, in order of
+// increasing precedence: core.attributesFile, the .gitattributes files
+// in the work tree from the root down to the directory of p, and
+// $GIT_DIR/info/attributes. Unspecified attributes aren't included.
+func attributesFor(c *Client, p IndexPath) (map[string]string, error) {
+	var files []File
+	var scopes []string
+	if global := c.GetConfig("core.attributesfile"); global != "" {
+		if strings.HasPrefix(global, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				global = home + global[1:]
+			}
+		}
+		files, scopes = append(files, File(global)), append(scopes, "")
+	}
+	if c.WorkDir != "" {
+		dirs := []string{""}
+		for dir := path.Dir(p.String()); dir != "."; dir = path.Dir(dir) {
+			dirs = append(dirs[:1], append([]string{dir}, dirs[1:]...)...)
+		}
+		for _, dir := range dirs {
+			files = append(files, File(path.Join(c.WorkDir.String(), dir, ".gitattributes")))
+			scopes = append(scopes, dir)
+		}
+	}
+	files, scopes = append(files, c.GitDir.File("info/attributes")), append(scopes, "")
+
+	attrs := make(map[string]string)
+	for i, f := range files {
+		rules, err := parseAttributesFile(f, scopes[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules {
+			if !rule.matches(p) {
+				continue
+			}
+			for _, a := range rule.attrs {
+				if a.value == "" {
+					delete(attrs, a.name)
+				} else {
+					attrs[a.name] = a.value
+				}
+			}
+		}
+	}
+	return attrs, nil
+}
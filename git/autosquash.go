@@ -0,0 +1,125 @@
+package git
+
+import (
+	"strings"
+)
+
+// Returns the rest of subject after a "fixup! ", "squash! " or "amend! "
+// prefix, and whether it had one.
+func skipFixupPrefix(subject string) (string, bool) {
+	for _, prefix := range []string{"fixup! ", "squash! ", "amend! "} {
+		if strings.HasPrefix(subject, prefix) {
+			return subject[len(prefix):], true
+		}
+	}
+	return subject, false
+}
+
+// AutosquashTodo rearranges the steps of an interactive rebase the way
+// "git rebase --autosquash" does. A commit whose subject starts with
+// "fixup! ", "squash! " or "amend! " is moved to just after the commit
+// that the rest of its subject refers to, and its action is changed to
+// RebaseFixup or RebaseSquash. An amend! commit is a fixup which replaces
+// the message with the rest of its own (see FixupMessage). Any number of
+// those prefixes are stripped, so that a fixup of a fixup is attached to
+// the original commit.
+//
+// As in git, the rest of the subject refers to an earlier commit in todo
+// by its subject, then by any name for the commit if it has no spaces,
+// such as an abbreviated sha, and finally by a prefix of its subject.
+// Commits which refer to the same one keep their order after it, and the
+// steps which don't refer to anything are left where they are.
+func AutosquashTodo(c *Client, todo []RebaseStep) ([]RebaseStep, error) {
+	result := make([]RebaseStep, len(todo))
+	copy(result, todo)
+
+	subjects := make([]string, len(todo))
+	// The index of the first step with each subject, leaving out the
+	// fixups which were attached to something.
+	bySubject := make(map[string]int)
+	byCommit := make(map[CommitID]int)
+	// For each step, the steps which were attached to it in order.
+	attached := make(map[int][]int)
+	moved := make([]bool, len(todo))
+	for i, step := range todo {
+		if step.Action == RebaseExec || step.Action == RebaseDrop {
+			continue
+		}
+		msg, err := step.Commit.GetCommitMessage(c)
+		if err != nil {
+			return nil, err
+		}
+		subject := msg.Subject()
+		subjects[i] = subject
+
+		target := -1
+		if rest, ok := skipFixupPrefix(subject); ok {
+			for ok {
+				rest, ok = skipFixupPrefix(strings.TrimLeft(rest, " \t"))
+			}
+			if j, found := bySubject[rest]; found {
+				target = j
+			} else if j, found := autosquashCommit(c, byCommit, rest); found {
+				target = j
+			} else {
+				for j := 0; j < i; j++ {
+					if subjects[j] != "" && strings.HasPrefix(subjects[j], rest) {
+						target = j
+						break
+					}
+				}
+			}
+		}
+		if target >= 0 {
+			switch {
+			case strings.HasPrefix(subject, "squash!"):
+				result[i].Action = RebaseSquash
+			case strings.HasPrefix(subject, "amend!"):
+				// The message of an amend! commit after its
+				// subject replaces the message of the target.
+				result[i].Action = RebaseFixup
+				result[i].Message = msg.body()
+			default:
+				result[i].Action = RebaseFixup
+			}
+			attached[target] = append(attached[target], i)
+			moved[i] = true
+		} else if _, found := bySubject[subject]; !found {
+			bySubject[subject] = i
+		}
+		byCommit[step.Commit] = i
+	}
+
+	rearranged := make([]RebaseStep, 0, len(todo))
+	var add func(i int)
+	add = func(i int) {
+		rearranged = append(rearranged, result[i])
+		for _, j := range attached[i] {
+			add(j)
+		}
+	}
+	for i := range result {
+		if !moved[i] {
+			add(i)
+		}
+	}
+	return rearranged, nil
+}
+
+// Returns the index of the step in byCommit for the commit named by name,
+// if it's a name without any spaces.
+func autosquashCommit(c *Client, byCommit map[CommitID]int, name string) (int, bool) {
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return 0, false
+	}
+	cmtish, err := RevParseCommitish(c, &RevParseOptions{}, name)
+	if err != nil {
+		return 0, false
+	}
+	cmt, err := cmtish.CommitID(c)
+	if err != nil {
+		return 0, false
+	}
+	i, ok := byCommit[cmt]
+	return i, ok
+}
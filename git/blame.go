@@ -0,0 +1,562 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BlameOptions are the options for Blame.
+type BlameOptions struct {
+	// The commit to blame the file at. If nil, HEAD is used. Changes
+	// which haven't been committed aren't blamed.
+	Revision Commitish
+
+	// Don't mark root commits as boundaries, as with --root or the
+	// blame.showRoot config.
+	ShowRoot bool
+
+	// Detect lines which were moved within the file, as with -M, if each
+	// block of them has at least MoveScore alphanumeric characters. The
+	// 0 value doesn't detect moves.
+	MoveScore int
+
+	// Detect lines which were copied from other files, as with -C. Each
+	// level looks harder in the parents of a commit: 1 looks in the files
+	// which the commit modified, 2 also looks in every file if the commit
+	// created the file and 3 always looks in every file. Detecting
+	// copies also detects moves.
+	CopyLevel int
+
+	// The minimum number of alphanumeric characters in a block of lines
+	// which was copied. The 0 value is 40, as in git.
+	CopyScore int
+
+	// If set, Incremental is called with each group of lines as soon
+	// as the commit which they're attributed to is found, as with
+	// "git blame --incremental". The groups for each commit are passed
+	// in the order of their lines, and if it returns an error, Blame
+	// stops and returns it.
+	Incremental func(BlameGroup) error
+}
+
+// A BlameLine is a line of a file, with the commit that it's attributed
+// to.
+type BlameLine struct {
+	Commit CommitID
+
+	// The path of the file in Commit.
+	Path IndexPath
+
+	// The 1-based line number of the line in Commit's version of the
+	// file, and in the file which was blamed.
+	OrigLine, FinalLine int
+
+	// The content of the line, without its trailing newline.
+	Line string
+}
+
+// A BlameGroup is a run of consecutive lines which are all attributed to
+// the same commit, and were consecutive in that commit's version of the
+// file too.
+type BlameGroup struct {
+	Commit CommitID
+
+	// The path of the file in Commit.
+	Path IndexPath
+
+	// The 1-based line numbers of the first line of the group in
+	// Commit's version of the file and in the file which was blamed,
+	// and the number of lines in the group.
+	OrigLine, FinalLine, NumLines int
+
+	// The commit was a boundary of the blame, so the lines may be
+	// older than it.
+	Boundary bool
+
+	// The first parent of Commit which had the file, and its path
+	// there. Previous is the zero value if no parent had it.
+	Previous     CommitID
+	PreviousPath IndexPath
+}
+
+// A range of lines in the blamed file which are being blamed on a suspect,
+// along with where they start in the suspect's version of the file. Lines
+// are 0-based.
+type blameEntry struct {
+	Final, Orig, Count int
+}
+
+// A version of the blamed file which lines are being blamed on.
+type blameSuspect struct {
+	Commit  CommitID
+	Path    IndexPath
+	Blob    Sha1
+	Date    time.Time
+	Entries []blameEntry
+
+	// The order that the suspect was found in, for commits with the
+	// same date.
+	seq int
+}
+
+type blameSuspectKey struct {
+	Commit CommitID
+	Path   IndexPath
+}
+
+// Blame attributes each line of the file at path to the commit which
+// added it. Starting from opts.Revision, the lines which the file already
+// had in each parent are passed on to the parent, and the lines which are
+// left are attributed to the commit. Commits are visited newest first, so
+// that all of a commit's descendants are done before it.
+func Blame(c *Client, opts BlameOptions, path IndexPath) ([]BlameLine, error) {
+	var rev Commitish = opts.Revision
+	if rev == nil {
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			return nil, err
+		}
+		rev = head
+	}
+	cmt, err := rev.CommitID(c)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := cmt.TreeID(c)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok, err := tree.lookupPath(c, path)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || entry.FileMode == ModeTree || entry.FileMode == ModeGitlink {
+		return nil, fmt.Errorf("no such path %v in %v", path, cmt)
+	}
+	final, err := blobLines(c, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]BlameLine, len(final))
+	for i, line := range final {
+		result[i] = BlameLine{FinalLine: i + 1, Line: line}
+	}
+	if len(final) == 0 {
+		return result, nil
+	}
+
+	suspects := make(map[blameSuspectKey]*blameSuspect)
+	seq := 0
+	addSuspect := func(cmt CommitID, path IndexPath, blob Sha1, entries []blameEntry) error {
+		key := blameSuspectKey{cmt, path}
+		if s, ok := suspects[key]; ok {
+			s.Entries = append(s.Entries, entries...)
+			return nil
+		}
+		date, err := cmt.GetCommitterDate(c)
+		if err != nil {
+			return err
+		}
+		suspects[key] = &blameSuspect{cmt, path, blob, date, entries, seq}
+		seq++
+		return nil
+	}
+	if err := addSuspect(cmt, path, entry.Sha1, []blameEntry{{0, 0, len(final)}}); err != nil {
+		return nil, err
+	}
+
+	for len(suspects) > 0 {
+		var s *blameSuspect
+		for _, candidate := range suspects {
+			// As in git, the versions of the same commit's files are
+			// done from the most recently found one.
+			switch {
+			case s == nil, candidate.Date.After(s.Date):
+				s = candidate
+			case candidate.Commit == s.Commit && candidate.seq > s.seq:
+				s = candidate
+			case candidate.Commit != s.Commit && candidate.Date.Equal(s.Date) && candidate.seq < s.seq:
+				s = candidate
+			}
+		}
+		delete(suspects, blameSuspectKey{s.Commit, s.Path})
+
+		guilty, err := s.passBlame(c, opts, addSuspect)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(guilty.Entries, func(i, j int) bool { return guilty.Entries[i].Final < guilty.Entries[j].Final })
+		for _, e := range guilty.Entries {
+			g := BlameGroup{
+				Commit:       s.Commit,
+				Path:         s.Path,
+				OrigLine:     e.Orig + 1,
+				FinalLine:    e.Final + 1,
+				NumLines:     e.Count,
+				Boundary:     guilty.Root && !opts.ShowRoot,
+				Previous:     guilty.Previous,
+				PreviousPath: guilty.PreviousPath,
+			}
+			for i := 0; i < e.Count; i++ {
+				line := &result[e.Final+i]
+				line.Commit, line.Path, line.OrigLine = s.Commit, s.Path, e.Orig+i+1
+			}
+			if opts.Incremental != nil {
+				if err := opts.Incremental(g); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// The lines which a suspect is guilty of adding.
+type blameGuilty struct {
+	Entries []blameEntry
+
+	// The suspect is a root commit.
+	Root bool
+
+	// The first parent which had the file.
+	Previous     CommitID
+	PreviousPath IndexPath
+}
+
+// Passes the lines of s which were already in its parents on to them
+// with add, trying each parent in turn, and returns the lines which are
+// left. If the file isn't in a parent, it's looked for under the name
+// which it was renamed from. Afterwards, the lines which are left are
+// looked for elsewhere in the parents if opts asks for moves or copies.
+func (s *blameSuspect) passBlame(c *Client, opts BlameOptions, add func(CommitID, IndexPath, Sha1, []blameEntry) error) (blameGuilty, error) {
+	parents, err := s.Commit.Parents(c)
+	if err != nil {
+		return blameGuilty{}, err
+	}
+	guilty := blameGuilty{Entries: s.Entries, Root: len(parents) == 0}
+	if len(parents) == 0 {
+		return guilty, nil
+	}
+	lines, err := blobLines(c, TreeEntry{Sha1: s.Blob})
+	if err != nil {
+		return blameGuilty{}, err
+	}
+
+	// The diffs between each parent and s.Commit, which are only found
+	// if they're needed.
+	diffs := make(map[int][]HashDiff)
+	diffTree := func(i int) ([]HashDiff, error) {
+		if d, ok := diffs[i]; ok {
+			return d, nil
+		}
+		d, err := DiffTree(c, &DiffTreeOptions{Recurse: true, DiffRenameOptions: DiffRenameOptions{FindRenames: defaultSimilarity}}, parents[i], s.Commit, nil)
+		if err != nil {
+			return nil, err
+		}
+		diffs[i] = d
+		return d, nil
+	}
+
+	// The version of the file in each parent, if it had it.
+	type parentFile struct {
+		Path  IndexPath
+		Entry TreeEntry
+	}
+	files := make([]*parentFile, len(parents))
+	for i, p := range parents {
+		tree, err := p.TreeID(c)
+		if err != nil {
+			return blameGuilty{}, err
+		}
+		entry, ok, err := tree.lookupPath(c, s.Path)
+		if err != nil {
+			return blameGuilty{}, err
+		}
+		path := s.Path
+		if !ok {
+			d, err := diffTree(i)
+			if err != nil {
+				return blameGuilty{}, err
+			}
+			for _, diff := range d {
+				if diff.Name == s.Path && diff.SrcName != "" && !diff.Copy {
+					entry, path, ok = diff.Src, diff.SrcName, true
+					break
+				}
+			}
+		}
+		if ok && renameableMode(entry.FileMode) {
+			files[i] = &parentFile{path, entry}
+		}
+	}
+
+	for i, p := range parents {
+		f := files[i]
+		if len(guilty.Entries) == 0 {
+			break
+		}
+		if f == nil {
+			continue
+		}
+		if guilty.Previous == (CommitID{}) {
+			guilty.Previous, guilty.PreviousPath = p, f.Path
+		}
+		if f.Entry.Sha1 == s.Blob {
+			// Nothing changed, so the parent is responsible for
+			// everything.
+			if err := add(p, f.Path, f.Entry.Sha1, guilty.Entries); err != nil {
+				return blameGuilty{}, err
+			}
+			guilty.Entries = nil
+			break
+		}
+		plines, err := blobLines(c, f.Entry)
+		if err != nil {
+			return blameGuilty{}, err
+		}
+		passed, kept := splitBlameEntries(guilty.Entries, diffLines(plines, lines), len(lines))
+		if len(passed) > 0 {
+			if err := add(p, f.Path, f.Entry.Sha1, passed); err != nil {
+				return blameGuilty{}, err
+			}
+		}
+		guilty.Entries = kept
+	}
+
+	moveScore, copyScore := opts.MoveScore, opts.CopyScore
+	if opts.CopyLevel > 0 {
+		if moveScore == 0 {
+			moveScore = DefaultBlameMoveScore
+		}
+		if copyScore == 0 {
+			copyScore = DefaultBlameCopyScore
+		}
+	}
+	for i, p := range parents {
+		if len(guilty.Entries) == 0 || (moveScore == 0 && opts.CopyLevel == 0) {
+			break
+		}
+		// The files which lines may have been moved or copied from,
+		// starting with the file itself.
+		var sources []parentFile
+		moves := files[i] != nil && moveScore > 0
+		if moves {
+			sources = append(sources, *files[i])
+		}
+		if opts.CopyLevel > 0 {
+			d, err := diffTree(i)
+			if err != nil {
+				return blameGuilty{}, err
+			}
+			if opts.CopyLevel >= 3 || (opts.CopyLevel == 2 && files[i] == nil) {
+				// Every file in the parent is a candidate.
+				tree, err := p.TreeID(c)
+				if err != nil {
+					return blameGuilty{}, err
+				}
+				err = WalkTree(c, tree, func(path string, entry TreeEntry) error {
+					if renameableMode(entry.FileMode) && (files[i] == nil || IndexPath(path) != files[i].Path) {
+						sources = append(sources, parentFile{IndexPath(path), entry})
+					}
+					return nil
+				})
+				if err != nil {
+					return blameGuilty{}, err
+				}
+			} else {
+				// Only the files which the commit modified.
+				for _, diff := range d {
+					if diff.Name == s.Path || diff.SrcName != "" || !renameableMode(diff.Src.FileMode) || !renameableMode(diff.Dst.FileMode) {
+						continue
+					}
+					sources = append(sources, parentFile{diff.Name, diff.Src})
+				}
+			}
+		}
+		for j, src := range sources {
+			if len(guilty.Entries) == 0 {
+				break
+			}
+			score := copyScore
+			if j == 0 && moves {
+				score = moveScore
+			}
+			slines, err := blobLines(c, src.Entry)
+			if err != nil {
+				return blameGuilty{}, err
+			}
+			passed, kept := findBlameBlocks(guilty.Entries, lines, slines, score)
+			if len(passed) > 0 {
+				if err := add(p, src.Path, src.Entry.Sha1, passed); err != nil {
+					return blameGuilty{}, err
+				}
+			}
+			guilty.Entries = kept
+		}
+	}
+	return guilty, nil
+}
+
+// The default minimum number of alphanumeric characters which a block
+// of lines needs to be detected as moved or copied, as in git.
+const (
+	DefaultBlameMoveScore = 20
+	DefaultBlameCopyScore = 40
+)
+
+// Returns the number of alphanumeric characters in lines, which is how
+// git decides whether a block of lines is significant enough to have been
+// moved or copied rather than written again.
+func blameScore(lines []string) int {
+	score := 0
+	for _, line := range lines {
+		for i := 0; i < len(line); i++ {
+			if b := line[i]; b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' {
+				score++
+			}
+		}
+	}
+	return score
+}
+
+// Finds blocks of the lines in entries which appear as consecutive lines
+// anywhere in src, regardless of their order, and splits them into the
+// blocks which have a score of at least minScore, with their line
+// numbers changed to src's, and the rest. lines is the version of the
+// file that entries refer to.
+func findBlameBlocks(entries []blameEntry, lines, src []string, minScore int) (passed, kept []blameEntry) {
+	positions := make(map[string][]int)
+	for i, line := range src {
+		positions[line] = append(positions[line], i)
+	}
+	for _, e := range entries {
+		keep := func(start, end int) {
+			// Adjacent lines which are kept are joined back together.
+			if n := len(kept); n > 0 && kept[n-1].Final+kept[n-1].Count == e.Final+start && kept[n-1].Orig+kept[n-1].Count == e.Orig+start {
+				kept[n-1].Count += end - start
+				return
+			}
+			kept = append(kept, blameEntry{e.Final + start, e.Orig + start, end - start})
+		}
+		for i := 0; i < e.Count; {
+			// The longest run of lines starting here which are also
+			// in src.
+			best, bestLen := -1, 0
+			for _, pos := range positions[lines[e.Orig+i]] {
+				n := 1
+				for i+n < e.Count && pos+n < len(src) && lines[e.Orig+i+n] == src[pos+n] {
+					n++
+				}
+				if n > bestLen {
+					best, bestLen = pos, n
+				}
+			}
+			if best < 0 || blameScore(lines[e.Orig+i:e.Orig+i+bestLen]) < minScore {
+				keep(i, i+1)
+				i++
+				continue
+			}
+			passed = append(passed, blameEntry{e.Final + i, best, bestLen})
+			i += bestLen
+		}
+	}
+	return passed, kept
+}
+
+// Splits entries, which are in a version of a file with n lines, into
+// the parts which pairs match with lines in a parent's version, with
+// their line numbers changed to the parent's, and the parts which aren't
+// matched.
+func splitBlameEntries(entries []blameEntry, pairs []linePair, n int) (passed, kept []blameEntry) {
+	// For each line, the line that it matches in the parent, or -1.
+	match := make([]int, n)
+	for i := range match {
+		match[i] = -1
+	}
+	for _, p := range pairs {
+		match[p.B] = p.A
+	}
+	for _, e := range entries {
+		start := 0
+		for start < e.Count {
+			line := e.Orig + start
+			end := start + 1
+			if match[line] < 0 {
+				for end < e.Count && match[e.Orig+end] < 0 {
+					end++
+				}
+				kept = append(kept, blameEntry{e.Final + start, line, end - start})
+			} else {
+				for end < e.Count && match[e.Orig+end] == match[line]+end-start {
+					end++
+				}
+				passed = append(passed, blameEntry{e.Final + start, match[line], end - start})
+			}
+			start = end
+		}
+	}
+	return passed, kept
+}
+
+// Returns the name, email, time and timezone of an author or committer
+// header of a commit.
+func parseIdent(header string) (name, email, when, tz string) {
+	pieces := strings.Split(header, " ")
+	if len(pieces) < 3 {
+		return header, "", "0", "+0000"
+	}
+	person := strings.Join(pieces[:len(pieces)-2], " ")
+	if lt := strings.LastIndex(person, " <"); lt >= 0 {
+		name, email = person[:lt], person[lt+1:]
+	} else {
+		name = person
+	}
+	return name, email, pieces[len(pieces)-2], pieces[len(pieces)-1]
+}
+
+// Writes the details of cmt in the format of "git blame --porcelain".
+func writeBlameCommitInfo(c *Client, w io.Writer, cmt CommitID) error {
+	obj, err := c.GetCommitObject(cmt)
+	if err != nil {
+		return err
+	}
+	msg, err := cmt.GetCommitMessage(c)
+	if err != nil {
+		return err
+	}
+	for _, role := range []string{"author", "committer"} {
+		name, email, when, tz := parseIdent(obj.GetHeader(role))
+		fmt.Fprintf(w, "%v %v\n%v-mail %v\n%v-time %v\n%v-tz %v\n", role, name, role, email, role, when, role, tz)
+	}
+	_, err = fmt.Fprintf(w, "summary %v\n", msg.Subject())
+	return err
+}
+
+// BlameIncrementalWriter returns a function to use as the Incremental
+// option to Blame, which writes each group to w in the format of
+// "git blame --incremental". The details of each commit are written with
+// the first group which is attributed to it.
+func BlameIncrementalWriter(c *Client, w io.Writer) func(BlameGroup) error {
+	shown := make(map[CommitID]bool)
+	return func(g BlameGroup) error {
+		fmt.Fprintf(w, "%v %d %d %d\n", g.Commit, g.OrigLine, g.FinalLine, g.NumLines)
+		if !shown[g.Commit] {
+			shown[g.Commit] = true
+			if err := writeBlameCommitInfo(c, w, g.Commit); err != nil {
+				return err
+			}
+			if g.Boundary {
+				fmt.Fprintln(w, "boundary")
+			}
+		}
+		if g.Previous != (CommitID{}) {
+			fmt.Fprintf(w, "previous %v %v\n", g.Previous, quotePath(g.PreviousPath.String()))
+		}
+		_, err := fmt.Fprintf(w, "filename %v\n", quotePath(g.Path.String()))
+		return err
+	}
+}
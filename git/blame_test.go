@@ -0,0 +1,138 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// Tests that the groups passed to the Incremental option of Blame cover
+// the same lines as the batch result, and that they're written in the
+// format of git blame --incremental.
+func TestBlameIncremental(t *testing.T) {
+	c, cleanup := testRepo(t, "gitblameincremental")
+	defer cleanup()
+
+	c1 := testCommitFile(t, c, "f.txt", "1\n2\n3\n4\n", "first")
+	c2 := testCommitFile(t, c, "f.txt", "1\nx\n3\n4\ny\n", "second")
+	c3 := testCommitFile(t, c, "f.txt", "0\n1\nx\n3\n4\ny\n", "third")
+
+	var groups []BlameGroup
+	lines, err := Blame(c, BlameOptions{Incremental: func(g BlameGroup) error {
+		groups = append(groups, g)
+		return nil
+	}}, "f.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CommitID{c3, c1, c2, c1, c1, c2}
+	if len(lines) != len(want) {
+		t.Fatalf("Unexpected number of lines: got %v want %v", len(lines), len(want))
+	}
+	for i, l := range lines {
+		if l.Commit != want[i] || l.FinalLine != i+1 {
+			t.Errorf("Line %d: got %v (line %d) want %v", i+1, l.Commit, l.FinalLine, want[i])
+		}
+	}
+
+	// The newest commits are found first, and the groups cover every
+	// line once.
+	wantGroups := []BlameGroup{
+		{Commit: c3, Path: "f.txt", OrigLine: 1, FinalLine: 1, NumLines: 1, Previous: c2, PreviousPath: "f.txt"},
+		{Commit: c2, Path: "f.txt", OrigLine: 2, FinalLine: 3, NumLines: 1, Previous: c1, PreviousPath: "f.txt"},
+		{Commit: c2, Path: "f.txt", OrigLine: 5, FinalLine: 6, NumLines: 1, Previous: c1, PreviousPath: "f.txt"},
+		{Commit: c1, Path: "f.txt", OrigLine: 1, FinalLine: 2, NumLines: 1, Boundary: true},
+		{Commit: c1, Path: "f.txt", OrigLine: 3, FinalLine: 4, NumLines: 2, Boundary: true},
+	}
+	if len(groups) != len(wantGroups) {
+		t.Fatalf("Unexpected groups: got %+v want %+v", groups, wantGroups)
+	}
+	seen := make(map[int]bool)
+	for i, g := range groups {
+		if g != wantGroups[i] {
+			t.Errorf("Group %d: got %+v want %+v", i, g, wantGroups[i])
+		}
+		for j := 0; j < g.NumLines; j++ {
+			l := lines[g.FinalLine-1+j]
+			if seen[l.FinalLine] || l.Commit != g.Commit || l.OrigLine != g.OrigLine+j {
+				t.Errorf("Group %d does not match line %d of the batch result: %+v", i, l.FinalLine, l)
+			}
+			seen[l.FinalLine] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := Blame(c, BlameOptions{Incremental: BlameIncrementalWriter(c, &buf)}, "f.txt"); err != nil {
+		t.Fatal(err)
+	}
+	out := strings.Split(buf.String(), "\n")
+	if want := c3.String() + " 1 1 1"; out[0] != want {
+		t.Errorf("Unexpected first line: got %q want %q", out[0], want)
+	}
+	if got := strings.Count(buf.String(), "\nsummary "); got != 3 {
+		t.Errorf("Commit details were written %d times, not once per commit", got)
+	}
+	if !strings.Contains(buf.String(), "summary first\nboundary\nfilename f.txt\n") {
+		t.Errorf("The root commit was not written as a boundary:\n%v", buf.String())
+	}
+}
+
+// Tests that with CopyLevel, lines which were moved from another file in
+// the same commit are blamed on the commit that wrote them, and that
+// blame follows a file across a rename.
+func TestBlameCopies(t *testing.T) {
+	c, cleanup := testRepo(t, "gitblamecopies")
+	defer cleanup()
+
+	block := "first moved line of the block\nsecond moved line of the block\nthird moved line\n"
+	testCommitFile(t, c, "b.txt", "b\n", "add b")
+	c1 := testCommitFile(t, c, "a.txt", "a\n"+block+"end of a\n", "add a")
+	if err := ioutil.WriteFile("a.txt", []byte("a\nend of a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	c2 := testCommitFile(t, c, "b.txt", "b\n"+block, "move the block")
+
+	lines, err := Blame(c, BlameOptions{}, "b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("Unexpected number of lines: got %v want 4", len(lines))
+	}
+	for _, l := range lines[1:] {
+		if l.Commit != c2 {
+			t.Errorf("Line %d was not blamed on the commit that moved it without -C: %+v", l.FinalLine, l)
+		}
+	}
+
+	lines, err = Blame(c, BlameOptions{CopyLevel: 1}, "b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range lines[1:] {
+		if l.Commit != c1 || l.Path != "a.txt" || l.OrigLine != i+2 {
+			t.Errorf("Line %d was not blamed on line %d of a.txt in %v: %+v", l.FinalLine, i+2, c1, l)
+		}
+	}
+
+	if _, err := Mv(c, MvOptions{}, []File{"a.txt"}, "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	c3, err := Commit(c, CommitOptions{}, "rename a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err = Blame(c, BlameOptions{}, "c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range lines {
+		if l.Commit == c3 || l.Path != "a.txt" {
+			t.Errorf("Line %d was not followed across the rename: %+v", l.FinalLine, l)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A blobReader reads the content of a blob, closing the files and
+// decompressors that it's reading from when it's closed.
+type blobReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *blobReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if cerr := r.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// BlobReader returns a reader for the content of the blob sha, and the
+// size of the content in bytes. The content is inflated as it's read
+// rather than being read into memory first, so that it can be used for
+// blobs which are too large to load.
+//
+// Blobs which are stored as deltas in a pack are reconstructed in memory,
+// since a delta can copy from anywhere in its base. git doesn't
+// deltify anything larger than core.bigFileThreshold, so the large blobs
+// that need to be streamed are stored whole.
+func (c *Client) BlobReader(sha Sha1) (io.ReadCloser, int64, error) {
+	sha, err := c.replacementObject(sha)
+	if err != nil {
+		return nil, 0, err
+	}
+	if obj, ok := c.cachedObject(shaRef{sha, false}); ok {
+		return bufferedBlobReader(sha, obj)
+	}
+	found, packfile, err := c.HaveObject(sha)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !found {
+		return nil, 0, fmt.Errorf("Object not found.")
+	}
+	if packfile != "" {
+		return c.packedBlobReader(sha)
+	}
+	return c.looseBlobReader(sha)
+}
+
+// Returns a reader for the content of obj, which has already been read.
+func bufferedBlobReader(sha Sha1, obj GitObject) (io.ReadCloser, int64, error) {
+	if obj.GetType() != "blob" {
+		return nil, 0, fmt.Errorf("%v is not a blob", sha)
+	}
+	content := obj.GetContent()
+	return ioutil.NopCloser(bytes.NewReader(content)), int64(len(content)), nil
+}
+
+// Returns a reader for the content of the loose blob sha.
+func (c *Client) looseBlobReader(sha Sha1) (io.ReadCloser, int64, error) {
+	f, err := os.Open(c.looseObjectFile(sha).String())
+	if err != nil {
+		return nil, 0, err
+	}
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	r := &blobReader{closers: []io.Closer{f, zr}}
+	buf := bufio.NewReader(zr)
+	header, err := buf.ReadString(0)
+	if err != nil {
+		r.Close()
+		return nil, 0, err
+	}
+	pieces := strings.Fields(strings.TrimSuffix(header, "\x00"))
+	if len(pieces) != 2 {
+		r.Close()
+		return nil, 0, InvalidObject
+	}
+	if pieces[0] != "blob" {
+		r.Close()
+		return nil, 0, fmt.Errorf("%v is not a blob", sha)
+	}
+	size, err := strconv.ParseInt(pieces[1], 10, 64)
+	if err != nil {
+		r.Close()
+		return nil, 0, fmt.Errorf("Invalid size: %v", err)
+	}
+	r.Reader = io.LimitReader(buf, size)
+	return r, size, nil
+}
+
+// Returns a reader for the content of the packed blob sha.
+func (c *Client) packedBlobReader(sha Sha1) (io.ReadCloser, int64, error) {
+	loc, err := c.packedObjectLocation(sha)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open((loc.packfile + ".pack").String())
+	if err != nil {
+		return nil, 0, err
+	}
+	var p PackfileHeader
+	t, sz, _, _, rawheader, err := p.ReadHeaderSize(io.NewSectionReader(f, loc.offset, 4096))
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	switch t {
+	case OBJ_BLOB:
+	case OBJ_OFS_DELTA, OBJ_REF_DELTA:
+		f.Close()
+		obj, err := c.readObject(sha, false)
+		if err != nil {
+			return nil, 0, err
+		}
+		return bufferedBlobReader(sha, obj)
+	default:
+		f.Close()
+		return nil, 0, fmt.Errorf("%v is not a blob", sha)
+	}
+	data := io.NewSectionReader(f, loc.offset+int64(len(rawheader)), math.MaxInt64-loc.offset-int64(len(rawheader)))
+	zr, err := zlib.NewReader(data)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return &blobReader{io.LimitReader(zr, int64(sz)), []io.Closer{f, zr}}, int64(sz), nil
+}
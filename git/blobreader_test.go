@@ -0,0 +1,147 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Checks that the content streamed by BlobReader for sha in c is the same
+// as the content read by GetObject.
+func testBlobReader(t *testing.T, c *Client, sha Sha1) {
+	t.Helper()
+	obj, err := c.GetObject(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Use a new client so that the object isn't in the object cache.
+	c2, err := NewClient(c.GitDir.String(), c.WorkDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, size, err := c2.BlobReader(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	streamed, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(obj.GetSize()) {
+		t.Errorf("Blob %v: size %v want %v", sha, size, obj.GetSize())
+	}
+	if !bytes.Equal(streamed, obj.GetContent()) {
+		t.Errorf("Blob %v: streamed %d bytes which don't match the %d read", sha, len(streamed), len(obj.GetContent()))
+	}
+}
+
+func TestBlobReader(t *testing.T) {
+	c, cleanup := testRepo(t, "gitblobreader")
+	defer cleanup()
+
+	var content bytes.Buffer
+	for i := 0; content.Len() < 1<<20; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	testCommitFile(t, c, "big.txt", content.String(), "Big file")
+	cmt := testCommitFile(t, c, "empty.txt", "", "Empty file")
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range idx.Objects {
+		testBlobReader(t, c, entry.Sha1)
+	}
+	if _, _, err := c.BlobReader(Sha1(cmt)); err == nil {
+		t.Error("BlobReader did not return an error for a commit")
+	}
+
+	// The same blobs, read from a pack.
+	objs, err := cmt.GetAllObjectsExcept(c, make(map[Sha1]struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pack bytes.Buffer
+	if err := SendPackfile(c, &pack, append(objs, Sha1(cmt))); err != nil {
+		t.Fatal(err)
+	}
+	gitdir, err := ioutil.TempDir("", "gitblobreaderpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitdir)
+	packed, err := Init(nil, InitOptions{Quiet: true, Bare: true}, gitdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IndexAndCopyPack(packed, IndexPackOptions{}, bytes.NewReader(pack.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if loose, _ := filepath.Glob(filepath.Join(gitdir, "objects", "??")); len(loose) != 0 {
+		t.Fatalf("Unexpected loose objects in %v", loose)
+	}
+	for _, entry := range idx.Objects {
+		testBlobReader(t, packed, entry.Sha1)
+	}
+}
+
+func TestBlobReaderDelta(t *testing.T) {
+	// The same pack as TestVerifyPack, 3 blobs in an OFS_DELTA chain with
+	// a length of 2.
+	data := []byte{0x50, 0x41, 0x43, 0x4b, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0xbc, 0x08, 0x78, 0x9c,
+		0x73, 0xe4, 0x72, 0xc4, 0x09, 0x9d, 0xb8, 0x9c, 0xb9, 0x5c, 0xb8, 0x5c, 0xe9, 0x46, 0x03, 0x00,
+		0xcc, 0xc9, 0x15, 0x0f, 0x65, 0x18, 0x78, 0x9c, 0xeb, 0x61, 0x2c, 0x9a, 0x50, 0x04, 0x00, 0x05,
+		0xad, 0x02, 0x02, 0x65, 0x0f, 0x78, 0x9c, 0x2b, 0x4a, 0x9a, 0x28, 0x90, 0x04, 0x00, 0x05, 0xfc,
+		0x01, 0xd8, 0x75, 0xcc, 0x90, 0x92, 0xc3, 0xd9, 0x93, 0xba, 0xcf, 0xe4, 0x1d, 0x7c, 0xed, 0x5d,
+		0x8f, 0x46, 0xdf, 0xc2, 0x19, 0x0f,
+	}
+	gitdir, err := ioutil.TempDir("", "gitblobreaderdelta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitdir)
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, gitdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IndexAndCopyPack(c, IndexPackOptions{}, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	idxs, err := filepath.Glob(c.GitDir.File("objects/pack/*.idx").String())
+	if err != nil || len(idxs) != 1 {
+		t.Fatalf("Unexpected index files: %v (%v)", idxs, err)
+	}
+	f, err := os.Open(idxs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pidx, err := readPackIndexV2(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pidx.Sha1Table) != 3 {
+		t.Fatalf("Unexpected objects in pack: %v", pidx.Sha1Table)
+	}
+	for _, sha := range pidx.Sha1Table {
+		testBlobReader(t, c, sha)
+	}
+
+	// A pack which is truncated before the header of an object is an
+	// error, not a panic.
+	packfile := idxs[0][:len(idxs[0])-len(".idx")] + ".pack"
+	if err := os.Truncate(packfile, 12); err != nil {
+		t.Fatal(err)
+	}
+	c, err = NewClient(c.GitDir.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.BlobReader(pidx.Sha1Table[0]); err == nil {
+		t.Error("BlobReader did not return an error for a truncated pack")
+	}
+}
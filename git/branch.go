@@ -1,8 +1,8 @@
 package git
 
 import (
-	"fmt"
-	"io"
+	"sort"
+	"strings"
 )
 
 type BranchOptions struct {
@@ -13,28 +13,198 @@ type BranchOptions struct {
 	Force  bool
 }
 
-func BranchList(c *Client, stdout io.Writer, opts BranchOptions, patterns []string) ([]Branch, error) {
-	branches, err := c.GetBranches()
+// BranchListOptions are the options for ListBranches.
+type BranchListOptions struct {
+	// Include remote branches along with the local ones.
+	All bool
+
+	// Only include remote branches.
+	Remotes bool
+
+	// If set, only include branches whose tips are reachable from
+	// Merged, or not reachable from NoMerged.
+	Merged, NoMerged Commitish
+
+	// If set, only include branches which contain Contains.
+	Contains Commitish
+}
+
+// A BranchInfo describes a branch listed by ListBranches.
+type BranchInfo struct {
+	Branch Branch
+
+	// True if the branch is checked out.
+	Head bool
+
+	// True if this is a detached HEAD instead of a branch, in which
+	// case Branch is empty.
+	Detached bool
+
+	// If the branch is a symbolic ref, such as refs/remotes/origin/HEAD,
+	// the branch that it points to.
+	Target Branch
+
+	// The commit at the tip of the branch, and its subject line.
+	Commit  CommitID
+	Subject string
+
+	// The upstream branch, or the empty string if the branch doesn't
+	// have one. If the upstream branch doesn't exist, UpstreamGone is
+	// set. Otherwise, Ahead and Behind are the number of commits in the
+	// branch that aren't in the upstream, and in the upstream that
+	// aren't in the branch.
+	Upstream      Branch
+	UpstreamGone  bool
+	Ahead, Behind int
+}
+
+// Upstream returns the branch that b tracks from its branch.<name>.remote
+// and branch.<name>.merge configuration, or the empty string if it doesn't
+// track anything. The upstream branch may not exist.
+func (b Branch) Upstream(c *Client) Branch {
+	if !strings.HasPrefix(string(b), "refs/heads/") {
+		return ""
+	}
+	name := b.BranchName()
+	remote := c.GetConfig("branch." + name + ".remote")
+	merge := c.GetConfig("branch." + name + ".merge")
+	if remote == "" || merge == "" {
+		return ""
+	}
+	if remote == "." {
+		return Branch(merge)
+	}
+	// The upstream is where the remote's fetch refspec stores the
+	// merge ref.
+	fetch := RefSpec(c.GetConfig("remote." + remote + ".fetch"))
+	if strings.HasSuffix(string(fetch.Src()), "/*") != strings.HasSuffix(string(fetch.Dst()), "/*") {
+		return ""
+	}
+	if ok, dst := (Ref{Name: merge}).MatchesRefSpecSrc(fetch); ok {
+		return Branch(dst)
+	}
+	return ""
+}
+
+// AheadBehind returns the number of commits reachable from local which
+// aren't reachable from upstream, and the number reachable from upstream
+// which aren't reachable from local.
+func AheadBehind(c *Client, local, upstream Commitish) (ahead, behind int, err error) {
+	l, err := local.CommitID(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	u, err := upstream.CommitID(c)
 	if err != nil {
-		return nil, err
+		return 0, 0, err
+	}
+	lancestors, err := l.AncestorMap(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	uancestors, err := u.AncestorMap(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	for cmt := range lancestors {
+		if _, ok := uancestors[cmt]; !ok {
+			ahead++
+		}
 	}
-	if opts.All {
-		rb, err := c.GetRemoteBranches()
+	for cmt := range uancestors {
+		if _, ok := lancestors[cmt]; !ok {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// ListBranches returns the branches in c along with their tracking
+// information, sorted by name, as shown by "git branch -vv".
+func ListBranches(c *Client, opts BranchListOptions) ([]BranchInfo, error) {
+	var branches []Branch
+	if !opts.Remotes {
+		local, err := c.GetBranches()
 		if err != nil {
 			return nil, err
 		}
-		branches = append(branches, rb...)
+		branches = append(branches, local...)
 	}
-	if !opts.Quiet {
-		head := c.GetHeadBranch()
-		for _, b := range branches {
-			if head == b {
-				fmt.Println(" *", b.BranchName())
-			} else {
-				fmt.Println("  ", b.BranchName())
+	if opts.All || opts.Remotes {
+		if c.GitDir.File("refs/remotes").Exists() {
+			remote, err := c.GetRemoteBranches()
+			if err != nil {
+				return nil, err
 			}
+			branches = append(branches, remote...)
+		}
+	}
+	sort.Slice(branches, func(i, j int) bool { return branches[i] < branches[j] })
+
+	var merged, nomerged, contains CommitID
+	var err error
+	for _, filter := range []struct {
+		c   Commitish
+		cmt *CommitID
+	}{{opts.Merged, &merged}, {opts.NoMerged, &nomerged}, {opts.Contains, &contains}} {
+		if filter.c == nil {
+			continue
+		}
+		if *filter.cmt, err = filter.c.CommitID(c); err != nil {
+			return nil, err
+		}
+	}
+
+	// A detached HEAD isn't any of the branches, so it's listed before
+	// them when local branches are.
+	head, err := c.HeadBranch()
+	detached, isdetached := err.(DetachedHeadError)
+	candidates := make([]BranchInfo, 0, len(branches)+1)
+	if isdetached && !opts.Remotes {
+		candidates = append(candidates, BranchInfo{Head: true, Detached: true, Commit: detached.Commit})
+	}
+	for _, b := range branches {
+		candidates = append(candidates, BranchInfo{Branch: b, Head: b == head})
+	}
 
+	var infos []BranchInfo
+	for _, info := range candidates {
+		b := info.Branch
+		if !info.Detached {
+			tip := b
+			if target, err := SymbolicRefGet(c, SymbolicRefOptions{}, SymbolicRef(b)); err == nil {
+				info.Target = Branch(target)
+				tip = info.Target
+			}
+			if info.Commit, err = tip.CommitID(c); err != nil {
+				return nil, err
+			}
+		}
+
+		if opts.Merged != nil && !info.Commit.IsAncestor(c, merged) {
+			continue
+		}
+		if opts.NoMerged != nil && info.Commit.IsAncestor(c, nomerged) {
+			continue
+		}
+		if opts.Contains != nil && !contains.IsAncestor(c, info.Commit) {
+			continue
+		}
+
+		msg, err := info.Commit.GetCommitMessage(c)
+		if err != nil {
+			return nil, err
+		}
+		info.Subject = msg.Subject()
+
+		if info.Upstream = b.Upstream(c); info.Upstream != "" {
+			if !info.Upstream.Exists(c) {
+				info.UpstreamGone = true
+			} else if info.Ahead, info.Behind, err = AheadBehind(c, info.Commit, info.Upstream); err != nil {
+				return nil, err
+			}
 		}
+		infos = append(infos, info)
 	}
-	return branches, nil
+	return infos, nil
 }
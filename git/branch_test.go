@@ -0,0 +1,111 @@
+package git
+
+import (
+	"testing"
+)
+
+func TestListBranches(t *testing.T) {
+	c, cleanup := testRepo(t, "gitlistbranches")
+	defer cleanup()
+
+	initial := testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	if err := c.CreateBranch("topic", initial); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBranch("old", initial); err != nil {
+		t.Fatal(err)
+	}
+	master := testCommitFile(t, c, "foo.txt", "bar\n", "Master commit\nwith a long subject\n\nAnd a body")
+	if _, err := Checkout(c, CheckoutOptions{}, "topic", nil); err != nil {
+		t.Fatal(err)
+	}
+	topic := testCommitFile(t, c, "bar.txt", "bar\n", "Topic commit")
+
+	c.SetCachedConfig("branch.topic.remote", ".")
+	c.SetCachedConfig("branch.topic.merge", "refs/heads/master")
+	c.SetCachedConfig("branch.old.remote", ".")
+	c.SetCachedConfig("branch.old.merge", "refs/heads/deleted")
+
+	branches, err := ListBranches(c, BranchListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BranchInfo{
+		{Branch: "refs/heads/master", Commit: master, Subject: "Master commit with a long subject"},
+		{Branch: "refs/heads/old", Commit: initial, Subject: "Initial commit", Upstream: "refs/heads/deleted", UpstreamGone: true},
+		{Branch: "refs/heads/topic", Head: true, Commit: topic, Subject: "Topic commit", Upstream: "refs/heads/master", Ahead: 1, Behind: 1},
+	}
+	if len(branches) != len(want) {
+		t.Fatalf("Unexpected branches: got %+v want %+v", branches, want)
+	}
+	for i := range want {
+		if branches[i] != want[i] {
+			t.Errorf("Unexpected branch %d: got %+v want %+v", i, branches[i], want[i])
+		}
+	}
+
+	tests := []struct {
+		opts BranchListOptions
+		want []Branch
+	}{
+		{BranchListOptions{Merged: master}, []Branch{"refs/heads/master", "refs/heads/old"}},
+		{BranchListOptions{NoMerged: master}, []Branch{"refs/heads/topic"}},
+		{BranchListOptions{Contains: initial}, []Branch{"refs/heads/master", "refs/heads/old", "refs/heads/topic"}},
+		{BranchListOptions{Contains: topic}, []Branch{"refs/heads/topic"}},
+	}
+	for i, tc := range tests {
+		branches, err := ListBranches(c, tc.opts)
+		if err != nil {
+			t.Errorf("Case %d: %v", i, err)
+			continue
+		}
+		var got []Branch
+		for _, b := range branches {
+			got = append(got, b.Branch)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("Case %d: got %v want %v", i, got, tc.want)
+			continue
+		}
+		for j := range got {
+			if got[j] != tc.want[j] {
+				t.Errorf("Case %d: got %v want %v", i, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+// Tests that a detached HEAD is listed before the branches, unless only the
+// remote branches are listed.
+func TestListBranchesDetached(t *testing.T) {
+	c, cleanup := testRepo(t, "gitlistbranchesdetached")
+	defer cleanup()
+
+	initial := testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	if err := c.GitDir.WriteFile("HEAD", []byte(initial.String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	branches, err := ListBranches(c, BranchListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BranchInfo{
+		{Head: true, Detached: true, Commit: initial, Subject: "Initial commit"},
+		{Branch: "refs/heads/master", Commit: initial, Subject: "Initial commit"},
+	}
+	if len(branches) != len(want) {
+		t.Fatalf("Unexpected branches: got %+v want %+v", branches, want)
+	}
+	for i := range want {
+		if branches[i] != want[i] {
+			t.Errorf("Unexpected branch %d: got %+v want %+v", i, branches[i], want[i])
+		}
+	}
+
+	if branches, err := ListBranches(c, BranchListOptions{Remotes: true}); err != nil {
+		t.Fatal(err)
+	} else if len(branches) != 0 {
+		t.Errorf("Detached HEAD listed as a remote branch: %+v", branches)
+	}
+}
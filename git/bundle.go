@@ -0,0 +1,277 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const bundleSignature = "# v2 git bundle\n"
+
+// BundleOptions are the options which may be passed to the bundle
+// family of commands.
+type BundleOptions struct {
+	// Display progress information while indexing the pack of an
+	// unbundled bundle.
+	Progress bool
+}
+
+// A BundlePrerequisite is a commit which must exist in a repository in
+// order for a bundle to be unbundled into it, because the bundle's pack
+// was created assuming the receiver already has it.
+type BundlePrerequisite struct {
+	Commit CommitID
+	// The subject line of the commit, for informational purposes.
+	Comment string
+}
+
+// A BundleHeader is the list of references and prerequisites at the start
+// of a bundle.
+type BundleHeader struct {
+	Prerequisites []BundlePrerequisite
+	Refs          []Ref
+}
+
+// Returns the full name of the reference that arg refers to, for writing
+// into a bundle, or false if arg isn't a reference.
+func bundleRefName(c *Client, arg string) (string, bool) {
+	if arg == "HEAD" {
+		return arg, true
+	}
+	for _, prefix := range []string{"", "refs/", "refs/heads/", "refs/tags/", "refs/remotes/"} {
+		name := prefix + arg
+		if !strings.HasPrefix(name, "refs/") {
+			continue
+		}
+		if c.GitDir.File(File(name)).Exists() {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// BundleCreate writes a bundle of the commits and objects described by revs
+// to out, in the same way as "git bundle create".
+//
+// revs are revisions to include, or exclude if they are prefixed by "^" or
+// the left side of a A..B range. Any revisions which are references are
+// recorded in the bundle. Commits which are excluded but are parents of
+// included commits are recorded as prerequisites of the bundle.
+func BundleCreate(c *Client, opts BundleOptions, out io.Writer, revs []string) error {
+	var args []string
+	for _, rev := range revs {
+		if pieces := strings.SplitN(rev, "..", 2); len(pieces) == 2 {
+			if pieces[0] == "" {
+				pieces[0] = "HEAD"
+			}
+			if pieces[1] == "" {
+				pieces[1] = "HEAD"
+			}
+			args = append(args, "^"+pieces[0], pieces[1])
+			continue
+		}
+		args = append(args, rev)
+	}
+
+	var header BundleHeader
+	var includes, excludes []Commitish
+	var tags []Sha1
+	// The commit that each reference in header.Refs points to.
+	var refCommits []Sha1
+	for _, arg := range args {
+		parsed, err := RevParse(c, RevParseOptions{}, []string{arg})
+		if err != nil {
+			return err
+		}
+		if len(parsed) != 1 {
+			return fmt.Errorf("Could not parse revision %v", arg)
+		}
+		rev := parsed[0]
+		if rev.Excluded {
+			excludes = append(excludes, rev)
+			continue
+		}
+		cid := CommitID(rev.Id)
+		if rev.Id.Type(c) == "tag" {
+			tags = append(tags, rev.Id)
+			cmt, err := RevParseCommitish(c, &RevParseOptions{}, arg)
+			if err != nil {
+				return err
+			}
+			if cid, err = cmt.CommitID(c); err != nil {
+				return err
+			}
+		}
+		includes = append(includes, cid)
+		if name, ok := bundleRefName(c, arg); ok {
+			header.Refs = append(header.Refs, Ref{name, rev.Id})
+			refCommits = append(refCommits, Sha1(cid))
+		}
+	}
+
+	cmts, err := RevList(c, RevListOptions{Quiet: true}, ioutil.Discard, includes, excludes)
+	if err != nil {
+		return err
+	}
+	included := make(map[Sha1]struct{}, len(cmts))
+	for _, cmt := range cmts {
+		included[cmt] = struct{}{}
+	}
+
+	// References which are excluded by the other revisions aren't
+	// included, since the bundle wouldn't contain their objects.
+	refs := header.Refs[:0]
+	for i, ref := range header.Refs {
+		if _, ok := included[refCommits[i]]; ok {
+			refs = append(refs, ref)
+		}
+	}
+	header.Refs = refs
+	if len(header.Refs) == 0 {
+		return fmt.Errorf("Refusing to create empty bundle.")
+	}
+
+	seen := make(map[CommitID]struct{})
+	for _, cmt := range cmts {
+		parents, err := CommitID(cmt).Parents(c)
+		if err != nil {
+			return err
+		}
+		for _, p := range parents {
+			if _, ok := included[Sha1(p)]; ok {
+				continue
+			}
+			if _, ok := seen[p]; ok {
+				continue
+			}
+			seen[p] = struct{}{}
+			msg, err := p.GetCommitMessage(c)
+			if err != nil {
+				return err
+			}
+			subject := strings.SplitN(msg.String(), "\n", 2)[0]
+			header.Prerequisites = append(header.Prerequisites, BundlePrerequisite{p, subject})
+		}
+	}
+	sort.Slice(header.Prerequisites, func(i, j int) bool {
+		return header.Prerequisites[i].Commit.String() < header.Prerequisites[j].Commit.String()
+	})
+
+	objects, err := RevList(c, RevListOptions{Quiet: true, Objects: true}, ioutil.Discard, includes, excludes)
+	if err != nil {
+		return err
+	}
+	objects = append(objects, tags...)
+
+	if _, err := fmt.Fprint(out, bundleSignature); err != nil {
+		return err
+	}
+	for _, p := range header.Prerequisites {
+		if _, err := fmt.Fprintf(out, "-%v %v\n", p.Commit, p.Comment); err != nil {
+			return err
+		}
+	}
+	for _, ref := range header.Refs {
+		if _, err := fmt.Fprintf(out, "%v %v\n", ref.Value, ref.Name); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprint(out, "\n"); err != nil {
+		return err
+	}
+	return SendPackfile(c, out, objects)
+}
+
+// Reads the header of a bundle from r, leaving r positioned at the start
+// of the bundle's pack.
+func readBundleHeader(r *bufio.Reader) (BundleHeader, error) {
+	var header BundleHeader
+	sig, err := r.ReadString('\n')
+	if err != nil {
+		return header, err
+	}
+	if sig != bundleSignature {
+		return header, fmt.Errorf("Not a v2 bundle file")
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return header, fmt.Errorf("Invalid bundle header: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return header, nil
+		}
+		if line[0] == '-' {
+			pieces := strings.SplitN(line[1:], " ", 2)
+			cmt, err := CommitIDFromString(pieces[0])
+			if err != nil {
+				return header, fmt.Errorf("Invalid bundle prerequisite: %v", line)
+			}
+			prereq := BundlePrerequisite{Commit: cmt}
+			if len(pieces) == 2 {
+				prereq.Comment = pieces[1]
+			}
+			header.Prerequisites = append(header.Prerequisites, prereq)
+			continue
+		}
+		pieces := strings.SplitN(line, " ", 2)
+		if len(pieces) != 2 {
+			return header, fmt.Errorf("Invalid bundle reference: %v", line)
+		}
+		sha, err := Sha1FromString(pieces[0])
+		if err != nil {
+			return header, fmt.Errorf("Invalid bundle reference: %v", line)
+		}
+		header.Refs = append(header.Refs, Ref{pieces[1], sha})
+	}
+}
+
+// Checks that every prerequisite of the bundle exists in c.
+func (h BundleHeader) verify(c *Client) error {
+	var missing bytes.Buffer
+	for _, p := range h.Prerequisites {
+		if have, _, err := c.HaveObject(Sha1(p.Commit)); err != nil {
+			return err
+		} else if !have {
+			fmt.Fprintf(&missing, "%v %v\n", p.Commit, p.Comment)
+		}
+	}
+	if missing.Len() > 0 {
+		return fmt.Errorf("Repository lacks these prerequisite commits:\n%s", missing.String())
+	}
+	return nil
+}
+
+// BundleVerify reads the header of the bundle from r and checks that the
+// commits that it requires exist in the repository. It returns the header
+// if the bundle can be unbundled.
+func BundleVerify(c *Client, opts BundleOptions, r io.Reader) (BundleHeader, error) {
+	header, err := readBundleHeader(bufio.NewReader(r))
+	if err != nil {
+		return header, err
+	}
+	return header, header.verify(c)
+}
+
+// BundleUnbundle verifies the bundle read from r, and then stores the
+// objects from its pack in the repository. It returns the references
+// recorded in the bundle, but doesn't update any.
+func BundleUnbundle(c *Client, opts BundleOptions, r io.Reader) ([]Ref, error) {
+	buf := bufio.NewReader(r)
+	header, err := readBundleHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := header.verify(c); err != nil {
+		return nil, err
+	}
+	if _, err := IndexAndCopyPack(c, IndexPackOptions{Verbose: opts.Progress}, buf); err != nil {
+		return nil, err
+	}
+	return header.Refs, nil
+}
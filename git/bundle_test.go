@@ -0,0 +1,75 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Tests that a bundle can be created and unbundled into an empty
+// repository, and that an incremental bundle requires its prerequisites.
+func TestBundleRoundTrip(t *testing.T) {
+	c, cleanup := testRepo(t, "gitbundle")
+	defer cleanup()
+
+	first := testCommitFile(t, c, "foo.txt", "foo\n", "first")
+	head := testCommitFile(t, c, "foo.txt", "bar\n", "second")
+
+	var full bytes.Buffer
+	if err := BundleCreate(c, BundleOptions{}, &full, []string{"master"}); err != nil {
+		t.Fatal(err)
+	}
+	var incremental bytes.Buffer
+	if err := BundleCreate(c, BundleOptions{}, &incremental, []string{"master^..master"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := BundleCreate(c, BundleOptions{}, ioutil.Discard, []string{"master..master"}); err == nil {
+		t.Error("Expected error creating a bundle without any references")
+	}
+
+	dir, err := ioutil.TempDir("", "gitbundledst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := BundleVerify(dst, BundleOptions{}, bytes.NewReader(incremental.Bytes()))
+	if err == nil {
+		t.Error("Expected incremental bundle to fail verification in an empty repository")
+	}
+	if len(header.Prerequisites) != 1 || header.Prerequisites[0].Commit != first || header.Prerequisites[0].Comment != "first" {
+		t.Errorf("Unexpected prerequisites: %v", header.Prerequisites)
+	}
+
+	refs, err := BundleUnbundle(dst, BundleOptions{}, &full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Name != "refs/heads/master" || refs[0].Value != Sha1(head) {
+		t.Errorf("Unexpected refs in bundle: got %v want refs/heads/master %v", refs, head)
+	}
+	blob, err := RevParsePath(c, &RevParseOptions{}, "HEAD:foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _, err := dst.HaveObject(blob); err != nil || !have {
+		t.Errorf("Blob %v was not unbundled: %v", blob, err)
+	}
+	msg, err := head.GetCommitMessage(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(msg.String()); got != "second" {
+		t.Errorf("Unexpected commit message: got %q want %q", got, "second")
+	}
+
+	if _, err := BundleVerify(dst, BundleOptions{}, bytes.NewReader(incremental.Bytes())); err != nil {
+		t.Errorf("Incremental bundle failed verification after unbundling its prerequisites: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A cacheTree is a node of the cache-tree ("TREE") index extension, which
+// records the tree objects that directories in the index were last written
+// as, so that they don't need to be written again if nothing in them has
+// changed.
+type cacheTree struct {
+	// The name of the directory relative to its parent, or the empty
+	// string for the root of the index.
+	name string
+
+	// The number of index entries under the directory, or -1 if the
+	// node is invalid and the tree needs to be written again.
+	entryCount int
+
+	subtrees []*cacheTree
+
+	// The tree that the directory was written as, if the node is valid.
+	// Only the root of a tree written in a repository using SHA-1 is
+	// saved in the index, since the extension has SHA-1 IDs.
+	tree ObjectID
+}
+
+// Returns true if t can be used instead of writing the tree.
+func (t *cacheTree) valid() bool {
+	return t != nil && t.entryCount >= 0
+}
+
+// Returns the subtree of t named name, or nil.
+func (t *cacheTree) subtree(name string) *cacheTree {
+	if t == nil {
+		return nil
+	}
+	for _, sub := range t.subtrees {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// Returns the node for the directory dir, which is relative to t, or nil
+// if there isn't one.
+func (t *cacheTree) lookup(dir string) *cacheTree {
+	if dir == "" {
+		return t
+	}
+	for _, name := range strings.Split(dir, "/") {
+		t = t.subtree(name)
+		if t == nil {
+			return nil
+		}
+	}
+	return t
+}
+
+// Invalidates the nodes for every directory above path, so that they're
+// written again by the next WriteTree.
+func (t *cacheTree) invalidate(path IndexPath) {
+	if t == nil {
+		return
+	}
+	t.entryCount = -1
+	names := strings.Split(path.String(), "/")
+	for _, name := range names[:len(names)-1] {
+		t = t.subtree(name)
+		if t == nil {
+			return
+		}
+		t.entryCount = -1
+	}
+}
+
+// Invalidates the cache-tree nodes affected by a change to path in g.
+func (g *Index) invalidateCacheTree(path IndexPath) {
+	g.cacheTree.invalidate(path)
+}
+
+// Parses the content of a TREE index extension. The nodes are stored in
+// pre-order, each as its NUL terminated name, the ASCII entry count and
+// number of subtrees separated by a space and terminated by a newline,
+// followed by the tree if the entry count isn't negative.
+func parseCacheTree(data []byte) (*cacheTree, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	var readNode func() (*cacheTree, error)
+	readNode = func() (*cacheTree, error) {
+		name, err := r.ReadString(0)
+		if err != nil {
+			return nil, err
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid cache-tree node: %q", line)
+		}
+		count, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		nsub, err := strconv.Atoi(fields[1])
+		if err != nil || nsub < 0 {
+			return nil, fmt.Errorf("Invalid cache-tree subtree count: %q", fields[1])
+		}
+		node := &cacheTree{name: strings.TrimSuffix(name, "\x00"), entryCount: count}
+		if count >= 0 {
+			var tree Sha1
+			if _, err := io.ReadFull(r, tree[:]); err != nil {
+				return nil, err
+			}
+			node.tree = tree.ObjectID()
+		}
+		for i := 0; i < nsub; i++ {
+			sub, err := readNode()
+			if err != nil {
+				return nil, err
+			}
+			node.subtrees = append(node.subtrees, sub)
+		}
+		return node, nil
+	}
+	root, err := readNode()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("Extra data after cache-tree")
+	}
+	return root, nil
+}
+
+// Writes t in the format of the TREE index extension.
+func (t *cacheTree) writeTo(w *bytes.Buffer) {
+	fmt.Fprintf(w, "%s\x00%d %d\n", t.name, t.entryCount, len(t.subtrees))
+	if t.entryCount >= 0 {
+		w.Write(t.tree.Bytes())
+	}
+	for _, sub := range t.subtrees {
+		sub.writeTo(w)
+	}
+}
+
+// Writes the tree object for entries, which must be sorted and all have the
+// path prefix dir (which is either empty for the root of the index or ends
+// in a slash). The subtrees which have a valid node under cache with the
+// same number of entries are reused instead of being written again.
+//
+// The trees are named with the hash algorithm of the repository, which
+// every entry's object must be named with too. The cache-tree node for the
+// tree that was written is returned, with its name unset.
+func writeCacheTree(c *Client, cache *cacheTree, dir string, entries []*IndexEntry) (*cacheTree, error) {
+	// A sparse directory entry for dir is a leaf, which already has the
+	// tree, the same as in git.
+	if len(entries) > 0 && entries[0].IsSparseDir() && entries[0].PathName.String() == dir {
+		return &cacheTree{entryCount: 1, tree: entries[0].ObjectID()}, nil
+	}
+	h := c.HashAlgorithm()
+	content := bytes.NewBuffer(nil)
+	node := &cacheTree{entryCount: len(entries)}
+	for i := 0; i < len(entries); {
+		obj := entries[i]
+		if obj.Stage() != Stage0 {
+			return nil, fmt.Errorf("Could not write index with unmerged entries")
+		}
+		name := strings.TrimPrefix(obj.PathName.String(), dir)
+		slash := strings.IndexByte(name, '/')
+		if slash < 0 {
+			id := obj.ObjectID()
+			if id.Algorithm != h {
+				return nil, fmt.Errorf("Can not write %v object %v for '%v' in a %v tree", id.Algorithm, id, obj.PathName, h)
+			}
+			appendTreeEntry(content, obj.Mode, name, id)
+			i++
+			continue
+		}
+
+		// Everything in the subdirectory is contiguous, since the
+		// entries are sorted.
+		subdir := name[:slash]
+		prefix := dir + subdir + "/"
+		j := i + 1
+		for j < len(entries) && strings.HasPrefix(entries[j].PathName.String(), prefix) {
+			j++
+		}
+		sub := cache.subtree(subdir)
+		if !sub.valid() || sub.entryCount != j-i {
+			var err error
+			sub, err = writeCacheTree(c, sub, prefix, entries[i:j])
+			if err != nil {
+				return nil, err
+			}
+			sub.name = subdir
+		}
+		node.subtrees = append(node.subtrees, sub)
+		appendTreeEntry(content, ModeTree, subdir, sub.tree)
+		i = j
+	}
+	id, err := c.WriteObjectID("tree", content.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	node.tree = id
+	return node, nil
+}
+
+// Appends the entry for name, with the mode and the object id, to content,
+// which is the content of a tree object. An entry is the mode in octal and
+// the name, separated by a space and terminated by a NUL, followed by the
+// ID in binary, with the size of its hash algorithm.
+func appendTreeEntry(content *bytes.Buffer, mode EntryMode, name string, id ObjectID) {
+	fmt.Fprintf(content, "%o %s\x00", mode, name)
+	content.Write(id.Bytes())
+}
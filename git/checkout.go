@@ -48,6 +48,10 @@ type CheckoutOptions struct {
 
 	// Not implemented
 	IgnoreOtherWorktrees bool
+
+	// Only work out what would change, without touching the working
+	// tree, index or HEAD.
+	DryRun bool
 }
 
 // Implements the "git checkout" subcommand of git. Variations in the man-page
@@ -65,52 +69,61 @@ type CheckoutOptions struct {
 //
 // "thing" is the thing that the user entered on the command line to be checked out. It
 // might be a branch, a commit, or a treeish, depending on the variation above.
-func Checkout(c *Client, opts CheckoutOptions, thing string, files []File) error {
+//
+// The files which were changed are returned, or the ones which would have
+// been if opts.DryRun is set. A patch checkout doesn't return any.
+func Checkout(c *Client, opts CheckoutOptions, thing string, files []File) ([]PlannedChange, error) {
 	if thing == "" {
 		thing = "HEAD"
+	} else if thing == "-" {
+		// "-" is the branch that was checked out before this one.
+		thing = "@{-1}"
 	}
 
 	if opts.Patch {
 		diffs, err := DiffFiles(c, DiffFilesOptions{}, files)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		var patchbuf bytes.Buffer
 		if err := GeneratePatch(c, DiffCommonOptions{Patch: true}, diffs, &patchbuf); err != nil {
-			return err
+			return nil, err
 		}
 		hunks, err := splitPatch(patchbuf.String(), false)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		hunks, err = filterHunks("discard this hunk from the work tree", hunks)
 		if err == userAborted {
-			return nil
+			return nil, nil
 		} else if err != nil {
-			return err
+			return nil, err
 		}
 
 		patch, err := ioutil.TempFile("", "checkoutpatch")
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer os.Remove(patch.Name())
 		recombinePatch(patch, hunks)
 
-		return Apply(c, ApplyOptions{Reverse: true}, []File{File(patch.Name())})
+		if opts.DryRun {
+			return nil, nil
+		}
+		return nil, Apply(c, ApplyOptions{Reverse: true}, []File{File(patch.Name())})
 	}
 
 	if len(files) == 0 {
 		cmt, err := RevParseCommitish(c, &RevParseOptions{}, thing)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		return CheckoutCommit(c, opts, cmt)
 	}
 
 	b, err := RevParseTreeish(c, &RevParseOptions{}, thing)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	return CheckoutFiles(c, opts, b, files)
 }
@@ -120,7 +133,7 @@ func Checkout(c *Client, opts CheckoutOptions, thing string, files []File) error
 //     git checkout [-q] [-f] [-m] --detach [<branch>]
 //     git checkout [-q] [-f] [-m] [--detach] <commit>
 //     git checkout [-q] [-f] [-m] [[-b|-B|--orphan] <new_branch>] [<start_point>]
-func CheckoutCommit(c *Client, opts CheckoutOptions, commit Commitish) error {
+func CheckoutCommit(c *Client, opts CheckoutOptions, commit Commitish) ([]PlannedChange, error) {
 	// RefSpec for new branch with -b/-B variety
 	var newRefspec RefSpec
 	if opts.Branch != "" {
@@ -130,7 +143,7 @@ func CheckoutCommit(c *Client, opts CheckoutOptions, commit Commitish) error {
 		newRefspec = RefSpec("refs/heads/" + opts.Branch)
 		refspecfile := newRefspec.File(c)
 		if refspecfile.Exists() && !opts.ForceBranch {
-			return fmt.Errorf("fatal: A branch named '%v' already exists.", opts.Branch)
+			return nil, fmt.Errorf("fatal: A branch named '%v' already exists.", opts.Branch)
 		}
 	}
 	// Get the original HEAD for the reflog
@@ -140,36 +153,36 @@ func CheckoutCommit(c *Client, opts CheckoutOptions, commit Commitish) error {
 	case DetachedHead:
 		head, err = c.GetHeadCommit()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	case nil:
 	default:
-		return err
+		return nil, err
 	}
 
 	// Convert from Commitish to Treeish for ReadTree and LsTree
 	cid, err := commit.CommitID(c)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if !opts.Force {
 		// Check that nothing would be lost
 		lstree, err := LsTree(c, LsTreeOptions{Recurse: true}, cid, nil)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		newfiles := make([]File, 0, len(lstree))
 		for _, entry := range lstree {
 			f, err := entry.PathName.FilePath(c)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			newfiles = append(newfiles, f)
 		}
 		untracked, err := LsFiles(c, LsFilesOptions{Others: true}, newfiles)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if len(untracked) > 0 {
 			err := "error: The following untracked working tree files would be overwritten by checkout:\n"
@@ -177,7 +190,7 @@ func CheckoutCommit(c *Client, opts CheckoutOptions, commit Commitish) error {
 				err += "\t" + f.IndexEntry.PathName.String() + "\n"
 			}
 			err += "Please move or remove them before you switch branches.\nAborting"
-			return fmt.Errorf("%v", err)
+			return nil, fmt.Errorf("%v", err)
 		}
 	}
 
@@ -185,9 +198,36 @@ func CheckoutCommit(c *Client, opts CheckoutOptions, commit Commitish) error {
 	// to a commit.
 	hc, err := head.CommitID(c)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// Unless it's forced, only the files which differ between HEAD and
+	// the new commit are touched. If it is, the changes in the work tree
+	// are thrown away too.
+	var changes []PlannedChange
+	if opts.Force {
+		changes, err = plannedResetChanges(c, cid)
+	} else {
+		var diffs []HashDiff
+		if diffs, err = DiffTree(c, &DiffTreeOptions{Recurse: true}, cid, hc, nil); err == nil {
+			changes, err = plannedTreeChanges(c, diffs, false)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		return changes, nil
+	}
+
+	origidx, lock, err := c.GitDir.LockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Rollback()
+	staged, err := DiffIndex(c, DiffIndexOptions{}, origidx, hc, nil)
+	if err != nil {
+		return nil, err
 	}
-	staged, err := DiffIndex(c, DiffIndexOptions{}, nil, hc, nil)
 	// Now actually read the tree into the index
 	readtreeopts := ReadTreeOptions{Update: true, Merge: true}
 	if opts.Force {
@@ -197,9 +237,9 @@ func CheckoutCommit(c *Client, opts CheckoutOptions, commit Commitish) error {
 	if opts.IgnoreSkipWorktreeBits {
 		readtreeopts.NoSparseCheckout = true
 	}
-	idx, err := ReadTree(c, readtreeopts, cid)
+	idx, err := readTree(c, readtreeopts, origidx, cid)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Put back changes that were staged before doing read-tree -u
@@ -208,29 +248,23 @@ func CheckoutCommit(c *Client, opts CheckoutOptions, commit Commitish) error {
 			continue
 		}
 		if err := idx.AddStage(c, diff.Name, diff.Dst.FileMode, diff.Dst.Sha1, Stage0, uint32(diff.DstSize), 0, UpdateIndexOptions{}); err != nil {
-			return err
+			return nil, err
 		}
 		content, err := CatFile(c, "blob", diff.Dst.Sha1, CatFileOptions{})
 		if err != nil {
-			return err
+			return nil, err
 		}
 		f, err := diff.Name.FilePath(c)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if err := ioutil.WriteFile(f.String(), []byte(content), os.FileMode(diff.Dst.FileMode)); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	f, err := c.GitDir.Create("index")
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	if err := idx.WriteIndex(f); err != nil {
-		return err
+	if err := readtreeSaveIndex(c, readtreeopts, idx, lock); err != nil {
+		return nil, err
 	}
 
 	var origB string
@@ -248,48 +282,49 @@ func CheckoutCommit(c *Client, opts CheckoutOptions, commit Commitish) error {
 	if opts.Branch != "" {
 		// In the case of -B (ForceBranch) this will slam in the new branch based on the provided commit ID
 		if err := c.CreateBranch(opts.Branch, cid); err != nil {
-			return err
+			return nil, err
 		}
 		refmsg := fmt.Sprintf("checkout: moving from %s to %s (dgit)", origB, opts.Branch)
-		return SymbolicRefUpdate(c, SymbolicRefOptions{}, "HEAD", RefSpec("refs/heads/"+opts.Branch), refmsg)
+		return changes, SymbolicRefUpdate(c, SymbolicRefOptions{}, "HEAD", RefSpec("refs/heads/"+opts.Branch), refmsg)
 	}
 	if b, ok := commit.(Branch); ok && !opts.Detach {
 		// We're checking out a branch, first read the new tree, and
 		// then update the SymbolicRef for HEAD, if that succeeds.
 		refmsg := fmt.Sprintf("checkout: moving from %s to %s (dgit)", origB, b.BranchName())
-		return SymbolicRefUpdate(c, SymbolicRefOptions{}, "HEAD", RefSpec(b), refmsg)
+		return changes, SymbolicRefUpdate(c, SymbolicRefOptions{}, "HEAD", RefSpec(b), refmsg)
 	}
 	refmsg := fmt.Sprintf("checkout: moving from %s to %s (dgit)", origB, cid)
 	if err := UpdateRef(c, UpdateRefOptions{NoDeref: true, OldValue: head}, "HEAD", cid, refmsg); err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return changes, nil
 }
 
 // Implements "git checkout" subcommand of git for variations:
 //     git checkout [-f|--ours|--theirs|-m|--conflict=<style>] [<tree-ish>] [--] <paths>...
 //     git checkout [-p|--patch] [<tree-ish>] [--] [<paths>...]
-func CheckoutFiles(c *Client, opts CheckoutOptions, tree Treeish, files []File) error {
+func CheckoutFiles(c *Client, opts CheckoutOptions, tree Treeish, files []File) ([]PlannedChange, error) {
 	// If files were specified, we don't want ReadTree to update the workdir,
 	// because we only want to (force) update the specified files.
 	//
 	// If they weren't, we want to checkout a treeish, so let ReadTree update
 	// the workdir so that we don't lose any changes.
 	// Load the index so that we can check the skip worktree bit if applicable
-	index, err := c.GitDir.ReadIndex()
+	index, lock, err := c.GitDir.LockIndex()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer lock.Rollback()
 	imap := index.GetMap()
 	expandedfiles, err := LsTree(c, LsTreeOptions{Recurse: true}, tree, files)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	files = make([]File, 0, len(files))
 	for _, entry := range expandedfiles {
 		f, err := entry.PathName.FilePath(c)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if opts.IgnoreSkipWorktreeBits {
 			files = append(files, f)
@@ -303,10 +338,23 @@ func CheckoutFiles(c *Client, opts CheckoutOptions, tree Treeish, files []File)
 
 	// We just want to load the tree as an index so that CheckoutIndexUncommited, so we
 	// specify DryRun.
-	treeidx, err := ReadTree(c, ReadTreeOptions{DryRun: true}, tree)
+	treeidx, err := readTree(c, ReadTreeOptions{DryRun: true}, index, tree)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return CheckoutIndexUncommited(c, treeidx, CheckoutIndexOptions{Force: true, UpdateStat: true}, files)
+	changes := make([]PlannedChange, 0, len(files))
+	for _, f := range files {
+		change := PlannedChange{Action: ChangeUpdate, Path: f}
+		if ip, err := f.IndexPath(c); err == nil {
+			if _, ok := imap[ip]; !ok {
+				change.Action = ChangeAdd
+			}
+		}
+		changes = append(changes, change)
+	}
+	if opts.DryRun {
+		return changes, nil
+	}
+	return changes, CheckoutIndexUncommited(c, treeidx, CheckoutIndexOptions{Force: true, UpdateStat: true, indexLock: lock}, files)
 }
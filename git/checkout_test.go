@@ -3,6 +3,7 @@ package git
 import (
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -38,7 +39,7 @@ func TestCheckoutBranch(t *testing.T) {
 	if err := c.CreateBranch("notmaster", initialCmt); err != nil {
 		t.Fatal(err)
 	}
-	if err := Checkout(c, CheckoutOptions{}, "notmaster", nil); err != nil {
+	if _, err := Checkout(c, CheckoutOptions{}, "notmaster", nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -82,7 +83,7 @@ func TestCheckoutBranch(t *testing.T) {
 	}
 
 	// Go back to the master branch with a clean working tree
-	if err := Checkout(c, CheckoutOptions{}, "master", nil); err != nil {
+	if _, err := Checkout(c, CheckoutOptions{}, "master", nil); err != nil {
 		t.Fatal(err)
 	}
 	head, err = SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD")
@@ -105,7 +106,7 @@ func TestCheckoutBranch(t *testing.T) {
 	if err := ioutil.WriteFile(dir+"/foo.txt", []byte("baz\n"), 0644); err != nil {
 		t.Fatal(err)
 	}
-	if err := Checkout(c, CheckoutOptions{}, "notmaster", nil); err == nil {
+	if _, err := Checkout(c, CheckoutOptions{}, "notmaster", nil); err == nil {
 		t.Error("Expected failed checkout due to unmerged changes, got no error")
 	}
 	foo, err = ioutil.ReadFile("foo.txt")
@@ -115,7 +116,7 @@ func TestCheckoutBranch(t *testing.T) {
 	if string(foo) != "baz\n" {
 		t.Errorf("File was modified by unfinished checkout.")
 	}
-	if err := Checkout(c, CheckoutOptions{Force: true}, "notmaster", nil); err != nil {
+	if _, err := Checkout(c, CheckoutOptions{Force: true}, "notmaster", nil); err != nil {
 		t.Errorf("Could not force checkout: %v", err)
 	}
 	foo, err = ioutil.ReadFile("foo.txt")
@@ -125,7 +126,7 @@ func TestCheckoutBranch(t *testing.T) {
 	if string(foo) != "bar\n" {
 		t.Errorf("File was not modified in forced checkout. Got %v want %v", string(foo), "bar")
 	}
-	if err := Checkout(c, CheckoutOptions{Detach: true}, "master", nil); err != nil {
+	if _, err := Checkout(c, CheckoutOptions{Detach: true}, "master", nil); err != nil {
 		t.Errorf("Error detaching checkout: %v", err)
 	}
 	foo, err = ioutil.ReadFile("foo.txt")
@@ -147,7 +148,7 @@ func TestCheckoutBranch(t *testing.T) {
 
 	// Since we're in a detached head state, test that we can get back
 	// to a normal state by checkouting master
-	if err := Checkout(c, CheckoutOptions{}, "master", nil); err != nil {
+	if _, err := Checkout(c, CheckoutOptions{}, "master", nil); err != nil {
 		t.Fatal(err)
 	}
 	head, err = SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD")
@@ -158,3 +159,38 @@ func TestCheckoutBranch(t *testing.T) {
 		t.Errorf("Checkout branch variation did not change head from detached head mode. Got: %v", head)
 	}
 }
+
+// Tests that a forced checkout's dry run lists the files in the work tree
+// which would be overwritten or thrown away, and doesn't change them.
+func TestCheckoutForceDryRun(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcheckoutdryrun")
+	defer cleanup()
+	initial := testCommitFile(t, c, "a.txt", "a\n", "Add a")
+	next := testCommitFile(t, c, "b.txt", "b\n", "Add b")
+	if _, err := CheckoutCommit(c, CheckoutOptions{}, initial); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("a.txt", []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("b.txt", []byte("untracked\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := CheckoutCommit(c, CheckoutOptions{Force: true, DryRun: true}, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PlannedChange{
+		{Action: ChangeUpdate, Path: "a.txt"},
+		{Action: ChangeAdd, Path: "b.txt"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Unexpected changes: got %v want %v", changes, want)
+	}
+	for name, want := range map[string]string{"a.txt": "changed\n", "b.txt": "untracked\n"} {
+		if content, err := ioutil.ReadFile(name); err != nil || string(content) != want {
+			t.Errorf("Dry run changed %v: %q %v", name, content, err)
+		}
+	}
+}
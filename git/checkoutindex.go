@@ -33,6 +33,10 @@ type CheckoutIndexOptions struct {
 	// nil implies it wasn't passed.
 	Stdin         io.Reader // nil implies no --stdin param passed
 	NullTerminate bool
+
+	// The lock on the index held by the caller, which the index is
+	// committed to with UpdateStat instead of locking it again.
+	indexLock *LockFile
 }
 
 // Performs CheckoutIndex on the Stdin io.Reader from opts, with the git index
@@ -52,23 +56,49 @@ func CheckoutIndexFromReaderUncommited(c *Client, idx *Index, opts CheckoutIndex
 	for s, err := reader.ReadString(delim); err == nil; s, err = reader.ReadString(delim) {
 		f = File(strings.TrimSuffix(s, string(delim)))
 
-		e := CheckoutIndexUncommited(c, idx, opts, []File{f})
+		e := checkoutIndexFiles(c, idx, opts, []File{f})
 		if e != nil {
 			fmt.Fprintln(os.Stderr, e)
 		}
 	}
-	return nil
+	return saveCheckoutIndex(c, idx, opts)
 }
 
 // Performs a CheckoutIndex on the files read from opts.Stdin
 func CheckoutIndexFromReader(c *Client, opts CheckoutIndexOptions) error {
-	idx, err := c.GitDir.ReadIndex()
+	idx, lock, err := checkoutIndexLock(c, opts)
 	if err != nil {
 		return err
 	}
+	defer lock.Rollback()
+	opts.indexLock = lock
 	return CheckoutIndexFromReaderUncommited(c, idx, opts)
 }
 
+// Reads the index to check out files from. As in git, the index is locked
+// before it's read if the stat information is going to be updated, and
+// the lock is nil otherwise.
+func checkoutIndexLock(c *Client, opts CheckoutIndexOptions) (*Index, *LockFile, error) {
+	if !opts.UpdateStat {
+		idx, err := c.GitDir.ReadIndex()
+		return idx, nil, err
+	}
+	return c.GitDir.LockIndex()
+}
+
+// Writes idx, whose files were checked out, to the index if opts.UpdateStat
+// is set, so that their stat information is updated.
+func saveCheckoutIndex(c *Client, idx *Index, opts CheckoutIndexOptions) error {
+	if !opts.UpdateStat {
+		return nil
+	}
+	idx.UseConfiguredVersion(c)
+	if opts.indexLock != nil {
+		return idx.CommitLocked(opts.indexLock)
+	}
+	return c.GitDir.WriteLocked("index", idx.WriteIndex)
+}
+
 // Handles checking out a file when --temp is specified on the command line.
 func checkoutTemp(c *Client, entry *IndexEntry, opts CheckoutIndexOptions) (string, error) {
 	// I don't know where ".merged_file" comes from
@@ -80,12 +110,12 @@ func checkoutTemp(c *Client, entry *IndexEntry, opts CheckoutIndexOptions) (stri
 	}
 	defer tmpfile.Close()
 
-	obj, err := c.GetObject(entry.Sha1)
+	r, _, err := c.BlobReader(entry.Sha1)
 	if err != nil {
 		return "", err
 	}
-	_, err = tmpfile.Write(obj.GetContent())
-	if err != nil {
+	defer r.Close()
+	if _, err := io.Copy(tmpfile, r); err != nil {
 		return "", err
 	}
 
@@ -93,6 +123,79 @@ func checkoutTemp(c *Client, entry *IndexEntry, opts CheckoutIndexOptions) (stri
 	return tmpfile.Name(), nil
 }
 
+// Writes the content of r to the file named name, like ioutil.WriteFile.
+func writeFileFrom(name string, r io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Writes the blob sha for the path p with the given mode to the file f. The
+// content is streamed from the object store, through the smudge filter of p
+// if it has one, so that large blobs don't need to fit in memory.
+//
+// Symlinks are created as symlinks to the (small) target stored in the
+// blob, unless core.symlinks is false, in which case they're checked out
+// as plain files containing the target like git does.
+func checkoutBlob(c *Client, p IndexPath, sha Sha1, mode EntryMode, f File) error {
+	if fi, err := f.Lstat(); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		// Don't write through an existing symlink.
+		if err := os.Remove(f.String()); err != nil {
+			return err
+		}
+	}
+	if mode == ModeSymlink && c.GetConfig("core.symlinks") != "false" {
+		obj, err := c.GetObject(sha)
+		if err != nil {
+			return err
+		}
+		if f.Exists() {
+			if err := os.Remove(f.String()); err != nil {
+				return err
+			}
+		}
+		return os.Symlink(string(obj.GetContent()), f.String())
+	}
+
+	fmode := os.FileMode(0644)
+	if mode == ModeExec {
+		fmode = 0755
+	}
+	smudge, required, err := smudgeFilter(c, p)
+	if err != nil {
+		return err
+	}
+	write := func(smudge string) error {
+		r, _, err := c.BlobReader(sha)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if smudge != "" {
+			return runSmudgeFilter(c, smudge, p, r, f, fmode)
+		}
+		return writeFileFrom(f.String(), r, fmode)
+	}
+	if err := write(smudge); err != nil {
+		if smudge == "" || required {
+			return err
+		}
+		// If the filter isn't required, git checks out the content
+		// without it.
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		if err := write(""); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(f.String(), fmode)
+}
+
 // Checks out a given index entry.
 func checkoutFile(c *Client, entry *IndexEntry, opts CheckoutIndexOptions) error {
 	f, err := entry.PathName.FilePath(c)
@@ -107,12 +210,12 @@ func checkoutFile(c *Client, entry *IndexEntry, opts CheckoutIndexOptions) error
 		return nil
 	}
 
-	obj, err := c.GetObject(entry.Sha1)
-	if err != nil {
+	if ok, _, err := c.HaveObject(entry.Sha1); err != nil {
 		return err
+	} else if !ok {
+		return fmt.Errorf("Object not found.")
 	}
 	if !opts.NoCreate {
-		fmode := os.FileMode(entry.Mode)
 		if f.Exists() && f.IsDir() {
 			if err := os.RemoveAll(f.String()); err != nil {
 				return err
@@ -135,11 +238,9 @@ func checkoutFile(c *Client, entry *IndexEntry, opts CheckoutIndexOptions) error
 				return err
 			}
 		}
-		err := ioutil.WriteFile(f.String(), obj.GetContent(), fmode)
-		if err != nil {
+		if err := checkoutBlob(c, entry.PathName, entry.Sha1, entry.Mode, f); err != nil {
 			return err
 		}
-		os.Chmod(f.String(), os.FileMode(entry.Mode))
 	}
 
 	// Update the stat information, but only if it's the same
@@ -163,6 +264,14 @@ func checkoutFile(c *Client, entry *IndexEntry, opts CheckoutIndexOptions) error
 // (This is primarily for read-tree to be able to update the filesystem with the
 // -u parameter.)
 func CheckoutIndexUncommited(c *Client, idx *Index, opts CheckoutIndexOptions, files []File) error {
+	if err := checkoutIndexFiles(c, idx, opts, files); err != nil {
+		return err
+	}
+	return saveCheckoutIndex(c, idx, opts)
+}
+
+// Checks out files from idx without writing it.
+func checkoutIndexFiles(c *Client, idx *Index, opts CheckoutIndexOptions, files []File) error {
 	if opts.All {
 		files = make([]File, 0, len(idx.Objects))
 		for _, entry := range idx.Objects {
@@ -328,14 +437,6 @@ func CheckoutIndexUncommited(c *Client, idx *Index, opts CheckoutIndexOptions, f
 		}
 	}
 
-	if opts.UpdateStat {
-		f, err := c.GitDir.Create(File("index"))
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		return idx.WriteIndex(f)
-	}
 	return nil
 }
 
@@ -345,10 +446,12 @@ func CheckoutIndex(c *Client, opts CheckoutIndexOptions, files []File) error {
 		return fmt.Errorf("Can not mix --all and named files")
 	}
 
-	idx, err := c.GitDir.ReadIndex()
+	idx, lock, err := checkoutIndexLock(c, opts)
 	if err != nil {
 		return err
 	}
+	defer lock.Rollback()
+	opts.indexLock = lock
 	if opts.Stdin == nil {
 		return CheckoutIndexUncommited(c, idx, opts, files)
 	} else {
@@ -1,6 +1,8 @@
 package git
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -106,3 +108,85 @@ func TestCheckoutIndex(t *testing.T) {
 	}
 
 }
+
+// Tests that a blob larger than the object cache's budget is streamed to
+// the work tree, through its smudge filter, without being read into memory.
+func TestCheckoutIndexLargeFile(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcheckoutindexlarge")
+	defer cleanup()
+
+	const threshold = 1 << 20
+	var content bytes.Buffer
+	for i := 0; content.Len() < 4*threshold; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	testCommitFile(t, c, "big.txt", content.String(), "Big file")
+	testCommitFile(t, c, "big.upper", content.String(), "Filtered big file")
+	if err := ioutil.WriteFile(".gitattributes", []byte("*.upper filter=upper\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"big.txt", "big.upper"} {
+		if err := os.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c2, err := NewClient(c.GitDir.String(), c.WorkDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.SetCachedConfig("core.deltabasecachelimit", fmt.Sprint(threshold))
+	c2.SetCachedConfig("filter.upper.smudge", "tr a-z A-Z")
+	if err := CheckoutIndex(c2, CheckoutIndexOptions{All: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{
+		"big.txt":   content.Bytes(),
+		"big.upper": bytes.ToUpper(content.Bytes()),
+	} {
+		got, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v: got %d bytes which don't match the %d expected", name, len(got), len(want))
+		}
+	}
+	if c2.objcache.size > threshold {
+		t.Errorf("Checkout buffered %d bytes of objects", c2.objcache.size)
+	}
+}
+
+// Tests that symlinks are checked out as symlinks, or as plain files with
+// core.symlinks=false.
+func TestCheckoutIndexSymlink(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcheckoutindexsymlink")
+	defer cleanup()
+
+	if err := os.Symlink("target.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"link"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckoutIndex(c, CheckoutIndexOptions{All: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink("link"); err != nil || target != "target.txt" {
+		t.Errorf("Unexpected symlink: got %q (%v) want %q", target, err, "target.txt")
+	}
+
+	c.SetCachedConfig("core.symlinks", "false")
+	if err := CheckoutIndex(c, CheckoutIndexOptions{All: true, Force: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat("link"); err != nil || !fi.Mode().IsRegular() {
+		t.Fatalf("link was not checked out as a regular file: %v", err)
+	}
+	if content, err := ioutil.ReadFile("link"); err != nil || string(content) != "target.txt" {
+		t.Errorf("Unexpected content of link: got %q (%v) want %q", content, err, "target.txt")
+	}
+}
@@ -0,0 +1,57 @@
+package git
+
+// A CherryEntry is a commit which Cherry found on head, and whether it's
+// already in the upstream.
+type CherryEntry struct {
+	Commit CommitID
+
+	// '-' if a commit with the same patch ID is in the upstream, and
+	// '+' if it isn't.
+	Status byte
+}
+
+// Cherry returns the commits which are in head but not in upstream, oldest
+// first, marking the ones which have an equivalent commit in upstream that
+// isn't in head, the way that "git cherry" does. The commits are compared
+// by their PatchID, so a commit which was cherry-picked or rebased to
+// upstream is found even though it has a different commit ID. Merges
+// aren't included.
+func Cherry(c *Client, upstream, head Commitish) ([]CherryEntry, error) {
+	revs, err := RevList(c, RevListOptions{Quiet: true}, nil, []Commitish{head}, []Commitish{upstream})
+	if err != nil {
+		return nil, err
+	}
+	upstreamRevs, err := RevList(c, RevListOptions{Quiet: true}, nil, []Commitish{upstream}, []Commitish{head})
+	if err != nil {
+		return nil, err
+	}
+	upstreamIDs := make(map[Sha1]struct{})
+	for _, rev := range upstreamRevs {
+		id, err := PatchID(c, CommitID(rev))
+		if err != nil {
+			return nil, err
+		}
+		if id != (Sha1{}) {
+			upstreamIDs[id] = struct{}{}
+		}
+	}
+
+	var entries []CherryEntry
+	for i := len(revs) - 1; i >= 0; i-- {
+		cmt := CommitID(revs[i])
+		id, err := PatchID(c, cmt)
+		if err != nil {
+			return nil, err
+		}
+		if id == (Sha1{}) {
+			// A merge.
+			continue
+		}
+		entry := CherryEntry{Commit: cmt, Status: '+'}
+		if _, ok := upstreamIDs[id]; ok {
+			entry.Status = '-'
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,38 @@
+package git
+
+import (
+	"testing"
+)
+
+// Tests that Cherry marks the commit of a branch which was already applied
+// to the upstream with '-', and the others with '+'.
+func TestCherry(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcherry")
+	defer cleanup()
+
+	base := testCommitFile(t, c, "f.txt", "1\n2\n3\n4\n5\n6\n", "base")
+	a := testCommitFile(t, c, "a.txt", "a\n", "add a")
+	five := testCommitFile(t, c, "f.txt", "1\n2\n3\n4\n5 five\n6\n", "change five")
+	b := testCommitFile(t, c, "b.txt", "b\n", "add b")
+
+	if _, err := ResetMode(c, ResetOptions{Hard: true}, base); err != nil {
+		t.Fatal(err)
+	}
+	testCommitFile(t, c, "f.txt", "0\n1\n2\n3\n4\n5\n6\n", "add zero")
+	// The same change as five, applied to the upstream.
+	upstream := testCommitFile(t, c, "f.txt", "0\n1\n2\n3\n4\n5 five\n6\n", "change five")
+
+	entries, err := Cherry(c, upstream, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CherryEntry{{a, '+'}, {five, '-'}, {b, '+'}}
+	if len(entries) != len(want) {
+		t.Fatalf("Unexpected entries: got %v want %v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("Entry %d: got %v want %v", i, entries[i], want[i])
+		}
+	}
+}
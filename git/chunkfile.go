@@ -0,0 +1,135 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// A chunk of a chunk-based file format, such as the commit-graph or the
+// multi-pack-index.
+type chunk struct {
+	id   string
+	data []byte
+}
+
+// Creates the content of a chunk-based file with the given header,
+// followed by the table of contents of the chunks, the chunks, and a
+// SHA1 checksum of everything that came before it.
+func writeChunkFile(header []byte, chunks []chunk) []byte {
+	var out bytes.Buffer
+	out.Write(header)
+	offset := uint64(len(header) + (len(chunks)+1)*12)
+	for _, ch := range chunks {
+		out.WriteString(ch.id)
+		binary.Write(&out, binary.BigEndian, offset)
+		offset += uint64(len(ch.data))
+	}
+	out.Write([]byte{0, 0, 0, 0})
+	binary.Write(&out, binary.BigEndian, offset)
+	for _, ch := range chunks {
+		out.Write(ch.data)
+	}
+	sum := sha1.Sum(out.Bytes())
+	out.Write(sum[:])
+	return out.Bytes()
+}
+
+// Parses the table of contents of a chunk-based file, which starts after
+// the header of size headerLen, and returns the chunks by their id. The
+// checksum of the file is verified.
+func parseChunkFile(content []byte, headerLen, numChunks int) (map[string][]byte, error) {
+	if len(content) < headerLen+20 {
+		return nil, fmt.Errorf("file is too small")
+	}
+	sum := sha1.Sum(content[:len(content)-20])
+	if !bytes.Equal(sum[:], content[len(content)-20:]) {
+		return nil, fmt.Errorf("incorrect checksum")
+	}
+	if headerLen+(numChunks+1)*12 > len(content)-20 {
+		return nil, fmt.Errorf("chunk lookup table is truncated")
+	}
+	chunks := make(map[string][]byte, numChunks)
+	for i := 0; i < numChunks; i++ {
+		entry := content[headerLen+i*12:]
+		start := binary.BigEndian.Uint64(entry[4:])
+		end := binary.BigEndian.Uint64(entry[16:])
+		if start > end || end > uint64(len(content)-20) {
+			return nil, fmt.Errorf("invalid chunk offset")
+		}
+		chunks[string(entry[:4])] = content[start:end]
+	}
+	return chunks, nil
+}
+
+// Atomically replaces file with a read-only file containing data. The data
+// is written to a temporary file with the given prefix in the same
+// directory, which is then renamed.
+func replaceReadOnlyFile(file File, prefix string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file.String()), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file.String()), prefix)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file.String()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Returns an OID fanout chunk for the sorted ids, where entry n is the
+// number of ids whose first byte is less than or equal to n.
+func writeOIDFanout(ids [][]byte) []byte {
+	var counts [256]uint32
+	for _, id := range ids {
+		counts[id[0]]++
+	}
+	fanout := make([]byte, 256*4)
+	var total uint32
+	for i, n := range counts {
+		total += n
+		binary.BigEndian.PutUint32(fanout[i*4:], total)
+	}
+	return fanout
+}
+
+// Finds the position of id in the sorted OID lookup chunk oids, using
+// the fanout chunk to narrow down the binary search.
+func lookupOID(fanout, oids []byte, id Sha1) (int, bool) {
+	lo := 0
+	if id[0] > 0 {
+		lo = int(binary.BigEndian.Uint32(fanout[(int(id[0])-1)*4:]))
+	}
+	hi := int(binary.BigEndian.Uint32(fanout[int(id[0])*4:]))
+	if lo > hi || hi*20 > len(oids) {
+		return 0, false
+	}
+	pos := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(oids[(lo+i)*20:(lo+i+1)*20], id[:]) >= 0
+	})
+	if pos < hi && bytes.Equal(oids[pos*20:(pos+1)*20], id[:]) {
+		return pos, true
+	}
+	return 0, false
+}
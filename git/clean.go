@@ -21,7 +21,10 @@ type CleanOptions struct {
 	Interactive bool
 }
 
-func Clean(c *Client, opts CleanOptions, files []File) error {
+// Clean removes untracked files from the working tree, and returns the
+// files removed. If opts.DryRun is set, it only returns the files that
+// would have been removed.
+func Clean(c *Client, opts CleanOptions, files []File) ([]PlannedChange, error) {
 	lsopts := LsFilesOptions{
 		Others:          true,
 		Directory:       opts.Directory,
@@ -31,28 +34,33 @@ func Clean(c *Client, opts CleanOptions, files []File) error {
 	}
 	paths, err := LsFiles(c, lsopts, files)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	changes := make([]PlannedChange, 0, len(paths))
 	for _, entry := range paths {
 		f, err := entry.PathName.FilePath(c)
 		if err != nil {
-			return err
-		}
-		if !opts.Quiet {
-			fmt.Printf("Removing %v\n", f)
+			return nil, err
 		}
+		changes = append(changes, PlannedChange{Action: ChangeRemove, Path: f})
 		if opts.DryRun {
+			if !opts.Quiet {
+				fmt.Printf("Would remove %v\n", f)
+			}
 			continue
 		}
+		if !opts.Quiet {
+			fmt.Printf("Removing %v\n", f)
+		}
 		if opts.Directory {
 			if err := os.RemoveAll(f.String()); err != nil {
-				return err
+				return nil, err
 			}
 		} else {
 			if err := os.Remove(f.String()); err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
-	return nil
+	return changes, nil
 }
@@ -0,0 +1,58 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Tests that a DryRun Clean returns the untracked files it would remove
+// without removing them.
+func TestCleanDryRun(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcleandryrun")
+	defer cleanup()
+	testCommitFile(t, c, "foo.txt", "foo\n", "Add foo")
+	if err := ioutil.WriteFile("untracked.txt", []byte("untracked\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("dir/other.txt", []byte("other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := CleanOptions{Directory: true, DryRun: true, Quiet: true}
+	changes, err := Clean(c, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[File]bool{"untracked.txt": true, "dir": true}
+	if len(changes) != len(want) {
+		t.Errorf("Unexpected planned changes: got %v want %v", changes, want)
+	}
+	for _, change := range changes {
+		if change.Action != ChangeRemove || !want[change.Path] {
+			t.Errorf("Unexpected planned change %v", change)
+		}
+	}
+	for _, f := range []File{"untracked.txt", "dir/other.txt", "foo.txt"} {
+		if !f.Exists() {
+			t.Errorf("%v was removed by a dry run", f)
+		}
+	}
+
+	// Without DryRun, the untracked files are removed.
+	opts.DryRun = false
+	if _, err := Clean(c, opts, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []File{"untracked.txt", "dir"} {
+		if f.Exists() {
+			t.Errorf("%v was not removed", f)
+		}
+	}
+	if !File("foo.txt").Exists() {
+		t.Error("Tracked file foo.txt was removed")
+	}
+}
@@ -11,9 +11,9 @@ import (
 	"os/user"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/driusan/dgit/zlib"
 )
 
 var NoGlobalConfig = fmt.Errorf("No global .gitconfig file exists")
@@ -43,6 +43,35 @@ func (f IndexPath) FilePath(c *Client) (File, error) {
 	return File(rel), err
 }
 
+// Returns the IndexPath as a filename relative to the directory prefix of
+// the work tree, rather than the current working directory. prefix is an
+// IndexPath with a trailing slash, such as the one returned by CwdPrefix,
+// and an empty prefix is the root of the work tree.
+func (f IndexPath) RelativeTo(prefix IndexPath) File {
+	rel, err := filepath.Rel("/"+prefix.String(), "/"+f.String())
+	if err != nil {
+		// Both paths are absolute, so this can't happen.
+		return File(f)
+	}
+	return File(rel)
+}
+
+// Returns the path of the current working directory relative to the root
+// of the work tree, with a trailing slash, in order to convert from paths
+// relative to Getwd to IndexPaths. If the working directory is the root of
+// the work tree (or outside of it), the prefix is empty.
+func (c *Client) CwdPrefix() (IndexPath, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(c.WorkDir.String(), cwd)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", nil
+	}
+	return IndexPath(filepath.ToSlash(rel) + "/"), nil
+}
+
 // A GitDir represents the .git/ directory of a repository. It should not
 // have a trailing slash.
 type GitDir File
@@ -86,6 +115,13 @@ type objectLocation struct {
 	packfile File
 	index    *PackfileIndexV2
 	offset   int64
+
+	// The objects directory that a loose object was found in, which
+	// may be an alternate.
+	objectDir File
+
+	// The type of the object, once ObjectType has read it.
+	objectType string
 }
 
 type fileish interface {
@@ -119,11 +155,66 @@ type Client struct {
 	// Cache of where this client has previously found existing objects
 	objectCache map[Sha1]objectLocation
 
-	objcache map[shaRef]GitObject
+	// Guards objectCache and the other caches used to find and read
+	// objects, so that history can be walked concurrently.
+	objectsMu sync.Mutex
+
+	// Cache of the objects that have been read, up to the byte budget
+	// of core.deltaBaseCacheLimit.
+	objcache objectLRU
 
 	// Cache of previous config lookups to avoid re-parsing.
 	configCache               map[string]string
+	configMu                  sync.Mutex
 	localConfig, globalConfig *GitConfig
+
+	// Do not transparently substitute objects which have a replace
+	// ref under refs/replace/ when reading them.
+	NoReplaceObjects bool
+
+	// Cache of replace refs, loaded the first time an object is read.
+	replaceRefs map[Sha1]Sha1
+
+	// Cache of commits whose parents are overridden by .git/shallow
+	// or .git/info/grafts.
+	grafts map[CommitID][]CommitID
+
+	// Cache of the object directories to search for objects, starting
+	// with the repository's own and followed by any alternates.
+	objectDirsCache []File
+
+	// The temporary objects directory that objects are written to, if
+	// this client was returned by Quarantine, and the ones of the
+	// quarantines of this client which haven't been finished yet.
+	quarantine  File
+	quarantines []File
+
+	// Also search the pending quarantines of this client for objects,
+	// so that the objects in them can be validated before they're
+	// committed.
+	ReadQuarantine bool
+
+	// The commit graph, loaded the first time that it's needed.
+	commitGraph       *commitGraph
+	commitGraphLoaded bool
+
+	// The multi-pack-index of each object directory, loaded the first
+	// time that the directory is searched, and the pack indexes read for
+	// objects found through them.
+	multiPackIndexes map[File]*multiPackIndex
+	packIndexes      map[File]*PackfileIndexV2
+
+	// The index, as read by GetIndex.
+	indexCache indexCache
+
+	// Set while a missing object is being fetched from the promisor
+	// remote of a partial clone, so that it isn't done recursively or by
+	// more than one goroutine at a time. Guarded by objectsMu.
+	fetchingPromised bool
+
+	// Cache of the output of textconv diff drivers for blobs. Guarded by
+	// objectsMu.
+	textconvCache map[textconvKey][]byte
 }
 
 func (c *Client) Close() error {
@@ -166,22 +257,31 @@ func (c *Client) IsInsideWorkTree(path File) bool {
 
 }
 
-// Walks from the current directory to find a .git directory
-func findGitDir() GitDir {
+// Walks from the current directory to find a .git directory, or a .git file
+// pointing to the git directory as in a linked worktree or a repository
+// made with --separate-git-dir. The directory that the .git was found in is
+// returned as the work tree.
+func findGitDir() (GitDir, WorkDir, error) {
 	startPath, err := os.Getwd()
 	if err != nil {
-		return ""
-	}
-	if dirinfo, err := os.Stat(startPath + "/.git"); err == nil && dirinfo.IsDir() {
-		return GitDir(startPath) + "/.git"
+		return "", "", nil
 	}
 	pieces := strings.Split(startPath, "/")
 
 	for i := len(pieces); i > 0; i -= 1 {
 		dir := strings.Join(pieces[0:i], "/")
-		if dirinfo, err := os.Stat(dir + "/.git"); err == nil && dirinfo.IsDir() {
-			return GitDir(dir) + "/.git"
+		dirinfo, err := os.Stat(dir + "/.git")
+		if err != nil {
+			continue
+		}
+		if dirinfo.IsDir() {
+			return GitDir(dir) + "/.git", WorkDir(dir), nil
+		}
+		gitdir, err := readGitFile(File(dir + "/.git"))
+		if err != nil {
+			return "", "", err
 		}
+		return GitDir(gitdir), WorkDir(dir), nil
 	}
 
 	// This could be a bare repository, let's check the configuration
@@ -189,45 +289,84 @@ func findGitDir() GitDir {
 
 	configFile, err := os.Open(configFileName)
 	if err != nil {
-		return ""
+		return "", "", nil
 	}
 	config := ParseConfig(configFile)
 	if err := configFile.Close(); err != nil {
-		return ""
+		return "", "", nil
 	}
 
 	bareVar, _ := config.GetConfig("core.bare")
 	if bareVar == "true" {
-		return GitDir(startPath)
+		return GitDir(startPath), "", nil
 	}
 
-	return ""
+	return "", "", nil
 }
 
 // Creates a new client with the given gitDir and workdir. If not specified,
 // NewClient will walk the filesystem until it finds a .git directory to use.
+// If the git directory is a .git file, as in a linked worktree, the git
+// directory that it points to is used.
 func NewClient(gitDir, workDir string) (*Client, error) {
 	gitdir := GitDir(gitDir)
+	var foundWorkdir WorkDir
 	if gitdir == "" {
 		gitdir = GitDir(os.Getenv("GIT_DIR"))
 		if gitdir == "" {
-			gitdir = findGitDir()
+			var err error
+			if gitdir, foundWorkdir, err = findGitDir(); err != nil {
+				return nil, fmt.Errorf("fatal: %v", err)
+			}
 		}
 	}
 
 	if gitdir == "" || !gitdir.Exists() {
 		return nil, fmt.Errorf("fatal: Not a git repository (or any parent)")
 	}
+	if dotgit := File(gitdir); !dotgit.IsDir() {
+		dir, err := readGitFile(dotgit)
+		if err != nil {
+			return nil, fmt.Errorf("fatal: %v", err)
+		}
+		if foundWorkdir == "" && filepath.Base(gitdir.String()) == ".git" {
+			foundWorkdir = WorkDir(filepath.Dir(gitdir.String()))
+		}
+		gitdir = GitDir(dir)
+	}
+
+	// Refuse to use a repository with features that would be mishandled
+	// by pretending that they aren't there.
+	config, err := gitdir.readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRepositoryFormat(config); err != nil {
+		return nil, fmt.Errorf("fatal: %v", err)
+	}
 
 	workdir := WorkDir(workDir)
 	if workdir == "" {
 		workdir = WorkDir(os.Getenv("GIT_WORK_TREE"))
+		if workdir == "" {
+			workdir = foundWorkdir
+		}
 		if workdir == "" && strings.HasSuffix(gitdir.String(), "/.git") {
 			workdir = WorkDir(strings.TrimSuffix(gitdir.String(), "/.git"))
 		}
 	}
-	m := make(map[Sha1]objectLocation)
-	return &Client{GitDir(gitdir), WorkDir(workdir), "", m, make(map[shaRef]GitObject), nil, nil, nil}, nil
+	return newClient(gitdir, workdir), nil
+}
+
+// Returns a client for the git directory gitdir and the work tree workdir,
+// which have already been found.
+func newClient(gitdir GitDir, workdir WorkDir) *Client {
+	return &Client{
+		GitDir:           gitdir,
+		WorkDir:          workdir,
+		objectCache:      make(map[Sha1]objectLocation),
+		NoReplaceObjects: os.Getenv("GIT_NO_REPLACE_OBJECTS") != "",
+	}
 }
 
 // Returns the branchname of the HEAD branch, or the empty string if the
@@ -242,6 +381,33 @@ func (c *Client) GetHeadBranch() Branch {
 	return Branch(refspec.String())
 }
 
+// HeadBranch returns the branch that HEAD refers to, which may not have any
+// commits yet. If HEAD is detached, the error is a DetachedHeadError with
+// the commit that it points to.
+func (c *Client) HeadBranch() (Branch, error) {
+	refspec, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD")
+	switch err {
+	case nil:
+		return Branch(refspec.String()), nil
+	case DetachedHead:
+		cmt, err := CommitIDFromString(strings.TrimSpace(string(refspec)))
+		if err != nil {
+			return "", InvalidHead
+		}
+		return "", DetachedHeadError{cmt}
+	default:
+		return "", err
+	}
+}
+
+// IsDetached returns true if HEAD points directly at a commit instead of a
+// branch.
+func (c *Client) IsDetached() bool {
+	_, err := c.HeadBranch()
+	_, detached := err.(DetachedHeadError)
+	return detached
+}
+
 // Opens a file relative to GitDir. There should not be
 // a leading slash.
 func (gd GitDir) Open(f File) (*os.File, error) {
@@ -342,12 +508,10 @@ func (c *Client) CreateBranch(name string, commit Commitish) error {
 		return fmt.Errorf("fatal: 'HEAD' is not a valid branch name.")
 	}
 
-	// Create the file using File.Create first to ensure any parent directories are created.
-	if err := c.GitDir.File(File("refs/heads/" + name)).Create(); err != nil {
+	return c.GitDir.WriteLocked(File("refs/heads/"+name), func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%v", id)
 		return err
-	}
-
-	return c.GitDir.WriteFile(File("refs/heads/"+name), []byte(id.String()), 0644)
+	})
 }
 
 // A Person is usually an Author, but might be a committer. It's someone
@@ -371,8 +535,12 @@ func (p Person) String() string {
 func timeToGitTime(t time.Time) string {
 	_, tzoff := t.Zone()
 	// for some reason t.Zone() returns the timezone offset in seconds
-	// instead of hours, so convert it to an hour format string
-	tzStr := fmt.Sprintf("%+03d00", tzoff/(60*60))
+	// instead of hours, so convert it to an hour and minute format string
+	sign := '+'
+	if tzoff < 0 {
+		sign, tzoff = '-', -tzoff
+	}
+	tzStr := fmt.Sprintf("%c%02d%02d", sign, tzoff/(60*60), tzoff/60%60)
 	val := fmt.Sprintf("%d %s", t.Unix(), tzStr)
 	return val
 }
@@ -462,22 +630,30 @@ func (c *Client) GetCommitter(t *time.Time) (Person, error) {
 // Resets the index to the Treeish tree and save the results in
 // the file named indexname
 func (c *Client) ResetIndex(tree Treeish, indexname string) error {
-	// If the index doesn't exist, idx is a new index, so ignore
-	// the path error that ReadIndex is returning
-	idx, _ := c.GitDir.ReadIndex()
-	idx.ResetIndex(c, tree)
-
-	f, err := c.GitDir.Create(File(indexname))
+	lock, err := c.GitDir.Lock(File(indexname))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return idx.WriteIndex(f)
+	defer lock.Rollback()
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return err
+	}
+	if err := idx.ResetIndex(c, tree); err != nil {
+		return err
+	}
+	idx.UseConfiguredVersion(c)
+	return idx.CommitLocked(lock)
 }
 
 // Writes an object into the Client's .git/objects/ directory. This will write
 // the object loosely, and not use a packfile.
 func (c *Client) WriteObject(objType string, rawdata []byte) (Sha1, error) {
+	if h := c.HashAlgorithm(); h != HashSHA1 {
+		// The object would be named with the wrong hash. Callers
+		// which support other algorithms use WriteObjectID.
+		return Sha1{}, fmt.Errorf("Can not write a SHA-1 object in a %v repository", h)
+	}
 	obj := []byte(fmt.Sprintf("%s %d\000", objType, len(rawdata)))
 	obj = append(obj, rawdata...)
 	sha := sha1.Sum(obj)
@@ -489,20 +665,9 @@ func (c *Client) WriteObject(objType string, rawdata []byte) (Sha1, error) {
 		}
 		return Sha1(sha), nil
 	}
-	directory := fmt.Sprintf("%x", sha[0:1])
-	file := fmt.Sprintf("%x", sha[1:])
-
-	os.MkdirAll(c.GitDir.String()+"/objects/"+directory, os.FileMode(0755))
-	f, err := c.GitDir.Create(File("objects/" + directory + "/" + file))
-	if err != nil {
-		return Sha1{}, err
-	}
-	defer f.Close()
-	w := zlib.NewWriter(f)
-	if _, err := w.Write(obj); err != nil {
+	if err := writeLooseObject(c.looseObjectIDFile(Sha1(sha).ObjectID()), obj); err != nil {
 		return Sha1{}, err
 	}
-	defer w.Close()
 	return Sha1(sha), nil
 }
 
@@ -548,46 +713,68 @@ func (c *Client) GetHeadCommit() (CommitID, error) {
 // zero value if it's stored loosely in the repo), and possibly an error
 // if anything went wrong.
 func (c *Client) HaveObject(id Sha1) (found bool, packedfile File, err error) {
+	c.objectsMu.Lock()
+	defer c.objectsMu.Unlock()
 	// If it's cached, avoid the overhead
 	if val, ok := c.objectCache[id]; ok {
 		log.Printf("Object %s was found in the cache\n", id)
 		return true, val.packfile, nil
 	}
 
-	// First the easy case
-	if f := c.GitDir.File(File(fmt.Sprintf("objects/%02x/%018x", id[0], id[1:]))); f.Exists() {
-		log.Printf("Object %s was found in the objects directory\n", id)
-		c.objectCache[id] = objectLocation{true, "", nil, 0}
-		return true, "", nil
-	}
-
-	// Then, check if it's in a pack file.
-	files, err := ioutil.ReadDir(c.GitDir.File("objects/pack").String())
+	dirs, err := c.objectDirs()
 	if err != nil {
-		// The pack directory doesn't exist. It's not an error, but it definitely
-		// doesn't have the file..
-		log.Printf("No pack directories to search for object %s\n", id)
-		return false, "", nil
-	}
-	for _, fi := range files {
-		if filepath.Ext(fi.Name()) == ".idx" {
-			// It's ambiguous if Name() has the full path or not according to what
-			// ReadDir returns, so just be very cautious on how we open it.
-			name := c.GitDir.File(File(fmt.Sprintf("objects/pack/%s", filepath.Base(fi.Name()))))
-			f, err := os.Open(name.String())
-			if err != nil {
-				log.Print(err)
-				continue
+		return false, "", err
+	}
+	for _, dir := range dirs {
+		// First the easy case
+		if f := dir + File(fmt.Sprintf("/%02x/%018x", id[0], id[1:])); f.Exists() {
+			log.Printf("Object %s was found in the objects directory %s\n", id, dir)
+			c.objectCache[id] = objectLocation{loose: true, objectDir: dir}
+			return true, "", nil
+		}
+
+		// Then, check if it's in a pack file, starting with the ones
+		// in the multi-pack-index.
+		midx := c.getMultiPackIndex(dir)
+		if midx != nil {
+			if idx, offset, ok := midx.lookup(id); ok {
+				pfile := dir + File("/pack/"+strings.TrimSuffix(idx, ".idx"))
+				log.Printf("Found object %s in pack file %s through the multi-pack-index\n", id, idx)
+				c.objectCache[id] = objectLocation{packfile: pfile, offset: offset}
+				return true, pfile, nil
 			}
-			pfile := File(strings.TrimSuffix(name.String(), ".idx"))
-			buf := bufio.NewReader(f)
-			if v2PackIndexHasSha1(c, pfile, buf, id) {
-				// We want to return the pack file, not the index.
+		}
+		files, err := ioutil.ReadDir((dir + "/pack").String())
+		if err != nil {
+			// The pack directory doesn't exist. It's not an error, but it definitely
+			// doesn't have the file..
+			log.Printf("No pack directory in %s to search for object %s\n", dir, id)
+			continue
+		}
+		for _, fi := range files {
+			if filepath.Ext(fi.Name()) == ".idx" {
+				if midx != nil && midx.hasPack(filepath.Base(fi.Name())) {
+					// It would have been found above.
+					continue
+				}
+				// It's ambiguous if Name() has the full path or not according to what
+				// ReadDir returns, so just be very cautious on how we open it.
+				name := dir + File("/pack/"+filepath.Base(fi.Name()))
+				f, err := os.Open(name.String())
+				if err != nil {
+					log.Print(err)
+					continue
+				}
+				pfile := File(strings.TrimSuffix(name.String(), ".idx"))
+				buf := bufio.NewReader(f)
+				if v2PackIndexHasSha1(c, pfile, buf, id) {
+					// We want to return the pack file, not the index.
+					f.Close()
+					log.Printf("Found object %s in pack file %s\n", id, fi.Name())
+					return true, pfile, nil
+				}
 				f.Close()
-				log.Printf("Found object %s in pack file %s\n", id, fi.Name())
-				return true, pfile, nil
 			}
-			f.Close()
 		}
 	}
 
@@ -599,6 +786,8 @@ func (c *Client) HaveObject(id Sha1) (found bool, packedfile File, err error) {
 // will be persisted into the local or global configuration. Once the
 // client is closed or is garbage collected the configuration is lost.
 func (c *Client) SetCachedConfig(varname string, value string) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
 	if c.configCache == nil {
 		c.configCache = make(map[string]string)
 	}
@@ -609,6 +798,8 @@ func (c *Client) SetCachedConfig(varname string, value string) {
 // Gets a cached config variable if it is there. Otherwise, it returns
 //  and empty string.
 func (c *Client) GetCachedConfig(varname string) string {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
 	if c.configCache == nil {
 		return ""
 	}
@@ -621,14 +812,34 @@ func (c *Client) GetCachedConfig(varname string) string {
 // it will use the global variable.
 // Non-existent variables will return the empty string.
 func (c *Client) GetConfig(varname string) string {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
 	if c.configCache == nil {
 		c.configCache = make(map[string]string)
 	}
 	if val, ok := c.configCache[varname]; ok {
 		return val
 	}
+	c.loadConfigs()
+	if val, _ := c.localConfig.GetConfig(varname); val != "" {
+		c.configCache[varname] = val
+		return val
+	}
+	if val, _ := c.globalConfig.GetConfig(varname); val != "" {
+		c.configCache[varname] = val
+		return val
+	}
+	return ""
+}
+
+// Parses the local and global config files if they haven't been yet,
+// with the files that they include. c.configMu must be held by the caller.
+func (c *Client) loadConfigs() {
 	if c.localConfig == nil {
 		config, err := LoadLocalConfig(c)
+		if err == nil {
+			config, err = config.WithIncludes(c)
+		}
 		if err == nil {
 			c.localConfig = &config
 		} else {
@@ -638,12 +849,11 @@ func (c *Client) GetConfig(varname string) string {
 			c.localConfig = &GitConfig{}
 		}
 	}
-	if val, _ := c.localConfig.GetConfig(varname); val != "" {
-		c.configCache[varname] = val
-		return val
-	}
 	if c.globalConfig == nil {
 		config, err := LoadGlobalConfig()
+		if err == nil {
+			config, err = config.WithIncludes(c)
+		}
 		if err == nil {
 			c.globalConfig = &config
 		} else {
@@ -653,9 +863,4 @@ func (c *Client) GetConfig(varname string) string {
 			c.globalConfig = &GitConfig{}
 		}
 	}
-	if val, _ := c.globalConfig.GetConfig(varname); val != "" {
-		c.configCache[varname] = val
-		return val
-	}
-	return ""
 }
@@ -0,0 +1,50 @@
+package git
+
+import (
+	"errors"
+	"testing"
+)
+
+// Tests that HeadBranch and IsDetached distinguish a HEAD which refers to
+// a branch from one which points directly at a commit.
+func TestHeadBranch(t *testing.T) {
+	c, cleanup := testRepo(t, "githeadbranch")
+	defer cleanup()
+
+	// A branch with no commits yet is still attached.
+	if b, err := c.HeadBranch(); err != nil || b != "refs/heads/master" {
+		t.Errorf("Unexpected HEAD before the first commit: got %v (%v) want refs/heads/master", b, err)
+	}
+	if c.IsDetached() {
+		t.Error("Unborn branch is detached")
+	}
+
+	cmt := testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	if b, err := c.HeadBranch(); err != nil || b != "refs/heads/master" {
+		t.Errorf("Unexpected HEAD: got %v (%v) want refs/heads/master", b, err)
+	}
+	if c.IsDetached() {
+		t.Error("HEAD is detached on a branch")
+	}
+
+	if _, err := Checkout(c, CheckoutOptions{Detach: true}, cmt.String(), nil); err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.HeadBranch()
+	if b != "" {
+		t.Errorf("Unexpected branch for detached HEAD: %v", b)
+	}
+	detached, ok := err.(DetachedHeadError)
+	if !ok {
+		t.Fatalf("Unexpected error for detached HEAD: %v", err)
+	}
+	if detached.Commit != cmt {
+		t.Errorf("Unexpected detached commit: got %v want %v", detached.Commit, cmt)
+	}
+	if !errors.Is(err, DetachedHead) {
+		t.Error("DetachedHeadError is not DetachedHead")
+	}
+	if !c.IsDetached() {
+		t.Error("HEAD is not detached")
+	}
+}
@@ -10,13 +10,26 @@ import (
 type CloneOptions struct {
 	InitOptions
 	FetchPackOptions
-	Local                      bool
-	NoHardLinks                bool
-	Reference, ReferenceIfAble bool
-	Dissociate                 bool
-	Progress                   bool
-	NoCheckout                 bool
-	Mirror                     bool
+	Local       bool
+	NoHardLinks bool
+
+	// Borrow the objects from the local repository being cloned by
+	// adding it to objects/info/alternates instead of copying them.
+	Shared bool
+
+	// Borrow objects from the local repository Reference in addition
+	// to the remote being cloned. If ReferenceIfAble is set, a
+	// Reference which doesn't exist is ignored instead of an error.
+	Reference       File
+	ReferenceIfAble bool
+
+	// Copy any objects borrowed via Shared or Reference into the new
+	// repository once the clone is done, and stop using the alternates.
+	Dissociate bool
+
+	Progress   bool
+	NoCheckout bool
+	Mirror     bool
 	// use name instead of origin as upstream remote.
 	Origin string
 	// Use branch instead of HEAD as default branch to checkout
@@ -55,13 +68,49 @@ func Clone(opts CloneOptions, rmt Remote, dst File) error {
 		return err
 	}
 
+	var alternates []File
+	if opts.Shared {
+		if !rmt.IsFile() {
+			return fmt.Errorf("--shared can only be used with a local repository")
+		}
+		objdir, err := localObjectDir(File(rmt.String()))
+		if err != nil {
+			return err
+		}
+		alternates = append(alternates, objdir)
+	}
+	if opts.Reference != "" {
+		objdir, err := localObjectDir(opts.Reference)
+		if err != nil && !opts.ReferenceIfAble {
+			return err
+		} else if err == nil {
+			alternates = append(alternates, objdir)
+		}
+	}
+	if len(alternates) > 0 {
+		if err := writeAlternates(c, alternates); err != nil {
+			return err
+		}
+	}
+
 	opts.FetchPackOptions.All = true
 	opts.FetchPackOptions.Verbose = true
 
 	refs, err := FetchPack(c, opts.FetchPackOptions, rmt, nil)
+	if err != nil && err.Error() == "Already up to date." {
+		// Every object was already available from the alternates,
+		// so nothing was fetched, but we still need to know what
+		// the refs are.
+		refs, err = LsRemote(c, LsRemoteOptions{Heads: true, RefsOnly: true}, rmt, nil)
+	}
 	if err != nil {
 		return err
 	}
+	if opts.Dissociate && len(alternates) > 0 {
+		if err := dissociate(c); err != nil {
+			return err
+		}
+	}
 	config, err := LoadLocalConfig(c)
 	if err != nil {
 		return err
@@ -90,6 +139,9 @@ func Clone(opts CloneOptions, rmt Remote, dst File) error {
 	// This should be smarter and get the HEAD symref from the connection.
 	// It isn't necessarily named refs/heads/master
 	config.SetConfig(fmt.Sprintf("branch.%v.merge", br), "refs/heads/master")
+	if opts.Filter != "" {
+		setPromisorRemote(c, &config, Remote(org), opts.Filter)
+	}
 	if err := config.WriteConfig(); err != nil {
 		return err
 	}
@@ -171,5 +223,8 @@ func Clone(opts CloneOptions, rmt Remote, dst File) error {
 	}
 	c.WorkDir = WorkDir(absdir)
 	c.GitDir = GitDir(filepath.Join(c.WorkDir.String(), ".git"))
-	return Reset(c, ResetOptions{Hard: true}, nil)
+	// The object directories were relative to the old working directory.
+	c.objectDirsCache = nil
+	_, err = Reset(c, ResetOptions{Hard: true}, nil)
+	return err
 }
@@ -0,0 +1,74 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that a shared clone borrows objects from the source repository
+// rather than copying them, and that dissociating copies them in.
+func TestCloneShared(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcloneshared")
+	defer cleanup()
+	testCommitFile(t, c, "foo.txt", "bar\n", "Initial commit")
+	blob, err := RevParsePath(c, &RevParseOptions{}, "HEAD:foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := c.WorkDir.String()
+
+	dir, err := ioutil.TempDir("", "gitclonedst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	shared := File(filepath.Join(dir, "shared.git"))
+	if err := Clone(CloneOptions{InitOptions: InitOptions{Quiet: true, Bare: true}, Shared: true}, Remote(src), shared); err != nil {
+		t.Fatal(err)
+	}
+	dissociated := File(filepath.Join(dir, "dissociated.git"))
+	if err := Clone(CloneOptions{InitOptions: InitOptions{Quiet: true, Bare: true}, Shared: true, Dissociate: true}, Remote(src), dissociated); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := NewClient(shared.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj, err := sc.GetObject(Sha1(blob)); err != nil {
+		t.Fatal(err)
+	} else if string(obj.GetContent()) != "bar\n" {
+		t.Errorf("Unexpected content: got %q want %q", obj.GetContent(), "bar\n")
+	}
+	if _, err := os.Stat(sc.GitDir.File(File("objects/" + blob.String()[:2])).String()); err == nil {
+		t.Error("Shared clone has a copy of the object")
+	}
+
+	// Once the source is gone, the shared clone can't read the
+	// object anymore but the dissociated one can.
+	if err := os.RemoveAll(filepath.Join(src, ".git")); err != nil {
+		t.Fatal(err)
+	}
+	sc, err = NewClient(shared.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.GetObject(Sha1(blob)); err == nil {
+		t.Error("Shared clone could read object after removing the source")
+	}
+	dc, err := NewClient(dissociated.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj, err := dc.GetObject(Sha1(blob)); err != nil {
+		t.Errorf("Dissociated clone could not read object: %v", err)
+	} else if string(obj.GetContent()) != "bar\n" {
+		t.Errorf("Unexpected content: got %q want %q", obj.GetContent(), "bar\n")
+	}
+	if dc.GitDir.File("objects/info/alternates").Exists() {
+		t.Error("Dissociated clone still has alternates")
+	}
+}
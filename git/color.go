@@ -0,0 +1,295 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const colorReset = "\033[m"
+
+// The default colors for the color.diff.<slot> config.
+var defaultDiffColors = map[string]string{
+	"context":    "",
+	"meta":       "bold",
+	"frag":       "cyan",
+	"func":       "",
+	"old":        "red",
+	"new":        "green",
+	"commit":     "yellow",
+	"whitespace": "normal red",
+}
+
+// The default colors for the color.status.<slot> config.
+var defaultStatusColors = map[string]string{
+	"header":    "",
+	"branch":    "",
+	"nobranch":  "red",
+	"added":     "green",
+	"updated":   "green",
+	"changed":   "red",
+	"untracked": "red",
+	"unmerged":  "red",
+}
+
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+var colorAttributes = map[string]int{
+	"bold":    1,
+	"dim":     2,
+	"italic":  3,
+	"ul":      4,
+	"blink":   5,
+	"reverse": 7,
+	"strike":  9,
+}
+
+// ParseColor parses a color value, in the format of the color.* config
+// options, into the ANSI escape sequence which starts it. A value is made up
+// of an optional foreground and background color, either by name or as a
+// number from 0 to 255, and any number of attributes such as "bold". The
+// empty string or "normal" means no color.
+func ParseColor(value string) (string, error) {
+	var codes []string
+	colors := 0
+	for _, word := range strings.Fields(strings.ToLower(value)) {
+		if attr, ok := colorAttributes[strings.TrimPrefix(word, "no")]; ok {
+			if strings.HasPrefix(word, "no") {
+				// Turning off bold and dim are both 22, the rest
+				// are 20 more than the code which sets them.
+				if attr == 1 {
+					attr = 2
+				}
+				attr += 20
+			}
+			codes = append(codes, strconv.Itoa(attr))
+			continue
+		}
+
+		if colors == 2 {
+			return "", fmt.Errorf("Invalid color value: %v", value)
+		}
+		base := 30
+		if colors == 1 {
+			base = 40
+		}
+		colors++
+
+		if word == "normal" {
+			continue
+		}
+		code := -1
+		for i, name := range colorNames {
+			if word == name {
+				code = base + i
+			} else if word == "bright"+name {
+				code = base + 60 + i
+			}
+		}
+		if code >= 0 {
+			codes = append(codes, strconv.Itoa(code))
+			continue
+		}
+		n, err := strconv.Atoi(word)
+		if err != nil || n < -1 || n > 255 {
+			return "", fmt.Errorf("Invalid color value: %v", value)
+		}
+		if n >= 0 {
+			codes = append(codes, fmt.Sprintf("%d;5;%d", base+8, n))
+		}
+	}
+	if len(codes) == 0 {
+		return "", nil
+	}
+	return "\033[" + strings.Join(codes, ";") + "m", nil
+}
+
+// ColorEnabled returns whether output written to w should be colored.
+//
+// option is the value of the --color option, or the empty string if it
+// wasn't specified. If it wasn't, the color.<name> config is used, falling
+// back on color.ui. If name is empty, the config is not consulted, as is
+// the case for plumbing commands. The values "always", "never" and "auto"
+// are understood along with booleans, which are "auto" when true. In "auto"
+// mode, output is colored if w is a terminal or a pager is running.
+func ColorEnabled(c *Client, name, option string, w io.Writer) (bool, error) {
+	setting := option
+	if setting == "" && name != "" {
+		if setting = c.GetConfig("color." + name); setting == "" {
+			setting = c.GetConfig("color.ui")
+		}
+	}
+	switch strings.ToLower(setting) {
+	case "always":
+		return true, nil
+	case "never", "false", "no", "off", "0":
+		return false, nil
+	case "", "auto", "true", "yes", "on", "1":
+		if setting == "" && name == "" {
+			// Plumbing is only colored when asked.
+			return false, nil
+		}
+		return pagerInUse || isTerminal(w), nil
+	default:
+		return false, fmt.Errorf("Invalid color setting: %v", setting)
+	}
+}
+
+// Returns the escape sequence for slot from the color.<name>.<slot> config,
+// or the default for the slot.
+func colorSlot(c *Client, name, slot string, defaults map[string]string) (string, error) {
+	value := c.GetConfig("color." + name + "." + slot)
+	if value == "" {
+		value = defaults[slot]
+	}
+	return ParseColor(value)
+}
+
+// Wraps s in the color seq, unless there is no color.
+func colorize(seq, s string) string {
+	if seq == "" || s == "" {
+		return s
+	}
+	return seq + s + colorReset
+}
+
+// Returns patch with each line colored according to the color.diff config.
+func colorPatch(c *Client, patch string) (string, error) {
+	slots := make(map[string]string, len(defaultDiffColors))
+	for slot := range defaultDiffColors {
+		seq, err := colorSlot(c, "diff", slot, defaultDiffColors)
+		if err != nil {
+			return "", err
+		}
+		slots[slot] = seq
+	}
+
+	rule, err := whitespaceRule(c)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.SplitAfter(patch, "\n")
+	var eofBlank map[int]bool
+	if rule.BlankAtEOF {
+		eofBlank = blankLinesAtEOF(lines)
+	}
+	inHunk := false
+	for i, line := range lines {
+		text := strings.TrimSuffix(line, "\n")
+		eol := line[len(text):]
+		switch {
+		case strings.HasPrefix(text, "diff --git "):
+			inHunk = false
+			text = colorize(slots["meta"], text)
+		case strings.HasPrefix(text, "@@"):
+			inHunk = true
+			// The function context after the range is colored
+			// separately.
+			if end := strings.Index(text[2:], "@@"); end >= 0 {
+				end += 4
+				text = colorize(slots["frag"], text[:end]) + colorize(slots["func"], text[end:])
+			} else {
+				text = colorize(slots["frag"], text)
+			}
+		case !inHunk:
+			text = colorize(slots["meta"], text)
+		case eofBlank[i]:
+			text = colorize(slots["whitespace"], text)
+		case strings.HasPrefix(text, "+"):
+			// Whitespace errors in the added lines are highlighted,
+			// as in git.
+			var highlighted strings.Builder
+			if rule.check(text[1:], &highlighted, slots["new"], slots["whitespace"]) != 0 {
+				text = colorize(slots["new"], "+") + highlighted.String()
+			} else {
+				text = colorize(slots["new"], text)
+			}
+		case strings.HasPrefix(text, "-"):
+			text = colorize(slots["old"], text)
+		default:
+			text = colorize(slots["context"], text)
+		}
+		lines[i] = text + eol
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// Returns the escape sequences for every color.status slot.
+func statusColors(c *Client) (map[string]string, error) {
+	slots := make(map[string]string, len(defaultStatusColors))
+	for slot := range defaultStatusColors {
+		seq, err := colorSlot(c, "status", slot, defaultStatusColors)
+		if err != nil {
+			return nil, err
+		}
+		slots[slot] = seq
+	}
+	return slots, nil
+}
+
+// Returns the output of StatusLong with the files in each section colored
+// according to the color.status config.
+func colorStatusLong(c *Client, status string) (string, error) {
+	slots, err := statusColors(c)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.SplitAfter(status, "\n")
+	slot := ""
+	for i, line := range lines {
+		text := strings.TrimSuffix(line, "\n")
+		eol := line[len(text):]
+		switch {
+		case strings.HasPrefix(text, "\t"):
+			text = "\t" + colorize(slots[slot], text[1:])
+		case strings.HasPrefix(text, "  ("), text == "":
+		default:
+			switch text {
+			case "Changes to be committed:":
+				slot = "added"
+			case "Changes not staged for commit:":
+				slot = "changed"
+			case "Untracked files:":
+				slot = "untracked"
+			case "Unmerged paths:":
+				slot = "unmerged"
+			}
+			text = colorize(slots["header"], text)
+		}
+		lines[i] = text + eol
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// Returns the output of StatusShort with the status codes colored according
+// to the color.status config.
+func colorStatusShort(c *Client, status string) (string, error) {
+	slots, err := statusColors(c)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.SplitAfter(status, "\n")
+	for i, line := range lines {
+		if len(line) < 3 {
+			continue
+		}
+		x, y := line[0:1], line[1:2]
+		switch {
+		case x == "?" && y == "?":
+			x, y = colorize(slots["untracked"], x), colorize(slots["untracked"], y)
+		case x == "U" || y == "U" || (x == "A" && y == "A") || (x == "D" && y == "D"):
+			x, y = colorize(slots["unmerged"], x), colorize(slots["unmerged"], y)
+		default:
+			if x != " " {
+				x = colorize(slots["updated"], x)
+			}
+			if y != " " {
+				y = colorize(slots["changed"], y)
+			}
+		}
+		lines[i] = x + y + line[2:]
+	}
+	return strings.Join(lines, ""), nil
+}
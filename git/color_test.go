@@ -0,0 +1,86 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	testcases := []struct {
+		Value string
+		Want  string
+	}{
+		{"", ""},
+		{"normal", ""},
+		{"green", "\033[32m"},
+		{"bold red", "\033[1;31m"},
+		{"white blue", "\033[37;44m"},
+		{"normal brightblack", "\033[100m"},
+		{"208 nobold", "\033[38;5;208;22m"},
+	}
+	for _, tc := range testcases {
+		got, err := ParseColor(tc.Value)
+		if err != nil {
+			t.Errorf("%q: %v", tc.Value, err)
+			continue
+		}
+		if got != tc.Want {
+			t.Errorf("%q: got %q want %q", tc.Value, got, tc.Want)
+		}
+	}
+	if _, err := ParseColor("red green blue"); err == nil {
+		t.Error("Expected error for three colors")
+	}
+}
+
+func TestColorPatch(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcolorpatch")
+	defer cleanup()
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "first")
+	if err := ioutil.WriteFile("foo.txt", []byte("foo\nbar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	color, err := ColorEnabled(c, "diff", "always", ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !color {
+		t.Fatal("--color=always did not enable color")
+	}
+	if color, _ := ColorEnabled(c, "diff", "", ioutil.Discard); color {
+		t.Error("Color was enabled for output which is not a terminal")
+	}
+
+	opts := DiffCommonOptions{Patch: true, Color: true}
+	diffs, err := DiffFiles(c, DiffFilesOptions{opts}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := GeneratePatch(c, opts, diffs, &out); err != nil {
+		t.Fatal(err)
+	}
+	patch := out.String()
+	if !strings.Contains(patch, "\n\033[32m+bar\033[m\n") {
+		t.Errorf("Added line was not colored green: %q", patch)
+	}
+	if !strings.HasPrefix(patch, "\033[1mdiff --git a/foo.txt b/foo.txt\033[m\n") {
+		t.Errorf("File header was not colored bold: %q", patch)
+	}
+	if !strings.Contains(patch, "\033[36m@@ -1 +1,2 @@\033[m\n") {
+		t.Errorf("Hunk header was not colored cyan: %q", patch)
+	}
+
+	c.SetCachedConfig("color.diff.new", "bold blue")
+	out.Reset()
+	if err := GeneratePatch(c, opts, diffs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "\n\033[1;34m+bar\033[m\n") {
+		t.Errorf("color.diff.new was not respected: %q", out.String())
+	}
+}
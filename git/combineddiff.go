@@ -0,0 +1,367 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A CombinedHunk represents a single hunk of a combined diff of a merge
+// commit against all of its parents, in the format of "git diff --cc".
+type CombinedHunk struct {
+	Path IndexPath
+
+	// The entries for Path in each parent and in the merge commit.
+	Parents []TreeEntry
+	Result  TreeEntry
+
+	// The starting line and number of lines that the hunk covers in
+	// each parent.
+	ParentStart, ParentLines []int
+
+	// The starting line and number of lines that the hunk covers in
+	// the merge result.
+	Start, Lines int
+
+	// The lines of the hunk. Each line is prefixed with one column per
+	// parent, which is '+' if the line was added relative to that parent,
+	// '-' if it was removed from that parent, or ' ' if it's unchanged.
+	Body []string
+}
+
+// Returns the hunk header in the format "@@@ -1,3 -1,3 +1,3 @@@"
+func (h CombinedHunk) Header() string {
+	marker := strings.Repeat("@", len(h.Parents)+1)
+	header := marker
+	for i := range h.Parents {
+		header += fmt.Sprintf(" -%d,%d", h.ParentStart[i], h.ParentLines[i])
+	}
+	return header + fmt.Sprintf(" +%d,%d %s", h.Start, h.Lines, marker)
+}
+
+func (h CombinedHunk) String() string {
+	return h.Header() + "\n" + strings.Join(h.Body, "\n") + "\n"
+}
+
+// The number of lines of context to include around changes in a
+// combined diff.
+const combinedContext = 3
+
+// A combinedLine is either a line in the result of a merge, or a line
+// which was lost from one or more parents before a line in the result.
+type combinedLine struct {
+	text    string
+	columns []byte
+}
+
+func (l combinedLine) interesting() bool {
+	for _, c := range l.columns {
+		if c != ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+// A CombinedHashDiff is a file which a merge commit changed relative to
+// every one of its parents, as listed in the raw output of "git diff-tree
+// -c".
+type CombinedHashDiff struct {
+	Name IndexPath
+
+	// The diff of Name against each parent. They all have the same Dst.
+	Parents []HashDiff
+}
+
+// Returns d as a line of the raw combined diff output, with a colon, a
+// mode, an object and a status for each parent.
+func (d CombinedHashDiff) String() string {
+	return d.abbrevString(len(Sha1{}) * 2)
+}
+
+// Returns d as a line of raw combined diff output, with the objects
+// abbreviated to n characters.
+func (d CombinedHashDiff) abbrevString(n int) string {
+	var modes, objects, status []string
+	for _, p := range d.Parents {
+		modes = append(modes, fmt.Sprintf("%0.6o", p.Src.FileMode))
+		objects = append(objects, fmt.Sprintf("%.*v", n, p.Src.Sha1))
+		status = append(status, p.Status())
+	}
+	dst := d.Parents[0].Dst
+	return fmt.Sprintf("%v%v %0.6o %v %.*v %v\t%v",
+		strings.Repeat(":", len(d.Parents)),
+		strings.Join(modes, " "), dst.FileMode,
+		strings.Join(objects, " "), n, dst.Sha1,
+		strings.Join(status, ""),
+		d.Name,
+	)
+}
+
+// DiffTreeCombinedRaw returns the files which the merge commit merge
+// changed relative to every one of its parents, sorted by name, so that
+// the files which were taken from one side of the merge are left out.
+func DiffTreeCombinedRaw(c *Client, merge CommitID) ([]CombinedHashDiff, error) {
+	parents, err := merge.Parents(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(parents) < 2 {
+		return nil, fmt.Errorf("%v is not a merge commit", merge)
+	}
+
+	var changed map[IndexPath][]HashDiff
+	for i, p := range parents {
+		diffs, err := diffCommits(c, p, merge)
+		if err != nil {
+			return nil, err
+		}
+		current := make(map[IndexPath][]HashDiff)
+		for _, d := range diffs {
+			if i == 0 {
+				current[d.Name] = []HashDiff{d}
+			} else if prev, ok := changed[d.Name]; ok {
+				current[d.Name] = append(prev, d)
+			}
+		}
+		changed = current
+	}
+
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path.String())
+	}
+	sort.Strings(paths)
+	files := make([]CombinedHashDiff, 0, len(paths))
+	for _, path := range paths {
+		files = append(files, CombinedHashDiff{IndexPath(path), changed[IndexPath(path)]})
+	}
+	return files, nil
+}
+
+// DiffTreeCombined produces the dense combined diff of the merge commit
+// merge against each of its parents. Only the files which differ from
+// every parent are included, and only hunks which differ from every
+// parent are returned, so that changes which were taken verbatim from one
+// side of the merge are not shown.
+func DiffTreeCombined(c *Client, merge CommitID) ([]CombinedHunk, error) {
+	files, err := DiffTreeCombinedRaw(c, merge)
+	if err != nil {
+		return nil, err
+	}
+	var hunks []CombinedHunk
+	for _, f := range files {
+		entries := make([]TreeEntry, 0, len(f.Parents))
+		for _, p := range f.Parents {
+			entries = append(entries, p.Src)
+		}
+		h, err := combinedFileHunks(c, f.Name, entries, f.Parents[0].Dst)
+		if err != nil {
+			return nil, err
+		}
+		hunks = append(hunks, h...)
+	}
+	return hunks, nil
+}
+
+func blobLines(c *Client, e TreeEntry) ([]string, error) {
+	if e.Sha1 == (Sha1{}) {
+		return nil, nil
+	}
+	obj, err := c.GetObject(e.Sha1)
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(string(obj.GetContent())), nil
+}
+
+func combinedFileHunks(c *Client, path IndexPath, parents []TreeEntry, result TreeEntry) ([]CombinedHunk, error) {
+	rlines, err := blobLines(c, result)
+	if err != nil {
+		return nil, err
+	}
+
+	// For every line in the result, whether it's present in each parent
+	// and for every position in the result, which lines were lost before
+	// it.
+	common := make([][]bool, len(parents))
+	lost := make([][]combinedLine, len(rlines)+1)
+	for i, p := range parents {
+		plines, err := blobLines(c, p)
+		if err != nil {
+			return nil, err
+		}
+		common[i] = make([]bool, len(rlines))
+		pairs := append(diffLines(plines, rlines), linePair{len(plines), len(rlines)})
+
+		pa, pos := 0, 0
+		for _, pair := range pairs {
+			// Any lines in the parent before the next common line were
+			// lost immediately after the previous common line. Lines
+			// which were lost from multiple parents at the same place
+			// are coalesced.
+			last := -1
+		lostline:
+			for ; pa < pair.A; pa++ {
+				for j := last + 1; j < len(lost[pos]); j++ {
+					l := lost[pos][j]
+					if l.text == plines[pa] && l.columns[i] == ' ' {
+						l.columns[i] = '-'
+						last = j
+						continue lostline
+					}
+				}
+				cols := []byte(strings.Repeat(" ", len(parents)))
+				cols[i] = '-'
+				lost[pos] = append(lost[pos], combinedLine{plines[pa], cols})
+				last = len(lost[pos]) - 1
+			}
+			if pair.B < len(rlines) {
+				common[i][pair.B] = true
+			}
+			pa, pos = pair.A+1, pair.B+1
+		}
+	}
+
+	// Flatten everything into a single list of lines in the order
+	// that they get displayed.
+	var lines []combinedLine
+	for k := 0; k <= len(rlines); k++ {
+		lines = append(lines, lost[k]...)
+		if k == len(rlines) {
+			break
+		}
+		cols := make([]byte, len(parents))
+		for i := range parents {
+			if common[i][k] {
+				cols[i] = ' '
+			} else {
+				cols[i] = '+'
+			}
+		}
+		lines = append(lines, combinedLine{rlines[k], cols})
+	}
+
+	// Group the interesting lines into hunks with context.
+	var ranges [][2]int
+	for i, l := range lines {
+		if !l.interesting() {
+			continue
+		}
+		start, end := i-combinedContext, i+combinedContext+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(lines) {
+			end = len(lines)
+		}
+		if n := len(ranges); n > 0 && start <= ranges[n-1][1] {
+			ranges[n-1][1] = end
+		} else {
+			ranges = append(ranges, [2]int{start, end})
+		}
+	}
+
+	var hunks []CombinedHunk
+	pline := make([]int, len(parents))
+	rline := 0
+	next := 0
+	for _, r := range ranges {
+		// Advance the line counters to the start of the hunk.
+		for ; next < r[0]; next++ {
+			countLine(lines[next], pline, &rline)
+		}
+		h := CombinedHunk{
+			Path:        path,
+			Parents:     parents,
+			Result:      result,
+			ParentStart: make([]int, len(parents)),
+			ParentLines: make([]int, len(parents)),
+		}
+		startp := append([]int(nil), pline...)
+		startr := rline
+		changedFrom := make([]bool, len(parents))
+		for ; next < r[1]; next++ {
+			l := lines[next]
+			countLine(l, pline, &rline)
+			for i, col := range l.columns {
+				if col != ' ' {
+					changedFrom[i] = true
+				}
+			}
+			h.Body = append(h.Body, string(l.columns)+l.text)
+		}
+
+		// If the hunk is identical to one of the parents, it's not
+		// interesting in a dense combined diff.
+		dense := true
+		for _, changed := range changedFrom {
+			if !changed {
+				dense = false
+			}
+		}
+		if !dense {
+			continue
+		}
+		for i := range parents {
+			h.ParentLines[i] = pline[i] - startp[i]
+			h.ParentStart[i] = startp[i]
+			if h.ParentLines[i] > 0 {
+				h.ParentStart[i]++
+			}
+		}
+		h.Lines = rline - startr
+		h.Start = startr
+		if h.Lines > 0 {
+			h.Start++
+		}
+		hunks = append(hunks, h)
+	}
+	return hunks, nil
+}
+
+// Increments the line counters for each parent which line l is present
+// in, and the result if it's in the result.
+func countLine(l combinedLine, pline []int, rline *int) {
+	lostLine := false
+	for _, col := range l.columns {
+		if col == '-' {
+			lostLine = true
+		}
+	}
+	for i, col := range l.columns {
+		if lostLine {
+			if col == '-' {
+				pline[i]++
+			}
+		} else if col == ' ' {
+			pline[i]++
+		}
+	}
+	if !lostLine {
+		*rline++
+	}
+}
+
+// WriteCombinedDiff writes the hunks of a combined diff to w in the format
+// used by "git diff --cc".
+func WriteCombinedDiff(hunks []CombinedHunk, w io.Writer) error {
+	var lastPath IndexPath
+	for _, h := range hunks {
+		if h.Path != lastPath || lastPath == "" {
+			fmt.Fprintf(w, "diff --cc %v\n", h.Path)
+			var srcs []string
+			for _, p := range h.Parents {
+				srcs = append(srcs, p.Sha1.String()[:7])
+			}
+			fmt.Fprintf(w, "index %v..%v\n", strings.Join(srcs, ","), h.Result.Sha1.String()[:7])
+			fmt.Fprintf(w, "--- a/%v\n+++ b/%v\n", h.Path, h.Path)
+			lastPath = h.Path
+		}
+		if _, err := fmt.Fprint(w, h.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
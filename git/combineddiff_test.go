@@ -0,0 +1,83 @@
+package git
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// Tests that the combined diff of a merge which resolved a conflict matches
+// the output of "git show" from the official git client.
+func TestDiffTreeCombined(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcombineddiff")
+	defer cleanup()
+
+	commit := func(content string, msg string, parents ...CommitID) CommitID {
+		t.Helper()
+		if err := ioutil.WriteFile("f", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Add(c, AddOptions{}, []File{"f"}); err != nil {
+			t.Fatal(err)
+		}
+		tree, err := WriteTree(c, WriteTreeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cid, err := CommitTree(c, CommitTreeOptions{}, TreeID(tree), parents, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cid
+	}
+
+	base := commit("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n", "base")
+	ours := commit("a\nB1\nc\nd\ne\nf\ng\nh\ni\nj\nk\n", "ours", base)
+	theirs := commit("a\nB2\nc\nd\ne\nf\ng\nh\ni\nj\nK2\n", "theirs", base)
+	merge := commit("a\nB3\nc\nd\ne\nf\ng\nh\ni\nj\nK2\nextra\n", "merge", ours, theirs)
+
+	hunks, err := DiffTreeCombined(c, merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`@@@ -1,5 -1,5 +1,5 @@@
+  a
+- B1
+ -B2
+++B3
+  c
+  d
+  e
+`,
+		`@@@ -8,4 -8,4 +8,5 @@@
+  h
+  i
+  j
+- k
++ K2
+++extra
+`,
+	}
+	if len(hunks) != len(expected) {
+		t.Fatalf("Unexpected number of hunks: got %v want %v", len(hunks), len(expected))
+	}
+	for i, h := range hunks {
+		if h.Path != "f" {
+			t.Errorf("Hunk %d: unexpected path %v", i, h.Path)
+		}
+		if got := h.String(); got != expected[i] {
+			t.Errorf("Hunk %d: got %v want %v", i, got, expected[i])
+		}
+	}
+
+	// If the merge took one side verbatim, there's nothing interesting
+	// in the dense combined diff.
+	merge = commit("a\nB1\nc\nd\ne\nf\ng\nh\ni\nj\nk\n", "merge", ours, theirs)
+	hunks, err = DiffTreeCombined(c, merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hunks) != 0 {
+		t.Errorf("Unexpected hunks for merge that took one side: %v", hunks)
+	}
+}
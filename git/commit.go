@@ -2,6 +2,7 @@ package git
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"regexp"
@@ -26,18 +27,23 @@ type CommitOptions struct {
 
 	NoPostRewrite bool
 	Include       bool
-	Only          bool
-	Quiet         bool
+	// Only commit the files given, without the other changes in the
+	// index. With no files, the commit has the same tree as HEAD, so
+	// that only the message changes.
+	Only  bool
+	Quiet bool
 
 	CleanupMode string
 	NoEdit      bool
 
-	// Should be passed to CommitTree, which needs support first:
-	// GPGSign GPGKeyID
-	// NoGpgSign bool
+	// Passed to CommitTree to sign the commit.
+	GPGSign   bool
+	GPGKey    GPGKeyId
+	NoGPGSign bool
 
 	// Things that are used to create the commit message and need to be
-	// parsed by package cmd/, but not included here.
+	// parsed by package cmd/, but not included here. (See FixupMessage
+	// for Fixup and Squash.)
 	//	ReuseMessage, ReeditMessage, Fixup, Squash Commitish
 	// File string
 	// Message string (-m)
@@ -85,7 +91,14 @@ func Commit(c *Client, opts CommitOptions, message CommitMessage, files []File)
 		}
 	}
 
-	if !opts.All && len(files) != 0 {
+	runHooks := !opts.NoVerify && !opts.DryRun
+	if runHooks {
+		if _, err := RunHook(c, "pre-commit", nil); err != nil {
+			return CommitID{}, err
+		}
+	}
+
+	if !opts.All && (len(files) != 0 || opts.Only) {
 		var idx1 *Index
 		head, err := c.GetHeadCommit()
 		if err != nil {
@@ -164,8 +177,13 @@ skipemptycheck:
 	if err != nil {
 		return CommitID{}, err
 	}
+	if runHooks {
+		if cleanMessage, err = commitMsgHook(c, opts, cleanMessage); err != nil {
+			return CommitID{}, err
+		}
+	}
 	var noConfig error
-	cid, err := CommitTree(c, CommitTreeOptions{}, TreeID(treeid), parents, cleanMessage)
+	cid, err := CommitTree(c, CommitTreeOptions{GPGSign: opts.GPGSign, GPGKey: opts.GPGKey, NoGPGSign: opts.NoGPGSign}, TreeID(treeid), parents, cleanMessage)
 	switch err {
 	case nil:
 		// Nothing
@@ -187,11 +205,82 @@ skipemptycheck:
 	if err := UpdateRef(c, UpdateRefOptions{OldValue: oldHead, CreateReflog: true}, "HEAD", cid, refmsg); err != nil {
 		return CommitID{}, err
 	}
+	if rerereEnabled(c) {
+		// The commit already succeeded, so failing to record a
+		// resolution isn't an error.
+		RerereRecord(c)
+	}
+	if runHooks {
+		// post-commit can't affect the commit, so failures are
+		// ignored.
+		RunHook(c, "post-commit", nil)
+	}
 	return cid, noConfig
 }
 
+// Runs the commit-msg hook on message, and returns the message after
+// the hook has had a chance to edit it.
+func commitMsgHook(c *Client, opts CommitOptions, message string) (string, error) {
+	f := c.GitDir.File("COMMIT_EDITMSG")
+	if err := c.GitDir.WriteFile("COMMIT_EDITMSG", []byte(message), 0660); err != nil {
+		return "", err
+	}
+	if ran, err := RunHook(c, "commit-msg", nil, f.String()); err != nil || !ran {
+		return message, err
+	}
+	edited, err := ioutil.ReadFile(f.String())
+	if err != nil {
+		return "", err
+	}
+	message, err = CommitMessage(edited).Cleanup(opts.CleanupMode, !opts.NoEdit)
+	if err != nil {
+		return "", err
+	}
+	if !opts.AllowEmptyMessage && message == "" {
+		return "", fmt.Errorf("Aborting commit due to empty commit message.")
+	}
+	return message, nil
+}
+
 type CommitMessage string
 
+// FixupMessage returns the message of a commit made with "git commit
+// --fixup" or "--squash", which rebase --autosquash attaches to target.
+// The kind is one of:
+//
+//	fixup	a "fixup! <subject>" commit, whose message is discarded
+//	squash	a "squash! <subject>" commit, whose message is added to target's
+//	amend	an "amend! <subject>" commit, whose message replaces target's
+//	reword	the same as amend, for a commit which doesn't change anything
+//
+// The message of an amend or reword commit starts with target's whole
+// message, so that it can be edited.
+func FixupMessage(c *Client, kind string, target Commitish) (CommitMessage, error) {
+	cmt, err := target.CommitID(c)
+	if err != nil {
+		return "", err
+	}
+	msg, err := cmt.GetCommitMessage(c)
+	if err != nil {
+		return "", err
+	}
+	subject := msg.Subject()
+	switch kind {
+	case "fixup", "squash":
+		return CommitMessage(kind + "! " + subject + "\n"), nil
+	case "amend", "reword":
+		body := msg.String()
+		if strings.HasPrefix(subject, "amend! ") {
+			// Don't repeat the subject when amending an amend!
+			// commit.
+			body = msg.body()
+		}
+		return CommitMessage("amend! " + subject + "\n\n" + body), nil
+	default:
+		return "", fmt.Errorf("Invalid fixup kind %v", kind)
+	}
+}
+
 func (cm CommitMessage) String() string {
 	return string(cm)
 }
@@ -234,10 +323,23 @@ func (cm CommitMessage) strip() string {
 	return strings.Join(filtered, "\n")
 }
 
+// Returns the rest of the commit message after the subject, or the empty
+// string if there's nothing else.
+func (cm CommitMessage) body() string {
+	parts := strings.SplitN(strings.TrimSpace(cm.whitespace()), "\n\n", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1] + "\n"
+}
+
+// Returns the subject of the commit message. Like git, the subject is the
+// first paragraph of the message, joined into a single line.
 func (cm CommitMessage) Subject() string {
-	lines := strings.SplitN(cm.whitespace(), "\n", 1)
-	if len(lines) > 0 {
-		return strings.TrimSpace(lines[0])
+	paragraph := strings.SplitN(strings.TrimSpace(cm.whitespace()), "\n\n", 2)[0]
+	lines := strings.Split(paragraph, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
 	}
-	return ""
+	return strings.Join(lines, " ")
 }
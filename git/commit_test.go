@@ -92,7 +92,7 @@ func TestSimpleCommits(t *testing.T) {
 	// Now go back to the first commit in a detached head state, and try
 	// again.
 
-	if err := CheckoutCommit(c, CheckoutOptions{}, initialCmt); err != nil {
+	if _, err := CheckoutCommit(c, CheckoutOptions{}, initialCmt); err != nil {
 		t.Fatal(err)
 	}
 	if err := ioutil.WriteFile(dir+"/foo.txt", []byte("bar\n"), 0644); err != nil {
@@ -346,3 +346,39 @@ func TestCommitAmend(t *testing.T) {
 	// FIXME: Add tests for when the tree doesn't get modified and --allow-empty
 	// isn't set, also add tests for merge commit amends
 }
+
+// Tests that the messages made for --fixup and --squash refer to the
+// subject of the target commit.
+func TestFixupMessage(t *testing.T) {
+	c, cleanup := testRepo(t, "gitfixupmessage")
+	defer cleanup()
+
+	target := testCommitFile(t, c, "a.txt", "a\n", "Add a\n\nWith a body.")
+	amend := testCommitFile(t, c, "a.txt", "b\n", "amend! Add a\n\nNew body.")
+
+	tests := []struct {
+		kind   string
+		target CommitID
+		want   CommitMessage
+	}{
+		{"fixup", target, "fixup! Add a\n"},
+		{"squash", target, "squash! Add a\n"},
+		{"amend", target, "amend! Add a\n\nAdd a\n\nWith a body.\n"},
+		{"reword", target, "amend! Add a\n\nAdd a\n\nWith a body.\n"},
+		// The subject isn't repeated when amending an amend! commit.
+		{"amend", amend, "amend! amend! Add a\n\nNew body.\n"},
+	}
+	for i, tc := range tests {
+		got, err := FixupMessage(c, tc.kind, tc.target)
+		if err != nil {
+			t.Errorf("Case %d: %v", i, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Case %d: got %q want %q", i, got, tc.want)
+		}
+	}
+	if _, err := FixupMessage(c, "bogus", target); err == nil {
+		t.Error("An invalid kind was accepted")
+	}
+}
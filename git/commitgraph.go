@@ -0,0 +1,379 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	commitGraphSignature = "CGPH"
+
+	// The parent position of a commit which has no parent in that slot.
+	graphParentNone = 0x70000000
+	// The flag on the second parent position of an octopus merge,
+	// making the rest of the value an index into the EDGE chunk.
+	graphOctopusFlag = 0x80000000
+	// Generation numbers which are too large to store are capped.
+	graphGenerationMax = 0x3FFFFFFF
+)
+
+// A commitGraph is a parsed .git/objects/info/commit-graph file, which
+// stores the parents and generation numbers of commits so that history can
+// be walked without reading the commit objects.
+type commitGraph struct {
+	fanout []byte
+	oids   []byte
+	data   []byte
+	edges  []byte
+}
+
+// The information about a commit which is stored in the commit graph.
+type graphCommit struct {
+	id         CommitID
+	tree       TreeID
+	parents    []CommitID
+	time       int64
+	generation uint32
+}
+
+func commitGraphFile(c *Client) File {
+	return c.GitDir.File("objects/info/commit-graph")
+}
+
+// Returns the number of commits in the graph.
+func (g *commitGraph) len() int {
+	return len(g.oids) / 20
+}
+
+func (g *commitGraph) oid(pos int) CommitID {
+	var cmt CommitID
+	copy(cmt[:], g.oids[pos*20:])
+	return cmt
+}
+
+// Returns the position of cmt in the graph, or false if it isn't in it.
+func (g *commitGraph) lookup(cmt CommitID) (int, bool) {
+	return lookupOID(g.fanout, g.oids, Sha1(cmt))
+}
+
+func (g *commitGraph) parents(pos int) ([]CommitID, error) {
+	entry := g.data[pos*36:]
+	var parents []CommitID
+	p1 := binary.BigEndian.Uint32(entry[20:])
+	if p1 == graphParentNone {
+		return nil, nil
+	}
+	if int(p1) >= g.len() {
+		return nil, fmt.Errorf("Invalid parent position in commit graph")
+	}
+	parents = append(parents, g.oid(int(p1)))
+
+	p2 := binary.BigEndian.Uint32(entry[24:])
+	switch {
+	case p2 == graphParentNone:
+	case p2&graphOctopusFlag != 0:
+		for i := int(p2 &^ graphOctopusFlag); ; i++ {
+			if (i+1)*4 > len(g.edges) {
+				return nil, fmt.Errorf("Invalid edge in commit graph")
+			}
+			edge := binary.BigEndian.Uint32(g.edges[i*4:])
+			if int(edge&^graphOctopusFlag) >= g.len() {
+				return nil, fmt.Errorf("Invalid parent position in commit graph")
+			}
+			parents = append(parents, g.oid(int(edge&^graphOctopusFlag)))
+			if edge&graphOctopusFlag != 0 {
+				break
+			}
+		}
+	default:
+		if int(p2) >= g.len() {
+			return nil, fmt.Errorf("Invalid parent position in commit graph")
+		}
+		parents = append(parents, g.oid(int(p2)))
+	}
+	return parents, nil
+}
+
+func (g *commitGraph) generation(pos int) uint32 {
+	return binary.BigEndian.Uint32(g.data[pos*36+28:]) >> 2
+}
+
+// Parses the content of a commit-graph file. It returns an error if the
+// file is corrupt or in a version that isn't understood.
+func parseCommitGraph(content []byte) (*commitGraph, error) {
+	if len(content) < 8+12+20 || string(content[:4]) != commitGraphSignature {
+		return nil, fmt.Errorf("commit-graph signature does not match")
+	}
+	if content[4] != 1 {
+		return nil, fmt.Errorf("commit-graph version %d does not match version 1", content[4])
+	}
+	if content[5] != 1 {
+		return nil, fmt.Errorf("commit-graph hash version %d is not supported", content[5])
+	}
+	chunks, err := parseChunkFile(content, 8, int(content[6]))
+	if err != nil {
+		return nil, fmt.Errorf("commit-graph: %v", err)
+	}
+	g := &commitGraph{
+		fanout: chunks["OIDF"],
+		oids:   chunks["OIDL"],
+		data:   chunks["CDAT"],
+		edges:  chunks["EDGE"],
+	}
+	if len(g.fanout) != 256*4 {
+		return nil, fmt.Errorf("commit-graph is missing the OID fanout chunk")
+	}
+	if g.oids == nil || len(g.oids)%20 != 0 {
+		return nil, fmt.Errorf("commit-graph is missing the OID lookup chunk")
+	}
+	if len(g.data) != g.len()*36 {
+		return nil, fmt.Errorf("commit-graph is missing the commit data chunk")
+	}
+	if int(binary.BigEndian.Uint32(g.fanout[255*4:])) != g.len() {
+		return nil, fmt.Errorf("commit-graph OID fanout does not match the OID lookup")
+	}
+	return g, nil
+}
+
+// Returns the commit graph for c, loading it the first time. If there is no
+// commit graph, or it's corrupt, nil is returned and commits need to be read
+// from the object store.
+//
+// The commit graph isn't used in repositories with grafts or which are
+// shallow, or when replace refs are in use, since it records the parents
+// from the commit objects.
+func (c *Client) getCommitGraph() *commitGraph {
+	c.objectsMu.Lock()
+	defer c.objectsMu.Unlock()
+	if c.commitGraphLoaded {
+		return c.commitGraph
+	}
+	c.commitGraph, c.commitGraphLoaded = nil, true
+	if c.grafts == nil {
+		if err := c.loadGrafts(); err != nil {
+			return nil
+		}
+	}
+	if len(c.grafts) > 0 || c.GetConfig("core.commitGraph") == "false" {
+		return nil
+	}
+	if c.useReplaceRefs() {
+		if c.replaceRefs == nil {
+			if err := c.loadReplaceRefs(); err != nil {
+				return nil
+			}
+		}
+		if len(c.replaceRefs) > 0 {
+			return nil
+		}
+	}
+	content, err := ioutil.ReadFile(commitGraphFile(c).String())
+	if err != nil {
+		return nil
+	}
+	if g, err := parseCommitGraph(content); err == nil {
+		c.commitGraph = g
+	}
+	return c.commitGraph
+}
+
+// Returns the parents of cmt from the commit graph, or false if it isn't
+// in the graph.
+func (c *Client) graphParents(cmt CommitID) ([]CommitID, bool) {
+	g := c.getCommitGraph()
+	if g == nil {
+		return nil, false
+	}
+	pos, ok := g.lookup(cmt)
+	if !ok {
+		return nil, false
+	}
+	parents, err := g.parents(pos)
+	if err != nil {
+		return nil, false
+	}
+	return parents, true
+}
+
+// Returns the generation number of cmt from the commit graph, or false if
+// it isn't in the graph.
+func (c *Client) graphGeneration(cmt CommitID) (uint32, bool) {
+	g := c.getCommitGraph()
+	if g == nil {
+		return 0, false
+	}
+	pos, ok := g.lookup(cmt)
+	if !ok {
+		return 0, false
+	}
+	return g.generation(pos), true
+}
+
+// Reads the tree, parents and commit time directly from the commit object.
+// As in git, replace refs aren't followed, since the graph records the
+// commits as they're stored.
+func readGraphCommit(c *Client, cmt CommitID) (*graphCommit, error) {
+	o, err := c.readObject(Sha1(cmt), false)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := o.(GitCommitObject)
+	if !ok {
+		return nil, fmt.Errorf("Could not convert commit ID %v to commit object", cmt)
+	}
+	gc := &graphCommit{id: cmt}
+	headers := obj.GetContent()
+	if end := bytes.Index(headers, []byte("\n\n")); end >= 0 {
+		headers = headers[:end]
+	}
+	for _, line := range strings.Split(string(headers), "\n") {
+		switch {
+		case strings.HasPrefix(line, "tree "):
+			tree, err := Sha1FromString(strings.TrimPrefix(line, "tree "))
+			if err != nil {
+				return nil, err
+			}
+			gc.tree = TreeID(tree)
+		case strings.HasPrefix(line, "parent "):
+			parent, err := CommitIDFromString(strings.TrimPrefix(line, "parent "))
+			if err != nil {
+				return nil, err
+			}
+			gc.parents = append(gc.parents, parent)
+		case strings.HasPrefix(line, "committer "):
+			fields := strings.Fields(line)
+			if len(fields) >= 2 {
+				gc.time, _ = strconv.ParseInt(fields[len(fields)-2], 10, 64)
+			}
+		}
+	}
+	return gc, nil
+}
+
+// WriteCommitGraph writes a commit-graph file containing every commit
+// reachable from the references in c, replacing any existing one.
+func WriteCommitGraph(c *Client) error {
+	refs, err := ShowRef(c, ShowRefOptions{IncludeHead: true}, nil)
+	if err != nil {
+		return err
+	}
+	var stack []CommitID
+	for _, ref := range refs {
+		cmt, err := RevParseCommit(c, &RevParseOptions{}, ref.Name)
+		if err != nil {
+			// Not every reference points to a commit.
+			continue
+		}
+		stack = append(stack, cmt)
+	}
+	if head, err := c.GetHeadCommit(); err == nil {
+		stack = append(stack, head)
+	}
+
+	commits := make(map[CommitID]*graphCommit)
+	for len(stack) > 0 {
+		cmt := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := commits[cmt]; ok {
+			continue
+		}
+		gc, err := readGraphCommit(c, cmt)
+		if err != nil {
+			return err
+		}
+		commits[cmt] = gc
+		stack = append(stack, gc.parents...)
+	}
+
+	// Calculate generation numbers without recursion, since history
+	// can be arbitrarily deep.
+	for _, gc := range commits {
+		if gc.generation != 0 {
+			continue
+		}
+		stack := []*graphCommit{gc}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			var gen uint32 = 1
+			done := true
+			for _, p := range top.parents {
+				parent := commits[p]
+				if parent.generation == 0 {
+					stack = append(stack, parent)
+					done = false
+				} else if parent.generation+1 > gen {
+					gen = parent.generation + 1
+				}
+			}
+			if !done {
+				continue
+			}
+			if gen > graphGenerationMax {
+				gen = graphGenerationMax
+			}
+			top.generation = gen
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	sorted := make([]*graphCommit, 0, len(commits))
+	for _, gc := range commits {
+		sorted = append(sorted, gc)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].id[:], sorted[j].id[:]) < 0
+	})
+	positions := make(map[CommitID]uint32, len(sorted))
+	for i, gc := range sorted {
+		positions[gc.id] = uint32(i)
+	}
+
+	var oids, data, edges bytes.Buffer
+	ids := make([][]byte, len(sorted))
+	for i, gc := range sorted {
+		ids[i] = gc.id[:]
+	}
+	for _, gc := range sorted {
+		oids.Write(gc.id[:])
+
+		data.Write(gc.tree[:])
+		p1, p2 := uint32(graphParentNone), uint32(graphParentNone)
+		if len(gc.parents) > 0 {
+			p1 = positions[gc.parents[0]]
+		}
+		if len(gc.parents) == 2 {
+			p2 = positions[gc.parents[1]]
+		} else if len(gc.parents) > 2 {
+			p2 = graphOctopusFlag | uint32(edges.Len()/4)
+			for i, p := range gc.parents[1:] {
+				edge := positions[p]
+				if i == len(gc.parents)-2 {
+					edge |= graphOctopusFlag
+				}
+				binary.Write(&edges, binary.BigEndian, edge)
+			}
+		}
+		binary.Write(&data, binary.BigEndian, p1)
+		binary.Write(&data, binary.BigEndian, p2)
+		binary.Write(&data, binary.BigEndian, gc.generation<<2|uint32(gc.time>>32)&0x3)
+		binary.Write(&data, binary.BigEndian, uint32(gc.time))
+	}
+
+	chunks := []chunk{{"OIDF", writeOIDFanout(ids)}, {"OIDL", oids.Bytes()}, {"CDAT", data.Bytes()}}
+	if edges.Len() > 0 {
+		chunks = append(chunks, chunk{"EDGE", edges.Bytes()})
+	}
+	header := []byte(commitGraphSignature + "\x01\x01")
+	header = append(header, byte(len(chunks)), 0)
+	if err := replaceReadOnlyFile(commitGraphFile(c), "tmp_graph_", writeChunkFile(header, chunks)); err != nil {
+		return err
+	}
+	// Make sure the next lookup uses the new graph.
+	c.commitGraph, c.commitGraphLoaded = nil, false
+	return nil
+}
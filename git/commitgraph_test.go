@@ -0,0 +1,183 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestCommitGraphMergeBase(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcommitgraph")
+	defer cleanup()
+
+	// Creates a history that looks like
+	//
+	//   B---C---M---F
+	//  /       /
+	// A---D---E
+	//      \
+	//       G
+	//
+	// and an octopus merge O of C, E and G.
+	A := testCommitFile(t, c, "foo.txt", "foo\n", "A")
+	tree, err := A.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(msg string, parents ...CommitID) CommitID {
+		t.Helper()
+		cmt, err := CommitTree(c, CommitTreeOptions{}, tree, parents, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmt
+	}
+	B := commit("B", A)
+	C := commit("C", B)
+	D := commit("D", A)
+	E := commit("E", D)
+	G := commit("G", D)
+	M := commit("M", C, E)
+	O := commit("O", C, E, G)
+	for name, cmt := range map[string]CommitID{"master": M, "g": G, "octopus": O} {
+		if err := UpdateRefSpec(c, UpdateRefOptions{}, RefSpec("refs/heads/"+name), cmt, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all := []CommitID{A, B, C, D, E, G, M, O}
+	mergeBases := func() []string {
+		t.Helper()
+		var results []string
+		for _, x := range all {
+			for _, y := range all {
+				base, err := MergeBase(c, MergeBaseOptions{}, []Commitish{x, y})
+				if err != nil {
+					t.Fatal(err)
+				}
+				results = append(results, fmt.Sprintf("%v %v %v %v", x, y, base, x.IsAncestor(c, y)))
+			}
+		}
+		return results
+	}
+	without := mergeBases()
+
+	if err := WriteCommitGraph(c); err != nil {
+		t.Fatal(err)
+	}
+	g := c.getCommitGraph()
+	if g == nil {
+		t.Fatal("Commit graph was not loaded after being written")
+	}
+	if g.len() != len(all) {
+		t.Errorf("Unexpected number of commits in graph: got %v want %v", g.len(), len(all))
+	}
+	for _, cmt := range all {
+		obj, err := readGraphCommit(c, cmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parents, ok := c.graphParents(cmt)
+		if !ok {
+			t.Errorf("%v is not in the commit graph", cmt)
+			continue
+		}
+		if fmt.Sprint(parents) != fmt.Sprint(obj.parents) {
+			t.Errorf("Unexpected parents for %v: got %v want %v", cmt, parents, obj.parents)
+		}
+	}
+	if gen, _ := c.graphGeneration(M); gen != 4 {
+		t.Errorf("Unexpected generation number for M: got %v want 4", gen)
+	}
+
+	with := mergeBases()
+	for i := range without {
+		if without[i] != with[i] {
+			t.Errorf("Merge base changed with commit graph: got %v want %v", with[i], without[i])
+		}
+	}
+
+	// Commits newer than the graph need to fall back on the object
+	// store.
+	F := commit("F", M)
+	if _, ok := c.graphParents(F); ok {
+		t.Error("Commit which isn't in the graph was found in it")
+	}
+	if base, err := MergeBase(c, MergeBaseOptions{}, []Commitish{F, G}); err != nil {
+		t.Fatal(err)
+	} else if base != D {
+		t.Errorf("Unexpected merge base for commit newer than graph: got %v want %v", base, D)
+	}
+	if !E.IsAncestor(c, F) || G.IsAncestor(c, F) {
+		t.Error("Unexpected ancestry for commit newer than graph")
+	}
+
+	// A corrupt graph is ignored.
+	if err := os.Chmod(commitGraphFile(c).String(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GitDir.WriteFile("objects/info/commit-graph", []byte("CGPH garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.commitGraphLoaded = false
+	if c.getCommitGraph() != nil {
+		t.Error("Corrupt commit graph was loaded")
+	}
+}
+
+// Tests that the commit graph isn't used when replace refs change the
+// parents of a commit, and that it records the commits as they're stored.
+func TestCommitGraphReplace(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcommitgraphreplace")
+	defer cleanup()
+
+	C1 := testCommitFile(t, c, "foo.txt", "foo\n", "C1")
+	tree, err := C1.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(msg string, parents ...CommitID) CommitID {
+		t.Helper()
+		cmt, err := CommitTree(c, CommitTreeOptions{}, tree, parents, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmt
+	}
+	C2 := commit("C2", C1)
+	C3 := commit("C3", C2)
+	R := commit("R", C1)
+	if err := UpdateRefSpec(c, UpdateRefOptions{}, RefSpec("refs/heads/master"), C3, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCommitGraph(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplaceRef(c, Sha1(C3), Sha1(R)); err != nil {
+		t.Fatal(err)
+	}
+	if c.getCommitGraph() != nil {
+		t.Error("Commit graph was used with replace refs")
+	}
+	if parents, err := C3.Parents(c); err != nil {
+		t.Fatal(err)
+	} else if fmt.Sprint(parents) != fmt.Sprint([]CommitID{C1}) {
+		t.Errorf("Unexpected parents of replaced commit: got %v want %v", parents, []CommitID{C1})
+	}
+
+	// The graph written with the replace ref has the parents from the
+	// commit object, which are used when replace refs are disabled.
+	if err := WriteCommitGraph(c); err != nil {
+		t.Fatal(err)
+	}
+	c.NoReplaceObjects = true
+	c.commitGraphLoaded = false
+	if _, ok := c.graphParents(C3); !ok {
+		t.Fatal("Commit graph was not used without replace refs")
+	}
+	if parents, err := C3.Parents(c); err != nil {
+		t.Fatal(err)
+	} else if fmt.Sprint(parents) != fmt.Sprint([]CommitID{C2}) {
+		t.Errorf("Unexpected parents in commit graph: got %v want %v", parents, []CommitID{C2})
+	}
+}
@@ -12,8 +12,12 @@ import (
 
 type GPGKeyId string
 type CommitTreeOptions struct {
-	GPGKey GPGKeyId
+	// Sign the commit with GPGKey, or the default key if it's empty.
+	// Setting GPGKey implies GPGSign.
+	GPGSign bool
+	GPGKey  GPGKeyId
 
+	// Don't sign the commit even if commit.gpgSign is set.
 	NoGPGSign bool
 }
 
@@ -47,15 +51,14 @@ func parseDate(str string) (time.Time, error) {
 		utime, _ := strconv.Atoi(pieces[1])
 		t := time.Unix(int64(utime), 0)
 
-		// Take the hour of the timezone and convert it to seconds
-		// from UTC.
-		// FIXME: This should deal with half hour timezones
-		// properly.
-		tz, _ := strconv.Atoi(pieces[3][0:2])
+		// Take the hours and minutes of the timezone and convert
+		// them to seconds from UTC.
+		hours, _ := strconv.Atoi(pieces[3][0:2])
+		minutes, _ := strconv.Atoi(pieces[3][2:4])
+		tz := hours*60*60 + minutes*60
 		if pieces[2] == "-" {
 			tz *= -1
 		}
-		tz *= 60 * 60
 		zone := time.FixedZone(pieces[2]+pieces[3], tz)
 		return t.In(zone), nil
 	}
@@ -99,9 +102,22 @@ func CommitTree(c *Client, opts CommitTreeOptions, tree Treeish, parents []Commi
 	}
 
 	fmt.Fprintf(content, "author %s\n", author)
-	fmt.Fprintf(content, "committer %s\n\n", committer)
-	fmt.Fprintf(content, "%s", message)
-	sha1, err := c.WriteObject("commit", content.Bytes())
+	fmt.Fprintf(content, "committer %s\n", committer)
+	if enc := commitEncoding(c); enc != "" {
+		// As in git, the message is expected to already be in the
+		// encoding, so it's only labelled.
+		fmt.Fprintf(content, "encoding %s\n", enc)
+	}
+	fmt.Fprintf(content, "\n%s", message)
+	raw := content.Bytes()
+	if wantSign(c, opts.GPGSign, opts.NoGPGSign, opts.GPGKey, "commit.gpgSign") {
+		sig, err := SignBuffer(c, opts.GPGKey, raw)
+		if err != nil {
+			return CommitID{}, err
+		}
+		raw = addCommitSignature(raw, sig)
+	}
+	sha1, err := c.WriteObject("commit", raw)
 	if err != nil {
 		return CommitID(sha1), err
 	}
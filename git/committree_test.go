@@ -34,6 +34,34 @@ func TestParseDate(t *testing.T) {
 			"1514593165 -0500",
 			false,
 		},
+		{
+			// Timezones which aren't a whole number of hours
+			"Fri, 29 Dec 2017 19:19:25 +0530",
+			"1514555365 +0530",
+			false,
+		},
+		{
+			"Fri, 29 Dec 2017 19:19:25 -0330",
+			"1514587765 -0330",
+			false,
+		},
+		{
+			// Negative timezones of less than an hour keep
+			// their sign.
+			"Fri, 29 Dec 2017 19:19:25 -0030",
+			"1514576965 -0030",
+			false,
+		},
+		{
+			"1514593165 -0930",
+			"1514593165 -0930",
+			false,
+		},
+		{
+			"1514593165 +0545",
+			"1514593165 +0545",
+			false,
+		},
 	}
 
 	for i, tc := range tests {
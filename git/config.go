@@ -7,6 +7,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -18,7 +20,69 @@ type GitConfigValues map[string]string
 type GitConfigSection struct {
 	name, subsection string
 	values           GitConfigValues
+
+	// Every value set in the section, in the order that they're set in
+	// the file, including all of the values of a multi-valued key. values
+	// only has the last one of each key.
+	entries []configValue
+}
+
+// A key in a config section and a value that it's set to.
+type configValue struct {
+	key, value string
 }
+
+// A ConfigEntry is a variable set in a config file, and its value. Name is
+// the full name of the variable, with the section and key in lower case,
+// such as "remote.origin.fetch".
+type ConfigEntry struct {
+	Name, Value string
+}
+
+func (e ConfigEntry) String() string {
+	return e.Name + " " + e.Value
+}
+
+// Returns the name of the variable key in this section, in the canonical
+// form used by ConfigEntry.
+func (s GitConfigSection) canonicalName(key string) string {
+	if s.subsection != "" {
+		return strings.ToLower(s.name) + "." + s.subsection + "." + strings.ToLower(key)
+	}
+	return strings.ToLower(s.name) + "." + strings.ToLower(key)
+}
+
+// Sets key to value in the section, replacing its last value if it had
+// any. Keys are case insensitive, so the last value is replaced even if
+// it was set with a different case.
+func (s *GitConfigSection) set(key, value string) {
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if strings.EqualFold(s.entries[i].key, key) {
+			s.entries[i].value = value
+			s.values[s.entries[i].key] = value
+			return
+		}
+	}
+	s.values[key] = value
+	s.entries = append(s.entries, configValue{key, value})
+}
+
+// Removes every value of key from the section, ignoring case.
+func (s *GitConfigSection) unset(key string) {
+	for k := range s.values {
+		if strings.EqualFold(k, key) {
+			delete(s.values, k)
+		}
+	}
+	entries := s.entries[:0]
+	for _, e := range s.entries {
+		if !strings.EqualFold(e.key, key) {
+			entries = append(entries, e)
+		}
+	}
+	s.entries = entries
+}
+
 type GitConfig struct {
 	sections []GitConfigSection
 	fname    string
@@ -33,34 +97,32 @@ argChecker:
 	switch len(pieces) {
 	case 2:
 		key = strings.TrimSpace(pieces[1])
-		for _, section := range g.sections {
+		for i, section := range g.sections {
 			log.Printf("Comparing %s to %s\n", section.name, pieces[0])
-			if section.name == pieces[0] {
-				sec = &section
+			if strings.EqualFold(section.name, pieces[0]) && section.subsection == "" {
+				sec = &g.sections[i]
 				break argChecker
 			}
 		}
 		log.Printf("Couldn't find %s, creating\n", pieces[0])
-		section := GitConfigSection{pieces[0], "", make(map[string]string, 0)}
-		sec = &section
-		g.sections = append(g.sections, section)
+		g.sections = append(g.sections, GitConfigSection{name: pieces[0], values: make(map[string]string, 0)})
+		sec = &g.sections[len(g.sections)-1]
 	case 3:
 		key = strings.TrimSpace(pieces[2])
-		for _, section := range g.sections {
+		for i, section := range g.sections {
 			log.Printf("Comparing %s to %s and %s to %s\n", section.name, pieces[0], section.subsection, pieces[1])
-			if section.name == pieces[0] && section.subsection == pieces[1] {
-				sec = &section
+			if strings.EqualFold(section.name, pieces[0]) && section.subsection == pieces[1] {
+				sec = &g.sections[i]
 				break argChecker
 			}
 		}
 		log.Printf("Couldn't find %s %s, creating\n", pieces[0], pieces[1])
-		section := GitConfigSection{pieces[0], pieces[1], make(map[string]string, 0)}
-		sec = &section
-		g.sections = append(g.sections, section)
+		g.sections = append(g.sections, GitConfigSection{name: pieces[0], subsection: pieces[1], values: make(map[string]string, 0)})
+		sec = &g.sections[len(g.sections)-1]
 	}
 
 	if sec != nil {
-		sec.values[key] = value
+		sec.set(key, value)
 	} else {
 		// TODO Always auto-create the sections
 		log.Printf("Couldn't find section %v\n", name)
@@ -76,34 +138,37 @@ argChecker:
 	switch len(pieces) {
 	case 2:
 		key = strings.TrimSpace(pieces[1])
-		for _, section := range g.sections {
+		for i, section := range g.sections {
 			log.Printf("Comparing %s to %s\n", section.name, pieces[0])
-			if section.name == pieces[0] {
-				sec = &section
+			if strings.EqualFold(section.name, pieces[0]) && section.subsection == "" {
+				sec = &g.sections[i]
 				break argChecker
 			}
 		}
 	case 3:
 		key = strings.TrimSpace(pieces[2])
-		for _, section := range g.sections {
-			if section.name == pieces[0] && section.subsection == pieces[1] {
-				sec = &section
+		for i, section := range g.sections {
+			if strings.EqualFold(section.name, pieces[0]) && section.subsection == pieces[1] {
+				sec = &g.sections[i]
 				break argChecker
 			}
 		}
 	}
 
 	if sec != nil {
-		if _, ok := sec.values[key]; !ok {
+		if _, status := sec.get(key); status != 0 {
 			return 5
 		}
-		delete(sec.values, key)
+		sec.unset(key)
 		return 0
 	} else {
 		return 5
 	}
 }
 
+// GetConfig returns the value of the variable name, and 0, or 1 if it isn't
+// set. As in git, the section and key of the name are case insensitive, and
+// the subsection isn't.
 func (g *GitConfig) GetConfig(name string) (string, int) {
 
 	pieces := strings.Split(name, ".")
@@ -111,22 +176,14 @@ func (g *GitConfig) GetConfig(name string) (string, int) {
 	switch len(pieces) {
 	case 2:
 		for _, section := range g.sections {
-			if section.name == pieces[0] {
-				val, ok := section.values[pieces[1]]
-				if !ok {
-					return "", 1
-				}
-				return val, 0
+			if strings.EqualFold(section.name, pieces[0]) && section.subsection == "" {
+				return section.get(pieces[1])
 			}
 		}
 	case 3:
 		for _, section := range g.sections {
-			if section.name == pieces[0] && section.subsection == pieces[1] {
-				val, ok := section.values[pieces[2]]
-				if !ok {
-					return "", 1
-				}
-				return val, 0
+			if strings.EqualFold(section.name, pieces[0]) && section.subsection == pieces[1] {
+				return section.get(pieces[2])
 			}
 		}
 
@@ -135,15 +192,29 @@ func (g *GitConfig) GetConfig(name string) (string, int) {
 	return "", 1
 }
 
+// Returns the last value of key in the section, ignoring case, and 0, or 1
+// if it isn't set in the section.
+func (s GitConfigSection) get(key string) (string, int) {
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if strings.EqualFold(s.entries[i].key, key) {
+			return s.entries[i].value, 0
+		}
+	}
+	if val, ok := s.values[key]; ok {
+		return val, 0
+	}
+	return "", 1
+}
+
 func (g *GitConfig) GetConfigList() []string {
 	list := []string{}
 
 	for _, section := range g.sections {
-		for key, value := range section.values {
+		for _, e := range section.entries {
 			if section.subsection != "" {
-				list = append(list, section.name+"."+section.subsection+"."+key+"="+value)
+				list = append(list, section.name+"."+section.subsection+"."+e.key+"="+e.value)
 			} else {
-				list = append(list, section.name+"."+key+"="+value)
+				list = append(list, section.name+"."+e.key+"="+e.value)
 			}
 		}
 	}
@@ -151,12 +222,90 @@ func (g *GitConfig) GetConfigList() []string {
 	return list
 }
 
-// Gets all config sections that match name and subsection. The empty
-// string matches all names/subsections.
+// GetAll returns every value of the variable name, in the order that they
+// are set in, as "git config --get-all" does. If valuePattern isn't empty,
+// only the values which match it as a regular expression are returned, or
+// the values which don't if it starts with "!".
+func (g *GitConfig) GetAll(name, valuePattern string) ([]string, error) {
+	match, err := configValueMatcher(valuePattern)
+	if err != nil {
+		return nil, err
+	}
+	dot, lastDot := strings.Index(name, "."), strings.LastIndex(name, ".")
+	if dot < 0 || lastDot == len(name)-1 {
+		return nil, fmt.Errorf("key does not contain a section: %v", name)
+	}
+	secname, key := name[:dot], name[lastDot+1:]
+	var subsection string
+	if dot != lastDot {
+		subsection = name[dot+1 : lastDot]
+	}
+
+	var values []string
+	for _, section := range g.sections {
+		if !strings.EqualFold(section.name, secname) || section.subsection != subsection {
+			continue
+		}
+		for _, e := range section.entries {
+			if strings.EqualFold(e.key, key) && match(e.value) {
+				values = append(values, e.value)
+			}
+		}
+	}
+	return values, nil
+}
+
+// GetRegexp returns the variables whose names match the regular expression
+// sectionKeyPattern, and their values, in the order that they are set in,
+// as "git config --get-regexp" does. The pattern is matched against the
+// canonical form of the names, which have a lower case section and key.
+// valuePattern filters the values in the same way as with GetAll.
+func (g *GitConfig) GetRegexp(sectionKeyPattern, valuePattern string) ([]ConfigEntry, error) {
+	re, err := regexp.Compile(sectionKeyPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key pattern: %v", err)
+	}
+	match, err := configValueMatcher(valuePattern)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ConfigEntry
+	for _, section := range g.sections {
+		for _, e := range section.entries {
+			name := section.canonicalName(e.key)
+			if re.MatchString(name) && match(e.value) {
+				entries = append(entries, ConfigEntry{name, e.value})
+			}
+		}
+	}
+	return entries, nil
+}
+
+// Returns a function which reports whether a value matches the value
+// pattern of "git config --get-all" and "--get-regexp". An empty pattern matches everything.
+func configValueMatcher(pattern string) (func(string) bool, error) {
+	if pattern == "" {
+		return func(string) bool { return true }, nil
+	}
+	negate := strings.HasPrefix(pattern, "!")
+	if negate {
+		pattern = pattern[1:]
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+	return func(value string) bool {
+		return re.MatchString(value) != negate
+	}, nil
+}
+
+// Gets all config sections that match name, ignoring case, and subsection.
+// The empty string matches all names/subsections.
 func (g *GitConfig) GetConfigSections(name, subsection string) []GitConfigSection {
 	matches := make([]GitConfigSection, 0, len(g.sections))
 	for _, sect := range g.sections {
-		if name != "" && sect.name != name {
+		if name != "" && !strings.EqualFold(sect.name, name) {
 			continue
 		}
 		if subsection != "" && subsection != sect.subsection {
@@ -175,8 +324,8 @@ func (g GitConfig) WriteFile(w io.Writer) {
 			fmt.Fprintf(w, "[%s \"%s\"]\n", section.name, section.subsection)
 		}
 
-		for key, value := range section.values {
-			fmt.Fprintf(w, "\t%s = %s\n", key, value)
+		for _, e := range section.entries {
+			fmt.Fprintf(w, "\t%s = %s\n", e.key, e.value)
 		}
 
 	}
@@ -187,7 +336,7 @@ func (s *GitConfigSection) ParseValues(valueslines string) {
 
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
-		if trimmed == "" {
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';' {
 			continue
 		}
 		split := strings.Split(trimmed, "=")
@@ -197,7 +346,9 @@ func (s *GitConfigSection) ParseValues(valueslines string) {
 		varname := strings.TrimSpace(split[0])
 
 		log.Printf("Parsed config variable %v\n", varname)
-		s.values[varname] = strings.TrimSpace(strings.Join(split[1:], "="))
+		value := strings.TrimSpace(strings.Join(split[1:], "="))
+		s.values[varname] = value
+		s.entries = append(s.entries, configValue{varname, value})
 
 	}
 }
@@ -228,11 +379,16 @@ func (s *GitConfigSection) ParseSectionHeader(headerline string) {
 }
 
 func ParseConfig(configFile io.Reader) GitConfig {
+	return GitConfig{sections: mergeConfigSections(nil, parseConfigSections(configFile))}
+}
+
+// Parses the sections of a config file in the order that they're in the
+// file, without merging the sections which have the same name.
+func parseConfigSections(configFile io.Reader) []GitConfigSection {
 	rawdata, _ := ioutil.ReadAll(configFile)
 	section := &GitConfigSection{}
 	parsingSectionName := false
 	parsingValues := false
-	var sect *GitConfigSection = nil
 	var sections []GitConfigSection
 	lastBracket := 0
 	lastClosingBracket := 0
@@ -245,46 +401,43 @@ func ParseConfig(configFile io.Reader) GitConfig {
 			if parsingValues == true {
 				section.ParseValues(string(rawdata[lastClosingBracket+1 : idx]))
 				parsingValues = false
-
-				if sect != nil {
-					for k, v := range section.values {
-						sect.values[k] = v
-					}
-					sect = nil
-				} else {
-					sections = append(sections, *section)
-				}
+				sections = append(sections, *section)
 			}
 			section = &GitConfigSection{}
 		}
 		if b == ']' && parsingSectionName == true {
 			section.ParseSectionHeader(string(rawdata[lastBracket+1 : idx]))
-
-			for _, s := range sections {
-				if s.name == section.name && s.subsection == section.subsection {
-					sect = &s
-					break
-				}
-			}
-
 			parsingValues = true
 			parsingSectionName = false
 			lastClosingBracket = idx
 		}
 		if idx == len(rawdata)-1 && parsingValues == true {
 			section.ParseValues(string(rawdata[lastClosingBracket+1 : idx]))
+			sections = append(sections, *section)
+		}
+	}
+	return sections
+}
 
-			if sect != nil {
+// Merges the values of each of the sections into the section of sections
+// with the same name, or appends it if there isn't one, so that the later
+// values of a key take precedence. As in git, section names which only
+// differ in case are the same section, and subsections are case sensitive.
+func mergeConfigSections(sections, add []GitConfigSection) []GitConfigSection {
+add:
+	for _, section := range add {
+		for i, s := range sections {
+			if strings.EqualFold(s.name, section.name) && s.subsection == section.subsection {
 				for k, v := range section.values {
-					sect.values[k] = v
+					sections[i].values[k] = v
 				}
-				sect = nil
-			} else {
-				sections = append(sections, *section)
+				sections[i].entries = append(sections[i].entries, section.entries...)
+				continue add
 			}
 		}
+		sections = append(sections, section)
 	}
-	return GitConfig{sections: sections}
+	return sections
 }
 
 func findGlobalConfigFile() (string, error) {
@@ -350,3 +503,28 @@ func (g GitConfig) WriteConfig() error {
 	g.WriteFile(configFile)
 	return configFile.Close()
 }
+
+// Parses a config value which is a number of bytes, optionally with a k, m
+// or g suffix for kibibytes, mebibytes or gibibytes.
+func parseConfigSize(val string) (int64, error) {
+	if val == "" {
+		return 0, fmt.Errorf("Invalid size %q", val)
+	}
+	var scale int64 = 1
+	switch strings.ToLower(val[len(val)-1:]) {
+	case "k":
+		scale = 1 << 10
+	case "m":
+		scale = 1 << 20
+	case "g":
+		scale = 1 << 30
+	}
+	if scale != 1 {
+		val = val[:len(val)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size %q: %v", val, err)
+	}
+	return n * scale, nil
+}
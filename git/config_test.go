@@ -0,0 +1,231 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMultiConfig = `[core]
+	bare = false
+[remote "origin"]
+	url = https://example.com/origin.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[Remote "Upstream"]
+	URL = https://example.com/upstream.git
+	fetch = +refs/heads/master:refs/remotes/upstream/master
+[branch "master"]
+	remote = origin
+`
+
+// Tests that GetRegexp returns the variables of every section which match,
+// in order and with their canonical names.
+func TestConfigGetRegexp(t *testing.T) {
+	config := ParseConfig(strings.NewReader(testMultiConfig))
+	tests := []struct {
+		pattern, valuePattern string
+		want                  []ConfigEntry
+	}{
+		{`\.fetch$`, "", []ConfigEntry{
+			{"remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"},
+			{"remote.origin.fetch", "+refs/tags/*:refs/tags/*"},
+			{"remote.Upstream.fetch", "+refs/heads/master:refs/remotes/upstream/master"},
+		}},
+		{`\.fetch$`, "tags", []ConfigEntry{
+			{"remote.origin.fetch", "+refs/tags/*:refs/tags/*"},
+		}},
+		{`\.fetch$`, "!tags", []ConfigEntry{
+			{"remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"},
+			{"remote.Upstream.fetch", "+refs/heads/master:refs/remotes/upstream/master"},
+		}},
+		{`url|^branch\.`, "", []ConfigEntry{
+			{"remote.origin.url", "https://example.com/origin.git"},
+			{"remote.Upstream.url", "https://example.com/upstream.git"},
+			{"branch.master.remote", "origin"},
+		}},
+		{`^nothing\.`, "", nil},
+	}
+	for _, tc := range tests {
+		got, err := config.GetRegexp(tc.pattern, tc.valuePattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%v %v: got %v want %v", tc.pattern, tc.valuePattern, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%v %v entry %d: got %v want %v", tc.pattern, tc.valuePattern, i, got[i], tc.want[i])
+			}
+		}
+	}
+	if _, err := config.GetRegexp("(", ""); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+	if _, err := config.GetRegexp("fetch", "("); err == nil {
+		t.Error("Expected an error for an invalid value pattern")
+	}
+}
+
+// Tests that GetAll returns every value of a multi-valued key in order,
+// filtered by the value pattern.
+func TestConfigGetAll(t *testing.T) {
+	config := ParseConfig(strings.NewReader(testMultiConfig))
+	tests := []struct {
+		name, valuePattern string
+		want               []string
+	}{
+		{"remote.origin.fetch", "", []string{"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"}},
+		{"remote.origin.fetch", "tags", []string{"+refs/tags/*:refs/tags/*"}},
+		{"remote.origin.fetch", "!tags", []string{"+refs/heads/*:refs/remotes/origin/*"}},
+		{"REMOTE.Upstream.Url", "", []string{"https://example.com/upstream.git"}},
+		{"remote.upstream.url", "", nil},
+	}
+	for _, tc := range tests {
+		got, err := config.GetAll(tc.name, tc.valuePattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, "\n") != strings.Join(tc.want, "\n") || len(got) != len(tc.want) {
+			t.Errorf("%v %v: got %q want %q", tc.name, tc.valuePattern, got, tc.want)
+		}
+	}
+
+	// Setting the key replaces its last value, and the rest are kept.
+	config.SetConfig("remote.origin.fetch", "+refs/notes/*:refs/notes/*")
+	got, err := config.GetAll("remote.origin.fetch", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"+refs/heads/*:refs/remotes/origin/*", "+refs/notes/*:refs/notes/*"}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected values after setting: got %q want %q", got, want)
+	}
+}
+
+// Tests that section names and keys are case insensitive and subsections
+// aren't, and that sections whose names only differ in case are merged.
+func TestConfigCaseInsensitive(t *testing.T) {
+	config := ParseConfig(strings.NewReader(`[receive]
+	denyCurrentBranch = refuse
+[Receive]
+	DENYNONFASTFORWARDS = true
+	denycurrentbranch = ignore
+[branch "Master"]
+	remote = origin
+`))
+	tests := []struct {
+		name, want string
+		status     int
+	}{
+		{"receive.denyCurrentBranch", "ignore", 0},
+		{"RECEIVE.denyNonFastForwards", "true", 0},
+		{"branch.Master.remote", "origin", 0},
+		{"BRANCH.Master.Remote", "origin", 0},
+		{"branch.master.remote", "", 1},
+	}
+	for _, tc := range tests {
+		if got, status := config.GetConfig(tc.name); got != tc.want || status != tc.status {
+			t.Errorf("%v: got %q (%v) want %q (%v)", tc.name, got, status, tc.want, tc.status)
+		}
+	}
+	if sections := config.GetConfigSections("receive", ""); len(sections) != 1 {
+		t.Errorf("Sections differing in case were not merged: %v", sections)
+	}
+	got, err := config.GetAll("receive.denycurrentbranch", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"refuse", "ignore"}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected values from merged sections: got %q want %q", got, want)
+	}
+
+	// Setting or unsetting a key in a different case changes the
+	// existing section and value.
+	config.SetConfig("Receive.DenyCurrentBranch", "warn")
+	if got, _ := config.GetConfig("receive.denycurrentbranch"); got != "warn" {
+		t.Errorf("Unexpected value after setting: got %q want %q", got, "warn")
+	}
+	if sections := config.GetConfigSections("receive", ""); len(sections) != 1 {
+		t.Errorf("Setting a key created another section: %v", sections)
+	}
+	if status := config.Unset("RECEIVE.denynonfastforwards"); status != 0 {
+		t.Errorf("Unexpected status unsetting a key: %v", status)
+	}
+	if _, status := config.GetConfig("receive.denyNonFastForwards"); status != 1 {
+		t.Error("Key was not unset")
+	}
+}
+
+// Tests that the includeIf sections whose gitdir: or onbranch: condition
+// matches the repository are included in place, and the others aren't.
+func TestConfigIncludeIf(t *testing.T) {
+	c, cleanup := testRepo(t, "gitconfiginclude")
+	defer cleanup()
+
+	gitdir, err := filepath.Abs(c.GitDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitdir = filepath.ToSlash(gitdir)
+	dir := filepath.Dir(gitdir)
+	for name, content := range map[string]string{
+		"work.inc":   "[user]\n\temail = work@example.com\n",
+		"other.inc":  "[user]\n\temail = other@example.com\n\tname = Other\n",
+		"branch.inc": "[core]\n\tpager = branch\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := fmt.Sprintf(`[user]
+	email = local@example.com
+	name = Local
+[includeIf "gitdir:%v/"]
+	path = ../work.inc
+[includeIf "gitdir:/nonexistent/"]
+	path = ../other.inc
+[includeIf "gitdir/i:%v"]
+	path = ../branch.inc
+[includeIf "onbranch:topic/**"]
+	path = ../other.inc
+`, dir, strings.ToUpper(gitdir))
+	f, err := os.OpenFile(c.GitDir.File("config").String(), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new client, so that the config is read again.
+	c, err = NewClient(c.GitDir.String(), c.WorkDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"user.email": "work@example.com",
+		"user.name":  "Local",
+		"core.pager": "branch",
+	} {
+		if got := c.GetConfig(key); got != want {
+			t.Errorf("Unexpected value of %v: got %q want %q", key, got, want)
+		}
+	}
+
+	// The values aren't written back to the config file.
+	local, err := LoadLocalConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := local.GetConfig("user.email"); got != "local@example.com" {
+		t.Errorf("Unexpected user.email without includes: got %q", got)
+	}
+}
@@ -0,0 +1,196 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The maximum depth of included config files, as in git, so that an
+// include loop is an error.
+const maxConfigIncludeDepth = 10
+
+// WithIncludes returns the config read again from its file, with the files
+// which it includes with include.path, and with the includeIf.<condition>.path
+// of the conditions that apply to c, loaded in place. The values of an
+// included file take precedence over the ones before the include, and the
+// ones after it take precedence over the included ones.
+//
+// The result is for reading the config, and shouldn't be written back to
+// the file since the included values would be written with it.
+func (g GitConfig) WithIncludes(c *Client) (GitConfig, error) {
+	if g.fname == "" {
+		return g, nil
+	}
+	sections, err := readConfigIncludes(c, g.fname, 0)
+	if err != nil {
+		return GitConfig{}, err
+	}
+	return GitConfig{sections: mergeConfigSections(nil, sections), fname: g.fname}, nil
+}
+
+// Returns the sections of the config file fname with the files that it
+// includes in place. A file which doesn't exist has no sections.
+func readConfigIncludes(c *Client, fname string, depth int) ([]GitConfigSection, error) {
+	if depth > maxConfigIncludeDepth {
+		return nil, fmt.Errorf("exceeded maximum include depth (%d) while including %v", maxConfigIncludeDepth, fname)
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	raw := parseConfigSections(f)
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	var sections []GitConfigSection
+	for _, section := range raw {
+		sections = append(sections, section)
+		if !configIncludeApplies(c, section, fname) {
+			continue
+		}
+		for _, e := range section.entries {
+			if !strings.EqualFold(e.key, "path") || e.value == "" {
+				continue
+			}
+			path := expandConfigPath(e.value)
+			if !filepath.IsAbs(path) {
+				// Relative paths are relative to the file that
+				// includes them.
+				path = filepath.Join(filepath.Dir(fname), path)
+			}
+			included, err := readConfigIncludes(c, path, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			sections = append(sections, included...)
+		}
+	}
+	return sections, nil
+}
+
+// Expands a leading "~/" in a path in a config file to the home directory.
+func expandConfigPath(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home := os.Getenv("HOME"); home != "" {
+			return strings.TrimSuffix(home, "/") + path[1:]
+		}
+	}
+	return path
+}
+
+// Returns true if section is an include section whose paths should be
+// loaded into the config file fname.
+func configIncludeApplies(c *Client, section GitConfigSection, fname string) bool {
+	switch strings.ToLower(section.name) {
+	case "include":
+		return section.subsection == ""
+	case "includeif":
+	default:
+		return false
+	}
+
+	cond := section.subsection
+	switch {
+	case strings.HasPrefix(cond, "gitdir:"):
+		return includeByGitDir(c, cond[len("gitdir:"):], fname, false)
+	case strings.HasPrefix(cond, "gitdir/i:"):
+		return includeByGitDir(c, cond[len("gitdir/i:"):], fname, true)
+	case strings.HasPrefix(cond, "onbranch:"):
+		return includeByBranch(c, cond[len("onbranch:"):])
+	}
+	// Conditions which aren't known never apply.
+	return false
+}
+
+// Returns true if the git directory of c matches the gitdir: condition
+// pattern of an includeIf section in the config file fname.
+func includeByGitDir(c *Client, pattern, fname string, icase bool) bool {
+	if c == nil || pattern == "" {
+		return false
+	}
+	// A directory matches everything in it.
+	dir := strings.HasSuffix(pattern, "/")
+	switch {
+	case strings.HasPrefix(pattern, "~/"):
+		pattern = filepath.ToSlash(expandConfigPath(pattern))
+	case strings.HasPrefix(pattern, "./"):
+		// Relative to the directory of the config file.
+		pattern = filepath.ToSlash(filepath.Dir(fname)) + pattern[1:]
+	case !filepath.IsAbs(pattern) && !strings.HasPrefix(pattern, "**/"):
+		pattern = "**/" + pattern
+	}
+	if dir {
+		pattern = strings.TrimSuffix(pattern, "/") + "/**"
+	}
+
+	gitdir, err := filepath.Abs(c.GitDir.String())
+	if err != nil {
+		return false
+	}
+	// The path is matched both as it is and with its symlinks resolved.
+	dirs := []string{gitdir}
+	if real, err := filepath.EvalSymlinks(gitdir); err == nil && real != gitdir {
+		dirs = append(dirs, real)
+	}
+	for _, dir := range dirs {
+		dir = filepath.ToSlash(dir)
+		if icase {
+			if includeMatch(strings.ToLower(pattern), strings.ToLower(dir)) {
+				return true
+			}
+		} else if includeMatch(pattern, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the branch checked out in c matches the onbranch:
+// condition pattern of an includeIf section.
+func includeByBranch(c *Client, pattern string) bool {
+	if c == nil || pattern == "" {
+		return false
+	}
+	branch := c.GetHeadBranch().BranchName()
+	if branch == "" {
+		return false
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return includeMatch(pattern, branch)
+}
+
+// Returns true if the whole of path matches the glob pattern of an
+// includeIf condition, where a "**" component matches any number of
+// components and the other wildcards don't match a "/".
+func includeMatch(pattern, path string) bool {
+	return includeMatchComponents(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+func includeMatchComponents(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if includeMatchComponents(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	if m, err := filepath.Match(pattern[0], path[0]); err != nil || !m {
+		return false
+	}
+	return includeMatchComponents(pattern[1:], path[1:])
+}
@@ -0,0 +1,252 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A credential is a username and password for a URL, in the form that it's
+// exchanged with credential helpers. See gitcredentials(7).
+type credential struct {
+	protocol, host, path string
+	username, password   string
+
+	// The URL that credential.<url>.* config is matched against,
+	// including the path even if it's not sent to helpers.
+	configurl string
+}
+
+// Returns the credential for rawurl, with the username filled in from the
+// URL or the credential.<url>.username config. The path is only included if
+// credential.useHttpPath is set, as in git.
+func newCredential(c *Client, rawurl string) (credential, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return credential{}, err
+	}
+	cred := credential{
+		protocol: u.Scheme,
+		host:     u.Host,
+		username: u.User.Username(),
+
+		configurl: (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String(),
+	}
+	if password, ok := u.User.Password(); ok {
+		cred.password = password
+	}
+	if c.GetURLConfig("credential", "useHttpPath", rawurl) == "true" {
+		cred.path = strings.TrimPrefix(u.Path, "/")
+	}
+	if cred.username == "" {
+		cred.username = c.GetURLConfig("credential", "username", rawurl)
+	}
+	return cred, nil
+}
+
+// Returns the URL that the credential is for, without any username or
+// password.
+func (cred credential) url() string {
+	u := url.URL{Scheme: cred.protocol, Host: cred.host}
+	if cred.path != "" {
+		u.Path = "/" + cred.path
+	}
+	return u.String()
+}
+
+// Writes the credential in the format that's sent to credential helpers.
+func (cred credential) write(w io.Writer) error {
+	for _, attr := range []struct{ key, val string }{
+		{"protocol", cred.protocol},
+		{"host", cred.host},
+		{"path", cred.path},
+		{"username", cred.username},
+		{"password", cred.password},
+	} {
+		if attr.val == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%v=%v\n", attr.key, attr.val); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// Reads the attributes that a credential helper printed, replacing the
+// ones in cred. It returns true if the helper asked for no other helpers
+// to be consulted.
+func (cred *credential) read(r io.Reader) (quit bool, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return false, fmt.Errorf("Invalid credential line: %v", line)
+		}
+		val := line[eq+1:]
+		switch line[:eq] {
+		case "protocol":
+			cred.protocol = val
+		case "host":
+			cred.host = val
+		case "path":
+			cred.path = val
+		case "username":
+			cred.username = val
+		case "password":
+			cred.password = val
+		case "quit":
+			quit = val == "true" || val == "1"
+		}
+	}
+	return quit, scanner.Err()
+}
+
+// Runs a credential.helper for cred with the action "get", "store" or
+// "erase". A helper starting with "!" is a shell command, an absolute
+// path is run directly, and anything else is the name of a
+// git-credential-<helper> program.
+func runCredentialHelper(helper, action string, cred credential) (credential, bool, error) {
+	var cmdline string
+	switch {
+	case strings.HasPrefix(helper, "!"):
+		cmdline = helper[1:]
+	case filepath.IsAbs(helper):
+		cmdline = helper
+	default:
+		cmdline = "git credential-" + helper
+	}
+	cmd := exec.Command("sh", "-c", cmdline+" "+action)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return cred, false, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return cred, false, err
+	}
+	if err := cmd.Start(); err != nil {
+		return cred, false, err
+	}
+	werr := cred.write(stdin)
+	stdin.Close()
+	var quit bool
+	var rerr error
+	if action == "get" {
+		quit, rerr = cred.read(stdout)
+	}
+	io.Copy(ioutil.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return cred, false, err
+	}
+	if werr != nil {
+		return cred, false, werr
+	}
+	return cred, quit, rerr
+}
+
+// Asks the user for a value with prompt by running an askpass program.
+func askpass(program, prompt string) (string, error) {
+	out, err := exec.Command(program, prompt).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// Fills in the username and password of cred, in the same order as git:
+// from each of the configured credential helpers until one fills them in
+// or says to quit, then the user's .netrc file, then GIT_ASKPASS,
+// core.askPass or SSH_ASKPASS, and finally by prompting on the terminal
+// unless GIT_TERMINAL_PROMPT is 0.
+func fillCredential(c *Client, cred credential) (credential, error) {
+	if cred.username != "" && cred.password != "" {
+		return cred, nil
+	}
+	for _, helper := range c.GetURLConfigAll("credential", "helper", cred.configurl) {
+		filled, quit, err := runCredentialHelper(helper, "get", cred)
+		if err != nil {
+			// As in git, a broken helper isn't fatal.
+			log.Printf("credential helper %v failed: %v\n", helper, err)
+			continue
+		}
+		cred = filled
+		if cred.username != "" && cred.password != "" {
+			return cred, nil
+		}
+		if quit {
+			return cred, fmt.Errorf("credential helper %v told us to quit", helper)
+		}
+	}
+
+	if netrcCredential(&cred) {
+		return cred, nil
+	}
+
+	program := os.Getenv("GIT_ASKPASS")
+	if program == "" {
+		program = c.GetConfig("core.askPass")
+	}
+	if program == "" {
+		program = os.Getenv("SSH_ASKPASS")
+	}
+	if program != "" {
+		var err error
+		if cred.username == "" {
+			if cred.username, err = askpass(program, fmt.Sprintf("Username for '%v': ", cred.url())); err != nil {
+				return cred, err
+			}
+		}
+		u := url.URL{Scheme: cred.protocol, Host: cred.host, User: url.User(cred.username)}
+		if cred.password, err = askpass(program, fmt.Sprintf("Password for '%v': ", u.String())); err != nil {
+			return cred, err
+		}
+		return cred, nil
+	}
+
+	if os.Getenv("GIT_TERMINAL_PROMPT") == "0" {
+		return cred, fmt.Errorf("could not read Username for '%v': terminal prompts disabled", cred.url())
+	}
+	userpass, err := getUserPassword(cred.url())
+	if err != nil {
+		return cred, err
+	}
+	cred.username, cred.password = userpass.user, userpass.password
+	return cred, nil
+}
+
+// Tells the credential helpers that cred worked, so that it may be stored.
+func approveCredential(c *Client, cred credential) error {
+	var firstErr error
+	for _, helper := range c.GetURLConfigAll("credential", "helper", cred.configurl) {
+		if _, _, err := runCredentialHelper(helper, "store", cred); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Tells the credential helpers that cred was rejected, so that it may be
+// erased.
+func rejectCredential(c *Client, cred credential) error {
+	var firstErr error
+	for _, helper := range c.GetURLConfigAll("credential", "helper", cred.configurl) {
+		if _, _, err := runCredentialHelper(helper, "erase", cred); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,219 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Serves a version 1 ref advertisement for /repo.git to clients which
+// authenticate as user with password.
+func testAuthServer(t *testing.T, user, password string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(testRefsHandler(user, password))
+}
+
+// Returns a handler which serves a version 1 ref advertisement for
+// /repo.git. If user is not empty, clients must authenticate as user with
+// password.
+func testRefsHandler(user, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); user != "" && (!ok || u != user || p != password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/repo.git/info/refs" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		for _, line := range []string{
+			"# service=git-upload-pack\n",
+			"",
+			"8a3f2cc2f7a5c7e5b5e2a3d4e6b3f3d1de40f8a1 refs/heads/master\000multi_ack agent=git/2.30.0\n",
+			"",
+		} {
+			if line == "" {
+				fmt.Fprint(w, "0000")
+				continue
+			}
+			fmt.Fprintf(w, "%04x%s", len(line)+4, line)
+		}
+	})
+}
+
+func TestCredentialHelper(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcredential")
+	defer cleanup()
+	ts := testAuthServer(t, "alice", "secret")
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "gitcredentialhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	logFile := filepath.Join(dir, "log")
+	helper := filepath.Join(dir, "helper")
+	script := fmt.Sprintf(`#!/bin/sh
+cat > /dev/null
+echo "$1" >> %v
+if [ "$1" = get ]; then
+	echo username=alice
+	echo "password=$(cat %v)"
+fi
+`, logFile, passwordFile)
+	if err := ioutil.WriteFile(helper, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The helper is only configured for the test server's URL.
+	config, err := os.OpenFile(c.GitDir.File("config").String(), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(config, "[credential \"%v\"]\n\thelper = %v\n", ts.URL, helper)
+	config.Close()
+	c, err = NewClient(c.GitDir.String(), c.WorkDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, env := range []string{"GIT_ASKPASS", "SSH_ASKPASS"} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Unsetenv(env)
+	}
+	defer os.Setenv("GIT_TERMINAL_PROMPT", os.Getenv("GIT_TERMINAL_PROMPT"))
+	os.Setenv("GIT_TERMINAL_PROMPT", "0")
+
+	expectLog := func(want ...string) {
+		t.Helper()
+		got, err := ioutil.ReadFile(logFile)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if strings.Join(want, "") != string(got) {
+			t.Errorf("Unexpected credential helper calls: got %q want %q", got, want)
+		}
+		os.Remove(logFile)
+	}
+
+	// Credentials which are rejected by the server are erased.
+	if err := ioutil.WriteFile(passwordFile, []byte("wrong\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LsRemote(c, LsRemoteOptions{}, Remote(ts.URL+"/repo.git"), nil); err == nil {
+		t.Error("Authenticated with the wrong password")
+	}
+	expectLog("get\n", "erase\n")
+
+	// Credentials which are accepted are stored.
+	if err := ioutil.WriteFile(passwordFile, []byte("secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	refs, err := LsRemote(c, LsRemoteOptions{}, Remote(ts.URL+"/repo.git"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Name != "refs/heads/master" {
+		t.Errorf("Unexpected refs: %v", refs)
+	}
+	expectLog("get\n", "store\n")
+
+	// The helper isn't used for other URLs, and with no other way to get
+	// credentials it fails without prompting.
+	other := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	if _, err := LsRemote(c, LsRemoteOptions{}, Remote(other+"/repo.git"), nil); err == nil || !strings.Contains(err.Error(), "terminal prompts disabled") {
+		t.Errorf("Unexpected error for URL without a helper: %v", err)
+	}
+	expectLog()
+}
+
+// Tests that every configured credential helper is asked in order until one
+// of them fills in the credentials or says to quit, and that they're all
+// told whether the credentials worked.
+func TestCredentialHelpers(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcredentialhelpers")
+	defer cleanup()
+	ts := testAuthServer(t, "alice", "secret")
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "gitcredentialhelpers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "log")
+	helper := func(name, output string) string {
+		path := filepath.Join(dir, name)
+		script := fmt.Sprintf(`#!/bin/sh
+cat > /dev/null
+echo "%v $1" >> %v
+if [ "$1" = get ]; then
+	printf '%v'
+fi
+`, name, logFile, output)
+		if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	unused := helper("unused", "username=mallory\\npassword=wrong\\n")
+	user := helper("user", "username=alice\\n")
+	password := helper("password", "password=secret\\n")
+	quit := helper("quit", "quit=true\\n")
+	last := helper("last", "username=alice\\npassword=secret\\n")
+
+	for _, env := range []string{"GIT_ASKPASS", "SSH_ASKPASS"} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Unsetenv(env)
+	}
+	defer os.Setenv("GIT_TERMINAL_PROMPT", os.Getenv("GIT_TERMINAL_PROMPT"))
+	os.Setenv("GIT_TERMINAL_PROMPT", "0")
+
+	expectLog := func(want ...string) {
+		t.Helper()
+		got, err := ioutil.ReadFile(logFile)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if strings.Join(want, "") != string(got) {
+			t.Errorf("Unexpected credential helper calls: got %q want %q", got, want)
+		}
+		os.Remove(logFile)
+	}
+	setHelpers := func(config string) {
+		t.Helper()
+		if err := c.GitDir.WriteFile("config", []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if c, err = NewClient(c.GitDir.String(), c.WorkDir.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The empty helper clears the one before it, and the helpers for the
+	// URL and those for every URL are asked in the order they're set in.
+	// The last helper isn't needed, but is still told to store the
+	// credentials.
+	setHelpers(fmt.Sprintf("[credential]\n\thelper = %v\n\thelper =\n\thelper = %v\n[credential \"%v\"]\n\thelper = %v\n\thelper = %v\n", unused, user, ts.URL, password, last))
+	if _, err := LsRemote(c, LsRemoteOptions{}, Remote(ts.URL+"/repo.git"), nil); err != nil {
+		t.Fatal(err)
+	}
+	expectLog("user get\n", "password get\n", "user store\n", "password store\n", "last store\n")
+
+	// A helper which says to quit stops the ones after it from being
+	// asked.
+	setHelpers(fmt.Sprintf("[credential]\n\thelper = %v\n\thelper = %v\n\thelper = %v\n", user, quit, last))
+	if _, err := LsRemote(c, LsRemoteOptions{}, Remote(ts.URL+"/repo.git"), nil); err == nil || !strings.Contains(err.Error(), "told us to quit") {
+		t.Errorf("Unexpected error after a helper quit: %v", err)
+	}
+	expectLog("user get\n", "quit get\n")
+}
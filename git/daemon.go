@@ -0,0 +1,139 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DaemonOptions are the options for Serve, corresponding to the options
+// of "git daemon".
+type DaemonOptions struct {
+	// The directory which the paths of the repositories requested are
+	// relative to.
+	BasePath string
+
+	// Serve every repository rather than only the ones which contain a
+	// git-daemon-export-ok file.
+	ExportAll bool
+
+	// Allow pushing to the repositories with receive-pack. Only
+	// fetching is allowed by default.
+	ReceivePack bool
+}
+
+// Serve accepts connections on listener and serves them with the git://
+// protocol, as "git daemon" does, until the listener fails.
+//
+// Each connection starts with a request for a service of a repository.
+// The repository is looked for under opts.BasePath, and must be exported
+// with a git-daemon-export-ok file unless opts.ExportAll is set. The
+// upload-pack service is always available, and receive-pack is available
+// if opts.ReceivePack is set.
+func Serve(listener net.Listener, opts DaemonOptions) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := serveDaemonConn(conn, opts); err != nil {
+				log.Printf("Error serving %v: %v\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Serves the git:// request made on conn.
+func serveDaemonConn(conn io.ReadWriter, opts DaemonOptions) error {
+	r := &packProtocolReader{conn: conn, state: PktLineMode}
+	line := make([]byte, 65536)
+	n, err := r.Read(line)
+	if err != nil {
+		return err
+	}
+	service, dir, protocol, err := parseDaemonRequest(string(line[:n]))
+	if err != nil {
+		return err
+	}
+	log.Printf("Request for %v of %v\n", service, dir)
+
+	switch service {
+	case "git-upload-pack":
+	case "git-receive-pack":
+		if !opts.ReceivePack {
+			writePktLine(conn, "ERR service not enabled: receive-pack\n")
+			return fmt.Errorf("receive-pack is not enabled")
+		}
+	default:
+		writePktLine(conn, fmt.Sprintf("ERR service not supported: %v\n", service))
+		return fmt.Errorf("unknown service %v", service)
+	}
+
+	c, err := daemonRepository(dir, opts)
+	if err != nil {
+		// The client isn't told why, so that it can't tell which
+		// repositories exist.
+		writePktLine(conn, "ERR access denied or repository not exported: "+dir+"\n")
+		return err
+	}
+	if service == "git-receive-pack" {
+		return ReceivePack(c, conn, conn, ReceivePackOptions{})
+	}
+	return UploadPack(c, conn, conn, UploadPackOptions{GitProtocol: protocol})
+}
+
+// Parses the request line sent at the start of a git:// connection, which
+// has the form "<service> <path>\0host=<host>\0" optionally followed by
+// "\0" and the extra parameters, each terminated by "\0". The extra
+// parameters are returned in the format of GIT_PROTOCOL.
+func parseDaemonRequest(line string) (service, dir, protocol string, err error) {
+	parts := strings.Split(line, "\000")
+	sp := strings.IndexByte(parts[0], ' ')
+	if sp < 0 {
+		return "", "", "", fmt.Errorf("invalid request: %q", line)
+	}
+	service, dir = parts[0][:sp], parts[0][sp+1:]
+
+	var params []string
+	for i, part := range parts[1:] {
+		if part == "" || (i == 0 && strings.HasPrefix(part, "host=")) {
+			continue
+		}
+		params = append(params, part)
+	}
+	return service, dir, strings.Join(params, ":"), nil
+}
+
+// Returns a client for the repository at the path dir of a git://
+// request, if it's served.
+func daemonRepository(dir string, opts DaemonOptions) (*Client, error) {
+	if !path.IsAbs(dir) {
+		return nil, fmt.Errorf("%v is not an absolute path", dir)
+	}
+	// Requests can't escape from the base path.
+	if cleaned := path.Clean(dir); cleaned != dir && cleaned+"/" != dir {
+		return nil, fmt.Errorf("%v is not a canonical path", dir)
+	}
+	base := filepath.Join(opts.BasePath, filepath.FromSlash(dir))
+
+	// As with git, the repository may be named with or without its
+	// .git suffix, and may be a bare repository or have a .git
+	// directory.
+	for _, suffix := range []string{"/.git", "", ".git/.git", ".git"} {
+		gitdir := GitDir(strings.TrimSuffix(base, "/") + suffix)
+		if !File(gitdir).IsDir() || !gitdir.File("objects").IsDir() || !gitdir.File("HEAD").Exists() {
+			continue
+		}
+		if !opts.ExportAll && !gitdir.File("git-daemon-export-ok").Exists() {
+			return nil, fmt.Errorf("%v is not exported", gitdir)
+		}
+		return NewClient(gitdir.String(), "")
+	}
+	return nil, fmt.Errorf("%v is not a repository", dir)
+}
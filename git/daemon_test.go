@@ -0,0 +1,71 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Tests that a repository served over TCP can be cloned once it's
+// exported, and that pushing is refused unless it's enabled.
+func TestDaemon(t *testing.T) {
+	c, cleanup := testRepo(t, "gitdaemon")
+	defer cleanup()
+	head := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+
+	workdir, err := filepath.Abs(c.WorkDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, name := filepath.Split(workdir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go Serve(l, DaemonOptions{BasePath: base})
+
+	dir, err := ioutil.TempDir("", "gitdaemondst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	uri := Remote(fmt.Sprintf("git://%v/%v", l.Addr(), name))
+	opts := CloneOptions{InitOptions: InitOptions{Quiet: true, Bare: true}}
+	if err := Clone(opts, uri, File(filepath.Join(dir, "unexported.git"))); err == nil {
+		t.Errorf("Cloned a repository without git-daemon-export-ok")
+	}
+
+	if err := c.GitDir.WriteFile("git-daemon-export-ok", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dst := File(filepath.Join(dir, "dst.git"))
+	if err := Clone(opts, uri, dst); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := NewClient(dst.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Branch("refs/heads/master").CommitID(dc); err != nil || got != head {
+		t.Errorf("Unexpected master after clone: got %v (%v) want %v", got, err, head)
+	}
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := writePktLine(conn, fmt.Sprintf("git-receive-pack /%v\000host=%v\000", name, l.Addr())); err != nil {
+		t.Fatal(err)
+	}
+	if line := loadLine(conn); !strings.HasPrefix(line, "ERR ") {
+		t.Errorf("Expected receive-pack to be refused, got %q", line)
+	}
+}
@@ -0,0 +1,478 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A DiffAlgorithm is the algorithm used to find the lines in common when
+// generating a patch, as with "git diff --diff-algorithm=".
+type DiffAlgorithm string
+
+const (
+	// The default, Myers' algorithm, which finds the shortest edit
+	// script.
+	DiffMyers = DiffAlgorithm("myers")
+
+	// Patience diff, which only matches lines which are unique in both
+	// files and then recurses between them. It avoids matching common
+	// lines such as braces between unrelated blocks.
+	DiffPatience = DiffAlgorithm("patience")
+
+	// Histogram diff, which extends patience diff to lines which occur
+	// more than once by matching the lines which occur the fewest times.
+	DiffHistogram = DiffAlgorithm("histogram")
+)
+
+// The number of times a line can occur in the first file before
+// histogram diff gives up and falls back on Myers' algorithm.
+const histogramMaxOccurrences = 64
+
+// ParseDiffAlgorithm parses the name of a diff algorithm, as given to
+// --diff-algorithm or the diff.algorithm config. "default" is the same as
+// "myers" and, since Myers' algorithm here always finds a minimal diff, so
+// is "minimal".
+func ParseDiffAlgorithm(name string) (DiffAlgorithm, error) {
+	switch strings.ToLower(name) {
+	case "", "default", "myers", "minimal":
+		return DiffMyers, nil
+	case "patience":
+		return DiffPatience, nil
+	case "histogram":
+		return DiffHistogram, nil
+	}
+	return "", fmt.Errorf("Unknown diff algorithm %v", name)
+}
+
+// Returns the lines in common between a and b as found by alg. The empty
+// DiffAlgorithm is Myers' algorithm.
+func (alg DiffAlgorithm) diffLines(a, b []string) []linePair {
+	switch alg {
+	case DiffPatience:
+		return patienceDiff(a, b, 0, 0)
+	case DiffHistogram:
+		return histogramDiff(a, b, 0, 0)
+	default:
+		return diffLines(a, b)
+	}
+}
+
+// Returns the pairs found by Myers' algorithm between a and b, which are
+// at offsets aoff and boff in the full files.
+func myersRange(a, b []string, aoff, boff int) []linePair {
+	pairs := diffLines(a, b)
+	for i := range pairs {
+		pairs[i].A += aoff
+		pairs[i].B += boff
+	}
+	return pairs
+}
+
+// Returns the lines in common at the start and the end of a and b, with
+// the number of each.
+func commonEnds(a, b []string) (prefix, suffix int) {
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return prefix, suffix
+}
+
+// Calls diff on the part of a and b between their common prefix and
+// suffix, and returns its pairs along with the pairs of the prefix and
+// suffix.
+func trimCommonEnds(a, b []string, aoff, boff int, diff func(a, b []string, aoff, boff int) []linePair) []linePair {
+	prefix, suffix := commonEnds(a, b)
+	var pairs []linePair
+	for i := 0; i < prefix; i++ {
+		pairs = append(pairs, linePair{aoff + i, boff + i})
+	}
+	if ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]; len(ma) > 0 && len(mb) > 0 {
+		pairs = append(pairs, diff(ma, mb, aoff+prefix, boff+prefix)...)
+	}
+	for i := suffix; i > 0; i-- {
+		pairs = append(pairs, linePair{aoff + len(a) - i, boff + len(b) - i})
+	}
+	return pairs
+}
+
+// patienceDiff finds the lines in common between a and b, which are at
+// offsets aoff and boff in the full files, with the patience algorithm.
+//
+// The lines which occur exactly once in both a and b are matched by the
+// longest increasing subsequence of their positions. Then the lines in
+// common at the ends of the gaps between those matches are matched, and
+// the algorithm recurses on what remains. If there are no unique lines,
+// it falls back on Myers' algorithm.
+func patienceDiff(a, b []string, aoff, boff int) []linePair {
+	if len(a) == 0 || len(b) == 0 {
+		return nil
+	}
+	anchors := patienceAnchors(a, b)
+	if len(anchors) == 0 {
+		return myersRange(a, b, aoff, boff)
+	}
+	var pairs []linePair
+	prevA, prevB := 0, 0
+	for _, anchor := range append(anchors, linePair{len(a), len(b)}) {
+		pairs = append(pairs, trimCommonEnds(a[prevA:anchor.A], b[prevB:anchor.B], aoff+prevA, boff+prevB, patienceDiff)...)
+		if anchor.A < len(a) {
+			pairs = append(pairs, linePair{aoff + anchor.A, boff + anchor.B})
+		}
+		prevA, prevB = anchor.A+1, anchor.B+1
+	}
+	return pairs
+}
+
+// Returns the longest increasing sequence of pairs of lines which are
+// unique in both a and b.
+func patienceAnchors(a, b []string) []linePair {
+	type occurrence struct {
+		countA, countB int
+		posA, posB     int
+	}
+	lines := make(map[string]*occurrence)
+	for i, line := range a {
+		o, ok := lines[line]
+		if !ok {
+			o = &occurrence{}
+			lines[line] = o
+		}
+		o.countA++
+		o.posA = i
+	}
+	for j, line := range b {
+		if o, ok := lines[line]; ok {
+			o.countB++
+			o.posB = j
+		}
+	}
+	var unique []linePair
+	for i, line := range a {
+		if o := lines[line]; o.countA == 1 && o.countB == 1 {
+			unique = append(unique, linePair{i, o.posB})
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	// Patience sort the pairs (which are ordered by A) by B. tops[k] is
+	// the index of the top of the pile k, and prev links each pair to the
+	// top of the previous pile when it was placed.
+	var tops []int
+	prev := make([]int, len(unique))
+	for i, p := range unique {
+		k := sort.Search(len(tops), func(k int) bool { return unique[tops[k]].B > p.B })
+		if k > 0 {
+			prev[i] = tops[k-1]
+		} else {
+			prev[i] = -1
+		}
+		if k == len(tops) {
+			tops = append(tops, i)
+		} else {
+			tops[k] = i
+		}
+	}
+	anchors := make([]linePair, len(tops))
+	for i, k := tops[len(tops)-1], len(tops)-1; k >= 0; i, k = prev[i], k-1 {
+		anchors[k] = unique[i]
+	}
+	return anchors
+}
+
+// histogramDiff finds the lines in common between a and b, which are at
+// offsets aoff and boff in the full files, with the histogram algorithm.
+//
+// The longest run of lines in common which contains the line occurring
+// the fewest times in a is matched, and the algorithm recurses on either
+// side of it. If the lines in common all occur too often it falls back on
+// Myers' algorithm.
+func histogramDiff(a, b []string, aoff, boff int) []linePair {
+	if len(a) == 0 || len(b) == 0 {
+		return nil
+	}
+	positions := make(map[string][]int)
+	for i, line := range a {
+		positions[line] = append(positions[line], i)
+	}
+
+	common := false
+	bestCount := histogramMaxOccurrences + 1
+	bestA, bestB, bestLen := 0, 0, 0
+	for j := 0; j < len(b); {
+		occurrences := positions[b[j]]
+		if len(occurrences) > 0 {
+			common = true
+		}
+		if len(occurrences) == 0 || len(occurrences) > bestCount {
+			j++
+			continue
+		}
+		next := j + 1
+		for _, i := range occurrences {
+			// Extend the run of common lines both ways, and find
+			// the fewest times that any line in it occurs.
+			as, bs := i, j
+			for as > 0 && bs > 0 && a[as-1] == b[bs-1] {
+				as--
+				bs--
+			}
+			ae, be := i+1, j+1
+			for ae < len(a) && be < len(b) && a[ae] == b[be] {
+				ae++
+				be++
+			}
+			if be > next {
+				next = be
+			}
+			count := len(occurrences)
+			for _, line := range a[as:ae] {
+				if n := len(positions[line]); n < count {
+					count = n
+				}
+			}
+			if ae-as > bestLen || count < bestCount {
+				bestCount = count
+				bestA, bestB, bestLen = as, bs, ae-as
+			}
+		}
+		j = next
+	}
+	if bestLen == 0 {
+		if common {
+			// The lines in common all occur too often.
+			return myersRange(a, b, aoff, boff)
+		}
+		return nil
+	}
+
+	pairs := histogramDiff(a[:bestA], b[:bestB], aoff, boff)
+	for n := 0; n < bestLen; n++ {
+		pairs = append(pairs, linePair{aoff + bestA + n, boff + bestB + n})
+	}
+	return append(pairs, histogramDiff(a[bestA+bestLen:], b[bestB+bestLen:], aoff+bestA+bestLen, boff+bestB+bestLen)...)
+}
+
+// Formats the range of a hunk for a unified diff header, in the same way
+// as diff -u. start is the 0 indexed line where the hunk starts.
+func unifiedRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// Returns the lines of the hunks of the unified diff between a and b,
+// without the header with the file names.
+func unifiedDiffLines(a, b []byte, context int) []string {
+	lines := splitLines(DiffMyers.unifiedDiff(a, b, context, "", ""))
+	if len(lines) < 2 {
+		return nil
+	}
+	return lines[2:]
+}
+
+// Returns the unified diff between a and b, with context lines of context
+// around each change and the labels labelA and labelB in the header, using
+// alg to find the lines in common. It returns an empty string if there
+// are no differences.
+//
+// This is used instead of the external diff tool for the algorithms which
+// it doesn't support.
+func (alg DiffAlgorithm) unifiedDiff(a, b []byte, context int, labelA, labelB string) string {
+	if isBinaryContent(a) || isBinaryContent(b) {
+		if bytes.Equal(a, b) {
+			return ""
+		}
+		return fmt.Sprintf("Binary files %v and %v differ\n", labelA, labelB)
+	}
+	return alg.unifiedTextDiff(a, b, context, labelA, labelB)
+}
+
+// Returns the unified diff between a and b like unifiedDiff, but treats
+// them as text even if they look like binary files.
+func (alg DiffAlgorithm) unifiedTextDiff(a, b []byte, context int, labelA, labelB string) string {
+	// The lines keep their newlines, so that a missing newline at the end
+	// of a file is a difference.
+	alines, blines := splitAfterLines(a), splitAfterLines(b)
+	pairs := append(slideDiffLines(alines, blines, alg.diffLines(alines, blines)), linePair{len(alines), len(blines)})
+
+	// Each line of the diff, with its index in a or b.
+	type diffLine struct {
+		op   byte
+		a, b int
+	}
+	var lines []diffLine
+	x, y := 0, 0
+	for _, p := range pairs {
+		for ; x < p.A; x++ {
+			lines = append(lines, diffLine{'-', x, y})
+		}
+		for ; y < p.B; y++ {
+			lines = append(lines, diffLine{'+', x, y})
+		}
+		if p.A < len(alines) {
+			lines = append(lines, diffLine{' ', x, y})
+		}
+		x, y = p.A+1, p.B+1
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk until there's a run of more than twice the
+		// context without any changes.
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end, unchanged := i, 0
+		for end < len(lines) && unchanged <= 2*context {
+			if lines[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		if unchanged > context {
+			end -= unchanged - context
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %v\n+++ %v\n", labelA, labelB)
+		}
+		acount, bcount := 0, 0
+		for _, l := range lines[start:end] {
+			if l.op != '+' {
+				acount++
+			}
+			if l.op != '-' {
+				bcount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%v +%v @@\n", unifiedRange(lines[start].a, acount), unifiedRange(lines[start].b, bcount))
+		for _, l := range lines[start:end] {
+			line := blines
+			idx := l.b
+			if l.op == '-' {
+				line, idx = alines, l.a
+			}
+			out.WriteByte(l.op)
+			out.WriteString(line[idx])
+			if !strings.HasSuffix(line[idx], "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// Slides each block of added or removed lines in the diff between a and b,
+// whose lines in common are pairs, as far down as it can go without
+// changing the lines which were added or removed. This is the same as git
+// does, so that a block which starts and ends with the same line (such as
+// a blank one) is the one shown as changed.
+func slideDiffLines(a, b []string, pairs []linePair) []linePair {
+	changedA := make([]bool, len(a))
+	changedB := make([]bool, len(b))
+	for i := range changedA {
+		changedA[i] = true
+	}
+	for i := range changedB {
+		changedB[i] = true
+	}
+	for _, p := range pairs {
+		changedA[p.A], changedB[p.B] = false, false
+	}
+
+	// Only blocks which are entirely added or removed are slid, since the
+	// lines on the other side of a replacement would no longer line up.
+	slide := func(lines []string, changed, other []bool) {
+		// The number of lines in common before s.
+		common := 0
+		for s := 0; s < len(lines); {
+			if !changed[s] {
+				common++
+				s++
+				continue
+			}
+			e := s
+			for e < len(lines) && changed[e] {
+				e++
+			}
+			for e < len(lines) && lines[s] == lines[e] && !gapChanged(other, common) && !gapChanged(other, common+1) {
+				changed[s], changed[e] = false, true
+				s++
+				e++
+				common++
+				for e < len(lines) && changed[e] {
+					e++
+				}
+			}
+			s = e
+		}
+	}
+	slide(b, changedB, changedA)
+	slide(a, changedA, changedB)
+
+	var slid []linePair
+	i, j := 0, 0
+	for {
+		for i < len(a) && changedA[i] {
+			i++
+		}
+		for j < len(b) && changedB[j] {
+			j++
+		}
+		if i >= len(a) || j >= len(b) {
+			return slid
+		}
+		slid = append(slid, linePair{i, j})
+		i++
+		j++
+	}
+}
+
+// Returns whether any lines are changed just before the nth unchanged
+// line (counting from 0) of changed.
+func gapChanged(changed []bool, n int) bool {
+	unchanged := 0
+	for _, c := range changed {
+		switch {
+		case c && unchanged == n:
+			return true
+		case !c && unchanged == n:
+			return false
+		case !c:
+			unchanged++
+		}
+	}
+	return false
+}
+
+// Splits content into lines, keeping the newline at the end of each.
+func splitAfterLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,128 @@
+package git
+
+import (
+	"testing"
+)
+
+const reorderedOriginal = `int one()
+{
+    int x = 0;
+    x++;
+    return x;
+}
+
+int two()
+{
+    int x = 0;
+    x--;
+    return x;
+}
+`
+
+const reorderedModified = `int two()
+{
+    int x = 0;
+    x--;
+    return x;
+}
+
+int one()
+{
+    int x = 0;
+    x++;
+    return x;
+}
+`
+
+// Tests that patience and histogram diff show a reordered function as
+// moved, where Myers' algorithm matches the lines which the functions have
+// in common and interleaves them. The expected patches are the same as
+// "git diff --diff-algorithm=".
+func TestDiffAlgorithmReorderedFunction(t *testing.T) {
+	myers := `--- a/f.c
++++ b/f.c
+@@ -1,13 +1,13 @@
+-int one()
++int two()
+ {
+     int x = 0;
+-    x++;
++    x--;
+     return x;
+ }
+ 
+-int two()
++int one()
+ {
+     int x = 0;
+-    x--;
++    x++;
+     return x;
+ }
+`
+	patience := `--- a/f.c
++++ b/f.c
+@@ -1,13 +1,13 @@
+-int one()
+-{
+-    int x = 0;
+-    x++;
+-    return x;
+-}
+-
+ int two()
+ {
+     int x = 0;
+     x--;
+     return x;
+ }
++
++int one()
++{
++    int x = 0;
++    x++;
++    return x;
++}
+`
+	tests := []struct {
+		alg      DiffAlgorithm
+		expected string
+	}{
+		{DiffMyers, myers},
+		{"", myers},
+		{DiffPatience, patience},
+		{DiffHistogram, patience},
+	}
+	for _, tc := range tests {
+		patch := tc.alg.unifiedDiff([]byte(reorderedOriginal), []byte(reorderedModified), 3, "a/f.c", "b/f.c")
+		if patch != tc.expected {
+			t.Errorf("Unexpected %q diff: got\n%v\nwant\n%v", tc.alg, patch, tc.expected)
+		}
+	}
+}
+
+// Tests the hunk splitting and headers of the diffs generated in Go.
+func TestUnifiedDiffHunks(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		context  int
+		expected string
+	}{
+		{"a\nb\nc\n", "a\nb\nc\n", 3, ""},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n", "1\n2\nx\n4\n5\n6\n7\n8\ny\n", 1,
+			"--- a\n+++ b\n@@ -2,3 +2,3 @@\n 2\n-3\n+x\n 4\n@@ -8,2 +8,2 @@\n 8\n-9\n+y\n",
+		},
+		{
+			"1\n2\n3\n4\n5\n", "1\n2\nx\n4\ny\n", 1,
+			"--- a\n+++ b\n@@ -2,4 +2,4 @@\n 2\n-3\n+x\n 4\n-5\n+y\n",
+		},
+		{"x\n", "x\ny\n", 0, "--- a\n+++ b\n@@ -1,0 +2 @@\n+y\n"},
+		{"x\ny", "x\nz\n", 3, "--- a\n+++ b\n@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n+z\n"},
+	}
+	for i, tc := range tests {
+		if got := DiffPatience.unifiedDiff([]byte(tc.a), []byte(tc.b), tc.context, "a", "b"); got != tc.expected {
+			t.Errorf("Case %d: got\n%q\nwant\n%q", i, got, tc.expected)
+		}
+	}
+}
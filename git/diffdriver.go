@@ -0,0 +1,222 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The diff driver from the diff attribute of a path.
+type diffDriver struct {
+	name string
+
+	// The command from diff.<driver>.textconv, which converts a file
+	// to text for diffing, and whether its output is cached in a notes
+	// ref, from diff.<driver>.cachetextconv.
+	textconv string
+	cache    bool
+
+	// Whether the files are treated as binary or as text, if it isn't
+	// detected from their content. An unset diff attribute or
+	// diff.<driver>.binary=true makes them binary, and
+	// diff.<driver>.binary=false makes them text.
+	binary, text bool
+
+	// The xfuncname pattern which finds the function that each hunk is
+	// in, from diff.<driver>.xfuncname or the builtin driver with the
+	// same name. If it's nil, the default is used.
+	funcnames []funcnamePattern
+}
+
+// The key of the textconv cache of a client.
+type textconvKey struct {
+	textconv string
+	blob     Sha1
+}
+
+// Returns the diff driver for path. If path doesn't have a diff attribute
+// which names a driver, the driver has no name and the diff depends on the
+// content.
+func diffDriverFor(c *Client, path IndexPath) (diffDriver, error) {
+	attrs, err := attributesFor(c, path)
+	if err != nil {
+		return diffDriver{}, err
+	}
+	switch name := attrs["diff"]; name {
+	case "", attributeSet:
+		return diffDriver{}, nil
+	case attributeUnset:
+		return diffDriver{binary: true}, nil
+	default:
+		d := diffDriver{
+			name:     name,
+			textconv: c.GetConfig("diff." + name + ".textconv"),
+			cache:    c.GetConfig("diff."+name+".cachetextconv") == "true",
+		}
+		switch c.GetConfig("diff." + name + ".binary") {
+		case "true":
+			d.binary = true
+		case "false":
+			d.text = true
+		}
+		pattern := c.GetConfig("diff." + name + ".xfuncname")
+		if pattern == "" {
+			pattern = builtinFuncnames[name]
+		}
+		if pattern != "" {
+			if d.funcnames, err = compileFuncname(name, pattern); err != nil {
+				return diffDriver{}, err
+			}
+		}
+		return d, nil
+	}
+}
+
+// Returns the notes ref that the textconv output of the driver is cached
+// in, which is the same as git's.
+func (d diffDriver) cacheRef() NotesOptions {
+	return NotesOptions{Ref: "refs/notes/textconv/" + d.name}
+}
+
+// Returns the output of the textconv command of d for content, which is
+// the content of the blob id, or of a file in the work tree if id is the
+// zero value. The output for blobs is cached by the client, and in the
+// cache notes ref if diff.<driver>.cachetextconv is set.
+func (d diffDriver) convert(c *Client, id Sha1, content []byte) ([]byte, error) {
+	key := textconvKey{d.textconv, id}
+	if id != (Sha1{}) {
+		c.objectsMu.Lock()
+		out, ok := c.textconvCache[key]
+		c.objectsMu.Unlock()
+		if ok {
+			return out, nil
+		}
+	}
+
+	// As in git, the cache is only valid if it was made with the same
+	// command, which is the message of the notes commit.
+	var notes map[Sha1]Sha1
+	var parent CommitID
+	if d.cache && id != (Sha1{}) {
+		var err error
+		notes, parent, err = readNotes(c, d.cacheRef())
+		if err != nil {
+			return nil, err
+		}
+		if parent != (CommitID{}) {
+			if msg, err := parent.GetCommitMessage(c); err != nil {
+				return nil, err
+			} else if strings.TrimSpace(msg.String()) != d.textconv {
+				notes = make(map[Sha1]Sha1)
+			}
+		}
+		if blob, ok := notes[id]; ok {
+			obj, err := c.GetObject(blob)
+			if err != nil {
+				return nil, err
+			}
+			return obj.GetContent(), nil
+		}
+	}
+
+	out, err := runTextconv(c, d.textconv, content)
+	if err != nil {
+		return nil, err
+	}
+	if id == (Sha1{}) {
+		return out, nil
+	}
+	c.objectsMu.Lock()
+	if c.textconvCache == nil {
+		c.textconvCache = make(map[textconvKey][]byte)
+	}
+	c.textconvCache[key] = out
+	c.objectsMu.Unlock()
+	if notes != nil {
+		blob, err := c.WriteObject("blob", out)
+		if err != nil {
+			return nil, err
+		}
+		notes[id] = blob
+		if err := writeNotes(c, d.cacheRef(), notes, parent, d.textconv); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Runs the textconv command with the name of a temporary file containing
+// content as its argument, the same way as git, and returns its output.
+func runTextconv(c *Client, textconv string, content []byte) ([]byte, error) {
+	tmpfile, err := ioutil.TempFile("", "gittextconv")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write(content); err != nil {
+		tmpfile.Close()
+		return nil, err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("sh", "-c", textconv+" "+shellQuote(tmpfile.Name()))
+	if c.WorkDir != "" {
+		cmd.Dir = c.WorkDir.String()
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("textconv %q failed: %v", textconv, err)
+	}
+	return out.Bytes(), nil
+}
+
+// One side of a diff for a diff driver.
+type diffSide struct {
+	// The blob, or the zero value for a file in the work tree.
+	id Sha1
+
+	content []byte
+
+	// False if the file doesn't exist on this side, so it isn't
+	// converted.
+	exists bool
+}
+
+// Returns the diff between a and b, as converted and treated by the
+// driver.
+func (d diffDriver) diff(c *Client, a, b diffSide, opts DiffCommonOptions, labelA, labelB string) (string, error) {
+	alg := opts.Algorithm
+	if alg == "" {
+		alg = DiffMyers
+	}
+	if d.textconv != "" {
+		for _, side := range []*diffSide{&a, &b} {
+			if !side.exists {
+				continue
+			}
+			out, err := d.convert(c, side.id, side.content)
+			if err != nil {
+				return "", err
+			}
+			side.content = out
+		}
+		return d.addFuncnames(alg.unifiedTextDiff(a.content, b.content, opts.NumContextLines, labelA, labelB), a.content), nil
+	}
+	if d.binary {
+		if bytes.Equal(a.content, b.content) {
+			return "", nil
+		}
+		return fmt.Sprintf("Binary files %v and %v differ\n", labelA, labelB), nil
+	}
+	if d.text {
+		return d.addFuncnames(alg.unifiedTextDiff(a.content, b.content, opts.NumContextLines, labelA, labelB), a.content), nil
+	}
+	return d.addFuncnames(alg.unifiedDiff(a.content, b.content, opts.NumContextLines, labelA, labelB), a.content), nil
+}
@@ -0,0 +1,180 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDiffTextconv(t *testing.T) {
+	c, cleanup := testRepo(t, "gitdifftextconv")
+	defer cleanup()
+
+	testCommitFile(t, c, "data.bin", "\x00\x01ab", "Add binary file")
+	testCommitFile(t, c, "text.txt", "foo\n", "Add text file")
+	if err := ioutil.WriteFile("data.bin", []byte("\x00\x01ac"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("text.txt", []byte("bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patch := func() string {
+		t.Helper()
+		diffs, err := DiffFiles(c, DiffFilesOptions{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := GeneratePatch(c, DiffCommonOptions{Patch: true, NumContextLines: 3}, diffs, &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	if err := ioutil.WriteFile(".gitattributes", []byte("*.bin diff=hex\n*.txt diff=raw\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.SetCachedConfig("diff.hex.textconv", "od -An -tx1")
+	c.SetCachedConfig("diff.hex.cachetextconv", "true")
+	c.SetCachedConfig("diff.raw.binary", "true")
+	out := patch()
+	for _, want := range []string{
+		"--- a/data.bin\n+++ b/data.bin\n@@ -1 +1 @@\n- 00 01 61 62\n+ 00 01 61 63\n",
+		"Binary files a/text.txt and b/text.txt differ\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Unexpected patch: got %q, want it to contain %q", out, want)
+		}
+	}
+
+	// The output for the blob in the index was cached, in the client and
+	// in the notes ref.
+	blob := hashString("\x00\x01ab")
+	if got := c.textconvCache[textconvKey{"od -An -tx1", blob}]; string(got) != " 00 01 61 62\n" {
+		t.Errorf("Unexpected cached textconv output: got %q", got)
+	}
+	notes, _, err := readNotes(c, NotesOptions{Ref: "refs/notes/textconv/hex"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note, ok := notes[blob]; !ok {
+		t.Error("Textconv output was not cached in the notes ref")
+	} else if obj, err := c.GetObject(note); err != nil || string(obj.GetContent()) != " 00 01 61 62\n" {
+		t.Errorf("Unexpected textconv output in the notes ref: %v", err)
+	}
+
+	// Without a driver, the binary file isn't shown and the text file
+	// is.
+	if err := ioutil.WriteFile(".gitattributes", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out = patch()
+	for _, want := range []string{
+		"Binary files a/data.bin and b/data.bin differ\n",
+		"-foo\n+bar\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Unexpected patch without drivers: got %q, want it to contain %q", out, want)
+		}
+	}
+
+	// A driver can force a binary file to be shown as text.
+	if err := ioutil.WriteFile(".gitattributes", []byte("*.bin diff=text\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.SetCachedConfig("diff.text.binary", "false")
+	if out := patch(); !strings.Contains(out, "-\x00\x01ab\n\\ No newline at end of file\n+\x00\x01ac\n") {
+		t.Errorf("Binary file was not shown as text: got %q", out)
+	}
+}
+
+func TestDiffFuncname(t *testing.T) {
+	c, cleanup := testRepo(t, "gitdifffuncname")
+	defer cleanup()
+
+	src := "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tx := 1\n\ty := 2\n\tz := 3\n\tfmt.Println(x, y, z)\n}\n"
+	testCommitFile(t, c, "main.go", src, "Add main")
+	if err := ioutil.WriteFile("main.go", []byte(strings.Replace(src, "x, y, z", "x, y", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hunk := func() string {
+		t.Helper()
+		diffs, err := DiffFiles(c, DiffFilesOptions{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := GeneratePatch(c, DiffCommonOptions{Patch: true, NumContextLines: 3}, diffs, &out); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(out.String(), "\n") {
+			if strings.HasPrefix(line, "@@") {
+				return line
+			}
+		}
+		t.Fatalf("No hunk in patch %q", out.String())
+		return ""
+	}
+
+	// Without a driver, the closest line before the hunk which starts
+	// with a letter is used.
+	if got, want := hunk(), "@@ -6,5 +6,5 @@ func main() {"; got != want {
+		t.Errorf("Unexpected default hunk header: got %q want %q", got, want)
+	}
+
+	if err := ioutil.WriteFile(".gitattributes", []byte("*.go diff=golang\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hunk(), "@@ -6,5 +6,5 @@ func main() {"; got != want {
+		t.Errorf("Unexpected golang hunk header: got %q want %q", got, want)
+	}
+
+	// A custom pattern uses the first group if there is one, and
+	// negated patterns stop lines from matching.
+	c.SetCachedConfig("diff.golang.xfuncname", "^(import) .*$")
+	if got, want := hunk(), "@@ -6,5 +6,5 @@ import"; got != want {
+		t.Errorf("Unexpected custom hunk header: got %q want %q", got, want)
+	}
+	c.SetCachedConfig("diff.golang.xfuncname", "!^func\n^[a-z].*$")
+	if got, want := hunk(), "@@ -6,5 +6,5 @@ import \"fmt\""; got != want {
+		t.Errorf("Unexpected negated hunk header: got %q want %q", got, want)
+	}
+	c.SetCachedConfig("diff.golang.xfuncname", "(")
+	diffs, err := DiffFiles(c, DiffFilesOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := GeneratePatch(c, DiffCommonOptions{Patch: true}, diffs, ioutil.Discard); err == nil {
+		t.Error("Expected an error for an invalid xfuncname")
+	}
+}
+
+// Tests that the textconv output of blobs can be converted and cached by
+// concurrent diffs.
+func TestDiffTextconvConcurrent(t *testing.T) {
+	c, cleanup := testRepo(t, "gitdifftextconvconcurrent")
+	defer cleanup()
+
+	d := diffDriver{name: "upper", textconv: "tr a-z A-Z <"}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := []byte(fmt.Sprintf("blob %d\n", i%2))
+			out, err := d.convert(c, hashString(string(content)), content)
+			if err != nil {
+				t.Error(err)
+			} else if want := strings.ToUpper(string(content)); string(out) != want {
+				t.Errorf("Unexpected textconv output: got %q want %q", out, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(c.textconvCache) != 2 {
+		t.Errorf("Unexpected number of cached outputs: %v", len(c.textconvCache))
+	}
+}
@@ -1,8 +1,14 @@
 package git
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"runtime"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // Options that are shared between git diff, git diff-files, diff-index,
@@ -19,6 +25,35 @@ type DiffCommonOptions struct {
 
 	// Exit with a exit code of 1 if there are any diffs
 	ExitCode bool
+
+	// Only show changes in the current working directory, and show
+	// their paths relative to it. If PathPrefix is set, it's used
+	// instead of the current working directory.
+	Relative bool
+
+	// The directory of the work tree that paths are displayed relative
+	// to, with a trailing slash. If empty, paths are displayed relative
+	// to the root of the work tree.
+	PathPrefix IndexPath
+
+	// Color the patch according to the color.diff config.
+	Color bool
+
+	// Terminate the raw output with NULs and don't quote paths, as with
+	// -z.
+	NullTerminate bool
+
+	// The algorithm used to generate the patch. The 0 value is
+	// DiffMyers.
+	Algorithm DiffAlgorithm
+}
+
+// Returns the format that the raw output is rendered in for opts.
+func (opts DiffCommonOptions) OutputFormat() OutputFormat {
+	if opts.NullTerminate {
+		return OutputNullTerminated
+	}
+	return OutputHuman
 }
 
 // Describes the options that may be specified on the command line for
@@ -28,9 +63,120 @@ type DiffFilesOptions struct {
 	DiffCommonOptions
 }
 
+// A file whose content needs to be hashed to determine if it has changed
+// from the index.
+type diffFilesJob struct {
+	diff HashDiff
+	file File
+
+	changed bool
+	err     error
+}
+
+// Returns the number of files that DiffFiles hashes concurrently, from the
+// index.threads config. If it's unset or true, GOMAXPROCS is used.
+func diffFilesThreads(c *Client) int {
+	switch val := c.GetConfig("index.threads"); val {
+	case "", "true":
+	case "false":
+		return 1
+	default:
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			return n
+		}
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Hashes the files for jobs with a pool of n workers, setting changed on
+// each job whose file doesn't match the index. The errors from the workers
+// are combined into one error after every job has finished.
+func hashDiffFiles(jobs []diffFilesJob, n int) error {
+	if n > len(jobs) {
+		n = len(jobs)
+	}
+	work := make(chan *diffFilesJob)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range work {
+				hash, _, err := HashFile("blob", job.file.String())
+				switch {
+				case os.IsNotExist(err):
+					// It was deleted after it was stat'ed.
+					job.changed = true
+				case err != nil:
+					job.err = err
+				default:
+					job.changed = hash != job.diff.Src.Sha1
+				}
+			}
+		}()
+	}
+	for i := range jobs {
+		work <- &jobs[i]
+	}
+	close(work)
+	wg.Wait()
+
+	var failed []string
+	var first error
+	for _, job := range jobs {
+		if job.err != nil {
+			if first == nil {
+				first = job.err
+			}
+			failed = append(failed, job.diff.Name.String())
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("Could not hash %v: %v", failed[0], first)
+	default:
+		return fmt.Errorf("Could not hash %v files (%v): %v", len(failed), strings.Join(failed, ", "), first)
+	}
+}
+
+// Returns the directory that opt.Relative restricts diffs to.
+func (opt DiffCommonOptions) relativePrefix(c *Client) (IndexPath, error) {
+	if opt.PathPrefix != "" {
+		return opt.PathPrefix, nil
+	}
+	return c.CwdPrefix()
+}
+
+// Removes the diffs which aren't in the current working directory (or
+// opt.PathPrefix) if opt.Relative is set.
+func (opt DiffCommonOptions) filterRelative(c *Client, diffs []HashDiff) ([]HashDiff, error) {
+	if !opt.Relative {
+		return diffs, nil
+	}
+	prefix, err := opt.relativePrefix(c)
+	if err != nil {
+		return nil, err
+	}
+	filtered := diffs[:0]
+	for _, d := range diffs {
+		if strings.HasPrefix(d.Name.String(), prefix.String()) {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered, nil
+}
+
 // DiffFiles implements the git diff-files command.
 // It compares the file system to the index.
+//
+// paths are relative to the current working directory, but if no paths
+// are given the whole work tree is compared, not just the working directory.
 func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error) {
+	if len(paths) == 0 {
+		paths = []File{File(c.WorkDir)}
+	}
 	indexentries, err := LsFiles(
 		c,
 		LsFilesOptions{
@@ -43,21 +189,56 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 	}
 
 	var val []HashDiff
+	var jobs []diffFilesJob
+
+	// Files which the fsmonitor knows haven't changed don't need to be
+	// looked at.
+	index, err := c.GetIndex()
+	if err != nil {
+		return nil, err
+	}
+	fsmonitor := queryFSMonitor(c, index)
+	indexMtime, err := indexMTime(c)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, idx := range indexentries {
+		if fsmonitor.isValid(idx.PathName) {
+			continue
+		}
+		if idx.SkipWorktree() {
+			// The file isn't expected to be in the work tree, so
+			// it's the same as the index, as in git.
+			continue
+		}
 		fs := TreeEntry{}
 		idxtree := TreeEntry{idx.Sha1, idx.Mode}
 
+		if idx.Mode == ModeGitlink {
+			// Gitlinks are compared by the commit that's checked
+			// out in the submodule, not the content. A submodule
+			// which isn't checked out isn't considered modified.
+			head, err := gitlinkHead(c, idx.PathName)
+			if err != nil {
+				return nil, err
+			}
+			if head != (CommitID{}) && Sha1(head) != idx.Sha1 {
+				val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: TreeEntry{FileMode: ModeGitlink}, SrcSize: uint(idx.Fsize)})
+			}
+			continue
+		}
+
 		f, err := idx.PathName.FilePath(c)
 		if err != nil || !f.Exists() {
 			// If there was an error, treat it as a non-existant file
 			// and just use the empty Sha1
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), 0})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize)})
 			continue
 		}
 		stat, err := f.Lstat()
 		if err != nil {
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), 0})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize)})
 			continue
 		}
 
@@ -66,7 +247,7 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 			// Since we're diffing files in the index (which only holds files)
 			// against a directory, it means that the file was deleted and
 			// replaced by a directory.
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), 0})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize)})
 			continue
 		case !stat.Mode().IsRegular():
 			// FIXME: This doesn't take into account that the file
@@ -80,20 +261,36 @@ func DiffFiles(c *Client, opt DiffFilesOptions, paths []File) ([]HashDiff, error
 		size := stat.Size()
 		if err := idx.CompareStat(f); err != nil {
 			log.Printf("Stat information does not match for %v: %v\n", f, err)
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), uint(size)})
+			val = append(val, HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize), DstSize: uint(size)})
+			continue
+		}
+		if fs.FileMode == idx.Mode && !idx.needsContentCheck(indexMtime) {
+			// The stat information matches and the index was
+			// written after the file was last modified, so it
+			// hasn't changed.
 			continue
 		}
 
 		// We couldn't short-circuit by checking the stat info, so fall back on hashing
-		// the file.
-		hash, _, err := HashFile("blob", f.String())
+		// the file once all the stat checks are done. This is always
+		// the case for racily clean entries, which may have been
+		// modified without their stat information changing.
+		jobs = append(jobs, diffFilesJob{
+			diff: HashDiff{Name: idx.PathName, Src: idxtree, Dst: fs, SrcSize: uint(idx.Fsize), DstSize: uint(size)},
+			file: f,
+		})
+	}
 
-		if err != nil || hash != idx.Sha1 {
-			val = append(val, HashDiff{idx.PathName, idxtree, fs, uint(idx.Fsize), uint(size)})
+	if err := hashDiffFiles(jobs, diffFilesThreads(c)); err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.changed {
+			val = append(val, job.diff)
 		}
 	}
 
 	sort.Sort(ByName(val))
 
-	return val, nil
+	return opt.filterRelative(c, val)
 }
@@ -0,0 +1,269 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that pathspecs passed to DiffFiles are relative to the current
+// working directory, and that Relative restricts the diff to it.
+func TestDiffFilesSubdirectory(t *testing.T) {
+	c, cleanup := testRepo(t, "gitdifffilessubdir")
+	defer cleanup()
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "first")
+	testCommitFile(t, c, "dir/bar.txt", "bar\n", "second")
+	if err := ioutil.WriteFile("foo.txt", []byte("changed foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("dir/bar.txt", []byte("changed bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		Paths    []File
+		Relative bool
+		Want     []IndexPath
+	}{
+		{nil, false, []IndexPath{"dir/bar.txt", "foo.txt"}},
+		{[]File{"bar.txt"}, false, []IndexPath{"dir/bar.txt"}},
+		{[]File{"../foo.txt"}, false, []IndexPath{"foo.txt"}},
+		{[]File{"."}, false, []IndexPath{"dir/bar.txt"}},
+		{nil, true, []IndexPath{"dir/bar.txt"}},
+		{[]File{"../foo.txt"}, true, nil},
+	}
+	for i, tc := range testcases {
+		opts := DiffFilesOptions{DiffCommonOptions{Relative: tc.Relative}}
+		diffs, err := DiffFiles(c, opts, tc.Paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []IndexPath
+		for _, d := range diffs {
+			got = append(got, d.Name)
+		}
+		if len(got) != len(tc.Want) {
+			t.Errorf("Case %d: unexpected diffs: got %v want %v", i, got, tc.Want)
+			continue
+		}
+		for j := range got {
+			if got[j] != tc.Want[j] {
+				t.Errorf("Case %d: unexpected diffs: got %v want %v", i, got, tc.Want)
+				break
+			}
+		}
+	}
+
+	diffs, err := DiffFiles(c, DiffFilesOptions{DiffCommonOptions{Relative: true}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := GeneratePatch(c, DiffCommonOptions{Raw: true, Relative: true}, diffs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "M\tbar.txt\n") {
+		t.Errorf("Path was not relative to the working directory: got %q", out.String())
+	}
+}
+
+// Creates n files spread across a few directories in the work tree of c
+// and commits them.
+func testCommitManyFiles(tb testing.TB, c *Client, n int) []File {
+	tb.Helper()
+	var files []File
+	for i := 0; i < n; i++ {
+		name := File(fmt.Sprintf("dir%d/file%d.txt", i%10, i))
+		if err := os.MkdirAll(filepath.Dir(name.String()), 0755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name.String(), []byte(strings.Repeat(name.String()+"\n", 100)), 0644); err != nil {
+			tb.Fatal(err)
+		}
+		files = append(files, name)
+	}
+	if _, err := Add(c, AddOptions{}, files); err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := Commit(c, CommitOptions{}, "Many files", nil); err != nil {
+		tb.Fatal(err)
+	}
+	return files
+}
+
+// Tests that hashing the files concurrently gives the same results as
+// hashing them one at a time.
+func TestDiffFilesConcurrent(t *testing.T) {
+	c, cleanup := testRepo(t, "gitdifffilesconcurrent")
+	defer cleanup()
+
+	files := testCommitManyFiles(t, c, 300)
+
+	var want []IndexPath
+	// Files whose stat information no longer matches the index.
+	for i := 0; i < 20; i++ {
+		if err := ioutil.WriteFile(files[i].String(), []byte("changed\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 20; i < 25; i++ {
+		if err := os.Remove(files[i].String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Entries whose stat information matches but content doesn't, so
+	// that they can only be found by hashing the file. The index is
+	// made older than the files below, so that every entry is racily
+	// clean and hashed.
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range idx.Objects {
+		var i int
+		fmt.Sscanf(filepath.Base(entry.PathName.String()), "file%d.txt", &i)
+		if i < 25 || i%7 == 0 {
+			want = append(want, entry.PathName)
+		}
+		if i >= 25 && i%7 == 0 {
+			entry.Sha1[0] ^= 0xff
+		}
+	}
+	f, err := c.GitDir.Create("index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.WriteIndex(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(c.GitDir.File("index").String(), past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	diff := func(threads string) []HashDiff {
+		t.Helper()
+		c.SetCachedConfig("index.threads", threads)
+		diffs, err := DiffFiles(c, DiffFilesOptions{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return diffs
+	}
+	serial := diff("1")
+	if len(serial) != len(want) {
+		t.Fatalf("Unexpected number of diffs: got %v want %v", len(serial), len(want))
+	}
+	for i := range serial {
+		if serial[i].Name != want[i] {
+			t.Fatalf("Unexpected diff %d: got %v want %v", i, serial[i].Name, want[i])
+		}
+	}
+	for _, threads := range []string{"2", "8", "true", "300", "1000"} {
+		concurrent := diff(threads)
+		if len(concurrent) != len(serial) {
+			t.Errorf("index.threads=%v: got %v diffs want %v", threads, len(concurrent), len(serial))
+			continue
+		}
+		for i := range serial {
+			if concurrent[i] != serial[i] {
+				t.Errorf("index.threads=%v: diff %d was %v want %v", threads, i, concurrent[i], serial[i])
+			}
+		}
+	}
+}
+
+// Tests that a file which was modified in the same timestamp tick as the
+// index was written is found by DiffFiles, even though its stat
+// information matches the index.
+func TestDiffFilesRacy(t *testing.T) {
+	c, cleanup := testRepo(t, "gitdifffilesracy")
+	defer cleanup()
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "first")
+
+	// Change the file without changing its size, and write an index with
+	// its new stat information but the old content, as if the file was
+	// changed again right after it was added.
+	if err := ioutil.WriteFile("foo.txt", []byte("bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Objects[0].RefreshStat(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GitDir.WriteLocked("index", idx.WriteIndex); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat("foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setIndexTime := func(mtime time.Time) {
+		t.Helper()
+		if err := os.Chtimes(c.GitDir.File("index").String(), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// If the index is newer than the file, the stat information is
+	// trusted.
+	setIndexTime(stat.ModTime().Add(time.Second))
+	diffs, err := DiffFiles(c, DiffFilesOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Unexpected diffs for an index newer than the file: %v", diffs)
+	}
+
+	// If it has the same mtime, the file is hashed.
+	setIndexTime(stat.ModTime())
+	diffs, err = DiffFiles(c, DiffFilesOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Name != "foo.txt" {
+		t.Fatalf("Unexpected diffs for a racily clean file: got %v want foo.txt", diffs)
+	}
+
+	// Status refreshes the index first, which must not make the change
+	// look clean.
+	short, err := Status(c, StatusOptions{Short: true, UntrackedMode: StatusUntrackedNo}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := " M foo.txt\n"; short != want {
+		t.Errorf("Unexpected short status: got %q want %q", short, want)
+	}
+}
+
+func BenchmarkDiffFiles(b *testing.B) {
+	c, cleanup := testRepo(b, "gitdifffilesbench")
+	defer cleanup()
+	testCommitManyFiles(b, c, 1000)
+
+	for _, threads := range []string{"1", "true"} {
+		b.Run("index.threads="+threads, func(b *testing.B) {
+			c.SetCachedConfig("index.threads", threads)
+			for n := 0; n < b.N; n++ {
+				if _, err := DiffFiles(c, DiffFilesOptions{}, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -51,7 +51,13 @@ func DiffIndex(c *Client, opt DiffIndexOptions, index *Index, tree Treeish, path
 
 	var val []HashDiff
 
-	for _, entry := range index.Objects {
+	objects := index.Objects
+	if index.IsSparse() {
+		if objects, err = expandSparseDirs(c, objects, tree); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range objects {
 		f, err := entry.PathName.FilePath(c)
 		if err != nil {
 			return nil, err
@@ -60,7 +66,22 @@ func DiffIndex(c *Client, opt DiffIndexOptions, index *Index, tree Treeish, path
 		var fssha Sha1
 		mode := ModeBlob
 		var fsize uint
-		if !opt.Cached {
+		if !opt.Cached && entry.Mode == ModeGitlink {
+			head, err := gitlinkHead(c, entry.PathName)
+			if err != nil {
+				return nil, err
+			}
+			mode = ModeGitlink
+			if head == (CommitID{}) {
+				// A submodule that isn't checked out is
+				// compared using the index.
+				fssha = entry.Sha1
+			} else {
+				fssha = Sha1(head)
+			}
+		} else if !opt.Cached && !entry.SkipWorktree() {
+			// Files with the skip-worktree bit are compared using
+			// the index, since they aren't in the work tree.
 			fssha1, data, err := HashFile("blob", f.String())
 			if err != nil {
 				// err means file was deleted, which isn't really an error, so ignore
@@ -77,16 +98,16 @@ func DiffIndex(c *Client, opt DiffIndexOptions, index *Index, tree Treeish, path
 		}
 
 		if entry.Sha1 != fssha {
-			val = append(val, HashDiff{entry.PathName, treeObjects[entry.PathName].Tree, TreeEntry{Sha1: Sha1{}, FileMode: mode}, treeObjects[entry.PathName].Size, 0})
+			val = append(val, HashDiff{Name: entry.PathName, Src: treeObjects[entry.PathName].Tree, Dst: TreeEntry{Sha1: Sha1{}, FileMode: mode}, SrcSize: treeObjects[entry.PathName].Size})
 		} else if !ok {
-			val = append(val, HashDiff{entry.PathName, TreeEntry{}, TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, 0, fsize})
+			val = append(val, HashDiff{Name: entry.PathName, Dst: TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, DstSize: fsize})
 		} else if entry.Sha1 != treeSha.Tree.Sha1 {
-			val = append(val, HashDiff{entry.PathName, treeSha.Tree, TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, treeSha.Size, fsize})
+			val = append(val, HashDiff{Name: entry.PathName, Src: treeSha.Tree, Dst: TreeEntry{Sha1: entry.Sha1, FileMode: entry.Mode}, SrcSize: treeSha.Size, DstSize: fsize})
 		} else {
 			if err != nil {
 				return nil, err
 			}
 		}
 	}
-	return val, nil
+	return opt.filterRelative(c, val)
 }
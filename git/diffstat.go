@@ -0,0 +1,168 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The number of lines added and removed in a file for a diffstat.
+type fileStat struct {
+	Name             IndexPath
+	Added, Deleted   int
+	Binary           bool
+	SrcSize, DstSize int
+}
+
+// Returns the content of the blob in entry, or nothing if entry is
+// not a blob that exists.
+func diffStatContent(c *Client, entry TreeEntry) ([]byte, error) {
+	if entry.Sha1 == (Sha1{}) || entry.FileMode == ModeGitlink {
+		return nil, nil
+	}
+	obj, err := c.GetObject(entry.Sha1)
+	if err != nil {
+		return nil, err
+	}
+	return obj.GetContent(), nil
+}
+
+// Returns true if content looks like a binary file, using the same
+// heuristic as git of looking for a NUL in the first 8000 bytes.
+func isBinaryContent(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+func diffFileStat(c *Client, d HashDiff) (fileStat, error) {
+	src, err := diffStatContent(c, d.Src)
+	if err != nil {
+		return fileStat{}, err
+	}
+	dst, err := diffStatContent(c, d.Dst)
+	if err != nil {
+		return fileStat{}, err
+	}
+	stat := fileStat{Name: d.Name, SrcSize: len(src), DstSize: len(dst)}
+	if isBinaryContent(src) || isBinaryContent(dst) {
+		stat.Binary = true
+		return stat, nil
+	}
+	a, b := splitLines(string(src)), splitLines(string(dst))
+	common := len(diffLines(a, b))
+	stat.Added, stat.Deleted = len(b)-common, len(a)-common
+	return stat, nil
+}
+
+// Scales the number of changes n so that max changes fit in width
+// columns, while anything which changed gets at least one column.
+func scaleDiffStat(n, width, max int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + (n*(width-1))/max
+}
+
+// Writes the diffstat for diffs, in the format of "git diff --stat", to
+// w. The widths of each part of the stat are calculated in the same way as
+// git for an 80 column output.
+func writeDiffStat(c *Client, diffs []HashDiff, w io.Writer) error {
+	stats := make([]fileStat, 0, len(diffs))
+	maxLen, maxChange := 0, 0
+	binWidth := 0
+	for _, d := range diffs {
+		stat, err := diffFileStat(c, d)
+		if err != nil {
+			return err
+		}
+		stats = append(stats, stat)
+		if l := len(stat.Name); l > maxLen {
+			maxLen = l
+		}
+		if stat.Binary {
+			if l := len(fmt.Sprintf("Bin %d -> %d bytes", stat.SrcSize, stat.DstSize)); l > binWidth {
+				binWidth = l
+			}
+			continue
+		}
+		if n := stat.Added + stat.Deleted; n > maxChange {
+			maxChange = n
+		}
+	}
+
+	const width = 80
+	numberWidth := len(strconv.Itoa(maxChange))
+	if binWidth > 0 && numberWidth < 3 {
+		// "Bin" is in the number's column.
+		numberWidth = 3
+	}
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxLen
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = width*3/8 - numberWidth - 6
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	var insertions, deletions int
+	for _, stat := range stats {
+		name := stat.Name.String()
+		if len(name) > nameWidth {
+			// Long names are truncated from the start, since the
+			// end of the path is more interesting.
+			name = "..." + name[len(name)-nameWidth+3:]
+		}
+		if stat.Binary {
+			fmt.Fprintf(w, " %-*s | %*s %d -> %d bytes\n", nameWidth, name, numberWidth, "Bin", stat.SrcSize, stat.DstSize)
+			continue
+		}
+		insertions += stat.Added
+		deletions += stat.Deleted
+		total := stat.Added + stat.Deleted
+		added, deleted := stat.Added, stat.Deleted
+		if graphWidth < maxChange {
+			added = scaleDiffStat(stat.Added, graphWidth, maxChange)
+			deleted = scaleDiffStat(total, graphWidth, maxChange) - added
+		}
+		graph := ""
+		if total > 0 {
+			graph = " " + strings.Repeat("+", added) + strings.Repeat("-", deleted)
+		}
+		fmt.Fprintf(w, " %-*s | %*d%s\n", nameWidth, name, numberWidth, total, graph)
+	}
+
+	plural := func(n int, s string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, s)
+		}
+		return fmt.Sprintf("%d %ss", n, s)
+	}
+	summary := " " + plural(len(stats), "file") + " changed"
+	if len(stats) == 0 {
+		_, err := fmt.Fprintln(w, summary)
+		return err
+	}
+	if insertions > 0 || deletions == 0 {
+		summary += ", " + plural(insertions, "insertion") + "(+)"
+	}
+	if deletions > 0 || insertions == 0 {
+		summary += ", " + plural(deletions, "deletion") + "(-)"
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
@@ -46,6 +46,10 @@ type DiffTreeOptions struct {
 
 	NoRenames bool
 
+	// Detect renames and copies between the trees. Only the raw output
+	// shows them.
+	DiffRenameOptions
+
 	// Warn if changes introduce conflict markers or whitespace errors.
 	Check bool
 
@@ -63,19 +67,25 @@ type DiffTreeOptions struct {
 	// format anyways.
 }
 
+// DiffTree returns the differences between the trees tree1 and tree2.
+// A nil tree1 is the empty tree, which is what a root commit is compared
+// to.
 func DiffTree(c *Client, opt *DiffTreeOptions, tree1, tree2 Treeish, paths []string) ([]HashDiff, error) {
-	t1, err := tree1.TreeID(c)
-	if err != nil {
-		return nil, err
-	}
 	t2, err := tree2.TreeID(c)
 	if err != nil {
 		return nil, err
 	}
 
-	tree1Objects, err := t1.GetAllObjects(c, "", opt.Recurse, opt.Recurse)
-	if err != nil {
-		return nil, err
+	tree1Objects := make(map[IndexPath]TreeEntry)
+	if tree1 != nil {
+		t1, err := tree1.TreeID(c)
+		if err != nil {
+			return nil, err
+		}
+		tree1Objects, err = t1.GetAllObjects(c, "", opt.Recurse, opt.Recurse)
+		if err != nil {
+			return nil, err
+		}
 	}
 	tree2Objects, err := t2.GetAllObjects(c, "", opt.Recurse, opt.Recurse)
 	if err != nil {
@@ -86,7 +96,7 @@ func DiffTree(c *Client, opt *DiffTreeOptions, tree1, tree2 Treeish, paths []str
 
 	for name, sha := range tree1Objects {
 		if osha := tree2Objects[name]; sha != osha {
-			val = append(val, HashDiff{name, sha, osha, 0, 0})
+			val = append(val, HashDiff{Name: name, Src: sha, Dst: osha})
 		}
 	}
 
@@ -94,11 +104,39 @@ func DiffTree(c *Client, opt *DiffTreeOptions, tree1, tree2 Treeish, paths []str
 	// would have gotten caught by the above ranging.
 	for name, sha := range tree2Objects {
 		if _, ok := tree1Objects[name]; !ok {
-			val = append(val, HashDiff{name, TreeEntry{Sha1{}, 0}, sha, 0, 0})
+			val = append(val, HashDiff{Name: name, Src: TreeEntry{Sha1{}, 0}, Dst: sha})
 		}
 	}
 
+	if opt.DiffRenameOptions.enabled() && !opt.NoRenames {
+		var unchanged map[IndexPath]TreeEntry
+		if opt.FindCopiesHarder {
+			unchanged = make(map[IndexPath]TreeEntry)
+			for name, sha := range tree1Objects {
+				if tree2Objects[name] == sha {
+					unchanged[name] = sha
+				}
+			}
+		}
+		if val, err = detectRenames(c, opt.DiffRenameOptions, val, unchanged); err != nil {
+			return nil, err
+		}
+	}
 	sort.Sort(ByName(val))
 
 	return val, nil
 }
+
+// CommitChangedFiles returns the files which commit changed compared to its
+// first parent, as the raw output of "git diff-tree -r" would list them. A
+// root commit is compared to the empty tree, so every file in it is added.
+func CommitChangedFiles(c *Client, commit CommitID) ([]HashDiff, error) {
+	parents, err := commit.Parents(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(parents) == 0 {
+		return diffCommits(c, nil, commit)
+	}
+	return diffCommits(c, parents[0], commit)
+}
@@ -0,0 +1,56 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Tests that CommitChangedFiles lists every file of an initial commit as
+// added, and only the changed files of the commits after it.
+func TestCommitChangedFiles(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcommitchangedfiles")
+	defer cleanup()
+
+	if err := os.Mkdir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dir/bar.txt", "foo.txt"} {
+		if err := ioutil.WriteFile(name, []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Add(c, AddOptions{}, []File{"dir/bar.txt", "foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	root, err := Commit(c, CommitOptions{}, "initial", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := CommitChangedFiles(c, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"A\tdir/bar.txt", "A\tfoo.txt"}
+	if len(diffs) != len(want) {
+		t.Fatalf("Unexpected files in the initial commit: got %v want %v", diffs, want)
+	}
+	for i, d := range diffs {
+		if got := d.NameStatus(); got != want[i] {
+			t.Errorf("File %d: got %q want %q", i, got, want[i])
+		}
+		if d.Src != (TreeEntry{}) || d.Dst.FileMode != ModeBlob {
+			t.Errorf("File %d was not added: %+v", i, d)
+		}
+	}
+
+	second := testCommitFile(t, c, "foo.txt", "changed\n", "second")
+	diffs, err = CommitChangedFiles(c, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].NameStatus() != "M\tfoo.txt" {
+		t.Errorf("Unexpected files in the second commit: got %v", diffs)
+	}
+}
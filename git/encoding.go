@@ -0,0 +1,154 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// The characters of Windows-1252 from 0x80 to 0x9f, which are control
+// characters in ISO-8859-1. The unused positions are 0.
+var windows1252 = [32]rune{
+	0x20ac, 0, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017d, 0,
+	0, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0, 0x017e, 0x0178,
+}
+
+// The characters of ISO-8859-15 which are different from ISO-8859-1.
+var iso885915 = map[byte]rune{
+	0xa4: 0x20ac, 0xa6: 0x0160, 0xa8: 0x0161, 0xb4: 0x017d,
+	0xb8: 0x017e, 0xbc: 0x0152, 0xbd: 0x0153, 0xbe: 0x0178,
+}
+
+// A charset is a single byte character set which can be converted to and
+// from UTF-8.
+type charset func(b byte) rune
+
+// Returns the charset called name, or nil if it's UTF-8 or isn't
+// supported. The second return value is false if it isn't supported.
+func lookupCharset(name string) (charset, bool) {
+	name = strings.Replace(strings.ToLower(name), "_", "-", -1)
+	switch name {
+	case "", "utf-8", "utf8":
+		return nil, true
+	case "us-ascii", "ascii", "ansi-x3.4-1968":
+		return func(b byte) rune {
+			if b >= 0x80 {
+				return utf8.RuneError
+			}
+			return rune(b)
+		}, true
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1", "l1":
+		return func(b byte) rune { return rune(b) }, true
+	case "iso-8859-15", "iso8859-15", "latin9", "latin-9":
+		return func(b byte) rune {
+			if r, ok := iso885915[b]; ok {
+				return r
+			}
+			return rune(b)
+		}, true
+	case "windows-1252", "cp1252":
+		return func(b byte) rune {
+			if b >= 0x80 && b < 0xa0 {
+				if r := windows1252[b-0x80]; r != 0 {
+					return r
+				}
+				return utf8.RuneError
+			}
+			return rune(b)
+		}, true
+	}
+	return nil, false
+}
+
+// Converts data from the character set from to the character set to. If
+// either of them isn't supported, data is returned unchanged, the same
+// way as git does when iconv fails. Characters which can't be represented
+// in to are replaced by "?".
+func reencode(data []byte, from, to string) []byte {
+	src, ok := lookupCharset(from)
+	if !ok {
+		return data
+	}
+	dst, ok := lookupCharset(to)
+	if !ok {
+		return data
+	}
+	if src == nil && dst == nil {
+		return data
+	}
+
+	// Decode to UTF-8 first.
+	decoded := data
+	if src != nil {
+		var buf bytes.Buffer
+		for _, b := range data {
+			buf.WriteRune(src(b))
+		}
+		decoded = buf.Bytes()
+	}
+	if dst == nil {
+		return decoded
+	}
+
+	encoded := make(map[rune]byte, 256)
+	for i := 255; i >= 0; i-- {
+		if r := dst(byte(i)); r != utf8.RuneError {
+			encoded[r] = byte(i)
+		}
+	}
+	var buf bytes.Buffer
+	for len(decoded) > 0 {
+		r, sz := utf8.DecodeRune(decoded)
+		decoded = decoded[sz:]
+		if b, ok := encoded[r]; ok && r != utf8.RuneError {
+			buf.WriteByte(b)
+		} else {
+			buf.WriteByte('?')
+		}
+	}
+	return buf.Bytes()
+}
+
+// Returns the character set that commits are written in, from
+// i18n.commitEncoding, or the empty string for UTF-8.
+func commitEncoding(c *Client) string {
+	if enc := c.GetConfig("i18n.commitEncoding"); !isUTF8Charset(enc) {
+		return enc
+	}
+	return ""
+}
+
+// Returns the character set that log messages are shown in. As in git,
+// it's i18n.logOutputEncoding if that's set, and otherwise
+// i18n.commitEncoding, or UTF-8 if neither is set. The locale isn't used.
+func logOutputEncoding(c *Client) string {
+	if enc := c.GetConfig("i18n.logOutputEncoding"); enc != "" {
+		return enc
+	}
+	if enc := c.GetConfig("i18n.commitEncoding"); enc != "" {
+		return enc
+	}
+	return "UTF-8"
+}
+
+// Returns true if name is UTF-8, which is also the default when there's no
+// name.
+func isUTF8Charset(name string) bool {
+	switch strings.ToLower(name) {
+	case "", "utf-8", "utf8":
+		return true
+	}
+	return false
+}
+
+// Converts s, which is part of the commit cmt, from the encoding in the
+// commit's encoding header to the log output encoding.
+func (cmt CommitID) logReencode(c *Client, s string) (string, error) {
+	obj, err := c.GetCommitObject(cmt)
+	if err != nil {
+		return "", err
+	}
+	return string(reencode([]byte(s), obj.GetHeader("encoding"), logOutputEncoding(c))), nil
+}
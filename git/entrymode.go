@@ -12,8 +12,11 @@ const (
 	ModeBlob    = EntryMode(0100644)
 	ModeExec    = EntryMode(0100755)
 	ModeSymlink = EntryMode(0120000)
-	ModeCommit  = EntryMode(0160000)
+	ModeGitlink = EntryMode(0160000)
 	ModeTree    = EntryMode(0040000)
+
+	// ModeCommit is the old name for ModeGitlink.
+	ModeCommit = ModeGitlink
 )
 
 // TreeType prints the entry mode as the type that shows up in the "git ls-tree"
@@ -23,7 +26,7 @@ func (e EntryMode) TreeType() string {
 	switch e {
 	case ModeBlob, ModeExec, ModeSymlink:
 		return "blob"
-	case ModeCommit:
+	case ModeGitlink:
 		return "commit"
 	case ModeTree:
 		return "tree"
@@ -41,7 +44,7 @@ func ModeFromString(s string) (EntryMode, error) {
 	case "120000":
 		return ModeSymlink, nil
 	case "160000":
-		return ModeCommit, nil
+		return ModeGitlink, nil
 	case "040000":
 		return ModeTree, nil
 	default:
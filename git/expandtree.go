@@ -18,41 +18,23 @@ import (
 // tree object, and not other data which is necessary for reading into an index (ie. file
 // size)
 func expandGitTreeIntoIndexes(c *Client, tree Treeish, recurse, showTreeEntry, treeOnly bool) ([]*IndexEntry, error) {
-	sha1, err := tree.TreeID(c)
-	if err != nil {
-		return nil, err
-	}
-	t, err := sha1.TreeID(c)
-	if err != nil {
-		return nil, err
-	}
-
-	newEntries, err := expandGitTreeIntoIndexesRecursive(c, t, "", recurse, showTreeEntry, treeOnly)
+	newEntries, err := treeIndexEntries(c, tree, recurse, showTreeEntry, treeOnly)
 	if err != nil {
 		return nil, err
 	}
 
+	// The index order isn't the same as the tree order, since trees
+	// are sorted as if they had a trailing slash.
 	sort.Sort(ByPath(newEntries))
 	return newEntries, nil
 }
 
-// This should not be called directly. It recurses into sub-trees to fully expand
-// all child trees. After calling this function the IndexEntries are *not* sorted
-// as the git index format requires.
-func expandGitTreeIntoIndexesRecursive(c *Client, t TreeID, prefix string, recurse bool, showTreeEntry, treeOnly bool) ([]*IndexEntry, error) {
-	vals, err := t.GetAllObjects(c, "", false, showTreeEntry)
-	if err != nil {
-		return nil, err
-	}
-
-	newEntries := make([]*IndexEntry, 0, len(vals))
-	for path, treeEntry := range vals {
-		var dirname IndexPath
-		if prefix == "" {
-			dirname = path
-		} else {
-			dirname = IndexPath(prefix) + "/" + path
-		}
+// Returns the same entries as expandGitTreeIntoIndexes, in the order that
+// WalkTree visits them instead of the order of an index.
+func treeIndexEntries(c *Client, tree Treeish, recurse, showTreeEntry, treeOnly bool) ([]*IndexEntry, error) {
+	var newEntries []*IndexEntry
+	err := WalkTree(c, tree, func(path string, treeEntry TreeEntry) error {
+		dirname := IndexPath(path)
 		if (treeEntry.FileMode != ModeTree) || showTreeEntry || !recurse {
 
 			newEntry := IndexEntry{}
@@ -65,7 +47,7 @@ func expandGitTreeIntoIndexesRecursive(c *Client, t TreeID, prefix string, recur
 				// not in the tree.
 				obj, err := c.GetObject(treeEntry.Sha1)
 				if err != nil {
-					return nil, err
+					return err
 				}
 				newEntry.Fsize = uint32(obj.GetSize())
 
@@ -84,13 +66,13 @@ func expandGitTreeIntoIndexesRecursive(c *Client, t TreeID, prefix string, recur
 			}
 			newEntries = append(newEntries, &newEntry)
 		}
-		if treeEntry.FileMode == ModeTree && recurse {
-			subindexes, err := expandGitTreeIntoIndexesRecursive(c, TreeID(treeEntry.Sha1), dirname.String(), recurse, showTreeEntry, treeOnly)
-			if err != nil {
-				return nil, err
-			}
-			newEntries = append(newEntries, subindexes...)
+		if treeEntry.FileMode == ModeTree && !recurse {
+			return SkipTree
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return newEntries, nil
 }
@@ -0,0 +1,347 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// FastExportOptions are the options which may be passed to FastExport.
+type FastExportOptions struct {
+	// Don't output blob contents. File modifications refer to the blob
+	// by its Sha1 rather than a mark, so the importing repository must
+	// already have them.
+	NoData bool
+}
+
+// A fastExporter keeps track of the state needed while writing a fast-export
+// stream.
+type fastExporter struct {
+	c    *Client
+	opts FastExportOptions
+	w    io.Writer
+
+	nextMark int
+	marks    map[Sha1]int
+}
+
+func (e *fastExporter) mark(obj Sha1) int {
+	e.nextMark++
+	e.marks[obj] = e.nextMark
+	return e.nextMark
+}
+
+// Returns the reference to use for obj in the stream, either its mark or its
+// Sha1 if it wasn't exported.
+func (e *fastExporter) dataref(obj Sha1) string {
+	if m, ok := e.marks[obj]; ok {
+		return fmt.Sprintf(":%d", m)
+	}
+	return obj.String()
+}
+
+func (e *fastExporter) data(content []byte) error {
+	if _, err := fmt.Fprintf(e.w, "data %d\n", len(content)); err != nil {
+		return err
+	}
+	_, err := e.w.Write(content)
+	return err
+}
+
+// Quotes path in the way that fast-import expects if it contains characters
+// which would otherwise be misinterpreted. As in git, paths with spaces are
+// quoted too, since they'd be ambiguous in a rename or copy.
+func fastExportPath(path IndexPath) string {
+	return quoteStatusPath(path.String())
+}
+
+// Splits the raw content of a commit or tag object into its headers
+// and message.
+func splitObjectMessage(content []byte) (headers []string, message []byte) {
+	pieces := bytes.SplitN(content, []byte("\n\n"), 2)
+	for _, line := range strings.Split(string(pieces[0]), "\n") {
+		if strings.HasPrefix(line, " ") && len(headers) > 0 {
+			// A continuation of a multiline header, like gpgsig
+			headers[len(headers)-1] += "\n" + line[1:]
+			continue
+		}
+		headers = append(headers, line)
+	}
+	if len(pieces) == 2 {
+		message = pieces[1]
+	}
+	return
+}
+
+func objectHeader(headers []string, name string) (string, bool) {
+	for _, h := range headers {
+		if strings.HasPrefix(h, name+" ") {
+			return strings.TrimPrefix(h, name+" "), true
+		}
+	}
+	return "", false
+}
+
+// Returns the files (not including trees) in tree, keyed by path.
+func fastExportFiles(c *Client, cmt CommitID) (map[IndexPath]TreeEntry, error) {
+	tree, err := cmt.TreeID(c)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := tree.GetAllObjects(c, "", true, true)
+	if err != nil {
+		return nil, err
+	}
+	for path, entry := range entries {
+		if entry.FileMode == ModeTree {
+			delete(entries, path)
+		}
+	}
+	return entries, nil
+}
+
+func (e *fastExporter) commit(cmt CommitID, ref string, needsReset bool) error {
+	obj, err := e.c.GetCommitObject(cmt)
+	if err != nil {
+		return err
+	}
+	headers, message := splitObjectMessage(obj.GetContent())
+	parents, err := cmt.Parents(e.c)
+	if err != nil {
+		return err
+	}
+
+	files, err := fastExportFiles(e.c, cmt)
+	if err != nil {
+		return err
+	}
+	old := make(map[IndexPath]TreeEntry)
+	if len(parents) > 0 {
+		if old, err = fastExportFiles(e.c, parents[0]); err != nil {
+			return err
+		}
+	}
+
+	var deleted, modified []IndexPath
+	for path := range old {
+		if _, ok := files[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+	for path, entry := range files {
+		if oldentry, ok := old[path]; !ok || oldentry != entry {
+			modified = append(modified, path)
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i] < deleted[j] })
+	sort.Slice(modified, func(i, j int) bool { return modified[i] < modified[j] })
+
+	// Blobs need to be written before the commit that refers to them.
+	if !e.opts.NoData {
+		for _, path := range modified {
+			entry := files[path]
+			if entry.FileMode == ModeGitlink {
+				continue
+			}
+			if _, ok := e.marks[entry.Sha1]; ok {
+				continue
+			}
+			blob, err := e.c.GetObject(entry.Sha1)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(e.w, "blob\nmark :%d\n", e.mark(entry.Sha1)); err != nil {
+				return err
+			}
+			if err := e.data(blob.GetContent()); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprint(e.w, "\n"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if needsReset {
+		if _, err := fmt.Fprintf(e.w, "reset %v\n", ref); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(e.w, "commit %v\nmark :%d\n", ref, e.mark(Sha1(cmt))); err != nil {
+		return err
+	}
+	for _, name := range []string{"author", "committer", "encoding"} {
+		if val, ok := objectHeader(headers, name); ok {
+			if _, err := fmt.Fprintf(e.w, "%v %v\n", name, val); err != nil {
+				return err
+			}
+		}
+	}
+	if err := e.data(message); err != nil {
+		return err
+	}
+	for i, p := range parents {
+		cmd := "merge"
+		if i == 0 {
+			cmd = "from"
+		}
+		if _, err := fmt.Fprintf(e.w, "%v %v\n", cmd, e.dataref(Sha1(p))); err != nil {
+			return err
+		}
+	}
+	for _, path := range deleted {
+		if _, err := fmt.Fprintf(e.w, "D %v\n", fastExportPath(path)); err != nil {
+			return err
+		}
+	}
+	for _, path := range modified {
+		entry := files[path]
+		if _, err := fmt.Fprintf(e.w, "M %o %v %v\n", entry.FileMode, e.dataref(entry.Sha1), fastExportPath(path)); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprint(e.w, "\n")
+	return err
+}
+
+func (e *fastExporter) tag(tag Sha1, name string) error {
+	obj, err := e.c.GetObject(tag)
+	if err != nil {
+		return err
+	}
+	headers, message := splitObjectMessage(obj.GetContent())
+	if bytes.Contains(message, []byte("-----BEGIN PGP SIGNATURE-----")) {
+		return fmt.Errorf("Can not export signed tag %v", name)
+	}
+	if typ, _ := objectHeader(headers, "type"); typ != "commit" {
+		return fmt.Errorf("Can not export tag %v of %v", name, typ)
+	}
+	target, _ := objectHeader(headers, "object")
+	sha, err := Sha1FromString(target)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(e.w, "tag %v\nfrom %v\n", strings.TrimPrefix(name, "refs/tags/"), e.dataref(sha)); err != nil {
+		return err
+	}
+	if tagger, ok := objectHeader(headers, "tagger"); ok {
+		if _, err := fmt.Fprintf(e.w, "tagger %v\n", tagger); err != nil {
+			return err
+		}
+	}
+	if err := e.data(message); err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(e.w, "\n")
+	return err
+}
+
+// Returns the name of the reference that rev refers to, if it's a reference.
+func fastExportRefName(rev Commitish) (string, bool) {
+	switch r := rev.(type) {
+	case Branch:
+		return r.String(), true
+	case RefSpec:
+		return r.String(), true
+	}
+	return "", false
+}
+
+// FastExport writes the history of revs to w as a git fast-import stream,
+// in the same way as "git fast-export".
+//
+// Every commit reachable from revs is exported along with the blobs that it
+// introduces. Commits are labelled with the first reference in revs they're
+// reachable from, so every rev must be a reference. Annotated tags are
+// exported as tag commands, but signed tags are not supported.
+func FastExport(c *Client, opts FastExportOptions, revs []Commitish, w io.Writer) error {
+	e := &fastExporter{c: c, opts: opts, w: w, marks: make(map[Sha1]int)}
+
+	// The ref that each commit will be exported under.
+	labels := make(map[CommitID]string)
+	var names []string
+	var tips []CommitID
+	for _, rev := range revs {
+		name, ok := fastExportRefName(rev)
+		if !ok {
+			return fmt.Errorf("Can not export %v: not a reference", rev)
+		}
+		tip, err := rev.CommitID(c)
+		if err != nil {
+			return err
+		}
+		cmts, err := RevList(c, RevListOptions{Quiet: true}, ioutil.Discard, []Commitish{tip}, nil)
+		if err != nil {
+			return err
+		}
+		for _, cmt := range cmts {
+			if _, ok := labels[CommitID(cmt)]; !ok {
+				labels[CommitID(cmt)] = name
+			}
+		}
+		names = append(names, name)
+		tips = append(tips, tip)
+	}
+
+	// Sort the commits so that parents always come before their children.
+	var order []CommitID
+	visited := make(map[CommitID]bool)
+	var visit func(cmt CommitID) error
+	visit = func(cmt CommitID) error {
+		if visited[cmt] {
+			return nil
+		}
+		visited[cmt] = true
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return err
+		}
+		for _, p := range parents {
+			if err := visit(p); err != nil {
+				return err
+			}
+		}
+		order = append(order, cmt)
+		return nil
+	}
+	for _, tip := range tips {
+		if err := visit(tip); err != nil {
+			return err
+		}
+	}
+
+	started := make(map[string]bool)
+	for _, cmt := range order {
+		ref := labels[cmt]
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return err
+		}
+		// A new root commit on a ref that already has history needs
+		// to reset it, or fast-import will use the previous commit
+		// as its parent.
+		if err := e.commit(cmt, ref, len(parents) == 0 && started[ref]); err != nil {
+			return err
+		}
+		started[ref] = true
+	}
+
+	for i, name := range names {
+		if obj, err := RefSpec(name).Sha1(c); err == nil && obj.Type(c) == "tag" {
+			if err := e.tag(obj, name); err != nil {
+				return err
+			}
+			continue
+		}
+		if labels[tips[i]] == name {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "reset %v\nfrom %v\n\n", name, e.dataref(Sha1(tips[i]))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Tests that exporting a repository and importing it into a new one
+// reproduces the same history.
+func TestFastExportImport(t *testing.T) {
+	c, cleanup := testRepo(t, "gitfastexport")
+	defer cleanup()
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "first")
+	testCommitFile(t, c, "dir/sub/bar.txt", "bar\n", "second")
+	testCommitFile(t, c, "with space", "space\n", "space")
+	testCommitFile(t, c, "q\"uote", "quote\n", "quote")
+	testCommitFile(t, c, "\u00e9", "accent\n", "accent")
+	if _, err := Rm(c, RmOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	head := testCommitFile(t, c, "dir/baz.txt", "baz\n", "third")
+
+	var stream bytes.Buffer
+	if err := FastExport(c, FastExportOptions{}, []Commitish{Branch("refs/heads/master")}, &stream); err != nil {
+		t.Fatal(err)
+	}
+	// Paths are quoted as git quotes them, including paths with spaces.
+	for _, path := range []string{` "with space"` + "\n", ` "q\"uote"` + "\n", ` "\303\251"` + "\n"} {
+		if !strings.Contains(stream.String(), path) {
+			t.Errorf("Path %q not quoted in stream:\n%s", path, stream.String())
+		}
+	}
+	if err := FastExport(c, FastExportOptions{}, []Commitish{head}, ioutil.Discard); err == nil {
+		t.Error("Expected error exporting a commit which is not a reference")
+	}
+
+	dir, err := ioutil.TempDir("", "gitfastimport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := FastImport(dst, FastImportOptions{}, &stream); err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := Branch("refs/heads/master").CommitID(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := head.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := imported.TreeID(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Unexpected tree after import: got %v want %v", got, want)
+	}
+	// The authors, committers and messages are preserved, so the commits
+	// should be identical.
+	if imported != head {
+		t.Errorf("Unexpected commit after import: got %v want %v", imported, head)
+	}
+}
@@ -0,0 +1,539 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FastImportOptions are the options which may be passed to FastImport.
+type FastImportOptions struct {
+	// Update references even if the new value is not a descendant of
+	// the old one.
+	Force bool
+}
+
+// A fastImporter keeps track of the state while reading a fast-import
+// stream.
+type fastImporter struct {
+	c    *Client
+	opts FastImportOptions
+	r    *bufio.Reader
+
+	// A line which was read but not consumed by the previous command.
+	pending    string
+	hasPending bool
+
+	marks map[string]Sha1
+
+	// The commits (or tags) that refs will be updated to at the end of
+	// the import, and the order that they were first seen in.
+	refs     map[string]Sha1
+	refOrder []string
+}
+
+// Reads the next line from the stream, without the trailing newline.
+func (fi *fastImporter) readLine() (string, error) {
+	if fi.hasPending {
+		fi.hasPending = false
+		return fi.pending, nil
+	}
+	line, err := fi.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimSuffix(line, "\n"), err
+}
+
+func (fi *fastImporter) unreadLine(line string) {
+	fi.pending = line
+	fi.hasPending = true
+}
+
+// Reads a data command, in either the exact byte count or delimited
+// format.
+func (fi *fastImporter) readData() ([]byte, error) {
+	line, err := fi.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "data ") {
+		return nil, fmt.Errorf("Expected 'data' command, got %q", line)
+	}
+	arg := strings.TrimPrefix(line, "data ")
+	if strings.HasPrefix(arg, "<<") {
+		delim := arg[2:]
+		var buf bytes.Buffer
+		for {
+			line, err := fi.r.ReadString('\n')
+			if err != nil {
+				return nil, fmt.Errorf("Unterminated data: %v", err)
+			}
+			if strings.TrimSuffix(line, "\n") == delim {
+				return buf.Bytes(), nil
+			}
+			buf.WriteString(line)
+		}
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid data length %q", arg)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(fi.r, data); err != nil {
+		return nil, err
+	}
+	// Data may optionally be followed by a newline.
+	if b, err := fi.r.Peek(1); err == nil && b[0] == '\n' {
+		fi.r.ReadByte()
+	}
+	return data, nil
+}
+
+// Reads an optional "name value" line, returning the value if it is present.
+func (fi *fastImporter) readOptional(name string) (string, bool, error) {
+	line, err := fi.readLine()
+	if err != nil {
+		if err == io.EOF {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if strings.HasPrefix(line, name+" ") {
+		return strings.TrimPrefix(line, name+" "), true, nil
+	}
+	fi.unreadLine(line)
+	return "", false, nil
+}
+
+// Resolves a reference in the stream to a mark, a ref which is being
+// imported, or an existing object.
+func (fi *fastImporter) resolve(ref string) (Sha1, error) {
+	if strings.HasPrefix(ref, ":") {
+		sha, ok := fi.marks[ref]
+		if !ok {
+			return Sha1{}, fmt.Errorf("Mark %v not declared", ref)
+		}
+		return sha, nil
+	}
+	if sha, ok := fi.refs[ref]; ok {
+		return sha, nil
+	}
+	if sha, err := Sha1FromString(ref); err == nil && len(ref) == 40 {
+		return sha, nil
+	}
+	cmt, err := RevParseCommitish(fi.c, &RevParseOptions{}, ref)
+	if err != nil {
+		return Sha1{}, err
+	}
+	cid, err := cmt.CommitID(fi.c)
+	return Sha1(cid), err
+}
+
+func (fi *fastImporter) setRef(name string, sha Sha1) {
+	if _, ok := fi.refs[name]; !ok {
+		fi.refOrder = append(fi.refOrder, name)
+	}
+	fi.refs[name] = sha
+}
+
+func (fi *fastImporter) blob() error {
+	mark, _, err := fi.readOptional("mark")
+	if err != nil {
+		return err
+	}
+	if _, _, err := fi.readOptional("original-oid"); err != nil {
+		return err
+	}
+	data, err := fi.readData()
+	if err != nil {
+		return err
+	}
+	sha, err := fi.c.WriteObject("blob", data)
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		fi.marks[mark] = sha
+	}
+	return nil
+}
+
+// Parses a path in a file command, returning the path and the rest of the
+// line after it. If last is true, the path is the remainder of the line.
+func fastImportPath(s string, last bool) (IndexPath, string, error) {
+	if strings.HasPrefix(s, "\"") {
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return "", "", err
+		}
+		path, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", "", err
+		}
+		return IndexPath(path), strings.TrimPrefix(s[len(quoted):], " "), nil
+	}
+	if last {
+		return IndexPath(s), "", nil
+	}
+	pieces := strings.SplitN(s, " ", 2)
+	if len(pieces) != 2 {
+		return "", "", fmt.Errorf("Missing destination path in %q", s)
+	}
+	return IndexPath(pieces[0]), pieces[1], nil
+}
+
+func fastImportMode(s string) (EntryMode, error) {
+	switch s {
+	case "644", "100644":
+		return ModeBlob, nil
+	case "755", "100755":
+		return ModeExec, nil
+	case "120000":
+		return ModeSymlink, nil
+	case "160000":
+		return ModeGitlink, nil
+	}
+	return 0, fmt.Errorf("Unsupported file mode %v", s)
+}
+
+// Applies the file command in line to files.
+func (fi *fastImporter) fileCommand(line string, files map[IndexPath]TreeEntry) error {
+	switch {
+	case line == "deleteall":
+		for path := range files {
+			delete(files, path)
+		}
+	case strings.HasPrefix(line, "M "):
+		pieces := strings.SplitN(line, " ", 4)
+		if len(pieces) != 4 {
+			return fmt.Errorf("Invalid file modify command %q", line)
+		}
+		mode, err := fastImportMode(pieces[1])
+		if err != nil {
+			return err
+		}
+		path, _, err := fastImportPath(pieces[3], true)
+		if err != nil {
+			return err
+		}
+		var sha Sha1
+		switch {
+		case pieces[2] == "inline":
+			data, err := fi.readData()
+			if err != nil {
+				return err
+			}
+			if sha, err = fi.c.WriteObject("blob", data); err != nil {
+				return err
+			}
+		case mode == ModeGitlink:
+			if sha, err = Sha1FromString(pieces[2]); err != nil {
+				return err
+			}
+		default:
+			if sha, err = fi.resolve(pieces[2]); err != nil {
+				return err
+			}
+		}
+		files[path] = TreeEntry{Sha1: sha, FileMode: mode}
+	case strings.HasPrefix(line, "D "):
+		path, _, err := fastImportPath(line[2:], true)
+		if err != nil {
+			return err
+		}
+		// Deleting a directory deletes everything under it.
+		for p := range files {
+			if p == path || strings.HasPrefix(p.String(), path.String()+"/") {
+				delete(files, p)
+			}
+		}
+	case strings.HasPrefix(line, "R "), strings.HasPrefix(line, "C "):
+		src, rest, err := fastImportPath(line[2:], false)
+		if err != nil {
+			return err
+		}
+		dst, _, err := fastImportPath(rest, true)
+		if err != nil {
+			return err
+		}
+		moved := make(map[IndexPath]TreeEntry)
+		for p, entry := range files {
+			if p != src && !strings.HasPrefix(p.String(), src.String()+"/") {
+				continue
+			}
+			moved[dst+p[len(src):]] = entry
+			if line[0] == 'R' {
+				delete(files, p)
+			}
+		}
+		for p, entry := range moved {
+			files[p] = entry
+		}
+	default:
+		return fmt.Errorf("Unsupported file command %q", line)
+	}
+	return nil
+}
+
+// Writes the tree containing files.
+func fastImportTree(c *Client, files map[IndexPath]TreeEntry) (TreeID, error) {
+	entries := make([]*IndexEntry, 0, len(files))
+	for path, entry := range files {
+		ie := &IndexEntry{PathName: path}
+		ie.Mode = entry.FileMode
+		ie.Sha1 = entry.Sha1
+		entries = append(entries, ie)
+	}
+	sort.Sort(ByPath(entries))
+	return writeTree(c, "", entries)
+}
+
+func (fi *fastImporter) commit(ref string) error {
+	mark, _, err := fi.readOptional("mark")
+	if err != nil {
+		return err
+	}
+	if _, _, err := fi.readOptional("original-oid"); err != nil {
+		return err
+	}
+	author, hasAuthor, err := fi.readOptional("author")
+	if err != nil {
+		return err
+	}
+	committer, ok, err := fi.readOptional("committer")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Missing committer for commit on %v", ref)
+	}
+	if !hasAuthor {
+		author = committer
+	}
+	encoding, _, err := fi.readOptional("encoding")
+	if err != nil {
+		return err
+	}
+	message, err := fi.readData()
+	if err != nil {
+		return err
+	}
+
+	var parents []Sha1
+	if from, ok, err := fi.readOptional("from"); err != nil {
+		return err
+	} else if ok {
+		p, err := fi.resolve(from)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, p)
+	} else if tip, ok := fi.refs[ref]; ok && tip != (Sha1{}) {
+		// Without a from command, the commit continues the existing
+		// history of the branch.
+		parents = append(parents, tip)
+	}
+	for {
+		merge, ok, err := fi.readOptional("merge")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		p, err := fi.resolve(merge)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, p)
+	}
+
+	files := make(map[IndexPath]TreeEntry)
+	if len(parents) > 0 {
+		if files, err = fastExportFiles(fi.c, CommitID(parents[0])); err != nil {
+			return err
+		}
+	}
+	for {
+		line, err := fi.readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		if line != "deleteall" && (len(line) < 2 || line[1] != ' ' || !strings.ContainsAny(line[:1], "MDRC")) {
+			fi.unreadLine(line)
+			break
+		}
+		if err := fi.fileCommand(line, files); err != nil {
+			return err
+		}
+	}
+
+	tree, err := fastImportTree(fi.c, files)
+	if err != nil {
+		return err
+	}
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "tree %v\n", tree)
+	for _, p := range parents {
+		fmt.Fprintf(&content, "parent %v\n", p)
+	}
+	fmt.Fprintf(&content, "author %v\ncommitter %v\n", author, committer)
+	if encoding != "" {
+		fmt.Fprintf(&content, "encoding %v\n", encoding)
+	}
+	fmt.Fprintf(&content, "\n%s", message)
+	sha, err := fi.c.WriteObject("commit", content.Bytes())
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		fi.marks[mark] = sha
+	}
+	fi.setRef(ref, sha)
+	return nil
+}
+
+func (fi *fastImporter) reset(ref string) error {
+	from, ok, err := fi.readOptional("from")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// The next commit on ref starts a new history.
+		fi.setRef(ref, Sha1{})
+		return nil
+	}
+	sha, err := fi.resolve(from)
+	if err != nil {
+		return err
+	}
+	fi.setRef(ref, sha)
+	return nil
+}
+
+func (fi *fastImporter) tag(name string) error {
+	mark, _, err := fi.readOptional("mark")
+	if err != nil {
+		return err
+	}
+	from, ok, err := fi.readOptional("from")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Missing from for tag %v", name)
+	}
+	if _, _, err := fi.readOptional("original-oid"); err != nil {
+		return err
+	}
+	tagger, hasTagger, err := fi.readOptional("tagger")
+	if err != nil {
+		return err
+	}
+	message, err := fi.readData()
+	if err != nil {
+		return err
+	}
+	target, err := fi.resolve(from)
+	if err != nil {
+		return err
+	}
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "object %v\ntype %v\ntag %v\n", target, target.Type(fi.c), name)
+	if hasTagger {
+		fmt.Fprintf(&content, "tagger %v\n", tagger)
+	}
+	fmt.Fprintf(&content, "\n%s", message)
+	sha, err := fi.c.WriteObject("tag", content.Bytes())
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		fi.marks[mark] = sha
+	}
+	fi.setRef("refs/tags/"+name, sha)
+	return nil
+}
+
+// Updates the references that were imported.
+func (fi *fastImporter) updateRefs() error {
+	for _, name := range fi.refOrder {
+		sha := fi.refs[name]
+		if sha == (Sha1{}) {
+			continue
+		}
+		ref := RefSpec(name)
+		if !fi.opts.Force && sha.Type(fi.c) == "commit" {
+			if old, err := ref.CommitID(fi.c); err == nil && old != CommitID(sha) && !old.IsAncestor(fi.c, CommitID(sha)) {
+				return fmt.Errorf("Not updating %v (new tip %v does not contain %v)", name, sha, old)
+			}
+		}
+		if err := UpdateRefSpec(fi.c, UpdateRefOptions{}, ref, CommitID(sha), "fast-import"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FastImport reads a git fast-import stream from r, such as the one produced
+// by FastExport, and creates the objects and references that it describes.
+//
+// The blob, commit, reset and tag commands are supported. Feature, option,
+// progress and checkpoint commands are accepted but otherwise ignored.
+func FastImport(c *Client, opts FastImportOptions, r io.Reader) error {
+	fi := &fastImporter{
+		c:     c,
+		opts:  opts,
+		r:     bufio.NewReader(r),
+		marks: make(map[string]Sha1),
+		refs:  make(map[string]Sha1),
+	}
+	for {
+		line, err := fi.readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		cmd := strings.SplitN(line, " ", 2)
+		arg := ""
+		if len(cmd) == 2 {
+			arg = cmd[1]
+		}
+		switch cmd[0] {
+		case "":
+			// Blank lines between commands are allowed.
+		case "blob":
+			err = fi.blob()
+		case "commit":
+			err = fi.commit(arg)
+		case "reset":
+			err = fi.reset(arg)
+		case "tag":
+			err = fi.tag(arg)
+		case "done":
+			return fi.updateRefs()
+		case "feature", "option", "progress", "checkpoint":
+		default:
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			return fmt.Errorf("Unsupported command: %v", line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return fi.updateRefs()
+}
@@ -26,6 +26,12 @@ func Fetch(c *Client, opts FetchOptions, rmt Remote, refs []RefSpec) error {
 		}
 	}
 
+	// Fetches from the promisor remote of a partial clone use the same
+	// filter as the clone did unless another one is given.
+	if opts.Filter == "" && rmt == c.promisorRemote() {
+		opts.Filter = c.GetConfig(fmt.Sprintf("remote.%s.partialclonefilter", rmt))
+	}
+
 	var wants []Refname
 	for _, ref := range refs {
 		wants = append(wants, ref.Src())
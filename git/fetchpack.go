@@ -4,10 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Refname string
@@ -30,11 +32,29 @@ type FetchPackOptions struct {
 	Thin                           bool
 	IncludeTag                     bool
 	UploadPack                     string
-	Depth                          int32
-	DeepenRelative                 bool
 	NoProgress                     bool
 	CheckSelfContainedAndConnected bool
 	Verbose                        bool
+
+	// Limit the history fetched to Depth commits from the tip of each
+	// ref. If DeepenRelative is set, the shallow boundary is moved back
+	// by Depth commits instead.
+	Depth          int32
+	DeepenRelative bool
+
+	// Limit the history fetched to commits more recent than
+	// ShallowSince, and to those not reachable from the refs in
+	// ShallowExclude.
+	ShallowSince   time.Time
+	ShallowExclude []string
+
+	// Fetch the complete history of a shallow repository.
+	Unshallow bool
+
+	// Ask the server to omit the objects excluded by the filter-spec
+	// Filter from the pack, for a partial clone. See validateFilter for
+	// the filters which are supported.
+	Filter string
 }
 
 // FetchPack fetches a packfile from rmt. It uses wants to retrieve the refnames
@@ -80,6 +100,22 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 			return nil, err
 		}
 	}
+	if opts.Filter != "" {
+		if err := validateFilter(opts.Filter); err != nil {
+			return nil, err
+		}
+	}
+	shallow := c.IsShallow()
+	if opts.Unshallow && !shallow {
+		return nil, fmt.Errorf("--unshallow on a complete repository does not make sense")
+	}
+	// When the shallow boundary is being changed, the tips are wanted
+	// even if we already have them, so that the server sends the
+	// history behind them.
+	deepening := opts.deepening()
+	// The changes to the shallow boundary sent by the server, which are
+	// applied once the pack has been received.
+	var newShallow, newUnshallow []Sha1
 
 	// FIXME: This should be configurable
 	conn.SetSideband(os.Stderr)
@@ -96,12 +132,20 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 	case 2:
 		log.Println("Using protocol version 2 for fetch-pack")
 		capabilities := conn.Capabilities()
-		// Discard the extra capabilities advertised by the server
-		// because we don't support any of them yet.
-		_, ok := capabilities["fetch"]
+		fetchcaps, ok := capabilities["fetch"]
 		if !ok {
 			return nil, fmt.Errorf("Server did not advertise fetch capability")
 		}
+		// If the server supports it, ask for refs by name rather than
+		// by the object ID from ls-refs, so that the server sends
+		// what the ref points to when it sends the pack.
+		_, refInWant := fetchcaps["ref-in-want"]
+		if _, ok := fetchcaps["filter"]; opts.Filter != "" && !ok {
+			return nil, fmt.Errorf("Server does not support filtering objects")
+		}
+		if _, ok := fetchcaps["shallow"]; (shallow || deepening) && !ok {
+			return nil, fmt.Errorf("Server does not support shallow clients")
+		}
 		// First we use ls-refs to get a list of references that we
 		// want.
 		var rs []string = make([]string, len(wants))
@@ -121,10 +165,21 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 				objects[sha] = true
 			}
 		}
+		var wantrefs []string
 		for _, ref := range rmtrefs {
+			if refInWant {
+				have, _, err := c.HaveObject(ref.Value)
+				if err != nil {
+					return nil, err
+				}
+				if !have || deepening {
+					wantrefs = append(wantrefs, ref.Name)
+				}
+				continue
+			}
 			objects[ref.Value] = true
 		}
-		log.Printf("Fetching these objects: %+v\n", objects)
+		log.Printf("Fetching these objects: %+v and refs: %v\n", objects, wantrefs)
 		refs = rmtrefs
 
 		// Now we perform the fetch itself.
@@ -136,13 +191,19 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 		if opts.NoProgress {
 			fmt.Fprintf(conn, "no-progress\n")
 		}
-		wanted := false
+		if opts.Filter != "" {
+			fmt.Fprintf(conn, "filter %v\n", opts.Filter)
+		}
+		wanted := len(wantrefs) > 0
+		for _, name := range wantrefs {
+			fmt.Fprintf(conn, "want-ref %v\n", name)
+		}
 		for object, _ := range objects {
 			have, _, err := c.HaveObject(object)
 			if err != nil {
 				return nil, err
 			}
-			if !have {
+			if !have || deepening {
 				fmt.Fprintf(conn, "want %v\n", object)
 				wanted = true
 			}
@@ -150,6 +211,9 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 		if !wanted {
 			return nil, fmt.Errorf("Already up to date.")
 		}
+		if err := writeShallowRequest(c, conn, opts, 2); err != nil {
+			return nil, err
+		}
 		for ref := range haves {
 			fmt.Fprintf(conn, "have %v\n", ref)
 		}
@@ -157,16 +221,19 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 		if err := conn.Flush(); err != nil {
 			return nil, err
 		}
-		buf := make([]byte, 65536)
-		n, err := conn.Read(buf)
+		resp, err := readFetchResponseV2(conn)
 		if err != nil {
 			return nil, err
 		}
-		if string(buf[:n]) != "packfile\n" {
-			// Panic because this is a bug in dgit. There are other
-			// valid values that a server can return, but we don't
-			// support them, so make sure people know it's a bug.
-			panic(fmt.Sprintf("Unexpected line returned: got %s want packfile", buf[:n]))
+		newShallow, newUnshallow = resp.shallow, resp.unshallow
+		// The wanted refs are what the server actually sent, which
+		// may have moved since ls-refs.
+		for _, wanted := range resp.wantedRefs {
+			for i := range refs {
+				if refs[i].Name == wanted.Name {
+					refs[i].Value = wanted.Value
+				}
+			}
 		}
 
 		// V2 always uses side-band-64k
@@ -203,7 +270,7 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 			if err != nil {
 				return nil, err
 			}
-			if found {
+			if found && !deepening {
 				haves[object] = struct{}{}
 				continue
 			}
@@ -233,6 +300,36 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 					caps += " side-band"
 					sideband = true
 				}
+				if opts.Filter != "" {
+					if _, ok := capabilities["filter"]; !ok {
+						return nil, fmt.Errorf("Server does not support filtering objects")
+					}
+					caps += " filter"
+				}
+				if shallow || deepening {
+					if _, ok := capabilities["shallow"]; !ok {
+						return nil, fmt.Errorf("Server does not support shallow clients")
+					}
+					caps += " shallow"
+				}
+				if !opts.ShallowSince.IsZero() {
+					if _, ok := capabilities["deepen-since"]; !ok {
+						return nil, fmt.Errorf("Server does not support --shallow-since")
+					}
+					caps += " deepen-since"
+				}
+				if len(opts.ShallowExclude) > 0 {
+					if _, ok := capabilities["deepen-not"]; !ok {
+						return nil, fmt.Errorf("Server does not support --shallow-exclude")
+					}
+					caps += " deepen-not"
+				}
+				if opts.DeepenRelative {
+					if _, ok := capabilities["deepen-relative"]; !ok {
+						return nil, fmt.Errorf("Server does not support --deepen")
+					}
+					caps += " deepen-relative"
+				}
 				if _, ok := capabilities["agent"]; ok {
 					caps += " agent=dgit/0.0.2"
 				}
@@ -250,6 +347,12 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 			// Nothing wanted, already up to date.
 			return refs, nil
 		}
+		if err := writeShallowRequest(c, conn, opts, 1); err != nil {
+			return nil, err
+		}
+		if opts.Filter != "" {
+			fmt.Fprintf(conn, "filter %v\n", opts.Filter)
+		}
 		if h, ok := conn.(*smartHTTPConn); ok {
 			// Hack so that the flush doesn't send a request.
 			h.almostdone = true
@@ -266,6 +369,15 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 			return nil, err
 		}
 
+		if deepening {
+			// The server tells us the new shallow boundary
+			// before the ack/nack.
+			newShallow, newUnshallow, err = readShallowUpdate(conn)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		// Read the last ack/nack and discard it before
 		// reading the pack file.
 		buf := make([]byte, 65536)
@@ -291,7 +403,7 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 	// Whether we've used V1 or V2, the connection is now returning the
 	// packfile upon read, so we want to index it and copy it into the
 	// .git directory.
-	_, err := IndexAndCopyPack(
+	pack, err := IndexAndCopyPack(
 		c,
 		IndexPackOptions{
 			Verbose: opts.Verbose,
@@ -299,7 +411,115 @@ func fetchPackDone(c *Client, opts FetchPackOptions, conn RemoteConn, wants []Re
 		},
 		conn,
 	)
-	return refs, err
+	if err != nil {
+		return refs, err
+	}
+	if err := c.updateShallow(newShallow, newUnshallow); err != nil {
+		return refs, err
+	}
+	if opts.Filter != "" {
+		// Mark the pack as coming from a promisor remote, so that the
+		// objects it refers to but doesn't contain aren't mistaken for
+		// corruption.
+		if err := ioutil.WriteFile(fmt.Sprintf("%v/pack/pack-%v.promisor", c.objectWriteDir(), pack), nil, 0644); err != nil {
+			return refs, err
+		}
+	}
+	return refs, nil
+}
+
+// The sections of a protocol version 2 fetch response which come before
+// the packfile.
+type fetchResponseV2 struct {
+	// The haves which the server acknowledged having.
+	acks []Sha1
+
+	// The boundaries of a shallow fetch.
+	shallow, unshallow []Sha1
+
+	// The object IDs of the refs requested with want-ref.
+	wantedRefs []Ref
+}
+
+// Reads the sections of a protocol version 2 fetch response from r, which
+// must be in PktLineMode, up to and including the "packfile" section
+// header. After it returns, the packfile can be read from r in sideband
+// mode. See gitprotocol-v2(5) for the format.
+func readFetchResponseV2(r io.Reader) (fetchResponseV2, error) {
+	var resp fetchResponseV2
+	buf := make([]byte, 65536)
+	// Reads a line, returning delimPkt or flushPkt at the end of a
+	// section.
+	readLine := func() (string, error) {
+		n, err := r.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(string(buf[:n]), "\n"), nil
+	}
+	for {
+		section, err := readLine()
+		if err == flushPkt {
+			return resp, fmt.Errorf("Server did not send a packfile")
+		} else if err != nil {
+			return resp, err
+		}
+		if section == "packfile" {
+			return resp, nil
+		}
+		for {
+			line, err := readLine()
+			if err == delimPkt {
+				break
+			} else if err == flushPkt {
+				if section == "acknowledgments" {
+					// The server wants to continue
+					// negotiating, but we always send done.
+					return resp, fmt.Errorf("Server did not send a packfile after done")
+				}
+				return resp, fmt.Errorf("Unexpected end of %v section", section)
+			} else if err != nil {
+				return resp, err
+			}
+			fields := strings.Fields(line)
+			switch section {
+			case "acknowledgments":
+				if len(fields) == 2 && fields[0] == "ACK" {
+					sha, err := Sha1FromString(fields[1])
+					if err != nil {
+						return resp, err
+					}
+					resp.acks = append(resp.acks, sha)
+				} else if line != "NAK" && line != "ready" {
+					return resp, fmt.Errorf("Invalid acknowledgment: %v", line)
+				}
+			case "shallow-info":
+				if len(fields) != 2 || (fields[0] != "shallow" && fields[0] != "unshallow") {
+					return resp, fmt.Errorf("Invalid shallow-info line: %v", line)
+				}
+				sha, err := Sha1FromString(fields[1])
+				if err != nil {
+					return resp, err
+				}
+				if fields[0] == "shallow" {
+					resp.shallow = append(resp.shallow, sha)
+				} else {
+					resp.unshallow = append(resp.unshallow, sha)
+				}
+			case "wanted-refs":
+				if len(fields) != 2 {
+					return resp, fmt.Errorf("Invalid wanted-refs line: %v", line)
+				}
+				sha, err := Sha1FromString(fields[0])
+				if err != nil {
+					return resp, err
+				}
+				resp.wantedRefs = append(resp.wantedRefs, Ref{Name: fields[1], Value: sha})
+			default:
+				return resp, fmt.Errorf("Unknown fetch response section %v", section)
+			}
+		}
+	}
 }
 
 var flushPkt = errors.New("Git protocol flush packet")
@@ -0,0 +1,107 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+// Encodes lines as a pkt-line stream. An empty line is a flush packet and
+// "0001" is a delimiter packet.
+func testPktLines(t *testing.T, lines ...string) string {
+	t.Helper()
+	var s string
+	for _, line := range lines {
+		switch line {
+		case "":
+			s += "0000"
+		case "0001":
+			s += "0001"
+		default:
+			enc, err := PktLineEncode([]byte(line))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s += string(enc)
+		}
+	}
+	return s
+}
+
+func TestParseCapabilitiesV2(t *testing.T) {
+	adv := "001e# service=git-upload-pack\n0000" + testPktLines(t,
+		"version 2",
+		"agent=git/2.39.0",
+		"ls-refs=unborn",
+		"fetch=shallow wait-for-done ref-in-want",
+		"server-option",
+		"object-format=sha1",
+		"",
+	)
+	version, caps, refs, err := parseRemoteInitialConnection(strings.NewReader(adv), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("Unexpected protocol version: got %v want 2", version)
+	}
+	if len(refs) != 0 {
+		t.Errorf("Unexpected refs in version 2 advertisement: %v", refs)
+	}
+	for _, name := range []string{"agent", "ls-refs", "fetch", "server-option", "object-format"} {
+		if _, ok := caps[name]; !ok {
+			t.Errorf("Capability %v missing from %v", name, caps)
+		}
+	}
+	for _, arg := range []string{"shallow", "wait-for-done", "ref-in-want"} {
+		if _, ok := caps["fetch"][arg]; !ok {
+			t.Errorf("Fetch argument %v missing from %v", arg, caps["fetch"])
+		}
+	}
+
+	cmd, err := buildLsRefsCmdV2(LsRemoteOptions{RefsOnly: true}, []string{"refs/heads/*", "master"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cmd, "ref-prefix refs/heads\n") || strings.Contains(cmd, "ref-prefix refs/refs/heads") {
+		t.Errorf("Unexpected ref-prefix for qualified pattern: %q", cmd)
+	}
+	if !strings.Contains(cmd, "ref-prefix refs/heads/master\n") {
+		t.Errorf("Missing ref-prefix for unqualified pattern: %q", cmd)
+	}
+}
+
+func TestReadFetchResponseV2(t *testing.T) {
+	resp := testPktLines(t,
+		"acknowledgments",
+		"ACK 8a3f2cc2f7a5c7e5b5e2a3d4e6b3f3d1de40f8a1",
+		"ready",
+		"0001",
+		"shallow-info",
+		"shallow 334a173aead888e9fb0d96eee3aa85c57cb2d8d7",
+		"0001",
+		"wanted-refs",
+		"3c094acaa20f8473a834cde76d044792e17c65d2 refs/heads/master",
+		"0001",
+		"packfile",
+	) + "PACK"
+	r := &packProtocolReader{conn: strings.NewReader(resp), state: PktLineMode}
+	got, err := readFetchResponseV2(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.acks) != 1 || got.acks[0].String() != "8a3f2cc2f7a5c7e5b5e2a3d4e6b3f3d1de40f8a1" {
+		t.Errorf("Unexpected acknowledgments: %v", got.acks)
+	}
+	if len(got.shallow) != 1 || got.shallow[0].String() != "334a173aead888e9fb0d96eee3aa85c57cb2d8d7" || len(got.unshallow) != 0 {
+		t.Errorf("Unexpected shallow-info: %v %v", got.shallow, got.unshallow)
+	}
+	if len(got.wantedRefs) != 1 || got.wantedRefs[0].Name != "refs/heads/master" || got.wantedRefs[0].Value.String() != "3c094acaa20f8473a834cde76d044792e17c65d2" {
+		t.Errorf("Unexpected wanted-refs: %v", got.wantedRefs)
+	}
+
+	// A response without a packfile is an error, not a panic.
+	r = &packProtocolReader{conn: strings.NewReader(testPktLines(t, "acknowledgments", "NAK", "")), state: PktLineMode}
+	if _, err := readFetchResponseV2(r); err == nil {
+		t.Error("Expected an error for a response without a packfile")
+	}
+}
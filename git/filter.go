@@ -0,0 +1,59 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Returns the smudge command of the filter driver from the filter
+// attribute of path, and whether the filter is required to succeed. If
+// path doesn't have a filter with a smudge command, the command is
+// empty.
+//
+// Only filter.<driver>.smudge is supported. The long-running
+// filter.<driver>.process protocol isn't, so drivers which only have a
+// process command are treated as if they don't have a smudge command.
+func smudgeFilter(c *Client, path IndexPath) (string, bool, error) {
+	attrs, err := attributesFor(c, path)
+	if err != nil {
+		return "", false, err
+	}
+	driver := attrs["filter"]
+	if driver == "" || driver == attributeSet || driver == attributeUnset {
+		return "", false, nil
+	}
+	required := c.GetConfig("filter."+driver+".required") == "true"
+	smudge := c.GetConfig("filter." + driver + ".smudge")
+	if smudge == "" && required {
+		return "", false, fmt.Errorf("%v: smudge filter %v is required but not configured", path, driver)
+	}
+	return smudge, required, nil
+}
+
+// Quotes s so that it's a single word when interpreted by sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Runs the smudge command with the content from r as its input, writing
+// its output to the file dst. As in git, "%f" in the command is replaced
+// by the (quoted) path of the file being smudged.
+func runSmudgeFilter(c *Client, smudge string, path IndexPath, r io.Reader, dst File, perm os.FileMode) error {
+	cmd := exec.Command("sh", "-c", strings.Replace(smudge, "%f", shellQuote(path.String()), -1))
+	cmd.Dir = c.WorkDir.String()
+	cmd.Stdin = r
+	cmd.Stderr = os.Stderr
+	f, err := os.OpenFile(dst.String(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = f
+	if err := cmd.Run(); err != nil {
+		f.Close()
+		return fmt.Errorf("%v: smudge filter %q failed: %v", path, smudge, err)
+	}
+	return f.Close()
+}
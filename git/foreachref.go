@@ -1,9 +1,14 @@
 package git
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Calls callback for each ref under c's GitDir which has prefix as a prefix.
@@ -30,3 +35,592 @@ func ForEachRefCallback(c *Client, prefix string, callback func(*Client, Ref) er
 	)
 	return err
 }
+
+// ForEachRefOptions are the options for ForEachRef, corresponding to the
+// options of "git for-each-ref".
+type ForEachRefOptions struct {
+	// The format of each ref, with %(fieldname) replaced by the field of
+	// the ref. If empty, the default of
+	// "%(objectname) %(objecttype)\t%(refname)" is used.
+	Format string
+
+	// The fields to sort by, with a "-" prefix to sort in descending
+	// order. As in git, the last key is the primary key. Refs are
+	// sorted by refname by default.
+	Sort []string
+
+	// The maximum number of refs to return, or 0 for all of them.
+	Count int
+}
+
+// Returns true if the ref named name matches one of the patterns given
+// to for-each-ref. Patterns without wildcards are prefixes which match full
+// path components of the ref.
+func matchesForEachRefPattern(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if strings.ContainsAny(pattern, "*?[") {
+			if matchesGlob("/"+name, false, "/"+pattern) {
+				return true
+			}
+			continue
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+		if name == pattern || strings.HasPrefix(name, pattern+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ForEachRef implements "git for-each-ref". It returns each ref matching
+// patterns formatted with opts.Format.
+//
+// The supported fields are refname, objecttype, objectsize, objectname,
+// tree, parent, numparent, object, type, tag, the author, committer,
+// tagger and creator fields (including their name, email and date
+// variants), subject, body, contents, upstream and HEAD, along with the
+// :short, :lstrip=N, :rstrip=N, :track, :trackshort and date format
+// modifiers. A field prefixed with "*" refers to the object that a tag
+// points to.
+func ForEachRef(c *Client, opts ForEachRefOptions, patterns []string) ([]string, error) {
+	format := opts.Format
+	if format == "" {
+		format = "%(objectname) %(objecttype)\t%(refname)"
+	}
+	allrefs, err := ShowRef(c, ShowRefOptions{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var refs []*formatRef
+	for _, r := range allrefs {
+		if matchesForEachRefPattern(r.Name, patterns) {
+			refs = append(refs, &formatRef{Ref: r, c: c})
+		}
+	}
+
+	sortKeys := opts.Sort
+	if len(sortKeys) == 0 {
+		sortKeys = []string{"refname"}
+	}
+	// Sort by each key in turn, so that the last is the primary key.
+	for _, key := range sortKeys {
+		reverse := strings.HasPrefix(key, "-")
+		key = strings.TrimPrefix(key, "-")
+		vals := make(map[*formatRef]refSortValue)
+		for _, r := range refs {
+			v, err := r.sortValue(key)
+			if err != nil {
+				return nil, err
+			}
+			vals[r] = v
+		}
+		sort.SliceStable(refs, func(i, j int) bool {
+			if reverse {
+				return vals[refs[j]].less(vals[refs[i]])
+			}
+			return vals[refs[i]].less(vals[refs[j]])
+		})
+	}
+	if opts.Count > 0 && len(refs) > opts.Count {
+		refs = refs[:opts.Count]
+	}
+
+	var lines []string
+	for _, r := range refs {
+		line, err := r.format(format)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// ForEachRefOutput is the output of git for-each-ref for the lines which
+// ForEachRef returned. Since the lines are already in the format that was
+// asked for, OutputHuman and OutputPorcelain render the same way.
+type ForEachRefOutput []string
+
+// Renders the lines in the given format.
+func (o ForEachRefOutput) Render(w io.Writer, format OutputFormat) error {
+	for _, line := range o {
+		if _, err := fmt.Fprint(w, line, format.Terminator()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A formatRef is a ref being formatted by ForEachRef. The objects that
+// the ref refers to are only read when a field needs them.
+type formatRef struct {
+	Ref
+	c *Client
+
+	obj, deref *parsedRefObject
+}
+
+// The parts of an object which are used by the fields of ForEachRef.
+type parsedRefObject struct {
+	sha     Sha1
+	typ     string
+	size    int
+	headers map[string][]string
+	message string
+}
+
+func parseRefObject(c *Client, sha Sha1) (*parsedRefObject, error) {
+	obj, err := c.GetObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	p := &parsedRefObject{
+		sha:     sha,
+		typ:     obj.GetType(),
+		size:    obj.GetSize(),
+		headers: make(map[string][]string),
+	}
+	if p.typ != "commit" && p.typ != "tag" {
+		return p, nil
+	}
+	content := string(obj.GetContent())
+	for content != "" {
+		nl := strings.IndexByte(content, '\n')
+		if nl < 0 {
+			nl = len(content)
+		}
+		line := content[:nl]
+		if nl < len(content) {
+			nl++
+		}
+		content = content[nl:]
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, " ") {
+			// A continuation of a multiline header, such as a
+			// signature.
+			continue
+		}
+		if sp := strings.IndexByte(line, ' '); sp > 0 {
+			p.headers[line[:sp]] = append(p.headers[line[:sp]], line[sp+1:])
+		}
+	}
+	p.message = content
+	return p, nil
+}
+
+func (p *parsedRefObject) header(name string) string {
+	if vals := p.headers[name]; len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// Splits the message into its subject, the paragraph at the start of the
+// message joined into a single line, and the rest of its body.
+func (p *parsedRefObject) subjectAndBody() (string, string) {
+	msg := p.message
+	if sig := strings.Index(msg, "-----BEGIN PGP SIGNATURE-----"); sig >= 0 && p.typ == "tag" {
+		msg = msg[:sig]
+	}
+	parts := strings.SplitN(msg, "\n\n", 2)
+	subject := strings.Join(strings.Split(strings.TrimSpace(parts[0]), "\n"), " ")
+	if len(parts) == 1 {
+		return subject, ""
+	}
+	return subject, strings.TrimLeft(parts[1], "\n")
+}
+
+// Splits a person header of the form "Name <email> unixtime tz" into the
+// person and the time.
+func parsePersonHeader(val string) (name, email string, t time.Time, err error) {
+	lt, gt := strings.IndexByte(val, '<'), strings.IndexByte(val, '>')
+	if lt < 0 || gt < lt {
+		return "", "", time.Time{}, fmt.Errorf("Could not parse %q", val)
+	}
+	name, email = strings.TrimSpace(val[:lt]), val[lt:gt+1]
+	fields := strings.Fields(val[gt+1:])
+	if len(fields) != 2 {
+		return name, email, time.Time{}, fmt.Errorf("Could not parse %q", val)
+	}
+	unix, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return name, email, time.Time{}, err
+	}
+	tz, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return name, email, time.Time{}, err
+	}
+	offset := (tz/100*60 + tz%100) * 60
+	return name, email, time.Unix(unix, 0).In(time.FixedZone(fields[1], offset)), nil
+}
+
+// Formats t for a date field with the format modifier, which is any of
+// the formats supported by git's --date option other than relative.
+func formatRefDate(t time.Time, modifier string) (string, error) {
+	switch modifier {
+	case "", "default":
+		return t.Format("Mon Jan 2 15:04:05 2006 -0700"), nil
+	case "iso", "iso8601":
+		return t.Format("2006-01-02 15:04:05 -0700"), nil
+	case "iso-strict", "iso8601-strict":
+		return t.Format(time.RFC3339), nil
+	case "rfc", "rfc2822":
+		return t.Format("Mon, 2 Jan 2006 15:04:05 -0700"), nil
+	case "short":
+		return t.Format("2006-01-02"), nil
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10), nil
+	case "raw":
+		return timeToGitTime(t), nil
+	}
+	return "", fmt.Errorf("unknown date format %v", modifier)
+}
+
+// Returns the shortest unambiguous form of the ref name.
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/", "refs/"} {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+	return name
+}
+
+// Strips n path components from the start of name, or if n is negative,
+// leaves -n components.
+func lstripRefName(name string, n int) string {
+	parts := strings.Split(name, "/")
+	if n < 0 {
+		n = len(parts) + n
+	}
+	if n < 0 {
+		n = 0
+	} else if n > len(parts) {
+		n = len(parts)
+	}
+	return strings.Join(parts[n:], "/")
+}
+
+// Strips n path components from the end of name, or if n is negative,
+// leaves -n components.
+func rstripRefName(name string, n int) string {
+	parts := strings.Split(name, "/")
+	if n < 0 {
+		n = len(parts) + n
+	}
+	if n < 0 {
+		n = 0
+	} else if n > len(parts) {
+		n = len(parts)
+	}
+	return strings.Join(parts[:len(parts)-n], "/")
+}
+
+// Applies the modifier of a field whose value is a ref name.
+func formatRefName(name, modifier string) (string, error) {
+	switch {
+	case modifier == "":
+		return name, nil
+	case modifier == "short":
+		return shortRefName(name), nil
+	case strings.HasPrefix(modifier, "lstrip="), strings.HasPrefix(modifier, "strip="):
+		n, err := strconv.Atoi(modifier[strings.IndexByte(modifier, '=')+1:])
+		if err != nil {
+			return "", fmt.Errorf("Invalid modifier %v", modifier)
+		}
+		return lstripRefName(name, n), nil
+	case strings.HasPrefix(modifier, "rstrip="):
+		n, err := strconv.Atoi(strings.TrimPrefix(modifier, "rstrip="))
+		if err != nil {
+			return "", fmt.Errorf("Invalid modifier %v", modifier)
+		}
+		return rstripRefName(name, n), nil
+	}
+	return "", fmt.Errorf("Invalid modifier %v", modifier)
+}
+
+// Returns the number of commits in the branch which aren't in its upstream,
+// and the number in its upstream which aren't in the branch. If the
+// upstream doesn't exist, gone is true.
+func (r *formatRef) aheadBehind(upstream Branch) (ahead, behind int, gone bool, err error) {
+	if !upstream.Exists(r.c) {
+		return 0, 0, true, nil
+	}
+	ahead, behind, err = AheadBehind(r.c, CommitID(r.Value), upstream)
+	return ahead, behind, false, err
+}
+
+// Returns the value of the field, such as "refname" or "*objectname:short",
+// for r.
+func (r *formatRef) field(field string) (string, error) {
+	name, modifier := field, ""
+	if colon := strings.IndexByte(field, ':'); colon >= 0 {
+		name, modifier = field[:colon], field[colon+1:]
+	}
+
+	switch name {
+	case "refname":
+		return formatRefName(r.Name, modifier)
+	case "HEAD":
+		if head := r.c.GetHeadBranch(); head != "" && string(head) == r.Name {
+			return "*", nil
+		}
+		return " ", nil
+	case "upstream":
+		upstream := Branch(r.Name).Upstream(r.c)
+		switch modifier {
+		case "track", "track,nobracket", "trackshort":
+			if upstream == "" {
+				return "", nil
+			}
+			ahead, behind, gone, err := r.aheadBehind(upstream)
+			if err != nil {
+				return "", err
+			}
+			if modifier == "trackshort" {
+				switch {
+				case gone:
+					return "", nil
+				case ahead > 0 && behind > 0:
+					return "<>", nil
+				case ahead > 0:
+					return ">", nil
+				case behind > 0:
+					return "<", nil
+				}
+				return "=", nil
+			}
+			var track string
+			switch {
+			case gone:
+				track = "gone"
+			case ahead > 0 && behind > 0:
+				track = fmt.Sprintf("ahead %d, behind %d", ahead, behind)
+			case ahead > 0:
+				track = fmt.Sprintf("ahead %d", ahead)
+			case behind > 0:
+				track = fmt.Sprintf("behind %d", behind)
+			default:
+				return "", nil
+			}
+			if modifier == "track" {
+				track = "[" + track + "]"
+			}
+			return track, nil
+		}
+		if upstream == "" {
+			return "", nil
+		}
+		return formatRefName(string(upstream), modifier)
+	}
+
+	// The remaining fields are fields of the object, or of the object
+	// that a tag points to if it's prefixed by "*".
+	obj, err := r.object(strings.HasPrefix(name, "*"))
+	if err != nil {
+		return "", err
+	}
+	if obj == nil {
+		return "", nil
+	}
+	name = strings.TrimPrefix(name, "*")
+
+	switch name {
+	case "objecttype":
+		return obj.typ, nil
+	case "objectsize":
+		return strconv.Itoa(obj.size), nil
+	case "objectname":
+		switch {
+		case modifier == "":
+			return obj.sha.String(), nil
+		case modifier == "short":
+			return obj.sha.String()[:7], nil
+		case strings.HasPrefix(modifier, "short="):
+			n, err := strconv.Atoi(strings.TrimPrefix(modifier, "short="))
+			if err != nil {
+				return "", fmt.Errorf("Invalid modifier %v", modifier)
+			}
+			if n < 4 {
+				n = 4
+			} else if n > 40 {
+				n = 40
+			}
+			return obj.sha.String()[:n], nil
+		}
+		return "", fmt.Errorf("Invalid modifier %v", modifier)
+	case "tree", "object", "type", "tag":
+		return obj.header(name), nil
+	case "parent":
+		return strings.Join(obj.headers["parent"], " "), nil
+	case "numparent":
+		if obj.typ != "commit" {
+			return "", nil
+		}
+		return strconv.Itoa(len(obj.headers["parent"])), nil
+	case "subject", "body", "contents":
+		subject, body := obj.subjectAndBody()
+		switch {
+		case name == "subject", name == "contents" && modifier == "subject":
+			return subject, nil
+		case name == "body", name == "contents" && modifier == "body":
+			return body, nil
+		}
+		return obj.message, nil
+	}
+
+	// Person fields
+	for _, person := range []string{"author", "committer", "tagger", "creator"} {
+		if !strings.HasPrefix(name, person) {
+			continue
+		}
+		header := person
+		if person == "creator" {
+			// The creator is the committer of a commit, or the
+			// tagger of a tag.
+			header = "committer"
+			if obj.typ == "tag" {
+				header = "tagger"
+			}
+		}
+		val := obj.header(header)
+		if val == "" {
+			return "", nil
+		}
+		pname, email, t, err := parsePersonHeader(val)
+		if err != nil {
+			return "", err
+		}
+		switch strings.TrimPrefix(name, person) {
+		case "":
+			return val, nil
+		case "name":
+			return pname, nil
+		case "email":
+			if modifier == "trim" {
+				return strings.Trim(email, "<>"), nil
+			}
+			return email, nil
+		case "date":
+			return formatRefDate(t, modifier)
+		}
+	}
+	return "", fmt.Errorf("unknown field name: %v", field)
+}
+
+// Returns the object that r refers to, or if deref is set, the object that
+// the tag r refers to. If r isn't a tag and deref is set, nil is returned.
+func (r *formatRef) object(deref bool) (*parsedRefObject, error) {
+	if r.obj == nil {
+		obj, err := parseRefObject(r.c, r.Value)
+		if err != nil {
+			return nil, err
+		}
+		r.obj = obj
+	}
+	if !deref {
+		return r.obj, nil
+	}
+	if r.obj.typ != "tag" {
+		return nil, nil
+	}
+	if r.deref == nil {
+		// Peel the tag until it's not a tag.
+		obj := r.obj
+		for obj.typ == "tag" {
+			sha, err := Sha1FromString(obj.header("object"))
+			if err != nil {
+				return nil, err
+			}
+			if obj, err = parseRefObject(r.c, sha); err != nil {
+				return nil, err
+			}
+		}
+		r.deref = obj
+	}
+	return r.deref, nil
+}
+
+// Replaces the %(field) placeholders in format with their values for r.
+// As in git, "%%" is a literal "%" and "%xx" is the byte with the hex
+// value xx.
+func (r *formatRef) format(format string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			out.WriteByte(format[i])
+			continue
+		}
+		switch next := format[i+1]; {
+		case next == '%':
+			out.WriteByte('%')
+			i++
+		case next == '(':
+			end := strings.IndexByte(format[i:], ')')
+			if end < 0 {
+				return "", fmt.Errorf("malformed format string %v", format[i:])
+			}
+			val, err := r.field(format[i+2 : i+end])
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(val)
+			i += end
+		default:
+			if i+2 < len(format) {
+				if b, err := strconv.ParseUint(format[i+1:i+3], 16, 8); err == nil {
+					out.WriteByte(byte(b))
+					i += 2
+					continue
+				}
+			}
+			out.WriteByte('%')
+		}
+	}
+	return out.String(), nil
+}
+
+// The value of a field that refs are sorted by. Dates and sizes are
+// sorted numerically, and everything else is sorted as a string.
+type refSortValue struct {
+	num     int64
+	str     string
+	numeric bool
+}
+
+func (v refSortValue) less(o refSortValue) bool {
+	if v.numeric {
+		return v.num < o.num
+	}
+	return v.str < o.str
+}
+
+func (r *formatRef) sortValue(key string) (refSortValue, error) {
+	field := key
+	if colon := strings.IndexByte(key, ':'); colon >= 0 {
+		field = key[:colon]
+	}
+	switch name := strings.TrimPrefix(field, "*"); {
+	case strings.HasSuffix(name, "date"):
+		unix, err := r.field(field + ":unix")
+		if err != nil {
+			return refSortValue{}, err
+		}
+		n, _ := strconv.ParseInt(unix, 10, 64)
+		return refSortValue{num: n, numeric: true}, nil
+	case name == "objectsize", name == "numparent":
+		val, err := r.field(key)
+		if err != nil {
+			return refSortValue{}, err
+		}
+		n, _ := strconv.ParseInt(val, 10, 64)
+		return refSortValue{num: n, numeric: true}, nil
+	}
+	val, err := r.field(key)
+	return refSortValue{str: val}, err
+}
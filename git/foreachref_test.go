@@ -0,0 +1,84 @@
+package git
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestForEachRef(t *testing.T) {
+	c, cleanup := testRepo(t, "gitforeachref")
+	defer cleanup()
+
+	defer os.Unsetenv("GIT_COMMITTER_DATE")
+	defer os.Unsetenv("GIT_AUTHOR_DATE")
+	os.Setenv("GIT_AUTHOR_NAME", "John Smith")
+	os.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	os.Setenv("GIT_COMMITTER_DATE", "Mon, 02 Jan 2006 15:04:05 -0700")
+	os.Setenv("GIT_AUTHOR_DATE", "Mon, 02 Jan 2006 15:04:05 -0700")
+	initial := testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	if err := c.CreateBranch("zzz", initial); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GIT_COMMITTER_DATE", "Wed, 02 Jan 2008 15:04:05 +0100")
+	second := testCommitFile(t, c, "foo.txt", "bar\n", "Second commit\n\nWith a body.")
+	if err := c.CreateBranch("aaa", second); err != nil {
+		t.Fatal(err)
+	}
+	c.SetCachedConfig("branch.aaa.remote", ".")
+	c.SetCachedConfig("branch.aaa.merge", "refs/heads/zzz")
+
+	tests := []struct {
+		opts     ForEachRefOptions
+		patterns []string
+		want     []string
+	}{
+		{
+			ForEachRefOptions{},
+			nil,
+			[]string{
+				second.String() + " commit\trefs/heads/aaa",
+				second.String() + " commit\trefs/heads/master",
+				initial.String() + " commit\trefs/heads/zzz",
+			},
+		},
+		{
+			ForEachRefOptions{Format: "%(HEAD)%(refname:short) %(objectname:short) %(upstream:short) %(upstream:track)"},
+			[]string{"refs/heads"},
+			[]string{
+				" aaa " + second.String()[:7] + " zzz [ahead 1]",
+				"*master " + second.String()[:7] + "  ",
+				" zzz " + initial.String()[:7] + "  ",
+			},
+		},
+		{
+			ForEachRefOptions{Format: "%(authorname) %(authoremail) %(committerdate:iso) %(subject)|%(body)%%"},
+			[]string{"refs/heads/m*"},
+			[]string{"John Smith <test@example.com> 2008-01-02 15:04:05 +0100 Second commit|With a body.\n%"},
+		},
+		{
+			ForEachRefOptions{Format: "%(refname)", Sort: []string{"committerdate"}},
+			nil,
+			[]string{"refs/heads/zzz", "refs/heads/aaa", "refs/heads/master"},
+		},
+		{
+			ForEachRefOptions{Format: "%(refname)", Sort: []string{"-refname", "-committerdate"}, Count: 2},
+			nil,
+			[]string{"refs/heads/master", "refs/heads/aaa"},
+		},
+	}
+	for i, tc := range tests {
+		got, err := ForEachRef(c, tc.opts, tc.patterns)
+		if err != nil {
+			t.Errorf("Case %d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Case %d: got %q want %q", i, got, tc.want)
+		}
+	}
+
+	if _, err := ForEachRef(c, ForEachRefOptions{Format: "%(bogus)"}, nil); err == nil {
+		t.Error("Expected error for an unknown field")
+	}
+}
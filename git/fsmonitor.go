@@ -0,0 +1,232 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The state of the file system monitor ("FSMN") index extension, which
+// records the entries of the index that haven't changed in the work tree
+// since the monitor's token, so that they don't need to be checked again
+// as long as the monitor doesn't report them.
+type fsmonitorState struct {
+	// The version of the hook interface that token is for. Version 1
+	// tokens are a time in nanoseconds.
+	version uint32
+	token   string
+
+	// The paths of the entries which are known to be unchanged.
+	valid map[IndexPath]bool
+}
+
+// Returns true if the entry for path is known to match the work tree.
+func (s *fsmonitorState) isValid(path IndexPath) bool {
+	return s != nil && s.valid[path]
+}
+
+// Records that the entry for path matches the work tree.
+func (s *fsmonitorState) markValid(path IndexPath) {
+	if s.valid == nil {
+		s.valid = make(map[IndexPath]bool)
+	}
+	s.valid[path] = true
+}
+
+// Marks path as needing to be checked against the work tree again, since
+// its index entry changed.
+func (s *fsmonitorState) invalidate(path IndexPath) {
+	if s != nil {
+		delete(s.valid, path)
+	}
+}
+
+// Returns the fsmonitor hook from the core.fsmonitor config, or the empty
+// string if there isn't one. The builtin fsmonitor daemon (core.fsmonitor
+// set to true) isn't supported, so the work tree is scanned instead.
+func fsmonitorHook(c *Client) string {
+	hook := c.GetConfig("core.fsmonitor")
+	switch hook {
+	case "", "true", "false":
+		return ""
+	}
+	if strings.HasPrefix(hook, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			hook = filepath.Join(home, hook[2:])
+		}
+	}
+	return hook
+}
+
+// Runs the fsmonitor hook with the given interface version and token,
+// returning the paths that it reports as changed and the new token.
+func runFSMonitorHook(c *Client, hook string, version uint32, token string) ([]string, string, error) {
+	cmd := exec.Command(hook, strconv.Itoa(int(version)), token)
+	cmd.Dir = c.WorkDir.String()
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, "", fmt.Errorf("fsmonitor hook %v failed: %v", hook, err)
+	}
+	paths := strings.Split(string(output), "\x00")
+	newToken := ""
+	if version == 2 {
+		// The first entry is the token to use next time.
+		if len(paths) == 1 {
+			return nil, "", fmt.Errorf("fsmonitor hook %v did not return a token", hook)
+		}
+		newToken, paths = paths[0], paths[1:]
+	}
+	var changed []string
+	for _, p := range paths {
+		if p != "" {
+			changed = append(changed, p)
+		}
+	}
+	return changed, newToken, nil
+}
+
+// Queries the fsmonitor for the changes to the work tree since the token in
+// idx, returning the state to use for the index from now on, or nil if
+// there's no fsmonitor configured. idx isn't modified.
+//
+// Only entries which were valid and which the fsmonitor didn't report as
+// changed are valid in the returned state. If the fsmonitor can't be
+// queried, nothing is valid and the entries need to be checked again.
+func queryFSMonitor(c *Client, idx *Index) *fsmonitorState {
+	hook := fsmonitorHook(c)
+	if hook == "" {
+		return nil
+	}
+	start := time.Now()
+	versions := []uint32{2, 1}
+	switch c.GetConfig("core.fsmonitorhookversion") {
+	case "1":
+		versions = []uint32{1}
+	case "2":
+		versions = []uint32{2}
+	}
+
+	old := idx.fsmonitor
+	state := &fsmonitorState{version: versions[0]}
+	var changed []string
+	queried := false
+	if old != nil {
+		for _, version := range versions {
+			if version == 1 && old.version != 1 {
+				// A version 1 hook needs a timestamp, so a
+				// version 2 token can't be used.
+				continue
+			}
+			paths, newToken, err := runFSMonitorHook(c, hook, version, old.token)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			state.version, state.token, changed, queried = version, newToken, paths, true
+			break
+		}
+	}
+	if state.token == "" {
+		state.token = strconv.FormatInt(start.UnixNano(), 10)
+	}
+	if !queried {
+		return state
+	}
+	for _, p := range changed {
+		if p == "/" {
+			// Everything may have changed.
+			return state
+		}
+	}
+
+	// Directories may or may not be reported with a trailing slash,
+	// either way everything under them has changed.
+	reported := make(map[string]bool, len(changed))
+	for _, p := range changed {
+		reported[strings.TrimSuffix(p, "/")] = true
+	}
+	state.valid = make(map[IndexPath]bool, len(old.valid))
+paths:
+	for path := range old.valid {
+		for p := path.String(); ; p = p[:strings.LastIndexByte(p, '/')] {
+			if reported[p] {
+				continue paths
+			}
+			if !strings.Contains(p, "/") {
+				break
+			}
+		}
+		state.valid[path] = true
+	}
+	return state
+}
+
+// Parses the content of an FSMN index extension for the index entries
+// entries.
+func parseFSMonitor(data []byte, entries []*IndexEntry) (*fsmonitorState, error) {
+	r := bytes.NewReader(data)
+	state := &fsmonitorState{}
+	if err := binary.Read(r, binary.BigEndian, &state.version); err != nil {
+		return nil, err
+	}
+	switch state.version {
+	case 1:
+		var timestamp uint64
+		if err := binary.Read(r, binary.BigEndian, &timestamp); err != nil {
+			return nil, err
+		}
+		state.token = strconv.FormatUint(timestamp, 10)
+	case 2:
+		token, err := readUntrackedString(r)
+		if err != nil {
+			return nil, err
+		}
+		state.token = token
+	default:
+		return nil, fmt.Errorf("Unsupported fsmonitor extension version %d", state.version)
+	}
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if int64(size) != int64(r.Len()) {
+		return nil, fmt.Errorf("Invalid fsmonitor bitmap size")
+	}
+	dirty, err := readEWAH(r)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		if i >= len(dirty) || !dirty[i] {
+			state.markValid(entry.PathName)
+		}
+	}
+	return state, nil
+}
+
+// Writes s in the format of the FSMN index extension for the index entries
+// entries.
+func (s *fsmonitorState) writeTo(w *bytes.Buffer, entries []*IndexEntry) {
+	binary.Write(w, binary.BigEndian, s.version)
+	if s.version == 1 {
+		timestamp, _ := strconv.ParseUint(s.token, 10, 64)
+		binary.Write(w, binary.BigEndian, timestamp)
+	} else {
+		w.WriteString(s.token + "\x00")
+	}
+	dirty := make([]bool, len(entries))
+	for i, entry := range entries {
+		dirty[i] = !s.valid[entry.PathName]
+	}
+	var bitmap bytes.Buffer
+	writeEWAH(&bitmap, dirty)
+	binary.Write(w, binary.BigEndian, uint32(bitmap.Len()))
+	w.Write(bitmap.Bytes())
+}
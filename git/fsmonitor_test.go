@@ -0,0 +1,105 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A stand in for an fsmonitor hook which records its arguments and always
+// reports that only b.txt has changed.
+const stubFSMonitor = `#!/bin/sh
+echo "$@" > "$GIT_DIR_FOR_TEST/fsmonitor-args"
+printf 'next-token\000b.txt\000'
+`
+
+// Tests that only the files that the fsmonitor reports as changed are
+// looked at when refreshing the index and diffing it against the work tree.
+func TestFSMonitor(t *testing.T) {
+	c, cleanup := testRepo(t, "gitfsmonitor")
+	defer cleanup()
+
+	hook := filepath.Join(c.GitDir.String(), "stubfsmonitor")
+	if err := ioutil.WriteFile(hook, []byte(stubFSMonitor), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GIT_DIR_FOR_TEST", c.GitDir.String())
+	defer os.Unsetenv("GIT_DIR_FOR_TEST")
+	c.SetCachedConfig("core.fsmonitor", hook)
+
+	testCommitFile(t, c, "a.txt", "a\n", "First commit")
+	testCommitFile(t, c, "b.txt", "b\n", "Second commit")
+	testCommitFile(t, c, "c.txt", "c\n", "Third commit")
+
+	// There's no token yet, so everything is checked the first time.
+	if err := refreshIndex(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(c.GitDir.String(), "fsmonitor-args")); err == nil {
+		t.Error("fsmonitor was queried without a token")
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.fsmonitor == nil {
+		t.Fatal("fsmonitor extension was not written")
+	}
+	token := idx.fsmonitor.token
+	stats := make(map[IndexPath]int64)
+	for _, entry := range idx.Objects {
+		if !idx.fsmonitor.isValid(entry.PathName) {
+			t.Errorf("Unchanged file %v is not valid", entry.PathName)
+		}
+		stats[entry.PathName] = entry.Mtime
+	}
+
+	// Change every file, even though the fsmonitor will only report one
+	// of them.
+	future := time.Now().Add(time.Hour)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := ioutil.WriteFile(name, []byte("changed\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := refreshIndex(c); err != nil {
+		t.Fatal(err)
+	}
+	args, err := ioutil.ReadFile(filepath.Join(c.GitDir.String(), "fsmonitor-args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(args), "2 "+token+"\n"; got != want {
+		t.Errorf("Unexpected fsmonitor arguments: got %q want %q", got, want)
+	}
+	idx, err = c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.fsmonitor == nil || idx.fsmonitor.token != "next-token" {
+		t.Fatalf("Unexpected fsmonitor state after refresh: %+v", idx.fsmonitor)
+	}
+	// The stat information of b.txt isn't refreshed either, since its
+	// content changed.
+	for _, entry := range idx.Objects {
+		if entry.Mtime != stats[entry.PathName] {
+			t.Errorf("Unexpected stat refresh of %v", entry.PathName)
+		}
+	}
+	if idx.fsmonitor.isValid("b.txt") {
+		t.Error("Changed file b.txt is still valid")
+	}
+
+	diffs, err := DiffFiles(c, DiffFilesOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Name != "b.txt" {
+		t.Errorf("Unexpected diff-files result: %v", diffs)
+	}
+}
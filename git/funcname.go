@@ -0,0 +1,125 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The builtin xfuncname patterns of the diff drivers with the same names,
+// which are the same as git's. Each line is a pattern, and a line which
+// starts with "!" stops the search if it matches.
+var builtinFuncnames = map[string]string{
+	"bash": "^[ \t]*((([a-zA-Z_][a-zA-Z0-9_]*)[ \t]*\\([ \t]*\\))|" +
+		"(function[ \t]+([a-zA-Z_][a-zA-Z0-9_]*)(([ \t]*\\([ \t]*\\))|([ \t]+))))" +
+		"[ \t]*(\\{|\\(\\(?|\\[\\[).*$",
+	"cpp": "!^[ \t]*[A-Za-z_][A-Za-z_0-9]*:[[:space:]]*($|/[/*])\n" +
+		"^((::[[:space:]]*)?[A-Za-z_].*)$",
+	"golang": "^[ \t]*(func[ \t]*.*(\\{[ \t]*)?)\n" +
+		"^[ \t]*(type[ \t].*(struct|interface)[ \t]*(\\{[ \t]*)?)",
+	"java": "!^[ \t]*(catch|do|for|if|instanceof|new|return|switch|throw|while)\n" +
+		"^[ \t]*(([A-Za-z_][A-Za-z_0-9]*[ \t]+)+[A-Za-z_][A-Za-z_0-9]*[ \t]*\\([^;]*)$",
+	"python": "^[ \t]*((class|(async[ \t]+)?def)[ \t].*)$",
+}
+
+// One line of an xfuncname pattern.
+type funcnamePattern struct {
+	re     *regexp.Regexp
+	negate bool
+}
+
+// Compiles the lines of the xfuncname pattern of the driver name.
+func compileFuncname(name, pattern string) ([]funcnamePattern, error) {
+	var patterns []funcnamePattern
+	for _, line := range strings.Split(pattern, "\n") {
+		p := funcnamePattern{}
+		if strings.HasPrefix(line, "!") {
+			p.negate, line = true, line[1:]
+		}
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regexp to look for hunk header for diff driver %v: %v", name, err)
+		}
+		p.re = re
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// The longest function name which is added to a hunk header, as in git.
+const maxFuncnameLen = 80
+
+// Returns the function name that line starts, if it matches the xfuncname
+// pattern of the driver. Without a pattern, any line which starts with a
+// letter, "_" or "$" starts a function, the same as git's default.
+func (d diffDriver) funcname(line string) (string, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if d.funcnames == nil {
+		if line == "" {
+			return "", false
+		}
+		if ch := line[0]; !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_' || ch == '$') {
+			return "", false
+		}
+		if len(line) > maxFuncnameLen {
+			line = line[:maxFuncnameLen]
+		}
+		return strings.TrimRight(line, " \t\v\f\r"), true
+	}
+	for _, p := range d.funcnames {
+		m := p.re.FindStringSubmatchIndex(line)
+		if m == nil {
+			continue
+		}
+		if p.negate {
+			return "", false
+		}
+		// The first group is the function name if there is one, and
+		// otherwise the whole match.
+		name := line[m[0]:m[1]]
+		if len(m) > 2 && m[2] >= 0 {
+			name = line[m[2]:m[3]]
+		}
+		name = strings.TrimRight(name, " \t\v\f\r")
+		if len(name) > maxFuncnameLen {
+			name = name[:maxFuncnameLen]
+		}
+		return name, true
+	}
+	return "", false
+}
+
+// Adds the name of the function that each hunk of patch is in, which is the
+// closest line before the hunk in old which starts a function, to the end
+// of the hunk's header.
+func (d diffDriver) addFuncnames(patch string, old []byte) string {
+	if patch == "" || len(old) == 0 {
+		return patch
+	}
+	var oldLines []string
+	lines := strings.SplitAfter(patch, "\n")
+	for i, line := range lines {
+		m := hunkHeaderRE.FindStringSubmatch(strings.TrimRight(line, "\n"))
+		if m == nil || m[5] != "" {
+			continue
+		}
+		// The hunk starts at the 0 indexed line start of old, and the
+		// search starts at the line before it. A hunk with no old lines
+		// has the line before it as its start.
+		start, _ := strconv.Atoi(m[1])
+		if m[2] != "0" {
+			start--
+		}
+		if oldLines == nil {
+			oldLines = splitAfterLines(old)
+		}
+		for n := start - 1; n >= 0 && n < len(oldLines); n-- {
+			if name, ok := d.funcname(oldLines[n]); ok {
+				lines[i] = strings.TrimRight(line, "\n") + " " + name + "\n"
+				break
+			}
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,179 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// The default for gc.auto, the approximate number of loose objects which
+// causes gc --auto to pack them.
+const defaultGCAuto = 6700
+
+type GCOptions struct {
+	// Only do anything if there are more than gc.auto loose objects.
+	Auto bool
+
+	// Don't print anything.
+	Quiet bool
+}
+
+// GC cleans up the repository of c. Currently this means packing the loose
+// objects into a new pack and removing them; unlike the official client it
+// doesn't repack existing packs, prune, or pack refs.
+func GC(c *Client, opts GCOptions) error {
+	if opts.Auto && !needsAutoGC(c) {
+		return nil
+	}
+	// gc.pid guards against more than one gc running at once, as it does
+	// for the official client.
+	lock, err := c.GitDir.Lock("gc.pid")
+	if err != nil {
+		if opts.Auto {
+			// Another gc is already taking care of it.
+			return nil
+		}
+		return err
+	}
+	defer lock.Rollback()
+	fmt.Fprintf(lock, "%d\n", os.Getpid())
+	return repackLooseObjects(c, opts.Quiet)
+}
+
+// Returns whether the repository has more loose objects than gc.auto, in
+// which case gc --auto should pack them. A gc.auto of 0 disables it.
+//
+// As in the official client, the number of loose objects is estimated from
+// the number in the objects/17 directory, since they're evenly
+// distributed.
+func needsAutoGC(c *Client) bool {
+	limit := defaultGCAuto
+	if cfg := c.GetConfig("gc.auto"); cfg != "" {
+		n, err := strconv.Atoi(cfg)
+		if err != nil {
+			log.Printf("Invalid gc.auto %v\n", cfg)
+			return false
+		}
+		limit = n
+	}
+	if limit <= 0 {
+		return false
+	}
+	threshold := (limit + 255) / 256
+	files, err := ioutil.ReadDir(c.GitDir.File("objects/17").String())
+	if err != nil {
+		return false
+	}
+	count := 0
+	for _, fi := range files {
+		if _, err := Sha1FromString("17" + fi.Name()); err == nil {
+			count++
+		}
+	}
+	return count > threshold
+}
+
+// Returns the command which AutoGC runs in the background to collect
+// garbage in c, so that the tests can replace it.
+var backgroundGCCommand = func(c *Client) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(self, "gc", "--auto", "--quiet")
+	cmd.Env = append(os.Environ(), "GIT_DIR="+c.GitDir.String())
+	return cmd, nil
+}
+
+// AutoGC runs gc --auto after a command which may have created many loose
+// objects, such as a commit or merge. It does nothing unless there are more
+// than gc.auto loose objects.
+//
+// Unless gc.autoDetach is false, the gc runs in the background by running
+// the current executable as "dgit gc --auto", so that the command which
+// triggered it can exit. Since that's only right for the dgit command
+// itself, AutoGC is called by the commands, not by the functions such as
+// Commit which they're built on.
+func AutoGC(c *Client) error {
+	if !needsAutoGC(c) {
+		return nil
+	}
+	if c.GetConfig("gc.autoDetach") == "false" {
+		fmt.Fprintln(os.Stderr, "Auto packing the repository for optimum performance.")
+		return GC(c, GCOptions{Auto: true})
+	}
+	cmd, err := backgroundGCCommand(c)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Auto packing the repository in background for optimum performance.")
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// The gc isn't waited for, but the process needs to be released.
+	return cmd.Process.Release()
+}
+
+// Returns the loose objects in the objects directory of c, not including
+// any from alternates.
+func looseObjects(c *Client) ([]Sha1, error) {
+	dirs, err := filepath.Glob(c.GitDir.File("objects/[0-9a-f][0-9a-f]").String())
+	if err != nil {
+		return nil, err
+	}
+	var objects []Sha1
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			if id, err := Sha1FromString(filepath.Base(dir) + fi.Name()); err == nil {
+				objects = append(objects, id)
+			}
+		}
+	}
+	return objects, nil
+}
+
+// Packs the loose objects of c into a new pack and removes them.
+func repackLooseObjects(c *Client, quiet bool) error {
+	objects, err := looseObjects(c)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(SendPackfile(c, w, objects))
+	}()
+	_, err = IndexAndCopyPack(c, IndexPackOptions{Verbose: !quiet}, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	// Now that they're packed, the loose copies can go. Any cached
+	// locations of them are no longer valid.
+	c.objectsMu.Lock()
+	c.objectCache = make(map[Sha1]objectLocation)
+	c.objectsMu.Unlock()
+	for _, id := range objects {
+		if err := os.Remove(c.looseObjectFile(id).String()); err != nil {
+			return err
+		}
+	}
+	// Remove the directories that are now empty, ignoring the ones
+	// that aren't.
+	for _, id := range objects {
+		os.Remove(c.GitDir.File(File(fmt.Sprintf("objects/%02x", id[0]))).String())
+	}
+	return nil
+}
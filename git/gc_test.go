@@ -0,0 +1,100 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+// Tests that AutoGC only packs the loose objects once there are more than
+// gc.auto of them.
+func TestAutoGC(t *testing.T) {
+	c, cleanup := testRepo(t, "gitautogc")
+	defer cleanup()
+	scheduled := 0
+	defer func(orig func(*Client) (*exec.Cmd, error)) { backgroundGCCommand = orig }(backgroundGCCommand)
+	backgroundGCCommand = func(c *Client) (*exec.Cmd, error) {
+		scheduled++
+		return exec.Command("true"), nil
+	}
+
+	// A gc.auto of 256 is crossed once there are 2 objects in
+	// objects/17.
+	c.SetCachedConfig("gc.auto", "256")
+	var objects []Sha1
+	in17 := 0
+	for i := 0; in17 < 2; i++ {
+		id, err := c.WriteObject("blob", []byte(fmt.Sprintf("blob %d\n", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, id)
+		if id[0] == 0x17 {
+			in17++
+		}
+		if in17 == 1 && id[0] == 0x17 {
+			// At the threshold, but not over it.
+			if err := AutoGC(c); err != nil {
+				t.Fatal(err)
+			}
+			if scheduled != 0 {
+				t.Fatal("gc was scheduled below the threshold")
+			}
+		}
+	}
+
+	// Commit doesn't run it itself, since it would run the program
+	// that Commit is part of. The commit adds a blob, a tree and the
+	// commit to the loose objects.
+	testCommitFile(t, c, "foo.txt", "foo\n", "Commit over the threshold")
+	if scheduled != 0 {
+		t.Fatal("gc was scheduled by Commit")
+	}
+	if loose, err := looseObjects(c); err != nil || len(loose) != len(objects)+3 {
+		t.Fatalf("Unexpected loose objects after commit: %v (%v)", len(loose), err)
+	}
+	objects, _ = looseObjects(c)
+
+	// Once it's crossed, the gc runs in the background by default.
+	if err := AutoGC(c); err != nil {
+		t.Fatal(err)
+	}
+	if scheduled != 1 {
+		t.Errorf("gc was not scheduled in the background")
+	}
+	if loose, err := looseObjects(c); err != nil || len(loose) != len(objects) {
+		t.Errorf("Loose objects were packed by a background gc: %v", err)
+	}
+
+	// With gc.autoDetach=false it runs right away.
+	c.SetCachedConfig("gc.autoDetach", "false")
+	if err := AutoGC(c); err != nil {
+		t.Fatal(err)
+	}
+	if scheduled != 1 {
+		t.Errorf("gc was scheduled in the background with gc.autoDetach=false")
+	}
+	if loose, err := looseObjects(c); err != nil || len(loose) != 0 {
+		t.Errorf("Loose objects were not packed: %v (%v)", loose, err)
+	}
+	for _, id := range objects {
+		if _, err := c.GetObject(id); err != nil {
+			t.Fatalf("Could not read %v after gc: %v", id, err)
+		}
+	}
+
+	// It's disabled by a gc.auto of 0.
+	c.SetCachedConfig("gc.auto", "0")
+	c.SetCachedConfig("gc.autoDetach", "true")
+	for i := 0; i < 600; i++ {
+		if _, err := c.WriteObject("blob", []byte(fmt.Sprintf("more %d\n", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := AutoGC(c); err != nil {
+		t.Fatal(err)
+	}
+	if scheduled != 1 {
+		t.Errorf("gc was scheduled with gc.auto=0")
+	}
+}
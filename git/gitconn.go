@@ -24,6 +24,13 @@ func (g *gitConn) OpenConn() error {
 	if err != nil {
 		return err
 	}
+	return g.openConn(conn)
+}
+
+// Starts the session with the git daemon over conn, which has already
+// been connected.
+func (g *gitConn) openConn(conn io.ReadWriteCloser) error {
+	host := g.uri.Hostname()
 	g.conn = conn
 	g.packProtocolReader = &packProtocolReader{g.conn, PktLineMode, nil, nil}
 
@@ -73,11 +80,11 @@ func (g *gitConn) GetRefs(opts LsRemoteOptions, patterns []string) ([]Ref, error
 				return nil, err
 			}
 			refstr := string(line[0:n])
-			ref, err := parseLsRef(refstr)
+			refs, err := parseLsRef(refstr)
 			if err != nil {
 				return nil, err
 			}
-			vals = append(vals, ref)
+			vals = append(vals, refs...)
 		}
 	default:
 		return nil, fmt.Errorf("Protocol version not supported")
@@ -0,0 +1,415 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	pgpSignatureStart  = "-----BEGIN PGP SIGNATURE-----"
+	pgpMessageStart    = "-----BEGIN PGP MESSAGE-----"
+	x509SignatureStart = "-----BEGIN SIGNED MESSAGE-----"
+	sshSignatureStart  = "-----BEGIN SSH SIGNATURE-----"
+)
+
+// A Signature is the result of verifying the signature of a commit or tag.
+type Signature struct {
+	// The status of the signature, using the same codes as the %G? log
+	// format:
+	//	G: a good and valid signature
+	//	B: a bad signature
+	//	U: a good signature with unknown validity
+	//	X: a good signature which has expired
+	//	Y: a good signature made by an expired key
+	//	R: a good signature made by a revoked key
+	//	E: the signature can't be checked, such as for a missing key
+	//	N: no signature
+	Status byte
+
+	// The name of the signer, as reported by gpg, or the principal
+	// from the allowed signers file for SSH signatures.
+	Signer string
+
+	// The key used to create the signature.
+	Key string
+
+	// The fingerprint of the key, if the signature was valid.
+	Fingerprint string
+
+	// The human readable output of gpg or ssh-keygen describing the
+	// signature.
+	Output string
+}
+
+// Good returns true if the signature is good, regardless of whether the
+// key's validity is known.
+func (s Signature) Good() bool {
+	return s.Status == 'G' || s.Status == 'U'
+}
+
+// Returns the format of signatures created by c from the gpg.format config,
+// which is one of "openpgp", "x509" or "ssh".
+func signatureFormat(c *Client) (string, error) {
+	switch format := c.GetConfig("gpg.format"); format {
+	case "":
+		return "openpgp", nil
+	case "openpgp", "x509", "ssh":
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid value for 'gpg.format': '%v'", format)
+	}
+}
+
+// Returns the program to use for signing and verification in format.
+func gpgProgram(c *Client, format string) string {
+	if prog := c.GetConfig("gpg." + format + ".program"); prog != "" {
+		return prog
+	}
+	switch format {
+	case "x509":
+		return "gpgsm"
+	case "ssh":
+		return "ssh-keygen"
+	}
+	// gpg.program is the old name for gpg.openpgp.program
+	if prog := c.GetConfig("gpg.program"); prog != "" {
+		return prog
+	}
+	return "gpg"
+}
+
+// Returns the key to sign with if none was explicitly given, which is the
+// user.signingkey config or the committer's identity.
+func defaultSigningKey(c *Client) (GPGKeyId, error) {
+	if key := c.GetConfig("user.signingkey"); key != "" {
+		return GPGKeyId(key), nil
+	}
+	committer, err := c.GetCommitter(nil)
+	if err != nil && err != NoGlobalConfig {
+		return "", err
+	}
+	return GPGKeyId(committer.String()), nil
+}
+
+// SignBuffer creates a detached, ASCII armoured signature of payload with
+// the key, or the default signing key if key is empty. The signature is
+// created with gpg, gpgsm or ssh-keygen depending on the gpg.format config.
+func SignBuffer(c *Client, key GPGKeyId, payload []byte) ([]byte, error) {
+	format, err := signatureFormat(c)
+	if err != nil {
+		return nil, err
+	}
+	if format == "ssh" {
+		if key == "" {
+			key = GPGKeyId(c.GetConfig("user.signingkey"))
+		}
+		if key == "" {
+			return nil, fmt.Errorf("user.signingkey needs to be set for ssh signing")
+		}
+		return sshSignBuffer(c, key, payload)
+	}
+	if key == "" {
+		k, err := defaultSigningKey(c)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(gpgProgram(c, format), "--status-fd=2", "-bsau", string(key))
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg failed to sign the data: %v\n%s", err, stderr.Bytes())
+	}
+	if !strings.Contains(stderr.String(), "[GNUPG:] SIG_CREATED ") {
+		return nil, fmt.Errorf("gpg failed to sign the data:\n%s", stderr.Bytes())
+	}
+	sig := stdout.Bytes()
+	if len(sig) > 0 && sig[len(sig)-1] != '\n' {
+		sig = append(sig, '\n')
+	}
+	return sig, nil
+}
+
+// Verifies the detached signature sig of payload with the program for the
+// format of the signature.
+func verifySignature(c *Client, payload, sig []byte) (Signature, error) {
+	format := "openpgp"
+	if bytes.HasPrefix(sig, []byte(sshSignatureStart)) {
+		return sshVerifySignature(c, payload, sig)
+	} else if bytes.HasPrefix(sig, []byte(x509SignatureStart)) {
+		format = "x509"
+	}
+
+	sigfile, err := ioutil.TempFile("", ".git_vtag_tmp")
+	if err != nil {
+		return Signature{}, err
+	}
+	defer os.Remove(sigfile.Name())
+	if _, err := sigfile.Write(sig); err != nil {
+		sigfile.Close()
+		return Signature{}, err
+	}
+	if err := sigfile.Close(); err != nil {
+		return Signature{}, err
+	}
+
+	var status, output bytes.Buffer
+	cmd := exec.Command(gpgProgram(c, format), "--keyid-format=long", "--status-fd=1", "--verify", sigfile.Name(), "-")
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &status
+	cmd.Stderr = &output
+	// gpg exits with an error for bad signatures, but the status output
+	// is what determines the result.
+	runerr := cmd.Run()
+
+	result := Signature{Status: 'E', Output: output.String()}
+	scanner := bufio.NewScanner(&status)
+	found := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "[GNUPG:] ") {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimPrefix(line, "[GNUPG:] "), " ", 3)
+		var code byte
+		switch fields[0] {
+		case "GOODSIG":
+			code = 'G'
+		case "BADSIG":
+			code = 'B'
+		case "EXPSIG":
+			code = 'X'
+		case "EXPKEYSIG":
+			code = 'Y'
+		case "REVKEYSIG":
+			code = 'R'
+		case "ERRSIG":
+			code = 'E'
+		case "VALIDSIG":
+			if len(fields) > 1 {
+				result.Fingerprint = fields[1]
+			}
+			continue
+		case "TRUST_UNDEFINED", "TRUST_NEVER":
+			if result.Status == 'G' {
+				result.Status = 'U'
+			}
+			continue
+		default:
+			continue
+		}
+		found = true
+		result.Status = code
+		if len(fields) > 1 {
+			result.Key = fields[1]
+		}
+		if len(fields) > 2 && code != 'E' {
+			result.Signer = fields[2]
+		}
+	}
+	if !found && runerr != nil {
+		return result, fmt.Errorf("Could not run gpg: %v\n%s", runerr, output.Bytes())
+	}
+	return result, nil
+}
+
+// Adds sig to the raw content of a commit object as a gpgsig header, which
+// goes at the end of the headers. Every line of the signature after the first
+// is a continuation line starting with a space.
+func addCommitSignature(content, sig []byte) []byte {
+	end := bytes.Index(content, []byte("\n\n"))
+	if end < 0 {
+		end = len(content)
+	} else {
+		end++
+	}
+	var buf bytes.Buffer
+	buf.Write(content[:end])
+	buf.WriteString("gpgsig ")
+	buf.WriteString(strings.Replace(strings.TrimSuffix(string(sig), "\n"), "\n", "\n ", -1))
+	buf.WriteString("\n")
+	buf.Write(content[end:])
+	return buf.Bytes()
+}
+
+// Splits the raw content of a commit object into the signature from its
+// gpgsig header and the payload that was signed, which is the commit
+// without the header. If the commit isn't signed, sig is nil.
+func splitCommitSignature(content []byte) (payload, sig []byte) {
+	end := bytes.Index(content, []byte("\n\n"))
+	if end < 0 {
+		end = len(content)
+	} else {
+		end++
+	}
+	var p, s bytes.Buffer
+	insig := false
+	for _, line := range strings.SplitAfter(string(content[:end]), "\n") {
+		switch {
+		case strings.HasPrefix(line, "gpgsig "):
+			insig = true
+			s.WriteString(strings.TrimPrefix(line, "gpgsig "))
+		case insig && strings.HasPrefix(line, " "):
+			s.WriteString(line[1:])
+		default:
+			insig = false
+			p.WriteString(line)
+		}
+	}
+	if s.Len() == 0 {
+		return content, nil
+	}
+	p.Write(content[end:])
+	return p.Bytes(), s.Bytes()
+}
+
+// Splits the raw content of a tag object into the payload and the signature
+// which was appended to its message. If the tag isn't signed, sig is nil.
+func splitTagSignature(content []byte) (payload, sig []byte) {
+	for _, start := range []string{pgpSignatureStart, pgpMessageStart, x509SignatureStart, sshSignatureStart} {
+		idx := bytes.LastIndex(content, []byte("\n"+start))
+		if idx < 0 {
+			continue
+		}
+		return content[:idx+1], content[idx+1:]
+	}
+	return content, nil
+}
+
+// Returns true if signing was requested by opts or the config key.
+func wantSign(c *Client, sign, nosign bool, key GPGKeyId, config string) bool {
+	if nosign {
+		return false
+	}
+	if sign || key != "" {
+		return true
+	}
+	return c.GetConfig(config) == "true"
+}
+
+// VerifyCommit verifies the signature of the commit cmt. An error is returned
+// if the commit isn't signed, or the signature isn't good.
+func VerifyCommit(c *Client, cmt CommitID) (Signature, error) {
+	obj, err := c.GetCommitObject(cmt)
+	if err != nil {
+		return Signature{}, err
+	}
+	payload, sig := splitCommitSignature(obj.GetContent())
+	if sig == nil {
+		return Signature{Status: 'N'}, fmt.Errorf("%v: no signature found", cmt)
+	}
+	result, err := verifySignature(c, payload, sig)
+	if err != nil {
+		return result, err
+	}
+	if !result.Good() {
+		return result, fmt.Errorf("%v: bad signature", cmt)
+	}
+	return result, nil
+}
+
+// VerifyTag verifies the signature of the annotated tag object tag. An error
+// is returned if the tag isn't signed, or the signature isn't good.
+func VerifyTag(c *Client, tag Sha1) (Signature, error) {
+	if typ := tag.Type(c); typ != "tag" {
+		return Signature{}, fmt.Errorf("%v: cannot verify a non-tag object of type %v.", tag, typ)
+	}
+	obj, err := c.GetObject(tag)
+	if err != nil {
+		return Signature{}, err
+	}
+	payload, sig := splitTagSignature(obj.GetContent())
+	if sig == nil {
+		return Signature{Status: 'N'}, fmt.Errorf("%v: no signature found", tag)
+	}
+	result, err := verifySignature(c, payload, sig)
+	if err != nil {
+		return result, err
+	}
+	if !result.Good() {
+		return result, fmt.Errorf("%v: bad signature", tag)
+	}
+	return result, nil
+}
+
+// The result of verifying the signature of an object with
+// VerifyObjectSignature.
+type SignatureResult int
+
+const (
+	// The object isn't signed.
+	SignatureMissing SignatureResult = iota
+	// The signature is good and was made by a trusted key.
+	SignatureGood
+	// The signature doesn't match the object, or was made by an expired
+	// or revoked key.
+	SignatureBad
+	// The signature couldn't be checked against a trusted key, because
+	// the key isn't available or the signer isn't trusted.
+	SignatureUnknownKey
+)
+
+func (r SignatureResult) String() string {
+	switch r {
+	case SignatureMissing:
+		return "no-signature"
+	case SignatureGood:
+		return "good"
+	case SignatureBad:
+		return "bad"
+	case SignatureUnknownKey:
+		return "unknown-key"
+	}
+	return fmt.Sprintf("SignatureResult(%d)", int(r))
+}
+
+// A SignatureStatus is the result of verifying the signature of a commit or
+// tag, along with the details of the signature reported by the verifier.
+type SignatureStatus struct {
+	Result SignatureResult
+	Signature
+}
+
+// VerifyObjectSignature verifies the signature of obj, which must be a
+// commit or an annotated tag. Unlike VerifyCommit and VerifyTag, a missing
+// or bad signature isn't an error: an error is only returned if the object
+// can't be read or the verifier can't be run, so the result can be used to
+// decide whether to trust the object.
+func VerifyObjectSignature(c *Client, obj Sha1) (SignatureStatus, error) {
+	o, err := c.GetObject(obj)
+	if err != nil {
+		return SignatureStatus{}, err
+	}
+	var payload, sig []byte
+	switch typ := o.GetType(); typ {
+	case "commit":
+		payload, sig = splitCommitSignature(o.GetContent())
+	case "tag":
+		payload, sig = splitTagSignature(o.GetContent())
+	default:
+		return SignatureStatus{}, fmt.Errorf("%v: cannot verify the signature of a %v", obj, typ)
+	}
+	if sig == nil {
+		return SignatureStatus{SignatureMissing, Signature{Status: 'N'}}, nil
+	}
+	result, err := verifySignature(c, payload, sig)
+	status := SignatureStatus{SignatureUnknownKey, result}
+	if err != nil {
+		return status, err
+	}
+	switch result.Status {
+	case 'G':
+		status.Result = SignatureGood
+	case 'B', 'X', 'Y', 'R':
+		status.Result = SignatureBad
+	}
+	return status, nil
+}
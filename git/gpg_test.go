@@ -0,0 +1,293 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A stand in for gpg which "signs" by printing a fixed signature, and
+// considers any signature to be good.
+const stubGPG = `#!/bin/sh
+for arg in "$@"; do
+	if [ "$arg" = "--verify" ]; then
+		cat > /dev/null
+		echo "[GNUPG:] GOODSIG 0123456789ABCDEF Test User <test@example.com>"
+		echo "[GNUPG:] VALIDSIG FINGERPRINT"
+		echo "[GNUPG:] TRUST_ULTIMATE"
+		echo 'gpg: Good signature from "Test User <test@example.com>"' >&2
+		exit 0
+	fi
+done
+cat > /dev/null
+echo "[GNUPG:] SIG_CREATED D 1 8 00 1234 FINGERPRINT" >&2
+printf -- '-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n=abcd\n-----END PGP SIGNATURE-----\n'
+`
+
+const stubSignature = "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n=abcd\n-----END PGP SIGNATURE-----\n"
+
+func TestSignedCommit(t *testing.T) {
+	c, cleanup := testRepo(t, "gitsignedcommit")
+	defer cleanup()
+
+	gpg := filepath.Join(c.GitDir.String(), "stubgpg")
+	if err := ioutil.WriteFile(gpg, []byte(stubGPG), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c.SetCachedConfig("gpg.program", gpg)
+
+	first := testCommitFile(t, c, "foo.txt", "foo\n", "first")
+	if _, err := VerifyCommit(c, first); err == nil {
+		t.Error("Unsigned commit verified")
+	}
+
+	tree, err := first.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := CommitTree(c, CommitTreeOptions{GPGSign: true}, tree, []CommitID{first}, "signed\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := c.GetCommitObject(cmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := obj.GetContent()
+	wantHeader := "\ngpgsig -----BEGIN PGP SIGNATURE-----\n \n iQEzBAABCAAdFiEE\n =abcd\n -----END PGP SIGNATURE-----\n\nsigned\n"
+	if !strings.HasSuffix(string(content), wantHeader) {
+		t.Errorf("Unexpected signed commit: %q", content)
+	}
+
+	payload, sig := splitCommitSignature(content)
+	if string(sig) != stubSignature {
+		t.Errorf("Unexpected signature: got %q want %q", sig, stubSignature)
+	}
+	if bytes.Contains(payload, []byte("gpgsig")) || !bytes.HasSuffix(payload, []byte("\n\nsigned\n")) {
+		t.Errorf("Unexpected payload: %q", payload)
+	}
+	if resigned := addCommitSignature(payload, sig); !bytes.Equal(resigned, content) {
+		t.Errorf("Signature did not round trip: got %q want %q", resigned, content)
+	}
+
+	result, err := VerifyCommit(c, cmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != 'G' || result.Signer != "Test User <test@example.com>" || result.Key != "0123456789ABCDEF" {
+		t.Errorf("Unexpected verification result: %+v", result)
+	}
+}
+
+func TestSignedTag(t *testing.T) {
+	c, cleanup := testRepo(t, "gitsignedtag")
+	defer cleanup()
+
+	gpg := filepath.Join(c.GitDir.String(), "stubgpg")
+	if err := ioutil.WriteFile(gpg, []byte(stubGPG), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c.SetCachedConfig("gpg.program", gpg)
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "first")
+	if err := TagCommit(c, TagOptions{Sign: true}, "v1", nil, "version 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := RefSpec("refs/tags/v1").Sha1(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := c.GetObject(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(obj.GetContent()), "\n\nversion 1\n"+stubSignature) {
+		t.Errorf("Unexpected signed tag: %q", obj.GetContent())
+	}
+	result, err := VerifyTag(c, tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Good() {
+		t.Errorf("Unexpected verification result: %+v", result)
+	}
+}
+
+// A stand in for ssh-keygen which records how it was called, produces
+// a fixed signature, and trusts every signature.
+const stubSSHKeygen = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/sshkeygen.log"
+case "$2" in
+sign)
+	for last; do :; done
+	cat > /dev/null
+	printf -- '-----BEGIN SSH SIGNATURE-----\nU1NIU0lH\n-----END SSH SIGNATURE-----\n' > "$last.sig"
+	;;
+find-principals)
+	echo "test@example.com"
+	;;
+verify)
+	cat > /dev/null
+	echo 'Good "git" signature for test@example.com with ED25519 key SHA256:abcd'
+	;;
+*)
+	exit 1
+	;;
+esac
+`
+
+func TestSSHSignedCommit(t *testing.T) {
+	c, cleanup := testRepo(t, "gitsshsignedcommit")
+	defer cleanup()
+
+	sshkeygen := filepath.Join(c.GitDir.String(), "stubsshkeygen")
+	if err := ioutil.WriteFile(sshkeygen, []byte(stubSSHKeygen), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// gpg.program should be ignored, since the format is ssh.
+	c.SetCachedConfig("gpg.program", "false")
+	c.SetCachedConfig("gpg.ssh.program", sshkeygen)
+	c.SetCachedConfig("gpg.format", "ssh")
+	c.SetCachedConfig("user.signingkey", "/path/to/id_ed25519")
+	c.SetCachedConfig("gpg.ssh.allowedSignersFile", "/path/to/allowed_signers")
+
+	first := testCommitFile(t, c, "foo.txt", "foo\n", "first")
+	tree, err := first.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := CommitTree(c, CommitTreeOptions{GPGSign: true}, tree, []CommitID{first}, "signed\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := c.GetCommitObject(cmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\ngpgsig -----BEGIN SSH SIGNATURE-----\n U1NIU0lH\n -----END SSH SIGNATURE-----\n\nsigned\n"
+	if !strings.HasSuffix(string(obj.GetContent()), want) {
+		t.Errorf("Unexpected signed commit: %q", obj.GetContent())
+	}
+
+	result, err := VerifyCommit(c, cmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != 'G' || result.Signer != "test@example.com" || result.Fingerprint != "SHA256:abcd" {
+		t.Errorf("Unexpected verification result: %+v", result)
+	}
+
+	log, err := ioutil.ReadFile(filepath.Join(c.GitDir.String(), "sshkeygen.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(log)), "\n")
+	if len(calls) != 3 {
+		t.Fatalf("Unexpected calls to ssh-keygen: %q", calls)
+	}
+	if !strings.HasPrefix(calls[0], "-Y sign -n git -f /path/to/id_ed25519 ") {
+		t.Errorf("Unexpected sign command: %v", calls[0])
+	}
+	if !strings.HasPrefix(calls[2], "-Y verify -n git -f /path/to/allowed_signers -I test@example.com -s ") {
+		t.Errorf("Unexpected verify command: %v", calls[2])
+	}
+}
+
+// A stand in for gpg which prints the status lines in the verify-status
+// file next to it when verifying, and exits with the status in
+// verify-exit.
+const stubGPGVerifier = `#!/bin/sh
+cat > /dev/null
+dir="$(dirname "$0")"
+cat "$dir/verify-status"
+exit "$(cat "$dir/verify-exit")"
+`
+
+func TestVerifyObjectSignature(t *testing.T) {
+	c, cleanup := testRepo(t, "gitverifyobjectsignature")
+	defer cleanup()
+
+	gpg := filepath.Join(c.GitDir.String(), "stubgpg")
+	if err := ioutil.WriteFile(gpg, []byte(stubGPG), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c.SetCachedConfig("gpg.program", gpg)
+	unsigned := testCommitFile(t, c, "foo.txt", "foo\n", "first")
+	tree, err := unsigned.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := CommitTree(c, CommitTreeOptions{GPGSign: true}, tree, []CommitID{unsigned}, "signed\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := TagCommit(c, TagOptions{Sign: true}, "v1", nil, "version 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := RefSpec("refs/tags/v1").Sha1(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := filepath.Join(c.GitDir.String(), "stubverifier")
+	if err := ioutil.WriteFile(verifier, []byte(stubGPGVerifier), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c.SetCachedConfig("gpg.program", verifier)
+
+	if status, err := VerifyObjectSignature(c, Sha1(unsigned)); err != nil || status.Result != SignatureMissing {
+		t.Errorf("Unsigned commit: got %v (%v) want %v", status.Result, err, SignatureMissing)
+	}
+	if _, err := VerifyObjectSignature(c, Sha1(tree)); err == nil {
+		t.Error("Verifying a tree did not return an error")
+	}
+
+	tests := []struct {
+		status string
+		exit   int
+		want   SignatureResult
+		signer string
+	}{
+		{
+			"[GNUPG:] GOODSIG 0123456789ABCDEF Test User <test@example.com>\n[GNUPG:] VALIDSIG FINGERPRINT\n[GNUPG:] TRUST_ULTIMATE\n",
+			0, SignatureGood, "Test User <test@example.com>",
+		},
+		{
+			"[GNUPG:] BADSIG 0123456789ABCDEF Test User <test@example.com>\n",
+			1, SignatureBad, "Test User <test@example.com>",
+		},
+		{
+			"[GNUPG:] EXPKEYSIG 0123456789ABCDEF Test User <test@example.com>\n",
+			0, SignatureBad, "Test User <test@example.com>",
+		},
+		{
+			"[GNUPG:] ERRSIG 0123456789ABCDEF 1 8 00 1600000000 9 -\n[GNUPG:] NO_PUBKEY 0123456789ABCDEF\n",
+			2, SignatureUnknownKey, "",
+		},
+		{
+			"[GNUPG:] GOODSIG 0123456789ABCDEF Test User <test@example.com>\n[GNUPG:] TRUST_UNDEFINED\n",
+			0, SignatureUnknownKey, "Test User <test@example.com>",
+		},
+	}
+	for _, tc := range tests {
+		if err := ioutil.WriteFile(filepath.Join(c.GitDir.String(), "verify-status"), []byte(tc.status), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(c.GitDir.String(), "verify-exit"), []byte(fmt.Sprint(tc.exit)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range []Sha1{Sha1(signed), tag} {
+			status, err := VerifyObjectSignature(c, obj)
+			if err != nil {
+				t.Errorf("%q: %v", tc.status, err)
+				continue
+			}
+			if status.Result != tc.want || status.Signer != tc.signer || status.Key != "0123456789ABCDEF" {
+				t.Errorf("%q: got %v %+v want %v signed by %q", tc.status, status.Result, status.Signature, tc.want, tc.signer)
+			}
+		}
+	}
+}
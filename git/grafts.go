@@ -0,0 +1,73 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Loads the commits whose parents are overridden by .git/shallow or
+// .git/info/grafts into the client's cache.
+//
+// Every commit listed in .git/shallow is treated as having no parents,
+// since the history beyond them was never fetched. Each line in
+// .git/info/grafts is a commit followed by the parents that it should be
+// treated as having instead of the ones recorded in the commit object.
+func (c *Client) loadGrafts() error {
+	c.grafts = make(map[CommitID][]CommitID)
+	if err := c.readGraftFile("info/grafts", c.grafts); err != nil {
+		return err
+	}
+	// Shallow boundaries take priority over grafts, since the parents
+	// of a shallow commit don't exist in the repository.
+	return c.readGraftFile("shallow", c.grafts)
+}
+
+// Reads the graft file name, relative to the GitDir, into grafts.
+func (c *Client) readGraftFile(name File, grafts map[CommitID][]CommitID) error {
+	f, err := c.GitDir.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		cmt, err := CommitIDFromString(fields[0])
+		if err != nil {
+			return fmt.Errorf("Bad graft data in %v: %v", name, line)
+		}
+		parents := make([]CommitID, 0, len(fields)-1)
+		for _, field := range fields[1:] {
+			parent, err := CommitIDFromString(field)
+			if err != nil {
+				return fmt.Errorf("Bad graft data in %v: %v", name, line)
+			}
+			parents = append(parents, parent)
+		}
+		grafts[cmt] = parents
+	}
+	return scanner.Err()
+}
+
+// Returns the parents that cmt should be treated as having because of a
+// graft or shallow boundary, and whether there was one.
+func (c *Client) graftedParents(cmt CommitID) ([]CommitID, bool, error) {
+	c.objectsMu.Lock()
+	defer c.objectsMu.Unlock()
+	if c.grafts == nil {
+		if err := c.loadGrafts(); err != nil {
+			return nil, false, err
+		}
+	}
+	parents, ok := c.grafts[cmt]
+	return parents, ok, nil
+}
@@ -0,0 +1,72 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Tests that history walks stop at the commits listed in .git/shallow,
+// even though the parents of the shallow commits don't exist, and that
+// grafts override the parents of a commit.
+func TestShallowAndGrafts(t *testing.T) {
+	c, cleanup := testRepo(t, "gitshallow")
+	defer cleanup()
+
+	a := testCommitFile(t, c, "foo.txt", "a\n", "a")
+	b := testCommitFile(t, c, "foo.txt", "b\n", "b")
+	cmt := testCommitFile(t, c, "foo.txt", "c\n", "c")
+	head := testCommitFile(t, c, "foo.txt", "d\n", "d")
+
+	revlist := func() []Sha1 {
+		t.Helper()
+		cmts, err := RevList(c, RevListOptions{Quiet: true}, ioutil.Discard, []Commitish{head}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmts
+	}
+
+	if err := c.GitDir.WriteFile("info/grafts", []byte("# comment\n"+cmt.String()+" "+a.String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.grafts = nil
+	if cmts := revlist(); len(cmts) != 3 || cmts[1] != Sha1(cmt) || cmts[2] != Sha1(a) {
+		t.Errorf("Unexpected history with graft: got %v want [%v %v %v]", cmts, head, cmt, a)
+	}
+	if err := os.Remove(c.GitDir.File("info/grafts").String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a shallow clone of depth 2, where the history before
+	// cmt was never fetched.
+	for _, obj := range []CommitID{a, b} {
+		if err := os.Remove(c.GitDir.File(File("objects/" + obj.String()[:2] + "/" + obj.String()[2:])).String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.GitDir.WriteFile("shallow", []byte(cmt.String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.grafts = nil
+	c.objcache.clear()
+
+	cmts := revlist()
+	if len(cmts) != 2 || cmts[0] != Sha1(head) || cmts[1] != Sha1(cmt) {
+		t.Errorf("Unexpected shallow history: got %v want [%v %v]", cmts, head, cmt)
+	}
+	parents, err := cmt.Parents(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) != 0 {
+		t.Errorf("Shallow commit has parents: %v", parents)
+	}
+	base, err := MergeBase(c, MergeBaseOptions{}, []Commitish{head, cmt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base != cmt {
+		t.Errorf("Unexpected merge base: got %v want %v", base, cmt)
+	}
+}
@@ -2,10 +2,12 @@ package git
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"regexp"
+	"strings"
 )
 
 type GrepOptions struct {
@@ -44,6 +46,11 @@ type GrepOptions struct {
 
 	LeadingContext, TrailingContext int
 
+	// The name of the tree being searched, as it was given on the
+	// command line, which prefixes the matches in it. If empty, the
+	// tree is named by its ID.
+	TreeName string
+
 	// If non-nil, Grep will use opts.Stdout to write results
 	// to instead of os.Stdout.
 	Stdout io.Writer
@@ -53,6 +60,9 @@ func Grep(c *Client, opts GrepOptions, pattern string, tree Treeish, pathspec []
 	if opts.Stdout == nil {
 		opts.Stdout = os.Stdout
 	}
+	if tree != nil {
+		return grepTree(c, opts, pattern, tree, pathspec)
+	}
 	lsopts := LsFilesOptions{Cached: true, ExcludeStandard: true}
 	if opts.Untracked {
 		lsopts.Others = true
@@ -76,19 +86,63 @@ func Grep(c *Client, opts GrepOptions, pattern string, tree Treeish, pathspec []
 	return nil
 }
 
+// Greps the blobs in tree which match pathspec, instead of the files in
+// the work tree. The matches are prefixed by the name of the tree and the
+// path, like "HEAD:foo.txt".
+func grepTree(c *Client, opts GrepOptions, pattern string, tree Treeish, pathspec []File) error {
+	name := opts.TreeName
+	if name == "" {
+		name = fmt.Sprint(tree)
+	}
+	var paths []IndexPath
+	for _, f := range pathspec {
+		ip, err := f.IndexPath(c)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, ip)
+	}
+	return WalkTree(c, tree, func(path string, entry TreeEntry) error {
+		if entry.FileMode == ModeTree || entry.FileMode == ModeGitlink {
+			return nil
+		}
+		if len(paths) > 0 {
+			matched := false
+			for _, p := range paths {
+				if IndexPath(path) == p || strings.HasPrefix(path, p.String()+"/") {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil
+			}
+		}
+		obj, err := c.GetObject(entry.Sha1)
+		if err != nil {
+			return err
+		}
+		return grepReader(name+":"+path, bytes.NewReader(obj.GetContent()), pattern, opts)
+	})
+}
+
 func grepFile(filename, pattern string, opts GrepOptions) error {
 	file, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
+	return grepReader(filename, file, pattern, opts)
+}
 
+// Prints the lines of r which match pattern, prefixed by filename.
+func grepReader(filename string, r io.Reader, pattern string, opts GrepOptions) error {
 	re, err := regexp.Compile(pattern)
 	if err != nil {
 		return err
 	}
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 
 	for lineNo := 1; scanner.Scan(); lineNo++ {
 		line := scanner.Bytes()
@@ -1,12 +1,15 @@
 package git
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 // A HashDiff represents a single line in a git diff-index type output.
@@ -14,24 +17,83 @@ type HashDiff struct {
 	Name             IndexPath
 	Src, Dst         TreeEntry
 	SrcSize, DstSize uint
+
+	// If Name was renamed or copied from another path, the path and how
+	// similar (as a percentage) Src at that path is to Dst.
+	SrcName    IndexPath
+	Similarity int
+
+	// SrcName still exists, so Name is a copy of it rather than a
+	// rename.
+	Copy bool
 }
 
 func (h HashDiff) String() string {
-	var status string = "?"
+	if h.SrcName != "" {
+		return fmt.Sprintf("%v\t%v\t%v", h.rawPrefix(), h.SrcName, h.Name)
+	}
+	return fmt.Sprintf("%v\t%v", h.rawPrefix(), h.Name)
+}
+
+// Renders the diff as a line of raw diff output in the given format. With
+// -z, the path is on its own after a NUL instead of after a tab.
+func (h HashDiff) Render(w io.Writer, format OutputFormat) error {
+	sep := "\t"
+	if format == OutputNullTerminated {
+		sep = "\000"
+	}
+	if _, err := fmt.Fprint(w, h.rawPrefix(), sep); err != nil {
+		return err
+	}
+	if h.SrcName != "" {
+		if _, err := fmt.Fprint(w, format.Path(h.SrcName.String()), sep); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprint(w, format.Path(h.Name.String()), format.Terminator())
+	return err
+}
+
+// Returns the modes, objects and status of the raw diff output for h, which
+// come before the path.
+func (h HashDiff) rawPrefix() string {
+	return h.abbrevRawPrefix(len(Sha1{}) * 2)
+}
+
+// Returns the raw diff output for h before the path, with the objects
+// abbreviated to n characters.
+func (h HashDiff) abbrevRawPrefix(n int) string {
+	return fmt.Sprintf(":%0.6o %0.6o %.*v %.*v %v", h.Src.FileMode, h.Dst.FileMode, n, h.Src.Sha1, n, h.Dst.Sha1, h.Status())
+}
+
+// Status returns the letter which git uses for the kind of change in h: A
+// for added, D for deleted and M for modified. A rename or copy is R or C
+// followed by the similarity, such as "R100".
+func (h HashDiff) Status() string {
+	if h.SrcName != "" {
+		status := "R"
+		if h.Copy {
+			status = "C"
+		}
+		return fmt.Sprintf("%v%03d", status, h.Similarity)
+	}
 
 	empty := Sha1{}
 	if h.Src.Sha1 == empty && h.Dst.Sha1 != empty {
-		status = "A"
-	} else if h.Src.Sha1 != empty && h.Dst.Sha1 == empty {
-		if h.Dst.FileMode == 0 {
-			status = "D"
-		} else {
-			status = "M"
-		}
-	} else {
-		status = "M"
+		return "A"
+	} else if h.Src.Sha1 != empty && h.Dst.Sha1 == empty && h.Dst.FileMode == 0 {
+		return "D"
 	}
-	return fmt.Sprintf(":%0.6o %0.6o %v %v %v	%v", h.Src.FileMode, h.Dst.FileMode, h.Src.Sha1, h.Dst.Sha1, status, h.Name)
+	return "M"
+}
+
+// NameStatus returns h as a line of the --name-status output, which is its
+// status and then its paths separated by tabs.
+func (h HashDiff) NameStatus() string {
+	if h.SrcName != "" {
+		return fmt.Sprintf("%v\t%v\t%v", h.Status(), h.SrcName, h.Name)
+	}
+	return fmt.Sprintf("%v\t%v", h.Status(), h.Name)
 }
 
 // Returns a diff in the format of the command "diff". Note: this invokes
@@ -45,12 +107,16 @@ func (h HashDiff) ExternalDiff(c *Client, s1, s2 TreeEntry, f File, opts DiffCom
 	defer os.Remove(tmpfile1.Name())
 
 	var emptySha Sha1
-	if s1.Sha1 != emptySha {
+	side1 := diffSide{id: s1.Sha1}
+	if s1.FileMode == ModeGitlink {
+		fmt.Fprintf(tmpfile1, "Subproject commit %v\n", s1.Sha1)
+	} else if s1.Sha1 != emptySha {
 		obj, err := c.GetObject(s1.Sha1)
 		if err != nil {
 			return "", err
 		}
-		tmpfile1.Write(obj.GetContent())
+		side1.content, side1.exists = obj.GetContent(), true
+		tmpfile1.Write(side1.content)
 	}
 
 	tmpfile1.Close()
@@ -62,14 +128,28 @@ func (h HashDiff) ExternalDiff(c *Client, s1, s2 TreeEntry, f File, opts DiffCom
 	defer os.Remove(tmpfile2.Name())
 
 	var file2 = tmpfile2.Name()
-	if s2.Sha1 != emptySha {
+	side2 := diffSide{id: s2.Sha1}
+	if s2.FileMode == ModeGitlink {
+		sha := s2.Sha1
+		if sha == emptySha {
+			// The commit checked out in the work tree.
+			head, err := gitlinkHead(c, h.Name)
+			if err != nil {
+				return "", err
+			}
+			sha = Sha1(head)
+		}
+		fmt.Fprintf(tmpfile2, "Subproject commit %v\n", sha)
+	} else if s2.Sha1 != emptySha {
 		obj, err := c.GetObject(s2.Sha1)
 		if err != nil {
 			return "", err
 		}
-		tmpfile2.Write(obj.GetContent())
+		side2.content, side2.exists = obj.GetContent(), true
+		tmpfile2.Write(side2.content)
 	} else if s2.FileMode != 0 {
 		file2 = f.String()
+		side2.exists = true
 	}
 	tmpfile2.Close()
 
@@ -78,6 +158,33 @@ func (h HashDiff) ExternalDiff(c *Client, s1, s2 TreeEntry, f File, opts DiffCom
 		// If it couldn't be converted, fall back on the file name.
 		indexPath = IndexPath(f)
 	}
+	driver, err := diffDriverFor(c, indexPath)
+	if err != nil {
+		return "", err
+	}
+	if indexPath, err = opts.displayName(c, indexPath); err != nil {
+		return "", err
+	}
+	if driver.name != "" || driver.binary {
+		if file2 == f.String() {
+			if side2.content, err = ioutil.ReadFile(file2); err != nil {
+				return "", err
+			}
+		}
+		return driver.diff(c, side1, side2, opts, ("a/" + indexPath).String(), ("b/" + indexPath).String())
+	}
+	if opts.Algorithm != "" && opts.Algorithm != DiffMyers {
+		// The external diff tool only knows Myers' algorithm.
+		a, err := ioutil.ReadFile(tmpfile1.Name())
+		if err != nil {
+			return "", err
+		}
+		b, err := ioutil.ReadFile(file2)
+		if err != nil {
+			return "", err
+		}
+		return driver.addFuncnames(opts.Algorithm.unifiedDiff(a, b, opts.NumContextLines, ("a/"+indexPath).String(), ("b/"+indexPath).String()), a), nil
+	}
 	diffcmd := exec.Command(posixDiff, "-u", "-U", strconv.Itoa(opts.NumContextLines), "-L", ("a/" + indexPath).String(), "-L", ("b/" + indexPath).String(), tmpfile1.Name(), file2)
 	// diff returns an error code if there's any differences, so just throw
 	// away the error.
@@ -86,7 +193,7 @@ func (h HashDiff) ExternalDiff(c *Client, s1, s2 TreeEntry, f File, opts DiffCom
 	// diff returns > 0 if any diffs are found, but we don't want to treat
 	// it as an error.
 	out, _ := diffcmd.Output()
-	return string(out), err
+	return driver.addFuncnames(string(out), side1.content), err
 
 }
 
@@ -105,13 +212,38 @@ func printDiffHeader(w io.Writer, name IndexPath, full bool) {
 	}
 }
 
+// Returns the name to display for the path name in a diff. If opts.Relative
+// is set, this has the relative prefix stripped. Otherwise, if opts.PathPrefix
+// is set it's relative to that directory, and if not it's name.
+func (opts DiffCommonOptions) displayName(c *Client, name IndexPath) (IndexPath, error) {
+	if !opts.Relative {
+		if opts.PathPrefix == "" {
+			return name, nil
+		}
+		return IndexPath(filepath.ToSlash(name.RelativeTo(opts.PathPrefix).String())), nil
+	}
+	prefix, err := opts.relativePrefix(c)
+	if err != nil {
+		return "", err
+	}
+	return IndexPath(strings.TrimPrefix(name.String(), prefix.String())), nil
+}
+
 func GeneratePatch(c *Client, options DiffCommonOptions, diffs []HashDiff, dst io.Writer) error {
 	if dst == nil {
 		dst = os.Stdout
 	}
 	for _, diff := range diffs {
+		name, err := options.displayName(c, diff.Name)
+		if err != nil {
+			return err
+		}
 		if options.Raw {
-			fmt.Fprintf(dst, "%v\n", diff)
+			display := diff
+			display.Name = name
+			if err := display.Render(dst, options.OutputFormat()); err != nil {
+				return err
+			}
 		}
 		if options.Patch {
 			f, err := diff.Name.FilePath(c)
@@ -122,8 +254,18 @@ func GeneratePatch(c *Client, options DiffCommonOptions, diffs []HashDiff, dst i
 			patch, err := diff.ExternalDiff(c, diff.Src, diff.Dst, f, options)
 			if err != nil {
 				return err
+			}
+			if options.Color {
+				var buf bytes.Buffer
+				printDiffHeader(&buf, name, false)
+				fmt.Fprintf(&buf, "%v\n", patch)
+				colored, err := colorPatch(c, buf.String())
+				if err != nil {
+					return err
+				}
+				fmt.Fprint(dst, colored)
 			} else {
-				printDiffHeader(dst, diff.Name, false)
+				printDiffHeader(dst, name, false)
 				fmt.Fprintf(dst, "%v\n", patch)
 			}
 		}
@@ -0,0 +1,65 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Returns the directory which hooks are run from, either core.hooksPath or
+// the hooks directory of the repository.
+func hooksDir(c *Client) string {
+	dir := c.GetConfig("core.hooksPath")
+	if dir == "" {
+		return c.GitDir.File("hooks").String()
+	}
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	// Relative paths are relative to where the hooks are run, the top
+	// of the work tree.
+	return filepath.Join(c.WorkDir.String(), dir)
+}
+
+// RunHook runs the hook name, with the arguments args and stdin as its
+// standard input. Hooks which don't exist or aren't executable are skipped.
+// It returns whether the hook was run.
+//
+// If the hook exits with a non-zero status, an error is returned and the
+// operation that the hook is for should be aborted.
+func RunHook(c *Client, name string, stdin io.Reader, args ...string) (ran bool, err error) {
+	return runHookEnv(c, name, nil, stdin, args...)
+}
+
+// Runs the hook name like RunHook, with the variables in env added to its
+// environment.
+func runHookEnv(c *Client, name string, env []string, stdin io.Reader, args ...string) (ran bool, err error) {
+	path := filepath.Join(hooksDir(c), name)
+	st, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if st.IsDir() || st.Mode()&0111 == 0 {
+		return false, nil
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Dir = c.WorkDir.String()
+	cmd.Stdin = stdin
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	// Like the official client, the hook's output goes to stderr so
+	// that it doesn't mix with the output of the command.
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return true, fmt.Errorf("The %v hook failed: %v", name, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,73 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPreCommitHook(t *testing.T) {
+	c, cleanup := testRepo(t, "gitprecommithook")
+	defer cleanup()
+
+	first := testCommitFile(t, c, "foo.txt", "foo\n", "first")
+
+	if err := os.MkdirAll(c.GitDir.File("hooks").String(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	hook := "#!/bin/sh\necho 'no commits allowed' >&2\nexit 1\n"
+	if err := c.GitDir.WriteFile("hooks/pre-commit", []byte(hook), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile("foo.txt", []byte("bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Commit(c, CommitOptions{}, "second", nil); err == nil {
+		t.Error("Failing pre-commit hook did not block the commit")
+	}
+	if head, err := c.GetHeadCommit(); err != nil {
+		t.Fatal(err)
+	} else if head != first {
+		t.Errorf("HEAD was updated despite failing hook: got %v want %v", head, first)
+	}
+
+	// A hook which isn't executable is skipped.
+	if err := os.Chmod(c.GitDir.File("hooks/pre-commit").String(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ran, err := RunHook(c, "pre-commit", nil); ran || err != nil {
+		t.Errorf("Non-executable hook: got ran %v err %v", ran, err)
+	}
+	if err := os.Chmod(c.GitDir.File("hooks/pre-commit").String(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// --no-verify bypasses it, but commit-msg can still edit the message
+	// when hooks are run.
+	if _, err := Commit(c, CommitOptions{NoVerify: true}, "second", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(c.GitDir.File("hooks/pre-commit").String()); err != nil {
+		t.Fatal(err)
+	}
+	msghook := "#!/bin/sh\necho 'Signed-off-by: Hook' >> \"$1\"\n"
+	if err := c.GitDir.WriteFile("hooks/commit-msg", []byte(msghook), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cid, err := Commit(c, CommitOptions{AllowEmpty: true}, "third", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := cid.GetCommitMessage(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(msg.String()); got != "third\nSigned-off-by: Hook" {
+		t.Errorf("Unexpected message after commit-msg hook: got %q", got)
+	}
+}
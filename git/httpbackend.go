@@ -0,0 +1,144 @@
+package git
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// HTTPBackendOptions are the options for HTTPBackend, corresponding to the
+// environment variables and config of "git http-backend".
+type HTTPBackendOptions struct {
+	// Serve every repository rather than only the ones which contain a
+	// git-daemon-export-ok file.
+	ExportAll bool
+
+	// Allow pushing to the repositories with receive-pack. Only
+	// fetching is allowed by default.
+	ReceivePack bool
+
+	// If set, pushes must be authenticated with HTTP basic auth, and
+	// are only allowed if Authenticate returns true for the username
+	// and password given.
+	Authenticate func(username, password string) bool
+}
+
+// HTTPBackend returns a handler which serves the repositories under root
+// over the smart HTTP protocol, as "git http-backend" does.
+//
+// The refs of a repository are advertised by GET requests for
+// "<repo>/info/refs?service=<service>", and the client then POSTs its
+// requests to "<repo>/<service>", which are handled by UploadPack or
+// ReceivePack. Repositories are looked up in the same way as Serve does,
+// and the dumb HTTP protocol isn't supported.
+func HTTPBackend(root string, opts HTTPBackendOptions) http.Handler {
+	return &httpBackend{root: root, opts: opts}
+}
+
+type httpBackend struct {
+	root string
+	opts HTTPBackendOptions
+}
+
+func (h *httpBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if !strings.HasPrefix(path, "/") {
+		// The handler may be used with http.StripPrefix.
+		path = "/" + path
+	}
+
+	var service string
+	advertise := false
+	switch {
+	case strings.HasSuffix(path, "/info/refs") && (r.Method == "GET" || r.Method == "HEAD"):
+		service = r.URL.Query().Get("service")
+		path = strings.TrimSuffix(path, "/info/refs")
+		advertise = true
+	case strings.HasSuffix(path, "/git-upload-pack") && r.Method == "POST":
+		service = "git-upload-pack"
+		path = strings.TrimSuffix(path, "/git-upload-pack")
+	case strings.HasSuffix(path, "/git-receive-pack") && r.Method == "POST":
+		service = "git-receive-pack"
+		path = strings.TrimSuffix(path, "/git-receive-pack")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	switch service {
+	case "git-upload-pack":
+	case "git-receive-pack":
+		if !h.opts.ReceivePack {
+			http.Error(w, "Service not enabled: receive-pack", http.StatusForbidden)
+			return
+		}
+		if h.opts.Authenticate != nil {
+			user, password, ok := r.BasicAuth()
+			if !ok || !h.opts.Authenticate(user, password) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="Git"`)
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+		}
+	default:
+		// Only the smart protocol is supported.
+		http.Error(w, fmt.Sprintf("Service not supported: %q", service), http.StatusForbidden)
+		return
+	}
+
+	c, err := daemonRepository(path, DaemonOptions{BasePath: h.root, ExportAll: h.opts.ExportAll})
+	if err != nil {
+		log.Printf("Not serving %v: %v\n", path, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	if !advertise && r.Header.Get("Content-Type") != "application/x-"+service+"-request" {
+		http.Error(w, "Unexpected Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if advertise {
+		w.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+	} else {
+		w.Header().Set("Content-Type", "application/x-"+service+"-result")
+	}
+	if r.Method == "HEAD" {
+		return
+	}
+
+	protocol := r.Header.Get("Git-Protocol")
+	v2 := service == "git-upload-pack" && strings.Contains(":"+protocol+":", ":version=2:")
+	if advertise && !v2 {
+		// The advertisement of version 0 is preceded by the
+		// service, which version 2 doesn't need.
+		if err := writePktLine(w, "# service="+service+"\n"); err != nil {
+			return
+		}
+		fmt.Fprintf(w, "0000")
+	}
+	if service == "git-receive-pack" {
+		err = ReceivePack(c, body, w, ReceivePackOptions{AdvertiseRefs: advertise, StatelessRPC: !advertise})
+	} else {
+		err = UploadPack(c, body, w, UploadPackOptions{AdvertiseRefs: advertise, StatelessRPC: !advertise, GitProtocol: protocol})
+	}
+	if err != nil {
+		// The response has already started, so the status can't be
+		// changed.
+		log.Printf("Error serving %v for %v: %v\n", service, path, err)
+	}
+}
@@ -0,0 +1,84 @@
+package git
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that the fetch client can clone through HTTPBackend, and that
+// pushes are only allowed when enabled and authenticated.
+func TestHTTPBackend(t *testing.T) {
+	c, cleanup := testRepo(t, "githttpbackend")
+	defer cleanup()
+	head := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+
+	workdir, err := filepath.Abs(c.WorkDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, name := filepath.Split(workdir)
+	opts := HTTPBackendOptions{ExportAll: true}
+	srv := httptest.NewServer(HTTPBackend(base, opts))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "githttpbackenddst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst := File(filepath.Join(dir, "dst.git"))
+	if err := Clone(CloneOptions{InitOptions: InitOptions{Quiet: true, Bare: true}}, Remote(srv.URL+"/"+name), dst); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := NewClient(dst.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Branch("refs/heads/master").CommitID(dc); err != nil || got != head {
+		t.Errorf("Unexpected master after clone: got %v (%v) want %v", got, err, head)
+	}
+
+	// Clients which don't ask for version 2 are told the service first.
+	resp, err := http.Get(srv.URL + "/" + name + "/info/refs?service=git-upload-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := loadLine(resp.Body); line != "# service=git-upload-pack\n" {
+		t.Errorf("Unexpected first line of advertisement: %q", line)
+	}
+	resp.Body.Close()
+
+	advertise := func(handler http.Handler, user, password string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/"+name+"/info/refs?service=git-receive-pack", nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+	if resp := advertise(HTTPBackend(base, opts), "", ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Unexpected status for disabled receive-pack: got %v", resp.Status)
+	}
+
+	opts.ReceivePack = true
+	opts.Authenticate = func(user, password string) bool {
+		return user == "user" && password == "secret"
+	}
+	handler := HTTPBackend(base, opts)
+	if resp := advertise(handler, "", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unexpected status for unauthenticated push: got %v", resp.Status)
+	}
+	if resp := advertise(handler, "user", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unexpected status for wrong password: got %v", resp.Status)
+	}
+	resp = advertise(handler, "user", "secret")
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "application/x-git-receive-pack-advertisement" {
+		t.Errorf("Unexpected response for authenticated push: got %v (%v)", resp.Status, ct)
+	}
+}
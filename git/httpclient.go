@@ -0,0 +1,64 @@
+package git
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Returns the http.Client to use for connecting to rawurl, configured from
+// the http.proxy, http.sslVerify and http.sslCAInfo config (or the
+// http.<url>.* variables which match rawurl).
+//
+// GIT_SSL_NO_VERIFY and GIT_SSL_CAINFO override the config, and if no proxy
+// is configured the usual environment variables such as HTTPS_PROXY and
+// NO_PROXY are used.
+func newHTTPClient(c *Client, rawurl string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxy := c.GetURLConfig("http", "proxy", rawurl); proxy != "" {
+		if !strings.Contains(proxy, "://") {
+			// As in git, a proxy without a protocol is an
+			// HTTP proxy.
+			proxy = "http://" + proxy
+		}
+		proxyurl, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid http.proxy %v: %v", proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyurl)
+	}
+
+	tlsconfig := &tls.Config{}
+	switch strings.ToLower(c.GetURLConfig("http", "sslVerify", rawurl)) {
+	case "false", "no", "off", "0":
+		tlsconfig.InsecureSkipVerify = true
+	}
+	if os.Getenv("GIT_SSL_NO_VERIFY") != "" {
+		tlsconfig.InsecureSkipVerify = true
+	}
+	cainfo := os.Getenv("GIT_SSL_CAINFO")
+	if cainfo == "" {
+		cainfo = c.GetURLConfig("http", "sslCAInfo", rawurl)
+	}
+	if cainfo != "" {
+		pem, err := ioutil.ReadFile(cainfo)
+		if err != nil {
+			return nil, err
+		}
+		// The bundle replaces the system's certificate authorities,
+		// the same as it does for curl.
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %v", cainfo)
+		}
+		tlsconfig.RootCAs = pool
+	}
+	transport.TLSClientConfig = tlsconfig
+	return &http.Client{Transport: transport}, nil
+}
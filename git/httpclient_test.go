@@ -0,0 +1,103 @@
+package git
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that the certificate authorities from http.sslCAInfo are used to
+// verify the server.
+func TestHTTPClientCAInfo(t *testing.T) {
+	c, cleanup := testRepo(t, "githttpcainfo")
+	defer cleanup()
+	ts := httptest.NewTLSServer(testRefsHandler("", ""))
+	defer ts.Close()
+	for _, env := range []string{"GIT_SSL_CAINFO", "GIT_SSL_NO_VERIFY"} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Unsetenv(env)
+	}
+
+	// The test server's certificate isn't trusted by default.
+	if _, err := LsRemote(c, LsRemoteOptions{}, Remote(ts.URL+"/repo.git"), nil); err == nil {
+		t.Error("Connected to a server with an untrusted certificate")
+	}
+
+	dir, err := ioutil.TempDir("", "githttpca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// It's only trusted for the URL that it's configured for.
+	config, err := os.OpenFile(c.GitDir.File("config").String(), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(config, "[http \"%v\"]\n\tsslCAInfo = %v\n", ts.URL, ca)
+	config.Close()
+	c, err = NewClient(c.GitDir.String(), c.WorkDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newHTTPClient(c, ts.URL+"/repo.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool := client.Transport.(*http.Transport).TLSClientConfig.RootCAs; pool == nil {
+		t.Error("No certificate pool was configured")
+	}
+	if client, err := newHTTPClient(c, "https://example.com/repo.git"); err != nil {
+		t.Error(err)
+	} else if client.Transport.(*http.Transport).TLSClientConfig.RootCAs != nil {
+		t.Error("Certificate pool was configured for the wrong URL")
+	}
+	refs, err := LsRemote(c, LsRemoteOptions{}, Remote(ts.URL+"/repo.git"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Name != "refs/heads/master" {
+		t.Errorf("Unexpected refs: %v", refs)
+	}
+}
+
+// Tests that http.sslVerify is read as a boolean.
+func TestHTTPClientSSLVerify(t *testing.T) {
+	c, cleanup := testRepo(t, "githttpsslverify")
+	defer cleanup()
+	defer os.Setenv("GIT_SSL_NO_VERIFY", os.Getenv("GIT_SSL_NO_VERIFY"))
+	os.Unsetenv("GIT_SSL_NO_VERIFY")
+
+	for _, tc := range []struct {
+		value    string
+		insecure bool
+	}{
+		{"", false},
+		{"true", false},
+		{"1", false},
+		{"false", true},
+		{"False", true},
+		{"no", true},
+		{"OFF", true},
+		{"0", true},
+	} {
+		c.SetCachedConfig("http.sslVerify", tc.value)
+		client, err := newHTTPClient(c, "https://example.com/repo.git")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify; got != tc.insecure {
+			t.Errorf("Unexpected verification for http.sslVerify=%q: got insecure %v want %v", tc.value, got, tc.insecure)
+		}
+	}
+}
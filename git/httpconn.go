@@ -23,6 +23,13 @@ type smartHTTPConn struct {
 	// username/password to use over HTTP basic auth.
 	username, password string
 
+	// The client to get credentials with if the server asks for them.
+	c *Client
+
+	// The HTTP client configured for giturl, created by the first
+	// request.
+	httpclient *http.Client
+
 	// nil we haven't tried to open yet, true if successfully got initial
 	// git-upload-pack response, and false if there was a problem getting
 	// the upload-pack response
@@ -50,18 +57,15 @@ func (s *smartHTTPConn) OpenConn() error {
 	var trueref bool = true
 	var falseref bool = false
 
-	req, err := http.NewRequest("GET", s.giturl+"/info/refs?service=git-upload-pack", nil)
-	if err != nil {
-		// We couldn't even try making a request, so give up early.
-		return err
-	}
-
-	// Set username and password if applicable
-	if s.username != "" || s.password != "" {
-		req.SetBasicAuth(s.username, s.password)
+	newreq := func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", s.giturl+"/info/refs?service=git-upload-pack", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Git-Protocol", "version=2")
+		return req, nil
 	}
-	req.Header.Set("Git-Protocol", "version=2")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := s.do(newreq)
 	if err != nil {
 		// If we couldn't perform the request, there's probably a
 		// network issue so give up.
@@ -73,12 +77,7 @@ func (s *smartHTTPConn) OpenConn() error {
 		// If the content-type was wrong, try again at "url.git"
 		log.Printf("Unexpected Content-Type for %v: got %v\n", s.giturl, ct)
 		s.giturl = s.giturl + ".git"
-		req, err = http.NewRequest("GET", s.giturl+"/info/refs?service=git-upload-pack", nil)
-		if s.username != "" || s.password != "" {
-			req.SetBasicAuth(s.username, s.password)
-		}
-		req.Header.Set("Git-Protocol", "version=2")
-		newresp, err := http.DefaultClient.Do(req)
+		newresp, err := s.do(newreq)
 		if err != nil {
 			s.isopen = &falseref
 			return fmt.Errorf("Could not connect to remote")
@@ -115,6 +114,70 @@ func (s *smartHTTPConn) OpenConn() error {
 	}
 }
 
+// Sends the request built by newreq with s's credentials. If the server
+// responds that authentication is required, the credentials are filled in
+// and the request is sent again. Credentials that the server accepts are
+// passed on to the credential helper to store, and ones that it rejects to
+// erase.
+func (s *smartHTTPConn) do(newreq func() (*http.Request, error)) (*http.Response, error) {
+	if s.httpclient == nil {
+		s.httpclient = http.DefaultClient
+		if s.c != nil {
+			client, err := newHTTPClient(s.c, s.giturl)
+			if err != nil {
+				return nil, err
+			}
+			s.httpclient = client
+		}
+	}
+	req, err := newreq()
+	if err != nil {
+		return nil, err
+	}
+	if s.username != "" || s.password != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	resp, err := s.httpclient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || s.c == nil {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	cred, err := newCredential(s.c, s.giturl)
+	if err != nil {
+		return nil, err
+	}
+	if s.username != "" || s.password != "" {
+		// The credentials that worked before aren't accepted
+		// anymore.
+		cred.username, cred.password = s.username, s.password
+		rejectCredential(s.c, cred)
+		return nil, fmt.Errorf("Authentication failed for '%v'", cred.url())
+	}
+	if cred, err = fillCredential(s.c, cred); err != nil {
+		return nil, err
+	}
+	if req, err = newreq(); err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(cred.username, cred.password)
+	if resp, err = s.httpclient.Do(req); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		rejectCredential(s.c, cred)
+		return nil, fmt.Errorf("Authentication failed for '%v'", cred.url())
+	}
+	s.username, s.password = cred.username, cred.password
+	if resp.StatusCode < 300 {
+		if err := approveCredential(s.c, cred); err != nil {
+			log.Printf("Could not store credentials: %v\n", err)
+		}
+	}
+	return resp, nil
+}
+
 func parseRemoteInitialConnection(r io.Reader, stateless bool) (uint8, map[string]map[string]struct{}, []Ref, error) {
 	line := loadLine(r)
 	switch line {
@@ -134,6 +197,7 @@ func parseRemoteInitialConnection(r io.Reader, stateless bool) (uint8, map[strin
 			// Version 2 lists capabilities one per line. If there's
 			// an equal sign, it's the options supported by that
 			// command.
+			line = strings.TrimSuffix(line, "\n")
 			if eq := strings.Index(line, "="); eq == -1 {
 				cap[line] = make(map[string]struct{})
 			} else {
@@ -193,9 +257,9 @@ func parseRemoteInitialConnection(r io.Reader, stateless bool) (uint8, map[strin
 						if eq := strings.Index(c, "="); eq == -1 {
 							cap[c] = make(map[string]struct{})
 						} else {
-							name := line[:eq]
+							name := c[:eq]
 							args := make(map[string]struct{})
-							for _, opt := range strings.Fields(line[eq+1:]) {
+							for _, opt := range strings.Fields(c[eq+1:]) {
 								args[opt] = struct{}{}
 							}
 							cap[name] = args
@@ -245,22 +309,27 @@ func (s smartHTTPConn) GetRefs(opts LsRemoteOptions, patterns []string) ([]Ref,
 		if err != nil {
 			return nil, err
 		}
-		r, err := http.NewRequest("POST", s.giturl+"/git-upload-pack", strings.NewReader(topost))
-		r.Header.Set("User-Agent", "dgit/0.0.2")
-		r.Header.Set("Git-Protocol", "version=2")
-		r.Header.Set("Content-Type", "application/x-git-upload-pack-request")
-		r.ContentLength = int64(len([]byte(topost)))
-		resp, err := http.DefaultClient.Do(r)
+		resp, err := s.do(func() (*http.Request, error) {
+			r, err := http.NewRequest("POST", s.giturl+"/git-upload-pack", strings.NewReader(topost))
+			if err != nil {
+				return nil, err
+			}
+			r.Header.Set("User-Agent", "dgit/0.0.2")
+			r.Header.Set("Git-Protocol", "version=2")
+			r.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+			r.ContentLength = int64(len([]byte(topost)))
+			return r, nil
+		})
 		if err != nil {
 			return nil, err
 		}
 		defer resp.Body.Close()
 		for line := loadLine(resp.Body); line != ""; line = loadLine(resp.Body) {
-			ref, err := parseLsRef(line)
+			refs, err := parseLsRef(line)
 			if err != nil {
 				return nil, err
 			}
-			vals = append(vals, ref)
+			vals = append(vals, refs...)
 		}
 		return vals, nil
 
@@ -316,18 +385,20 @@ func (s *smartHTTPConn) Delim() error {
 func (s *smartHTTPConn) sendRequest(expectedmime string) error {
 	log.Println("Sending HTTP Request")
 	topost := s.buf.String()
-	r, err := http.NewRequest("POST", s.giturl+"/git-upload-pack", strings.NewReader(topost))
-	r.Header.Set("User-Agent", "dgit/0.0.2")
-	if s.protocolversion == 2 {
-		r.Header.Set("Git-Protocol", "version=2")
-	}
+	resp, err := s.do(func() (*http.Request, error) {
+		r, err := http.NewRequest("POST", s.giturl+"/git-upload-pack", strings.NewReader(topost))
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("User-Agent", "dgit/0.0.2")
+		if s.protocolversion == 2 {
+			r.Header.Set("Git-Protocol", "version=2")
+		}
 
-	r.Header.Set("Content-Type", "application/x-git-upload-pack-request")
-	r.ContentLength = int64(len([]byte(topost)))
-	if s.username != "" || s.password != "" {
-		r.SetBasicAuth(s.username, s.password)
-	}
-	resp, err := http.DefaultClient.Do(r)
+		r.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+		r.ContentLength = int64(len([]byte(topost)))
+		return r, nil
+	})
 	if err != nil {
 		return err
 	}
@@ -360,9 +431,12 @@ func getRefsV1(refs []Ref, opts LsRemoteOptions, patterns []string) ([]Ref, erro
 	all := !(opts.Heads || opts.Tags)
 refs:
 	for _, r := range refs {
+		if opts.RefsOnly && (!strings.HasPrefix(r.Name, "refs/") || strings.HasSuffix(r.Name, "^{}")) {
+			continue refs
+		}
 		if !all {
 			if opts.Heads {
-				if strings.HasPrefix(r.Name, "refs/heads/") || r.Name == "HEAD" {
+				if strings.HasPrefix(r.Name, "refs/heads/") {
 					goto good
 				}
 			}
@@ -380,11 +454,13 @@ refs:
 			vals = append(vals, r)
 			continue refs
 		}
+		// A peeled tag is shown if the tag itself matches.
+		name := Ref{Name: strings.TrimSuffix(r.Name, "^{}")}
 		for _, p := range patterns {
 			// FIXME: Matches is the logic used by show-ref
 			// This isn't the same as the logic for ls-remote
 			// which should honour ie. "Foo*"
-			if r.Matches(p) {
+			if name.Matches(p) {
 				vals = append(vals, r)
 				continue refs
 			}
@@ -444,6 +520,16 @@ func buildLsRefsCmdV2(opts LsRemoteOptions, patterns []string) (string, error) {
 			if p[len(p)-1] == '*' {
 				p = p[:len(p)-2]
 			}
+			if strings.HasPrefix(p, "refs/") {
+				// It's already fully qualified, so the
+				// other prefixes can't match anything.
+				penc, err := PktLineEncode([]byte("ref-prefix " + p))
+				if err != nil {
+					return "", err
+				}
+				cmd += penc
+				continue
+			}
 			for _, prefix := range prefixes {
 				penc, err := PktLineEncode([]byte("ref-prefix " + prefix + p))
 				if err != nil {
@@ -458,13 +544,27 @@ func buildLsRefsCmdV2(opts LsRemoteOptions, patterns []string) (string, error) {
 	return cmd.String() + "0000", nil
 }
 
-// parses a ref returned from the LsRefs command
-func parseLsRef(s string) (Ref, error) {
-	sha1, err := Sha1FromString(s[0:40])
+// parses a ref returned from the LsRefs command. If the ref is an annotated
+// tag and was peeled, the object that it points to is returned as a second
+// Ref named with a "^{}" suffix, the same as in a version 1 advertisement.
+func parseLsRef(s string) ([]Ref, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("Invalid ls-refs line: %v", s)
+	}
+	sha1, err := Sha1FromString(fields[0])
 	if err != nil {
-		return Ref{}, err
+		return nil, err
+	}
+	refs := []Ref{{Name: fields[1], Value: sha1}}
+	for _, attr := range fields[2:] {
+		if strings.HasPrefix(attr, "peeled:") {
+			peeled, err := Sha1FromString(strings.TrimPrefix(attr, "peeled:"))
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, Ref{Name: fields[1] + "^{}", Value: peeled})
+		}
 	}
-	name := string(s[41:])
-	name = strings.TrimSuffix(name, "\n")
-	return Ref{Name: name, Value: sha1}, nil
+	return refs, nil
 }
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
 	"errors"
@@ -8,8 +9,10 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -30,6 +33,19 @@ type fixedGitIndex struct {
 type Index struct {
 	fixedGitIndex // 12
 	Objects       []*IndexEntry
+
+	// The cache-tree extension, if the index has one.
+	cacheTree *cacheTree
+
+	// The untracked cache extension, if the index has one.
+	untracked *untrackedCache
+
+	// The file system monitor extension, if the index has one.
+	fsmonitor *fsmonitorState
+
+	// True if the index has the sparse directory extension, so it may
+	// have sparse directory entries.
+	sparse bool
 }
 
 type V3IndexExtensions struct {
@@ -40,6 +56,33 @@ type IndexEntry struct {
 	FixedIndexEntry
 	*V3IndexExtensions
 	PathName IndexPath
+
+	// The ID of the object if it's named with a hash algorithm other
+	// than SHA-1, in which case it doesn't fit in the Sha1 of the
+	// FixedIndexEntry. Index files with other algorithms can't be read
+	// yet, so this is only set by SetObjectID.
+	objectID ObjectID
+}
+
+// Returns the ID of the object that the entry is for, in the hash
+// algorithm that it's named with.
+func (ie IndexEntry) ObjectID() ObjectID {
+	if ie.objectID.Algorithm != HashSHA1 {
+		return ie.objectID
+	}
+	return ie.Sha1.ObjectID()
+}
+
+// Sets the object that the entry is for to id, which may be named with any
+// hash algorithm.
+func (ie *IndexEntry) SetObjectID(id ObjectID) {
+	if id.Algorithm == HashSHA1 {
+		ie.Sha1, _ = id.Sha1()
+		ie.objectID = ObjectID{}
+		return
+	}
+	ie.Sha1 = Sha1{}
+	ie.objectID = id
 }
 
 func (ie IndexEntry) Stage() Stage {
@@ -176,6 +219,24 @@ func (i *FixedIndexEntry) CompareStat(f File) error {
 	return nil
 }
 
+// Returns true if the entry is racily clean, which means that the file
+// wasn't modified before the index file was written, according to
+// indexMtime, so it may have been modified again within the resolution of
+// the timestamps without its stat information changing. indexMtime is in
+// the same format as the mtime of the entry, and 0 if there's no index
+// file.
+func (ie *IndexEntry) isRacy(indexMtime int64) bool {
+	return indexMtime != 0 && ie.Mtime >= indexMtime
+}
+
+// Returns true if the content of the file needs to be checked even if its
+// stat information matches the entry. That's the case if the entry is
+// racily clean, or if it has a size of 0, which matches an empty file but
+// is also the size of an entry which was found to be racily clean before.
+func (ie *IndexEntry) needsContentCheck(indexMtime int64) bool {
+	return ie.isRacy(indexMtime) || ie.Fsize == 0
+}
+
 // Refreshes the stat information for an index entry by comparing
 // it against the path in the index.
 func (ie *IndexEntry) RefreshStat(c *Client) error {
@@ -201,6 +262,10 @@ func (d GitDir) ReadIndex() (*Index, error) {
 					0, // no entries
 				},
 				make([]*IndexEntry, 0),
+				nil,
+				nil,
+				nil,
+				false,
 			}, nil
 		}
 		return nil, err
@@ -218,35 +283,172 @@ func (d GitDir) ReadIndex() (*Index, error) {
 	log.Println("Index version", i.Version)
 
 	var idx uint32
+	var prev IndexPath
 	indexes := make([]*IndexEntry, i.NumberIndexEntries, i.NumberIndexEntries)
 	for idx = 0; idx < i.NumberIndexEntries; idx += 1 {
-		if index, err := ReadIndexEntry(file, i.Version); err == nil {
+		if i.Version == 4 {
+			// Version 4 path names are compressed against the
+			// previous entry, so an entry can't be skipped.
+			index, err := readIndexEntryV4(file, prev)
+			if err != nil {
+				return nil, err
+			}
+			indexes[idx] = index
+			prev = index.PathName
+		} else if index, err := ReadIndexEntry(file, i.Version); err == nil {
 			indexes[idx] = index
 		}
 	}
-	return &Index{i, indexes}, nil
+	index := &Index{fixedGitIndex: i, Objects: indexes}
+	if err := index.readExtensions(file); err != nil {
+		return nil, err
+	}
+	return index, nil
 }
 
-func ReadIndexEntry(file *os.File, indexVersion uint32) (*IndexEntry, error) {
-	log.Printf("Reading index entry from %v assuming index version %d\n", file.Name(), indexVersion)
-	if indexVersion < 2 || indexVersion > 3 {
-		return nil, fmt.Errorf("Unsupported index version.")
+// Reads the extensions after the entries of the index file, up to the
+// checksum at the end of the file. Extensions which aren't understood are
+// ignored, as are cache-tree, untracked cache and fsmonitor extensions which
+// can't be parsed, since they only need to be recomputed.
+func (g *Index) readExtensions(file *os.File) error {
+	pos, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return err
 	}
+	end := stat.Size() - 20
+	for pos+8 <= end {
+		var header struct {
+			Signature [4]byte
+			Size      uint32
+		}
+		if err := binary.Read(file, binary.BigEndian, &header); err != nil {
+			return err
+		}
+		pos += 8
+		if pos+int64(header.Size) > end {
+			return InvalidIndex
+		}
+		data := make([]byte, header.Size)
+		if _, err := io.ReadFull(file, data); err != nil {
+			return err
+		}
+		pos += int64(header.Size)
+		switch string(header.Signature[:]) {
+		case "TREE":
+			if tree, err := parseCacheTree(data); err == nil {
+				g.cacheTree = tree
+			} else {
+				log.Printf("Ignoring invalid cache-tree: %v\n", err)
+			}
+		case "UNTR":
+			if untracked, err := parseUntrackedCache(data); err == nil {
+				g.untracked = untracked
+			} else {
+				log.Printf("Ignoring invalid untracked cache: %v\n", err)
+			}
+		case "FSMN":
+			if fsmonitor, err := parseFSMonitor(data, g.Objects); err == nil {
+				g.fsmonitor = fsmonitor
+			} else {
+				log.Printf("Ignoring invalid fsmonitor extension: %v\n", err)
+			}
+		case "sdir":
+			g.sparse = true
+		default:
+			log.Printf("Ignoring index extension %s\n", header.Signature[:])
+		}
+	}
+	return nil
+}
+
+// Reads the fixed size portion of an index entry, including the V3
+// extended flags if the entry has them.
+func readIndexEntryFixed(file *os.File, indexVersion uint32) (FixedIndexEntry, *V3IndexExtensions, error) {
 	var f FixedIndexEntry
-	var name []byte
 	if err := binary.Read(file, binary.BigEndian, &f); err != nil {
+		return f, nil, err
+	}
+	if !f.ExtendedFlag() {
+		return f, nil, nil
+	}
+	if indexVersion < 3 {
+		return f, nil, InvalidIndex
+	}
+	v3e := &V3IndexExtensions{}
+	if err := binary.Read(file, binary.BigEndian, v3e); err != nil {
+		return f, nil, err
+	}
+	return f, v3e, nil
+}
+
+// Reads an entry from a version 4 index. The path name of the entry is
+// stored as the number of bytes to remove from the end of the previous
+// entry's path name, followed by the NUL terminated bytes to append to it,
+// and there's no padding after it.
+func readIndexEntryV4(file *os.File, prev IndexPath) (*IndexEntry, error) {
+	f, v3e, err := readIndexEntryFixed(file, 4)
+	if err != nil {
+		return nil, err
+	}
+	strip, err := readIndexV4Strip(file)
+	if err != nil {
 		return nil, err
 	}
+	if strip > uint64(len(prev)) {
+		return nil, InvalidIndex
+	}
+	name := []byte(prev[:len(prev)-int(strip)])
+	nbyte := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(file, nbyte); err != nil {
+			return nil, err
+		}
+		if nbyte[0] == 0 {
+			break
+		}
+		name = append(name, nbyte[0])
+	}
+	return &IndexEntry{FixedIndexEntry: f, V3IndexExtensions: v3e, PathName: IndexPath(name)}, nil
+}
 
-	var v3e *V3IndexExtensions
-	if f.ExtendedFlag() {
-		if indexVersion < 3 {
-			return nil, InvalidIndex
+// Reads the number of bytes to strip from the previous path name in a
+// version 4 index entry. It's encoded in the same variable width format as
+// an OFS_DELTA offset in a pack, which ReadDeltaOffset reads, but a value
+// which doesn't fit in a uint64 is an InvalidIndex.
+func readIndexV4Strip(r io.Reader) (uint64, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+	val := uint64(b[0] & 0x7f)
+	for b[0]&0x80 != 0 {
+		if val+1 > math.MaxUint64>>7 {
+			return 0, InvalidIndex
 		}
-		v3e = &V3IndexExtensions{}
-		if err := binary.Read(file, binary.BigEndian, v3e); err != nil {
-			return nil, err
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, err
 		}
+		val = (val+1)<<7 | uint64(b[0]&0x7f)
+	}
+	return val, nil
+}
+
+func ReadIndexEntry(file *os.File, indexVersion uint32) (*IndexEntry, error) {
+	log.Printf("Reading index entry from %v assuming index version %d\n", file.Name(), indexVersion)
+	if indexVersion == 4 {
+		return nil, fmt.Errorf("Version 4 index entries can not be read without the previous entry.")
+	}
+	if indexVersion < 2 || indexVersion > 3 {
+		return nil, fmt.Errorf("Unsupported index version.")
+	}
+	var name []byte
+	f, v3e, err := readIndexEntryFixed(file, indexVersion)
+	if err != nil {
+		return nil, err
 	}
 
 	var nameLength uint16
@@ -307,7 +509,7 @@ func ReadIndexEntry(file *os.File, indexVersion uint32) (*IndexEntry, error) {
 			return nil, err
 		}
 	}
-	return &IndexEntry{f, v3e, IndexPath(name)}, nil
+	return &IndexEntry{FixedIndexEntry: f, V3IndexExtensions: v3e, PathName: IndexPath(name)}, nil
 }
 
 // A Stage represents a git merge stage in the index.
@@ -322,6 +524,9 @@ const (
 )
 
 func (g *Index) SetSkipWorktree(c *Client, path IndexPath, value bool) error {
+	if err := g.ExpandToPath(c, path); err != nil {
+		return err
+	}
 	for _, entry := range g.Objects {
 		if entry.PathName == path {
 			if entry.Stage() != Stage0 {
@@ -344,6 +549,11 @@ func (g *Index) SetSkipWorktree(c *Client, path IndexPath, value bool) error {
 // As a special case, if something is added as Stage0, then Stage1-3 entries
 // will be removed.
 func (g *Index) AddStage(c *Client, path IndexPath, mode EntryMode, s Sha1, stage Stage, size uint32, mtime int64, opts UpdateIndexOptions) error {
+	if err := g.ExpandToPath(c, path); err != nil {
+		return err
+	}
+	g.invalidateCacheTree(path)
+	g.fsmonitor.invalidate(path)
 	if stage == Stage0 {
 		defer g.RemoveUnmergedStages(c, path)
 	}
@@ -360,11 +570,13 @@ func (g *Index) AddStage(c *Client, path IndexPath, mode EntryMode, s Sha1, stag
 				if !opts.Replace {
 					return fmt.Errorf("There is an existing file %s under %s, should it be replaced?", e.PathName, path)
 				}
+				g.untracked.invalidate(e.PathName)
 				continue
 			} else if strings.HasPrefix(string(path), string(e.PathName)+"/") {
 				if !opts.Replace {
 					return fmt.Errorf("There is a parent file %s above %s, should it be replaced?", e.PathName, path)
 				}
+				g.untracked.invalidate(e.PathName)
 				continue
 			}
 
@@ -432,7 +644,7 @@ func (g *Index) AddStage(c *Client, path IndexPath, mode EntryMode, s Sha1, stag
 		return err
 	}
 	newentry := &IndexEntry{
-		FixedIndexEntry{
+		FixedIndexEntry: FixedIndexEntry{
 			0, //uint32(csec),
 			0, //uint32(cnano),
 			mtime,
@@ -445,11 +657,12 @@ func (g *Index) AddStage(c *Client, path IndexPath, mode EntryMode, s Sha1, stag
 			s,
 			flags,
 		},
-		&V3IndexExtensions{},
-		path,
+		V3IndexExtensions: &V3IndexExtensions{},
+		PathName:          path,
 	}
 	newentry.RefreshStat(c)
 
+	g.untracked.invalidate(path)
 	g.Objects = append(g.Objects, newentry)
 	g.NumberIndexEntries += 1
 	sort.Sort(ByPath(g.Objects))
@@ -458,6 +671,7 @@ func (g *Index) AddStage(c *Client, path IndexPath, mode EntryMode, s Sha1, stag
 
 // Remove any unmerged (non-stage 0) stage from the index for the given path
 func (g *Index) RemoveUnmergedStages(c *Client, path IndexPath) error {
+	g.invalidateCacheTree(path)
 	// There are likely 3 things being deleted, so make a new slice
 	newobjects := make([]*IndexEntry, 0, len(g.Objects))
 	for _, entry := range g.Objects {
@@ -466,6 +680,7 @@ func (g *Index) RemoveUnmergedStages(c *Client, path IndexPath) error {
 			newobjects = append(newobjects, entry)
 		} else if entry.PathName == path && stage != Stage0 {
 			// do not add it, it's the wrong stage.
+			g.untracked.invalidate(path)
 		} else {
 			// It's a different Pathname, keep it.
 			newobjects = append(newobjects, entry)
@@ -587,10 +802,14 @@ func (i *Index) GetUnmerged() map[IndexPath]*UnmergedPath {
 }
 
 // Remove the first instance of file from the index. (This will usually
-// be stage 0.)
+// be stage 0.) In a sparse index, the directories containing file must
+// have been expanded with ExpandToPath first.
 func (g *Index) RemoveFile(file IndexPath) {
+	g.invalidateCacheTree(file)
+	g.fsmonitor.invalidate(file)
 	for i, entry := range g.Objects {
 		if entry.PathName == file {
+			g.untracked.invalidate(file)
 			g.Objects = append(g.Objects[:i], g.Objects[i+1:]...)
 			g.NumberIndexEntries -= 1
 			return
@@ -609,7 +828,11 @@ func (g Index) WriteIndex(file io.Writer) error {
 	g.NumberIndexEntries = uint32(len(g.Objects))
 	s := sha1.New()
 	w := io.MultiWriter(file, s)
+	if g.Version < 2 || g.Version > 4 {
+		return InvalidIndex
+	}
 	binary.Write(w, binary.BigEndian, g.fixedGitIndex)
+	var prev IndexPath
 	for _, entry := range g.Objects {
 		if err := binary.Write(w, binary.BigEndian, entry.FixedIndexEntry); err != nil {
 			return err
@@ -622,6 +845,22 @@ func (g Index) WriteIndex(file io.Writer) error {
 				return err
 			}
 		}
+		if g.Version == 4 {
+			// Compress the path name against the previous one,
+			// without any padding.
+			common := 0
+			for common < len(prev) && common < len(entry.PathName) && prev[common] == entry.PathName[common] {
+				common++
+			}
+			if _, err := w.Write(encodeIndexVarint(uint64(len(prev) - common))); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, string(entry.PathName[common:])+"\x00"); err != nil {
+				return err
+			}
+			prev = entry.PathName
+			continue
+		}
 		if err := binary.Write(w, binary.BigEndian, []byte(entry.PathName)); err != nil {
 			return err
 		}
@@ -635,10 +874,108 @@ func (g Index) WriteIndex(file io.Writer) error {
 			return err
 		}
 	}
+	if g.cacheTree != nil {
+		var tree bytes.Buffer
+		g.cacheTree.writeTo(&tree)
+		if _, err := io.WriteString(w, "TREE"); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(tree.Len())); err != nil {
+			return err
+		}
+		if _, err := w.Write(tree.Bytes()); err != nil {
+			return err
+		}
+	}
+	if g.untracked != nil {
+		var untracked bytes.Buffer
+		g.untracked.writeTo(&untracked)
+		if _, err := io.WriteString(w, "UNTR"); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(untracked.Len())); err != nil {
+			return err
+		}
+		if _, err := w.Write(untracked.Bytes()); err != nil {
+			return err
+		}
+	}
+	if g.fsmonitor != nil {
+		var fsmonitor bytes.Buffer
+		g.fsmonitor.writeTo(&fsmonitor, g.Objects)
+		if _, err := io.WriteString(w, "FSMN"); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(fsmonitor.Len())); err != nil {
+			return err
+		}
+		if _, err := w.Write(fsmonitor.Bytes()); err != nil {
+			return err
+		}
+	}
+	if g.sparse {
+		if _, err := io.WriteString(w, "sdir"); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(0)); err != nil {
+			return err
+		}
+	}
 	binary.Write(w, binary.BigEndian, s.Sum(nil))
 	return nil
 }
 
+// Encodes n in the variable length format used by version 4 indexes, which
+// is the same as the offset of an OFS_DELTA in a pack file.
+func encodeIndexVarint(n uint64) []byte {
+	var buf [16]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(n & 127)
+	for n >>= 7; n != 0; n >>= 7 {
+		n--
+		pos--
+		buf[pos] = 128 | byte(n&127)
+	}
+	return buf[pos:]
+}
+
+// Returns the index version that new index files should be written in,
+// from the GIT_INDEX_VERSION environment variable or the index.version
+// config, or 0 if neither is set to a supported version.
+func configuredIndexVersion(c *Client) uint32 {
+	val := os.Getenv("GIT_INDEX_VERSION")
+	if val == "" {
+		val = c.GetConfig("index.version")
+	}
+	if val == "" {
+		return 0
+	}
+	v, err := strconv.Atoi(val)
+	if err != nil || v < 2 || v > 4 {
+		log.Printf("index.version set, but the value %v is invalid\n", val)
+		return 0
+	}
+	return uint32(v)
+}
+
+// UseConfiguredVersion sets the version that g will be written in to the
+// one configured by index.version, if it's set. Version 2 is upgraded to
+// version 3 if any entries have extended flags, since they can't be stored
+// in version 2.
+func (g *Index) UseConfiguredVersion(c *Client) {
+	if v := configuredIndexVersion(c); v != 0 {
+		g.Version = v
+	}
+	if g.Version == 2 {
+		for _, entry := range g.Objects {
+			if entry.ExtendedFlag() {
+				g.Version = 3
+				break
+			}
+		}
+	}
+}
+
 // Looks up the Sha1 of path currently stored in the index.
 // Will return the 0 Sha1 if not found.
 func (g Index) GetSha1(path IndexPath) Sha1 {
@@ -692,6 +1029,11 @@ func (g *Index) ResetIndex(c *Client, tree Treeish) error {
 	}
 	g.NumberIndexEntries = uint32(len(newEntries))
 	g.Objects = newEntries
+	g.cacheTree = nil
+	g.untracked = nil
+	if g.fsmonitor != nil {
+		g.fsmonitor.valid = nil
+	}
 	return nil
 }
 
@@ -0,0 +1,122 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// Tests that a version 4 index, with prefix compressed path names, can be
+// written and read back to the same entries.
+func TestIndexV4RoundTrip(t *testing.T) {
+	c, cleanup := testRepo(t, "gitindexv4")
+	defer cleanup()
+
+	for _, name := range []string{"a/b/c/one", "a/b/c/two", "a/b/three", "a/b/three2", "a/four", "five", "zz/long/path/name/to/compress"} {
+		testCommitFile(t, c, name, name+"\n", "Add "+name)
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Version != 2 {
+		t.Fatalf("Unexpected initial index version: got %v want 2", idx.Version)
+	}
+	// Make sure the extended flags survive too.
+	if err := idx.SetSkipWorktree(c, "a/four", true); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetCachedConfig("index.version", "4")
+	idx.UseConfiguredVersion(c)
+	if idx.Version != 4 {
+		t.Fatalf("index.version was not used: got version %v", idx.Version)
+	}
+	f, err := c.GitDir.Create("index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.WriteIndex(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	v4, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v4.Version != 4 {
+		t.Errorf("Unexpected index version: got %v want 4", v4.Version)
+	}
+	if len(v4.Objects) != len(idx.Objects) {
+		t.Fatalf("Unexpected number of entries: got %v want %v", len(v4.Objects), len(idx.Objects))
+	}
+	for i := range idx.Objects {
+		if !reflect.DeepEqual(v4.Objects[i], idx.Objects[i]) {
+			t.Errorf("Entry %d: got %+v want %+v", i, v4.Objects[i], idx.Objects[i])
+		}
+	}
+
+	// Without padding, the version 4 index is smaller.
+	stat, err := os.Stat(c.GitDir.File("index").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCachedConfig("index.version", "3")
+	v4.UseConfiguredVersion(c)
+	f, err = c.GitDir.Create("index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v4.WriteIndex(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	v3, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v3.Version != 3 || !reflect.DeepEqual(v3.Objects, idx.Objects) {
+		t.Error("Index was not converted back to version 3")
+	}
+	v3stat, err := os.Stat(c.GitDir.File("index").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() >= v3stat.Size() {
+		t.Errorf("Version 4 index was not compressed: %v bytes for v4, %v bytes for v3", stat.Size(), v3stat.Size())
+	}
+}
+
+// Tests that a version 4 index which is truncated or corrupt in the middle
+// of the length to strip from the previous path name is an error, instead
+// of reading past the end of the file forever.
+func TestIndexV4Truncated(t *testing.T) {
+	c, cleanup := testRepo(t, "gitindexv4truncated")
+	defer cleanup()
+
+	// One entry whose strip length has the continuation bit set, and
+	// nothing after it.
+	var index bytes.Buffer
+	index.WriteString("DIRC")
+	binary.Write(&index, binary.BigEndian, []uint32{4, 1})
+	binary.Write(&index, binary.BigEndian, FixedIndexEntry{Mode: ModeBlob, Flags: 1})
+	index.WriteByte(0x80)
+	if err := c.GitDir.WriteFile("index", index.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GitDir.ReadIndex(); err == nil {
+		t.Error("Expected an error reading a truncated version 4 index")
+	}
+
+	// A strip length which doesn't fit in a uint64.
+	overflow := append(bytes.Repeat([]byte{0xff}, 10), 0)
+	if _, err := readIndexV4Strip(bytes.NewReader(overflow)); err != InvalidIndex {
+		t.Errorf("Unexpected error for an overflowing strip length: %v", err)
+	}
+	if got, err := readIndexV4Strip(bytes.NewReader([]byte{0x81, 0x00})); err != nil || got != 256 {
+		t.Errorf("Unexpected strip length: got %v (%v) want 256", got, err)
+	}
+}
@@ -0,0 +1,76 @@
+package git
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// An index which is kept in memory by a Client, along with the stat
+// information of the index file that it was read from.
+type indexCache struct {
+	sync.Mutex
+
+	index  *Index
+	stat   os.FileInfo
+	loaded time.Time
+}
+
+// The amount of time that the index file must be older than when it was
+// read for the cache to be trusted. If it's newer, it may have been written
+// again within the resolution of the file system's timestamps without its
+// size or mtime changing, so it's re-read every time until it's old enough.
+const racyIndexWindow = time.Second
+
+// Returns true if the cached index is still the same as the file described
+// by stat.
+func (ic *indexCache) valid(stat os.FileInfo) bool {
+	if ic.index == nil || ic.stat == nil {
+		return false
+	}
+	if !os.SameFile(ic.stat, stat) || ic.stat.Size() != stat.Size() || !ic.stat.ModTime().Equal(stat.ModTime()) {
+		return false
+	}
+	return ic.loaded.Sub(stat.ModTime()) >= racyIndexWindow
+}
+
+// GetIndex returns the index of c, reading it from disk the first time and
+// whenever .git/index has changed since it was last read. It's safe to call
+// from multiple goroutines.
+//
+// The returned index is shared with other callers and must not be modified.
+// Use GitDir.ReadIndex to get a copy that can be modified and written back.
+func (c *Client) GetIndex() (*Index, error) {
+	c.indexCache.Lock()
+	defer c.indexCache.Unlock()
+
+	stat, err := os.Stat(c.GitDir.File("index").String())
+	if err != nil {
+		c.indexCache.index, c.indexCache.stat = nil, nil
+		if os.IsNotExist(err) {
+			// There's no file to check for changes, so don't cache
+			// the empty index.
+			return c.GitDir.ReadIndex()
+		}
+		return nil, err
+	}
+	if c.indexCache.valid(stat) {
+		return c.indexCache.index, nil
+	}
+	loaded := time.Now()
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		c.indexCache.index, c.indexCache.stat = nil, nil
+		return nil, err
+	}
+	c.indexCache.index, c.indexCache.stat, c.indexCache.loaded = idx, stat, loaded
+	return idx, nil
+}
+
+// InvalidateIndex discards the index cached by GetIndex, so that the next
+// call reads it from disk.
+func (c *Client) InvalidateIndex() {
+	c.indexCache.Lock()
+	defer c.indexCache.Unlock()
+	c.indexCache.index, c.indexCache.stat = nil, nil
+}
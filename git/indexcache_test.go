@@ -0,0 +1,98 @@
+package git
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Tests that the index is only re-read by GetIndex when the file changes
+// or it's explicitly invalidated.
+func TestIndexCache(t *testing.T) {
+	c, cleanup := testRepo(t, "gitindexcache")
+	defer cleanup()
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+
+	// Make the index old enough that it's not racy.
+	age := func() {
+		t.Helper()
+		old := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(c.GitDir.File("index").String(), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	age()
+	idx, err := c.GetIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Objects) != 1 {
+		t.Fatalf("Unexpected number of index entries: got %v want 1", len(idx.Objects))
+	}
+	idx2, err := c.GetIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx2 != idx {
+		t.Error("Index was not cached")
+	}
+
+	// Concurrent readers all get the cached index.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := c.GetIndex(); err != nil || got != idx {
+				t.Errorf("Concurrent read did not return the cached index: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	c.InvalidateIndex()
+	idx3, err := c.GetIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx3 == idx {
+		t.Error("Index was not re-read after InvalidateIndex")
+	}
+
+	// Committing another file rewrites the index.
+	testCommitFile(t, c, "bar.txt", "bar\n", "Second commit")
+	idx4, err := c.GetIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx4 == idx3 {
+		t.Error("Index was not re-read after the file changed")
+	}
+	if len(idx4.Objects) != 2 {
+		t.Errorf("Unexpected number of index entries: got %v want 2", len(idx4.Objects))
+	}
+
+	// A newly written index isn't trusted until it's old enough, since
+	// another write might not change its mtime.
+	idx5, err := c.GetIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx5 == idx4 {
+		t.Error("Racily clean index was cached")
+	}
+	age()
+	idx6, err := c.GetIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx7, err := c.GetIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx6 == idx5 || idx7 != idx6 {
+		t.Error("Index was not cached once it was no longer racy")
+	}
+}
@@ -1,17 +1,20 @@
 package git
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"sort"
-	"sync"
+	"strings"
 
 	"crypto/sha1"
 	"encoding/binary"
 	"hash/crc32"
+
+	"github.com/driusan/dgit/zlib"
 )
 
 type IndexPackOptions struct {
@@ -22,8 +25,9 @@ type IndexPackOptions struct {
 	// the filename.
 	Output io.Writer
 
-	// Fix a "thin" pack produced by git pack-objects --thin
-	// (not implemented)
+	// Fix a "thin" pack produced by git pack-objects --thin by
+	// adding the objects from the repository that deltas are based on.
+	// Only valid when the pack is being copied.
 	FixThin bool
 
 	// A message to store in a .keep file. The string "none"
@@ -132,7 +136,7 @@ func v2PackIndexHasSha1(c *Client, pfile File, r io.Reader, obj Sha1) bool {
 		} else {
 			offset = int64(pack.FourByteOffsets[i])
 		}
-		c.objectCache[pack.Sha1Table[i]] = objectLocation{false, pfile, &pack, offset}
+		c.objectCache[pack.Sha1Table[i]] = objectLocation{packfile: pfile, index: &pack, offset: offset}
 	}
 	return pack.HasObject(obj)
 }
@@ -148,7 +152,10 @@ func (idx PackfileIndexV2) getObjectAtOffset(r io.ReaderAt, offset int64, metaOn
 
 	// 4k should be enough for the header.
 	metareader := io.NewSectionReader(r, offset, 4096)
-	t, sz, ref, refoffset, rawheader := p.ReadHeaderSize(metareader)
+	t, sz, ref, refoffset, rawheader, err := p.ReadHeaderSize(metareader)
+	if err != nil {
+		return nil, err
+	}
 	var rawdata []byte
 	// sz is the uncompressed size, so the total size should usually be
 	// less than sz for the compressed data. It might theoretically be a
@@ -264,20 +271,43 @@ func (idx PackfileIndexV2) getObjectAtOffset(r io.ReaderAt, offset int64, metaOn
 	}
 }
 
-// Find the object in the table.
-func (idx PackfileIndexV2) GetObjectMetadata(r io.ReaderAt, s Sha1) (GitObject, error) {
-	foundIdx := -1
-	startIdx := idx.Fanout[s[0]]
+// The longest chain of OFS_DELTA entries that packObjectTypeAtOffset will
+// follow, which is the deepest that git's pack-objects will make them.
+const maxOfsDeltaChain = 4095
 
-	// Packfiles are designed so that we could do a binary search here, but
-	// we don't need that optimization yet, so just do a linear search through
-	// the objects with the same first byte.
-	for i := startIdx - 1; idx.Sha1Table[i][0] == s[0]; i-- {
-		if s == idx.Sha1Table[i] {
-			foundIdx = int(i)
-			break
+// Returns the type of the object at offset in the pack r from the headers
+// of its entry and of the OFS_DELTA entries that it's based on. If the
+// chain ends with a REF_DELTA, OBJ_REF_DELTA is returned along with the
+// object that it's based on.
+func packObjectTypeAtOffset(r io.ReaderAt, offset int64) (PackEntryType, Sha1, error) {
+	var p PackfileHeader
+	for depth := 0; depth <= maxOfsDeltaChain; depth++ {
+		t, _, ref, refoffset, _, err := p.ReadHeaderSize(io.NewSectionReader(r, offset, 4096))
+		if err != nil {
+			return 0, Sha1{}, err
+		}
+		switch t {
+		case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+			return t, Sha1{}, nil
+		case OBJ_OFS_DELTA:
+			// The base must come before the delta in the pack, or
+			// the chain could go on forever.
+			if refoffset == 0 || uint64(refoffset) >= uint64(offset) {
+				return 0, Sha1{}, InvalidObject
+			}
+			offset -= int64(refoffset)
+		case OBJ_REF_DELTA:
+			return t, ref, nil
+		default:
+			return 0, Sha1{}, InvalidObject
 		}
 	}
+	return 0, Sha1{}, fmt.Errorf("Delta chain at offset %v is longer than %v", offset, maxOfsDeltaChain)
+}
+
+// Find the object in the table.
+func (idx PackfileIndexV2) GetObjectMetadata(r io.ReaderAt, s Sha1) (GitObject, error) {
+	foundIdx := idx.findObject(s)
 	if foundIdx == -1 {
 		return nil, fmt.Errorf("Object not found: %v", s)
 	}
@@ -297,24 +327,7 @@ func (idx PackfileIndexV2) GetObjectMetadata(r io.ReaderAt, s Sha1) (GitObject,
 }
 
 func (idx PackfileIndexV2) GetObject(r io.ReaderAt, s Sha1) (GitObject, error) {
-	foundIdx := -1
-	startIdx := idx.Fanout[s[0]]
-	if startIdx <= 0 {
-		// The fanout table holds the number of entries less than x, so we
-		// subtract 1 to make sure we don't miss the hash we're looking for,
-		// but we need a special case for s[0] == 0 to prevent underflow
-		startIdx = 1
-	}
-
-	// Packfiles are designed so that we could do a binary search here, but
-	// we don't need that optimization yet, so just do a linear search through
-	// the objects with the same first byte.
-	for i := startIdx - 1; idx.Sha1Table[i][0] == s[0]; i-- {
-		if s == idx.Sha1Table[i] {
-			foundIdx = int(i)
-			break
-		}
-	}
+	foundIdx := idx.findObject(s)
 	if foundIdx == -1 {
 		return nil, fmt.Errorf("Object not found: %v", s)
 	}
@@ -428,23 +441,28 @@ func (idx PackfileIndexV2) writeIndex(w io.Writer, withTrailer bool) error {
 	return nil
 }
 func (idx PackfileIndexV2) HasObject(s Sha1) bool {
-	startIdx := idx.Fanout[s[0]]
-	if startIdx <= 0 {
-		// The fanout table holds the number of entries less than x, so we
-		// subtract 1 to make sure we don't miss the hash we're looking for,
-		// but we need a special case for s[0] == 0 to prevent underflow
-		startIdx = 1
+	return idx.findObject(s) != -1
+}
+
+// Returns the index of s in the Sha1Table, or -1 if it isn't in the pack.
+func (idx PackfileIndexV2) findObject(s Sha1) int {
+	// The fanout table holds the number of entries whose first byte is
+	// less than or equal to x, so the last one with the same first byte
+	// as s comes just before it.
+	end := int(idx.Fanout[s[0]])
+	if end > len(idx.Sha1Table) {
+		end = len(idx.Sha1Table)
 	}
 
 	// Packfiles are designed so that we could do a binary search here, but
 	// we don't need that optimization yet, so just do a linear search through
 	// the objects with the same first byte.
-	for i := startIdx - 1; idx.Sha1Table[i][0] == s[0] && i >= 0; i-- {
+	for i := end - 1; i >= 0 && idx.Sha1Table[i][0] == s[0]; i-- {
 		if s == idx.Sha1Table[i] {
-			return true
+			return i
 		}
 	}
-	return false
+	return -1
 }
 
 // Implements the Sorter interface on PackfileIndexV2, in order to sort the
@@ -484,18 +502,67 @@ func (p *PackfileIndexV2) calculateTrailer() error {
 	return nil
 }
 
-func IndexPack(c *Client, opts IndexPackOptions, r io.Reader) (idx PackfileIndex, rerr error) {
+var errUnresolvedDelta = errors.New("Delta base has not been resolved yet")
+
+// An object in a packfile which is being indexed.
+type packIndexEntry struct {
+	Sha1     Sha1
+	resolved bool
+
+	location int64
+	crc      uint32
+	typ      PackEntryType
+
+	// The base of a REF_DELTA or location of the base of an OFS_DELTA
+	ref  Sha1
+	base int64
+}
+
+// Indexes the packfile read from r, writes the index to opts.Output (or a
+// file named after the pack if opts.Output is nil) and returns the
+// checksum which the pack is named after.
+//
+// If r is an *os.File and opts.Stdin is not set, the pack is indexed in
+// place. Otherwise, it's copied into the .git/objects/pack directory while
+// being indexed.
+//
+// If opts.FixThin is set, any deltas against objects which are not in the
+// pack are resolved against the objects in the repository, and those
+// objects are appended to the copied pack so that it is self-contained.
+func IndexPack(c *Client, opts IndexPackOptions, r io.Reader) (Sha1, error) {
+	idx, err := indexPack(c, opts, r)
+	if err != nil {
+		return Sha1{}, err
+	}
+	if opts.Output != nil {
+		return idx.Packfile, idx.WriteIndex(opts.Output)
+	}
+	if f, ok := r.(*os.File); ok && !opts.Stdin {
+		fidx, err := os.Create(strings.TrimSuffix(f.Name(), ".pack") + ".idx")
+		if err != nil {
+			return Sha1{}, err
+		}
+		defer fidx.Close()
+		return idx.Packfile, idx.WriteIndex(fidx)
+	}
+	return idx.Packfile, nil
+}
+
+func indexPack(c *Client, opts IndexPackOptions, r io.Reader) (indexfile PackfileIndexV2, rerr error) {
 	var p PackfileHeader
-	var indexfile PackfileIndexV2
 
 	iscopying := false
 	var file *os.File
 	if r2, ok := r.(*os.File); ok && !opts.Stdin {
 		file = r2
 	} else {
-		pack, err := ioutil.TempFile(c.GitDir.File("objects/pack").String(), ".tmppackfileidx")
+		packdir := c.objectWriteDir().String() + "/pack"
+		if err := os.MkdirAll(packdir, 0755); err != nil {
+			return indexfile, err
+		}
+		pack, err := ioutil.TempFile(packdir, ".tmppackfileidx")
 		if err != nil {
-			return nil, err
+			return indexfile, err
 		}
 		defer pack.Close()
 		file = pack
@@ -504,65 +571,63 @@ func IndexPack(c *Client, opts IndexPackOptions, r io.Reader) (idx PackfileIndex
 		// If -stdin was specified, we copy it to the pack directory
 		// namd after the trailer.
 		defer func() {
-			if rerr == nil && idx != nil {
-				packhash, _ := indexfile.GetTrailer()
-				base := fmt.Sprintf("%s/pack-%s", c.GitDir.File("objects/pack").String(), packhash)
-				if err := os.Rename(pack.Name(), base+".pack"); err != nil {
-					rerr = err
-					return
-				}
-				fidx, err := os.Create(base + ".idx")
-				if err != nil {
-					rerr = err
-					return
-				}
-				if err := indexfile.WriteIndex(fidx); err != nil {
-					rerr = err
-					return
-				}
+			if rerr != nil {
+				os.Remove(pack.Name())
+				return
+			}
+			base := fmt.Sprintf("%s/pack-%s", packdir, indexfile.Packfile)
+			if err := os.Rename(pack.Name(), base+".pack"); err != nil {
+				rerr = err
+				return
+			}
+			fidx, err := os.Create(base + ".idx")
+			if err != nil {
+				rerr = err
+				return
+			}
+			defer fidx.Close()
+			if err := indexfile.WriteIndex(fidx); err != nil {
+				rerr = err
+				return
 			}
 		}()
 	}
 	if err := binary.Read(r, binary.BigEndian, &p); err != nil {
-		return nil, err
+		return indexfile, err
 	}
 
 	if p.Signature != [4]byte{'P', 'A', 'C', 'K'} {
-		return nil, fmt.Errorf("Invalid packfile: %+v", p.Signature)
+		return indexfile, fmt.Errorf("Invalid packfile: %+v", p.Signature)
 	}
 	if p.Version != 2 {
-		return nil, fmt.Errorf("Unsupported packfile version: %d", p.Version)
+		return indexfile, fmt.Errorf("Unsupported packfile version: %d", p.Version)
 	}
 
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-	wg.Add(int(p.Size))
-
-	indexfile.magic = [4]byte{0377, 't', 'O', 'c'}
-	indexfile.Version = 2
-
-	indexfile.Sha1Table = make([]Sha1, p.Size)
-	indexfile.CRC32 = make([]uint32, p.Size)
-	indexfile.FourByteOffsets = make([]uint32, p.Size)
-	priorObjects := make(map[Sha1]ObjectOffset)
-
 	if iscopying {
 		// Seek past the header that was just copied.
 		file.Seek(12, io.SeekStart)
 	}
 
+	// Read through the pack once to find where every object is. The
+	// hash of non-deltified objects can be calculated right away, but
+	// deltas may refer to objects later in the pack, so they're resolved
+	// once everything has been read.
+	entries := make([]packIndexEntry, p.Size)
 	for i := uint32(0); i < p.Size; i += 1 {
 		if opts.Verbose {
 			progressF("Indexing objects: %2.f%% (%d/%d)", (float32(i+1) / float32(p.Size) * 100), i+1, p.Size)
 		}
 		location, err := file.Seek(0, io.SeekCurrent)
 		if err != nil {
-			panic(err)
+			return indexfile, err
 		}
 
 		checksum := crc32.NewIEEE()
 		tr := io.TeeReader(r, checksum)
-		t, _, ref, offset, _ := p.ReadHeaderSize(tr)
+		t, _, ref, offset, _, err := p.ReadHeaderSize(tr)
+		if err != nil {
+			return indexfile, err
+		}
 
 		var rawdata []byte
 
@@ -577,155 +642,278 @@ func IndexPack(c *Client, opts IndexPackOptions, r io.Reader) (idx PackfileIndex
 		} else {
 			// If we're reading from a file, we just read it
 			// directly since we can seek back.
-			rawdata, _ = p.readEntryDataStream2(file)
-		}
-		// The CRC32 checksum of the compressed data and the offset in
-		// the file don't change regardless of type.
-		mu.Lock()
-		indexfile.CRC32[i] = checksum.Sum32()
-
-		if location < (1 << 31) {
-			indexfile.FourByteOffsets[i] = uint32(location)
-		} else {
-			indexfile.FourByteOffsets[i] = uint32(len(indexfile.EightByteOffsets)) | (1 << 31)
-			indexfile.EightByteOffsets = append(indexfile.EightByteOffsets, uint64(location))
+			var compressed []byte
+			rawdata, compressed = p.readEntryDataStream2(file)
+			checksum.Write(compressed)
 		}
-		mu.Unlock()
 
-		// The way we calculate the hash changes based on if it's a delta
-		// or not.
-		var sha1 Sha1
+		entry := &entries[i]
+		entry.location = location
+		entry.crc = checksum.Sum32()
+		entry.typ = t
 		switch t {
-		case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB:
-			sha1, _, err = HashSlice(t.String(), rawdata)
-			if err != nil && opts.Strict {
+		case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+			sha1, _, err := HashSlice(t.String(), rawdata)
+			if err != nil {
 				return indexfile, err
 			}
-			mu.Lock()
-			for j := int(sha1[0]); j < 256; j++ {
-				indexfile.Fanout[j]++
-			}
-			indexfile.Sha1Table[i] = sha1
-			mu.Unlock()
+			entry.Sha1 = sha1
+			entry.resolved = true
+		case OBJ_REF_DELTA:
+			entry.ref = ref
+		case OBJ_OFS_DELTA:
+			entry.base = location - int64(offset)
+		default:
+			return indexfile, fmt.Errorf("Unhandled type in IndexPack: %v", t)
+		}
+	}
+	if opts.Verbose && p.Size > 0 {
+		progressF("Indexing objects: 100%% (%d/%d), done.\n", p.Size, p.Size)
+	}
+	// Read the packfile trailer into the index trailer.
+	if err := binary.Read(r, binary.BigEndian, &indexfile.Packfile); err != nil {
+		return indexfile, err
+	}
 
-			// Maintain the list of references for further chains
-			// to use.
-			mu.Lock()
-			priorObjects[sha1] = ObjectOffset(location)
-			mu.Unlock()
+	thin, err := resolvePackDeltas(c, opts, file, entries)
+	if err != nil {
+		return indexfile, err
+	}
+	if len(thin) > 0 {
+		if !iscopying {
+			return indexfile, fmt.Errorf("--fix-thin cannot be used without --stdin")
+		}
+		packsha, err := completeThinPack(file, thin, &entries)
+		if err != nil {
+			return indexfile, err
+		}
+		indexfile.Packfile = packsha
+	}
 
-			wg.Done()
-		case OBJ_REF_DELTA:
-			log.Printf("Resolving REF_DELTA from %v\n", ref)
-			mu.Lock()
-			o, ok := priorObjects[ref]
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Sha1[:], entries[j].Sha1[:]) < 0
+	})
+	indexfile.magic = [4]byte{0377, 't', 'O', 'c'}
+	indexfile.Version = 2
+	indexfile.Sha1Table = make([]Sha1, len(entries))
+	indexfile.CRC32 = make([]uint32, len(entries))
+	indexfile.FourByteOffsets = make([]uint32, len(entries))
+	for i, entry := range entries {
+		for j := int(entry.Sha1[0]); j < 256; j++ {
+			indexfile.Fanout[j]++
+		}
+		indexfile.Sha1Table[i] = entry.Sha1
+		indexfile.CRC32[i] = entry.crc
+		if entry.location < (1 << 31) {
+			indexfile.FourByteOffsets[i] = uint32(entry.location)
+		} else {
+			indexfile.FourByteOffsets[i] = uint32(len(indexfile.EightByteOffsets)) | (1 << 31)
+			indexfile.EightByteOffsets = append(indexfile.EightByteOffsets, uint64(entry.location))
+		}
+	}
+
+	err = indexfile.calculateTrailer()
+	return indexfile, err
+}
+
+// Resolves the deltas in the packfile in order to calculate their hashes.
+//
+// Deltas are resolved in multiple passes, since a REF_DELTA may refer to an
+// object which is later in the pack (or is itself a delta.) If opts.FixThin
+// is set, a delta whose base isn't in the pack at all is resolved against
+// the object in the repository and the external bases that were needed are
+// returned, so that they can be added to the pack.
+func resolvePackDeltas(c *Client, opts IndexPackOptions, file io.ReaderAt, entries []packIndexEntry) (map[Sha1]resolvedDelta, error) {
+	byLocation := make(map[int64]int, len(entries))
+	bySha := make(map[Sha1]int, len(entries))
+	unresolved := 0
+	for i, entry := range entries {
+		byLocation[entry.location] = i
+		if entry.resolved {
+			bySha[entry.Sha1] = i
+		} else {
+			unresolved++
+		}
+	}
+	total := unresolved
+
+	// Delta chains are resolved recursively, so keep a cache of the
+	// previous objects which were resolved so that the bases of long
+	// chains aren't continuously re-read. The cache is reset
+	// periodically to keep the memory bounded.
+	cache := make(map[int64]resolvedDelta)
+	thin := make(map[Sha1]resolvedDelta)
+	allowExternal := false
+
+	var objectAt func(i int) (resolvedDelta, error)
+	objectAt = func(i int) (resolvedDelta, error) {
+		entry := entries[i]
+		if obj, ok := cache[entry.location]; ok {
+			return obj, nil
+		}
+		var p PackfileHeader
+		r := io.NewSectionReader(file, entry.location, 1<<62)
+		if _, _, _, _, _, err := p.ReadHeaderSize(r); err != nil {
+			return resolvedDelta{}, err
+		}
+		data := p.readEntryDataStream1(r)
+
+		var base resolvedDelta
+		switch entry.typ {
+		case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+			obj := resolvedDelta{Value: data, Type: entry.typ}
+			if len(cache) > 1024 {
+				cache = make(map[int64]resolvedDelta)
+			}
+			cache[entry.location] = obj
+			return obj, nil
+		case OBJ_OFS_DELTA:
+			j, ok := byLocation[entry.base]
 			if !ok {
-				mu.Unlock()
-				panic("Could not find basis for REF_DELTA")
+				return resolvedDelta{}, fmt.Errorf("OFS_DELTA base at %d is not an object", entry.base)
 			}
-			// The refs in the index file need to be sorted in
-			// order for GetObject to look up the other SHA1s
-			// when resolving deltas. Chains don't have access
-			// to the priorObjects map that we have here.
-			sort.Sort(&indexfile)
-			mu.Unlock()
+			b, err := objectAt(j)
 			if err != nil {
-				return indexfile, err
+				return resolvedDelta{}, err
 			}
-			base, err := indexfile.getObjectAtOffset(file, int64(o), false)
-			if err != nil {
-				return indexfile, err
+			base = b
+		case OBJ_REF_DELTA:
+			if j, ok := bySha[entry.ref]; ok {
+				b, err := objectAt(j)
+				if err != nil {
+					return resolvedDelta{}, err
+				}
+				base = b
+			} else if b, ok := thin[entry.ref]; ok {
+				base = b
+			} else if !allowExternal {
+				return resolvedDelta{}, errUnresolvedDelta
+			} else {
+				obj, err := c.GetObject(entry.ref)
+				if err != nil {
+					return resolvedDelta{}, errUnresolvedDelta
+				}
+				base = resolvedDelta{Value: obj.GetContent(), Type: entry.ref.PackEntryType(c)}
+				thin[entry.ref] = base
 			}
-			res := resolvedDelta{Value: base.GetContent()}
-			_, val, err := calculateDelta(res, rawdata)
-			if err != nil {
-				return indexfile, err
+		}
+		t, val, err := calculateDelta(base, data)
+		if err != nil {
+			return resolvedDelta{}, err
+		}
+		obj := resolvedDelta{Value: val, Type: t}
+		if len(cache) > 1024 {
+			cache = make(map[int64]resolvedDelta)
+		}
+		cache[entry.location] = obj
+		return obj, nil
+	}
+
+	for progress := true; unresolved > 0; {
+		if !progress {
+			if allowExternal || !opts.FixThin {
+				return nil, fmt.Errorf("pack has %d unresolved deltas", unresolved)
 			}
-			switch base.GetType() {
-			case "commit":
-				t = OBJ_COMMIT
-			case "tree":
-				t = OBJ_TREE
-			case "blob":
-				t = OBJ_BLOB
-			default:
-				panic("Unhandled delta base type" + base.GetType())
+			// Everything that can be resolved from the pack has
+			// been, so look for the remaining bases in the repo.
+			allowExternal = true
+		}
+		progress = false
+		for i := range entries {
+			if entries[i].resolved {
+				continue
 			}
-			sha1, _, err = HashSlice(base.GetType(), val)
-			if err != nil && opts.Strict {
+			obj, err := objectAt(i)
+			if err == errUnresolvedDelta {
+				continue
+			} else if err != nil {
 				return nil, err
 			}
-
-			mu.Lock()
-			priorObjects[sha1] = ObjectOffset(location)
-			mu.Unlock()
-
-			mu.Lock()
-			for j := int(sha1[0]); j < 256; j++ {
-				indexfile.Fanout[j]++
-			}
-			indexfile.Sha1Table[i] = sha1
-
-			mu.Unlock()
-			wg.Done()
-		case OBJ_OFS_DELTA:
-			log.Printf("Resolving OFS_DELTA from %v\n", location-int64(offset))
-			base, err := indexfile.getObjectAtOffset(file, location-int64(offset), false)
-			if err != nil {
-				return indexfile, err
-			}
-
-			res := resolvedDelta{Value: base.GetContent()}
-			_, val, err := calculateDelta(res, rawdata)
+			sha1, _, err := HashSlice(obj.Type.String(), obj.Value)
 			if err != nil {
-				return indexfile, err
-			}
-			switch base.GetType() {
-			case "commit":
-				t = OBJ_COMMIT
-			case "tree":
-				t = OBJ_TREE
-			case "blob":
-				t = OBJ_BLOB
-			default:
-				panic("Unhandled delta base type" + base.GetType())
-			}
-			sha1, _, err = HashSlice(base.GetType(), val)
-			if err != nil && opts.Strict {
 				return nil, err
 			}
-
-			mu.Lock()
-			priorObjects[sha1] = ObjectOffset(location)
-			mu.Unlock()
-
-			mu.Lock()
-			for j := int(sha1[0]); j < 256; j++ {
-				indexfile.Fanout[j]++
+			entries[i].Sha1 = sha1
+			entries[i].resolved = true
+			bySha[sha1] = i
+			unresolved--
+			progress = true
+			if opts.Verbose {
+				progressF("Resolving deltas: %2.f%% (%d/%d)", (float32(total-unresolved) / float32(total) * 100), total-unresolved, total)
 			}
-			indexfile.Sha1Table[i] = sha1
-
-			mu.Unlock()
-			wg.Done()
-		default:
-			panic("Unhandled type in IndexPack: " + t.String())
-
 		}
 	}
-	// Read the packfile trailer into the index trailer.
-	binary.Read(r, binary.BigEndian, &indexfile.Packfile)
-	sort.Sort(&indexfile)
+	if opts.Verbose && total > 0 {
+		progressF("Resolving deltas: 100%% (%d/%d), done.\n", total, total)
+	}
+	return thin, nil
+}
 
-	// The sorting may have changed things, so as a final pass, hash
-	// everything to get the trailer (instead of doing it while we
-	// were calculating everything.)
-	err := indexfile.calculateTrailer()
-	return indexfile, err
+// Completes a thin pack by appending the external delta bases in thin to
+// the end of file, then updating the object count in the header and the
+// trailer. The entries for the appended objects are added to entries,
+// and the new pack checksum is returned.
+func completeThinPack(file *os.File, thin map[Sha1]resolvedDelta, entries *[]packIndexEntry) (Sha1, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return Sha1{}, err
+	}
+	// Overwrite the old trailer.
+	location := stat.Size() - 20
+
+	shas := make([]Sha1, 0, len(thin))
+	for sha := range thin {
+		shas = append(shas, sha)
+	}
+	sort.Slice(shas, func(i, j int) bool {
+		return bytes.Compare(shas[i][:], shas[j][:]) < 0
+	})
+	for _, sha := range shas {
+		obj := thin[sha]
+		var buf bytes.Buffer
+		if err := VariableLengthInt(len(obj.Value)).WriteVariable(&buf, obj.Type); err != nil {
+			return Sha1{}, err
+		}
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(obj.Value); err != nil {
+			return Sha1{}, err
+		}
+		if err := zw.Close(); err != nil {
+			return Sha1{}, err
+		}
+		if _, err := file.WriteAt(buf.Bytes(), location); err != nil {
+			return Sha1{}, err
+		}
+		*entries = append(*entries, packIndexEntry{
+			Sha1:     sha,
+			resolved: true,
+			location: location,
+			crc:      crc32.ChecksumIEEE(buf.Bytes()),
+			typ:      obj.Type,
+		})
+		location += int64(buf.Len())
+	}
+
+	var count [4]byte
+	binary.BigEndian.PutUint32(count[:], uint32(len(*entries)))
+	if _, err := file.WriteAt(count[:], 8); err != nil {
+		return Sha1{}, err
+	}
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, location)); err != nil {
+		return Sha1{}, err
+	}
+	trailer := h.Sum(nil)
+	if _, err := file.WriteAt(trailer, location); err != nil {
+		return Sha1{}, err
+	}
+	return Sha1FromSlice(trailer)
 }
 
 // Indexes the pack, and stores a copy in Client's .git/objects/pack directory as it's
 // doing so. This is the equivalent of "git index-pack --stdin", but works with any
 // reader.
-func IndexAndCopyPack(c *Client, opts IndexPackOptions, r io.Reader) (PackfileIndex, error) {
+func IndexAndCopyPack(c *Client, opts IndexPackOptions, r io.Reader) (Sha1, error) {
+	opts.Stdin = true
 	return IndexPack(c, opts, r)
 }
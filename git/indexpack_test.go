@@ -2,11 +2,152 @@ package git
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
+// Tests that a pack written by SendPackfile can be indexed, and that the
+// objects can be read through the index that was produced.
+func TestIndexPackRoundTrip(t *testing.T) {
+	c, cleanup := testRepo(t, "gitindexpack")
+	defer cleanup()
+
+	cmt := testCommitFile(t, c, "foo.txt", "bar\n", "Initial commit")
+	objs, err := cmt.GetAllObjectsExcept(c, make(map[Sha1]struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs = append(objs, Sha1(cmt))
+	var pack bytes.Buffer
+	if err := SendPackfile(c, &pack, objs); err != nil {
+		t.Fatal(err)
+	}
+
+	gitdir, err := ioutil.TempDir("", "gitindexpackdst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitdir)
+	dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, gitdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packsha, err := IndexAndCopyPack(dst, IndexPackOptions{}, bytes.NewReader(pack.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := pack.Bytes()[pack.Len()-20:]; !bytes.Equal(packsha[:], expected) {
+		t.Errorf("Unexpected pack checksum: got %v want %x", packsha, expected)
+	}
+	idxfile := dst.GitDir.File(File("objects/pack/pack-" + packsha.String() + ".idx"))
+	f, err := os.Open(idxfile.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	idx, err := readPackIndexV2(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Sha1Table) != len(objs) {
+		t.Errorf("Unexpected number of objects in index: got %v want %v", len(idx.Sha1Table), len(objs))
+	}
+
+	blob, err := RevParsePath(c, &RevParseOptions{}, "HEAD:foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packfile, err := os.Open(filepath.Join(gitdir, "objects/pack/pack-"+packsha.String()+".pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packfile.Close()
+	obj, err := idx.GetObject(packfile, Sha1(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.GetType() != "blob" || string(obj.GetContent()) != "bar\n" {
+		t.Errorf("Unexpected object from index: got %v %q", obj.GetType(), obj.GetContent())
+	}
+}
+
+// Tests that a thin pack is completed with the base of its delta from the
+// repository when FixThin is set.
+func TestIndexPackFixThin(t *testing.T) {
+	// The first pack from TestPackfileUnpack, with a blob and a REF_DELTA
+	// against it.
+	full := []byte{0x50, 0x41, 0x43, 0x4b, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x02, 0xbc, 0x08, 0x78, 0x9c,
+		0x73, 0xe4, 0x72, 0xc4, 0x09, 0x9d, 0xb8, 0x9c, 0xb9, 0x5c, 0xb8, 0x5c, 0xe9, 0x46, 0x03, 0x00,
+		0xcc, 0xc9, 0x15, 0x0f, 0x75, 0xbe, 0x22, 0xa5, 0xc7, 0xd7, 0xb2, 0x5c, 0x99, 0x0d, 0x89, 0xd7,
+		0xc1, 0x83, 0x82, 0xf0, 0x81, 0x5f, 0x68, 0x3f, 0x17, 0x78, 0x9c, 0xeb, 0x61, 0x2c, 0x9a, 0x50,
+		0x04, 0x00, 0x05, 0xad, 0x02, 0x02, 0x75, 0xb8, 0x48, 0x6c, 0x4d, 0xda, 0x43, 0x46, 0x17, 0x0b,
+		0x29, 0x86, 0x95, 0x51, 0x0a, 0x29, 0x29, 0x68, 0x86, 0xe4}
+
+	// Make a thin pack with only the delta by dropping the first object.
+	var thin bytes.Buffer
+	thin.Write(full[:8])
+	binary.Write(&thin, binary.BigEndian, uint32(1))
+	thin.Write(full[36 : len(full)-20])
+	trailer := sha1.Sum(thin.Bytes())
+	thin.Write(trailer[:])
+
+	gitdir, err := ioutil.TempDir("", "gitindexpackthin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitdir)
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, gitdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IndexAndCopyPack(c, IndexPackOptions{}, bytes.NewReader(thin.Bytes())); err == nil {
+		t.Fatal("Expected error indexing thin pack without FixThin")
+	}
+
+	// Add the base to the repo and try again.
+	if _, err := UnpackObjects(c, UnpackObjectsOptions{Quiet: true}, bytes.NewReader(full)); err != nil {
+		t.Fatal(err)
+	}
+	base, _ := Sha1FromString("be22a5c7d7b25c990d89d7c18382f0815f683f17")
+	expected, err := c.GetObject(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := ioutil.TempDir("", "gitindexpackthindst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	c2, err := Init(nil, InitOptions{Quiet: true, Bare: true}, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.WriteObject("blob", expected.GetContent()); err != nil {
+		t.Fatal(err)
+	}
+
+	packsha, err := IndexAndCopyPack(c2, IndexPackOptions{FixThin: true}, bytes.NewReader(thin.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, err := VerifyPack(c2, VerifyPackOptions{}, c2.GitDir.File(File("objects/pack/pack-"+packsha.String()+".idx")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, obj := range objs {
+		found[obj.Sha1.String()] = true
+	}
+	if len(objs) != 2 || !found["84dfc6fb0e86cf29049d53041e2d55f863eacfd8"] || !found[base.String()] {
+		t.Errorf("Unexpected objects in completed pack: %v", objs)
+	}
+}
+
 func BenchmarkIndexPackFromFile(b *testing.B) {
 	// A random small pack file, the same as one from TestUnpackObjects.
 	// OFS_DELTA chain with a length of 2. It's not a very realistic
@@ -41,6 +182,80 @@ func BenchmarkIndexPackFromFile(b *testing.B) {
 	}
 }
 
+// Tests that OFS_DELTA entries which don't point back into the pack, or
+// make a chain that's too long, are errors instead of looping forever.
+func TestPackObjectTypeBadOfsDelta(t *testing.T) {
+	header := []byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, 1}
+	tests := []struct {
+		desc  string
+		entry []byte
+	}{
+		{"zero offset", []byte{0x60, 0x00}},
+		{"offset before the pack", []byte{0x60, 0x0d}},
+		{"offset of the pack header", []byte{0x60, 0x0c}},
+		{"overflowing offset", append(append([]byte{0x60}, bytes.Repeat([]byte{0xff}, 10)...), 0x7f)},
+		{"truncated offset", []byte{0x60, 0x80}},
+	}
+	for _, tc := range tests {
+		pack := append(append([]byte{}, header...), tc.entry...)
+		if _, _, err := packObjectTypeAtOffset(bytes.NewReader(pack), 12); err == nil {
+			t.Errorf("%v: no error", tc.desc)
+		}
+	}
+
+	// A blob followed by a chain of deltas each based on the entry
+	// before it.
+	chain := func(n int) []byte {
+		pack := append(append([]byte{}, header...), 0x30, 0x00)
+		for i := 0; i < n; i++ {
+			pack = append(pack, 0x60, 0x02)
+		}
+		return pack
+	}
+	pack := chain(maxOfsDeltaChain)
+	if typ, _, err := packObjectTypeAtOffset(bytes.NewReader(pack), int64(len(pack)-2)); err != nil || typ != OBJ_BLOB {
+		t.Errorf("Unexpected type for maximum length chain: got %v (%v) want %v", typ, err, OBJ_BLOB)
+	}
+	pack = chain(maxOfsDeltaChain + 1)
+	if _, _, err := packObjectTypeAtOffset(bytes.NewReader(pack), int64(len(pack)-2)); err == nil {
+		t.Error("No error for a chain which is too long")
+	}
+}
+
+// Tests that looking up an object which isn't in a pack index doesn't run
+// off the start of the table when the first entry has the same first byte.
+func TestPackIndexMissingObject(t *testing.T) {
+	var a, b, missing Sha1
+	a[1], b[1], missing[1] = 0xaa, 0xbb, 0xcc
+	var idx PackfileIndexV2
+	for i := range idx.Fanout {
+		idx.Fanout[i] = 2
+	}
+	idx.Sha1Table = []Sha1{a, b}
+	idx.FourByteOffsets = []uint32{12, 12}
+
+	for _, tc := range []struct {
+		desc string
+		idx  PackfileIndexV2
+	}{
+		{"pack", idx},
+		{"empty pack", PackfileIndexV2{}},
+	} {
+		if tc.idx.HasObject(missing) {
+			t.Errorf("%v: HasObject found a missing object", tc.desc)
+		}
+		if _, err := tc.idx.GetObject(bytes.NewReader(nil), missing); err == nil {
+			t.Errorf("%v: GetObject found a missing object", tc.desc)
+		}
+		if _, err := tc.idx.GetObjectMetadata(bytes.NewReader(nil), missing); err == nil {
+			t.Errorf("%v: GetObjectMetadata found a missing object", tc.desc)
+		}
+	}
+	if !idx.HasObject(a) || !idx.HasObject(b) {
+		t.Error("HasObject did not find the objects in the pack")
+	}
+}
+
 func BenchmarkIndexPackFromReader(b *testing.B) {
 	// A random small pack file, the same as one from TestUnpackObjects.
 	// OFS_DELTA chain with a length of 2. It's not a very realistic
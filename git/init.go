@@ -3,17 +3,29 @@ package git
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type InitOptions struct {
 	Quiet bool
 	Bare  bool
 
+	// The directory that files, such as hooks, are copied into the git
+	// directory from. If it's empty, $GIT_TEMPLATE_DIR or init.templateDir
+	// is used if either is set.
 	Template File
 
-	// Not implemented
+	// The name of the branch that HEAD refers to in a new repository. If
+	// it's empty, init.defaultBranch is used, or master if that isn't
+	// set either.
+	InitialBranch string
+
+	// Create the git directory at SeparateGitDir instead of in the .git
+	// directory of the work tree, which becomes a .git file pointing to
+	// it. An existing .git directory is moved there.
 	SeparateGitDir File
 
 	// Not implemented
@@ -33,17 +45,26 @@ func Init(c *Client, opts InitOptions, dir string) (*Client, error) {
 		return nil, err
 	}
 
+	if opts.Bare && opts.SeparateGitDir != "" {
+		return nil, fmt.Errorf("--separate-git-dir and --bare are mutually exclusive")
+	}
+	if opts.InitialBranch != "" {
+		if err := checkInitialBranch(opts.InitialBranch); err != nil {
+			return nil, err
+		}
+	}
+
 	reinit := false
 	if !opts.Bare {
-		if File(dir + "/.git").Exists() {
-			reinit = true
-		} else if err := os.Mkdir(dir+"/.git", 0755); err != nil {
+		gitdir, existed, err := initGitDir(dir, opts.SeparateGitDir)
+		if err != nil {
 			return nil, err
 		}
+		reinit = existed
 		if c != nil {
 			// These must be absolute to be sure that filepath.Rel
 			// will work when cloning.
-			gd, err := filepath.Abs(filepath.Join(dir, ".git"))
+			gd, err := filepath.Abs(gitdir)
 			if err != nil {
 				return nil, err
 			}
@@ -54,7 +75,7 @@ func Init(c *Client, opts InitOptions, dir string) (*Client, error) {
 			c.GitDir = GitDir(gd)
 			c.WorkDir = WorkDir(wd)
 		} else {
-			c2, err := NewClient(dir+"/.git", dir)
+			c2, err := NewClient(gitdir, dir)
 			if err != nil {
 				return nil, err
 			}
@@ -103,16 +124,32 @@ func Init(c *Client, opts InitOptions, dir string) (*Client, error) {
 		bareConf = "bare = true"
 	}
 
-	if c.GitDir.File("HEAD").Exists() {
+	// The config is written first, since reading init.defaultBranch
+	// from the config of c would otherwise create an empty one.
+	if c.GitDir.File("config").Exists() {
 		reinit = true
-	} else if err := c.GitDir.WriteFile("HEAD", []byte("ref: refs/heads/master\n"), 0644); err != nil {
+	} else if err := c.GitDir.WriteFile("config", []byte("[core]\n\trepositoryformatversion = 0\n\t"+bareConf+"\n"), 0644); err != nil {
 		return nil, err
 	}
 
-	if c.GitDir.File("config").Exists() {
+	if c.GitDir.File("HEAD").Exists() {
 		reinit = true
-	} else if err := c.GitDir.WriteFile("config", []byte("[core]\n\trepositoryformatversion = 0\n\t"+bareConf+"\n"), 0644); err != nil {
-		return nil, err
+		if opts.InitialBranch != "" {
+			fmt.Fprintf(os.Stderr, "warning: re-init: ignored --initial-branch=%v\n", opts.InitialBranch)
+		}
+	} else {
+		branch := opts.InitialBranch
+		if branch == "" {
+			branch = c.GetConfig("init.defaultBranch")
+			if branch == "" {
+				branch = "master"
+			} else if err := checkInitialBranch(branch); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.GitDir.WriteFile("HEAD", []byte("ref: refs/heads/"+branch+"\n"), 0644); err != nil {
+			return nil, err
+		}
 	}
 	if c.GitDir.File("description").Exists() {
 		reinit = true
@@ -131,6 +168,13 @@ func Init(c *Client, opts InitOptions, dir string) (*Client, error) {
 		}
 	}
 
+	if opts.Template == "" {
+		if tmpl := os.Getenv("GIT_TEMPLATE_DIR"); tmpl != "" {
+			opts.Template = File(tmpl)
+		} else {
+			opts.Template = File(c.GetConfig("init.templateDir"))
+		}
+	}
 	if opts.Template != "" {
 		err := filepath.Walk(opts.Template.String(), func(path string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -147,7 +191,9 @@ func Init(c *Client, opts InitOptions, dir string) (*Client, error) {
 				if c.GitDir.File(File(path)).Exists() {
 					return nil
 				}
-				newFile, err := c.GitDir.Create(File(path))
+				// Hooks need to keep their permissions to be
+				// executable.
+				newFile, err := os.OpenFile(c.GitDir.File(File(path)).String(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
 				if err != nil {
 					return err
 				}
@@ -171,3 +217,69 @@ func Init(c *Client, opts InitOptions, dir string) (*Client, error) {
 
 	return c, nil
 }
+
+// Returns the git directory for a repository with the work tree dir, creating
+// it if it doesn't exist, and whether it already existed. If separate is set,
+// the git directory is there and the .git file of dir points to it.
+func initGitDir(dir string, separate File) (string, bool, error) {
+	dotgit := File(filepath.Join(dir, ".git"))
+	if separate == "" {
+		switch {
+		case dotgit.IsDir():
+			return dotgit.String(), true, nil
+		case dotgit.Exists():
+			gitdir, err := readGitFile(dotgit)
+			return gitdir, true, err
+		}
+		return dotgit.String(), false, os.Mkdir(dotgit.String(), 0755)
+	}
+
+	gitdir, err := filepath.Abs(separate.String())
+	if err != nil {
+		return "", false, err
+	}
+	existed := false
+	switch {
+	case dotgit.IsDir():
+		// As in git, an existing repository is moved to the
+		// separate git directory.
+		if err := os.Rename(dotgit.String(), gitdir); err != nil {
+			return "", false, err
+		}
+		existed = true
+	case dotgit.Exists():
+		old, err := readGitFile(dotgit)
+		if err != nil {
+			return "", false, err
+		}
+		if old != gitdir {
+			if err := os.Rename(old, gitdir); err != nil {
+				return "", false, err
+			}
+		}
+		existed = true
+	default:
+		if err := os.MkdirAll(gitdir, 0755); err != nil {
+			return "", false, err
+		}
+	}
+	if err := ioutil.WriteFile(dotgit.String(), []byte("gitdir: "+gitdir+"\n"), 0644); err != nil {
+		return "", false, err
+	}
+	return gitdir, existed, nil
+}
+
+// Returns an error if name can't be the name of the initial branch.
+func checkInitialBranch(name string) error {
+	if name == "HEAD" || name == "" || strings.HasPrefix(name, "-") || strings.HasPrefix(name, "/") ||
+		strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".lock") ||
+		strings.Contains(name, "..") || strings.Contains(name, "//") || strings.ContainsAny(name, " ~^:?*[\\\x7f") {
+		return fmt.Errorf("fatal: invalid initial branch name: '%v'", name)
+	}
+	for _, ch := range name {
+		if ch < ' ' {
+			return fmt.Errorf("fatal: invalid initial branch name: '%v'", name)
+		}
+	}
+	return nil
+}
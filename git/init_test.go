@@ -3,6 +3,7 @@ package git
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -33,3 +34,121 @@ func TestInitRepo(t *testing.T) {
 		t.Errorf("Unexpected head reference. got %v want %v", string(head), e)
 	}
 }
+
+func TestInitOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitinitopts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The default branch comes from the global config.
+	home := os.Getenv("HOME")
+	defer os.Setenv("HOME", home)
+	os.Setenv("HOME", dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, ".gitconfig"), []byte("[init]\n\tdefaultBranch = trunk\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	readHead := func(c *Client) string {
+		t.Helper()
+		head, err := c.GitDir.ReadFile("HEAD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(head)
+	}
+
+	// A template's hooks are copied with their permissions.
+	tmpl := filepath.Join(dir, "template")
+	if err := os.MkdirAll(filepath.Join(tmpl, "hooks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpl, "hooks", "pre-commit"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	work := filepath.Join(dir, "work")
+	c, err := Init(nil, InitOptions{Quiet: true, Template: File(tmpl)}, work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readHead(c), "ref: refs/heads/trunk\n"; got != want {
+		t.Errorf("Unexpected HEAD with init.defaultBranch: got %q want %q", got, want)
+	}
+	if c.IsBare() || c.WorkDir != WorkDir(work) {
+		t.Errorf("Unexpected non-bare repository: bare %v WorkDir %v", c.IsBare(), c.WorkDir)
+	}
+	if stat, err := os.Stat(filepath.Join(work, ".git", "hooks", "pre-commit")); err != nil {
+		t.Error(err)
+	} else if stat.Mode().Perm()&0100 == 0 {
+		t.Errorf("Hook from template is not executable: %v", stat.Mode())
+	}
+
+	// Reinitializing doesn't change the existing repository.
+	if err := c.GitDir.WriteFile("description", []byte("mine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = Init(nil, InitOptions{Quiet: true, InitialBranch: "other"}, work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readHead(c), "ref: refs/heads/trunk\n"; got != want {
+		t.Errorf("Unexpected HEAD after reinitializing: got %q want %q", got, want)
+	}
+	if desc, err := c.GitDir.ReadFile("description"); err != nil || string(desc) != "mine\n" {
+		t.Errorf("Description was overwritten: %q %v", desc, err)
+	}
+
+	// A bare repository with a custom initial branch.
+	bare := filepath.Join(dir, "bare.git")
+	c, err = Init(nil, InitOptions{Quiet: true, Bare: true, InitialBranch: "main"}, bare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readHead(c), "ref: refs/heads/main\n"; got != want {
+		t.Errorf("Unexpected HEAD with an initial branch: got %q want %q", got, want)
+	}
+	if !c.IsBare() {
+		t.Error("Bare repository is not bare")
+	}
+	if File(filepath.Join(bare, ".git")).Exists() {
+		t.Error("Bare repository has a .git directory")
+	}
+	if _, err := Init(nil, InitOptions{Quiet: true, InitialBranch: "bad..name"}, filepath.Join(dir, "bad")); err == nil {
+		t.Error("Expected an error for an invalid initial branch")
+	}
+
+	// A separate git directory is pointed to by the .git file.
+	sep := filepath.Join(dir, "sep.git")
+	c, err = Init(nil, InitOptions{Quiet: true, SeparateGitDir: File(sep)}, filepath.Join(dir, "sepwork"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GitDir != GitDir(sep) {
+		t.Errorf("Unexpected GitDir with a separate git directory: got %v want %v", c.GitDir, sep)
+	}
+	if c, err := OpenRepository(filepath.Join(dir, "sepwork")); err != nil {
+		t.Error(err)
+	} else if c.GitDir != GitDir(sep) {
+		t.Errorf("Unexpected GitDir from the .git file: got %v want %v", c.GitDir, sep)
+	}
+
+	// NewClient follows the .git file when it's found from a
+	// subdirectory of the work tree, or given as the git directory.
+	sepwork := filepath.Join(dir, "sepwork")
+	if err := os.Mkdir(filepath.Join(sepwork, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(sepwork, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	for _, gitdir := range []string{"", filepath.Join(sepwork, ".git")} {
+		c, err := NewClient(gitdir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.GitDir != GitDir(sep) || c.WorkDir != WorkDir(sepwork) {
+			t.Errorf("Unexpected client for .git file with git dir %q: GitDir %v WorkDir %v", gitdir, c.GitDir, c.WorkDir)
+		}
+	}
+}
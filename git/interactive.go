@@ -14,7 +14,8 @@ type patchHunk struct {
 	Hunk string
 }
 
-// Split a patch into the hunks which make up the patch.
+// Split a patch into the hunks which make up the patch. If nameonly is set,
+// it's split into the part of the patch for each file instead.
 func splitPatch(fullpatch string, nameonly bool) ([]patchHunk, error) {
 	// Regexp to extract the different files that are part of the patch
 	fileRE := regexp.MustCompile(`(?m)^diff --git a/([[:graph:]]+) b/([[:graph:]]+)$`)
@@ -33,7 +34,7 @@ func splitPatch(fullpatch string, nameonly bool) ([]patchHunk, error) {
 			patch = fullpatch[match[0]:filechunks[i+1][0]]
 		}
 		if nameonly {
-			ret = append(ret, patchHunk{IndexPath(a), ""})
+			ret = append(ret, patchHunk{IndexPath(a), patch})
 
 		} else {
 			pieces := extractPatchHunks(IndexPath(a), patch)
@@ -45,7 +46,7 @@ func splitPatch(fullpatch string, nameonly bool) ([]patchHunk, error) {
 
 func extractPatchHunks(name IndexPath, filepatch string) []patchHunk {
 	// Regex to extract the hunk header which delineates different hunks
-	hunkRE := regexp.MustCompile(`(?m)^@@ -([\d]+)(?:,[\d]+)? \+([\d]+)(?:,[\d]+)? @@$`)
+	hunkRE := regexp.MustCompile(`(?m)^@@ -([\d]+)(?:,[\d]+)? \+([\d]+)(?:,[\d]+)? @@.*$`)
 
 	// Regex to extract parts of that hunk that are actually part of the patch.
 	// Must start with a space, a plus, or a minus sign (for context diff)
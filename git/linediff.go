@@ -0,0 +1,93 @@
+package git
+
+import (
+	"strings"
+)
+
+// A linePair represents a line which is common between two files in a line
+// based diff. A is the (0 indexed) line number in the first file and B is
+// the line number in the second.
+type linePair struct {
+	A, B int
+}
+
+// Splits content into lines for a line based diff. The trailing newline
+// of each line is not included.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// diffLines calculates the longest common subsequence of a and b using
+// Myers' algorithm, and returns the lines in common as pairs of indexes
+// in ascending order. Any line in a without a pair was removed and any
+// line in b without a pair was added.
+func diffLines(a, b []string) []linePair {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	// v[max+k] is the furthest x reached on diagonal k. trace[d] holds
+	// the window [-d, d] of v at the start of round d, so that we can
+	// backtrack once we've found the shortest edit script.
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	var x, y int
+search:
+	for d := 0; d <= max; d++ {
+		window := make([]int, 2*d+1)
+		copy(window, v[max-d:max+d+1])
+		trace = append(trace, window)
+
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y = x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var pairs []linePair
+	x, y = n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		w := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && w[k-1+d] < w[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		var prevX int
+		if d > 0 {
+			prevX = w[prevK+d]
+		}
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			pairs = append(pairs, linePair{x, y})
+		}
+		x, y = prevX, prevY
+	}
+
+	// We found them from the end, so reverse them.
+	for i, j := 0, len(pairs)-1; i < j; i, j = i+1, j-1 {
+		pairs[i], pairs[j] = pairs[j], pairs[i]
+	}
+	return pairs
+}
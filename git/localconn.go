@@ -95,11 +95,11 @@ func (s localConn) GetRefs(opts LsRemoteOptions, patterns []string) ([]Ref, erro
 				return nil, err
 			}
 			refstr := string(line[0:n])
-			ref, err := parseLsRef(refstr)
+			refs, err := parseLsRef(refstr)
 			if err != nil {
 				return nil, err
 			}
-			vals = append(vals, ref)
+			vals = append(vals, refs...)
 		}
 	default:
 		return nil, fmt.Errorf("Protocol version not supported")
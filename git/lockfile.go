@@ -0,0 +1,161 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A LockError is returned when a file can't be locked because its lock
+// file already exists.
+type LockError struct {
+	File File
+}
+
+func (e LockError) Error() string {
+	return fmt.Sprintf("Unable to lock %v: %v.lock exists.\n\nAnother process seems to be running in this repository. If it's not, a process may have crashed while holding the lock, so remove the lock file and try again.", e.File, e.File)
+}
+
+// A LockFile is a lock on a file in the GitDir. While it's held, the new
+// content of the file is written to the LockFile, which replaces the file
+// when it's committed. Like git, the lock is the existence of a file with
+// the same name and a ".lock" suffix.
+type LockFile struct {
+	*os.File
+	target File
+}
+
+// The lock files which are currently held, so that they can be cleaned up
+// if the process is interrupted.
+var heldLocks = struct {
+	sync.Mutex
+	files map[*LockFile]struct{}
+}{files: make(map[*LockFile]struct{})}
+
+// Locks the file f relative to GitDir. If the lock is already held, a
+// LockError is returned.
+//
+// The caller must call Commit or Rollback on the returned LockFile to
+// release the lock.
+func (gd GitDir) Lock(f File) (*LockFile, error) {
+	target := File(filepath.Join(gd.String(), f.String()))
+	if dir := File(filepath.Dir(target.String())); !dir.Exists() {
+		if err := os.MkdirAll(dir.String(), 0755); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(target.String()+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, LockError{target}
+		}
+		return nil, err
+	}
+	l := &LockFile{file, target}
+	heldLocks.Lock()
+	heldLocks.files[l] = struct{}{}
+	heldLocks.Unlock()
+	return l, nil
+}
+
+// Returns true if l is still locked, and forgets about it so that the
+// lock is only released once.
+func (l *LockFile) release() bool {
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+	if _, ok := heldLocks.files[l]; !ok {
+		return false
+	}
+	delete(heldLocks.files, l)
+	return true
+}
+
+// Commit replaces the locked file with the content written to l, and
+// releases the lock.
+func (l *LockFile) Commit() error {
+	if !l.release() {
+		return fmt.Errorf("%v is not locked", l.target)
+	}
+	if err := l.File.Close(); err != nil {
+		os.Remove(l.Name())
+		return err
+	}
+	if err := os.Rename(l.Name(), l.target.String()); err != nil {
+		os.Remove(l.Name())
+		return err
+	}
+	return nil
+}
+
+// Rollback releases the lock without changing the locked file. It does
+// nothing if the lock was already committed, or if l is nil, so it can be
+// deferred to clean up the lock if anything fails before Commit.
+func (l *LockFile) Rollback() error {
+	if l == nil || !l.release() {
+		return nil
+	}
+	l.File.Close()
+	return os.Remove(l.Name())
+}
+
+// RollbackLockFiles releases all of the locks held by the process without
+// changing the locked files. It should be called if the process is
+// interrupted, so that it doesn't leave behind stale locks.
+func RollbackLockFiles() {
+	heldLocks.Lock()
+	var locks []*LockFile
+	for l := range heldLocks.files {
+		locks = append(locks, l)
+	}
+	heldLocks.Unlock()
+	for _, l := range locks {
+		l.Rollback()
+	}
+}
+
+// WriteLocked locks the file f relative to GitDir and replaces it with the
+// content that write writes, releasing the lock when it's done.
+func (gd GitDir) WriteLocked(f File, write func(w io.Writer) error) error {
+	l, err := gd.Lock(f)
+	if err != nil {
+		return err
+	}
+	defer l.Rollback()
+	if err := write(l); err != nil {
+		return err
+	}
+	return l.Commit()
+}
+
+// LockIndex locks the index of gd and then reads it, like git's
+// hold_locked_index. The lock should be held while the new index is
+// computed from the one that was read, so that changes made to the index
+// by another process in the meantime aren't lost. If the index doesn't
+// exist, the Index is empty.
+//
+// The caller must release the returned LockFile, either with
+// Index.CommitLocked or Rollback.
+func (gd GitDir) LockIndex() (*Index, *LockFile, error) {
+	l, err := gd.Lock("index")
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, err := gd.ReadIndex()
+	if err != nil {
+		l.Rollback()
+		return nil, nil, err
+	}
+	return idx, l, nil
+}
+
+// CommitLocked writes g to the lock l from LockIndex, and commits it to
+// replace the index.
+func (g *Index) CommitLocked(l *LockFile) error {
+	if err := g.WriteIndex(l); err != nil {
+		l.Rollback()
+		return err
+	}
+	return l.Commit()
+}
@@ -0,0 +1,136 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	c, cleanup := testRepo(t, "gitlockfile")
+	defer cleanup()
+
+	initial := testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	oldindex, err := c.GitDir.ReadFile("index")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// While another writer holds the locks, writing the index or a ref
+	// must fail rather than change them.
+	indexlock, err := c.GitDir.Lock("index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reflock, err := c.GitDir.Lock("refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GitDir.Lock("index"); err == nil {
+		t.Error("Locked the index twice")
+	} else if _, ok := err.(LockError); !ok {
+		t.Errorf("Unexpected error locking the index twice: %v", err)
+	}
+
+	if err := ioutil.WriteFile("bar.txt", []byte("bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"bar.txt"}); err == nil {
+		t.Error("Add succeeded while the index was locked")
+	} else if _, ok := err.(LockError); !ok {
+		t.Errorf("Unexpected error adding while the index was locked: %v", err)
+	}
+	if index, err := c.GitDir.ReadFile("index"); err != nil {
+		t.Fatal(err)
+	} else if string(index) != string(oldindex) {
+		t.Error("Index changed while it was locked")
+	}
+
+	tree, err := WriteTree(c, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmt, err := CommitTree(c, CommitTreeOptions{}, tree, []CommitID{initial}, "Second commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef(c, UpdateRefOptions{OldValue: initial}, "refs/heads/master", cmt, ""); err == nil {
+		t.Error("UpdateRef succeeded while the ref was locked")
+	}
+	if head, err := c.GetHeadCommit(); err != nil || head != initial {
+		t.Errorf("HEAD changed while it was locked: got %v want %v (%v)", head, initial, err)
+	}
+
+	// Once the locks are released, the writes succeed.
+	if err := indexlock.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	RollbackLockFiles()
+	if c.GitDir.File("refs/heads/master.lock").Exists() {
+		t.Error("RollbackLockFiles did not release the lock")
+	}
+	if err := reflock.Commit(); err == nil {
+		t.Error("Committed a lock that was rolled back")
+	}
+	if _, err := Add(c, AddOptions{}, []File{"bar.txt"}); err != nil {
+		t.Error(err)
+	}
+	if err := UpdateRef(c, UpdateRefOptions{OldValue: initial}, "refs/heads/master", cmt, ""); err != nil {
+		t.Error(err)
+	}
+	if head, err := c.GetHeadCommit(); err != nil || head != cmt {
+		t.Errorf("Unexpected HEAD: got %v want %v (%v)", head, cmt, err)
+	}
+}
+
+// Tests that concurrent writers of the index don't lose each other's
+// updates, since the index is locked before it's read.
+func TestLockIndexConcurrentAdd(t *testing.T) {
+	c, cleanup := testRepo(t, "gitlockindex")
+	defer cleanup()
+	testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("file%d.txt", i)
+		if err := ioutil.WriteFile(name, []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			// Each writer is a separate client, as it would be in
+			// a separate process.
+			wc, err := NewClient(c.GitDir.String(), c.WorkDir.String())
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			for {
+				_, err := Add(wc, AddOptions{}, []File{File(name)})
+				if _, ok := err.(LockError); ok {
+					continue
+				}
+				errs[i] = err
+				return
+			}
+		}(i, name)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(idx.Objects); got != n+1 {
+		t.Errorf("Unexpected number of index entries: got %v want %v\n%v", got, n+1, idx)
+	}
+}
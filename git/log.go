@@ -0,0 +1,275 @@
+package git
+
+import (
+	"fmt"
+	"time"
+)
+
+// LogOptions denotes the options for walking the history in "git log".
+type LogOptions struct {
+	// The maximum number of commits to return.
+	MaxCount *uint
+
+	// Continue the history of a single file before it was renamed. When
+	// the file was added in a commit, it's looked for among the files
+	// that the commit deleted, and the history of that file is followed
+	// from the parent on.
+	Follow bool
+
+	// Walk every parent of the merges when the history is limited to
+	// paths, instead of simplifying it. See RevListOptions.FullHistory.
+	FullHistory bool
+
+	// Don't return any commit before all of its children, and keep the
+	// commits of each line of history together, as "git log --topo-order"
+	// does. The whole history has to be walked before the first commit is
+	// returned.
+	TopoOrder bool
+
+	// Leave out the commits with more than one parent.
+	NoMerges bool
+}
+
+// A commit waiting to be walked by Log, and the paths which are being
+// looked at in it.
+type logCommit struct {
+	Commit CommitID
+	Date   time.Time
+	Paths  []IndexPath
+}
+
+// Log calls callback for each commit reachable from revs, the most recent
+// by committer date first, the way "git log" walks history.
+//
+// If paths are given, only the commits which changed them are returned,
+// and the history is simplified as described in RevListOptions.Paths
+// unless opts.FullHistory is set. Exactly one path must be given with
+// opts.Follow.
+func Log(c *Client, opts LogOptions, revs []Commitish, paths []IndexPath, callback func(CommitID) error) error {
+	if opts.Follow && len(paths) != 1 {
+		return fmt.Errorf("--follow requires exactly one pathspec")
+	}
+	if opts.TopoOrder {
+		return logTopoOrder(c, opts, revs, paths, callback)
+	}
+
+	var queue []logCommit
+	seen := make(map[CommitID]struct{})
+	push := func(cmt CommitID, paths []IndexPath) error {
+		if _, ok := seen[cmt]; ok {
+			return nil
+		}
+		seen[cmt] = struct{}{}
+		date, err := cmt.GetCommitterDate(c)
+		if err != nil {
+			return err
+		}
+		// Commits with the same date are walked in the order that
+		// they were found.
+		i := len(queue)
+		for i > 0 && date.After(queue[i-1].Date) {
+			i--
+		}
+		queue = append(queue, logCommit{})
+		copy(queue[i+1:], queue[i:])
+		queue[i] = logCommit{cmt, date, paths}
+		return nil
+	}
+	for _, rev := range revs {
+		cmt, err := rev.CommitID(c)
+		if err != nil {
+			return err
+		}
+		if err := push(cmt, paths); err != nil {
+			return err
+		}
+	}
+
+	count := uint(0)
+	for len(queue) > 0 {
+		if opts.MaxCount != nil && count >= *opts.MaxCount {
+			return nil
+		}
+		next := queue[0]
+		queue = queue[1:]
+
+		parents, err := next.Commit.Parents(c)
+		if err != nil {
+			return err
+		}
+		merge := len(parents) > 1
+		changed := true
+		if len(next.Paths) > 0 {
+			parents, changed, err = simplifyParents(c, next.Commit, parents, next.Paths, opts.FullHistory)
+			if err != nil {
+				return err
+			}
+		}
+		for _, p := range parents {
+			paths := next.Paths
+			if opts.Follow && changed {
+				if paths, err = logFollow(c, next, p); err != nil {
+					return err
+				}
+			}
+			if err := push(p, paths); err != nil {
+				return err
+			}
+		}
+		if changed && !(merge && opts.NoMerges) {
+			if err := callback(next.Commit); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+	return nil
+}
+
+// Returns the path to follow in parent, which is the path that cmt renamed
+// its path from if it was added in cmt.
+func logFollow(c *Client, cmt logCommit, parent CommitID) ([]IndexPath, error) {
+	path := cmt.Paths[0]
+	tree, err := cmt.Commit.TreeID(c)
+	if err != nil {
+		return nil, err
+	}
+	ptree, err := parent.TreeID(c)
+	if err != nil {
+		return nil, err
+	}
+	_, ok, err := tree.lookupPath(c, path)
+	if err != nil {
+		return nil, err
+	}
+	_, pok, err := ptree.lookupPath(c, path)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || pok {
+		return cmt.Paths, nil
+	}
+
+	diffs, err := DiffTree(c, &DiffTreeOptions{Recurse: true, DiffRenameOptions: DiffRenameOptions{FindRenames: defaultSimilarity}}, parent, cmt.Commit, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range diffs {
+		if d.Name == path && d.SrcName != "" && !d.Copy {
+			return []IndexPath{d.SrcName}, nil
+		}
+	}
+	return cmt.Paths, nil
+}
+
+// Calls callback for the commits which Log returns in topological order.
+// As in git, the commits are taken from a stack which each commit's
+// parents are pushed to once all of their children are done, so that the
+// last parent of a merge is returned first.
+func logTopoOrder(c *Client, opts LogOptions, revs []Commitish, paths []IndexPath, callback func(CommitID) error) error {
+	walkOpts := opts
+	walkOpts.TopoOrder = false
+	walkOpts.MaxCount = nil
+
+	var commits []CommitID
+	// The number of children of each commit, plus 1 for the commits
+	// which are being returned.
+	indegree := make(map[CommitID]int)
+	if err := Log(c, walkOpts, revs, paths, func(cmt CommitID) error {
+		commits = append(commits, cmt)
+		indegree[cmt] = 1
+		return nil
+	}); err != nil {
+		return err
+	}
+	parents := make(map[CommitID][]CommitID)
+	for _, cmt := range commits {
+		ps, err := cmt.Parents(c)
+		if err != nil {
+			return err
+		}
+		parents[cmt] = ps
+		for _, p := range ps {
+			if indegree[p] > 0 {
+				indegree[p]++
+			}
+		}
+	}
+
+	var stack []CommitID
+	for i := len(commits) - 1; i >= 0; i-- {
+		if indegree[commits[i]] == 1 {
+			stack = append(stack, commits[i])
+		}
+	}
+	count := uint(0)
+	for len(stack) > 0 {
+		if opts.MaxCount != nil && count >= *opts.MaxCount {
+			return nil
+		}
+		cmt := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, p := range parents[cmt] {
+			if indegree[p] == 0 {
+				continue
+			}
+			indegree[p]--
+			if indegree[p] == 1 {
+				stack = append(stack, p)
+			}
+		}
+		if err := callback(cmt); err != nil {
+			return err
+		}
+		count++
+	}
+	return nil
+}
+
+// LogRaw returns the lines that "git log --raw" lists for cmt, which are
+// the changes to the files against its first parent, or against the empty
+// tree for a root commit, with the objects abbreviated. Renames are found
+// as they are by "git log".
+//
+// A merge has no lines unless combined is set, in which case they're the
+// files which it changed from every parent, as with "git log --raw -c".
+func LogRaw(c *Client, cmt CommitID, combined bool) ([]string, error) {
+	parents, err := cmt.Parents(c)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	if len(parents) > 1 {
+		if !combined {
+			return nil, nil
+		}
+		files, err := DiffTreeCombinedRaw(c, cmt)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			lines = append(lines, f.abbrevString(7))
+		}
+		return lines, nil
+	}
+
+	var parent Treeish
+	if len(parents) == 1 {
+		parent = parents[0]
+	}
+	diffs, err := DiffTree(c, &DiffTreeOptions{Recurse: true, DiffRenameOptions: DiffRenameOptions{FindRenames: defaultSimilarity}}, parent, cmt, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range diffs {
+		if d.Src.FileMode == ModeTree || d.Dst.FileMode == ModeTree {
+			continue
+		}
+		if d.SrcName != "" {
+			lines = append(lines, fmt.Sprintf("%v\t%v\t%v", d.abbrevRawPrefix(7), d.SrcName, d.Name))
+		} else {
+			lines = append(lines, fmt.Sprintf("%v\t%v", d.abbrevRawPrefix(7), d.Name))
+		}
+	}
+	return lines, nil
+}
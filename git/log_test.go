@@ -0,0 +1,257 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Tests that Log with Follow continues the history of a file before it
+// was renamed, and leaves out the commits which didn't change it.
+func TestLogFollow(t *testing.T) {
+	c, cleanup := testRepo(t, "gitlogfollow")
+	defer cleanup()
+
+	content := "first line of the file\nsecond line\nthird line\n"
+	c1 := testCommitFile(t, c, "a.txt", content, "add a")
+	testCommitFile(t, c, "other.txt", "other\n", "unrelated")
+	c3 := testCommitFile(t, c, "a.txt", content+"fourth line\n", "change a")
+	if _, err := Mv(c, MvOptions{}, []File{"a.txt"}, "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	c4, err := Commit(c, CommitOptions{}, "rename a to b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c5 := testCommitFile(t, c, "b.txt", content+"fourth line\nfifth line\n", "change b")
+
+	var got []CommitID
+	err = Log(c, LogOptions{Follow: true}, []Commitish{c5}, []IndexPath{"b.txt"}, func(cmt CommitID) error {
+		got = append(got, cmt)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CommitID{c5, c4, c3, c1}
+	if len(got) != len(want) {
+		t.Fatalf("Unexpected history of b.txt: got %v want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Commit %d: got %v want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// Tests that limiting Log and RevList to a path leaves out the commits
+// which didn't change it, and a merge which has the same version of it as
+// one of its parents unless FullHistory is set.
+func TestLogPaths(t *testing.T) {
+	c, cleanup := testRepo(t, "gitlogpaths")
+	defer cleanup()
+
+	testCommitFile(t, c, "g.txt", "1\n", "add g")
+	A := testCommitFile(t, c, "f.txt", "1\n", "add f")
+	B := testCommitFile(t, c, "g.txt", "2\n", "change g")
+	C := testCommitFile(t, c, "f.txt", "2\n", "change f")
+	// A merge of B into C which keeps C's version of both files, so it's
+	// TREESAME to C for f.txt.
+	tree, err := C.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	M, err := CommitTree(c, CommitTreeOptions{}, tree, []CommitID{C, B}, "merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		FullHistory bool
+		Want        []CommitID
+	}{
+		{false, []CommitID{C, A}},
+		{true, []CommitID{M, C, A}},
+	} {
+		var got []CommitID
+		err := Log(c, LogOptions{FullHistory: tc.FullHistory}, []Commitish{M}, []IndexPath{"f.txt"}, func(cmt CommitID) error {
+			got = append(got, cmt)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tc.Want) {
+			t.Fatalf("FullHistory %v: unexpected history of f.txt: got %v want %v", tc.FullHistory, got, tc.Want)
+		}
+		for i := range tc.Want {
+			if got[i] != tc.Want[i] {
+				t.Errorf("FullHistory %v: commit %d: got %v want %v", tc.FullHistory, i, got[i], tc.Want[i])
+			}
+		}
+
+		revs, err := RevList(c, RevListOptions{Quiet: true, Paths: []IndexPath{"f.txt"}, FullHistory: tc.FullHistory}, nil, []Commitish{M}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revs) != len(tc.Want) {
+			t.Errorf("FullHistory %v: unexpected rev-list of f.txt: got %v want %v", tc.FullHistory, revs, tc.Want)
+		}
+	}
+}
+
+// Tests that LogRaw lists the changes of a commit against its first parent,
+// and the files that a merge changed from all of its parents if combined
+// is set, and that NoMerges leaves the merge out of the log.
+func TestLogRaw(t *testing.T) {
+	c, cleanup := testRepo(t, "gitlograw")
+	defer cleanup()
+
+	testCommitFile(t, c, "a.txt", "a\n", "add a")
+	base := testCommitFile(t, c, "b.txt", "b\n", "add b")
+	testCommitFile(t, c, "a.txt", "side\n", "change a")
+	side := testCommitFile(t, c, "n.txt", "new\n", "add n")
+
+	if _, err := ResetMode(c, ResetOptions{Hard: true}, base); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("a.txt", []byte("master\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rm(c, RmOptions{}, []File{"b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	master, err := Commit(c, CommitOptions{}, "change a and remove b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A merge which takes n.txt from the side and changes a.txt from
+	// both.
+	for name, content := range map[string]string{"a.txt": "merged\n", "n.txt": "new\n"} {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Add(c, AddOptions{}, []File{"a.txt", "n.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := WriteTree(c, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merge, err := CommitTree(c, CommitTreeOptions{}, TreeID(tree), []CommitID{master, side}, "merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		commit   CommitID
+		combined bool
+		want     []string
+	}{
+		{master, false, []string{
+			":100644 100644 7898192 1f7391f M\ta.txt",
+			":100644 000000 6178079 0000000 D\tb.txt",
+		}},
+		{merge, false, nil},
+		{merge, true, []string{
+			"::100644 100644 100644 1f7391f 2299c37 20b117f MM\ta.txt",
+		}},
+	}
+	for i, tc := range tests {
+		got, err := LogRaw(c, tc.commit, tc.combined)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("Test %d: got %q want %q", i, got, tc.want)
+			continue
+		}
+		for j := range got {
+			if got[j] != tc.want[j] {
+				t.Errorf("Test %d line %d: got %q want %q", i, j, got[j], tc.want[j])
+			}
+		}
+	}
+
+	var commits []CommitID
+	if err := Log(c, LogOptions{NoMerges: true}, []Commitish{merge}, nil, func(cmt CommitID) error {
+		commits = append(commits, cmt)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmt := range commits {
+		if cmt == merge {
+			t.Error("Merge was not left out with NoMerges")
+		}
+	}
+	if len(commits) != 5 {
+		t.Errorf("Unexpected commits with NoMerges: %v", commits)
+	}
+}
+
+func TestLogEncoding(t *testing.T) {
+	c, cleanup := testRepo(t, "gitlogencoding")
+	defer cleanup()
+	// As in git, the locale doesn't change the encoding of the output.
+	defer os.Setenv("LC_ALL", os.Getenv("LC_ALL"))
+	os.Setenv("LC_ALL", "fr_FR.ISO-8859-1")
+
+	head := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+	tree, err := head.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A commit made with i18n.commitEncoding is labelled with the
+	// encoding, but the message isn't changed.
+	c.SetCachedConfig("i18n.commitEncoding", "ISO-8859-1")
+	latin1, err := CommitTree(c, CommitTreeOptions{}, tree, []CommitID{head}, "Caf\xe9 cr\xe8me\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := c.GetCommitObject(latin1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc := obj.GetHeader("encoding"); enc != "ISO-8859-1" {
+		t.Errorf("Unexpected encoding header: got %q want ISO-8859-1", enc)
+	}
+	if msg, err := latin1.GetCommitMessage(c); err != nil || msg != "Caf\xe9 cr\xe8me\n" {
+		t.Errorf("Unexpected raw message: got %q (%v)", msg, err)
+	}
+
+	for _, tc := range []struct {
+		commit, output string
+		cmt            CommitID
+		want           string
+	}{
+		// Messages are shown in UTF-8 by default.
+		{"", "", latin1, "    Café crème\n"},
+		{"", "", head, "    First commit\n"},
+
+		// They can be shown in another encoding, which is
+		// i18n.commitEncoding if i18n.logOutputEncoding isn't set.
+		{"", "ISO-8859-1", latin1, "    Caf\xe9 cr\xe8me\n"},
+		{"", "windows-1252", latin1, "    Caf\xe9 cr\xe8me\n"},
+		{"", "US-ASCII", latin1, "    Caf? cr?me\n"},
+		{"ISO-8859-1", "", latin1, "    Caf\xe9 cr\xe8me\n"},
+		{"ISO-8859-1", "UTF-8", latin1, "    Café crème\n"},
+	} {
+		c.SetCachedConfig("i18n.commitEncoding", tc.commit)
+		c.SetCachedConfig("i18n.logOutputEncoding", tc.output)
+		output, err := tc.cmt.FormatMedium(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output, tc.want) {
+			t.Errorf("Unexpected output for %v with encodings %q and %q: got %q want %q", tc.cmt, tc.commit, tc.output, output, tc.want)
+		}
+	}
+}
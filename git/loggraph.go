@@ -0,0 +1,564 @@
+package git
+
+import (
+	"strings"
+)
+
+// The states that a LogGraph goes through while drawing the lines for a
+// commit.
+type logGraphState int
+
+const (
+	// Drawing the branch lines unchanged, after the commit is done.
+	logGraphPadding logGraphState = iota
+	// The previous commit wasn't finished, so "..." is drawn.
+	logGraphSkip
+	// Making room around a merge with more than 2 parents.
+	logGraphPreCommit
+	// The line with the commit's own "*".
+	logGraphCommit
+	// The line below a merge with the edges to its parents.
+	logGraphPostMerge
+	// Moving the branch lines to the left into their new columns.
+	logGraphCollapsing
+)
+
+// LogGraph draws the history walked by Log as ASCII art next to each
+// commit, the way that "git log --graph" does. It keeps track of which
+// column each branch line is in from one commit to the next, so it must be
+// given every commit that's shown in order with Update.
+//
+// The drawing is the same as git's, so that a commit with more lines than
+// its graph needs is padded with the branch lines, and the lines for a
+// merge or for branch lines moving to the left are drawn next to the
+// commit's text.
+type LogGraph struct {
+	commit  CommitID
+	parents []CommitID
+
+	state, prevState logGraphState
+
+	// The width of the graph for the current commit.
+	width int
+	// The number of pre-commit lines drawn so far.
+	expansionRow int
+
+	commitIndex, prevCommitIndex int
+	// 0 if the merge's first parent is to its left, or 1 if it's below
+	// the merge, which changes how the edges are drawn.
+	mergeLayout int
+
+	// The number of columns added to the right of the commit by a merge,
+	// or -1 if an edge joins the column to its right immediately.
+	edgesAdded, prevEdgesAdded int
+
+	// The commits which are expected in each column, before and after
+	// the current commit.
+	columns, newColumns []CommitID
+
+	// For each character of the graph's width, the index in newColumns
+	// of the branch line which is drawn there, or -1.
+	mapping, oldMapping []int
+}
+
+// NewLogGraph returns a LogGraph which hasn't drawn any commits.
+func NewLogGraph() *LogGraph {
+	return &LogGraph{}
+}
+
+// Update moves the graph on to the next commit, which has the parents
+// given. The lines up to the commit are drawn by the next call to Show.
+func (g *LogGraph) Update(cmt CommitID, parents []CommitID) {
+	g.commit = cmt
+	g.parents = parents
+	g.prevCommitIndex = g.commitIndex
+	g.updateColumns()
+	g.expansionRow = 0
+
+	if g.state != logGraphPadding {
+		g.state = logGraphSkip
+	} else if g.needsPreCommitLine() {
+		g.state = logGraphPreCommit
+	} else {
+		g.state = logGraphCommit
+	}
+}
+
+// Padding returns a line of the graph which doesn't change the branch
+// lines, which is what's drawn in front of the blank line between two
+// commits. It needs to be called after Update, since the blank line
+// belongs to the commit which is about to be shown.
+func (g *LogGraph) Padding() string {
+	if g.state != logGraphCommit {
+		line, _ := g.nextLine()
+		return line
+	}
+	var line strings.Builder
+	for _, col := range g.columns {
+		line.WriteByte('|')
+		if col == g.commit && len(g.parents) > 2 {
+			line.WriteString(strings.Repeat(" ", (len(g.parents)-2)*2))
+		} else {
+			line.WriteByte(' ')
+		}
+	}
+	g.prevState = logGraphPadding
+	return g.pad(line.String())
+}
+
+// Show returns text, which is the output for the commit that was passed to
+// Update, with the graph drawn in front of each of its lines. Its first
+// line goes next to the commit's "*", and if the graph needs more lines
+// than text has they're added after it.
+func (g *LogGraph) Show(text string) string {
+	var out strings.Builder
+	for {
+		line, shown := g.nextLine()
+		out.WriteString(line)
+		if shown {
+			break
+		}
+		out.WriteByte('\n')
+	}
+
+	terminated := strings.HasSuffix(text, "\n")
+	for len(text) > 0 {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			out.WriteString(text)
+			break
+		}
+		out.WriteString(text[:i+1])
+		text = text[i+1:]
+		if text != "" {
+			line, _ := g.nextLine()
+			out.WriteString(line)
+		}
+	}
+
+	if g.state != logGraphPadding {
+		if !terminated {
+			out.WriteByte('\n')
+		}
+		for {
+			line, _ := g.nextLine()
+			out.WriteString(line)
+			if g.state == logGraphPadding {
+				break
+			}
+			out.WriteByte('\n')
+		}
+		if terminated {
+			out.WriteByte('\n')
+		}
+	}
+	return out.String()
+}
+
+// Returns the next line of the graph, and whether it was the line with the
+// commit on it.
+func (g *LogGraph) nextLine() (string, bool) {
+	var line string
+	switch g.state {
+	case logGraphPadding:
+		line = g.paddingLine()
+	case logGraphSkip:
+		line = g.skipLine()
+	case logGraphPreCommit:
+		line = g.preCommitLine()
+	case logGraphCommit:
+		return g.pad(g.commitLine()), true
+	case logGraphPostMerge:
+		line = g.postMergeLine()
+	case logGraphCollapsing:
+		line = g.collapsingLine()
+	}
+	return g.pad(line), false
+}
+
+// Adds spaces to line up to the width of the graph, so that the text next
+// to the graph stays aligned for the whole commit.
+func (g *LogGraph) pad(line string) string {
+	if len(line) < g.width {
+		return line + strings.Repeat(" ", g.width-len(line))
+	}
+	return line
+}
+
+func (g *LogGraph) setState(s logGraphState) {
+	g.prevState = g.state
+	g.state = s
+}
+
+// The number of parents of a merge which get a "-" on the commit line.
+func (g *LogGraph) dashedParents() int {
+	return len(g.parents) + g.mergeLayout - 3
+}
+
+func (g *LogGraph) needsPreCommitLine() bool {
+	return len(g.parents) >= 3 &&
+		g.commitIndex < len(g.columns)-1 &&
+		g.expansionRow < g.dashedParents()*2
+}
+
+// Returns the index of cmt in newColumns, or -1.
+func (g *LogGraph) findNewColumn(cmt CommitID) int {
+	for i, col := range g.newColumns {
+		if col == cmt {
+			return i
+		}
+	}
+	return -1
+}
+
+// Works out the columns after the current commit, which has replaced
+// itself with its parents, and where each of them is drawn on the line
+// after the commit.
+func (g *LogGraph) updateColumns() {
+	g.columns = g.newColumns
+	g.newColumns = nil
+
+	g.mapping = make([]int, 2*(len(g.columns)+len(g.parents)))
+	for i := range g.mapping {
+		g.mapping[i] = -1
+	}
+	g.width = 0
+	g.prevEdgesAdded = g.edgesAdded
+	g.edgesAdded = 0
+
+	seen := false
+	for i := 0; i <= len(g.columns); i++ {
+		var col CommitID
+		if i == len(g.columns) {
+			if seen {
+				break
+			}
+			col = g.commit
+		} else {
+			col = g.columns[i]
+		}
+
+		if col == g.commit {
+			seen = true
+			g.commitIndex = i
+			g.mergeLayout = -1
+			for _, p := range g.parents {
+				g.insertNewColumn(p, i)
+			}
+			// The commit always takes up at least 2 characters.
+			if len(g.parents) == 0 {
+				g.width += 2
+			}
+		} else {
+			g.insertNewColumn(col, -1)
+		}
+	}
+
+	for len(g.mapping) > 1 && g.mapping[len(g.mapping)-1] < 0 {
+		g.mapping = g.mapping[:len(g.mapping)-1]
+	}
+}
+
+// Adds cmt to newColumns if it isn't already there, and maps the next
+// position in the graph to it. idx is the column of the current commit if
+// cmt is one of its parents, or -1.
+func (g *LogGraph) insertNewColumn(cmt CommitID, idx int) {
+	i := g.findNewColumn(cmt)
+	if i < 0 {
+		i = len(g.newColumns)
+		g.newColumns = append(g.newColumns, cmt)
+	}
+
+	var mappingIdx int
+	if len(g.parents) > 1 && idx > -1 && g.mergeLayout == -1 {
+		// The first parent of a merge decides how the edges are
+		// drawn, depending on whether it's to the left of the merge.
+		dist := idx - i
+		shift := 1
+		if dist > 1 {
+			shift = 2*dist - 3
+		}
+		if dist > 0 {
+			g.mergeLayout = 0
+		} else {
+			g.mergeLayout = 1
+		}
+		g.edgesAdded = len(g.parents) + g.mergeLayout - 2
+		mappingIdx = g.width + (g.mergeLayout-1)*shift
+		g.width += 2 * g.mergeLayout
+	} else if g.edgesAdded > 0 && g.width >= 2 && i == g.mapping[g.width-2] {
+		// The parent is in the column that the merge's edge goes
+		// to, so the two are joined straight away.
+		mappingIdx = g.width - 2
+		g.edgesAdded = -1
+	} else {
+		mappingIdx = g.width
+		g.width += 2
+	}
+	g.mapping[mappingIdx] = i
+}
+
+// Reports whether every branch line is in its column, or one to the right
+// of it so that the "/" drawn moves it there.
+func (g *LogGraph) mappingCorrect() bool {
+	for i, target := range g.mapping {
+		if target >= 0 && target != i/2 {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *LogGraph) paddingLine() string {
+	var line strings.Builder
+	for range g.newColumns {
+		line.WriteString("| ")
+	}
+	return line.String()
+}
+
+func (g *LogGraph) skipLine() string {
+	if g.needsPreCommitLine() {
+		g.setState(logGraphPreCommit)
+	} else {
+		g.setState(logGraphCommit)
+	}
+	return "..."
+}
+
+// Widens the space around a merge with more than 2 parents, 2 lines for
+// each parent over 2.
+func (g *LogGraph) preCommitLine() string {
+	var line strings.Builder
+	seen := false
+	for i, col := range g.columns {
+		switch {
+		case col == g.commit:
+			seen = true
+			line.WriteByte('|')
+			line.WriteString(strings.Repeat(" ", g.expansionRow))
+		case seen && g.expansionRow == 0:
+			// Continue the "\" from the post-merge line of the
+			// previous commit.
+			if g.prevState == logGraphPostMerge && g.prevCommitIndex < i {
+				line.WriteByte('\\')
+			} else {
+				line.WriteByte('|')
+			}
+		case seen && g.expansionRow > 0:
+			line.WriteByte('\\')
+		default:
+			line.WriteByte('|')
+		}
+		line.WriteByte(' ')
+	}
+
+	g.expansionRow++
+	if !g.needsPreCommitLine() {
+		g.setState(logGraphCommit)
+	}
+	return line.String()
+}
+
+func (g *LogGraph) commitLine() string {
+	var line strings.Builder
+	seen := false
+	for i := 0; i <= len(g.columns); i++ {
+		var col CommitID
+		if i == len(g.columns) {
+			if seen {
+				break
+			}
+			col = g.commit
+		} else {
+			col = g.columns[i]
+		}
+
+		switch {
+		case col == g.commit:
+			seen = true
+			line.WriteByte('*')
+			if len(g.parents) > 2 {
+				n := g.dashedParents()
+				line.WriteString(strings.Repeat("-", 2*n-1))
+				line.WriteByte('.')
+			}
+		case seen && g.edgesAdded > 1:
+			line.WriteByte('\\')
+		case seen && g.edgesAdded == 1:
+			// Continue the "\" from the post-merge line of the
+			// previous commit, since there's no pre-commit line.
+			if g.prevState == logGraphPostMerge && g.prevEdgesAdded > 0 && g.prevCommitIndex < i {
+				line.WriteByte('\\')
+			} else {
+				line.WriteByte('|')
+			}
+		case g.prevState == logGraphCollapsing && 2*i+1 < len(g.oldMapping) &&
+			g.oldMapping[2*i+1] == i && 2*i < len(g.mapping) && g.mapping[2*i] < i:
+			line.WriteByte('/')
+		default:
+			line.WriteByte('|')
+		}
+		line.WriteByte(' ')
+	}
+
+	if len(g.parents) > 1 {
+		g.setState(logGraphPostMerge)
+	} else if g.mappingCorrect() {
+		g.setState(logGraphPadding)
+	} else {
+		g.setState(logGraphCollapsing)
+	}
+	return line.String()
+}
+
+// Draws the edges from a merge to each of its parents.
+func (g *LogGraph) postMergeLine() string {
+	mergeChars := []byte{'/', '|', '\\'}
+
+	var line strings.Builder
+	seen := false
+	// Whether the first parent's column has been passed, so that the
+	// gap up to the merge is drawn with "_".
+	parentSeen := false
+	for i := 0; i <= len(g.columns); i++ {
+		var col CommitID
+		if i == len(g.columns) {
+			if seen {
+				break
+			}
+			col = g.commit
+		} else {
+			col = g.columns[i]
+		}
+
+		switch {
+		case col == g.commit:
+			seen = true
+			idx := g.mergeLayout
+			for j := range g.parents {
+				line.WriteByte(mergeChars[idx])
+				if idx == 2 {
+					if g.edgesAdded > 0 || j < len(g.parents)-1 {
+						line.WriteByte(' ')
+					}
+				} else {
+					idx++
+				}
+			}
+			if g.edgesAdded == 0 {
+				line.WriteByte(' ')
+			}
+		case seen:
+			if g.edgesAdded > 0 {
+				line.WriteByte('\\')
+			} else {
+				line.WriteByte('|')
+			}
+			line.WriteByte(' ')
+		default:
+			line.WriteByte('|')
+			if g.mergeLayout != 0 || i != g.commitIndex-1 {
+				if parentSeen {
+					line.WriteByte('_')
+				} else {
+					line.WriteByte(' ')
+				}
+			}
+		}
+
+		if col == g.parents[0] {
+			parentSeen = true
+		}
+	}
+
+	if g.mappingCorrect() {
+		g.setState(logGraphPadding)
+	} else {
+		g.setState(logGraphCollapsing)
+	}
+	return line.String()
+}
+
+// Moves each branch line which isn't in its column one to the left. Only
+// one of them moves horizontally with "_" at a time, so that lines which
+// cross each other stay readable.
+func (g *LogGraph) collapsingLine() string {
+	g.mapping, g.oldMapping = g.oldMapping, g.mapping
+	if len(g.mapping) < len(g.oldMapping) {
+		g.mapping = make([]int, len(g.oldMapping))
+	}
+	g.mapping = g.mapping[:len(g.oldMapping)]
+	for i := range g.mapping {
+		g.mapping[i] = -1
+	}
+
+	horizontalEdge, horizontalTarget := -1, -1
+	for i, target := range g.oldMapping {
+		if target < 0 {
+			continue
+		}
+		switch {
+		case target*2 == i:
+			// Already in the right place.
+			g.mapping[i] = target
+		case g.mapping[i-1] < 0:
+			// Nothing to the left, so move left by one.
+			g.mapping[i-1] = target
+			if horizontalEdge == -1 {
+				horizontalEdge = i
+				horizontalTarget = target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		case g.mapping[i-1] == target:
+			// Joins the branch line to the left, which goes to
+			// the same commit.
+		default:
+			// Crosses over the branch line to the left.
+			g.mapping[i-2] = target
+			if horizontalEdge == -1 {
+				horizontalTarget = target
+				horizontalEdge = i - 1
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		}
+	}
+
+	g.oldMapping = append(g.oldMapping[:0], g.mapping...)
+	if g.mapping[len(g.mapping)-1] < 0 {
+		g.mapping = g.mapping[:len(g.mapping)-1]
+	}
+
+	var line strings.Builder
+	usedHorizontal := false
+	for i, target := range g.mapping {
+		switch {
+		case target < 0:
+			line.WriteByte(' ')
+		case target*2 == i:
+			line.WriteByte('|')
+		case target == horizontalTarget && i != horizontalEdge-1:
+			// Only the first segment of the horizontal edge
+			// continues to the next line.
+			if i != target*2+3 {
+				g.mapping[i] = -1
+			}
+			usedHorizontal = true
+			line.WriteByte('_')
+		default:
+			if usedHorizontal && i < horizontalEdge {
+				g.mapping[i] = -1
+			}
+			line.WriteByte('/')
+		}
+	}
+
+	if g.mappingCorrect() {
+		g.setState(logGraphPadding)
+	}
+	return line.String()
+}
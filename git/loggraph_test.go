@@ -0,0 +1,90 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests that LogGraph draws the branch lines of a small history with one
+// merge the way git log --graph does, next to commits with one line and
+// with several.
+func TestLogGraph(t *testing.T) {
+	c, cleanup := testRepo(t, "gitloggraph")
+	defer cleanup()
+
+	// Creates a history that looks like
+	//
+	//   B
+	//  / \
+	// A   M---T
+	//  \ /
+	//   C
+	A := testCommitFile(t, c, "foo.txt", "foo\n", "A")
+	tree, err := A.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(msg string, parents ...CommitID) CommitID {
+		t.Helper()
+		cmt, err := CommitTree(c, CommitTreeOptions{}, tree, parents, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmt
+	}
+	B := commit("B", A)
+	C := commit("C", A)
+	M := commit("M", B, C)
+	T := commit("T", M)
+
+	subjects := map[CommitID]string{A: "A", B: "B", C: "C", M: "M", T: "T"}
+	draw := func(text func(CommitID) string) string {
+		t.Helper()
+		var out strings.Builder
+		g := NewLogGraph()
+		err := Log(c, LogOptions{TopoOrder: true}, []Commitish{T}, nil, func(cmt CommitID) error {
+			parents, err := cmt.Parents(c)
+			if err != nil {
+				return err
+			}
+			g.Update(cmt, parents)
+			out.WriteString(g.Show(text(cmt)))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	got := draw(func(cmt CommitID) string { return subjects[cmt] + "\n" })
+	want := `* T
+*   M
+|\  
+| * C
+* | B
+|/  
+* A
+`
+	if got != want {
+		t.Errorf("Unexpected graph:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The lines after a merge are drawn next to the text of a commit
+	// with more than one line, and the graph continues through the rest.
+	got = draw(func(cmt CommitID) string { return subjects[cmt] + "\nbody\n" })
+	want = `* T
+| body
+*   M
+|\  body
+| * C
+| | body
+* | B
+|/  body
+* A
+  body
+`
+	if got != want {
+		t.Errorf("Unexpected graph:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
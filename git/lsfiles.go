@@ -2,6 +2,7 @@ package git
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"path"
@@ -12,10 +13,14 @@ import (
 
 // Finds things that aren't tracked, and creates fake IndexEntrys for them to be merged into
 // the output if --others is passed.
-func findUntrackedFilesFromDir(c *Client, opts LsFilesOptions, root, parent, dir File, tracked map[IndexPath]bool, recursedir bool, ignorePatterns []IgnorePattern) (untracked []*IndexEntry) {
-	files, err := ioutil.ReadDir(dir.String())
-	if err != nil {
-		return nil
+//
+// If node is not nil, the untracked files found are recorded in it for the
+// untracked cache, and old is the untracked cache of the directory from
+// the last time that it was read (or nil.) The directory is only read
+// again if it's changed since then.
+func findUntrackedFilesFromDir(c *Client, opts LsFilesOptions, root, parent, dir File, tracked map[IndexPath]bool, recursedir bool, ignorePatterns []IgnorePattern, old, node *untrackedDir) (untracked []*IndexEntry) {
+	if node != nil {
+		node.stat = statUntracked(dir)
 	}
 	for _, ignorefile := range opts.ExcludePerDirectory {
 		ignoreInDir := ignorefile
@@ -23,6 +28,15 @@ func findUntrackedFilesFromDir(c *Client, opts LsFilesOptions, root, parent, dir
 			ignoreInDir = dir + "/" + ignorefile
 		}
 
+		if node != nil {
+			node.exclude = hashExcludeFile(ignoreInDir)
+			if old != nil && old.exclude != node.exclude {
+				// The ignore patterns changed, so nothing
+				// cached in or below this directory can
+				// be trusted.
+				old = nil
+			}
+		}
 		if ignoreInDir.Exists() {
 			log.Println("Adding excludes from", ignoreInDir)
 
@@ -33,6 +47,16 @@ func findUntrackedFilesFromDir(c *Client, opts LsFilesOptions, root, parent, dir
 			ignorePatterns = append(ignorePatterns, patterns...)
 		}
 	}
+	if node != nil && old.usable(node.stat) {
+		return findCachedUntrackedFiles(c, opts, root, parent, dir, tracked, recursedir, ignorePatterns, old, node)
+	}
+	files, err := ioutil.ReadDir(dir.String())
+	if err != nil {
+		return nil
+	}
+	if node != nil {
+		node.valid = !untrackedStatRacy(node.stat)
+	}
 files:
 	for _, fi := range files {
 		fname := File(fi.Name())
@@ -75,6 +99,9 @@ files:
 						indexPath += "/"
 					}
 					untracked = append(untracked, &IndexEntry{PathName: indexPath})
+					if node != nil {
+						node.untracked = append(node.untracked, fi.Name()+"/")
+					}
 					continue
 				}
 			}
@@ -90,7 +117,12 @@ files:
 				newdir = dir + "/" + fname
 			}
 
-			recurseFiles := findUntrackedFilesFromDir(c, opts, root, newparent, newdir, tracked, recursedir, ignorePatterns)
+			var newnode *untrackedDir
+			if node != nil {
+				newnode = &untrackedDir{name: fi.Name()}
+				node.dirs = append(node.dirs, newnode)
+			}
+			recurseFiles := findUntrackedFilesFromDir(c, opts, root, newparent, newdir, tracked, recursedir, ignorePatterns, old.subdir(fi.Name()), newnode)
 			untracked = append(untracked, recurseFiles...)
 		} else {
 			var filePath File
@@ -108,6 +140,9 @@ files:
 
 			if _, ok := tracked[indexPath]; !ok {
 				untracked = append(untracked, &IndexEntry{PathName: indexPath})
+				if node != nil {
+					node.untracked = append(node.untracked, fi.Name())
+				}
 			}
 		}
 	}
@@ -156,6 +191,10 @@ type LsFilesOptions struct {
 
 	// Equivalent to the -t option to git ls-files
 	Status bool
+
+	// Show the sparse directory entries of a sparse index, instead of
+	// the files in them.
+	Sparse bool
 }
 
 type LsFilesResult struct {
@@ -163,11 +202,62 @@ type LsFilesResult struct {
 	StatusCode rune
 }
 
+// LsFilesOutput is the output of git ls-files for the files returned by
+// LsFiles. There's no porcelain format for ls-files, so OutputHuman and
+// OutputPorcelain render the same way.
+type LsFilesOutput struct {
+	// Show the status code of each file, as with -t.
+	Status bool
+
+	// Show the mode, object and stage of each file, as with --stage.
+	Stage bool
+
+	Files []LsFilesResult
+
+	// The name to display for each of Files, relative to the current
+	// directory.
+	Names []File
+}
+
+// NewLsFilesOutput returns the output of git ls-files with options opts for
+// files, which were returned by LsFiles with the same options.
+func NewLsFilesOutput(c *Client, opts LsFilesOptions, files []LsFilesResult) (LsFilesOutput, error) {
+	names := make([]File, len(files))
+	for i, file := range files {
+		path, err := file.PathName.FilePath(c)
+		if err != nil {
+			return LsFilesOutput{}, err
+		}
+		if opts.Directory && !opts.Stage && path.IsDir() || file.IsSparseDir() {
+			path += "/"
+		}
+		names[i] = path
+	}
+	return LsFilesOutput{Status: opts.Status, Stage: opts.Stage, Files: files, Names: names}, nil
+}
+
+// Renders the files in the given format.
+func (o LsFilesOutput) Render(w io.Writer, format OutputFormat) error {
+	for i, file := range o.Files {
+		var line string
+		if o.Status {
+			line = fmt.Sprintf("%c ", file.StatusCode)
+		}
+		if o.Stage {
+			line += fmt.Sprintf("%o %v %v\t", file.Mode, file.Sha1, file.Stage())
+		}
+		if _, err := fmt.Fprint(w, line, format.Path(o.Names[i].String()), format.Terminator()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // LsFiles implements the git ls-files command. It returns an array of files
 // that match the options passed.
 func LsFiles(c *Client, opt LsFilesOptions, files []File) ([]LsFilesResult, error) {
 	var fs []LsFilesResult
-	index, err := c.GitDir.ReadIndex()
+	index, err := c.GetIndex()
 	if err != nil {
 		return nil, err
 	}
@@ -179,7 +269,14 @@ func LsFiles(c *Client, opt LsFilesOptions, files []File) ([]LsFilesResult, erro
 		filesInIndex = make(map[IndexPath]bool)
 	}
 
-	for _, entry := range index.Objects {
+	entries := index.Objects
+	if index.IsSparse() && !opt.Sparse {
+		if entries, err = expandSparseDirs(c, entries, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range entries {
 		f, err := entry.PathName.FilePath(c)
 		if err != nil {
 			return nil, err
@@ -260,6 +357,11 @@ func LsFiles(c *Client, opt LsFilesOptions, files []File) ([]LsFilesResult, erro
 			}
 			continue
 		}
+		if entry.SkipWorktree() {
+			// Files which aren't checked out are never deleted or
+			// modified.
+			continue
+		}
 		if opt.Deleted {
 			if !f.Exists() {
 				fs = append(fs, LsFilesResult{entry, 'R'})
@@ -318,6 +420,17 @@ func LsFiles(c *Client, opt LsFilesOptions, files []File) ([]LsFilesResult, erro
 
 		ignorePatterns := []IgnorePattern{}
 
+		// The untracked cache can only be used with the standard
+		// excludes, since that's all that it keeps track of.
+		var uc *untrackedCache
+		if opt.ExcludeStandard && len(opt.ExcludeFiles) == 0 && len(opt.ExcludePatterns) == 0 && len(opt.ExcludePerDirectory) == 0 && !opt.NoEmptyDirectory {
+			var flags uint32
+			if opt.Directory {
+				flags |= untrackedShowOtherDirectories
+			}
+			uc = loadUntrackedCache(c, index, flags)
+		}
+
 		if opt.ExcludeStandard {
 			opt.ExcludeFiles = append(opt.ExcludeFiles, File(filepath.Join(c.GitDir.String(), "info/exclude")))
 			opt.ExcludePerDirectory = append(opt.ExcludePerDirectory, ".gitignore")
@@ -335,7 +448,15 @@ func LsFiles(c *Client, opt LsFilesOptions, files []File) ([]LsFilesResult, erro
 			ignorePatterns = append(ignorePatterns, IgnorePattern{Pattern: pattern, Source: "", LineNum: 1, Scope: ""})
 		}
 
-		others := findUntrackedFilesFromDir(c, opt, wd+"/", wd, wd, filesInIndex, !opt.Directory, ignorePatterns)
+		var others []*IndexEntry
+		if uc != nil {
+			root := &untrackedDir{}
+			others = findUntrackedFilesFromDir(c, opt, wd+"/", wd, wd, filesInIndex, !opt.Directory, ignorePatterns, uc.root, root)
+			uc.root = root
+			saveUntrackedCache(c, index, uc)
+		} else {
+			others = findUntrackedFilesFromDir(c, opt, wd+"/", wd, wd, filesInIndex, !opt.Directory, ignorePatterns, nil, nil)
+		}
 		for _, file := range others {
 			f, err := file.PathName.FilePath(c)
 			if err != nil {
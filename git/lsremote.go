@@ -3,6 +3,7 @@ package git
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 type LsRemoteOptions struct {
@@ -17,6 +18,9 @@ type LsRemoteOptions struct {
 	ServerOptions []string
 }
 
+// LsRemote connects to the remote r and returns the refs that it advertises
+// matching patterns, without fetching any objects, as "git ls-remote". Peeled
+// annotated tags are returned with a "^{}" suffix unless RefsOnly is set.
 func LsRemote(c *Client, opts LsRemoteOptions, r Remote, patterns []string) ([]Ref, error) {
 	if r == "" {
 		r = "origin"
@@ -41,5 +45,17 @@ func LsRemote(c *Client, opts LsRemoteOptions, r Remote, patterns []string) ([]R
 		return nil, err
 	}
 	defer remoteconn.Close()
-	return remoteconn.GetRefs(opts, patterns)
+	refs, err := remoteconn.GetRefs(opts, patterns)
+	if err != nil || !opts.RefsOnly {
+		return refs, err
+	}
+	// Version 2 of the protocol doesn't have a way to ask for only
+	// refs, so pseudorefs such as HEAD need to be filtered out here.
+	filtered := refs[:0]
+	for _, r := range refs {
+		if strings.HasPrefix(r.Name, "refs/") {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered, nil
 }
@@ -0,0 +1,106 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Tests ls-remote against a smart HTTP server which advertises its refs with
+// protocol version 1.
+func TestLsRemoteHTTP(t *testing.T) {
+	const (
+		master = "8a3f2cc2f7a5c7e5b5e2a3d4e6b3f3d1de40f8a1"
+		topic  = "0b2e1c0c1e8c4c0c5b4e1f2a9d9c7e8f6a5b4c3d"
+		tag    = "3f1b7e8a4c2d6e9f0a1b2c3d4e5f60718293a4b5"
+	)
+	advertisement := []string{
+		"# service=git-upload-pack\n",
+		"",
+		master + " HEAD\000multi_ack side-band-64k ofs-delta symref=HEAD:refs/heads/master agent=git/2.30.0\n",
+		master + " refs/heads/master\n",
+		topic + " refs/heads/topic\n",
+		tag + " refs/tags/v1.0\n",
+		master + " refs/tags/v1.0^{}\n",
+		"",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repo.git/info/refs" || r.URL.Query().Get("service") != "git-upload-pack" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		for _, line := range advertisement {
+			if line == "" {
+				fmt.Fprint(w, "0000")
+				continue
+			}
+			fmt.Fprintf(w, "%04x%s", len(line)+4, line)
+		}
+	}))
+	defer ts.Close()
+
+	c, cleanup := testRepo(t, "gitlsremote")
+	defer cleanup()
+
+	tests := []struct {
+		opts     LsRemoteOptions
+		patterns []string
+		want     []string
+	}{
+		{
+			LsRemoteOptions{}, nil,
+			[]string{master + " HEAD", master + " refs/heads/master", topic + " refs/heads/topic", tag + " refs/tags/v1.0", master + " refs/tags/v1.0^{}"},
+		},
+		{
+			LsRemoteOptions{Heads: true}, nil,
+			[]string{master + " refs/heads/master", topic + " refs/heads/topic"},
+		},
+		{
+			LsRemoteOptions{Tags: true}, nil,
+			[]string{tag + " refs/tags/v1.0", master + " refs/tags/v1.0^{}"},
+		},
+		{
+			LsRemoteOptions{Tags: true, RefsOnly: true}, nil,
+			[]string{tag + " refs/tags/v1.0"},
+		},
+		{
+			LsRemoteOptions{}, []string{"v1.0", "topic"},
+			[]string{topic + " refs/heads/topic", tag + " refs/tags/v1.0", master + " refs/tags/v1.0^{}"},
+		},
+	}
+	for i, tc := range tests {
+		refs, err := LsRemote(c, tc.opts, Remote(ts.URL+"/repo"), tc.patterns)
+		if err != nil {
+			t.Errorf("Test %d: %v", i, err)
+			continue
+		}
+		if len(refs) != len(tc.want) {
+			t.Errorf("Test %d: got %v want %v", i, refs, tc.want)
+			continue
+		}
+		for j, ref := range refs {
+			if got := ref.Value.String() + " " + ref.Name; got != tc.want[j] {
+				t.Errorf("Test %d ref %d: got %v want %v", i, j, got, tc.want[j])
+			}
+		}
+	}
+}
+
+func TestParseLsRef(t *testing.T) {
+	refs, err := parseLsRef("3f1b7e8a4c2d6e9f0a1b2c3d4e5f60718293a4b5 refs/tags/v1.0 peeled:8a3f2cc2f7a5c7e5b5e2a3d4e6b3f3d1de40f8a1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].Name != "refs/tags/v1.0" || refs[1].Name != "refs/tags/v1.0^{}" || refs[1].Value.String() != "8a3f2cc2f7a5c7e5b5e2a3d4e6b3f3d1de40f8a1" {
+		t.Errorf("Unexpected peeled tag: %v", refs)
+	}
+	refs, err = parseLsRef("8a3f2cc2f7a5c7e5b5e2a3d4e6b3f3d1de40f8a1 HEAD symref-target:refs/heads/master\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Name != "HEAD" {
+		t.Errorf("Unexpected symbolic ref: %v", refs)
+	}
+}
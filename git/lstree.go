@@ -4,7 +4,6 @@ import (
 	//	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 )
 
@@ -29,7 +28,7 @@ type LsTreeOptions struct {
 }
 
 // Implements the git ls-tree subcommand. This will return a list of
-// index entries in tree that match paths
+// index entries in tree that match paths, in the order of the tree.
 func LsTree(c *Client, opts LsTreeOptions, tree Treeish, paths []File) ([]*IndexEntry, error) {
 	// This is a really inefficient implementation, but the output (seems to)
 	// match the official git client in most cases.
@@ -65,7 +64,7 @@ func LsTree(c *Client, opts LsTreeOptions, tree Treeish, paths []File) ([]*Index
 	}
 
 	// Find all the subtrees that exist anywhere.
-	allentries, err := expandGitTreeIntoIndexes(c, tree, true, true, true)
+	allentries, err := treeIndexEntries(c, tree, true, true, true)
 	if err != nil {
 		return nil, err
 	}
@@ -142,17 +141,13 @@ func LsTree(c *Client, opts LsTreeOptions, tree Treeish, paths []File) ([]*Index
 		}
 	}
 
-	// Finally, sort them and remove duplicates, since we were lazy above
-	sort.Sort(ByPath(vals))
-	// We're also lazy here, but we already conceded that this implementation
-	// was inefficient a long time ago..
+	// Finally, remove duplicates, since we were lazy above. The entries
+	// were found in the order of the tree, so they're still in it.
+	seen := make(map[IndexPath]bool)
 	retvals := make([]*IndexEntry, 0, len(vals))
 	for _, v := range vals {
-		if len(retvals) == 0 {
-			retvals = append(retvals, v)
-			continue
-		}
-		if v.PathName != retvals[len(retvals)-1].PathName {
+		if !seen[v.PathName] {
+			seen[v.PathName] = true
 			retvals = append(retvals, v)
 		}
 	}
@@ -160,7 +155,7 @@ func LsTree(c *Client, opts LsTreeOptions, tree Treeish, paths []File) ([]*Index
 }
 
 func lsTree(c *Client, opts LsTreeOptions, tree Treeish, prefix string, paths []File) ([]*IndexEntry, error) {
-	entries, err := expandGitTreeIntoIndexes(c, tree, opts.Recurse, opts.ShowTrees, true)
+	entries, err := treeIndexEntries(c, tree, opts.Recurse, opts.ShowTrees, true)
 	if err != nil {
 		return nil, err
 	}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -17,3 +18,53 @@ func TestMain(m *testing.M) {
 	code := m.Run()
 	os.Exit(code)
 }
+
+// Creates a new repository in a temporary directory named after prefix
+// and changes to it. The environment variables used by CommitTree are
+// also set to known values for repeatable tests. The returned function
+// removes the repository.
+func testRepo(t testing.TB, prefix string) (*Client, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	os.Setenv("GIT_COMMITTER_NAME", "John Smith")
+	os.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	os.Setenv("GIT_AUTHOR_NAME", "John Smith")
+	os.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	os.Setenv("GIT_COMMITTER_DATE", "Mon, 02 Jan 2006 15:04:05 -0700")
+	os.Setenv("GIT_AUTHOR_DATE", "Mon, 02 Jan 2006 15:04:05 -0700")
+	return c, func() { os.RemoveAll(dir) }
+}
+
+// Writes content to the file named name in the current directory, adds
+// it to the index and commits the result with the given message.
+func testCommitFile(t testing.TB, c *Client, name, content, message string) CommitID {
+	t.Helper()
+	if dir := filepath.Dir(name); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{File(name)}); err != nil {
+		t.Fatal(err)
+	}
+	cid, err := Commit(c, CommitOptions{AllowEmpty: true}, CommitMessage(message), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid
+}
@@ -1,9 +1,12 @@
 package git
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"time"
 )
 
 type MergeStrategy string
@@ -141,6 +144,11 @@ func Merge(c *Client, opts MergeOptions, others []Commitish) error {
 	if len(others) != 1 {
 		return fmt.Errorf("Can only merge one branch at a time (for now.)")
 	}
+	// The three-way merge replaces the index and writes the merged files,
+	// so there mustn't be any local changes for it to lose.
+	if err := requireClean(c, "merge"); err != nil {
+		return err
+	}
 
 	// Perform a three-way merge with mergebase, head, and tree.
 	tree, err := others[0].CommitID(c)
@@ -162,68 +170,143 @@ func Merge(c *Client, opts MergeOptions, others []Commitish) error {
 	}
 
 	// run MergeFile on any unmerged entries.
-	unmerged := idx.GetUnmerged()
-	if len(unmerged) != 0 {
+	if len(idx.GetUnmerged()) != 0 {
 		// Flag conflicts in the tree if necessary.
 		conflictLabel := commitishName(others[0])
 		if conflictLabel == "" {
 			conflictLabel = tree.String()
 		}
-		var errStr string
-		for path, file := range unmerged {
-			fp, err := path.FilePath(c)
-			if err != nil {
+		conflicted, err := mergeUnmergedFiles(c, idx, MergeFileOptions{
+			CurrentLabel: "HEAD",
+			BaseLabel:    "merged common ancestors",
+			OtherLabel:   conflictLabel,
+		})
+		if err != nil {
+			return err
+		}
+		if len(conflicted) > 0 && rerereEnabled(c) {
+			// As in git, paths resolved by rerere are left
+			// unmerged in the index, so the merge still fails
+			// until they're added.
+			if _, err := RerereResolve(c, conflicted); err != nil {
 				return err
 			}
+		}
 
-			fmt.Fprintf(os.Stderr, "Auto-merging %v\n", fp)
-
-			// Check out the stages to temporary files for MergeFile
-			stage1tmp, _ := checkoutTemp(c, file.Stage1, CheckoutIndexOptions{})
-			defer os.Remove(stage1tmp)
-			stage2tmp, _ := checkoutTemp(c, file.Stage2, CheckoutIndexOptions{})
-			defer os.Remove(stage2tmp)
-			stage3tmp, _ := checkoutTemp(c, file.Stage3, CheckoutIndexOptions{})
-			defer os.Remove(stage3tmp)
-
-			// run git merge-file with the appropriate parameters.
-			r, err := MergeFile(c,
-				MergeFileOptions{
-					Current: MergeFileFile{
-						Filename: File(stage2tmp),
-						Label:    "HEAD",
-					},
-					Base: MergeFileFile{
-						Filename: File(stage1tmp),
-						Label:    "merged common ancestors",
-					},
-					Other: MergeFileFile{
-						Filename: File(stage3tmp),
-						Label:    conflictLabel,
-					},
-				},
-			)
-			if err != nil {
-				errStr += "CONFLICT (content): Merge conflict in " + fp.String() + "\n"
-			}
+		// Only error out if there was at least 1 conflict, otherwise it was
+		// a success.
+		if len(conflicted) > 0 {
+			return fmt.Errorf("%vAutomatic merge failed; fix conflicts and then commit the result.", conflictMessage(c, conflicted))
+		}
+	}
 
-			// Write the output with conflict markers into the file.
-			f2, err := os.Create(fp.String())
-			if err != nil {
-				return err
+	// TODO: Finally, create the new commit.
+	return nil
+}
+
+// Runs MergeFile on every unmerged path in idx, and writes the result,
+// including any conflict markers, into the work tree. The paths which had
+// conflicts are returned.
+func mergeUnmergedFiles(c *Client, idx *Index, labels MergeFileOptions) ([]IndexPath, error) {
+	var conflicted []IndexPath
+	for path, file := range idx.GetUnmerged() {
+		fp, err := path.FilePath(c)
+		if err != nil {
+			return nil, err
+		}
+
+		// A file which was deleted on one side and unchanged on the
+		// other is deleted, the same way as git merge-one-file.
+		if b := file.Stage1; b != nil && ((file.Stage2 == nil && file.Stage3 != nil && file.Stage3.Sha1 == b.Sha1) || (file.Stage3 == nil && file.Stage2 != nil && file.Stage2.Sha1 == b.Sha1)) {
+			// RemoveFile only removes one stage at a time.
+			for i := 0; i < 3; i++ {
+				idx.RemoveFile(path)
+			}
+			if err := os.Remove(fp.String()); err != nil && !os.IsNotExist(err) {
+				return nil, err
 			}
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "Auto-merging %v\n", fp)
 
-			io.Copy(f2, r)
-			f2.Close()
+		var stages [3]io.ReadCloser
+		for i, entry := range []*IndexEntry{file.Stage2, file.Stage1, file.Stage3} {
+			if entry == nil {
+				// It was added on one side.
+				stages[i] = ioutil.NopCloser(bytes.NewReader(nil))
+				continue
+			}
+			if stages[i], _, err = c.BlobReader(entry.Sha1); err != nil {
+				for _, r := range stages[:i] {
+					r.Close()
+				}
+				return nil, err
+			}
+		}
+		merged, conflicts, err := MergeFile(stages[0], stages[1], stages[2], labels)
+		for _, r := range stages {
+			r.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if conflicts > 0 {
+			conflicted = append(conflicted, path)
 		}
 
-		// Only error out if there was at least 1 conflict, otherwise it was
-		// a success.
-		if errStr != "" {
-			return fmt.Errorf("%vAutomatic merge failed; fix conflicts and then commit the result.", errStr)
+		// Write the output with conflict markers into the file.
+		if err := ioutil.WriteFile(fp.String(), merged, 0644); err != nil {
+			return nil, err
 		}
 	}
+	return conflicted, nil
+}
 
-	// TODO: Finally, create the new commit.
+// Adds the result of every unmerged path in idx which isn't in conflicted
+// to the index at stage 0, after mergeUnmergedFiles has merged it into the
+// work tree.
+func stageCleanMerges(c *Client, idx *Index, conflicted []IndexPath) error {
+	isConflicted := make(map[IndexPath]bool)
+	for _, path := range conflicted {
+		isConflicted[path] = true
+	}
+	for path, file := range idx.GetUnmerged() {
+		if isConflicted[path] {
+			continue
+		}
+		entry := file.Stage3
+		if entry == nil {
+			entry = file.Stage2
+		}
+		fp, err := path.FilePath(c)
+		if err != nil {
+			return err
+		}
+		merged, err := ioutil.ReadFile(fp.String())
+		if err != nil {
+			return err
+		}
+		sha, err := c.WriteObject("blob", merged)
+		if err != nil {
+			return err
+		}
+		if err := idx.AddStage(c, path, entry.Mode, sha, Stage0, uint32(len(merged)), time.Now().UnixNano(), UpdateIndexOptions{Add: true}); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// Returns the "CONFLICT" lines reported for the conflicted paths.
+func conflictMessage(c *Client, conflicted []IndexPath) string {
+	var msg string
+	for _, path := range conflicted {
+		fp, err := path.FilePath(c)
+		if err != nil {
+			fp = File(path)
+		}
+		msg += "CONFLICT (content): Merge conflict in " + fp.String() + "\n"
+	}
+	return msg
+}
@@ -52,7 +52,7 @@ func testMergeBaseSimpleSetup(t *testing.T) (c *Client, tmpdir string, A, B, C C
 	if err := c.CreateBranch("B", initialCmt); err != nil {
 		t.Fatal(err)
 	}
-	if err := Checkout(c, CheckoutOptions{}, "B", nil); err != nil {
+	if _, err := Checkout(c, CheckoutOptions{}, "B", nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -73,7 +73,7 @@ func testMergeBaseSimpleSetup(t *testing.T) (c *Client, tmpdir string, A, B, C C
 	if err := c.CreateBranch("C", initialCmt); err != nil {
 		t.Fatal(err)
 	}
-	if err := Checkout(c, CheckoutOptions{}, "C", nil); err != nil {
+	if _, err := Checkout(c, CheckoutOptions{}, "C", nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -3,63 +3,218 @@ package git
 import (
 	"bytes"
 	"io"
-	"os"
-	"os/exec"
+	"io/ioutil"
+	"strings"
 )
 
-type MergeFileFile struct {
-	Filename File
-	Label    string
-}
+// MergeFavor determines how MergeFile resolves conflicting changes.
+type MergeFavor int
+
+const (
+	// Conflicting changes are left in the output with conflict markers.
+	MergeFavorNone MergeFavor = iota
+	// Conflicting changes are resolved in favour of the current file.
+	MergeFavorOurs
+	// Conflicting changes are resolved in favour of the other file.
+	MergeFavorTheirs
+	// Both sides of conflicting changes are kept, the current file's
+	// first.
+	MergeFavorUnion
+)
+
+// The default length of conflict markers.
+const defaultConflictMarkerSize = 7
 
 type MergeFileOptions struct {
-	Current, Base, Other MergeFileFile
+	// The labels of the current, base and other files in the conflict
+	// markers.
+	CurrentLabel, BaseLabel, OtherLabel string
+
+	// How to resolve conflicting changes.
+	Favor MergeFavor
+
+	// The length of the conflict markers, or 0 for the default of 7.
+	MarkerSize int
 
+	// Include the base's version of conflicting changes in the output,
+	// like diff3.
+	Diff3 bool
+
+	// Used by the merge-file command to not print warnings about files
+	// which can't be merged, and to write the result to stdout instead
+	// of the current file.
 	Quiet  bool
 	Stdout bool
-	Diff3  bool
 }
 
-// MergeFile merges changes that lead from opt.Base to opt.Other into opt.Current,
-// flagging conflictsas appropriate.
-//
-// This will return an io.Reader of the merged state rather than directly Current.
-//
-// BUG(driusan): this currently invokes an external diff3 tool, which needs to be
-// installed in order to work.
-func MergeFile(c *Client, opt MergeFileOptions) (io.Reader, error) {
-	var args []string
-	if opt.Current.Label != "" {
-		args = append(args, "-L", opt.Current.Label)
-	} else {
-		// Ensure there's the right amount of -L specified if base or other happen
-		// to have specified a label but current didn't.
-		args = append(args, "-L", opt.Current.Label)
-	}
-
-	if opt.Base.Label != "" {
-		args = append(args, "-L", opt.Base.Label)
-	} else {
-		args = append(args, "-L", opt.Base.Label)
-	}
-
-	if opt.Other.Label != "" {
-		args = append(args, "-L", opt.Other.Label)
-	} else {
-		// Ensure there's the right amount of -L specified if base or other happen
-		// to have specified a label but current didn't.
-		args = append(args, "-L", opt.Other.Label)
-	}
-
-	// FIXME: There should be a way to get output similar to -A for -X, but
-	// -X doesn't seem to include non-conflicting lines. So for now, this always
-	// uses diff3 output.
-	args = append(args, "-m", "-A")
-	args = append(args, opt.Current.Filename.String(), opt.Base.Filename.String(), opt.Other.Filename.String())
-	var output bytes.Buffer
-	diff3cmd := exec.Command(posixDiff3, args...)
-	diff3cmd.Stderr = os.Stderr
-	diff3cmd.Stdout = &output
-	err := diff3cmd.Run()
-	return &output, err
+// Splits content into lines for merging. Unlike splitLines, each line
+// includes its trailing newline, so that a missing newline at the end of
+// the file is preserved.
+func splitMergeLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Returns the index of the line in a which is paired with each line of the
+// base o, or -1 if the line was removed in a.
+func mergeMatches(o, a []string) []int {
+	matches := make([]int, len(o))
+	for i := range matches {
+		matches[i] = -1
+	}
+	for _, pair := range diffLines(o, a) {
+		matches[pair.A] = pair.B
+	}
+	return matches
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MergeFile does a three-way merge of the changes that lead from base to
+// theirs into ours, and returns the merged content along with the number
+// of conflicts. Unless opts.Favor resolves them, conflicts are marked in
+// the content the same way as git merge-file.
+func MergeFile(ours, base, theirs io.Reader, opts MergeFileOptions) ([]byte, int, error) {
+	var content [3][]byte
+	for i, r := range []io.Reader{ours, base, theirs} {
+		c, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		content[i] = c
+	}
+	a, o, b := splitMergeLines(content[0]), splitMergeLines(content[1]), splitMergeLines(content[2])
+	amatch, bmatch := mergeMatches(o, a), mergeMatches(o, b)
+
+	m := merger{opts: opts}
+	if m.opts.MarkerSize <= 0 {
+		m.opts.MarkerSize = defaultConflictMarkerSize
+	}
+	ia, ibase, ib := 0, 0, 0
+	for {
+		// Find the stable lines which are unchanged in both files.
+		n := 0
+		for ibase+n < len(o) && amatch[ibase+n] == ia+n && bmatch[ibase+n] == ib+n {
+			n++
+		}
+		if n > 0 {
+			m.write(o[ibase : ibase+n])
+			ia, ibase, ib = ia+n, ibase+n, ib+n
+			continue
+		}
+		if ibase == len(o) && ia == len(a) && ib == len(b) {
+			break
+		}
+
+		// The next chunk changed in at least one of the files, up to
+		// the next base line that's unchanged in both.
+		jbase, ja, jb := ibase, len(a), len(b)
+		for ; jbase < len(o); jbase++ {
+			if amatch[jbase] >= ia && bmatch[jbase] >= ib {
+				ja, jb = amatch[jbase], bmatch[jbase]
+				break
+			}
+		}
+		m.chunk(a[ia:ja], o[ibase:jbase], b[ib:jb])
+		ia, ibase, ib = ja, jbase, jb
+	}
+	return m.out.Bytes(), m.conflicts, nil
+}
+
+// A merger accumulates the output of MergeFile.
+type merger struct {
+	opts      MergeFileOptions
+	out       bytes.Buffer
+	conflicts int
+}
+
+func (m *merger) write(lines []string) {
+	for _, l := range lines {
+		m.out.WriteString(l)
+	}
+}
+
+// Writes the side of a conflict, making sure that the marker after it
+// starts on a new line.
+func (m *merger) writeSide(lines []string) {
+	m.write(lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		m.out.WriteString("\n")
+	}
+}
+
+func (m *merger) marker(c byte, label string) {
+	m.out.WriteString(strings.Repeat(string(c), m.opts.MarkerSize))
+	if label != "" {
+		m.out.WriteString(" " + label)
+	}
+	m.out.WriteString("\n")
+}
+
+// Merges a chunk where the base o was changed to a in the current file,
+// and to b in the other file.
+func (m *merger) chunk(a, o, b []string) {
+	switch {
+	case equalLines(a, o):
+		m.write(b)
+		return
+	case equalLines(b, o), equalLines(a, b):
+		m.write(a)
+		return
+	}
+
+	// Lines that both sides changed the same way at the start and end of
+	// the chunk aren't part of the conflict, unless the base is being
+	// shown.
+	var prefix, suffix int
+	if !m.opts.Diff3 || m.opts.Favor != MergeFavorNone {
+		for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+			prefix++
+		}
+		for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+			suffix++
+		}
+	}
+	switch m.opts.Favor {
+	case MergeFavorOurs:
+		m.write(a)
+		return
+	case MergeFavorTheirs:
+		m.write(b)
+		return
+	case MergeFavorUnion:
+		m.write(a[:prefix])
+		m.writeSide(a[prefix : len(a)-suffix])
+		m.write(b[prefix:])
+		return
+	}
+
+	m.write(a[:prefix])
+	m.marker('<', m.opts.CurrentLabel)
+	m.writeSide(a[prefix : len(a)-suffix])
+	if m.opts.Diff3 {
+		m.marker('|', m.opts.BaseLabel)
+		m.writeSide(o)
+	}
+	m.marker('=', "")
+	m.writeSide(b[prefix : len(b)-suffix])
+	m.marker('>', m.opts.OtherLabel)
+	m.write(a[len(a)-suffix:])
+	m.conflicts++
 }
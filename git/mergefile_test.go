@@ -0,0 +1,94 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeFile(t *testing.T) {
+	const base = "a\nb\nc\nd\ne\n"
+	tests := []struct {
+		name         string
+		ours, theirs string
+		opts         MergeFileOptions
+		want         string
+		conflicts    int
+	}{
+		{
+			name:   "Clean merge",
+			ours:   "a\nB\nc\nd\ne\n",
+			theirs: "a\nb\nc\nD\ne\nf\n",
+			want:   "a\nB\nc\nD\ne\nf\n",
+		},
+		{
+			name:   "Same change on both sides",
+			ours:   "a\nB\nc\nd\ne\n",
+			theirs: "a\nB\nc\nd\ne\n",
+			want:   "a\nB\nc\nd\ne\n",
+		},
+		{
+			name:      "Conflict",
+			ours:      "a\nB\nc\nd\ne\n",
+			theirs:    "a\nX\nc\nd\nE\n",
+			opts:      MergeFileOptions{CurrentLabel: "ours", OtherLabel: "theirs"},
+			want:      "a\n<<<<<<< ours\nB\n=======\nX\n>>>>>>> theirs\nc\nd\nE\n",
+			conflicts: 1,
+		},
+		{
+			name:      "Conflict with diff3 and marker size",
+			ours:      "a\nB\nc\nd\ne\n",
+			theirs:    "a\nX\nc\nd\ne\n",
+			opts:      MergeFileOptions{CurrentLabel: "ours", BaseLabel: "base", OtherLabel: "theirs", Diff3: true, MarkerSize: 3},
+			want:      "a\n<<< ours\nB\n||| base\nb\n===\nX\n>>> theirs\nc\nd\ne\n",
+			conflicts: 1,
+		},
+		{
+			name:      "Common lines of a conflict",
+			ours:      "a\nB\nsame\nc\nd\ne\n",
+			theirs:    "a\nX\nsame\nc\nd\ne\n",
+			want:      "a\n<<<<<<<\nB\n=======\nX\n>>>>>>>\nsame\nc\nd\ne\n",
+			conflicts: 1,
+		},
+		{
+			name:   "Ours",
+			ours:   "a\nB\nc\nd\ne\n",
+			theirs: "a\nX\nc\nd\nE\n",
+			opts:   MergeFileOptions{Favor: MergeFavorOurs},
+			want:   "a\nB\nc\nd\nE\n",
+		},
+		{
+			name:   "Theirs",
+			ours:   "a\nB\nc\nd\ne\n",
+			theirs: "a\nX\nc\nd\nE\n",
+			opts:   MergeFileOptions{Favor: MergeFavorTheirs},
+			want:   "a\nX\nc\nd\nE\n",
+		},
+		{
+			name:   "Union",
+			ours:   "a\nB\nc\nd\ne\n",
+			theirs: "a\nX\nc\nd\nE\n",
+			opts:   MergeFileOptions{Favor: MergeFavorUnion},
+			want:   "a\nB\nX\nc\nd\nE\n",
+		},
+		{
+			name:      "Missing newline at the end",
+			ours:      "a\nb\nc\nd\nours",
+			theirs:    "a\nb\nc\nd\ntheirs",
+			want:      "a\nb\nc\nd\n<<<<<<<\nours\n=======\ntheirs\n>>>>>>>\n",
+			conflicts: 1,
+		},
+	}
+	for _, tc := range tests {
+		merged, conflicts, err := MergeFile(strings.NewReader(tc.ours), strings.NewReader(base), strings.NewReader(tc.theirs), tc.opts)
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		if string(merged) != tc.want {
+			t.Errorf("%v: got %q want %q", tc.name, merged, tc.want)
+		}
+		if conflicts != tc.conflicts {
+			t.Errorf("%v: got %d conflicts want %d", tc.name, conflicts, tc.conflicts)
+		}
+	}
+}
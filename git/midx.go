@@ -0,0 +1,278 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const multiPackIndexSignature = "MIDX"
+
+// A multiPackIndex is a parsed objects/pack/multi-pack-index file, which
+// indexes the objects of many packs so that an object can be found with a
+// single binary search instead of searching the index of every pack.
+type multiPackIndex struct {
+	// The names of the pack indexes, such as "pack-<sha1>.idx", in the
+	// order that the pack-int-ids of the object offsets refer to.
+	packs []string
+
+	fanout       []byte
+	oids         []byte
+	offsets      []byte
+	largeOffsets []byte
+}
+
+func multiPackIndexFile(dir File) File {
+	return dir + "/pack/multi-pack-index"
+}
+
+// Returns the number of objects in the multi-pack-index.
+func (m *multiPackIndex) len() int {
+	return len(m.oids) / 20
+}
+
+// Returns true if the pack index named idx is covered by the
+// multi-pack-index.
+func (m *multiPackIndex) hasPack(idx string) bool {
+	i := sort.SearchStrings(m.packs, idx)
+	return i < len(m.packs) && m.packs[i] == idx
+}
+
+// Finds the object id in the multi-pack-index, returning the name of the
+// pack index that it's in and its offset in the packfile.
+func (m *multiPackIndex) lookup(id Sha1) (idx string, offset int64, found bool) {
+	pos, ok := lookupOID(m.fanout, m.oids, id)
+	if !ok {
+		return "", 0, false
+	}
+	entry := m.offsets[pos*8:]
+	pack := binary.BigEndian.Uint32(entry)
+	if int(pack) >= len(m.packs) {
+		return "", 0, false
+	}
+	off := binary.BigEndian.Uint32(entry[4:])
+	if off&(1<<31) == 0 {
+		return m.packs[pack], int64(off), true
+	}
+	large := int(off &^ (1 << 31))
+	if (large+1)*8 > len(m.largeOffsets) {
+		return "", 0, false
+	}
+	return m.packs[pack], int64(binary.BigEndian.Uint64(m.largeOffsets[large*8:])), true
+}
+
+// Parses the content of a multi-pack-index file. It returns an error if the
+// file is corrupt or in a version that isn't understood.
+func parseMultiPackIndex(content []byte) (*multiPackIndex, error) {
+	if len(content) < 12+12+20 || string(content[:4]) != multiPackIndexSignature {
+		return nil, fmt.Errorf("multi-pack-index signature does not match")
+	}
+	if content[4] != 1 {
+		return nil, fmt.Errorf("multi-pack-index version %d not recognized", content[4])
+	}
+	if content[5] != 1 {
+		return nil, fmt.Errorf("multi-pack-index hash version %d is not supported", content[5])
+	}
+	if content[7] != 0 {
+		return nil, fmt.Errorf("multi-pack-index with base files is not supported")
+	}
+	chunks, err := parseChunkFile(content, 12, int(content[6]))
+	if err != nil {
+		return nil, fmt.Errorf("multi-pack-index: %v", err)
+	}
+	m := &multiPackIndex{
+		fanout:       chunks["OIDF"],
+		oids:         chunks["OIDL"],
+		offsets:      chunks["OOFF"],
+		largeOffsets: chunks["LOFF"],
+	}
+	numPacks := int(binary.BigEndian.Uint32(content[8:]))
+	for _, name := range bytes.Split(chunks["PNAM"], []byte{0}) {
+		if len(name) > 0 {
+			m.packs = append(m.packs, string(name))
+		}
+	}
+	if len(m.packs) != numPacks {
+		return nil, fmt.Errorf("multi-pack-index pack-name chunk does not match the number of packs")
+	}
+	if !sort.StringsAreSorted(m.packs) {
+		return nil, fmt.Errorf("multi-pack-index pack names out of order")
+	}
+	if len(m.fanout) != 256*4 {
+		return nil, fmt.Errorf("multi-pack-index is missing the OID fanout chunk")
+	}
+	if m.oids == nil || len(m.oids)%20 != 0 {
+		return nil, fmt.Errorf("multi-pack-index is missing the OID lookup chunk")
+	}
+	if len(m.offsets) != m.len()*8 {
+		return nil, fmt.Errorf("multi-pack-index is missing the object offsets chunk")
+	}
+	if int(binary.BigEndian.Uint32(m.fanout[255*4:])) != m.len() {
+		return nil, fmt.Errorf("multi-pack-index OID fanout does not match the OID lookup")
+	}
+	return m, nil
+}
+
+// Returns the multi-pack-index of the objects directory dir, loading it the
+// first time. If there is no multi-pack-index, it's corrupt, or any of the
+// packs that it refers to have been removed, nil is returned and the pack
+// indexes need to be searched individually.
+func (c *Client) getMultiPackIndex(dir File) *multiPackIndex {
+	if m, ok := c.multiPackIndexes[dir]; ok {
+		return m
+	}
+	if c.multiPackIndexes == nil {
+		c.multiPackIndexes = make(map[File]*multiPackIndex)
+	}
+	c.multiPackIndexes[dir] = nil
+	if c.GetConfig("core.multiPackIndex") == "false" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(multiPackIndexFile(dir).String())
+	if err != nil {
+		return nil
+	}
+	m, err := parseMultiPackIndex(content)
+	if err != nil {
+		return nil
+	}
+	for _, idx := range m.packs {
+		pack := dir + File("/pack/"+strings.TrimSuffix(idx, ".idx")+".pack")
+		if !pack.Exists() {
+			return nil
+		}
+	}
+	c.multiPackIndexes[dir] = m
+	return m
+}
+
+// Returns the index of the packfile pfile (the base name with no
+// extension), reading it if it hasn't been read yet.
+func (c *Client) packIndex(pfile File) (*PackfileIndexV2, error) {
+	if idx, ok := c.packIndexes[pfile]; ok {
+		return idx, nil
+	}
+	f, err := os.Open((pfile + ".idx").String())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idx, err := readPackIndexV2(f)
+	if err != nil {
+		return nil, err
+	}
+	if c.packIndexes == nil {
+		c.packIndexes = make(map[File]*PackfileIndexV2)
+	}
+	c.packIndexes[pfile] = &idx
+	return &idx, nil
+}
+
+// WriteMultiPackIndex writes a multi-pack-index for every pack in the
+// objects directory of c, replacing any existing one. If an object is in
+// more than one pack, the most recently modified pack is used.
+func WriteMultiPackIndex(c *Client) error {
+	packdir := c.GitDir.File("objects/pack")
+	files, err := ioutil.ReadDir(packdir.String())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	type location struct {
+		pack   uint32
+		offset int64
+		mtime  int64
+	}
+	var packs []string
+	objects := make(map[Sha1]location)
+	for _, fi := range files {
+		if filepath.Ext(fi.Name()) != ".idx" {
+			continue
+		}
+		base := packdir + File("/"+strings.TrimSuffix(fi.Name(), ".idx"))
+		pstat, err := os.Stat((base + ".pack").String())
+		if err != nil {
+			// An index without a pack can't be used.
+			continue
+		}
+		idx, err := c.packIndex(base)
+		if err != nil {
+			return fmt.Errorf("%v: %v", fi.Name(), err)
+		}
+		// ReadDir returns the names sorted, which is the order that the
+		// pack names need to be in.
+		packid := uint32(len(packs))
+		packs = append(packs, fi.Name())
+		mtime := pstat.ModTime().UnixNano()
+		for i, id := range idx.Sha1Table {
+			if prev, ok := objects[id]; ok && prev.mtime >= mtime {
+				continue
+			}
+			objects[id] = location{packid, idx.objectOffset(i), mtime}
+		}
+	}
+
+	file := multiPackIndexFile(c.GitDir.File("objects"))
+	if len(packs) == 0 {
+		if err := os.Remove(file.String()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		c.multiPackIndexes = nil
+		return nil
+	}
+
+	sorted := make([]Sha1, 0, len(objects))
+	for id := range objects {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+
+	var pnam, oids, offsets, largeOffsets bytes.Buffer
+	for _, name := range packs {
+		pnam.WriteString(name)
+		pnam.WriteByte(0)
+	}
+	for pnam.Len()%4 != 0 {
+		pnam.WriteByte(0)
+	}
+	ids := make([][]byte, len(sorted))
+	for i := range sorted {
+		ids[i] = sorted[i][:]
+		oids.Write(sorted[i][:])
+
+		loc := objects[sorted[i]]
+		binary.Write(&offsets, binary.BigEndian, loc.pack)
+		if loc.offset >= 1<<31 {
+			binary.Write(&offsets, binary.BigEndian, uint32(1<<31|largeOffsets.Len()/8))
+			binary.Write(&largeOffsets, binary.BigEndian, uint64(loc.offset))
+		} else {
+			binary.Write(&offsets, binary.BigEndian, uint32(loc.offset))
+		}
+	}
+
+	chunks := []chunk{
+		{"PNAM", pnam.Bytes()},
+		{"OIDF", writeOIDFanout(ids)},
+		{"OIDL", oids.Bytes()},
+		{"OOFF", offsets.Bytes()},
+	}
+	if largeOffsets.Len() > 0 {
+		chunks = append(chunks, chunk{"LOFF", largeOffsets.Bytes()})
+	}
+	header := []byte(multiPackIndexSignature + "\x01\x01")
+	header = append(header, byte(len(chunks)), 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(packs)))
+	if err := replaceReadOnlyFile(file, "tmp_midx_", writeChunkFile(header, chunks)); err != nil {
+		return err
+	}
+	// Make sure the next lookup uses the new index.
+	c.multiPackIndexes = nil
+	return nil
+}
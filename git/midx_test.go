@@ -0,0 +1,158 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Tests that objects spread across two packs can be read through the
+// multi-pack-index, and that it stays consistent as packs are added and
+// removed.
+func TestMultiPackIndex(t *testing.T) {
+	c, cleanup := testRepo(t, "gitmidx")
+	defer cleanup()
+
+	first := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+	second := testCommitFile(t, c, "bar.txt", "bar\n", "Second commit")
+	third := testCommitFile(t, c, "baz.txt", "baz\n", "Third commit")
+
+	// Packs each commit with the objects that weren't in the ones before
+	// it.
+	seen := make(map[Sha1]struct{})
+	var packed [][]Sha1
+	pack := func(cmt CommitID) []byte {
+		t.Helper()
+		objs, err := cmt.GetAllObjectsExcept(c, seen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, Sha1(cmt))
+		for _, o := range objs {
+			seen[o] = struct{}{}
+		}
+		packed = append(packed, objs)
+		var buf bytes.Buffer
+		if err := SendPackfile(c, &buf, objs); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	packs := [][]byte{pack(first), pack(second), pack(third)}
+	all := append(append(append([]Sha1{}, packed[0]...), packed[1]...), packed[2]...)
+
+	gitdir, err := ioutil.TempDir("", "gitmidxdst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitdir)
+	dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, gitdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packshas []Sha1
+	for _, p := range packs[:2] {
+		sha, err := IndexAndCopyPack(dst, IndexPackOptions{}, bytes.NewReader(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		packshas = append(packshas, sha)
+	}
+	if err := WriteMultiPackIndex(dst); err != nil {
+		t.Fatal(err)
+	}
+	if !multiPackIndexFile(dst.GitDir.File("objects")).Exists() {
+		t.Fatal("multi-pack-index was not written")
+	}
+
+	// Use a new client so that nothing is cached from indexing the packs.
+	readAll := func(objs []Sha1) *Client {
+		t.Helper()
+		c2, err := NewClient(gitdir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range objs {
+			expected, err := c.GetObject(o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			obj, err := c2.GetObject(o)
+			if err != nil {
+				t.Fatalf("Could not read %v: %v", o, err)
+			}
+			if obj.GetType() != expected.GetType() || !bytes.Equal(obj.GetContent(), expected.GetContent()) {
+				t.Errorf("Unexpected content for %v", o)
+			}
+		}
+		return c2
+	}
+
+	c2 := readAll(nil)
+	midx := c2.getMultiPackIndex(c2.GitDir.File("objects"))
+	if midx == nil {
+		t.Fatal("multi-pack-index was not loaded")
+	}
+	if len(midx.packs) != 2 {
+		t.Errorf("Unexpected number of packs in multi-pack-index: got %v want 2", len(midx.packs))
+	}
+	for _, sha := range packshas {
+		if !midx.hasPack("pack-" + sha.String() + ".idx") {
+			t.Errorf("multi-pack-index is missing pack %v", sha)
+		}
+	}
+	indexed := append(append([]Sha1{}, packed[0]...), packed[1]...)
+	for _, o := range indexed {
+		if _, _, ok := midx.lookup(o); !ok {
+			t.Errorf("Object %v is not in the multi-pack-index", o)
+		}
+	}
+	if midx.len() != len(indexed) {
+		t.Errorf("Unexpected number of objects in multi-pack-index: got %v want %v", midx.len(), len(indexed))
+	}
+	if _, _, ok := midx.lookup(Sha1(third)); ok {
+		t.Errorf("Unexpected object %v in multi-pack-index", third)
+	}
+	readAll(indexed)
+
+	// A pack added after the multi-pack-index was written must still be
+	// searched.
+	if _, err := IndexAndCopyPack(dst, IndexPackOptions{}, bytes.NewReader(packs[2])); err != nil {
+		t.Fatal(err)
+	}
+	readAll(all)
+
+	// Once a pack that it refers to is removed, the multi-pack-index is
+	// ignored and the objects in the remaining packs are still found.
+	removed := dst.GitDir.File(File("objects/pack/pack-" + packshas[0].String()))
+	if err := os.Remove((removed + ".pack").String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove((removed + ".idx").String()); err != nil {
+		t.Fatal(err)
+	}
+	c3 := readAll(nil)
+	if c3.getMultiPackIndex(c3.GitDir.File("objects")) != nil {
+		t.Error("Stale multi-pack-index was loaded")
+	}
+	var remaining []Sha1
+	for _, o := range all {
+		if found, _, _ := c3.HaveObject(o); found {
+			remaining = append(remaining, o)
+		}
+	}
+	if len(remaining) != len(packed[1])+len(packed[2]) {
+		t.Errorf("Unexpected number of objects after removing pack: got %v want %v", len(remaining), len(packed[1])+len(packed[2]))
+	}
+	readAll(remaining)
+
+	// Rewriting it brings it back in sync.
+	if err := WriteMultiPackIndex(dst); err != nil {
+		t.Fatal(err)
+	}
+	c4 := readAll(remaining)
+	if midx := c4.getMultiPackIndex(c4.GitDir.File("objects")); midx == nil || len(midx.packs) != 2 || midx.len() != len(remaining) {
+		t.Error("Rewritten multi-pack-index does not match the packs")
+	}
+}
@@ -1,11 +1,13 @@
 package git
 
 import (
-	"io"
-	//"reflect"
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 type MkTreeOptions struct {
@@ -14,65 +16,132 @@ type MkTreeOptions struct {
 	Batch        bool
 }
 
+// A MkTreeEntry is an entry of a tree built by MkTreeEntries.
+type MkTreeEntry struct {
+	TreeEntry
+
+	// The type of the object, which must match the type implied by the
+	// mode. If empty, the type isn't checked.
+	Type string
+
+	// The name of the entry within the tree, which can't contain a
+	// slash.
+	Name string
+}
+
 // Mktree reads a tree from r in the same format as ls-tree and returns
 // the TreeID of the converted tree.
 func MkTree(c *Client, opts MkTreeOptions, r io.Reader) (TreeID, error) {
-	var entry struct {
-		mode, typ string
-		sha1      string
-		name      string
+	var entries []MkTreeEntry
+	sep := byte('\n')
+	if opts.NilTerminate {
+		sep = 0
 	}
-	entries := []*IndexEntry{}
-readlines:
+	buf := bufio.NewReader(r)
 	for {
-		var err error
-		if opts.NilTerminate {
-			_, err = fmt.Fscanf(r, "%s %s %s\t %s\x00", &entry.mode, &entry.typ, &entry.sha1, &entry.name)
-		} else {
-			_, err = fmt.Fscanf(r, "%s %s %s\t %s\n", &entry.mode, &entry.typ, &entry.sha1, &entry.name)
-		}
-		switch err {
-		case io.EOF:
-			break readlines
-		case nil:
-		default:
+		line, err := buf.ReadString(sep)
+		if err == io.EOF && line == "" {
+			break
+		} else if err != nil && err != io.EOF {
 			return TreeID{}, err
 		}
-		indexentry := &IndexEntry{}
-		switch entry.mode {
-		// FIXME: Validate typ too
-		case "100644":
-			indexentry.Mode = ModeBlob
-		case "100755":
-			indexentry.Mode = ModeExec
-		case "120000":
-			indexentry.Mode = ModeSymlink
-		case "160000":
-			indexentry.Mode = ModeCommit
-		case "040000":
-			indexentry.Mode = ModeTree
+		entry, perr := parseMkTreeLine(strings.TrimSuffix(line, string(sep)), opts)
+		if perr != nil {
+			return TreeID{}, perr
+		}
+		entries = append(entries, entry)
+		if err == io.EOF {
+			break
+		}
+	}
+	return MkTreeEntries(c, opts, entries)
+}
+
+// Parses a line of ls-tree output of the form "<mode> <type> <sha>\t<name>".
+func parseMkTreeLine(line string, opts MkTreeOptions) (MkTreeEntry, error) {
+	tab := strings.IndexByte(line, '\t')
+	if tab < 0 {
+		return MkTreeEntry{}, fmt.Errorf("input format error: %v", line)
+	}
+	fields := strings.Fields(line[:tab])
+	if len(fields) != 3 {
+		return MkTreeEntry{}, fmt.Errorf("input format error: %v", line)
+	}
+	mode, err := ModeFromString(fields[0])
+	if fields[0] == "40000" {
+		mode, err = ModeTree, nil
+	}
+	if err != nil {
+		return MkTreeEntry{}, err
+	}
+	sha1, err := Sha1FromString(fields[2])
+	if err != nil {
+		return MkTreeEntry{}, fmt.Errorf("input format error: %v", line)
+	}
+	name := line[tab+1:]
+	if !opts.NilTerminate && strings.HasPrefix(name, `"`) {
+		// Names with special characters are quoted by ls-tree.
+		if name, err = strconv.Unquote(name); err != nil {
+			return MkTreeEntry{}, fmt.Errorf("invalid quoting: %v", line[tab+1:])
+		}
+	}
+	return MkTreeEntry{TreeEntry{sha1, mode}, fields[1], name}, nil
+}
+
+// MkTreeEntries writes a tree made of entries to c and returns its TreeID.
+//
+// The entries are sorted in the order that git requires, so they can be
+// given in any order, but the names must be unique. Unless
+// opts.AllowMissing is set, every object other than the commits of
+// submodules must exist with the type implied by its mode.
+func MkTreeEntries(c *Client, opts MkTreeOptions, entries []MkTreeEntry) (TreeID, error) {
+	sorted := make([]MkTreeEntry, len(entries))
+	copy(sorted, entries)
+	names := make(map[string]struct{}, len(entries))
+	for _, entry := range sorted {
+		switch entry.FileMode {
+		case ModeBlob, ModeExec, ModeSymlink, ModeGitlink, ModeTree:
 		default:
-			return TreeID{}, fmt.Errorf("Invalid mode")
+			return TreeID{}, fmt.Errorf("Invalid mode %o for %v", entry.FileMode, entry.Name)
 		}
-		sha1, err := Sha1FromString(entry.sha1)
-		if err != nil {
+		if entry.Name == "" || entry.Name == "." || entry.Name == ".." || strings.ContainsAny(entry.Name, "/\000") {
+			return TreeID{}, fmt.Errorf("Invalid name %q", entry.Name)
+		}
+		if _, ok := names[entry.Name]; ok {
+			return TreeID{}, fmt.Errorf("Duplicate entry %v", entry.Name)
+		}
+		names[entry.Name] = struct{}{}
+		typ := entry.FileMode.TreeType()
+		if entry.Type != "" && entry.Type != typ {
+			return TreeID{}, fmt.Errorf("Object type (%v) of %v does not match mode type (%v)", entry.Type, entry.Name, typ)
+		}
+		if entry.FileMode == ModeGitlink || opts.AllowMissing {
+			continue
+		}
+		if !c.HasObject(entry.Sha1) {
+			return TreeID{}, fmt.Errorf("Missing object %v", entry.Sha1)
+		}
+		if objtype, err := c.ObjectType(entry.Sha1); err != nil {
 			return TreeID{}, err
+		} else if objtype != typ {
+			return TreeID{}, fmt.Errorf("Object %v of %v is a %v, not a %v", entry.Sha1, entry.Name, objtype, typ)
 		}
-		indexentry.Sha1 = sha1
-		if !opts.AllowMissing {
-			have, _, _ := c.HaveObject(sha1)
-			if !have {
-				return TreeID{}, fmt.Errorf("Missing object %v", sha1)
-			}
+	}
+
+	// Trees are sorted as if their names have a trailing slash.
+	sortName := func(e MkTreeEntry) string {
+		if e.FileMode == ModeTree {
+			return e.Name + "/"
 		}
-		indexentry.PathName = IndexPath(entry.name)
-		entries = append(entries, indexentry)
+		return e.Name
 	}
-	// format of each line: Mode filename\x00sha1
-	sort.Sort(ByPath(entries))
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sortName(sorted[i]) < sortName(sorted[j])
+	})
 	content := bytes.NewBuffer(nil)
-	for _, entry := range entries {
-		fmt.Fprintf(content, "%o %s\x00", entry.Mode, entry.PathName)
+	for _, entry := range sorted {
+		// format of each entry: Mode filename\x00sha1
+		fmt.Fprintf(content, "%o %s\x00", entry.FileMode, entry.Name)
 		if _, err := content.Write(entry.Sha1[:]); err != nil {
 			return TreeID{}, err
 		}
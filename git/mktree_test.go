@@ -0,0 +1,60 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMkTree(t *testing.T) {
+	c, cleanup := testRepo(t, "gitmktree")
+	defer cleanup()
+	testCommitFile(t, c, "a.b", "foo\n", "Add a.b")
+	testCommitFile(t, c, "a/c.txt", "bar\n", "Add a/c.txt")
+	testCommitFile(t, c, "z", "baz\n", "Add z")
+	want, err := WriteTree(c, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, err := want.GetAllObjects(c, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The entries are given out of order, with the subtree "a" by its
+	// sha, and sorted as if it were "a/", after "a.b".
+	var entries []MkTreeEntry
+	var lines []string
+	for _, name := range []string{"z", "a", "a.b"} {
+		entry := objs[IndexPath(name)]
+		entries = append(entries, MkTreeEntry{TreeEntry: entry, Name: name})
+		lines = append(lines, fmt.Sprintf("%06o %v %v\t%v\n", entry.FileMode, entry.FileMode.TreeType(), entry.Sha1, name))
+	}
+	if got, err := MkTreeEntries(c, MkTreeOptions{}, entries); err != nil || got != want {
+		t.Errorf("Unexpected tree from entries: got %v (%v) want %v", got, err, want)
+	}
+	if got, err := MkTree(c, MkTreeOptions{}, strings.NewReader(strings.Join(lines, ""))); err != nil || got != want {
+		t.Errorf("Unexpected tree from ls-tree format: got %v (%v) want %v", got, err, want)
+	}
+
+	blob := objs["z"]
+	for _, tc := range []struct {
+		desc    string
+		entries []MkTreeEntry
+	}{
+		{"duplicate names", []MkTreeEntry{{TreeEntry: blob, Name: "a"}, {TreeEntry: objs["a"], Name: "a"}}},
+		{"bad mode", []MkTreeEntry{{TreeEntry: TreeEntry{blob.Sha1, EntryMode(0100664)}, Name: "a"}}},
+		{"bad name", []MkTreeEntry{{TreeEntry: blob, Name: "a/b"}}},
+		{"mismatched type", []MkTreeEntry{{TreeEntry: blob, Type: "tree", Name: "a"}}},
+		{"mode of the wrong type", []MkTreeEntry{{TreeEntry: TreeEntry{blob.Sha1, ModeTree}, Name: "a"}}},
+		{"missing object", []MkTreeEntry{{TreeEntry: TreeEntry{Sha1{1}, ModeBlob}, Name: "a"}}},
+	} {
+		if _, err := MkTreeEntries(c, MkTreeOptions{}, tc.entries); err == nil {
+			t.Errorf("Tree with %v was made", tc.desc)
+		}
+	}
+	missing := []MkTreeEntry{{TreeEntry: TreeEntry{Sha1{1}, ModeBlob}, Name: "a"}}
+	if _, err := MkTreeEntries(c, MkTreeOptions{AllowMissing: true}, missing); err != nil {
+		t.Errorf("Missing object not allowed with AllowMissing: %v", err)
+	}
+}
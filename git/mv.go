@@ -0,0 +1,157 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MvOptions denotes command line options that may be parsed from "git mv"
+type MvOptions struct {
+	// Overwrite the destination if it already exists.
+	Force bool
+
+	// Only report what would be renamed, without touching the index or
+	// the working tree.
+	DryRun bool
+
+	// Skip sources which can't be moved instead of returning an error.
+	SkipErrors bool
+
+	// Print the name of each file as it's moved.
+	Verbose bool
+}
+
+// Mv implements the "git mv" command. If dst is an existing directory, each
+// of sources is moved into it, otherwise there must be exactly one source
+// and it's renamed to dst. A source may be a file in the index or a
+// directory with files in the index.
+//
+// It returns the renames which were done, or which would have been done if
+// opts.DryRun is set.
+func Mv(c *Client, opts MvOptions, sources []File, dst File) ([]PlannedChange, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("No source to move")
+	}
+	intoDir := dst.IsDir()
+	if !intoDir && len(sources) > 1 {
+		return nil, fmt.Errorf("destination '%v' is not a directory", dst)
+	}
+
+	idx, lock, err := c.GitDir.LockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Rollback()
+
+	var changes []PlannedChange
+	// The index entries to rename for each change, and their new names.
+	var renames [][]*IndexEntry
+	var newNames [][]IndexPath
+	for _, src := range sources {
+		dest := dst
+		if intoDir {
+			dest = File(filepath.Join(dst.String(), filepath.Base(src.String())))
+		}
+		if opts.DryRun {
+			fmt.Printf("Checking rename of '%v' to '%v'\n", src, dest)
+		}
+		entries, names, err := mvEntries(c, idx, opts, src, dest)
+		if err != nil {
+			if opts.SkipErrors {
+				continue
+			}
+			return nil, err
+		}
+		if opts.Verbose || opts.DryRun {
+			fmt.Printf("Renaming %v to %v\n", src, dest)
+		}
+		changes = append(changes, PlannedChange{Action: ChangeRename, Path: src, Dest: dest})
+		renames = append(renames, entries)
+		newNames = append(newNames, names)
+	}
+	if opts.DryRun || len(changes) == 0 {
+		return changes, nil
+	}
+
+	for i, change := range changes {
+		if change.Dest.Exists() {
+			// mvEntries only allows this with Force.
+			if err := os.RemoveAll(change.Dest.String()); err != nil {
+				return nil, err
+			}
+			if destIP, err := change.Dest.IndexPath(c); err == nil {
+				idx.RemoveFile(destIP)
+			}
+		}
+		if err := os.Rename(change.Path.String(), change.Dest.String()); err != nil {
+			return nil, err
+		}
+		for j, entry := range renames[i] {
+			idx.invalidateCacheTree(entry.PathName)
+			idx.untracked.invalidate(entry.PathName)
+			idx.fsmonitor.invalidate(entry.PathName)
+			entry.PathName = newNames[i][j]
+			// The name length is part of the flags.
+			length := uint16(len(entry.PathName))
+			if length > 0x0FFF {
+				length = 0x0FFF
+			}
+			entry.FixedIndexEntry.Flags = entry.FixedIndexEntry.Flags&^0x0FFF | length
+			idx.invalidateCacheTree(entry.PathName)
+			idx.untracked.invalidate(entry.PathName)
+			idx.fsmonitor.invalidate(entry.PathName)
+		}
+	}
+	idx.UseConfiguredVersion(c)
+	return changes, idx.CommitLocked(lock)
+}
+
+// Returns the index entries which moving src to dest renames, and their new
+// names, or an error if src can't be moved there.
+func mvEntries(c *Client, idx *Index, opts MvOptions, src, dest File) ([]*IndexEntry, []IndexPath, error) {
+	if !src.Exists() {
+		return nil, nil, fmt.Errorf("bad source, source=%v, destination=%v", src, dest)
+	}
+	srcIP, err := src.IndexPath(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	destIP, err := dest.IndexPath(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if srcIP == destIP || strings.HasPrefix(string(destIP), string(srcIP)+"/") {
+		return nil, nil, fmt.Errorf("can not move directory into itself, source=%v, destination=%v", src, dest)
+	}
+	if dest.Exists() && !opts.Force {
+		return nil, nil, fmt.Errorf("destination exists, source=%v, destination=%v", src, dest)
+	}
+	if parent := filepath.Dir(dest.String()); !File(parent).IsDir() {
+		return nil, nil, fmt.Errorf("destination directory does not exist, source=%v, destination=%v", src, dest)
+	}
+
+	var entries []*IndexEntry
+	var names []IndexPath
+	for _, entry := range idx.Objects {
+		var newName IndexPath
+		switch {
+		case entry.PathName == srcIP:
+			newName = destIP
+		case strings.HasPrefix(string(entry.PathName), string(srcIP)+"/"):
+			newName = destIP + entry.PathName[len(srcIP):]
+		default:
+			continue
+		}
+		if entry.Stage() != Stage0 {
+			return nil, nil, fmt.Errorf("conflicted, source=%v, destination=%v", src, dest)
+		}
+		entries = append(entries, entry)
+		names = append(names, newName)
+	}
+	if len(entries) == 0 {
+		return nil, nil, fmt.Errorf("not under version control, source=%v, destination=%v", src, dest)
+	}
+	return entries, names, nil
+}
@@ -0,0 +1,127 @@
+package git
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// A netrcEntry is the login for a machine from a .netrc file. The entry
+// for "default" has an empty machine.
+type netrcEntry struct {
+	machine, login, password string
+}
+
+// Parses the entries of a .netrc file. Macro definitions are skipped, as
+// are account tokens.
+func parseNetrc(r io.Reader) ([]netrcEntry, error) {
+	var entries []netrcEntry
+	var cur *netrcEntry
+	inMacro := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// A macro definition ends at the first blank line.
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			// The value of a token, if there is one.
+			var val string
+			if i+1 < len(fields) {
+				val = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: val})
+				cur = &entries[len(entries)-1]
+				i++
+			case "default":
+				entries = append(entries, netrcEntry{})
+				cur = &entries[len(entries)-1]
+			case "login":
+				if cur != nil {
+					cur.login = val
+				}
+				i++
+			case "password":
+				if cur != nil {
+					cur.password = val
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				// The rest of the line is the macro name, and
+				// the definition follows it.
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Returns the path of the user's .netrc file, which is named _netrc on
+// Windows. The NETRC environment variable overrides it.
+func netrcPath() (string, error) {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc"), nil
+	}
+	return filepath.Join(home, ".netrc"), nil
+}
+
+// Fills in the username and password of cred from the user's .netrc file,
+// if it has an entry for cred's host. It returns false if there was no
+// matching entry.
+func netrcCredential(cred *credential) bool {
+	path, err := netrcPath()
+	if err != nil {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	entries, err := parseNetrc(f)
+	if err != nil {
+		return false
+	}
+	host := cred.host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, e := range entries {
+		if e.machine != "" && !strings.EqualFold(e.machine, host) {
+			continue
+		}
+		if cred.username != "" && e.login != "" && e.login != cred.username {
+			continue
+		}
+		if e.password == "" {
+			continue
+		}
+		if cred.username == "" {
+			cred.username = e.login
+		}
+		cred.password = e.password
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	netrc := `machine example.com login alice password secret
+macdef init
+cd /pub
+machine notamachine login nobody
+
+machine other.example.com
+	login bob
+	account ignored
+	password hunter2
+default login anonymous password guest
+`
+	got, err := parseNetrc(strings.NewReader(netrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netrcEntry{
+		{"example.com", "alice", "secret"},
+		{"other.example.com", "bob", "hunter2"},
+		{"", "anonymous", "guest"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Unexpected entries: got %v want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Unexpected entry %d: got %v want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNetrcAuth(t *testing.T) {
+	c, cleanup := testRepo(t, "gitnetrc")
+	defer cleanup()
+	ts := testAuthServer(t, "alice", "secret")
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "gitnetrcfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	netrc := filepath.Join(dir, "netrc")
+	content := fmt.Sprintf("machine other.example.com login bob password hunter2\nmachine %v login alice password secret\n", u.Hostname())
+	if err := ioutil.WriteFile(netrc, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, env := range []string{"NETRC", "GIT_ASKPASS", "SSH_ASKPASS", "GIT_TERMINAL_PROMPT"} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Unsetenv(env)
+	}
+	os.Setenv("NETRC", netrc)
+	os.Setenv("GIT_TERMINAL_PROMPT", "0")
+
+	refs, err := LsRemote(c, LsRemoteOptions{}, Remote(ts.URL+"/repo.git"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Name != "refs/heads/master" {
+		t.Errorf("Unexpected refs: %v", refs)
+	}
+
+	// A login in the URL that doesn't match the entry isn't replaced.
+	u.User = url.User("mallory")
+	if _, err := LsRemote(c, LsRemoteOptions{}, Remote(u.String()+"/repo.git"), nil); err == nil {
+		t.Error("Used the netrc entry for a different login")
+	}
+}
@@ -0,0 +1,290 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// NotesOptions are the options which may be passed to the "git notes"
+// family of commands.
+type NotesOptions struct {
+	// The notes ref to operate on. If empty, core.notesRef, or
+	// GIT_NOTES_REF, or refs/notes/commits is used in that order.
+	Ref string
+
+	// Overwrite an existing note when adding.
+	Force bool
+}
+
+// Returns the full name of the notes ref that opts refers to.
+func (opts NotesOptions) notesRef(c *Client) RefSpec {
+	ref := opts.Ref
+	if ref == "" {
+		ref = os.Getenv("GIT_NOTES_REF")
+	}
+	if ref == "" {
+		ref = c.GetConfig("core.notesref")
+	}
+	if ref == "" {
+		ref = "refs/notes/commits"
+	}
+	switch {
+	case strings.HasPrefix(ref, "refs/notes/"):
+	case strings.HasPrefix(ref, "notes/"):
+		ref = "refs/" + ref
+	default:
+		ref = "refs/notes/" + ref
+	}
+	return RefSpec(ref)
+}
+
+// Reads the notes in the notes ref into a map of annotated object to
+// the blob that holds its note. The commit that the notes ref pointed to
+// is also returned, or the zero value if there are no notes yet.
+func readNotes(c *Client, opts NotesOptions) (map[Sha1]Sha1, CommitID, error) {
+	notes := make(map[Sha1]Sha1)
+	ref := opts.notesRef(c)
+	if !ref.File(c).Exists() {
+		return notes, CommitID{}, nil
+	}
+	cmt, err := ref.CommitID(c)
+	if err != nil {
+		return nil, CommitID{}, err
+	}
+	tree, err := cmt.TreeID(c)
+	if err != nil {
+		return nil, CommitID{}, err
+	}
+	entries, err := tree.GetAllObjects(c, "", true, false)
+	if err != nil {
+		return nil, CommitID{}, err
+	}
+	for path, entry := range entries {
+		if entry.FileMode == ModeTree {
+			continue
+		}
+		// Notes may be fanned out to any depth, so the name of the
+		// object is the path with the directory separators removed.
+		// Anything else in the tree isn't a note and is ignored.
+		name := strings.Replace(path.String(), "/", "", -1)
+		if len(name) != 40 {
+			continue
+		}
+		obj, err := Sha1FromString(name)
+		if err != nil {
+			continue
+		}
+		notes[obj] = entry.Sha1
+	}
+	return notes, cmt, nil
+}
+
+// Determines whether notes named by the hex strings names, which all share
+// the same first 2*level characters, should be fanned out into another
+// level of subtrees. This uses the same heuristic as git: if every possible
+// next hex digit is shared by at least 2 notes, a flat tree is getting
+// crowded enough to be worth splitting into 2/38 (or 2/2/36, etc) paths.
+func notesNeedFanout(names []string, level int) bool {
+	if 2*level+2 >= 40 {
+		return false
+	}
+	var counts [16]int
+	for _, name := range names {
+		switch d := name[2*level]; {
+		case d >= '0' && d <= '9':
+			counts[d-'0']++
+		case d >= 'a' && d <= 'f':
+			counts[d-'a'+10]++
+		}
+	}
+	for _, n := range counts {
+		if n < 2 {
+			return false
+		}
+	}
+	return true
+}
+
+// Calculates the paths for the notes named by names in a notes tree, using
+// fanout subtrees where necessary.
+func notesLayout(names []string, prefix string, level int) []string {
+	if !notesNeedFanout(names, level) {
+		paths := make([]string, 0, len(names))
+		for _, name := range names {
+			paths = append(paths, prefix+name[2*level:])
+		}
+		return paths
+	}
+	groups := make(map[string][]string)
+	for _, name := range names {
+		dir := name[2*level : 2*level+2]
+		groups[dir] = append(groups[dir], name)
+	}
+	var paths []string
+	for dir, group := range groups {
+		paths = append(paths, notesLayout(group, prefix+dir+"/", level+1)...)
+	}
+	return paths
+}
+
+// Writes the notes in notes to a new tree and commits it to the notes ref,
+// with parent being the previous value of the notes ref.
+func writeNotes(c *Client, opts NotesOptions, notes map[Sha1]Sha1, parent CommitID, message string) error {
+	names := make([]string, 0, len(notes))
+	for obj := range notes {
+		names = append(names, obj.String())
+	}
+	sort.Strings(names)
+
+	var entries []*IndexEntry
+	for _, path := range notesLayout(names, "", 0) {
+		obj, err := Sha1FromString(strings.Replace(path, "/", "", -1))
+		if err != nil {
+			return err
+		}
+		entry := &IndexEntry{PathName: IndexPath(path)}
+		entry.Mode = ModeBlob
+		entry.Sha1 = notes[obj]
+		entries = append(entries, entry)
+	}
+	sort.Sort(ByPath(entries))
+	tree, err := writeTree(c, "", entries)
+	if err != nil {
+		return err
+	}
+
+	var parents []CommitID
+	if parent != (CommitID{}) {
+		parents = append(parents, parent)
+	}
+	cmt, err := CommitTree(c, CommitTreeOptions{}, tree, parents, message+"\n")
+	if err != nil && err != NoGlobalConfig {
+		return err
+	}
+	return UpdateRefSpec(
+		c,
+		UpdateRefOptions{OldValue: parent, CreateReflog: true},
+		opts.notesRef(c),
+		cmt,
+		"notes: "+message,
+	)
+}
+
+// NotesAdd adds a note containing message to the object. If there is
+// already a note for object, it will return an error unless opts.Force
+// is set.
+func NotesAdd(c *Client, opts NotesOptions, object Sha1, message string) error {
+	notes, parent, err := readNotes(c, opts)
+	if err != nil {
+		return err
+	}
+	if _, ok := notes[object]; ok && !opts.Force {
+		return fmt.Errorf("Cannot add notes. Found existing notes for object %v. Use '-f' to overwrite existing notes", object)
+	}
+	cleaned := CommitMessage(message).whitespace()
+	blob, err := c.WriteObject("blob", []byte(cleaned))
+	if err != nil {
+		return err
+	}
+	notes[object] = blob
+	return writeNotes(c, opts, notes, parent, "Notes added by 'git notes add'")
+}
+
+// NotesShow returns the note for object. If there is no note it returns
+// an error.
+func NotesShow(c *Client, opts NotesOptions, object Sha1) (string, error) {
+	notes, _, err := readNotes(c, opts)
+	if err != nil {
+		return "", err
+	}
+	blob, ok := notes[object]
+	if !ok {
+		return "", fmt.Errorf("no note found for object %v.", object)
+	}
+	obj, err := c.GetObject(blob)
+	if err != nil {
+		return "", err
+	}
+	return string(obj.GetContent()), nil
+}
+
+// NotesRemove removes the note for object.
+func NotesRemove(c *Client, opts NotesOptions, object Sha1) error {
+	notes, parent, err := readNotes(c, opts)
+	if err != nil {
+		return err
+	}
+	if _, ok := notes[object]; !ok {
+		return fmt.Errorf("Object %v has no note", object)
+	}
+	delete(notes, object)
+	return writeNotes(c, opts, notes, parent, "Notes removed by 'git notes remove'")
+}
+
+// A Note is the note attached to an object.
+type Note struct {
+	// The object which the note is attached to.
+	Object Sha1
+
+	// The blob containing the note.
+	Blob Sha1
+}
+
+// NotesList returns the note of every object which has one, sorted by the
+// object.
+func NotesList(c *Client, opts NotesOptions) ([]Note, error) {
+	notes, _, err := readNotes(c, opts)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Note, 0, len(notes))
+	for obj, blob := range notes {
+		list = append(list, Note{obj, blob})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].Object[:], list[j].Object[:]) < 0
+	})
+	return list, nil
+}
+
+// Notes are the notes in a notes ref, which are read once so that the
+// notes of every commit that a command prints can be looked up.
+type Notes struct {
+	ref   RefSpec
+	notes map[Sha1]Sha1
+}
+
+// ReadNotes reads the notes in the notes ref of opts.
+func ReadNotes(c *Client, opts NotesOptions) (Notes, error) {
+	notes, _, err := readNotes(c, opts)
+	if err != nil {
+		return Notes{}, err
+	}
+	return Notes{ref: opts.notesRef(c), notes: notes}, nil
+}
+
+// FormatNotes returns the note for the commit from notes in the format
+// that it's appended to commits by "git log" and "git show", or the empty
+// string if there's no note.
+func (cmt CommitID) FormatNotes(c *Client, notes Notes) (string, error) {
+	blob, ok := notes.notes[Sha1(cmt)]
+	if !ok {
+		return "", nil
+	}
+	obj, err := c.GetObject(blob)
+	if err != nil {
+		return "", err
+	}
+	output := "Notes:\n"
+	ref := notes.ref
+	if ref != "refs/notes/commits" {
+		output = fmt.Sprintf("Notes (%v):\n", strings.TrimPrefix(ref.String(), "refs/notes/"))
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(obj.GetContent()), "\n"), "\n") {
+		output += "    " + line + "\n"
+	}
+	return output + "\n", nil
+}
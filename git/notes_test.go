@@ -0,0 +1,136 @@
+package git
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestNotesAddShowRemove(t *testing.T) {
+	c, cleanup := testRepo(t, "gitnotes")
+	defer cleanup()
+
+	cid := testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	obj := Sha1(cid)
+
+	if _, err := NotesShow(c, NotesOptions{}, obj); err == nil {
+		t.Error("Expected error showing note for object with no note")
+	}
+	if err := NotesAdd(c, NotesOptions{}, obj, "A note"); err != nil {
+		t.Fatal(err)
+	}
+	note, err := NotesShow(c, NotesOptions{}, obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note != "A note\n" {
+		t.Errorf("Unexpected note: got %q want %q", note, "A note\n")
+	}
+	if !c.GitDir.File("refs/notes/commits").Exists() {
+		t.Error("Notes were not stored in refs/notes/commits")
+	}
+
+	// Adding without force shouldn't overwrite the existing note.
+	if err := NotesAdd(c, NotesOptions{}, obj, "Another note"); err == nil {
+		t.Error("Expected error overwriting note without force")
+	}
+	if note, _ := NotesShow(c, NotesOptions{}, obj); note != "A note\n" {
+		t.Errorf("Note was overwritten without force: got %q", note)
+	}
+	if err := NotesAdd(c, NotesOptions{Force: true}, obj, "Another note"); err != nil {
+		t.Fatal(err)
+	}
+	if note, _ := NotesShow(c, NotesOptions{}, obj); note != "Another note\n" {
+		t.Errorf("Note was not overwritten with force: got %q", note)
+	}
+
+	// Other notes refs are independent
+	if err := NotesAdd(c, NotesOptions{Ref: "review"}, obj, "Looks good"); err != nil {
+		t.Fatal(err)
+	}
+	if note, _ := NotesShow(c, NotesOptions{Ref: "refs/notes/review"}, obj); note != "Looks good\n" {
+		t.Errorf("Unexpected note in review notes: got %q", note)
+	}
+
+	notes, err := ReadNotes(c, NotesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted, err := cid.FormatNotes(c, notes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted != "Notes:\n    Another note\n\n" {
+		t.Errorf("Unexpected formatted notes: got %q", formatted)
+	}
+
+	if err := NotesRemove(c, NotesOptions{}, obj); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NotesShow(c, NotesOptions{}, obj); err == nil {
+		t.Error("Note still exists after removal")
+	}
+	if err := NotesRemove(c, NotesOptions{}, obj); err == nil {
+		t.Error("Expected error removing non-existent note")
+	}
+}
+
+func TestNotesFanout(t *testing.T) {
+	var few, many []string
+	for i := 0; i < 300; i++ {
+		name := fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("%d", i))))
+		if i < 10 {
+			few = append(few, name)
+		}
+		many = append(many, name)
+	}
+	sort.Strings(few)
+	for _, path := range notesLayout(few, "", 0) {
+		if strings.Contains(path, "/") {
+			t.Errorf("Unexpected fanout for small number of notes: %v", path)
+		}
+	}
+
+	paths := notesLayout(many, "", 0)
+	if len(paths) != len(many) {
+		t.Fatalf("Unexpected number of paths: got %v want %v", len(paths), len(many))
+	}
+	for _, path := range paths {
+		if len(path) != 41 || path[2] != '/' {
+			t.Errorf("Expected 2/38 fanout for large number of notes, got %v", path)
+		}
+	}
+
+	// Notes written with a fanout must be read back the same.
+	c, cleanup := testRepo(t, "gitnotesfanout")
+	defer cleanup()
+	notes := make(map[Sha1]Sha1)
+	blob, err := c.WriteObject("blob", []byte("note\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range many {
+		obj, _ := Sha1FromString(name)
+		notes[obj] = blob
+	}
+	if err := writeNotes(c, NotesOptions{}, notes, CommitID{}, "Notes added by test"); err != nil {
+		t.Fatal(err)
+	}
+	read, err := NotesList(c, NotesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(many) {
+		t.Fatalf("Unexpected number of notes read: got %v want %v", len(read), len(many))
+	}
+
+	// The notes are listed in the order of the objects.
+	sort.Strings(many)
+	for i, note := range read {
+		if note.Object.String() != many[i] || note.Blob != blob {
+			t.Errorf("Unexpected note %d: got %v %v want %v %v", i, note.Blob, note.Object, blob, many[i])
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package git
+
+import (
+	"container/list"
+	"log"
+	"sync"
+)
+
+// The default number of bytes of objects kept in memory by a Client, which
+// matches git's default core.deltaBaseCacheLimit.
+const defaultObjectCacheLimit = 96 << 20
+
+// The number of bytes counted for each cached object in addition to its
+// content, to account for the bookkeeping.
+const objectCacheOverhead = 64
+
+// An objectLRU is a cache of the objects that have been read by a Client,
+// which evicts the least recently used objects once the size of their
+// content exceeds the limit. It's safe for concurrent use.
+type objectLRU struct {
+	mu sync.Mutex
+
+	// The maximum number of bytes to cache, and whether it has been
+	// loaded from the config yet.
+	limit       int64
+	limitLoaded bool
+
+	size    int64
+	entries map[shaRef]*list.Element
+	// The cached objects, with the most recently used at the front.
+	order *list.List
+
+	// The number of objects which weren't in the cache and had to be
+	// read from the object store.
+	misses int64
+}
+
+type objectLRUEntry struct {
+	key  shaRef
+	obj  GitObject
+	size int64
+}
+
+// Returns the number of bytes that obj takes up in the cache. Objects which
+// were read for their metadata only don't have any content.
+func objectCacheSize(key shaRef, obj GitObject) int64 {
+	if metaOnly := key.bool; metaOnly {
+		return objectCacheOverhead
+	}
+	return int64(len(obj.GetContent())) + objectCacheOverhead
+}
+
+// Returns the byte budget of the object cache of c, from the
+// core.deltaBaseCacheLimit config. A limit of 0 disables the cache.
+func objectCacheLimit(c *Client) int64 {
+	val := c.GetConfig("core.deltabasecachelimit")
+	if val == "" {
+		return defaultObjectCacheLimit
+	}
+	limit, err := parseConfigSize(val)
+	if err != nil || limit < 0 {
+		log.Printf("Invalid core.deltaBaseCacheLimit %q, using the default\n", val)
+		return defaultObjectCacheLimit
+	}
+	return limit
+}
+
+// Returns the cached object for key, and marks it as the most recently used.
+func (l *objectLRU) get(key shaRef) (GitObject, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		l.misses++
+		return nil, false
+	}
+	l.order.MoveToFront(e)
+	return e.Value.(*objectLRUEntry).obj, true
+}
+
+// Returns the cached object for key without marking it as used, or
+// counting a miss if it isn't cached.
+func (l *objectLRU) peek(key shaRef) (GitObject, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return e.Value.(*objectLRUEntry).obj, true
+}
+
+// Adds obj to the cache under key, evicting the least recently used objects
+// until the cache is within limit. Objects larger than limit aren't cached.
+func (l *objectLRU) add(key shaRef, obj GitObject, limit int64) {
+	size := objectCacheSize(key, obj)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if size > limit {
+		return
+	}
+	if l.entries == nil {
+		l.entries = make(map[shaRef]*list.Element)
+		l.order = list.New()
+	}
+	if e, ok := l.entries[key]; ok {
+		// Another walker read it at the same time.
+		l.order.MoveToFront(e)
+		return
+	}
+	l.entries[key] = l.order.PushFront(&objectLRUEntry{key, obj, size})
+	l.size += size
+	for l.size > limit {
+		oldest := l.order.Back()
+		entry := oldest.Value.(*objectLRUEntry)
+		l.order.Remove(oldest)
+		delete(l.entries, entry.key)
+		l.size -= entry.size
+	}
+}
+
+// Removes every object from the cache.
+func (l *objectLRU) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+	l.order = nil
+	l.size = 0
+}
+
+// Returns the object for key from the object cache of c.
+func (c *Client) cachedObject(key shaRef) (GitObject, bool) {
+	return c.objcache.get(key)
+}
+
+// Stores obj in the object cache of c, if it fits in the budget.
+func (c *Client) cacheObject(key shaRef, obj GitObject) {
+	c.objcache.mu.Lock()
+	if !c.objcache.limitLoaded {
+		c.objcache.mu.Unlock()
+		// Don't hold the lock while the config is being loaded.
+		limit := objectCacheLimit(c)
+		c.objcache.mu.Lock()
+		c.objcache.limit, c.objcache.limitLoaded = limit, true
+	}
+	limit := c.objcache.limit
+	c.objcache.mu.Unlock()
+	c.objcache.add(key, obj, limit)
+}
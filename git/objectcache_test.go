@@ -0,0 +1,136 @@
+package git
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Creates n commits in c, each of which changes one of the files in a
+// small nested tree, and returns the last one.
+func testObjectCacheHistory(tb testing.TB, c *Client, n int) CommitID {
+	tb.Helper()
+	var head CommitID
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("dir%d/sub%d/file%d.txt", i%3, i%2, i%5)
+		head = testCommitFile(tb, c, name, fmt.Sprintf("version %d\n", i), fmt.Sprintf("Commit %d", i))
+	}
+	return head
+}
+
+// Walks every commit reachable from head and every tree of those commits.
+func walkObjectCacheHistory(c *Client, head CommitID) error {
+	for cmt := head; ; {
+		tree, err := cmt.TreeID(c)
+		if err != nil {
+			return err
+		}
+		if _, err := tree.GetAllObjects(c, "", true, false); err != nil {
+			return err
+		}
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return err
+		}
+		if len(parents) == 0 {
+			return nil
+		}
+		cmt = parents[0]
+	}
+}
+
+func TestObjectCacheLimit(t *testing.T) {
+	var l objectLRU
+	blob := func(n int) GitObject {
+		return GitBlobObject{n, make([]byte, n)}
+	}
+	limit := int64(3 * (100 + objectCacheOverhead))
+	for i := 0; i < 4; i++ {
+		l.add(shaRef{Sha1{byte(i)}, false}, blob(100), limit)
+	}
+	if l.size > limit {
+		t.Errorf("Cache size %v exceeds the limit %v", l.size, limit)
+	}
+	if _, ok := l.get(shaRef{Sha1{0}, false}); ok {
+		t.Error("Least recently used object was not evicted")
+	}
+	if _, ok := l.get(shaRef{Sha1{3}, false}); !ok {
+		t.Error("Most recently added object was evicted")
+	}
+
+	// Using an object makes it the most recently used.
+	l.get(shaRef{Sha1{1}, false})
+	l.add(shaRef{Sha1{4}, false}, blob(100), limit)
+	if _, ok := l.get(shaRef{Sha1{1}, false}); !ok {
+		t.Error("Recently used object was evicted")
+	}
+	if _, ok := l.get(shaRef{Sha1{2}, false}); ok {
+		t.Error("Least recently used object was not evicted")
+	}
+
+	l.add(shaRef{Sha1{5}, false}, blob(int(limit)), limit)
+	if _, ok := l.get(shaRef{Sha1{5}, false}); ok {
+		t.Error("Object larger than the limit was cached")
+	}
+
+	// Objects read for their metadata don't have content.
+	l.add(shaRef{Sha1{6}, true}, GitCommitObject{1000, nil}, limit)
+	if _, ok := l.get(shaRef{Sha1{6}, true}); !ok {
+		t.Error("Metadata only object was not cached")
+	}
+}
+
+func TestObjectCacheConcurrent(t *testing.T) {
+	c, cleanup := testRepo(t, "gitobjectcacheconcurrent")
+	defer cleanup()
+	head := testObjectCacheHistory(t, c, 10)
+
+	// Use a budget which is too small for the whole history, so objects
+	// are evicted while they're being walked.
+	client, err := NewClient(c.GitDir.String(), c.WorkDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCachedConfig("core.deltabasecachelimit", "1k")
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = walkObjectCacheHistory(client, head)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Walker %d: %v", i, err)
+		}
+	}
+	if client.objcache.size > 1024 {
+		t.Errorf("Cache size %v exceeds the limit", client.objcache.size)
+	}
+}
+
+func BenchmarkObjectCache(b *testing.B) {
+	c, cleanup := testRepo(b, "gitobjectcachebench")
+	defer cleanup()
+	head := testObjectCacheHistory(b, c, 20)
+
+	for _, limit := range []string{"0", "96m"} {
+		b.Run("core.deltaBaseCacheLimit="+limit, func(b *testing.B) {
+			client, err := NewClient(c.GitDir.String(), c.WorkDir.String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			client.SetCachedConfig("core.deltabasecachelimit", limit)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				if err := walkObjectCacheHistory(client, head); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(client.objcache.misses)/float64(b.N), "inflations/op")
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package git
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/driusan/dgit/zlib"
+)
+
+// A HashAlgorithm is the hash function that a repository names its objects
+// with, from the extensions.objectFormat config. Most of dgit still uses
+// the Sha1 type, so repositories using SHA-256 are refused when they're
+// opened, and SHA-256 objects can only be written by a client whose config
+// was set to use it.
+type HashAlgorithm uint8
+
+const (
+	HashSHA1 HashAlgorithm = iota
+	HashSHA256
+)
+
+// Returns the hash algorithm called name in extensions.objectFormat.
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	switch strings.ToLower(name) {
+	case "", "sha1":
+		return HashSHA1, nil
+	case "sha256":
+		return HashSHA256, nil
+	default:
+		return 0, fmt.Errorf("Unknown object format: %v", name)
+	}
+}
+
+func (h HashAlgorithm) String() string {
+	switch h {
+	case HashSHA1:
+		return "sha1"
+	case HashSHA256:
+		return "sha256"
+	default:
+		return fmt.Sprintf("HashAlgorithm(%d)", uint8(h))
+	}
+}
+
+// Returns the number of bytes in an object ID of the algorithm.
+func (h HashAlgorithm) Size() int {
+	if h == HashSHA256 {
+		return sha256.Size
+	}
+	return sha1.Size
+}
+
+// Returns a new hash of the algorithm.
+func (h HashAlgorithm) New() hash.Hash {
+	if h == HashSHA256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+// Returns the ID of an object of type objType with the content data, which
+// is the hash of its header and content.
+func (h HashAlgorithm) HashObject(objType string, data []byte) ObjectID {
+	hsh := h.New()
+	fmt.Fprintf(hsh, "%s %d\000", objType, len(data))
+	hsh.Write(data)
+	id, _ := ObjectIDFromSlice(h, hsh.Sum(nil))
+	return id
+}
+
+// An ObjectID is the name of an object in the hash algorithm of its
+// repository. The zero value is the zero SHA-1. ObjectIDs can be compared
+// with ==.
+type ObjectID struct {
+	Algorithm HashAlgorithm
+
+	// The ID, padded with zeros after the size of the algorithm.
+	id [sha256.Size]byte
+}
+
+// Returns the ObjectID with the bytes b, which must be the size of an ID
+// of the algorithm h.
+func ObjectIDFromSlice(h HashAlgorithm, b []byte) (ObjectID, error) {
+	if len(b) != h.Size() {
+		return ObjectID{}, fmt.Errorf("Invalid %v object ID %x (Size: %d)", h, b, len(b))
+	}
+	id := ObjectID{Algorithm: h}
+	copy(id.id[:], b)
+	return id, nil
+}
+
+// Parses the hexadecimal object ID s. The hash algorithm is detected from
+// its length, so an ID of either algorithm, such as the value of a ref, can
+// be parsed.
+func ObjectIDFromString(s string) (ObjectID, error) {
+	s = strings.TrimSpace(s)
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return ObjectID{}, err
+	}
+	switch len(b) {
+	case sha1.Size:
+		return ObjectIDFromSlice(HashSHA1, b)
+	case sha256.Size:
+		return ObjectIDFromSlice(HashSHA256, b)
+	default:
+		return ObjectID{}, fmt.Errorf("Invalid object ID %v (Size: %d)", s, len(b))
+	}
+}
+
+// Returns the bytes of the ID.
+func (id ObjectID) Bytes() []byte {
+	return id.id[:id.Algorithm.Size()]
+}
+
+func (id ObjectID) String() string {
+	return hex.EncodeToString(id.Bytes())
+}
+
+// Returns the ID as a Sha1, for the parts of dgit which don't support other
+// hash algorithms yet.
+func (id ObjectID) Sha1() (Sha1, error) {
+	if id.Algorithm != HashSHA1 {
+		return Sha1{}, fmt.Errorf("%v object %v can not be used as a SHA-1", id.Algorithm, id)
+	}
+	return Sha1FromSlice(id.Bytes())
+}
+
+// Returns the SHA-1 s as an ObjectID.
+func (s Sha1) ObjectID() ObjectID {
+	id := ObjectID{Algorithm: HashSHA1}
+	copy(id.id[:], s[:])
+	return id
+}
+
+// Returns the hash algorithm of the objects in the repository of c. As in
+// git, extensions.objectFormat is only used by repositories with
+// core.repositoryFormatVersion 1.
+func (c *Client) HashAlgorithm() HashAlgorithm {
+	if c.GetConfig("core.repositoryformatversion") != "1" {
+		return HashSHA1
+	}
+	h, err := ParseHashAlgorithm(c.GetConfig("extensions.objectformat"))
+	if err != nil {
+		// NewClient refuses to open repositories with an unknown
+		// object format, so this only happens if the cached config
+		// was changed.
+		return HashSHA1
+	}
+	return h
+}
+
+// Returns whether the object id is in the repository of c. Objects named
+// with other algorithms than SHA-1 can only be loose objects for now.
+func (c *Client) haveObjectID(id ObjectID) (bool, error) {
+	if id.Algorithm == HashSHA1 {
+		sha, _ := id.Sha1()
+		have, _, err := c.HaveObject(sha)
+		return have, err
+	}
+	return c.looseObjectIDFile(id).Exists(), nil
+}
+
+// Returns the file that the loose object id is stored in, in the objects
+// directory that new objects are written to.
+func (c *Client) looseObjectIDFile(id ObjectID) File {
+	hexid := id.String()
+	return File(c.objectWriteDir().String() + "/" + hexid[:2] + "/" + hexid[2:])
+}
+
+// WriteObjectID writes a loose object of type objType with the content
+// rawdata, named with the hash algorithm of the repository, and returns its
+// ID.
+func (c *Client) WriteObjectID(objType string, rawdata []byte) (ObjectID, error) {
+	h := c.HashAlgorithm()
+	if h == HashSHA1 {
+		// Objects which are packed already don't need to be written.
+		id, err := c.WriteObject(objType, rawdata)
+		return id.ObjectID(), err
+	}
+	id := h.HashObject(objType, rawdata)
+	file := c.looseObjectIDFile(id)
+	if file.Exists() {
+		return id, nil
+	}
+	obj := append([]byte(fmt.Sprintf("%s %d\000", objType, len(rawdata))), rawdata...)
+	if err := writeLooseObject(file, obj); err != nil {
+		return ObjectID{}, err
+	}
+	return id, nil
+}
+
+// Writes the compressed object obj, which includes its header, to the
+// loose object file. The object is written to a temporary file which is
+// renamed once it's complete, so that a failed write doesn't leave a
+// partial object under its name.
+func writeLooseObject(file File, obj []byte) error {
+	dir := filepath.Dir(file.String())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "tmp_obj_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := zlib.NewWriter(f)
+	if _, err := w.Write(obj); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// Objects are never modified once they're written.
+	if err := os.Chmod(f.Name(), 0444); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file.String())
+}
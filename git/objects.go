@@ -172,13 +172,35 @@ func (t GitTreeObject) String() string {
 	return ret
 }
 
+// Returns the location of the packed object sha1, which must have been
+// found by HaveObject, with the index of its pack loaded.
+func (c *Client) packedObjectLocation(sha1 Sha1) (objectLocation, error) {
+	c.objectsMu.Lock()
+	defer c.objectsMu.Unlock()
+	cacheloc, cached := c.objectCache[sha1]
+	if !cached {
+		panic("Attempt to use pack file before parsing index.")
+	}
+	if cacheloc.index == nil {
+		// It was found through the multi-pack-index, so the pack's
+		// own index hasn't been read yet.
+		idx, err := c.packIndex(cacheloc.packfile)
+		if err != nil {
+			return objectLocation{}, err
+		}
+		cacheloc.index = idx
+		c.objectCache[sha1] = cacheloc
+	}
+	return cacheloc, nil
+}
+
 // Returns the byte array of a packed object from packfile, after
 // resolving any deltas. (packfile should be the base name with no
 // extension.)
 func (c *Client) getPackedObject(packfile File, sha1 Sha1, metaOnly bool) (GitObject, error) {
-	cacheloc, cached := c.objectCache[sha1]
-	if !cached {
-		panic("Attempt to use pack file before parsing index.")
+	cacheloc, err := c.packedObjectLocation(sha1)
+	if err != nil {
+		return nil, err
 	}
 
 	f, err := os.Open((cacheloc.packfile + ".pack").String())
@@ -205,12 +227,120 @@ func (c *Client) GetObjectMetadata(sha1 Sha1) (string, uint64, error) {
 	return obj.GetType(), uint64(obj.GetSize()), nil
 }
 
+// HasObject returns true if the object id is in one of c's object
+// directories. The object isn't read.
+func (c *Client) HasObject(id Sha1) bool {
+	found, _, err := c.HaveObject(id)
+	return err == nil && found
+}
+
+// ObjectType returns the type of the object id ("commit", "tree", "blob"
+// or "tag"), after substituting any replace refs.
+//
+// Only the header of the object is read. For a packed object, the type
+// comes from the header of its pack entry, following any delta to its
+// base, so no object data is inflated.
+func (c *Client) ObjectType(id Sha1) (string, error) {
+	id, err := c.replacementObject(id)
+	if err != nil {
+		return "", err
+	}
+	return c.objectType(id)
+}
+
+// Returns the type of the object id without substituting replace refs.
+// The type is remembered with the location of the object, so it's only
+// read once.
+func (c *Client) objectType(id Sha1) (string, error) {
+	c.objectsMu.Lock()
+	typ := c.objectCache[id].objectType
+	c.objectsMu.Unlock()
+	if typ != "" {
+		return typ, nil
+	}
+	// An object which has already been read knows its type.
+	for _, metaOnly := range []bool{true, false} {
+		if obj, ok := c.objcache.peek(shaRef{id, metaOnly}); ok {
+			return obj.GetType(), nil
+		}
+	}
+
+	typ, err := c.readObjectType(id)
+	if err != nil {
+		return "", err
+	}
+	c.objectsMu.Lock()
+	if loc, ok := c.objectCache[id]; ok {
+		loc.objectType = typ
+		c.objectCache[id] = loc
+	}
+	c.objectsMu.Unlock()
+	return typ, nil
+}
+
+// Reads the type of the object id from its header.
+func (c *Client) readObjectType(id Sha1) (string, error) {
+	found, packfile, err := c.HaveObject(id)
+	if err != nil {
+		return "", err
+	}
+	if !found || packfile == "" {
+		// Loose objects only have their header inflated, and missing
+		// objects may need to be fetched from the promisor remote.
+		obj, err := c.readObject(id, true)
+		if err != nil {
+			return "", err
+		}
+		return obj.GetType(), nil
+	}
+
+	loc, err := c.packedObjectLocation(id)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open((loc.packfile + ".pack").String())
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	t, base, err := packObjectTypeAtOffset(f, loc.offset)
+	if err != nil {
+		return "", err
+	}
+	if t == OBJ_REF_DELTA {
+		// The base may be in another pack, or loose.
+		return c.objectType(base)
+	}
+	return t.String(), nil
+}
+
 func (c *Client) GetObject(sha1 Sha1) (GitObject, error) {
 	return c.getObject(sha1, false)
 }
 
 func (c *Client) getObject(sha1 Sha1, metaOnly bool) (GitObject, error) {
-	if gobj, ok := c.objcache[shaRef{sha1, metaOnly}]; ok {
+	sha1, err := c.replacementObject(sha1)
+	if err != nil {
+		return nil, err
+	}
+	return c.readObject(sha1, metaOnly)
+}
+
+// Returns the file of the loose object sha1, in whichever objects directory
+// HaveObject found it in.
+func (c *Client) looseObjectFile(sha1 Sha1) File {
+	c.objectsMu.Lock()
+	dir := c.objectCache[sha1].objectDir
+	c.objectsMu.Unlock()
+	if dir == "" {
+		dir = c.GitDir.File("objects")
+	}
+	return File(fmt.Sprintf("%s/%x/%x", dir, sha1[0:1], sha1[1:]))
+}
+
+// Reads the object sha1 without substituting any replace refs.
+func (c *Client) readObject(sha1 Sha1, metaOnly bool) (GitObject, error) {
+	if gobj, ok := c.cachedObject(shaRef{sha1, metaOnly}); ok {
 		// FIXME: We should determine why this is attempting to retrieve the
 		// same things multiple times and fix the source.
 		return gobj, nil
@@ -221,7 +351,21 @@ func (c *Client) getObject(sha1 Sha1, metaOnly bool) (GitObject, error) {
 	}
 
 	if found == false {
-		return nil, fmt.Errorf("Object not found.")
+		// In a partial clone, the object may have been omitted by the
+		// filter and can be fetched from the promisor remote.
+		fetched, err := c.fetchPromisedObject(sha1)
+		if err != nil {
+			return nil, err
+		}
+		if fetched {
+			found, packfile, err = c.HaveObject(sha1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if found == false {
+			return nil, fmt.Errorf("Object not found.")
+		}
 	}
 
 	var b []byte
@@ -230,11 +374,10 @@ func (c *Client) getObject(sha1 Sha1, metaOnly bool) (GitObject, error) {
 		if err != nil {
 			return nil, err
 		}
-		c.objcache[shaRef{sha1, metaOnly}] = gobj
+		c.cacheObject(shaRef{sha1, metaOnly}, gobj)
 		return gobj, nil
 	} else {
-		objectname := fmt.Sprintf("%s/objects/%x/%x", c.GitDir, sha1[0:1], sha1[1:])
-		f, err := os.Open(objectname)
+		f, err := os.Open(c.looseObjectFile(sha1).String())
 		if err != nil {
 			return nil, err
 		}
@@ -288,7 +431,7 @@ func (c *Client) getObject(sha1 Sha1, metaOnly bool) (GitObject, error) {
 			}
 		}
 		gobj := GitBlobObject{size, content}
-		c.objcache[shaRef{sha1, metaOnly}] = gobj
+		c.cacheObject(shaRef{sha1, metaOnly}, gobj)
 		return gobj, nil
 	} else if strings.HasPrefix(string(b), "commit ") {
 		var size int
@@ -303,7 +446,7 @@ func (c *Client) getObject(sha1 Sha1, metaOnly bool) (GitObject, error) {
 			}
 		}
 		gobj := GitCommitObject{size, content}
-		c.objcache[shaRef{sha1, metaOnly}] = gobj
+		c.cacheObject(shaRef{sha1, metaOnly}, gobj)
 		return gobj, nil
 	} else if strings.HasPrefix(string(b), "tree ") {
 		var size int
@@ -318,7 +461,7 @@ func (c *Client) getObject(sha1 Sha1, metaOnly bool) (GitObject, error) {
 			}
 		}
 		gobj := GitTreeObject{size, content}
-		c.objcache[shaRef{sha1, metaOnly}] = gobj
+		c.cacheObject(shaRef{sha1, metaOnly}, gobj)
 		return gobj, nil
 	} else if strings.HasPrefix(string(b), "tag ") {
 		var size int
@@ -333,7 +476,7 @@ func (c *Client) getObject(sha1 Sha1, metaOnly bool) (GitObject, error) {
 			}
 		}
 		gobj := GitTagObject{size, content}
-		c.objcache[shaRef{sha1, metaOnly}] = gobj
+		c.cacheObject(shaRef{sha1, metaOnly}, gobj)
 		return gobj, nil
 	}
 	return nil, InvalidObject
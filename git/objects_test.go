@@ -0,0 +1,237 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// Writes the header of a pack entry of type t with size sz to buf.
+func testPackEntryHeader(buf *bytes.Buffer, t PackEntryType, sz int) {
+	b := byte(t)<<4 | byte(sz&0x0f)
+	for sz >>= 4; sz != 0; sz >>= 7 {
+		buf.WriteByte(b | 0x80)
+		b = byte(sz & 0x7f)
+	}
+	buf.WriteByte(b)
+}
+
+// Writes the zlib compressed data to buf.
+func testPackEntryData(tb testing.TB, buf *bytes.Buffer, data []byte) {
+	tb.Helper()
+	z := zlib.NewWriter(buf)
+	if _, err := z.Write(data); err != nil {
+		tb.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// Adds a pack to c containing the blob base and an OFS_DELTA against it
+// which appends suffix, and returns the ids of the two blobs.
+func testDeltaPack(tb testing.TB, c *Client, base []byte, suffix string) (Sha1, Sha1) {
+	tb.Helper()
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+
+	baseOffset := pack.Len()
+	testPackEntryHeader(&pack, OBJ_BLOB, len(base))
+	testPackEntryData(tb, &pack, base)
+
+	// The delta copies all of the base and then inserts the suffix.
+	var delta bytes.Buffer
+	for _, sz := range []int{len(base), len(base) + len(suffix)} {
+		for ; sz >= 0x80; sz >>= 7 {
+			delta.WriteByte(byte(sz) | 0x80)
+		}
+		delta.WriteByte(byte(sz))
+	}
+	copyop := byte(0x80)
+	var copysize []byte
+	for i := uint(0); i < 3; i++ {
+		if b := byte(len(base) >> (8 * i)); b != 0 {
+			copyop |= 0x10 << i
+			copysize = append(copysize, b)
+		}
+	}
+	delta.WriteByte(copyop)
+	delta.Write(copysize)
+	delta.WriteByte(byte(len(suffix)))
+	delta.WriteString(suffix)
+
+	deltaOffset := pack.Len()
+	testPackEntryHeader(&pack, OBJ_OFS_DELTA, delta.Len())
+	// The offset to the base is big endian, with 1 subtracted from each
+	// byte but the last.
+	n := deltaOffset - baseOffset
+	offset := []byte{byte(n & 0x7f)}
+	for n >>= 7; n != 0; n >>= 7 {
+		n--
+		offset = append([]byte{byte(n&0x7f) | 0x80}, offset...)
+	}
+	pack.Write(offset)
+	testPackEntryData(tb, &pack, delta.Bytes())
+
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+	if _, err := IndexAndCopyPack(c, IndexPackOptions{}, &pack); err != nil {
+		tb.Fatal(err)
+	}
+
+	baseID, _, err := HashSlice("blob", base)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	deltaID, _, err := HashSlice("blob", append(append([]byte{}, base...), suffix...))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return baseID, deltaID
+}
+
+func TestObjectType(t *testing.T) {
+	c, cleanup := testRepo(t, "gitobjecttype")
+	defer cleanup()
+	cmt := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+	base, delta := testDeltaPack(t, c, bytes.Repeat([]byte("base\n"), 100), "delta\n")
+
+	tree, err := cmt.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		id   Sha1
+		want string
+	}{
+		{Sha1(cmt), "commit"},
+		{Sha1(tree), "tree"},
+		{base, "blob"},
+		{delta, "blob"},
+	} {
+		if !c.HasObject(tc.id) {
+			t.Errorf("HasObject(%v) = false, want true", tc.id)
+		}
+		if got, err := c.ObjectType(tc.id); err != nil || got != tc.want {
+			t.Errorf("ObjectType(%v) = %v (%v), want %v", tc.id, got, err, tc.want)
+		}
+	}
+
+	missing := Sha1{1, 2, 3}
+	if c.HasObject(missing) {
+		t.Errorf("HasObject(%v) = true for a missing object", missing)
+	}
+	if _, err := c.ObjectType(missing); err == nil {
+		t.Errorf("ObjectType(%v) did not return an error for a missing object", missing)
+	}
+}
+
+// Compares getting the type of a large deltified blob with ObjectType,
+// which only reads the pack entry headers, against GetObjectMetadata,
+// which has to resolve the delta to get the size.
+func BenchmarkObjectType(b *testing.B) {
+	c, cleanup := testRepo(b, "gitobjecttypebench")
+	defer cleanup()
+	_, delta := testDeltaPack(b, c, bytes.Repeat([]byte("0123456789abcdef"), 1<<16), "delta\n")
+
+	for _, bc := range []struct {
+		name string
+		typ  func(*Client) (string, error)
+	}{
+		{"ObjectType", func(c *Client) (string, error) {
+			return c.ObjectType(delta)
+		}},
+		{"GetObjectMetadata", func(c *Client) (string, error) {
+			typ, _, err := c.GetObjectMetadata(delta)
+			return typ, err
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			client, err := NewClient(c.GitDir.String(), c.WorkDir.String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			// Nothing is cached between iterations.
+			client.SetCachedConfig("core.deltabasecachelimit", "0")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				if typ, err := bc.typ(client); err != nil || typ != "blob" {
+					b.Fatalf("Unexpected type %v (%v)", typ, err)
+				}
+			}
+			b.ReportMetric(float64(client.objcache.misses)/float64(b.N), "inflations/op")
+		})
+	}
+}
+
+// Tests that ObjectType only reads the header of an object once, and then
+// remembers its type.
+func TestObjectTypeCached(t *testing.T) {
+	c, cleanup := testRepo(t, "gitobjecttypecached")
+	defer cleanup()
+	testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+	blob, _, err := HashSlice("blob", []byte("foo\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, delta := testDeltaPack(t, c, bytes.Repeat([]byte("base\n"), 100), "delta\n")
+
+	client, err := NewClient(c.GitDir.String(), c.WorkDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []Sha1{blob, delta} {
+		if typ := id.Type(client); typ != "blob" {
+			t.Errorf("Unexpected type of %v: got %v want blob", id, typ)
+		}
+		if _, ok := client.objcache.peek(shaRef{id, false}); ok {
+			t.Errorf("Object %v was fully read to get its type", id)
+		}
+	}
+	if client.objcache.misses > 1 {
+		t.Errorf("Unexpected number of objects read: got %v want 1 (the header of %v)", client.objcache.misses, blob)
+	}
+
+	// The type doesn't need to be read again.
+	if err := client.looseObjectFile(blob).Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if typ, err := client.ObjectType(blob); err != nil || typ != "blob" {
+		t.Errorf("Type of %v was not cached: got %v (%v)", blob, typ, err)
+	}
+}
+
+// Benchmarks "show-ref --dereference" in a repository with many annotated
+// tags, where the type of every ref is needed.
+func BenchmarkShowRefDereference(b *testing.B) {
+	c, cleanup := testRepo(b, "gitshowrefbench")
+	defer cleanup()
+	testCommitFile(b, c, "foo.txt", "foo\n", "First commit")
+	for i := 0; i < 50; i++ {
+		if err := TagCommit(c, TagOptions{Annotated: true}, fmt.Sprintf("v%d", i), nil, "Tag\n"); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	client, err := NewClient(c.GitDir.String(), c.WorkDir.String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		refs, err := ShowRef(client, ShowRefOptions{Dereference: true}, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(refs) != 101 {
+			b.Fatalf("Unexpected number of refs: got %v want 101", len(refs))
+		}
+	}
+}
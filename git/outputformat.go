@@ -0,0 +1,118 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An OutputFormat is a way of rendering the structured result of a command.
+type OutputFormat uint8
+
+const (
+	// Human readable output, as printed by default. It may change
+	// between versions.
+	OutputHuman = OutputFormat(iota)
+
+	// Machine readable output, as printed with --porcelain. Each record
+	// is terminated by a newline, and paths which need it are quoted.
+	// Unlike OutputHuman, it doesn't change between versions.
+	OutputPorcelain
+
+	// Machine readable output, as printed with -z. It's the same as
+	// OutputPorcelain, except that records are terminated by a NUL and
+	// paths are never quoted.
+	OutputNullTerminated
+)
+
+// A Renderer is the result of a command, which can be printed in any
+// OutputFormat.
+type Renderer interface {
+	Render(w io.Writer, format OutputFormat) error
+}
+
+// Returns the string which terminates each record in the format.
+func (f OutputFormat) Terminator() string {
+	if f == OutputNullTerminated {
+		return "\000"
+	}
+	return "\n"
+}
+
+// Returns path as it should be printed in the format. Paths with special
+// characters are quoted the way git quotes them, unless the output is NUL
+// terminated.
+func (f OutputFormat) Path(path string) string {
+	if f == OutputNullTerminated {
+		return path
+	}
+	return quotePath(path)
+}
+
+// Returns path as git status --short and --porcelain=v1 print it. This is
+// the same as Path, except that paths containing a space are quoted too.
+func (f OutputFormat) statusPath(path string) string {
+	if f == OutputNullTerminated {
+		return path
+	}
+	return quoteStatusPath(path)
+}
+
+// Quotes path as a C-style string if it contains a quote, backslash,
+// control character or non-ASCII byte, the same way that git does with
+// the default core.quotePath. Other paths are returned unchanged.
+func quotePath(path string) string {
+	return quotePathIf(path, false)
+}
+
+// Quotes path the same way as quotePath, and also if it contains a space,
+// as git status does in its short formats and fast-export does in its file
+// commands, so that they can be split on spaces.
+func quoteStatusPath(path string) string {
+	return quotePathIf(path, true)
+}
+
+func quotePathIf(path string, space bool) string {
+	needsQuote := false
+	for i := 0; i < len(path); i++ {
+		if b := path[i]; b < 0x20 || b >= 0x7f || b == '"' || b == '\\' || (space && b == ' ') {
+			needsQuote = true
+			break
+		}
+	}
+	if !needsQuote {
+		return path
+	}
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(path); i++ {
+		switch b := path[i]; b {
+		case '\a':
+			quoted.WriteString(`\a`)
+		case '\b':
+			quoted.WriteString(`\b`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\v':
+			quoted.WriteString(`\v`)
+		case '\f':
+			quoted.WriteString(`\f`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		case '"':
+			quoted.WriteString(`\"`)
+		case '\\':
+			quoted.WriteString(`\\`)
+		default:
+			if b < 0x20 || b >= 0x7f {
+				fmt.Fprintf(&quoted, `\%03o`, b)
+			} else {
+				quoted.WriteByte(b)
+			}
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
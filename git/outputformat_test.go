@@ -0,0 +1,139 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestQuotePath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"foo.txt", "foo.txt"},
+		{"dir/with space", "dir/with space"},
+		{"tab\there", `"tab\there"`},
+		{`quote"d`, `"quote\"d"`},
+		{`back\slash`, `"back\\slash"`},
+		{"caf\xc3\xa9", `"caf\303\251"`},
+	}
+	for _, tc := range tests {
+		if got := quotePath(tc.path); got != tc.want {
+			t.Errorf("quotePath(%q): got %v want %v", tc.path, got, tc.want)
+		}
+	}
+
+	// git status quotes spaces in its short formats too.
+	for path, want := range map[string]string{
+		"foo.txt":         "foo.txt",
+		"dir/with space":  `"dir/with space"`,
+		"tab\there space": `"tab\there space"`,
+	} {
+		if got := quoteStatusPath(path); got != want {
+			t.Errorf("quoteStatusPath(%q): got %v want %v", path, got, want)
+		}
+	}
+}
+
+// Tests that the same status result renders relative to the current
+// directory for humans, and relative to the root of the work tree with
+// quoted or NUL terminated paths for the machine readable formats.
+func TestStatusResultRender(t *testing.T) {
+	result := StatusResult{
+		Branch: "## master",
+		Entries: []StatusEntry{
+			{Index: 'M', WorkTree: ' ', Path: "sub/foo.txt"},
+			{Index: ' ', WorkTree: 'D', Path: "bar.txt"},
+			{Index: 'U', WorkTree: 'U', Path: "sub/tab\tname"},
+			{Index: '?', WorkTree: '?', Path: "sub/new/"},
+			{Index: '?', WorkTree: '?', Path: "with space"},
+		},
+		PathPrefix: "sub/",
+	}
+	tests := []struct {
+		format OutputFormat
+		want   string
+	}{
+		{OutputHuman, "## master\nM  foo.txt\n D ../bar.txt\nUU \"tab\\tname\"\n?? new/\n?? \"../with space\"\n"},
+		{OutputPorcelain, "## master\nM  sub/foo.txt\n D bar.txt\nUU \"sub/tab\\tname\"\n?? sub/new/\n?? \"with space\"\n"},
+		{OutputNullTerminated, "## master\000M  sub/foo.txt\000 D bar.txt\000UU sub/tab\tname\000?? sub/new/\000?? with space\000"},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		if err := result.Render(&buf, tc.format); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("Format %d: got %q want %q", tc.format, got, tc.want)
+		}
+	}
+}
+
+// Tests that Status with --short and --porcelain render the structured
+// status of a repository the same way, except for the paths.
+func TestShortStatusFormats(t *testing.T) {
+	c, cleanup := testRepo(t, "gitstatusformat")
+	defer cleanup()
+	head := testCommitFile(t, c, "sub/foo.txt", "foo\n", "Add foo")
+	if err := ioutil.WriteFile("sub/bar.txt", []byte("bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"sub/bar.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("sub/new.txt", []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	short, err := Status(c, StatusOptions{Short: true, PathPrefix: "sub/", UntrackedMode: StatusUntrackedAll}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "A  bar.txt\n?? new.txt\n"; short != want {
+		t.Errorf("Unexpected short status: got %q want %q", short, want)
+	}
+	porcelain, err := Status(c, StatusOptions{Porcelain: 1, PathPrefix: "sub/", UntrackedMode: StatusUntrackedAll}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "A  sub/bar.txt\n?? sub/new.txt\n"; porcelain != want {
+		t.Errorf("Unexpected porcelain status: got %q want %q", porcelain, want)
+	}
+
+	v2, err := Status(c, StatusOptions{Porcelain: 2, Branch: true, PathPrefix: "sub/", UntrackedMode: StatusUntrackedAll}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# branch.oid " + head.String() + "\n# branch.head master\n" +
+		"1 A. N... 000000 100644 100644 0000000000000000000000000000000000000000 " + hashString("bar\n").String() + " sub/bar.txt\n" +
+		"? sub/new.txt\n"
+	if v2 != want {
+		t.Errorf("Unexpected porcelain v2 status: got %q want %q", v2, want)
+	}
+}
+
+// A writer which fails every write, and counts them.
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("write failed")
+}
+
+// Tests that rendering a diff stops at the first failed write, and returns
+// its error.
+func TestHashDiffRenderError(t *testing.T) {
+	h := HashDiff{Name: "new.txt", SrcName: "old.txt", Similarity: 100}
+	for _, format := range []OutputFormat{OutputPorcelain, OutputNullTerminated} {
+		var w failingWriter
+		if err := h.Render(&w, format); err == nil {
+			t.Errorf("Format %d: no error from a failed write", format)
+		}
+		if w.writes != 1 {
+			t.Errorf("Format %d: got %d writes want 1", format, w.writes)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
 
 	"github.com/driusan/dgit/zlib"
 )
@@ -52,8 +53,9 @@ func (t PackEntryType) String() string {
 
 // Readers a packfile entry header from r, and returns the type of packfile,
 // the size from the header, optionally a reference or file offset (for deltas
-// only), and any data read from the io stream.
-func (p PackfileHeader) ReadHeaderSize(r io.Reader) (PackEntryType, PackEntrySize, Sha1, ObjectOffset, []byte) {
+// only), and any data read from the io stream. An error is returned if the
+// header couldn't be read, such as when the pack is truncated.
+func (p PackfileHeader) ReadHeaderSize(r io.Reader) (PackEntryType, PackEntrySize, Sha1, ObjectOffset, []byte, error) {
 	b := make([]byte, 1)
 	var i uint
 	var size PackEntrySize
@@ -65,8 +67,8 @@ func (p PackfileHeader) ReadHeaderSize(r io.Reader) (PackEntryType, PackEntrySiz
 	// headers should be less than 32 bytes.
 	dataread := make([]byte, 0, 32)
 	for {
-		if _, err := r.Read(b); err != nil {
-			panic(err)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, 0, Sha1{}, 0, dataread, fmt.Errorf("Could not read pack entry header: %v", err)
 		}
 		dataread = append(dataread, b...)
 		if i == 0 {
@@ -80,7 +82,6 @@ func (p PackfileHeader) ReadHeaderSize(r io.Reader) (PackEntryType, PackEntrySiz
 			case OBJ_TREE:
 			case OBJ_BLOB:
 			case OBJ_TAG:
-				fmt.Printf("Tag!\n")
 			case OBJ_OFS_DELTA:
 			case OBJ_REF_DELTA:
 			}
@@ -108,23 +109,23 @@ func (p PackfileHeader) ReadHeaderSize(r io.Reader) (PackEntryType, PackEntrySiz
 	case OBJ_REF_DELTA:
 		n, err := io.ReadFull(r, refDelta)
 		if err != nil {
-			panic(err)
-		}
-		if n != 20 {
-			panic(fmt.Sprintf("Could not read refDelta base. Got %v (%x) instead of 20 bytes", n, refDelta[:n]))
+			return entrytype, size, Sha1{}, 0, dataread, fmt.Errorf("Could not read refDelta base. Got %v (%x) instead of 20 bytes: %v", n, refDelta[:n], err)
 		}
 		dataread = append(dataread, refDelta...)
 		sha, err := Sha1FromSlice(refDelta)
 		if err != nil {
-			panic(err)
+			return entrytype, size, Sha1{}, 0, dataread, err
 		}
-		return entrytype, size, sha, 0, dataread
+		return entrytype, size, sha, 0, dataread, nil
 	case OBJ_OFS_DELTA:
-		deltaOffset, raw := ReadDeltaOffset(r)
+		deltaOffset, raw, err := ReadDeltaOffset(r)
 		dataread = append(dataread, raw...)
-		return entrytype, size, Sha1{}, ObjectOffset(deltaOffset), dataread
+		if err != nil {
+			return entrytype, size, Sha1{}, 0, dataread, fmt.Errorf("Could not read OFS_DELTA offset: %v", err)
+		}
+		return entrytype, size, Sha1{}, ObjectOffset(deltaOffset), dataread, nil
 	}
-	return entrytype, size, Sha1{}, 0, dataread
+	return entrytype, size, Sha1{}, 0, dataread, nil
 }
 
 // This is a hack to ensure zlib only reads 1 byte at a time and
@@ -241,24 +242,31 @@ func (v VariableLengthInt) WriteVariable(w io.Writer, typ PackEntryType) error {
 }
 
 // Reads a delta offset from the io.Reader, and returns both the value
-// and the list of bytes consumed from the reader.
-func ReadDeltaOffset(src io.Reader) (uint64, []byte) {
+// and the list of bytes consumed from the reader. It's an error if the
+// offset is truncated or doesn't fit in a uint64.
+func ReadDeltaOffset(src io.Reader) (uint64, []byte, error) {
 	b := make([]byte, 1)
 	consumed := make([]byte, 0, 32)
-	var val uint64
-	src.Read(b)
+	if _, err := io.ReadFull(src, b); err != nil {
+		return 0, consumed, err
+	}
 	consumed = append(consumed, b...)
-	val = uint64(b[0] & 127)
-	for i := 0; b[0]&128 != 0; i++ {
+	val := uint64(b[0] & 127)
+	for b[0]&128 != 0 {
+		if val+1 > math.MaxUint64>>7 {
+			return 0, consumed, InvalidObject
+		}
 		val += 1
 		if debug {
 			fmt.Printf("%x ", b)
 		}
-		src.Read(b)
+		if _, err := io.ReadFull(src, b); err != nil {
+			return 0, consumed, err
+		}
 		consumed = append(consumed, b...)
 		val = (val << 7) + uint64(b[0]&127)
 	}
-	return val, consumed
+	return val, consumed, nil
 }
 func ReadVariable(src io.Reader) uint64 {
 	b := make([]byte, 1)
@@ -0,0 +1,115 @@
+package git
+
+import (
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Set while a pager is running, so that color is still used in auto mode
+// even though output is no longer going directly to the terminal.
+var pagerInUse bool
+
+// PagerOptions are the options which may be passed to NewPager.
+type PagerOptions struct {
+	// Never start a pager, as with "git --no-pager".
+	NoPager bool
+}
+
+// A Pager is an io.WriteCloser which writes its output through the user's
+// pager, if one was started, or directly to the underlying writer if not.
+type Pager struct {
+	w    io.Writer
+	pipe *os.File
+	cmd  *exec.Cmd
+}
+
+// PagerCommand returns the command that should be used as a pager for c.
+// This is the first one set of $GIT_PAGER, core.pager and $PAGER, falling
+// back on "less". If it's the empty string or "cat", nothing should be
+// paged.
+func (c *Client) PagerCommand() string {
+	if pager, ok := os.LookupEnv("GIT_PAGER"); ok {
+		return pager
+	}
+	if pager := c.GetConfig("core.pager"); pager != "" {
+		return pager
+	}
+	if pager, ok := os.LookupEnv("PAGER"); ok {
+		return pager
+	}
+	return "less"
+}
+
+// NewPager starts the pager for output which would otherwise be written to
+// out. The pager is only started if out is a terminal, so output isn't paged
+// if it's redirected. If the pager isn't started, writes to the Pager go
+// directly to out.
+//
+// The Pager must be closed once all output is written in order to wait for
+// the user to exit the pager.
+func NewPager(c *Client, opts PagerOptions, out io.Writer) (*Pager, error) {
+	p := &Pager{w: out}
+	if opts.NoPager || !isTerminal(out) {
+		return p, nil
+	}
+	pager := c.PagerCommand()
+	if pager == "" || pager == "cat" {
+		return p, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = r
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	// The same defaults as the official client, so that less exits if
+	// the output fits on one screen and passes through color.
+	cmd.Env = os.Environ()
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(cmd.Env, "LESS=FRX")
+	}
+	if _, ok := os.LookupEnv("LV"); !ok {
+		cmd.Env = append(cmd.Env, "LV=-c")
+	}
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	// The pager has its own copy of the read end.
+	r.Close()
+
+	p.w = w
+	p.pipe = w
+	p.cmd = cmd
+	pagerInUse = true
+	return p, nil
+}
+
+// Stdin returns the pipe to the pager's standard input, so that output
+// written directly to os.Stdout can be redirected to it. If no pager was
+// started, it returns nil.
+func (p *Pager) Stdin() *os.File {
+	return p.pipe
+}
+
+func (p *Pager) Write(b []byte) (int, error) {
+	return p.w.Write(b)
+}
+
+// Close finishes the output to the pager and waits for it to exit. If no
+// pager was started, it does nothing.
+func (p *Pager) Close() error {
+	if p.cmd == nil {
+		return nil
+	}
+	p.pipe.Close()
+	err := p.cmd.Wait()
+	p.cmd = nil
+	pagerInUse = false
+	return err
+}
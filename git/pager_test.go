@@ -0,0 +1,32 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestPagerDisabled(t *testing.T) {
+	c, cleanup := testRepo(t, "gitpager")
+	defer cleanup()
+
+	for _, opts := range []PagerOptions{{NoPager: true}, {}} {
+		var out bytes.Buffer
+		p, err := NewPager(c, opts, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A bytes.Buffer isn't a terminal, so even without NoPager
+		// the output shouldn't be paged.
+		if p.Stdin() != nil {
+			t.Errorf("%+v: Pager was started", opts)
+		}
+		fmt.Fprintf(p, "hello\n")
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := out.String(); got != "hello\n" {
+			t.Errorf("%+v: Unexpected output: got %q want %q", opts, got, "hello\n")
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A CommitHeader is a header of a commit object. The lines of a multiline
+// value, like a signature, are separated by newlines without the space
+// which starts continuation lines in the object. A header with an empty
+// value is written without a space after its name.
+type CommitHeader struct {
+	Name, Value string
+}
+
+// A ParsedCommit is a commit object split into its parts, so that they can
+// be inspected or changed, and then written again with Bytes.
+type ParsedCommit struct {
+	Tree      TreeID
+	Parents   []CommitID
+	Author    Person
+	Committer Person
+
+	// The headers after the committer, in the order that they appear,
+	// such as encoding, mergetag and gpgsig.
+	Headers []CommitHeader
+
+	// The raw message, after the blank line which ends the headers.
+	// If Message is nil, the headers aren't followed by a blank line.
+	Message []byte
+
+	// The author and committer as they were parsed, so that Bytes can
+	// write them exactly as they were if they haven't changed.
+	rawAuthor, rawCommitter   string
+	origAuthor, origCommitter Person
+}
+
+// ParseCommit reads the commit id and parses it. Calling Bytes on the
+// result gives the original content of the commit, byte for byte, as long
+// as nothing was changed.
+func ParseCommit(c *Client, id CommitID) (*ParsedCommit, error) {
+	obj, err := c.GetCommitObject(id)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := parseCommit(obj.GetContent())
+	if err != nil {
+		return nil, fmt.Errorf("commit %v: %v", id, err)
+	}
+	return pc, nil
+}
+
+func parseCommit(content []byte) (*ParsedCommit, error) {
+	pc := &ParsedCommit{}
+	all, message, err := parseObjectHeaders(content)
+	if err != nil {
+		return nil, err
+	}
+	pc.Message = message
+
+	// The tree, parents, author and committer always come first, in that
+	// order. Anything else, including another author or committer, is
+	// kept with the rest of the headers.
+	if len(all) == 0 || all[0].Name != "tree" {
+		return nil, fmt.Errorf("missing tree")
+	}
+	tree, err := Sha1FromString(all[0].Value)
+	if err != nil {
+		return nil, fmt.Errorf("bad tree %q", all[0].Value)
+	}
+	pc.Tree = TreeID(tree)
+	all = all[1:]
+	for len(all) > 0 && all[0].Name == "parent" {
+		parent, err := Sha1FromString(all[0].Value)
+		if err != nil {
+			return nil, fmt.Errorf("bad parent %q", all[0].Value)
+		}
+		pc.Parents = append(pc.Parents, CommitID(parent))
+		all = all[1:]
+	}
+	for _, ident := range []struct {
+		name   string
+		person *Person
+		raw    *string
+		orig   *Person
+	}{
+		{"author", &pc.Author, &pc.rawAuthor, &pc.origAuthor},
+		{"committer", &pc.Committer, &pc.rawCommitter, &pc.origCommitter},
+	} {
+		if len(all) == 0 || all[0].Name != ident.name {
+			continue
+		}
+		person, err := parseObjectPerson(all[0].Value)
+		if err != nil {
+			return nil, fmt.Errorf("bad %v: %v", ident.name, err)
+		}
+		*ident.person = person
+		*ident.raw, *ident.orig = all[0].Value, *ident.person
+		all = all[1:]
+	}
+	if len(all) > 0 {
+		pc.Headers = all
+	}
+	return pc, nil
+}
+
+// Splits the raw content of a commit or tag object into its headers and
+// the message after the blank line which ends them. The message is nil if
+// there's no blank line.
+func parseObjectHeaders(content []byte) ([]CommitHeader, []byte, error) {
+	headers := content
+	var message []byte
+	if end := bytes.Index(content, []byte("\n\n")); end >= 0 {
+		headers, message = content[:end+1], content[end+2:]
+	}
+	if !bytes.HasSuffix(headers, []byte("\n")) {
+		return nil, nil, fmt.Errorf("unterminated header")
+	}
+
+	var all []CommitHeader
+	for _, line := range strings.SplitAfter(string(headers), "\n") {
+		line = strings.TrimSuffix(line, "\n")
+		if strings.HasPrefix(line, " ") && len(all) > 0 {
+			all[len(all)-1].Value += "\n" + line[1:]
+			continue
+		} else if line == "" {
+			continue
+		}
+		pieces := strings.SplitN(line, " ", 2)
+		h := CommitHeader{Name: pieces[0]}
+		if len(pieces) == 2 {
+			h.Value = pieces[1]
+		}
+		all = append(all, h)
+	}
+	return all, message, nil
+}
+
+// Writes headers to buf in the format of a commit or tag object.
+func writeObjectHeaders(buf *bytes.Buffer, headers []CommitHeader) {
+	for _, h := range headers {
+		buf.WriteString(h.Name)
+		if h.Value != "" {
+			buf.WriteString(" " + strings.Replace(h.Value, "\n", "\n ", -1))
+		}
+		buf.WriteString("\n")
+	}
+}
+
+// Parses an ident of the form "Name <email> unixtime tz".
+func parseObjectPerson(value string) (Person, error) {
+	name, email, t, err := parsePersonHeader(value)
+	if err != nil {
+		return Person{}, err
+	}
+	return Person{name, strings.Trim(email, "<>"), &t}, nil
+}
+
+// Writes the ident header name for person to buf. If person is the same
+// as orig, which was parsed from raw, raw is written so that it's
+// unchanged. Nothing is written for an empty person.
+func writeIdent(buf *bytes.Buffer, name string, person Person, raw string, orig Person) {
+	switch {
+	case raw != "" && samePerson(person, orig):
+		fmt.Fprintf(buf, "%v %v\n", name, raw)
+	case person != Person{}:
+		fmt.Fprintf(buf, "%v %v\n", name, person)
+	}
+}
+
+// Returns true if a and b are the same person at the same time in the same
+// timezone.
+func samePerson(a, b Person) bool {
+	if a.Name != b.Name || a.Email != b.Email {
+		return false
+	}
+	if a.Time == nil || b.Time == nil {
+		return a.Time == b.Time
+	}
+	_, aoff := a.Time.Zone()
+	_, boff := b.Time.Zone()
+	return a.Time.Equal(*b.Time) && aoff == boff
+}
+
+// Header returns the value of the first header called name after the
+// committer, or false if there isn't one.
+func (pc *ParsedCommit) Header(name string) (string, bool) {
+	for _, h := range pc.Headers {
+		if h.Name == name {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// Encoding returns the encoding of the message from the encoding header,
+// or the empty string if there isn't one, in which case it's UTF-8.
+func (pc *ParsedCommit) Encoding() string {
+	enc, _ := pc.Header("encoding")
+	return enc
+}
+
+// GPGSig returns the signature from the gpgsig header, or nil if the commit
+// isn't signed.
+func (pc *ParsedCommit) GPGSig() []byte {
+	sig, ok := pc.Header("gpgsig")
+	if !ok {
+		return nil
+	}
+	return []byte(sig + "\n")
+}
+
+// Bytes serializes the commit into the content of a commit object.
+func (pc *ParsedCommit) Bytes() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %v\n", pc.Tree)
+	for _, p := range pc.Parents {
+		fmt.Fprintf(&buf, "parent %v\n", p)
+	}
+	writeIdent(&buf, "author", pc.Author, pc.rawAuthor, pc.origAuthor)
+	writeIdent(&buf, "committer", pc.Committer, pc.rawCommitter, pc.origCommitter)
+	writeObjectHeaders(&buf, pc.Headers)
+	if pc.Message != nil {
+		buf.WriteString("\n")
+		buf.Write(pc.Message)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,89 @@
+package git
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseCommit(t *testing.T) {
+	c, cleanup := testRepo(t, "gitparsecommit")
+	defer cleanup()
+
+	parent := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+	tree, err := parent.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := []byte("-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n=abcd\n-----END PGP SIGNATURE-----\n")
+	unsigned := []byte("tree " + tree.String() + "\n" +
+		"parent " + parent.String() + "\n" +
+		"author A U Thor <author@example.com> 1514593165 +0530\n" +
+		"committer C O Mitter <committer@example.com> 1514593165 -0000\n" +
+		"encoding ISO-8859-1\n" +
+		"x-extra first line\n second line\n" +
+		"\n" +
+		"Signed commit\n\nWith a body\n")
+	content := addCommitSignature(unsigned, sig)
+	id, err := c.WriteObject("commit", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := ParseCommit(c, CommitID(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pc.Bytes(); !bytes.Equal(got, content) {
+		t.Errorf("Commit was not serialized identically:\ngot:\n%s\nwant:\n%s", got, content)
+	}
+
+	if pc.Tree != tree {
+		t.Errorf("Unexpected tree: got %v want %v", pc.Tree, tree)
+	}
+	if len(pc.Parents) != 1 || pc.Parents[0] != parent {
+		t.Errorf("Unexpected parents: got %v want [%v]", pc.Parents, parent)
+	}
+	if pc.Author.Name != "A U Thor" || pc.Author.Email != "author@example.com" || pc.Author.Time.Unix() != 1514593165 {
+		t.Errorf("Unexpected author: %v", pc.Author)
+	}
+	if pc.Committer.Name != "C O Mitter" || pc.Committer.Email != "committer@example.com" {
+		t.Errorf("Unexpected committer: %v", pc.Committer)
+	}
+	if enc := pc.Encoding(); enc != "ISO-8859-1" {
+		t.Errorf("Unexpected encoding: got %q", enc)
+	}
+	if got := pc.GPGSig(); !bytes.Equal(got, sig) {
+		t.Errorf("Unexpected signature:\ngot:\n%s\nwant:\n%s", got, sig)
+	}
+	if extra, _ := pc.Header("x-extra"); extra != "first line\nsecond line" {
+		t.Errorf("Unexpected extra header: got %q", extra)
+	}
+	if string(pc.Message) != "Signed commit\n\nWith a body\n" {
+		t.Errorf("Unexpected message: got %q", pc.Message)
+	}
+
+	// Stripping the signature gives the payload which was signed.
+	var headers []CommitHeader
+	for _, h := range pc.Headers {
+		if h.Name != "gpgsig" {
+			headers = append(headers, h)
+		}
+	}
+	pc.Headers = headers
+	if got := pc.Bytes(); !bytes.Equal(got, unsigned) {
+		t.Errorf("Unexpected payload:\ngot:\n%s\nwant:\n%s", got, unsigned)
+	}
+
+	// Changing the committer only changes its header.
+	pc.Committer.Name = "Somebody Else"
+	want := bytes.Replace(unsigned, []byte("C O Mitter"), []byte("Somebody Else"), 1)
+	want = bytes.Replace(want, []byte("-0000"), []byte("+0000"), 1)
+	if got := pc.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("Unexpected commit after changing committer:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := ParseCommit(c, CommitID(tree)); err == nil {
+		t.Error("Expected an error parsing a tree as a commit")
+	}
+}
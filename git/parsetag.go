@@ -0,0 +1,120 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A ParsedTag is an annotated tag object split into its parts, so that they
+// can be inspected or changed, and then written again with Bytes.
+type ParsedTag struct {
+	// The object that the tag points to, and its type.
+	Object Sha1
+	Type   string
+
+	// The name of the tag.
+	Name string
+
+	// The tagger, which is empty for old tags which don't have one.
+	Tagger Person
+
+	// Any headers after the tagger, in the order that they appear.
+	Headers []CommitHeader
+
+	// The raw message, after the blank line which ends the headers and
+	// before the signature. If Message is nil, the headers aren't
+	// followed by a blank line.
+	Message []byte
+
+	// The signature which was appended to the message, or nil if the tag
+	// isn't signed.
+	Signature []byte
+
+	// The tagger as it was parsed, so that Bytes can write it exactly as
+	// it was if it hasn't changed.
+	rawTagger  string
+	origTagger Person
+}
+
+// ParseTag reads the tag object id and parses it. Calling Bytes on the
+// result gives the original content of the tag, byte for byte, as long as
+// nothing was changed.
+func ParseTag(c *Client, id Sha1) (*ParsedTag, error) {
+	obj, err := c.GetObject(id)
+	if err != nil {
+		return nil, err
+	}
+	if typ := obj.GetType(); typ != "tag" {
+		return nil, fmt.Errorf("%v is a %v, not a tag", id, typ)
+	}
+	pt, err := parseTag(obj.GetContent())
+	if err != nil {
+		return nil, fmt.Errorf("tag %v: %v", id, err)
+	}
+	return pt, nil
+}
+
+func parseTag(content []byte) (*ParsedTag, error) {
+	pt := &ParsedTag{}
+	content, pt.Signature = splitTagSignature(content)
+	all, message, err := parseObjectHeaders(content)
+	if err != nil {
+		return nil, err
+	}
+	pt.Message = message
+
+	// The object, type and name always come first, in that order,
+	// followed by the tagger if there is one.
+	if len(all) < 3 || all[0].Name != "object" || all[1].Name != "type" || all[2].Name != "tag" {
+		return nil, fmt.Errorf("missing object, type or tag name")
+	}
+	if pt.Object, err = Sha1FromString(all[0].Value); err != nil {
+		return nil, fmt.Errorf("bad object %q", all[0].Value)
+	}
+	pt.Type, pt.Name = all[1].Value, all[2].Value
+	all = all[3:]
+	if len(all) > 0 && all[0].Name == "tagger" {
+		if pt.Tagger, err = parseObjectPerson(all[0].Value); err != nil {
+			return nil, fmt.Errorf("bad tagger: %v", err)
+		}
+		pt.rawTagger, pt.origTagger = all[0].Value, pt.Tagger
+		all = all[1:]
+	}
+	if len(all) > 0 {
+		pt.Headers = all
+	}
+	return pt, nil
+}
+
+// Bytes serializes the tag into the content of a tag object.
+func (pt *ParsedTag) Bytes() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "object %v\ntype %v\ntag %v\n", pt.Object, pt.Type, pt.Name)
+	writeIdent(&buf, "tagger", pt.Tagger, pt.rawTagger, pt.origTagger)
+	writeObjectHeaders(&buf, pt.Headers)
+	if pt.Message != nil {
+		buf.WriteString("\n")
+		buf.Write(pt.Message)
+	}
+	buf.Write(pt.Signature)
+	return buf.Bytes()
+}
+
+// Peel follows id through any tags that it refers to, including tags of
+// tags, and returns the object at the end which isn't a tag.
+func Peel(c *Client, id Sha1) (Sha1, error) {
+	for {
+		typ, err := c.ObjectType(id)
+		if err != nil {
+			return Sha1{}, err
+		}
+		if typ != "tag" {
+			return id, nil
+		}
+		tag, err := ParseTag(c, id)
+		if err != nil {
+			return Sha1{}, err
+		}
+		id = tag.Object
+	}
+}
@@ -0,0 +1,83 @@
+package git
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseTag(t *testing.T) {
+	c, cleanup := testRepo(t, "gitparsetag")
+	defer cleanup()
+
+	cmt := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+	if err := TagCommit(c, TagOptions{Annotated: true}, "v1", nil, "Version 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	v1, err := RefSpec("refs/tags/v1").Sha1(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag, err := ParseTag(c, v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Object != Sha1(cmt) || tag.Type != "commit" || tag.Name != "v1" {
+		t.Errorf("Unexpected tag: object %v type %v name %v", tag.Object, tag.Type, tag.Name)
+	}
+	if tag.Tagger.Time == nil || tag.Tagger.Email == "" {
+		t.Errorf("Unexpected tagger: %v", tag.Tagger)
+	}
+	if string(tag.Message) != "Version 1\n" || tag.Signature != nil {
+		t.Errorf("Unexpected message %q and signature %q", tag.Message, tag.Signature)
+	}
+	obj, err := c.GetObject(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tag.Bytes(); !bytes.Equal(got, obj.GetContent()) {
+		t.Errorf("Tag was not serialized identically:\ngot:\n%s\nwant:\n%s", got, obj.GetContent())
+	}
+
+	// A signed tag of the tag.
+	content := []byte("object " + v1.String() + "\n" +
+		"type tag\n" +
+		"tag nested\n" +
+		"tagger T Agger <tagger@example.com> 1514593165 -0330\n" +
+		"\n" +
+		"Tag of a tag\n" +
+		"-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n-----END PGP SIGNATURE-----\n")
+	nested, err := c.WriteObject("tag", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err = ParseTag(c, nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tag.Bytes(); !bytes.Equal(got, content) {
+		t.Errorf("Signed tag was not serialized identically:\ngot:\n%s\nwant:\n%s", got, content)
+	}
+	if tag.Object != v1 || tag.Type != "tag" || tag.Tagger.Name != "T Agger" {
+		t.Errorf("Unexpected nested tag: object %v type %v tagger %v", tag.Object, tag.Type, tag.Tagger)
+	}
+	if string(tag.Message) != "Tag of a tag\n" || !bytes.HasPrefix(tag.Signature, []byte("-----BEGIN PGP SIGNATURE-----\n")) {
+		t.Errorf("Unexpected message %q and signature %q", tag.Message, tag.Signature)
+	}
+
+	for _, id := range []Sha1{nested, v1, Sha1(cmt)} {
+		if peeled, err := Peel(c, id); err != nil || peeled != Sha1(cmt) {
+			t.Errorf("Unexpected peeled object for %v: got %v (%v) want %v", id, peeled, err, cmt)
+		}
+	}
+	if err := UpdateRef(c, UpdateRefOptions{}, "refs/tags/nested", CommitID(nested), "test"); err != nil {
+		t.Fatal(err)
+	}
+	if peeled, err := RefSpec("refs/tags/nested").CommitID(c); err != nil || peeled != cmt {
+		t.Errorf("Unexpected commit for nested tag: got %v (%v) want %v", peeled, err, cmt)
+	}
+
+	if _, err := ParseTag(c, Sha1(cmt)); err == nil {
+		t.Error("Expected an error parsing a commit as a tag")
+	}
+}
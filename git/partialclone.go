@@ -0,0 +1,106 @@
+package git
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Checks that spec is a filter for a partial clone that dgit supports. The
+// supported filters are:
+//
+//	blob:none        omit all blobs
+//	blob:limit=<n>   omit blobs of at least n bytes, with an optional
+//	                 k, m or g suffix
+//	tree:<depth>     omit trees and blobs deeper than depth from the root
+//	                 tree (tree:0 omits everything but commits)
+func validateFilter(spec string) error {
+	switch {
+	case spec == "blob:none":
+		return nil
+	case strings.HasPrefix(spec, "blob:limit="):
+		limit := strings.ToLower(strings.TrimPrefix(spec, "blob:limit="))
+		if n := len(limit); n > 0 && strings.IndexByte("kmg", limit[n-1]) != -1 {
+			limit = limit[:n-1]
+		}
+		if _, err := strconv.ParseUint(limit, 10, 64); err != nil {
+			return fmt.Errorf("Invalid filter-spec '%v'", spec)
+		}
+		return nil
+	case strings.HasPrefix(spec, "tree:"):
+		if _, err := strconv.ParseUint(strings.TrimPrefix(spec, "tree:"), 10, 64); err != nil {
+			return fmt.Errorf("Invalid filter-spec '%v'", spec)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unsupported filter-spec '%v'", spec)
+}
+
+// Returns the promisor remote of c, which missing objects can be fetched
+// from, or the empty string if c is not a partial clone.
+func (c *Client) promisorRemote() Remote {
+	return Remote(c.GetConfig("extensions.partialclone"))
+}
+
+// Records rmt as the promisor remote of a partial clone made with filter.
+func setPromisorRemote(c *Client, config *GitConfig, rmt Remote, filter string) {
+	vals := map[string]string{
+		// Older clients which don't understand the extension must not
+		// use the repository, since they would treat the missing
+		// objects as corruption.
+		"core.repositoryformatversion":                   "1",
+		"extensions.partialclone":                        rmt.String(),
+		fmt.Sprintf("remote.%v.promisor", rmt):           "true",
+		fmt.Sprintf("remote.%v.partialclonefilter", rmt): filter,
+	}
+	for k, v := range vals {
+		config.SetConfig(k, v)
+		c.SetCachedConfig(k, v)
+	}
+}
+
+// Fetches the missing object id from the promisor remote of c. Only id
+// itself is fetched, not the blobs that it refers to if it's a tree or
+// commit.
+//
+// It returns false without an error if c is not a partial clone, or if
+// c is already fetching a missing object, either further up the stack or
+// in another goroutine.
+func (c *Client) fetchPromisedObject(id Sha1) (bool, error) {
+	rmt := c.promisorRemote()
+	if rmt == "" {
+		return false, nil
+	}
+	c.objectsMu.Lock()
+	if c.fetchingPromised {
+		c.objectsMu.Unlock()
+		return false, nil
+	}
+	c.fetchingPromised = true
+	c.objectsMu.Unlock()
+	defer func() {
+		c.objectsMu.Lock()
+		c.fetchingPromised = false
+		c.objectsMu.Unlock()
+	}()
+
+	log.Printf("Fetching missing object %v from promisor remote %v\n", id, rmt)
+	conn, err := NewRemoteConn(c, rmt)
+	if err != nil {
+		return false, err
+	}
+	if err := conn.OpenConn(); err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// There are no haves, since the server would assume that we have
+	// every object reachable from them, including the one that's
+	// missing.
+	opts := FetchPackOptions{Filter: "blob:none", NoProgress: true}
+	if _, err := fetchPackDone(c, opts, conn, []Refname{Refname(id.String())}, make(map[Sha1]struct{})); err != nil {
+		return false, fmt.Errorf("Could not fetch %v from promisor remote: %v", id, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,136 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestValidateFilter(t *testing.T) {
+	tests := []struct {
+		Spec  string
+		Valid bool
+	}{
+		{"blob:none", true},
+		{"blob:limit=1024", true},
+		{"blob:limit=1k", true},
+		{"blob:limit=", false},
+		{"blob:limit=lots", false},
+		{"tree:0", true},
+		{"tree:-1", false},
+		{"sparse:oid=master:.sparse", false},
+	}
+	for _, tc := range tests {
+		if err := validateFilter(tc.Spec); (err == nil) != tc.Valid {
+			t.Errorf("Unexpected result for %v: %v", tc.Spec, err)
+		}
+	}
+}
+
+// Tests that a blob:none clone doesn't download blobs until they're read.
+func TestPartialClone(t *testing.T) {
+	c, cleanup := testRepo(t, "gitpartialclone")
+	defer cleanup()
+	testCommitFile(t, c, "foo.txt", "bar\n", "Initial commit")
+	blob, err := RevParsePath(c, &RevParseOptions{}, "HEAD:foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The source is served by git-upload-pack, which needs to be told
+	// to allow filters.
+	config, err := os.OpenFile(c.GitDir.File("config").String(), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(config, "[uploadpack]\n\tallowFilter = true\n\tallowAnySHA1InWant = true\n")
+	config.Close()
+	src := c.WorkDir.String()
+
+	dir, err := ioutil.TempDir("", "gitpartialclonedst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst := File(filepath.Join(dir, "partial.git"))
+	opts := CloneOptions{InitOptions: InitOptions{Quiet: true, Bare: true}}
+	opts.Filter = "blob:none"
+	if err := Clone(opts, Remote(src), dst); err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := NewClient(dst.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pc.GetConfig("extensions.partialclone"); got != "origin" {
+		t.Errorf("Unexpected promisor remote: got %q want %q", got, "origin")
+	}
+	if got := pc.GetConfig("remote.origin.partialclonefilter"); got != "blob:none" {
+		t.Errorf("Unexpected partial clone filter: got %q want %q", got, "blob:none")
+	}
+	if have, _, err := pc.HaveObject(Sha1(blob)); err != nil {
+		t.Fatal(err)
+	} else if have {
+		t.Fatal("Blob was fetched by a blob:none clone")
+	}
+	cmt, err := RevParseCommit(pc, &RevParseOptions{}, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _, err := pc.HaveObject(Sha1(cmt)); err != nil || !have {
+		t.Errorf("Commit was not fetched: %v", err)
+	}
+
+	// Reading the blob fetches it from the promisor remote.
+	obj, err := pc.GetObject(Sha1(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(obj.GetContent()) != "bar\n" {
+		t.Errorf("Unexpected content: got %q want %q", obj.GetContent(), "bar\n")
+	}
+	if have, _, err := pc.HaveObject(Sha1(blob)); err != nil || !have {
+		t.Errorf("Blob was not stored after being fetched: %v", err)
+	}
+}
+
+// Tests that a missing object is only fetched by one goroutine at a time,
+// and that the others don't wait for it or fetch recursively.
+func TestFetchPromisedObjectConcurrent(t *testing.T) {
+	c, cleanup := testRepo(t, "gitpromisedconcurrent")
+	defer cleanup()
+	c.SetCachedConfig("extensions.partialclone", "origin")
+	c.SetCachedConfig("remote.origin.url", filepath.Join(c.WorkDir.String(), "missing"))
+
+	c.objectsMu.Lock()
+	c.fetchingPromised = true
+	c.objectsMu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if fetched, err := c.fetchPromisedObject(hashString("missing\n")); fetched || err != nil {
+				t.Errorf("Fetch while another was in progress: %v (%v)", fetched, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Once it's finished, the next lookup tries the remote again, and the
+	// flag is cleared even though it fails.
+	c.objectsMu.Lock()
+	c.fetchingPromised = false
+	c.objectsMu.Unlock()
+	if _, err := c.fetchPromisedObject(hashString("missing\n")); err == nil {
+		t.Error("Fetch from a missing promisor remote did not fail")
+	}
+	c.objectsMu.Lock()
+	defer c.objectsMu.Unlock()
+	if c.fetchingPromised {
+		t.Error("Fetching flag was not cleared after the fetch failed")
+	}
+}
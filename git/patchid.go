@@ -0,0 +1,114 @@
+package git
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"strings"
+	"unicode"
+)
+
+// PatchID returns the patch ID of commit, which is the same for any commit
+// which makes the same changes to the same files as commit, such as a
+// commit and its cherry-pick onto another branch. It's the ID which "git
+// cherry" uses, and which "git show --no-renames commit | git patch-id"
+// prints unless a binary file was changed, since the whole IDs of binary
+// blobs are hashed rather than the abbreviated ones in the patch.
+//
+// The ID is the hash of the diff between commit and its first parent,
+// with the whitespace removed and without the hunk headers, so that
+// neither the line numbers nor the whitespace of the changes matter.
+// Merges don't have a patch ID, and an empty ID is returned for them.
+func PatchID(c *Client, commit CommitID) (Sha1, error) {
+	parents, err := commit.Parents(c)
+	if err != nil {
+		return Sha1{}, err
+	}
+	if len(parents) > 1 {
+		return Sha1{}, nil
+	}
+	var parent Treeish
+	if len(parents) == 1 {
+		parent = parents[0]
+	}
+	diffs, err := DiffTree(c, &DiffTreeOptions{Recurse: true}, parent, commit, nil)
+	if err != nil {
+		return Sha1{}, err
+	}
+
+	h := sha1.New()
+	for _, d := range diffs {
+		if d.Src.FileMode == ModeTree || d.Dst.FileMode == ModeTree {
+			continue
+		}
+		if err := patchIDFile(c, h, d); err != nil {
+			return Sha1{}, err
+		}
+	}
+	return Sha1FromSlice(h.Sum(nil))
+}
+
+// Adds the diff of the file in d to the patch ID being calculated by h.
+func patchIDFile(c *Client, h hash.Hash, d HashDiff) error {
+	name := patchIDString(d.Name.String())
+	patchIDWrite(h, "diff --git a/%v b/%v", name, name)
+	switch {
+	case d.Src.FileMode == 0:
+		patchIDWrite(h, "new file mode %0.6o", d.Dst.FileMode)
+	case d.Dst.FileMode == 0:
+		patchIDWrite(h, "deleted file mode %0.6o", d.Src.FileMode)
+	case d.Src.FileMode != d.Dst.FileMode:
+		patchIDWrite(h, "old mode %0.6o", d.Src.FileMode)
+		patchIDWrite(h, "new mode %0.6o", d.Dst.FileMode)
+	}
+
+	if d.Src.Sha1 == d.Dst.Sha1 {
+		// Only the mode was changed.
+		return nil
+	}
+	old, err := diffStatContent(c, d.Src)
+	if err != nil {
+		return err
+	}
+	new, err := diffStatContent(c, d.Dst)
+	if err != nil {
+		return err
+	}
+	if isBinaryContent(old) || isBinaryContent(new) {
+		// Only the blobs of a binary file are part of the ID.
+		patchIDWrite(h, "%v%v", d.Src.Sha1, d.Dst.Sha1)
+		return nil
+	}
+	switch {
+	case d.Src.FileMode == 0:
+		patchIDWrite(h, "--- /dev/null +++ b/%v", name)
+	case d.Dst.FileMode == 0:
+		patchIDWrite(h, "--- a/%v +++ /dev/null", name)
+	default:
+		patchIDWrite(h, "--- a/%v +++ b/%v", name, name)
+	}
+	for _, line := range unifiedDiffLines(old, new, 3) {
+		// Neither the hunk headers nor the missing newlines at the
+		// end of the files are part of the ID.
+		if strings.HasPrefix(line, "@@") || strings.HasPrefix(line, "\\ ") {
+			continue
+		}
+		patchIDWrite(h, "%v", patchIDString(line))
+	}
+	return nil
+}
+
+// Writes the formatted string to h without any whitespace.
+func patchIDWrite(h hash.Hash, format string, args ...interface{}) {
+	h.Write([]byte(patchIDString(fmt.Sprintf(format, args...))))
+}
+
+// Returns s without any whitespace, the way it's hashed in a patch ID.
+func patchIDString(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,46 @@
+package git
+
+import (
+	"testing"
+)
+
+// Tests that a commit and its cherry-pick onto another branch have the same
+// patch ID, even when the lines around the change moved, and that it's
+// the ID that git calculates.
+func TestPatchID(t *testing.T) {
+	c, cleanup := testRepo(t, "gitpatchid")
+	defer cleanup()
+
+	base := testCommitFile(t, c, "f.txt", "one\ntwo\nthree\nfour\nfive\nsix\n", "base")
+	picked := testCommitFile(t, c, "f.txt", "one\ntwo\nthree\nfour\n  five changed\nsix\n", "change five")
+	id, err := PatchID(c, picked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The ID from "git show --no-renames | git patch-id".
+	if want := "8eb9486e87003d2d0f642e4683071edd205dcf88"; id.String() != want {
+		t.Errorf("Unexpected patch ID: got %v want %v", id, want)
+	}
+
+	if _, err := ResetMode(c, ResetOptions{Hard: true}, base); err != nil {
+		t.Fatal(err)
+	}
+	testCommitFile(t, c, "f.txt", "zero\none\ntwo\nthree\nfour\nfive\nsix\n", "add zero")
+	cherry := testCommitFile(t, c, "f.txt", "zero\none\ntwo\nthree\nfour\n  five changed\nsix\n", "change five")
+	cherryID, err := PatchID(c, cherry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cherryID != id {
+		t.Errorf("Cherry-pick has a different patch ID: got %v want %v", cherryID, id)
+	}
+
+	other := testCommitFile(t, c, "f.txt", "zero\none\ntwo\nthree\nfour\nfive\nsix\n", "revert five")
+	otherID, err := PatchID(c, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherID == id {
+		t.Errorf("Different change has the same patch ID %v", id)
+	}
+}
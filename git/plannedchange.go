@@ -0,0 +1,106 @@
+package git
+
+import (
+	"fmt"
+)
+
+// A ChangeAction is the kind of change which a PlannedChange makes to a
+// path.
+type ChangeAction string
+
+const (
+	ChangeAdd    = ChangeAction("add")
+	ChangeUpdate = ChangeAction("update")
+	ChangeRemove = ChangeAction("remove")
+	ChangeRename = ChangeAction("rename")
+)
+
+// A PlannedChange is a change to a path which a mutating command such as
+// Add, Rm, Mv, Clean, Checkout or Reset made, or would have made if it
+// wasn't a DryRun.
+type PlannedChange struct {
+	Action ChangeAction
+
+	// The path that was changed, relative to the current directory.
+	Path File
+
+	// The new name of Path, for a ChangeRename.
+	Dest File
+
+	// Only the index is changed, not the working tree.
+	IndexOnly bool
+}
+
+func (p PlannedChange) String() string {
+	if p.Action == ChangeRename {
+		return fmt.Sprintf("%v '%v' -> '%v'", p.Action, p.Path, p.Dest)
+	}
+	return fmt.Sprintf("%v '%v'", p.Action, p.Path)
+}
+
+// Returns the changes which make the index match the tree side of diffs,
+// which are the result of a DiffIndex against that tree.
+func plannedTreeChanges(c *Client, diffs []HashDiff, indexOnly bool) ([]PlannedChange, error) {
+	changes := make([]PlannedChange, 0, len(diffs))
+	for _, d := range diffs {
+		f, err := d.Name.FilePath(c)
+		if err != nil {
+			return nil, err
+		}
+		change := PlannedChange{Action: ChangeUpdate, Path: f, IndexOnly: indexOnly}
+		switch {
+		case d.Src == (TreeEntry{}):
+			change.Action = ChangeRemove
+		case d.Dst == (TreeEntry{}):
+			change.Action = ChangeAdd
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// Returns the changes which make both the index and the work tree match
+// tree, as a hard reset or a forced checkout does. Along with the changes
+// to the index, these are the files whose changes in the work tree would be
+// discarded and the files in tree which aren't in the index, which would be
+// written or overwritten.
+func plannedResetChanges(c *Client, tree Treeish) ([]PlannedChange, error) {
+	index, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	diffs, err := DiffIndex(c, DiffIndexOptions{}, index, tree, nil)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := plannedTreeChanges(c, diffs, false)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := index.Objects
+	if index.IsSparse() {
+		if objects, err = expandSparseDirs(c, objects, tree); err != nil {
+			return nil, err
+		}
+	}
+	indexed := make(map[IndexPath]bool, len(objects))
+	for _, entry := range objects {
+		indexed[entry.PathName] = true
+	}
+	treefiles, err := LsTree(c, LsTreeOptions{Recurse: true, FullTree: true}, tree, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range treefiles {
+		if indexed[entry.PathName] {
+			continue
+		}
+		f, err := entry.PathName.FilePath(c)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, PlannedChange{Action: ChangeAdd, Path: f})
+	}
+	return changes, nil
+}
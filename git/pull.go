@@ -1,13 +1,41 @@
 package git
 
-import ()
+import (
+	"fmt"
+	"strings"
+)
 
 type PullOptions struct {
 	FetchOptions
 	MergeOptions
+
+	// Rebase the current branch onto the upstream instead of merging
+	// the upstream into it. If neither Rebase nor NoRebase is set, the
+	// pull.rebase config decides.
+	Rebase, NoRebase bool
 }
 
+// Pull fetches from repository and integrates remotebranches into the
+// current branch, as "git pull". If repository is empty, the current
+// branch's remote is used, and if remotebranches is empty the branch's
+// upstream is integrated.
+//
+// The pull.ff config is used unless FastForwardOnly or NoFastForward is
+// set.
 func Pull(c *Client, opts PullOptions, repository Remote, remotebranches []string) error {
+	if err := requireClean(c, "pull"); err != nil {
+		return err
+	}
+
+	headbranch := c.GetHeadBranch()
+	branchremote := Remote(c.GetConfig("branch." + headbranch.BranchName() + ".remote"))
+	if repository == "" {
+		repository = branchremote
+		if repository == "" {
+			repository = "origin"
+		}
+	}
+
 	err := Fetch(c, opts.FetchOptions, repository, nil)
 	if err != nil && err.Error() != "Already up to date." {
 		// If fetch says we have all the refs, that doesn't
@@ -16,8 +44,29 @@ func Pull(c *Client, opts PullOptions, repository Remote, remotebranches []strin
 		return err
 	}
 
+	if len(remotebranches) == 0 {
+		merge := c.GetConfig("branch." + headbranch.BranchName() + ".merge")
+		if merge == "" || repository != branchremote {
+			return fmt.Errorf("There is no tracking information for the current branch.")
+		}
+		remotebranches = []string{merge}
+	}
+	fetch := RefSpec(c.GetConfig("remote." + repository.String() + ".fetch"))
+	if fetch == "" {
+		// The same default that Fetch uses.
+		fetch = RefSpec(fmt.Sprintf("refs/heads/*:refs/remotes/%s/*", repository))
+	}
 	others := make([]Commitish, 0, len(remotebranches))
 	for _, name := range remotebranches {
+		// Branches on the remote are where the fetch refspec put
+		// them.
+		src := name
+		if !strings.HasPrefix(src, "refs/") {
+			src = "refs/heads/" + src
+		}
+		if ok, dst := (Ref{Name: src}).MatchesRefSpecSrc(fetch); ok {
+			name = string(dst)
+		}
 		c, err := RevParseCommitish(c, &RevParseOptions{}, name)
 		if err != nil {
 			return err
@@ -25,5 +74,36 @@ func Pull(c *Client, opts PullOptions, repository Remote, remotebranches []strin
 		others = append(others, c)
 	}
 
-	return Merge(c, opts.MergeOptions, others)
+	if !opts.FastForwardOnly && !opts.NoFastForward {
+		switch c.GetConfig("pull.ff") {
+		case "only":
+			opts.FastForwardOnly = true
+		case "false":
+			opts.NoFastForward = true
+		}
+	}
+	rebase := opts.Rebase
+	if !opts.Rebase && !opts.NoRebase {
+		switch c.GetConfig("pull.rebase") {
+		case "true", "merges", "interactive":
+			rebase = true
+		}
+	}
+	if !rebase {
+		return Merge(c, opts.MergeOptions, others)
+	}
+
+	if len(others) != 1 {
+		return fmt.Errorf("Cannot rebase onto multiple branches.")
+	}
+	if opts.FastForwardOnly {
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			return err
+		}
+		if !head.IsAncestor(c, others[0]) {
+			return fmt.Errorf("Not possible to fast-forward, aborting.")
+		}
+	}
+	return Rebase(c, RebaseOptions{}, others[0])
 }
@@ -0,0 +1,86 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests pulling changes from a local remote into a clone of it.
+func TestPull(t *testing.T) {
+	c, cleanup := testRepo(t, "gitpullsrc")
+	defer cleanup()
+	testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	src := c.WorkDir.String()
+
+	dir, err := ioutil.TempDir("", "gitpulldst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "clone")
+	if err := Clone(CloneOptions{InitOptions: InitOptions{Quiet: true}}, Remote(src), File(dst)); err != nil {
+		t.Fatal(err)
+	}
+	cc, err := NewClient(filepath.Join(dst, ".git"), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A change in the remote is fast-forwarded to.
+	upstream := testCommitFile(t, c, "bar.txt", "bar\n", "Add bar")
+	if err := os.Chdir(dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := Pull(cc, PullOptions{MergeOptions: MergeOptions{FastForwardOnly: true}}, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := cc.GetHeadCommit(); err != nil || head != upstream {
+		t.Errorf("Pull did not fast-forward: got %v want %v (%v)", head, upstream, err)
+	}
+	if content, err := ioutil.ReadFile("bar.txt"); err != nil || string(content) != "bar\n" {
+		t.Errorf("Unexpected work tree after pull: got %q (%v)", content, err)
+	}
+
+	// Once the branches have diverged, --ff-only refuses to pull.
+	if err := os.Chdir(src); err != nil {
+		t.Fatal(err)
+	}
+	upstream = testCommitFile(t, c, "foo.txt", "upstream\n", "Change foo")
+	if err := os.Chdir(dst); err != nil {
+		t.Fatal(err)
+	}
+	local := testCommitFile(t, cc, "local.txt", "local\n", "Add local")
+	cc.SetCachedConfig("pull.ff", "only")
+	if err := Pull(cc, PullOptions{Rebase: true}, "", nil); err == nil {
+		t.Error("Pull with pull.ff=only did not fail when the branches diverged")
+	}
+	if head, err := cc.GetHeadCommit(); err != nil || head != local {
+		t.Errorf("Failed pull moved HEAD: got %v want %v (%v)", head, local, err)
+	}
+
+	// Rebasing replays the local commit on top of the upstream one.
+	cc.SetCachedConfig("pull.ff", "true")
+	if err := Pull(cc, PullOptions{Rebase: true}, "", []string{"master"}); err != nil {
+		t.Fatal(err)
+	}
+	head, err := cc.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parents, err := head.Parents(cc); err != nil || len(parents) != 1 || parents[0] != upstream {
+		t.Errorf("Unexpected parents after rebase: got %v want %v (%v)", parents, upstream, err)
+	}
+	if msg, err := head.GetCommitMessage(cc); err != nil || msg.String() != "Add local\n" {
+		t.Errorf("Unexpected message after rebase: got %q (%v)", msg, err)
+	}
+	for name, want := range map[string]string{"foo.txt": "upstream\n", "local.txt": "local\n"} {
+		if content, err := ioutil.ReadFile(name); err != nil || string(content) != want {
+			t.Errorf("Unexpected content of %v: got %q want %q (%v)", name, content, want, err)
+		}
+	}
+	if clean, dirty, err := cc.IsClean(CleanCheckOptions{}); err != nil || !clean {
+		t.Errorf("Work tree not clean after rebase: %v (%v)", dirty, err)
+	}
+}
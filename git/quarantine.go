@@ -0,0 +1,143 @@
+package git
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Quarantine returns a client for the same repository as c, whose objects
+// are written to a temporary objects directory rather than to the
+// repository's, so that incoming objects can be checked before they're
+// accepted. The quarantined client can read objects from both the
+// temporary directory and the repository.
+//
+// commit moves the objects written to the quarantine into the repository's
+// objects directory, and rollback discards them. Either one removes the
+// temporary directory, after which the quarantined client writes to the
+// repository like any other client.
+//
+// While the quarantine is pending, c only finds the objects in it if
+// c.ReadQuarantine is set.
+func (c *Client) Quarantine() (q *Client, commit func() error, rollback func() error) {
+	var suffix [6]byte
+	rand.Read(suffix[:])
+	// The directory isn't created until the first object is written to
+	// it, so quarantining something which doesn't have any objects
+	// doesn't leave anything behind.
+	dir := c.GitDir.File(File(fmt.Sprintf("objects/incoming-%x", suffix)))
+
+	q = &Client{
+		GitDir:           c.GitDir,
+		WorkDir:          c.WorkDir,
+		objectCache:      make(map[Sha1]objectLocation),
+		NoReplaceObjects: c.NoReplaceObjects,
+		quarantine:       dir,
+	}
+
+	c.objectsMu.Lock()
+	c.quarantines = append(c.quarantines, dir)
+	c.objectsMu.Unlock()
+
+	done := false
+	finish := func(migrate bool) error {
+		if done {
+			return fmt.Errorf("quarantine %v was already finished", dir)
+		}
+		if migrate {
+			if err := migrateQuarantine(dir, c.GitDir.File("objects")); err != nil {
+				return err
+			}
+		}
+		if err := os.RemoveAll(dir.String()); err != nil {
+			return err
+		}
+		done = true
+
+		// The cached locations of the objects of either client may
+		// have been in the quarantine.
+		c.objectsMu.Lock()
+		for i, qdir := range c.quarantines {
+			if qdir == dir {
+				c.quarantines = append(c.quarantines[:i:i], c.quarantines[i+1:]...)
+				break
+			}
+		}
+		c.objectDirsCache = nil
+		c.objectCache = make(map[Sha1]objectLocation)
+		c.objcache.clear()
+		c.objectsMu.Unlock()
+
+		q.objectsMu.Lock()
+		q.quarantine = ""
+		q.objectDirsCache = nil
+		q.objectCache = make(map[Sha1]objectLocation)
+		q.objcache.clear()
+		q.objectsMu.Unlock()
+		return nil
+	}
+	commit = func() error { return finish(true) }
+	rollback = func() error { return finish(false) }
+	return q, commit, rollback
+}
+
+// Returns the objects directory that new objects are written to, which is
+// the quarantine for a quarantined client.
+func (c *Client) objectWriteDir() File {
+	if c.quarantine != "" {
+		return c.quarantine
+	}
+	return c.GitDir.File("objects")
+}
+
+// Moves every file in the quarantine directory dir into the objects
+// directory objdir. Objects which already exist in objdir are left alone.
+// The pack indexes are moved after everything else, so that a reader
+// never finds an index whose pack hasn't been moved yet.
+func migrateQuarantine(dir, objdir File) error {
+	var files []string
+	err := filepath.Walk(dir.String(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir.String() {
+				// Nothing was ever written to the quarantine.
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			// Skip the temporary files of anything which was
+			// still being written.
+			return nil
+		}
+		rel, err := filepath.Rel(dir.String(), path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return filepath.Ext(files[i]) != ".idx" && filepath.Ext(files[j]) == ".idx"
+	})
+
+	for _, rel := range files {
+		src := filepath.Join(dir.String(), rel)
+		dst := filepath.Join(objdir.String(), rel)
+		if File(dst).Exists() {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package git
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Tests that objects written to a quarantine are only in the repository
+// once it's committed, and that rolling it back discards them.
+func TestQuarantine(t *testing.T) {
+	c, cleanup := testRepo(t, "gitquarantine")
+	defer cleanup()
+
+	q, _, rollback := c.Quarantine()
+	rejected, err := q.WriteObject("blob", []byte("rejected\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _, err := q.HaveObject(rejected); err != nil || !have {
+		t.Fatalf("Quarantined client can not find its object: %v", err)
+	}
+	if have, _, err := c.HaveObject(rejected); err != nil || have {
+		t.Fatalf("Quarantined object found without ReadQuarantine: %v", err)
+	}
+	c.ReadQuarantine = true
+	if have, _, err := c.HaveObject(rejected); err != nil || !have {
+		t.Fatalf("Quarantined object not found with ReadQuarantine: %v", err)
+	}
+	if err := rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if have, _, err := c.HaveObject(rejected); err != nil || have {
+		t.Fatalf("Object found after rollback: %v", err)
+	}
+	if err := rollback(); err == nil {
+		t.Error("Quarantine rolled back twice")
+	}
+
+	q, commit, _ := c.Quarantine()
+	accepted, err := q.WriteObject("blob", []byte("accepted\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := commit(); err != nil {
+		t.Fatal(err)
+	}
+	c.ReadQuarantine = false
+	if have, _, err := c.HaveObject(accepted); err != nil || !have {
+		t.Fatalf("Object not found after commit: %v", err)
+	}
+	matches, err := filepath.Glob(c.GitDir.File("objects/incoming-*").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("Quarantine directories left behind: %v", matches)
+	}
+}
@@ -0,0 +1,382 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RangeDiffOptions denotes the options for comparing two ranges of commits
+// with RangeDiff.
+type RangeDiffOptions struct {
+	// How much of a commit's patch, as a percentage, may be different
+	// before it's shown as a commit which was dropped and another which
+	// was added rather than as a changed commit. The 0 value is 60, which
+	// is git's default.
+	CreationFactor int
+}
+
+// A RangeDiffEntry is a commit of one of the ranges compared by RangeDiff,
+// and the commit of the other range which it corresponds to.
+type RangeDiffEntry struct {
+	// The commits in the old and the new range, and their positions in
+	// the ranges starting from 1. A commit which was dropped has no New,
+	// and one which was added has no Old, and their index is 0.
+	Old, New           CommitID
+	OldIndex, NewIndex int
+
+	// '=' if the commits have the same patch and message, '!' if they
+	// were changed, '<' if Old was dropped and '>' if New was added.
+	Status byte
+
+	// For a changed commit, the diff between the patches of Old and New,
+	// with a "@@" line before each hunk naming the part of the patch that
+	// it's in.
+	InterDiff string
+}
+
+// A commit of one of the ranges and its patch in the format used to compare
+// them.
+type rangeDiffCommit struct {
+	Commit CommitID
+	// The whole patch, with the metadata and message.
+	Patch string
+	// The part of Patch with the changes to the files.
+	Diff string
+	// The number of lines in Diff.
+	DiffSize int
+
+	Matching int
+	Shown    bool
+}
+
+// The cost of matching two commits which can't be matched.
+const rangeDiffCostMax = 1 << 16
+
+// RangeDiff compares the commits in base1..tip1 to those in base2..tip2,
+// such as a branch before and after it was rebased, the way that "git
+// range-diff" does. The entries are in the order of the new range, with
+// the commits which were dropped from the old range among them.
+//
+// Commits are first matched by having exactly the same changes. The rest
+// are matched so that the total size of the differences between their
+// patches is as small as possible, where leaving a commit unmatched costs
+// the CreationFactor percentage of the size of its own patch.
+func RangeDiff(c *Client, opts RangeDiffOptions, base1, tip1, base2, tip2 Commitish) ([]RangeDiffEntry, error) {
+	creationFactor := opts.CreationFactor
+	if creationFactor == 0 {
+		creationFactor = 60
+	}
+	a, err := rangeDiffCommits(c, base1, tip1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := rangeDiffCommits(c, base2, tip2)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exact matches of the changes.
+	byDiff := make(map[string]int)
+	for i := range a {
+		if _, ok := byDiff[a[i].Diff]; !ok {
+			byDiff[a[i].Diff] = i
+		}
+	}
+	for j := range b {
+		if i, ok := byDiff[b[j].Diff]; ok && a[i].Matching < 0 {
+			a[i].Matching = j
+			b[j].Matching = i
+		}
+	}
+
+	// The rest are matched by the cost of the differences, and each commit
+	// can be matched with a dummy commit on the other side to leave it
+	// unmatched.
+	n := len(a) + len(b)
+	cost := make([][]int, n)
+	for i := range cost {
+		cost[i] = make([]int, n)
+		for j := range cost[i] {
+			switch {
+			case i < len(a) && j < len(b):
+				if a[i].Matching >= 0 || b[j].Matching >= 0 {
+					if a[i].Matching == j {
+						cost[i][j] = 0
+					} else {
+						cost[i][j] = rangeDiffCostMax
+					}
+				} else {
+					cost[i][j] = rangeDiffSize(a[i].Diff, b[j].Diff)
+				}
+			case i < len(a):
+				if a[i].Matching < 0 {
+					cost[i][j] = a[i].DiffSize * creationFactor / 100
+				} else {
+					cost[i][j] = rangeDiffCostMax
+				}
+			case j < len(b):
+				if b[j].Matching < 0 {
+					cost[i][j] = b[j].DiffSize * creationFactor / 100
+				} else {
+					cost[i][j] = rangeDiffCostMax
+				}
+			}
+		}
+	}
+	for i, j := range minCostAssignment(cost) {
+		if i < len(a) && j < len(b) {
+			a[i].Matching = j
+			b[j].Matching = i
+		}
+	}
+
+	var entries []RangeDiffEntry
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		for i < len(a) && a[i].Shown {
+			i++
+		}
+		// The dropped commits are shown once the commits before them
+		// have been.
+		if i < len(a) && a[i].Matching < 0 {
+			entries = append(entries, RangeDiffEntry{Old: a[i].Commit, OldIndex: i + 1, Status: '<'})
+			i++
+			continue
+		}
+		for j < len(b) && b[j].Matching < 0 {
+			entries = append(entries, RangeDiffEntry{New: b[j].Commit, NewIndex: j + 1, Status: '>'})
+			j++
+		}
+		if j < len(b) {
+			old := &a[b[j].Matching]
+			entry := RangeDiffEntry{
+				Old:      old.Commit,
+				OldIndex: b[j].Matching + 1,
+				New:      b[j].Commit,
+				NewIndex: j + 1,
+				Status:   '=',
+			}
+			if old.Patch != b[j].Patch {
+				entry.Status = '!'
+				entry.InterDiff = rangeInterDiff(old.Patch, b[j].Patch)
+			}
+			entries = append(entries, entry)
+			old.Shown = true
+			j++
+		}
+	}
+	return entries, nil
+}
+
+// Returns the commits in base..tip which aren't merges, oldest first.
+func rangeDiffCommits(c *Client, base, tip Commitish) ([]rangeDiffCommit, error) {
+	revs, err := RevList(c, RevListOptions{Quiet: true}, nil, []Commitish{tip}, []Commitish{base})
+	if err != nil {
+		return nil, err
+	}
+	var commits []rangeDiffCommit
+	for i := len(revs) - 1; i >= 0; i-- {
+		cmt := CommitID(revs[i])
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return nil, err
+		}
+		if len(parents) > 1 {
+			continue
+		}
+		patch, diffStart, size, err := rangeDiffPatch(c, cmt, parents)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, rangeDiffCommit{
+			Commit:   cmt,
+			Patch:    patch,
+			Diff:     patch[diffStart:],
+			DiffSize: size,
+			Matching: -1,
+		})
+	}
+	return commits, nil
+}
+
+// Returns the patch of cmt in the format which git range-diff compares,
+// where the differences to the files are after a " ## path ##" line and
+// the lines of the hunk headers are left out. It also returns where the
+// changes to the files start, and the number of lines after it.
+func rangeDiffPatch(c *Client, cmt CommitID, parents []CommitID) (string, int, int, error) {
+	author, err := cmt.GetAuthor(c)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	msg, err := cmt.GetCommitMessage(c)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	var patch strings.Builder
+	fmt.Fprintf(&patch, " ## Metadata ##\nAuthor: %v\n\n ## Commit message ##\n", author)
+	for _, line := range strings.Split(strings.TrimSpace(msg.String()), "\n") {
+		fmt.Fprintf(&patch, "%v\n", strings.TrimRight("    "+line, " \t"))
+	}
+
+	var parent Treeish
+	if len(parents) > 0 {
+		parent = parents[0]
+	}
+	diffs, err := DiffTree(c, &DiffTreeOptions{Recurse: true, DiffRenameOptions: DiffRenameOptions{FindRenames: defaultSimilarity}}, parent, cmt, nil)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	diffStart, size := -1, 0
+	for _, d := range diffs {
+		if d.Src.FileMode == ModeTree || d.Dst.FileMode == ModeTree {
+			continue
+		}
+		patch.WriteByte('\n')
+		if diffStart < 0 {
+			diffStart = patch.Len()
+		}
+
+		var header string
+		switch status := d.Status(); {
+		case status == "A":
+			header = fmt.Sprintf("%v (new)", d.Name)
+		case status == "D":
+			header = fmt.Sprintf("%v (deleted)", d.Name)
+		case d.SrcName != "":
+			header = fmt.Sprintf("%v => %v", d.SrcName, d.Name)
+		default:
+			header = d.Name.String()
+		}
+		if d.Src.FileMode != 0 && d.Dst.FileMode != 0 && d.Src.FileMode != d.Dst.FileMode {
+			header += fmt.Sprintf(" (mode change %0.6o => %0.6o)", d.Src.FileMode, d.Dst.FileMode)
+		}
+		fmt.Fprintf(&patch, " ## %v ##\n", header)
+		size++
+
+		old, err := diffStatContent(c, d.Src)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		new, err := diffStatContent(c, d.Dst)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		for _, line := range unifiedDiffLines(old, new, 3) {
+			switch line[0] {
+			case '@':
+				line = "@@"
+			case '+', '-', ' ':
+			default:
+				line = " " + line
+			}
+			fmt.Fprintf(&patch, "%v\n", line)
+			size++
+		}
+	}
+	if diffStart < 0 {
+		diffStart = patch.Len()
+	}
+	return patch.String(), diffStart, size, nil
+}
+
+// Returns the number of lines in the diff between the patches a and b,
+// which is the cost of matching them.
+func rangeDiffSize(a, b string) int {
+	return len(unifiedDiffLines([]byte(a), []byte(b), 3))
+}
+
+var (
+	rangeDiffHunkRE = regexp.MustCompile(`^@@ -(\d+)`)
+	rangeDiffFuncRE = regexp.MustCompile(`^ ## (.*) ##$|^.?@@ (.*)$`)
+)
+
+// Returns the diff between the patches a and b, where each hunk header is
+// replaced by "@@" and the section of the patch that the hunk is in.
+func rangeInterDiff(a, b string) string {
+	alines := splitLines(a)
+	var out strings.Builder
+	for _, line := range unifiedDiffLines([]byte(a), []byte(b), 3) {
+		m := rangeDiffHunkRE.FindStringSubmatch(line)
+		if m == nil {
+			fmt.Fprintf(&out, "%v\n", line)
+			continue
+		}
+		// The section is found from the line before the hunk.
+		start, _ := strconv.Atoi(m[1])
+		if strings.HasPrefix(line, "@@ -"+m[1]+",0 ") {
+			start++
+		}
+		out.WriteString("@@")
+		for i := start - 2; i >= 0 && i < len(alines); i-- {
+			if f := rangeDiffFuncRE.FindStringSubmatch(alines[i]); f != nil {
+				out.WriteString(" " + f[1] + f[2])
+				break
+			}
+		}
+		out.WriteByte('\n')
+	}
+	return out.String()
+}
+
+// Returns the column assigned to each row of the square cost matrix so that
+// the total cost is as small as possible, using the Hungarian algorithm.
+func minCostAssignment(cost [][]int) []int {
+	n := len(cost)
+	// The potentials of the rows and columns, and the row assigned to
+	// each column, which are indexed from 1 so that 0 can be the row
+	// being added.
+	u := make([]int, n+1)
+	v := make([]int, n+1)
+	p := make([]int, n+1)
+	way := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]int, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = int(^uint(0) >> 1)
+		}
+		for {
+			used[j0] = true
+			i0, delta, j1 := p[j0], int(^uint(0)>>1), 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				if cur := cost[i0-1][j-1] - u[i0] - v[j]; cur < minv[j] {
+					minv[j], way[j] = cur, j0
+				}
+				if minv[j] < delta {
+					delta, j1 = minv[j], j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	assignment := make([]int, n)
+	for j := 1; j <= n; j++ {
+		if p[j] > 0 {
+			assignment[p[j]-1] = j - 1
+		}
+	}
+	return assignment
+}
@@ -0,0 +1,59 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests that RangeDiff matches the commits of a branch to those of the
+// branch after it was rebased, where one commit was reworded, one was
+// dropped and another was added.
+func TestRangeDiff(t *testing.T) {
+	c, cleanup := testRepo(t, "gitrangediff")
+	defer cleanup()
+
+	base := testCommitFile(t, c, "f.txt", "one\ntwo\n", "base")
+	b1 := testCommitFile(t, c, "g.txt", "g1\ng2\ng3\n", "add g")
+	c1 := testCommitFile(t, c, "h.txt", "h1\nh2\n", "add h")
+	d1 := testCommitFile(t, c, "i.txt", "i1\n", "add i")
+
+	if _, err := ResetMode(c, ResetOptions{Hard: true}, base); err != nil {
+		t.Fatal(err)
+	}
+	base2 := testCommitFile(t, c, "u.txt", "u\n", "add u")
+	b2 := testCommitFile(t, c, "g.txt", "g1\ng2\ng3\n", "add g")
+	c2 := testCommitFile(t, c, "h.txt", "h1\nh2\n", "add h file\n\nwith a body")
+	e2 := testCommitFile(t, c, "e.txt", "e1\ne2\ne3\ne4\n", "add e")
+
+	entries, err := RangeDiff(c, RangeDiffOptions{}, base, d1, base2, e2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RangeDiffEntry{
+		{Old: b1, OldIndex: 1, New: b2, NewIndex: 1, Status: '='},
+		{Old: c1, OldIndex: 2, New: c2, NewIndex: 2, Status: '!'},
+		{Old: d1, OldIndex: 3, Status: '<'},
+		{New: e2, NewIndex: 3, Status: '>'},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Unexpected entries: got %v want %v", entries, want)
+	}
+	for i, e := range entries {
+		got := e
+		got.InterDiff = ""
+		if got != want[i] {
+			t.Errorf("Entry %d: got %v want %v", i, got, want[i])
+		}
+	}
+
+	diff := entries[1].InterDiff
+	if !strings.HasPrefix(diff, "@@ Metadata\n") {
+		t.Errorf("Unexpected section of the inter-diff: %q", diff)
+	}
+	if !strings.Contains(diff, "\n-    add h\n+    add h file\n") {
+		t.Errorf("Inter-diff doesn't have the reworded message: %q", diff)
+	}
+	if entries[0].InterDiff != "" {
+		t.Errorf("Unexpected inter-diff for an unchanged commit: %q", entries[0].InterDiff)
+	}
+}
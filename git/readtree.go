@@ -128,10 +128,14 @@ func parseSparsePatterns(c *Client, opt *ReadTreeOptions) []IgnorePattern {
 //
 // If options.DryRun is not false, it will also be written to the Client's index file.
 func ReadTreeThreeWay(c *Client, opt ReadTreeOptions, stage1, stage2, stage3 Treeish) (*Index, error) {
-	idx, err := c.GitDir.ReadIndex()
+	idx, lock, err := readtreeLockIndex(c, opt)
 	if err != nil {
 		return nil, err
 	}
+	defer lock.Rollback()
+	if err := idx.EnsureFull(c); err != nil {
+		return nil, err
+	}
 
 	resetremovals, err := checkReadtreePrereqs(c, opt, idx)
 	if err != nil {
@@ -363,7 +367,7 @@ paths:
 		return nil, err
 	}
 
-	return idx, readtreeSaveIndex(c, opt, idx)
+	return idx, readtreeSaveIndex(c, opt, idx, lock)
 }
 
 // ReadTreeFastForward will return a new Index with parent fast-forwarded to
@@ -412,10 +416,14 @@ func ReadTreeFastForward(c *Client, opt ReadTreeOptions, parent, dst Treeish) (*
 	//	   19 no    no	  yes	  exists   exists   keep index
 	//	   20 yes   yes   no	  exists   exists   use M
 	//	   21 no    yes   no	  exists   exists   fail
-	idx, err := c.GitDir.ReadIndex()
+	idx, lock, err := readtreeLockIndex(c, opt)
 	if err != nil {
 		return nil, err
 	}
+	defer lock.Rollback()
+	if err := idx.EnsureFull(c); err != nil {
+		return nil, err
+	}
 
 	resetremovals, err := checkReadtreePrereqs(c, opt, idx)
 	if err != nil {
@@ -533,22 +541,39 @@ func ReadTreeFastForward(c *Client, opt ReadTreeOptions, parent, dst Treeish) (*
 		return nil, err
 	}
 
-	return newidx, readtreeSaveIndex(c, opt, newidx)
+	return newidx, readtreeSaveIndex(c, opt, newidx, lock)
+}
+
+// Locks the file that the index is written to, which is opt.IndexOutput if
+// it's set, and then reads the index. As in git, the lock is taken even
+// with DryRun, so that the index that's read can't be changed while the
+// new one is being computed from it.
+func readtreeLockIndex(c *Client, opt ReadTreeOptions) (*Index, *LockFile, error) {
+	if opt.IndexOutput == "" || opt.IndexOutput == "index" {
+		return c.GitDir.LockIndex()
+	}
+	lock, err := c.GitDir.Lock(File(opt.IndexOutput))
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		lock.Rollback()
+		return nil, nil, err
+	}
+	return idx, lock, nil
 }
 
 // Helper to ensure the DryRun option gets checked no matter what the code path
-// for ReadTree.
-func readtreeSaveIndex(c *Client, opt ReadTreeOptions, i *Index) error {
+// for ReadTree. If it isn't a dry run, i is committed to lock, which was
+// returned by readtreeLockIndex.
+func readtreeSaveIndex(c *Client, opt ReadTreeOptions, i *Index, lock *LockFile) error {
 	if !opt.DryRun {
-		if opt.IndexOutput == "" {
-			opt.IndexOutput = "index"
-		}
-		f, err := c.GitDir.Create(File(opt.IndexOutput))
-		if err != nil {
+		if err := i.ConvertToSparse(c); err != nil {
 			return err
 		}
-		defer f.Close()
-		return i.WriteIndex(f)
+		i.UseConfiguredVersion(c)
+		return i.CommitLocked(lock)
 	}
 	return nil
 }
@@ -594,10 +619,60 @@ func checkReadtreePrereqs(c *Client, opt ReadTreeOptions, idx *Index) ([]File, e
 
 }
 
+// ReadTrees implements "git read-tree" for any number of trees, choosing
+// the operation by the number of trees given:
+//
+// With no trees, the index is emptied, which requires opt.Empty. With one
+// tree, it's read into the index with ReadTree. With two trees, the index
+// is fast-forwarded from the first to the second with ReadTreeFastForward.
+// With three or more, ReadTreeThreeWay merges the second and third trees
+// with the first as their base, producing stage 1, 2 and 3 entries for
+// the paths which can't be resolved trivially.
+func ReadTrees(c *Client, opt ReadTreeOptions, trees ...Treeish) (*Index, error) {
+	switch len(trees) {
+	case 0:
+		if !opt.Empty {
+			return nil, fmt.Errorf("No tree to read")
+		}
+		return ReadTree(c, opt, nil)
+	case 1:
+		return ReadTree(c, opt, trees[0])
+	case 2:
+		return ReadTreeFastForward(c, opt, trees[0], trees[1])
+	default:
+		// The last test in the t1000-read-tree-m-3way.sh test suite
+		// calls "git read-tree -m $tree0 $tree1 $tree1 $tree0" and
+		// expects it to succeed.
+		//
+		// git-read-tree(1) doesn't really have any guidance on how to
+		// interpret a command that looks like that, so we just treat
+		// everything that has >= 3 trees as a 3-way merge, discarding
+		// trees after the first three and hope for the best.
+		return ReadTreeThreeWay(c, opt, trees[0], trees[1], trees[2])
+	}
+}
+
 // Reads a tree into the index. If DryRun is not false, it will also be written
 // to disk.
 func ReadTree(c *Client, opt ReadTreeOptions, tree Treeish) (*Index, error) {
-	idx, _ := c.GitDir.ReadIndex()
+	idx, lock, err := readtreeLockIndex(c, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Rollback()
+	newidx, err := readTree(c, opt, idx, tree)
+	if err != nil {
+		return nil, err
+	}
+	return newidx, readtreeSaveIndex(c, opt, newidx, lock)
+}
+
+// Reads tree into idx, which must have been read while holding the index
+// lock, and returns the new index without writing it.
+func readTree(c *Client, opt ReadTreeOptions, idx *Index, tree Treeish) (*Index, error) {
+	if err := idx.EnsureFull(c); err != nil {
+		return nil, err
+	}
 	origMap := idx.GetMap()
 
 	resetremovals, err := checkReadtreePrereqs(c, opt, idx)
@@ -609,10 +684,15 @@ func ReadTree(c *Client, opt ReadTreeOptions, tree Treeish) (*Index, error) {
 	if opt.Empty {
 		idx.NumberIndexEntries = 0
 		idx.Objects = make([]*IndexEntry, 0)
+		idx.cacheTree = nil
+		idx.untracked = nil
+		if idx.fsmonitor != nil {
+			idx.fsmonitor.valid = nil
+		}
 		if err := checkMergeAndUpdate(c, opt, origMap, idx, resetremovals); err != nil {
 			return nil, err
 		}
-		return idx, readtreeSaveIndex(c, opt, idx)
+		return idx, nil
 	}
 	newidx := NewIndex()
 	if err := newidx.ResetIndex(c, tree); err != nil {
@@ -644,7 +724,27 @@ func ReadTree(c *Client, opt ReadTreeOptions, tree Treeish) (*Index, error) {
 	if err := checkMergeAndUpdate(c, opt, origMap, idx, resetremovals); err != nil {
 		return nil, err
 	}
-	return idx, readtreeSaveIndex(c, opt, idx)
+	return idx, nil
+}
+
+// Resets the index and the work tree to tree while holding the index lock,
+// as "git read-tree --reset -u" followed by "git checkout-index -a -f -u"
+// would.
+func resetToTree(c *Client, tree Treeish) error {
+	idx, lock, err := c.GitDir.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	opt := ReadTreeOptions{Reset: true, Update: true}
+	newidx, err := readTree(c, opt, idx, tree)
+	if err != nil {
+		return err
+	}
+	if err := newidx.ConvertToSparse(c); err != nil {
+		return err
+	}
+	return CheckoutIndexUncommited(c, newidx, CheckoutIndexOptions{All: true, Force: true, UpdateStat: true, indexLock: lock}, nil)
 }
 
 // Check if the merge would overwrite any modified files and return an error if so (unless --reset),
@@ -205,3 +205,57 @@ func TestReadTreeThreeWayConflict(t *testing.T) {
 		t.Errorf("Unexpected hash for foo: got %v want %v", idx.Objects[1].Sha1, hash)
 	}
 }
+
+// Tests that reading a single tree replaces the index, and the work tree
+// with Update.
+func TestReadTreesSingle(t *testing.T) {
+	c, cleanup := testRepo(t, "gitreadtreesingle")
+	defer cleanup()
+	first := testCommitFile(t, c, "foo", "foo\n", "First commit")
+	testCommitFile(t, c, "bar", "bar\n", "Second commit")
+
+	idx, err := ReadTrees(c, ReadTreeOptions{Reset: true, Update: true}, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Objects) != 1 || idx.Objects[0].PathName != "foo" || idx.Objects[0].Sha1 != hashString("foo\n") {
+		t.Errorf("Unexpected index after reading tree: %v", idx.Objects)
+	}
+	if saved, err := c.GitDir.ReadIndex(); err != nil || len(saved.Objects) != 1 {
+		t.Errorf("Index was not saved: %v (%v)", saved, err)
+	}
+	if File("bar").Exists() {
+		t.Errorf("File not in the tree was left in the work tree")
+	}
+}
+
+// Tests a two tree merge which fast-forwards the index and work tree from
+// one commit to the next.
+func TestReadTreesFastForward(t *testing.T) {
+	c, cleanup := testRepo(t, "gitreadtreeff")
+	defer cleanup()
+	first := testCommitFile(t, c, "foo", "foo\n", "First commit")
+	testCommitFile(t, c, "foo", "foo2\n", "Second commit")
+	second := testCommitFile(t, c, "bar", "bar\n", "Third commit")
+	if _, err := ReadTrees(c, ReadTreeOptions{Reset: true, Update: true}, first); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := ReadTrees(c, ReadTreeOptions{Merge: true, Update: true}, first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := idx.GetMap()
+	if len(idx.Objects) != 2 {
+		t.Errorf("Unexpected index after merge: %v", idx.Objects)
+	}
+	for name, content := range map[IndexPath]string{"foo": "foo2\n", "bar": "bar\n"} {
+		entry, ok := entries[name]
+		if !ok || entry.Stage() != Stage0 || entry.Sha1 != hashString(content) {
+			t.Errorf("Unexpected entry for %v after merge: %v", name, entry)
+		}
+		if got, err := ioutil.ReadFile(name.String()); err != nil || string(got) != content {
+			t.Errorf("Unexpected content of %v after merge: %q (%v)", name, got, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package git
+
+import (
+	"fmt"
+	"os"
+)
+
+// RebaseOptions are the options which may be passed to "git rebase".
+type RebaseOptions struct {
+	// Not implemented, since there's no editor for the todo list. Use
+	// RebaseInteractive with the steps instead.
+	Interactive bool
+
+	// Not implemented
+	Onto Commitish
+}
+
+// Returns the commits reachable from head which aren't reachable from
+// upstream, ordered so that every commit comes after its parents.
+func rebaseCommits(c *Client, head, upstream CommitID) ([]CommitID, error) {
+	commits, err := RevList(c, RevListOptions{Quiet: true}, nil, []Commitish{head}, []Commitish{upstream})
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[CommitID]bool)
+	for _, s := range commits {
+		pending[CommitID(s)] = true
+	}
+	var ordered []CommitID
+	var visit func(cmt CommitID) error
+	visit = func(cmt CommitID) error {
+		if !pending[cmt] {
+			return nil
+		}
+		delete(pending, cmt)
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return err
+		}
+		for _, p := range parents {
+			if err := visit(p); err != nil {
+				return err
+			}
+		}
+		ordered = append(ordered, cmt)
+		return nil
+	}
+	if err := visit(head); err != nil {
+		return nil, err
+	}
+	return ordered, nil
+}
+
+// Sets the author environment variables used by CommitTree to the author
+// of cmt, and returns a function which restores them.
+func useAuthorOf(c *Client, cmt CommitID) (func(), error) {
+	author, err := cmt.GetAuthor(c)
+	if err != nil {
+		return nil, err
+	}
+	date, err := cmt.GetDate(c)
+	if err != nil {
+		return nil, err
+	}
+	oldname, oldemail, olddate := os.Getenv("GIT_AUTHOR_NAME"), os.Getenv("GIT_AUTHOR_EMAIL"), os.Getenv("GIT_AUTHOR_DATE")
+	os.Setenv("GIT_AUTHOR_NAME", author.Name)
+	os.Setenv("GIT_AUTHOR_EMAIL", author.Email)
+	os.Setenv("GIT_AUTHOR_DATE", date.Format("Mon, 02 Jan 2006 15:04:05 -0700"))
+	return func() {
+		os.Setenv("GIT_AUTHOR_NAME", oldname)
+		os.Setenv("GIT_AUTHOR_EMAIL", oldemail)
+		os.Setenv("GIT_AUTHOR_DATE", olddate)
+	}, nil
+}
+
+// Applies the changes introduced by cmt on top of onto, which must be
+// what's checked out in the index and work tree, and commits them with
+// cmt's author and message. If the changes are already in onto, onto is
+// returned. The paths which had conflicts are returned if the changes
+// can't be applied cleanly.
+func pickCommit(c *Client, cmt, onto CommitID) (CommitID, []IndexPath, error) {
+	parents, err := cmt.Parents(c)
+	if err != nil {
+		return CommitID{}, nil, err
+	}
+	if len(parents) != 1 {
+		return CommitID{}, nil, fmt.Errorf("Can not apply %v: only commits with exactly one parent may be applied", cmt)
+	}
+	idx, err := ReadTreeThreeWay(c, ReadTreeOptions{Merge: true, Update: true}, parents[0], onto, cmt)
+	if err != nil {
+		return CommitID{}, nil, err
+	}
+	if len(idx.GetUnmerged()) > 0 {
+		conflicted, err := mergeUnmergedFiles(c, idx, MergeFileOptions{
+			CurrentLabel: "HEAD",
+			BaseLabel:    "parent of " + cmt.String(),
+			OtherLabel:   cmt.String(),
+		})
+		if err != nil {
+			return CommitID{}, nil, err
+		}
+		if err := stageCleanMerges(c, idx, conflicted); err != nil {
+			return CommitID{}, nil, err
+		}
+		if err := c.GitDir.WriteLocked("index", idx.WriteIndex); err != nil {
+			return CommitID{}, nil, err
+		}
+		if len(conflicted) > 0 {
+			return CommitID{}, conflicted, nil
+		}
+	}
+
+	tree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return CommitID{}, nil, err
+	}
+	if ontotree, err := onto.TreeID(c); err != nil {
+		return CommitID{}, nil, err
+	} else if ontotree == tree {
+		return onto, nil, nil
+	}
+	msg, err := cmt.GetCommitMessage(c)
+	if err != nil {
+		return CommitID{}, nil, err
+	}
+	restore, err := useAuthorOf(c, cmt)
+	if err != nil {
+		return CommitID{}, nil, err
+	}
+	defer restore()
+	newcmt, err := CommitTree(c, CommitTreeOptions{}, tree, []CommitID{onto}, msg.String())
+	return newcmt, nil, err
+}
+
+// Rebase replays the commits on the current branch which aren't in
+// upstream on top of upstream, and moves the branch to the result, as
+// "git rebase <upstream>". Merge commits aren't replayed, and commits whose
+// changes are already in upstream are dropped.
+//
+// There is no sequencer to stop and continue a rebase, so if a commit can't
+// be applied cleanly the rebase is aborted and the index and work tree are
+// restored to the original HEAD.
+func Rebase(c *Client, opts RebaseOptions, upstream Commitish) error {
+	if opts.Interactive || opts.Onto != nil {
+		return fmt.Errorf("Rebase options not implemented")
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	onto, err := upstream.CommitID(c)
+	if err != nil {
+		return err
+	}
+	if onto.IsAncestor(c, head) {
+		// Everything in upstream is already in the branch.
+		return nil
+	}
+
+	// If a commit can't be applied, the index and work tree are reset to
+	// head, so there mustn't be any local changes for it to lose.
+	if err := requireClean(c, "rebase"); err != nil {
+		return err
+	}
+	commits, err := rebaseCommits(c, head, onto)
+	if err != nil {
+		return err
+	}
+	if _, err := ReadTreeFastForward(c, ReadTreeOptions{Merge: true, Update: true}, head, onto); err != nil {
+		return err
+	}
+	current := onto
+	for _, cmt := range commits {
+		if parents, err := cmt.Parents(c); err != nil {
+			return err
+		} else if len(parents) > 1 {
+			continue
+		}
+		newcmt, conflicted, err := pickCommit(c, cmt, current)
+		if err == nil && len(conflicted) == 0 {
+			current = newcmt
+			continue
+		}
+
+		// Put everything back the way it was before giving up.
+		if rerr := resetToTree(c, head); rerr != nil {
+			return rerr
+		}
+		if err != nil {
+			return err
+		}
+		msg, _ := cmt.GetCommitMessage(c)
+		return fmt.Errorf("%vcould not apply %v... %v\nThe rebase was aborted.", conflictMessage(c, conflicted), cmt, msg.Subject())
+	}
+
+	if err := UpdateRef(c, UpdateRefOptions{NoDeref: true}, "ORIG_HEAD", head, ""); err != nil {
+		return err
+	}
+	var refmsg string
+	if b := c.GetHeadBranch(); b != "" {
+		refmsg = fmt.Sprintf("rebase (finish): %v onto %v", b, onto)
+	} else {
+		refmsg = fmt.Sprintf("rebase (finish): returning to %v", current)
+	}
+	return UpdateRef(c, UpdateRefOptions{OldValue: head, CreateReflog: true}, "HEAD", current, refmsg)
+}
@@ -0,0 +1,518 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A RebaseAction is what a step of an interactive rebase does.
+type RebaseAction string
+
+const (
+	// Apply the commit.
+	RebasePick = RebaseAction("pick")
+	// Apply the commit with a new message.
+	RebaseReword = RebaseAction("reword")
+	// Apply the commit and stop, so that it can be amended.
+	RebaseEdit = RebaseAction("edit")
+	// Meld the commit into the previous one, combining their messages.
+	RebaseSquash = RebaseAction("squash")
+	// Meld the commit into the previous one, keeping the previous
+	// message.
+	RebaseFixup = RebaseAction("fixup")
+	// Leave the commit out.
+	RebaseDrop = RebaseAction("drop")
+	// Run a shell command, and stop if it fails.
+	RebaseExec = RebaseAction("exec")
+)
+
+// A RebaseStep is a line of the todo list of an interactive rebase.
+type RebaseStep struct {
+	Action RebaseAction
+
+	// The commit to apply. It isn't used by RebaseExec.
+	Commit CommitID
+
+	// The shell command run by RebaseExec.
+	Command string
+
+	// The new message for RebaseReword. For RebaseSquash and RebaseFixup
+	// it replaces the combined message if it's set. Since there's no
+	// editor, it's required for RebaseReword.
+	Message string
+}
+
+// Returns the step as a line of a todo list.
+func (s RebaseStep) String() string {
+	if s.Action == RebaseExec {
+		return fmt.Sprintf("%v %v", s.Action, s.Command)
+	}
+	return fmt.Sprintf("%v %v", s.Action, s.Commit)
+}
+
+// ParseRebaseTodo parses a todo list in the format of git-rebase-todo. The
+// short forms of the actions (such as "p" for "pick") are accepted, and
+// blank lines and lines starting with a # are ignored. Anything after the
+// commit on a line, such as its subject, is ignored.
+func ParseRebaseTodo(c *Client, r io.Reader) ([]RebaseStep, error) {
+	actions := map[string]RebaseAction{
+		"p": RebasePick, "r": RebaseReword, "e": RebaseEdit, "s": RebaseSquash,
+		"f": RebaseFixup, "d": RebaseDrop, "x": RebaseExec,
+	}
+	for _, a := range []RebaseAction{RebasePick, RebaseReword, RebaseEdit, RebaseSquash, RebaseFixup, RebaseDrop, RebaseExec} {
+		actions[string(a)] = a
+	}
+	var steps []RebaseStep
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		action, ok := actions[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("Invalid rebase todo line: %v", line)
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Missing %v argument: %v", action, line)
+		}
+		if action == RebaseExec {
+			steps = append(steps, RebaseStep{Action: action, Command: strings.TrimSpace(fields[1])})
+			continue
+		}
+		cmts, err := RevParse(c, RevParseOptions{Verify: true}, strings.Fields(fields[1])[:1])
+		if err != nil {
+			return nil, err
+		}
+		cmt, err := cmts[0].CommitID(c)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, RebaseStep{Action: action, Commit: cmt})
+	}
+	return steps, scanner.Err()
+}
+
+// RebaseStoppedError is returned by RebaseInteractive and RebaseContinue
+// when the rebase stops at an edit step. The state of the rebase is saved,
+// so that the commit can be amended before calling RebaseContinue.
+type RebaseStoppedError struct {
+	Commit CommitID
+}
+
+func (e RebaseStoppedError) Error() string {
+	return fmt.Sprintf("Stopped at %v\nYou can amend the commit now, then continue the rebase.", e.Commit)
+}
+
+// The state of an interactive rebase, which is saved in the rebase-merge
+// directory in the same way as git.
+type rebaseState struct {
+	// The branch being rebased, or "detached HEAD".
+	HeadName string
+
+	OrigHead, Onto CommitID
+}
+
+const rebaseStateDir = "rebase-merge"
+
+// Returns whether there's an interactive rebase in progress.
+func rebaseInProgress(c *Client) bool {
+	return c.GitDir.File(rebaseStateDir).Exists()
+}
+
+// Saves the state of the rebase, with the steps which are still to be
+// done. If amend is set, continuing the rebase amends HEAD with the changes
+// in the index instead of making a new commit. If message is set, it's the
+// message used when the rebase is continued. stopped is the commit that
+// the rebase stopped at.
+func (s rebaseState) save(c *Client, todo []RebaseStep, stopped CommitID, amend bool, message string) error {
+	dir := c.GitDir.File(rebaseStateDir)
+	if err := os.RemoveAll(dir.String()); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir.String(), 0755); err != nil {
+		return err
+	}
+	files := map[File]string{
+		"head-name":   s.HeadName + "\n",
+		"orig-head":   s.OrigHead.String() + "\n",
+		"onto":        s.Onto.String() + "\n",
+		"stopped-sha": stopped.String() + "\n",
+	}
+	if amend {
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			return err
+		}
+		files["amend"] = head.String() + "\n"
+	}
+	if message != "" {
+		files["message"] = message
+	}
+	var lines strings.Builder
+	for i, step := range todo {
+		fmt.Fprintln(&lines, step)
+		if step.Message != "" {
+			// The messages can't go in the todo list, so they're
+			// saved by line number.
+			files[File(fmt.Sprintf("message-%d", i+1))] = step.Message
+		}
+	}
+	files["git-rebase-todo"] = lines.String()
+	for name, content := range files {
+		if err := c.GitDir.WriteFile(rebaseStateDir+"/"+name, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads a file of the saved rebase state, with any trailing whitespace
+// removed. It returns the empty string if the file doesn't exist.
+func readRebaseFile(c *Client, name File) string {
+	content, err := c.GitDir.ReadFile(rebaseStateDir + "/" + name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(content), " \n")
+}
+
+// Loads the state of the rebase in progress, with the steps which are
+// still to be done.
+func loadRebaseState(c *Client) (rebaseState, []RebaseStep, error) {
+	if !rebaseInProgress(c) {
+		return rebaseState{}, nil, fmt.Errorf("No rebase in progress")
+	}
+	state := rebaseState{HeadName: readRebaseFile(c, "head-name")}
+	var err error
+	if state.OrigHead, err = CommitIDFromString(readRebaseFile(c, "orig-head")); err != nil {
+		return rebaseState{}, nil, err
+	}
+	if state.Onto, err = CommitIDFromString(readRebaseFile(c, "onto")); err != nil {
+		return rebaseState{}, nil, err
+	}
+	todo, err := ParseRebaseTodo(c, strings.NewReader(readRebaseFile(c, "git-rebase-todo")))
+	if err != nil {
+		return rebaseState{}, nil, err
+	}
+	for i := range todo {
+		if msg, err := c.GitDir.ReadFile(File(fmt.Sprintf("%v/message-%d", rebaseStateDir, i+1))); err == nil {
+			todo[i].Message = string(msg)
+		}
+	}
+	return state, todo, nil
+}
+
+// Points the detached HEAD of the rebase at cmt.
+func (s rebaseState) detachHead(c *Client, cmt CommitID, action string) error {
+	return UpdateRef(c, UpdateRefOptions{NoDeref: true, CreateReflog: true}, "HEAD", cmt, fmt.Sprintf("rebase -i (%v): %v", action, cmt))
+}
+
+// Returns the message of prev with the message of next added to it, as
+// squashing next into prev does.
+func squashMessage(prev, next string) string {
+	return strings.TrimRight(prev, "\n") + "\n\n" + strings.TrimRight(next, "\n") + "\n"
+}
+
+// Commits tree with the message, the author of author and the parents of
+// replace, replacing it.
+func replaceCommit(c *Client, replace, author CommitID, tree TreeID, message string) (CommitID, error) {
+	parents, err := replace.Parents(c)
+	if err != nil {
+		return CommitID{}, err
+	}
+	restore, err := useAuthorOf(c, author)
+	if err != nil {
+		return CommitID{}, err
+	}
+	defer restore()
+	return CommitTree(c, CommitTreeOptions{}, tree, parents, message)
+}
+
+// RebaseInteractive rebases the current branch by doing each of the steps
+// in todo in order, as "git rebase -i" does with the todo list from its
+// editor. The steps are replayed on top of the parent of the first commit
+// in todo, and commits which aren't in todo are left out.
+//
+// If a commit can't be applied cleanly or an exec step fails, the rebase
+// stops and its state is saved, so that the conflicts can be resolved
+// before RebaseContinue, or the rebase undone with RebaseAbort. It also
+// stops with a RebaseStoppedError after applying each edit step.
+//
+// The steps can be rearranged with AutosquashTodo first, as with
+// "git rebase -i --autosquash".
+func RebaseInteractive(c *Client, todo []RebaseStep) error {
+	if rebaseInProgress(c) {
+		return fmt.Errorf("A rebase is already in progress")
+	}
+	// As in git, a squash or fixup needs a step before it which isn't a
+	// drop, so that there's a commit for it to be squashed into.
+	var first *RebaseStep
+	var fixupOK bool
+	for i, step := range todo {
+		switch step.Action {
+		case RebasePick, RebaseReword, RebaseEdit:
+			fixupOK = true
+		case RebaseDrop:
+		case RebaseSquash, RebaseFixup:
+			if !fixupOK {
+				return fmt.Errorf("cannot '%v' without a previous commit", step.Action)
+			}
+		case RebaseExec:
+			fixupOK = true
+			continue
+		default:
+			return fmt.Errorf("Invalid rebase action %v", step.Action)
+		}
+		if step.Action == RebaseReword && step.Message == "" {
+			return fmt.Errorf("reword of %v requires a message", step.Commit)
+		}
+		if first == nil {
+			first = &todo[i]
+		}
+	}
+	if first == nil {
+		return fmt.Errorf("Nothing to do")
+	}
+
+	if err := requireClean(c, "rebase"); err != nil {
+		return err
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	parents, err := first.Commit.Parents(c)
+	if err != nil {
+		return err
+	}
+	if len(parents) != 1 {
+		return fmt.Errorf("Can not rebase onto the parent of %v: it has %d parents", first.Commit, len(parents))
+	}
+	state := rebaseState{HeadName: "detached HEAD", OrigHead: head, Onto: parents[0]}
+	if b := c.GetHeadBranch(); b != "" {
+		state.HeadName = b.String()
+	}
+
+	if _, err := ReadTreeFastForward(c, ReadTreeOptions{Merge: true, Update: true}, head, state.Onto); err != nil {
+		return err
+	}
+	if err := state.detachHead(c, state.Onto, "start"); err != nil {
+		return err
+	}
+	return state.run(c, state.Onto, todo)
+}
+
+// Does the steps in todo on top of current, which is checked out as the
+// detached HEAD, and finishes the rebase.
+func (s rebaseState) run(c *Client, current CommitID, todo []RebaseStep) error {
+	for i, step := range todo {
+		remaining := todo[i+1:]
+		switch step.Action {
+		case RebaseDrop:
+			continue
+		case RebaseExec:
+			cmd := exec.Command("sh", "-c", step.Command)
+			cmd.Dir = c.WorkDir.String()
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+			if err := cmd.Run(); err != nil {
+				if serr := s.save(c, remaining, current, false, ""); serr != nil {
+					return serr
+				}
+				return fmt.Errorf("Execution failed: %v\n%v\nYou can fix the problem, and then continue the rebase.", step.Command, err)
+			}
+			continue
+		}
+
+		cmt := step.Commit
+		msg, err := cmt.GetCommitMessage(c)
+		if err != nil {
+			return err
+		}
+		message := msg.String()
+		switch step.Action {
+		case RebaseReword:
+			message = step.Message
+		case RebaseSquash, RebaseFixup:
+			prev, err := current.GetCommitMessage(c)
+			if err != nil {
+				return err
+			}
+			message = prev.String()
+			if step.Action == RebaseSquash {
+				message = squashMessage(message, msg.String())
+			}
+			if step.Message != "" {
+				message = step.Message
+			}
+		}
+
+		var newcmt CommitID
+		var conflicted []IndexPath
+		if parents, err := cmt.Parents(c); err == nil && len(parents) == 1 && parents[0] == current && (step.Action == RebasePick || step.Action == RebaseEdit) {
+			// The commit is already on top of current, so it can
+			// be reused instead of being replayed.
+			if _, err := ReadTreeFastForward(c, ReadTreeOptions{Merge: true, Update: true}, current, cmt); err != nil {
+				return err
+			}
+			newcmt = cmt
+		} else {
+			newcmt, conflicted, err = pickCommit(c, cmt, current)
+		}
+		if err != nil || len(conflicted) > 0 {
+			squashing := step.Action == RebaseSquash || step.Action == RebaseFixup
+			if serr := s.save(c, remaining, cmt, squashing, message); serr != nil {
+				return serr
+			}
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%vcould not apply %v... %v\nResolve the conflicts, and then continue the rebase.", conflictMessage(c, conflicted), cmt, msg.Subject())
+		}
+
+		tree, err := newcmt.TreeID(c)
+		if err != nil {
+			return err
+		}
+		switch step.Action {
+		case RebaseReword:
+			// If the changes were already in current, there's
+			// nothing to reword.
+			if newcmt != current {
+				if newcmt, err = replaceCommit(c, newcmt, cmt, tree, message); err != nil {
+					return err
+				}
+			}
+		case RebaseSquash, RebaseFixup:
+			if newcmt, err = replaceCommit(c, current, current, tree, message); err != nil {
+				return err
+			}
+		}
+		current = newcmt
+		if err := s.detachHead(c, current, string(step.Action)); err != nil {
+			return err
+		}
+		if step.Action == RebaseEdit {
+			if err := s.save(c, remaining, cmt, true, ""); err != nil {
+				return err
+			}
+			return RebaseStoppedError{cmt}
+		}
+	}
+	return s.finish(c, current)
+}
+
+// Moves the branch being rebased to current, checks it out again, and
+// removes the saved state.
+func (s rebaseState) finish(c *Client, current CommitID) error {
+	if err := UpdateRef(c, UpdateRefOptions{NoDeref: true}, "ORIG_HEAD", s.OrigHead, ""); err != nil {
+		return err
+	}
+	if strings.HasPrefix(s.HeadName, "refs/") {
+		refmsg := fmt.Sprintf("rebase -i (finish): %v onto %v", s.HeadName, s.Onto)
+		if err := UpdateRef(c, UpdateRefOptions{CreateReflog: true}, s.HeadName, current, refmsg); err != nil {
+			return err
+		}
+		refmsg = fmt.Sprintf("rebase -i (finish): returning to %v", s.HeadName)
+		if err := SymbolicRefUpdate(c, SymbolicRefOptions{}, "HEAD", RefSpec(s.HeadName), refmsg); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(c.GitDir.File(rebaseStateDir).String())
+}
+
+// RebaseContinue continues the interactive rebase in progress after it
+// stopped. If it stopped at a conflict, the resolved changes in the index
+// are committed with the message of the commit which couldn't be applied.
+// If it stopped at an edit step, any changes in the index are amended into
+// the commit.
+func RebaseContinue(c *Client) error {
+	state, todo, err := loadRebaseState(c)
+	if err != nil {
+		return err
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return err
+	}
+	if len(idx.GetUnmerged()) > 0 {
+		return fmt.Errorf("You must resolve all merge conflicts before continuing the rebase")
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return err
+	}
+	tree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return err
+	}
+	headtree, err := head.TreeID(c)
+	if err != nil {
+		return err
+	}
+	stopped, err := CommitIDFromString(readRebaseFile(c, "stopped-sha"))
+	if err != nil {
+		return err
+	}
+	message, amend := readRebaseFile(c, "message"), readRebaseFile(c, "amend") != ""
+	if message != "" {
+		message += "\n"
+	}
+
+	current := head
+	switch {
+	case amend && (tree != headtree || message != ""):
+		if message == "" {
+			msg, err := head.GetCommitMessage(c)
+			if err != nil {
+				return err
+			}
+			message = msg.String()
+		}
+		if current, err = replaceCommit(c, head, head, tree, message); err != nil {
+			return err
+		}
+	case !amend && tree != headtree:
+		restore, err := useAuthorOf(c, stopped)
+		if err != nil {
+			return err
+		}
+		current, err = CommitTree(c, CommitTreeOptions{}, tree, []CommitID{head}, message)
+		restore()
+		if err != nil {
+			return err
+		}
+	}
+	if current != head {
+		if err := state.detachHead(c, current, "continue"); err != nil {
+			return err
+		}
+	}
+	return state.run(c, current, todo)
+}
+
+// RebaseAbort abandons the interactive rebase in progress, and restores
+// the branch, index and work tree to the way that they were before it
+// started.
+func RebaseAbort(c *Client) error {
+	state, _, err := loadRebaseState(c)
+	if err != nil {
+		return err
+	}
+	if err := resetToTree(c, state.OrigHead); err != nil {
+		return err
+	}
+	if strings.HasPrefix(state.HeadName, "refs/") {
+		if err := SymbolicRefUpdate(c, SymbolicRefOptions{}, "HEAD", RefSpec(state.HeadName), "rebase -i (abort): returning to "+state.HeadName); err != nil {
+			return err
+		}
+	} else if err := state.detachHead(c, state.OrigHead, "abort"); err != nil {
+		return err
+	}
+	return os.RemoveAll(c.GitDir.File(rebaseStateDir).String())
+}
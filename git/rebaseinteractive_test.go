@@ -0,0 +1,194 @@
+package git
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// Tests that squashing two commits into their parent with an interactive
+// rebase combines their changes and messages into a single commit.
+func TestRebaseInteractiveSquash(t *testing.T) {
+	c, cleanup := testRepo(t, "gitrebasesquash")
+	defer cleanup()
+
+	base := testCommitFile(t, c, "a.txt", "a\n", "Add a")
+	c1 := testCommitFile(t, c, "b.txt", "b\n", "Add b")
+	c2 := testCommitFile(t, c, "c.txt", "c\n", "Add c")
+	c3 := testCommitFile(t, c, "d.txt", "d\n", "Add d")
+
+	err := RebaseInteractive(c, []RebaseStep{
+		{Action: RebasePick, Commit: c1},
+		{Action: RebaseSquash, Commit: c2},
+		{Action: RebaseFixup, Commit: c3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch := c.GetHeadBranch(); branch != "refs/heads/master" {
+		t.Errorf("HEAD was not returned to the branch: %v", branch)
+	}
+	if parents, err := head.Parents(c); err != nil || len(parents) != 1 || parents[0] != base {
+		t.Errorf("Unexpected parents of the squashed commit: %v (%v)", parents, err)
+	}
+	if msg, err := head.GetCommitMessage(c); err != nil || msg != "Add b\n\nAdd c\n" {
+		t.Errorf("Unexpected message of the squashed commit: %q (%v)", msg, err)
+	}
+	// The tree is the same as the final commit which was squashed.
+	got, err := head.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, err := c3.TreeID(c); err != nil || got != want {
+		t.Errorf("Unexpected tree of the squashed commit: got %v want %v", got, want)
+	}
+	if rebaseInProgress(c) {
+		t.Error("The rebase state was not removed")
+	}
+}
+
+// Tests that an interactive rebase stops at an edit step, and that
+// continuing it amends the commit with the changes in the index before
+// doing the rest of the steps.
+func TestRebaseInteractiveEdit(t *testing.T) {
+	c, cleanup := testRepo(t, "gitrebaseedit")
+	defer cleanup()
+
+	base := testCommitFile(t, c, "a.txt", "a\n", "Add a")
+	c1 := testCommitFile(t, c, "b.txt", "b\n", "Add b")
+	c2 := testCommitFile(t, c, "c.txt", "c\n", "Add c")
+	c3 := testCommitFile(t, c, "d.txt", "d\n", "Add d")
+
+	err := RebaseInteractive(c, []RebaseStep{
+		{Action: RebaseEdit, Commit: c1},
+		{Action: RebaseDrop, Commit: c2},
+		{Action: RebaseReword, Commit: c3, Message: "Add d again\n"},
+	})
+	if _, ok := err.(RebaseStoppedError); !ok {
+		t.Fatalf("Rebase did not stop for the edit: %v", err)
+	}
+	if !rebaseInProgress(c) {
+		t.Fatal("The rebase state was not saved")
+	}
+	if head, err := c.GetHeadCommit(); err != nil || head != c1 {
+		t.Fatalf("HEAD is not at the commit being edited: %v (%v)", head, err)
+	}
+
+	if err := ioutil.WriteFile("b.txt", []byte("amended\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RebaseContinue(c); err != nil {
+		t.Fatal(err)
+	}
+	if rebaseInProgress(c) {
+		t.Error("The rebase state was not removed")
+	}
+
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := head.GetCommitMessage(c); err != nil || msg != "Add d again\n" {
+		t.Errorf("Unexpected message of the reworded commit: %q (%v)", msg, err)
+	}
+	files, err := LsTree(c, LsTreeOptions{Recurse: true, NameOnly: true}, head, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.PathName.String())
+	}
+	if len(names) != 3 || names[0] != "a.txt" || names[1] != "b.txt" || names[2] != "d.txt" {
+		t.Errorf("Unexpected files after the rebase: %v", names)
+	}
+	amended, err := head.Parents(c)
+	if err != nil || len(amended) != 1 {
+		t.Fatal(err)
+	}
+	amendedFiles, err := LsTree(c, LsTreeOptions{}, amended[0], []File{"b.txt"})
+	if err != nil || len(amendedFiles) != 1 {
+		t.Fatalf("Could not find b.txt in the edited commit: %v", err)
+	}
+	if obj, err := c.GetObject(amendedFiles[0].Sha1); err != nil || string(obj.GetContent()) != "amended\n" {
+		t.Errorf("The edited commit was not amended")
+	}
+	if parents, err := amended[0].Parents(c); err != nil || len(parents) != 1 || parents[0] != base {
+		t.Errorf("Unexpected parents of the amended commit: %v", parents)
+	}
+}
+
+// Tests that autosquash moves fixup and squash commits to just after the
+// commits that they refer to by subject or by sha.
+func TestAutosquashTodo(t *testing.T) {
+	c, cleanup := testRepo(t, "gitautosquash")
+	defer cleanup()
+
+	testCommitFile(t, c, "a.txt", "a\n", "Add a")
+	c1 := testCommitFile(t, c, "b.txt", "b\n", "Add b")
+	c2 := testCommitFile(t, c, "c.txt", "c\n", "Add c")
+	c3 := testCommitFile(t, c, "b.txt", "b2\n", "fixup! Add b")
+	c4 := testCommitFile(t, c, "c.txt", "c2\n", "squash! "+c2.String()[:7])
+	c5 := testCommitFile(t, c, "b.txt", "b3\n", "fixup! fixup! Add b")
+
+	var todo []RebaseStep
+	for _, cmt := range []CommitID{c1, c2, c3, c4, c5} {
+		todo = append(todo, RebaseStep{Action: RebasePick, Commit: cmt})
+	}
+	got, err := AutosquashTodo(c, todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RebaseStep{
+		{Action: RebasePick, Commit: c1},
+		{Action: RebaseFixup, Commit: c3},
+		{Action: RebaseFixup, Commit: c5},
+		{Action: RebasePick, Commit: c2},
+		{Action: RebaseSquash, Commit: c4},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Unexpected todo: got %v want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Unexpected step %d: got %v want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// Tests that a squash or fixup without a commit before it to squash into
+// is rejected before anything is changed, even if it follows a drop.
+func TestRebaseInteractiveSquashFirst(t *testing.T) {
+	c, cleanup := testRepo(t, "gitrebasesquashfirst")
+	defer cleanup()
+
+	testCommitFile(t, c, "a.txt", "a\n", "Add a")
+	c1 := testCommitFile(t, c, "b.txt", "b\n", "Add b")
+	c2 := testCommitFile(t, c, "c.txt", "c\n", "Add c")
+
+	for _, tc := range []struct {
+		todo []RebaseStep
+		want string
+	}{
+		{[]RebaseStep{{Action: RebaseSquash, Commit: c1}, {Action: RebasePick, Commit: c2}}, "cannot 'squash' without a previous commit"},
+		{[]RebaseStep{{Action: RebaseDrop, Commit: c1}, {Action: RebaseSquash, Commit: c2}}, "cannot 'squash' without a previous commit"},
+		{[]RebaseStep{{Action: RebaseDrop, Commit: c1}, {Action: RebaseFixup, Commit: c2}}, "cannot 'fixup' without a previous commit"},
+	} {
+		if err := RebaseInteractive(c, tc.todo); err == nil || err.Error() != tc.want {
+			t.Errorf("Unexpected error for %v: got %v want %v", tc.todo, err, tc.want)
+		}
+		if head, err := c.GetHeadCommit(); err != nil || head != c2 {
+			t.Errorf("Rejected rebase moved HEAD to %v (%v)", head, err)
+		}
+		if rebaseInProgress(c) {
+			t.Error("Rejected rebase left a rebase in progress")
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// The capabilities that ReceivePack advertises.
+const receivePackCapabilities = "report-status delete-refs quiet ofs-delta agent=dgit/0.0.2"
+
+// ReceivePackOptions are the options for ReceivePack, corresponding to
+// the options of "git receive-pack".
+type ReceivePackOptions struct {
+	// Only advertise the refs and capabilities without reading any
+	// commands, as the response to the info/refs request of the smart
+	// HTTP protocol.
+	AdvertiseRefs bool
+
+	// Read the commands without advertising the refs first, since they
+	// were advertised by an earlier request of the smart HTTP protocol.
+	StatelessRPC bool
+
+	// Allow updates which aren't fast-forwards, even if
+	// receive.denyNonFastForwards is set.
+	Force bool
+}
+
+// A ref update command sent to receive-pack.
+type receiveCommand struct {
+	old, new Sha1
+	ref      string
+
+	// The reason that the command was rejected, or the empty string if
+	// it wasn't.
+	rejected string
+}
+
+// ReceivePack implements the server side of a push, as "git receive-pack"
+// does, reading the requests of the client from in and writing the
+// responses to out.
+//
+// The refs of c are advertised, and then the update commands and the
+// packfile that the client sends are read. The objects in the pack are
+// quarantined until the updates have been checked, so that nothing is
+// added to the repository if every update is rejected. An update is
+// rejected if it isn't a fast-forward and receive.denyNonFastForwards is
+// set (unless opts.Force is set), if it would update the branch checked
+// out in a non-bare repository, or if the pre-receive or update hook
+// declines it. The post-receive hook is run with the refs that were
+// updated.
+//
+// If the client asked for report-status, the result of each update is
+// reported to it.
+func ReceivePack(c *Client, in io.Reader, out io.Writer, opts ReceivePackOptions) error {
+	if !opts.StatelessRPC {
+		if err := advertiseReceivePack(c, out); err != nil {
+			return err
+		}
+	}
+	if opts.AdvertiseRefs {
+		return nil
+	}
+
+	conn := &packProtocolReader{conn: in, state: PktLineMode}
+	cmds, caps, err := readReceiveCommands(conn)
+	if err != nil {
+		return err
+	}
+	if len(cmds) == 0 {
+		// The client has nothing to push.
+		return nil
+	}
+
+	q, commit, rollback := c.Quarantine()
+	var unpackErr error
+	for _, cmd := range cmds {
+		if cmd.new != (Sha1{}) {
+			// Only deletes can be sent without a pack.
+			conn.SetReadMode(DirectReadMode)
+			_, unpackErr = IndexAndCopyPack(q, IndexPackOptions{FixThin: true}, conn)
+			break
+		}
+	}
+	if unpackErr != nil {
+		for _, cmd := range cmds {
+			cmd.rejected = "unpacker error"
+		}
+	} else {
+		for _, cmd := range cmds {
+			cmd.rejected = checkReceiveCommand(c, q, opts, cmd)
+		}
+		if err := runReceiveHook(q, "pre-receive", cmds); err != nil {
+			for _, cmd := range cmds {
+				if cmd.rejected == "" {
+					cmd.rejected = "pre-receive hook declined"
+				}
+			}
+		}
+	}
+
+	accepted := false
+	for _, cmd := range cmds {
+		if cmd.rejected == "" {
+			accepted = true
+		}
+	}
+	if accepted {
+		err = commit()
+	} else {
+		err = rollback()
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range cmds {
+		if cmd.rejected != "" {
+			continue
+		}
+		if _, err := RunHook(c, "update", nil, cmd.ref, cmd.old.String(), cmd.new.String()); err != nil {
+			cmd.rejected = "hook declined"
+			continue
+		}
+		if err := UpdateRefCAS(c, cmd.ref, cmd.old, cmd.new, "push"); err != nil {
+			cmd.rejected = "failed to update ref"
+		}
+	}
+	if err := runReceiveHook(c, "post-receive", cmds); err != nil {
+		// The refs have already been updated, so the post-receive
+		// hook can't change the outcome of the push.
+		return err
+	}
+
+	if _, ok := caps["report-status"]; ok {
+		return reportReceiveStatus(out, unpackErr, cmds)
+	}
+	return nil
+}
+
+// Advertises the refs of c and the capabilities of ReceivePack to out,
+// followed by a flush packet.
+func advertiseReceivePack(c *Client, out io.Writer) error {
+	first := true
+	err := ForEachRefCallback(c, "refs/", func(c *Client, ref Ref) error {
+		line := fmt.Sprintf("%v %v", ref.Value, ref.Name)
+		if first {
+			line += "\000" + receivePackCapabilities
+			first = false
+		}
+		return writePktLine(out, line+"\n")
+	})
+	if err != nil {
+		return err
+	}
+	if first {
+		// There are no refs to send the capabilities with.
+		if err := writePktLine(out, fmt.Sprintf("%v capabilities^{}\000%v\n", Sha1{}, receivePackCapabilities)); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(out, "0000")
+	return err
+}
+
+// Reads the ref update commands sent to receive-pack, up to the flush
+// packet that ends them, and the capabilities that the client sent with
+// the first one.
+func readReceiveCommands(conn *packProtocolReader) ([]*receiveCommand, map[string]struct{}, error) {
+	var cmds []*receiveCommand
+	caps := make(map[string]struct{})
+	line := make([]byte, 65536)
+	for {
+		n, err := conn.Read(line)
+		if err == flushPkt {
+			return cmds, caps, nil
+		} else if err == io.EOF && len(cmds) == 0 {
+			// The client hung up after the advertisement without
+			// pushing anything.
+			return nil, caps, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		cmd := string(line[:n])
+		if i := strings.IndexByte(cmd, 0); i >= 0 {
+			for _, c := range strings.Fields(cmd[i+1:]) {
+				if i := strings.IndexByte(c, '='); i >= 0 {
+					c = c[:i]
+				}
+				caps[c] = struct{}{}
+			}
+			cmd = cmd[:i]
+		}
+		fields := strings.Fields(cmd)
+		if len(fields) != 3 {
+			return nil, nil, fmt.Errorf("protocol error: expected old/new/ref, got '%v'", strings.TrimSpace(cmd))
+		}
+		old, err := Sha1FromString(fields[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		new, err := Sha1FromString(fields[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		cmds = append(cmds, &receiveCommand{old: old, new: new, ref: fields[2]})
+	}
+}
+
+// Returns the reason that cmd should be rejected, or the empty string if
+// it's allowed. The new objects are read from the quarantined client q.
+func checkReceiveCommand(c, q *Client, opts ReceivePackOptions, cmd *receiveCommand) string {
+	if !strings.HasPrefix(cmd.ref, "refs/") {
+		return "funny refname"
+	}
+	if !c.IsBare() && cmd.ref == c.GetHeadBranch().String() {
+		switch c.GetConfig("receive.denycurrentbranch") {
+		case "ignore", "warn", "false":
+		default:
+			return "branch is currently checked out"
+		}
+	}
+	if cmd.new == (Sha1{}) {
+		if c.GetConfig("receive.denydeletes") == "true" {
+			return "deletion prohibited"
+		}
+		return ""
+	}
+	if have, _, err := q.HaveObject(cmd.new); err != nil || !have {
+		return "missing necessary objects"
+	}
+	if cmd.old == (Sha1{}) || opts.Force || c.GetConfig("receive.denynonfastforwards") != "true" {
+		return ""
+	}
+	// Only commits can be fast-forwarded.
+	oldtype, err := q.ObjectType(cmd.old)
+	if err != nil {
+		return "missing necessary objects"
+	}
+	newtype, err := q.ObjectType(cmd.new)
+	if err != nil {
+		return "missing necessary objects"
+	}
+	if oldtype == "commit" && newtype == "commit" && !CommitID(cmd.old).IsAncestor(q, CommitID(cmd.new)) {
+		return "non-fast-forward"
+	}
+	return ""
+}
+
+// Runs the pre-receive or post-receive hook with the commands which
+// haven't been rejected on its standard input. The pre-receive hook is run
+// with q's quarantine as its object directory, so that it can inspect
+// the objects being pushed.
+func runReceiveHook(q *Client, name string, cmds []*receiveCommand) error {
+	var stdin bytes.Buffer
+	for _, cmd := range cmds {
+		if cmd.rejected == "" {
+			fmt.Fprintf(&stdin, "%v %v %v\n", cmd.old, cmd.new, cmd.ref)
+		}
+	}
+	if stdin.Len() == 0 {
+		return nil
+	}
+	var env []string
+	if q.quarantine != "" {
+		qdir, err := filepath.Abs(q.quarantine.String())
+		if err != nil {
+			return err
+		}
+		objdir, err := filepath.Abs(q.GitDir.File("objects").String())
+		if err != nil {
+			return err
+		}
+		env = []string{
+			"GIT_QUARANTINE_PATH=" + qdir,
+			"GIT_OBJECT_DIRECTORY=" + qdir,
+			"GIT_ALTERNATE_OBJECT_DIRECTORIES=" + strings.Join([]string{qdir, objdir}, string(filepath.ListSeparator)),
+		}
+	}
+	_, err := runHookEnv(q, name, env, &stdin)
+	return err
+}
+
+// Writes the report-status response for the commands to out.
+func reportReceiveStatus(out io.Writer, unpackErr error, cmds []*receiveCommand) error {
+	unpack := "ok"
+	if unpackErr != nil {
+		unpack = unpackErr.Error()
+	}
+	if err := writePktLine(out, "unpack "+unpack+"\n"); err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		line := "ok " + cmd.ref + "\n"
+		if cmd.rejected != "" {
+			line = "ng " + cmd.ref + " " + cmd.rejected + "\n"
+		}
+		if err := writePktLine(out, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(out, "0000")
+	return err
+}
+
+// Writes line to w as a pkt-line.
+func writePktLine(w io.Writer, line string) error {
+	l, err := PktLineEncodeNoNl([]byte(line))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s", l)
+	return err
+}
@@ -0,0 +1,201 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Pushes new to ref in the repository served by ReceivePack over a pipe,
+// sending the objects of src which the server doesn't advertise, the same
+// way as the push client. Returns the report-status lines.
+func testReceivePack(t *testing.T, src, dst *Client, opts ReceivePackOptions, ref string, new Sha1) []string {
+	t.Helper()
+	cr, cw := io.Pipe()
+	sr, sw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		err := ReceivePack(dst, cr, sw, opts)
+		sw.CloseWithError(err)
+		errc <- err
+	}()
+
+	conn := &packProtocolReader{conn: sr, state: PktLineMode}
+	line := make([]byte, 65536)
+	remote := make(map[string]Sha1)
+	for {
+		n, err := conn.Read(line)
+		if err == flushPkt {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		// The capabilities come after a NUL on the first line.
+		r, err := parseLsRef(strings.SplitN(string(line[:n]), "\000", 2)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ref := range r {
+			remote[ref.Name] = ref.Value
+		}
+	}
+
+	old := remote[ref]
+	var excludes []Commitish
+	for _, id := range remote {
+		if have, _, _ := src.HaveObject(id); have {
+			excludes = append(excludes, CommitID(id))
+		}
+	}
+	objects, err := RevList(src, RevListOptions{Quiet: true, Objects: true}, nil, []Commitish{CommitID(new)}, excludes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writePktLine(cw, fmt.Sprintf("%v %v %v\000report-status\n", old, new, ref)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(cw, "0000")
+	if err := SendPackfile(src, cw, objects); err != nil {
+		t.Fatal(err)
+	}
+
+	var report []string
+	for {
+		n, err := conn.Read(line)
+		if err == flushPkt {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		report = append(report, string(line[:n]))
+	}
+	cw.Close()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestReceivePack(t *testing.T) {
+	c, cleanup := testRepo(t, "gitreceivepack")
+	defer cleanup()
+	first := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+
+	dir, err := ioutil.TempDir("", "gitreceivepackdst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dir, "dst.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.GitDir.File("config").Append("[receive]\n\tdenyNonFastForwards = true\n"); err != nil {
+		t.Fatal(err)
+	}
+	if dst, err = NewClient(dst.GitDir.String(), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	report := testReceivePack(t, c, dst, ReceivePackOptions{}, "refs/heads/master", Sha1(first))
+	if want := []string{"unpack ok\n", "ok refs/heads/master\n"}; fmt.Sprint(report) != fmt.Sprint(want) {
+		t.Errorf("Unexpected report for new branch: got %q want %q", report, want)
+	}
+	if got, err := Branch("refs/heads/master").CommitID(dst); err != nil || got != first {
+		t.Errorf("Branch not updated by push: got %v (%v) want %v", got, err, first)
+	}
+
+	// An unrelated commit isn't a fast-forward.
+	tree, err := WriteTree(c, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := CommitTree(c, CommitTreeOptions{}, tree, nil, "Unrelated commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = testReceivePack(t, c, dst, ReceivePackOptions{}, "refs/heads/master", Sha1(other))
+	if want := []string{"unpack ok\n", "ng refs/heads/master non-fast-forward\n"}; fmt.Sprint(report) != fmt.Sprint(want) {
+		t.Errorf("Unexpected report for non-fast-forward: got %q want %q", report, want)
+	}
+	if have, _, err := dst.HaveObject(Sha1(other)); err != nil || have {
+		t.Errorf("Objects of rejected push were kept: %v", err)
+	}
+	if got, _ := Branch("refs/heads/master").CommitID(dst); got != first {
+		t.Errorf("Branch updated by rejected push: got %v want %v", got, first)
+	}
+
+	// A declining pre-receive hook rejects the push too.
+	if err := os.MkdirAll(dst.GitDir.File("hooks").String(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.GitDir.WriteFile("hooks/pre-receive", []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	report = testReceivePack(t, c, dst, ReceivePackOptions{Force: true}, "refs/heads/master", Sha1(other))
+	if want := []string{"unpack ok\n", "ng refs/heads/master pre-receive hook declined\n"}; fmt.Sprint(report) != fmt.Sprint(want) {
+		t.Errorf("Unexpected report for declined push: got %q want %q", report, want)
+	}
+	if have, _, err := dst.HaveObject(Sha1(other)); err != nil || have {
+		t.Errorf("Objects of declined push were kept: %v", err)
+	}
+
+	if err := os.Remove(dst.GitDir.File("hooks/pre-receive").String()); err != nil {
+		t.Fatal(err)
+	}
+	report = testReceivePack(t, c, dst, ReceivePackOptions{Force: true}, "refs/heads/master", Sha1(other))
+	if want := []string{"unpack ok\n", "ok refs/heads/master\n"}; fmt.Sprint(report) != fmt.Sprint(want) {
+		t.Errorf("Unexpected report for forced push: got %q want %q", report, want)
+	}
+	if got, _ := Branch("refs/heads/master").CommitID(dst); got != other {
+		t.Errorf("Branch not updated by forced push: got %v want %v", got, other)
+	}
+}
+
+// Tests that non-fast-forwards and pushes to the checked out branch are
+// only rejected when the receive config says to, as in git.
+func TestReceivePackConfig(t *testing.T) {
+	c, cleanup := testRepo(t, "gitreceivepackconfig")
+	defer cleanup()
+	first := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+	tree, err := first.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := CommitTree(c, CommitTreeOptions{}, tree, nil, "Unrelated commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "gitreceivepackconfigdst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.GitDir.File("config").Append("[receive]\n\tdenyCurrentBranch = ignore\n"); err != nil {
+		t.Fatal(err)
+	}
+	if dst, err = NewClient(dst.GitDir.String(), dst.WorkDir.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	report := testReceivePack(t, c, dst, ReceivePackOptions{}, "refs/heads/master", Sha1(first))
+	if want := []string{"unpack ok\n", "ok refs/heads/master\n"}; fmt.Sprint(report) != fmt.Sprint(want) {
+		t.Errorf("Unexpected report for push to current branch: got %q want %q", report, want)
+	}
+	report = testReceivePack(t, c, dst, ReceivePackOptions{}, "refs/heads/master", Sha1(other))
+	if want := []string{"unpack ok\n", "ok refs/heads/master\n"}; fmt.Sprint(report) != fmt.Sprint(want) {
+		t.Errorf("Unexpected report for non-fast-forward: got %q want %q", report, want)
+	}
+	if got, _ := Branch("refs/heads/master").CommitID(dst); got != other {
+		t.Errorf("Branch not updated by non-fast-forward: got %v want %v", got, other)
+	}
+}
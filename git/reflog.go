@@ -2,8 +2,10 @@ package git
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type ReflogDeleteOptions struct{}
@@ -59,3 +61,98 @@ func ReflogExpire(c *Client, opts ReflogExpireOptions, refpatterns []string) err
 	}
 	return nil
 }
+
+// A ReflogEntry is a single entry in the reflog of a ref.
+type ReflogEntry struct {
+	// The value of the ref before and after the change.
+	Old, New CommitID
+
+	// The identity and time of the change, in the same format as the
+	// committer of a commit.
+	Committer string
+
+	Message string
+}
+
+func (e ReflogEntry) String() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s %s\n", e.Old, e.New, e.Committer)
+	}
+	return fmt.Sprintf("%s %s %s\t%s\n", e.Old, e.New, e.Committer, e.Message)
+}
+
+// Reads the reflog for ref, oldest entry first. If there's no reflog, no
+// entries are returned.
+func readReflog(c *Client, ref string) ([]ReflogEntry, error) {
+	data, err := c.GitDir.ReadFile(File("logs/" + ref))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []ReflogEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if len(line) < 82 || line[40] != ' ' || line[81] != ' ' {
+			continue
+		}
+		old, err := CommitIDFromString(line[:40])
+		if err != nil {
+			return nil, err
+		}
+		new, err := CommitIDFromString(line[41:81])
+		if err != nil {
+			return nil, err
+		}
+		entry := ReflogEntry{Old: old, New: new, Committer: line[82:]}
+		if tab := strings.IndexByte(entry.Committer, '\t'); tab >= 0 {
+			entry.Message = entry.Committer[tab+1:]
+			entry.Committer = entry.Committer[:tab]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Replaces the reflog for ref with entries.
+func writeReflog(c *Client, ref string, entries []ReflogEntry) error {
+	return c.GitDir.WriteLocked(File("logs/"+ref), func(w io.Writer) error {
+		for _, e := range entries {
+			if _, err := io.WriteString(w, e.String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PreviousBranch returns the branch (or commit, if HEAD was detached)
+// that was checked out before the nth most recent checkout, which is what
+// "@{-n}" refers to. It's found from the "checkout: moving from" entries
+// in the HEAD reflog. Other entries are ignored.
+func PreviousBranch(c *Client, n int) (string, error) {
+	if n < 1 {
+		return "", fmt.Errorf("Invalid previous checkout @{-%d}", n)
+	}
+	entries, err := readReflog(c, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	const prefix = "checkout: moving from "
+	remaining := n
+	for i := len(entries) - 1; i >= 0; i-- {
+		msg := entries[i].Message
+		if !strings.HasPrefix(msg, prefix) {
+			continue
+		}
+		msg = strings.TrimPrefix(msg, prefix)
+		to := strings.Index(msg, " to ")
+		if to < 0 {
+			continue
+		}
+		if remaining--; remaining == 0 {
+			return msg[:to], nil
+		}
+	}
+	return "", fmt.Errorf("Not enough checkouts in the reflog to resolve @{-%d}", n)
+}
@@ -0,0 +1,55 @@
+package git
+
+import (
+	"testing"
+)
+
+func TestPreviousBranch(t *testing.T) {
+	c, cleanup := testRepo(t, "gitpreviousbranch")
+	defer cleanup()
+
+	initial := testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	if err := c.CreateBranch("B", initial); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PreviousBranch(c, 1); err == nil {
+		t.Error("Found a previous checkout before any checkouts")
+	}
+
+	if _, err := Checkout(c, CheckoutOptions{}, "B", nil); err != nil {
+		t.Fatal(err)
+	}
+	// Commits are also in the HEAD reflog, but aren't checkouts.
+	bcmt := testCommitFile(t, c, "foo.txt", "bar\n", "Commit on B")
+	if _, err := Checkout(c, CheckoutOptions{}, "master", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cmt, err := RevParseCommitish(c, &RevParseOptions{}, "@{-1}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := cmt.(Branch); !ok || b != "refs/heads/B" {
+		t.Errorf("Unexpected @{-1}: got %v want refs/heads/B", cmt)
+	}
+	if prev, err := PreviousBranch(c, 2); err != nil || prev != "master" {
+		t.Errorf("Unexpected @{-2}: got %v want master (%v)", prev, err)
+	}
+	if _, err := PreviousBranch(c, 3); err == nil {
+		t.Error("Found too many previous checkouts")
+	}
+
+	if _, err := Checkout(c, CheckoutOptions{}, "-", nil); err != nil {
+		t.Fatal(err)
+	}
+	head, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != "refs/heads/B" {
+		t.Errorf("Checkout - switched to %v, want refs/heads/B", head)
+	}
+	if got, err := c.GetHeadCommit(); err != nil || got != bcmt {
+		t.Errorf("Unexpected HEAD after checkout -: got %v want %v (%v)", got, bcmt, err)
+	}
+}
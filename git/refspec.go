@@ -1,7 +1,6 @@
 package git
 
 import (
-	"bytes"
 	"fmt"
 	"strings"
 )
@@ -76,11 +75,21 @@ func (r RefSpec) Value(c *Client) (string, error) {
 }
 
 func (r RefSpec) Sha1(c *Client) (Sha1, error) {
-	v, err := r.Value(c)
+	id, err := r.ObjectID(c)
 	if err != nil {
 		return Sha1{}, err
 	}
-	return Sha1FromString(v)
+	return id.Sha1()
+}
+
+// Returns the object that r points to, which may be named with either hash
+// algorithm.
+func (r RefSpec) ObjectID(c *Client) (ObjectID, error) {
+	v, err := r.Value(c)
+	if err != nil {
+		return ObjectID{}, err
+	}
+	return ObjectIDFromString(v)
 }
 
 func (r RefSpec) CommitID(c *Client) (CommitID, error) {
@@ -92,29 +101,14 @@ func (r RefSpec) CommitID(c *Client) (CommitID, error) {
 	case "commit":
 		return CommitID(sha1), nil
 	case "tag":
-		obj, err := c.GetObject(sha1)
-		if err != nil {
-			return CommitID{}, err
-		}
-		content := obj.GetContent()
-		reader := bytes.NewBuffer(content)
-		line, err := reader.ReadBytes('\n')
-		if err != nil {
-			return CommitID{}, err
-		}
-		objid := strings.TrimPrefix(string(line), "object ")
-		objsha, err := CommitIDFromString(objid)
-		if err != nil {
-			return CommitID{}, err
-		}
-		line, err = reader.ReadBytes('\n')
+		peeled, err := Peel(c, sha1)
 		if err != nil {
 			return CommitID{}, err
 		}
-		if string(line) != "type commit\n" {
-			return CommitID{}, fmt.Errorf("Tag does not point to a commit: %v", string(line))
+		if t := peeled.Type(c); t != "commit" {
+			return CommitID{}, fmt.Errorf("Tag does not point to a commit: %v", t)
 		}
-		return objsha, nil
+		return CommitID(peeled), nil
 	default:
 		return CommitID{}, fmt.Errorf("Invalid commit type %v", t)
 	}
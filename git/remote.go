@@ -169,6 +169,7 @@ func NewRemoteConn(c *Client, r Remote) (RemoteConn, error) {
 		conn := &smartHTTPConn{
 			sharedRemoteConn: &sharedRemoteConn{uri: uri},
 			giturl:           urls,
+			c:                c,
 		}
 		return conn, nil
 	case "git":
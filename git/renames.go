@@ -0,0 +1,251 @@
+package git
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffRenameOptions are the options for detecting renames and copies in
+// the result of a diff. The 0 value doesn't detect either.
+type DiffRenameOptions struct {
+	// The minimum similarity, as a percentage, for a deleted and an added
+	// file to be reported as a rename.
+	FindRenames int
+
+	// The minimum similarity for an added file to be reported as a copy
+	// of a file which was modified. Setting it also detects renames.
+	FindCopies int
+
+	// Consider the files which weren't modified as the sources of copies
+	// too. This is much more expensive, since every file in the tree is a
+	// candidate.
+	FindCopiesHarder bool
+
+	// The number of sources and destinations beyond which only exact
+	// renames and copies are detected. The 0 value uses the
+	// diff.renameLimit config, or 1000 if it's not set.
+	RenameLimit int
+}
+
+// The similarity used by -M and -C when one isn't given.
+const defaultSimilarity = 50
+
+// ParseSimilarity parses the minimum similarity given to -M or -C. As in
+// git, it's either a percentage such as "90%", or the digits after the
+// decimal point of a fraction, so that "9" and "90" are both 90%. The empty
+// string is the default of 50%.
+func ParseSimilarity(s string) (int, error) {
+	if s == "" {
+		return defaultSimilarity, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+		if err != nil || n < 0 || n > 100 {
+			return 0, fmt.Errorf("Invalid similarity %v", s)
+		}
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || strings.ContainsAny(s, "+-") {
+		return 0, fmt.Errorf("Invalid similarity %v", s)
+	}
+	scale := 1
+	for range s {
+		scale *= 10
+	}
+	return n * 100 / scale, nil
+}
+
+// Returns whether opts detect anything.
+func (opts DiffRenameOptions) enabled() bool {
+	return opts.FindRenames > 0 || opts.FindCopies > 0 || opts.FindCopiesHarder
+}
+
+func (opts DiffRenameOptions) renameLimit(c *Client) int {
+	if opts.RenameLimit > 0 {
+		return opts.RenameLimit
+	}
+	if n, err := strconv.Atoi(c.GetConfig("diff.renameLimit")); err == nil && n > 0 {
+		return n
+	}
+	return 1000
+}
+
+// A candidate for the source of a rename or copy.
+type renameSource struct {
+	Name  IndexPath
+	Entry TreeEntry
+
+	// The source was deleted, so it can be renamed rather than copied.
+	Deleted bool
+}
+
+// Returns whether an entry with mode is a file which can be renamed or
+// copied.
+func renameableMode(mode EntryMode) bool {
+	switch mode {
+	case ModeBlob, ModeExec, ModeSymlink:
+		return true
+	}
+	return false
+}
+
+// detectRenames pairs up the files which were added in diffs with files
+// that were deleted or, if opts.FindCopies is set, modified, and replaces
+// them with HashDiffs for the renames and copies. If the number of
+// candidates is over the rename limit, only the files which are identical
+// are paired.
+//
+// unchanged is the files which weren't changed by the diff, which are only
+// used with opts.FindCopiesHarder.
+func detectRenames(c *Client, opts DiffRenameOptions, diffs []HashDiff, unchanged map[IndexPath]TreeEntry) ([]HashDiff, error) {
+	renameScore, copyScore := opts.FindRenames, opts.FindCopies
+	if opts.FindCopiesHarder && copyScore == 0 {
+		copyScore = defaultSimilarity
+	}
+	if copyScore > 0 && renameScore == 0 {
+		renameScore = defaultSimilarity
+	}
+
+	var dests []int
+	var sources []renameSource
+	for i, d := range diffs {
+		switch {
+		case d.Src.FileMode == 0 && renameableMode(d.Dst.FileMode):
+			dests = append(dests, i)
+		case d.Dst.FileMode == 0 && renameableMode(d.Src.FileMode):
+			sources = append(sources, renameSource{d.Name, d.Src, true})
+		case copyScore > 0 && renameableMode(d.Src.FileMode):
+			sources = append(sources, renameSource{d.Name, d.Src, false})
+		}
+	}
+	if opts.FindCopiesHarder {
+		for name, entry := range unchanged {
+			if renameableMode(entry.FileMode) {
+				sources = append(sources, renameSource{name, entry, false})
+			}
+		}
+	}
+	if len(dests) == 0 || len(sources) == 0 {
+		return diffs, nil
+	}
+	sort.SliceStable(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+
+	limit := opts.renameLimit(c)
+	exactOnly := len(dests)*len(sources) > limit*limit
+	contents := make(map[Sha1][]byte)
+	content := func(sha Sha1) ([]byte, error) {
+		if data, ok := contents[sha]; ok {
+			return data, nil
+		}
+		obj, err := c.GetObject(sha)
+		if err != nil {
+			return nil, err
+		}
+		contents[sha] = obj.GetContent()
+		return contents[sha], nil
+	}
+
+	type candidate struct {
+		dest, src, score int
+	}
+	var candidates []candidate
+	for di, i := range dests {
+		dst := diffs[i].Dst
+		for si, src := range sources {
+			min := copyScore
+			if src.Deleted {
+				min = renameScore
+			}
+			if min == 0 {
+				continue
+			}
+			if src.Entry.Sha1 == dst.Sha1 {
+				candidates = append(candidates, candidate{di, si, 100})
+				continue
+			}
+			if exactOnly || src.Entry.FileMode == ModeSymlink || dst.FileMode == ModeSymlink {
+				continue
+			}
+			a, err := content(src.Entry.Sha1)
+			if err != nil {
+				return nil, err
+			}
+			b, err := content(dst.Sha1)
+			if err != nil {
+				return nil, err
+			}
+			if score := similarity(a, b); score >= min {
+				candidates = append(candidates, candidate{di, si, score})
+			}
+		}
+	}
+	// The best matches are used first, and a deleted file is renamed to
+	// the best match for it and copied to any others.
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	matched := make(map[int]bool)
+	renamed := make(map[int]bool)
+	result := make([]HashDiff, len(diffs))
+	copy(result, diffs)
+	for _, cand := range candidates {
+		if matched[cand.dest] {
+			continue
+		}
+		src := sources[cand.src]
+		isCopy := !src.Deleted || renamed[cand.src]
+		if isCopy && copyScore == 0 {
+			continue
+		}
+		matched[cand.dest] = true
+		renamed[cand.src] = renamed[cand.src] || !isCopy
+
+		d := &result[dests[cand.dest]]
+		d.Src, d.SrcSize = src.Entry, 0
+		d.SrcName = src.Name
+		d.Similarity = cand.score
+		d.Copy = isCopy
+	}
+
+	// A deleted file which was renamed isn't reported as deleted.
+	deleted := make(map[IndexPath]bool)
+	for si, src := range sources {
+		if renamed[si] {
+			deleted[src.Name] = true
+		}
+	}
+	filtered := result[:0]
+	for _, d := range result {
+		if d.SrcName == "" && d.Dst.FileMode == 0 && deleted[d.Name] {
+			continue
+		}
+		filtered = append(filtered, d)
+	}
+	return filtered, nil
+}
+
+// Returns how similar a and b are, as a percentage of the size of the
+// larger one. The lines in common are counted regardless of their order,
+// so that moving a block within a file doesn't make it less similar.
+func similarity(a, b []byte) int {
+	max := len(a)
+	if len(b) > max {
+		max = len(b)
+	}
+	if max == 0 {
+		return 100
+	}
+	lines := make(map[string]int)
+	for _, line := range splitAfterLines(a) {
+		lines[line]++
+	}
+	common := 0
+	for _, line := range splitAfterLines(b) {
+		if lines[line] > 0 {
+			lines[line]--
+			common += len(line)
+		}
+	}
+	return common * 100 / max
+}
@@ -0,0 +1,128 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Returns the lines of a file with the numbers from start to end.
+func testSeq(start, end int) string {
+	var s strings.Builder
+	for i := start; i <= end; i++ {
+		fmt.Fprintf(&s, "%d\n", i)
+	}
+	return s.String()
+}
+
+// Tests that DiffTree reports renames with FindRenames, copies of modified
+// files with FindCopies, and copies of unmodified files only with
+// FindCopiesHarder.
+func TestDiffTreeFindCopies(t *testing.T) {
+	c, cleanup := testRepo(t, "gitfindcopies")
+	defer cleanup()
+
+	testCommitFile(t, c, "m", testSeq(1, 100), "Add m")
+	testCommitFile(t, c, "r", testSeq(200, 300), "Add r")
+	base := testCommitFile(t, c, "u", testSeq(400, 500), "Add u")
+
+	files := map[string]string{
+		"m":  testSeq(1, 101),
+		"m2": testSeq(1, 101) + "extra\n",
+		"r2": testSeq(200, 300) + "x\n",
+		"u2": testSeq(400, 500),
+	}
+	var added []File
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		added = append(added, File(name))
+	}
+	if _, err := Add(c, AddOptions{}, added); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rm(c, RmOptions{}, []File{"r"}); err != nil {
+		t.Fatal(err)
+	}
+	head, err := Commit(c, CommitOptions{}, "Copy and rename", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts     DiffRenameOptions
+		expected []string
+	}{
+		{
+			DiffRenameOptions{},
+			[]string{"M m", "A m2", "D r", "A r2", "A u2"},
+		},
+		{
+			DiffRenameOptions{FindRenames: 50},
+			[]string{"M m", "A m2", "R099 r r2", "A u2"},
+		},
+		{
+			DiffRenameOptions{FindCopies: 50},
+			[]string{"M m", "C096 m m2", "R099 r r2", "A u2"},
+		},
+		{
+			DiffRenameOptions{FindCopiesHarder: true},
+			[]string{"M m", "C096 m m2", "R099 r r2", "C100 u u2"},
+		},
+		{
+			// The copy is above the threshold but the rename
+			// isn't.
+			DiffRenameOptions{FindRenames: 100, FindCopies: 90},
+			[]string{"M m", "C096 m m2", "D r", "A r2", "A u2"},
+		},
+		{
+			// Over the rename limit, only exact copies are
+			// detected.
+			DiffRenameOptions{FindCopiesHarder: true, RenameLimit: 1},
+			[]string{"M m", "A m2", "D r", "A r2", "C100 u u2"},
+		},
+	}
+	for i, tc := range tests {
+		diffs, err := DiffTree(c, &DiffTreeOptions{Recurse: true, DiffRenameOptions: tc.opts}, base, head, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range diffs {
+			// Strip the modes and hashes from the raw output.
+			fields := strings.Fields(d.String())
+			got = append(got, strings.Join(fields[4:], " "))
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Case %d: got %v want %v", i, got, tc.expected)
+		}
+	}
+}
+
+func TestParseSimilarity(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected int
+	}{
+		{"", 50},
+		{"9", 90},
+		{"90", 90},
+		{"05", 5},
+		{"100", 10},
+		{"100%", 100},
+		{"75%", 75},
+	}
+	for _, tc := range tests {
+		if got, err := ParseSimilarity(tc.s); err != nil || got != tc.expected {
+			t.Errorf("ParseSimilarity(%q): got %v (%v) want %v", tc.s, got, err, tc.expected)
+		}
+	}
+	for _, s := range []string{"x", "-5", "101%"} {
+		if _, err := ParseSimilarity(s); err == nil {
+			t.Errorf("ParseSimilarity(%q) did not return an error", s)
+		}
+	}
+}
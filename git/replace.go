@@ -0,0 +1,178 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Returns whether objects with replace refs should be substituted by
+// their replacement when read.
+func (c *Client) useReplaceRefs() bool {
+	if c.NoReplaceObjects {
+		return false
+	}
+	return c.GetConfig("core.usereplacerefs") != "false"
+}
+
+// Loads the replace refs under refs/replace into the client's cache.
+//
+// This reads the files directly rather than using ShowRef, since ShowRef
+// reads the objects that the refs point to, which would recurse back into
+// the replacement lookup.
+func (c *Client) loadReplaceRefs() error {
+	c.replaceRefs = make(map[Sha1]Sha1)
+	dir := c.GitDir.File("refs/replace")
+	if !dir.Exists() {
+		return nil
+	}
+	return filepath.Walk(dir.String(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		original, err := Sha1FromString(filepath.Base(path))
+		if err != nil {
+			// Not a replace ref, so ignore it like git does.
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		replacement, err := Sha1FromString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("Invalid replace ref %v: %v", path, err)
+		}
+		c.replaceRefs[original] = replacement
+		return nil
+	})
+}
+
+// Returns the object that should be read in place of sha1, following any
+// replace refs. If there is no replacement, sha1 itself is returned.
+func (c *Client) replacementObject(sha1 Sha1) (Sha1, error) {
+	if !c.useReplaceRefs() {
+		return sha1, nil
+	}
+	c.objectsMu.Lock()
+	defer c.objectsMu.Unlock()
+	if c.replaceRefs == nil {
+		if err := c.loadReplaceRefs(); err != nil {
+			return sha1, err
+		}
+	}
+	seen := make(map[Sha1]struct{})
+	for {
+		replacement, ok := c.replaceRefs[sha1]
+		if !ok {
+			return sha1, nil
+		}
+		if _, ok := seen[sha1]; ok {
+			return sha1, fmt.Errorf("replace depth too high for object %v", sha1)
+		}
+		seen[sha1] = struct{}{}
+		sha1 = replacement
+	}
+}
+
+// Returns the type of the object sha1 as stored, without substituting
+// any replacement for it.
+func (c *Client) rawObjectType(sha1 Sha1) (string, error) {
+	obj, err := c.readObject(sha1, true)
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve '%v' as a valid object: %v", sha1, err)
+	}
+	return obj.GetType(), nil
+}
+
+// ReplaceRef creates a replace ref so that any time the object original is
+// read, replacement is used instead. Both objects must exist and be of the
+// same type, and original must not already be replaced.
+func ReplaceRef(c *Client, original, replacement Sha1) error {
+	if original == replacement {
+		return fmt.Errorf("new object is the same as the old one: '%v'", original)
+	}
+	otype, err := c.rawObjectType(original)
+	if err != nil {
+		return err
+	}
+	rtype, err := c.rawObjectType(replacement)
+	if err != nil {
+		return err
+	}
+	if otype != rtype {
+		return fmt.Errorf("Objects must be of the same type.\n'%v' points to a replaced object of type '%v'\nwhile '%v' points to a replacement object of type '%v'.", original, otype, replacement, rtype)
+	}
+	if c.replaceRefs == nil {
+		if err := c.loadReplaceRefs(); err != nil {
+			return err
+		}
+	}
+	if _, ok := c.replaceRefs[original]; ok {
+		return fmt.Errorf("replace ref 'refs/replace/%v' already exists", original)
+	}
+
+	// Make sure that following the chain of replacements from the
+	// replacement doesn't lead back to the original.
+	seen := map[Sha1]struct{}{replacement: struct{}{}}
+	for cur, ok := c.replaceRefs[replacement]; ok; cur, ok = c.replaceRefs[cur] {
+		if cur == original {
+			return fmt.Errorf("replacing '%v' with '%v' would create a cycle", original, replacement)
+		}
+		if _, ok := seen[cur]; ok {
+			break
+		}
+		seen[cur] = struct{}{}
+	}
+
+	err = c.GitDir.WriteLocked(File("refs/replace/"+original.String()), func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%v\n", replacement)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	c.replaceRefs[original] = replacement
+	c.objcache.clear()
+	c.commitGraph, c.commitGraphLoaded = nil, false
+	return nil
+}
+
+// ReplaceList returns the replace refs in the repository. The Value of each
+// Ref is the replacement object.
+func ReplaceList(c *Client) ([]Ref, error) {
+	if err := c.loadReplaceRefs(); err != nil {
+		return nil, err
+	}
+	refs := make([]Ref, 0, len(c.replaceRefs))
+	for original, replacement := range c.replaceRefs {
+		refs = append(refs, Ref{"refs/replace/" + original.String(), replacement})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, nil
+}
+
+// ReplaceDelete deletes the replace ref for original.
+func ReplaceDelete(c *Client, original Sha1) error {
+	if c.replaceRefs == nil {
+		if err := c.loadReplaceRefs(); err != nil {
+			return err
+		}
+	}
+	if _, ok := c.replaceRefs[original]; !ok {
+		return fmt.Errorf("replace ref '%v' not found", original)
+	}
+	if err := os.Remove(c.GitDir.File(File("refs/replace/" + original.String())).String()); err != nil {
+		return err
+	}
+	delete(c.replaceRefs, original)
+	c.objcache.clear()
+	return nil
+}
@@ -0,0 +1,87 @@
+package git
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// Tests that replacing a commit with another one makes the history
+// walk follow the replacement, and that cycles are rejected.
+func TestReplaceRef(t *testing.T) {
+	c, cleanup := testRepo(t, "gitreplace")
+	defer cleanup()
+
+	a := testCommitFile(t, c, "foo.txt", "a\n", "a")
+	b := testCommitFile(t, c, "foo.txt", "b\n", "b")
+	head := testCommitFile(t, c, "foo.txt", "c\n", "c")
+
+	// A root commit with the same tree as b, to graft off the history
+	// before it.
+	tree, err := b.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := CommitTree(c, CommitTreeOptions{}, tree, nil, "x")
+	if err != nil && err != NoGlobalConfig {
+		t.Fatal(err)
+	}
+
+	revlist := func() []Sha1 {
+		t.Helper()
+		cmts, err := RevList(c, RevListOptions{Quiet: true}, ioutil.Discard, []Commitish{head}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmts
+	}
+	if cmts := revlist(); len(cmts) != 3 || cmts[2] != Sha1(a) {
+		t.Fatalf("Unexpected history before replacing: got %v", cmts)
+	}
+
+	if err := ReplaceRef(c, Sha1(b), Sha1(x)); err != nil {
+		t.Fatal(err)
+	}
+	if !c.GitDir.File(File("refs/replace/" + b.String())).Exists() {
+		t.Error("Replace ref was not created")
+	}
+	if err := ReplaceRef(c, Sha1(b), Sha1(a)); err == nil {
+		t.Error("Expected error replacing an object which is already replaced")
+	}
+
+	cmts := revlist()
+	if len(cmts) != 2 || cmts[0] != Sha1(head) || cmts[1] != Sha1(b) {
+		t.Fatalf("Unexpected history after replacing: got %v want [%v %v]", cmts, head, b)
+	}
+	msg, err := b.GetCommitMessage(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.String() != "x" {
+		t.Errorf("Unexpected message for replaced commit: got %q want %q", msg.String(), "x")
+	}
+
+	refs, err := ReplaceList(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Name != "refs/replace/"+b.String() || refs[0].Value != Sha1(x) {
+		t.Errorf("Unexpected replace refs: %v", refs)
+	}
+
+	if err := ReplaceRef(c, Sha1(x), Sha1(b)); err == nil {
+		t.Error("Expected error creating a replacement cycle")
+	}
+
+	c.NoReplaceObjects = true
+	if cmts := revlist(); len(cmts) != 3 {
+		t.Errorf("Replacement was used with NoReplaceObjects: got %v", cmts)
+	}
+	c.NoReplaceObjects = false
+
+	if err := ReplaceDelete(c, Sha1(b)); err != nil {
+		t.Fatal(err)
+	}
+	if cmts := revlist(); len(cmts) != 3 {
+		t.Errorf("Replacement was used after deleting it: got %v", cmts)
+	}
+}
@@ -0,0 +1,171 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The repository extensions, from extensions.* in the config, which dgit
+// understands. The names are in lower case, since they're case insensitive.
+var supportedExtensions = map[string]bool{
+	"noop":         true,
+	"objectformat": true,
+	"partialclone": true,
+}
+
+// OpenRepository opens the repository at path and returns a client for it.
+// path may be the work tree of a repository, which has a .git directory or
+// a .git file pointing to the git directory, or a git directory itself, as
+// with a bare repository.
+//
+// Unlike NewClient, OpenRepository doesn't search the parents of path or
+// look at the environment. The git directory is checked for HEAD, objects
+// and refs, and the repository format version and extensions in its config
+// are checked to make sure that dgit can use the repository, so that the
+// error says why path isn't a repository that can be opened.
+func OpenRepository(path string) (*Client, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if !File(path).IsDir() {
+		return nil, fmt.Errorf("%v is not a directory", path)
+	}
+
+	var gitdir GitDir
+	var workdir WorkDir
+	dotgit := File(filepath.Join(path, ".git"))
+	switch {
+	case dotgit.IsDir():
+		gitdir, workdir = GitDir(dotgit), WorkDir(path)
+	case dotgit.Exists():
+		dir, err := readGitFile(dotgit)
+		if err != nil {
+			return nil, err
+		}
+		gitdir, workdir = GitDir(dir), WorkDir(path)
+	default:
+		gitdir = GitDir(path)
+	}
+	if err := gitdir.validate(); err != nil {
+		if workdir == "" {
+			return nil, fmt.Errorf("Not a git repository: %v: %v", path, err)
+		}
+		return nil, err
+	}
+
+	config, err := gitdir.readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRepositoryFormat(config); err != nil {
+		return nil, err
+	}
+
+	// A git directory which isn't bare and is opened directly has the
+	// work tree that it's in, the same as with NewClient.
+	if bare, _ := config.GetConfig("core.bare"); workdir == "" && bare != "true" && filepath.Base(path) == ".git" {
+		workdir = WorkDir(filepath.Dir(path))
+	}
+	return newClient(gitdir, workdir), nil
+}
+
+// Returns an error describing what's missing if g doesn't have the layout
+// of a git directory, which has a HEAD file and objects and refs
+// directories. In a linked worktree, the objects and refs directories are
+// in the common directory from the commondir file.
+func (g GitDir) validate() error {
+	if !g.File("HEAD").Exists() {
+		return fmt.Errorf("Invalid git directory %v: HEAD does not exist", g)
+	}
+	common := g
+	if commondir := g.File("commondir"); commondir.Exists() {
+		line, err := commondir.ReadFirstLine()
+		if err != nil {
+			return err
+		}
+		dir := strings.TrimSpace(line)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(g.String(), dir)
+		}
+		common = GitDir(dir)
+	}
+	for _, dir := range []File{"objects", "refs"} {
+		if !common.File(dir).IsDir() {
+			return fmt.Errorf("Invalid git directory %v: %v is not a directory", g, dir)
+		}
+	}
+	return nil
+}
+
+// Reads the config file of the git directory g, without its includes. If
+// there's no config file, the config is empty.
+func (g GitDir) readConfig() (GitConfig, error) {
+	file, err := os.Open(g.File("config").String())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return GitConfig{}, nil
+		}
+		return GitConfig{}, err
+	}
+	defer file.Close()
+	return ParseConfig(file), nil
+}
+
+// Returns an error if the repository with the config can't be used by dgit,
+// because it has a repository format version newer than 1, or because it's
+// version 1 and uses an extension which dgit doesn't understand. As in git,
+// the extensions of a version 0 repository are ignored.
+func checkRepositoryFormat(config GitConfig) error {
+	version := 0
+	if v, _ := config.GetConfig("core.repositoryformatversion"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("Invalid core.repositoryformatversion: %v", v)
+		}
+		version = n
+	}
+	switch version {
+	case 0:
+		return nil
+	case 1:
+	default:
+		return fmt.Errorf("Expected git repo version <= 1, found %d", version)
+	}
+
+	extensions, err := config.GetRegexp(`^extensions\.`, "")
+	if err != nil {
+		return err
+	}
+	var unknown []string
+	for _, ext := range extensions {
+		name := strings.TrimPrefix(ext.Name, "extensions.")
+		if !supportedExtensions[name] {
+			unknown = append(unknown, name)
+			continue
+		}
+		if name == "objectformat" {
+			h, err := ParseHashAlgorithm(ext.Value)
+			if err != nil {
+				return err
+			}
+			if h != HashSHA1 {
+				// Only objects can be written with other hash
+				// algorithms, the index and object readers
+				// still expect SHA-1 IDs.
+				return fmt.Errorf("Unsupported object format: %v", h)
+			}
+		}
+	}
+	switch len(unknown) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("Unknown repository extension found: %v", unknown[0])
+	default:
+		return fmt.Errorf("Unknown repository extensions found: %v", strings.Join(unknown, ", "))
+	}
+}
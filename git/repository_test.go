@@ -0,0 +1,234 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitopenrepo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A work tree with a .git directory.
+	work := filepath.Join(dir, "work")
+	if _, err := Init(nil, InitOptions{Quiet: true}, work); err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenRepository(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := GitDir(filepath.Join(work, ".git")); c.GitDir != want {
+		t.Errorf("Unexpected GitDir: got %v want %v", c.GitDir, want)
+	}
+	if want := WorkDir(work); c.WorkDir != want {
+		t.Errorf("Unexpected WorkDir: got %v want %v", c.WorkDir, want)
+	}
+	if c.IsBare() {
+		t.Error("Repository with a work tree is bare")
+	}
+
+	// Opening the .git directory finds the same work tree.
+	c, err = OpenRepository(filepath.Join(work, ".git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := WorkDir(work); c.WorkDir != want {
+		t.Errorf("Unexpected WorkDir for .git directory: got %v want %v", c.WorkDir, want)
+	}
+
+	// A .git file is followed to the git directory.
+	linked := filepath.Join(dir, "linked")
+	if err := os.Mkdir(linked, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(linked, ".git"), []byte("gitdir: ../work/.git\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = OpenRepository(linked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := GitDir(filepath.Join(work, ".git")); c.GitDir != want {
+		t.Errorf("Unexpected GitDir for .git file: got %v want %v", c.GitDir, want)
+	}
+	if want := WorkDir(linked); c.WorkDir != want {
+		t.Errorf("Unexpected WorkDir for .git file: got %v want %v", c.WorkDir, want)
+	}
+
+	// A bare repository has no work tree.
+	bare := filepath.Join(dir, "bare.git")
+	if _, err := Init(nil, InitOptions{Quiet: true, Bare: true}, bare); err != nil {
+		t.Fatal(err)
+	}
+	c, err = OpenRepository(bare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GitDir != GitDir(bare) || c.WorkDir != "" {
+		t.Errorf("Unexpected bare client: GitDir %v WorkDir %q", c.GitDir, c.WorkDir)
+	}
+	if !c.IsBare() {
+		t.Error("Bare repository is not bare")
+	}
+
+	// A directory which isn't a repository, and one which is missing
+	// part of the layout, are errors which say what's wrong.
+	notrepo := filepath.Join(dir, "notrepo")
+	if err := os.Mkdir(notrepo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRepository(notrepo); err == nil || !strings.Contains(err.Error(), "Not a git repository") {
+		t.Errorf("Unexpected error for a directory which isn't a repository: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(bare, "refs")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRepository(bare); err == nil || !strings.Contains(err.Error(), "refs is not a directory") {
+		t.Errorf("Unexpected error for a repository without refs: %v", err)
+	}
+	if _, err := OpenRepository(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a directory which doesn't exist")
+	}
+
+	// Repositories with a newer format can't be opened.
+	c, err = OpenRepository(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GitDir.WriteFile("config", []byte("[core]\n\trepositoryformatversion = 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRepository(work); err == nil || !strings.Contains(err.Error(), "version <= 1") {
+		t.Errorf("Unexpected error for repository format version 2: %v", err)
+	}
+}
+
+func TestRepositoryExtensions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitrepoext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := Init(nil, InitOptions{Quiet: true}, dir); err != nil {
+		t.Fatal(err)
+	}
+	gitdir := filepath.Join(dir, ".git")
+
+	tests := []struct {
+		config string
+		err    string
+	}{
+		// Extensions which dgit understands.
+		{"[core]\n\trepositoryformatversion = 1\n[extensions]\n\tnoop = true\n\tpartialClone = origin\n", ""},
+		{"[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectFormat = sha1\n", ""},
+
+		// Extensions are ignored in version 0 repositories.
+		{"[core]\n\trepositoryformatversion = 0\n[extensions]\n\tunknownThing = true\n", ""},
+
+		{"[core]\n\trepositoryformatversion = 1\n[extensions]\n\tworktreeConfig = true\n", "Unknown repository extension found: worktreeconfig"},
+		{"[core]\n\trepositoryformatversion = 1\n[extensions]\n\tfoo = true\n\tbar = true\n", "Unknown repository extensions found: foo, bar"},
+		{"[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectFormat = md5\n", "Unknown object format: md5"},
+
+		// SHA-256 objects can be written, but not read yet.
+		{"[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectFormat = sha256\n", "Unsupported object format: sha256"},
+	}
+	for i, tc := range tests {
+		if err := ioutil.WriteFile(filepath.Join(gitdir, "config"), []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, open := range []func() error{
+			func() error { _, err := NewClient(gitdir, dir); return err },
+			func() error { _, err := OpenRepository(dir); return err },
+		} {
+			err := open()
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("Case %d: unexpected error: %v", i, err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Errorf("Case %d: got error %v want %q", i, err, tc.err)
+			}
+		}
+	}
+}
+
+// Tests that a repository laid out the way git init --object-format=sha256
+// lays it out, with a commit and an index of SHA-256 IDs, is refused when
+// it's opened instead of failing when the IDs are read.
+func TestSHA256Repository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitsha256repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := Init(nil, InitOptions{Quiet: true}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const config = "[core]\n\trepositoryformatversion = 1\n\tfilemode = true\n\tbare = false\n\tlogallrefupdates = true\n[extensions]\n\tobjectformat = sha256\n"
+	if err := c.GitDir.WriteFile("config", []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The objects are written with a client that was told to use
+	// SHA-256, since the repository can't be opened.
+	c.SetCachedConfig("core.repositoryformatversion", "1")
+	c.SetCachedConfig("extensions.objectformat", "sha256")
+	blob, err := c.WriteObjectID("blob", []byte("hi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "96c18f0297e38d01f4b2dacddea4259aea6b2961eb0822bd2c0c3f6029030045"; blob.String() != want {
+		t.Fatalf("Unexpected SHA-256 blob: got %v want %v", blob, want)
+	}
+	tree, err := c.WriteObjectID("tree", append([]byte("100644 a\000"), blob.Bytes()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "001cc91be706c78c4f70ff2e0d4034fb366e9742e04bcd0754666eeb9687b95a"; tree.String() != want {
+		t.Fatalf("Unexpected SHA-256 tree: got %v want %v", tree, want)
+	}
+	commit, err := c.WriteObjectID("commit", []byte("tree "+tree.String()+"\nauthor a <a@b> 1 +0000\ncommitter a <a@b> 1 +0000\n\nm\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GitDir.WriteFile("refs/heads/master", []byte(commit.String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A version 2 index with one entry, whose ID and checksum are
+	// SHA-256.
+	var index bytes.Buffer
+	index.WriteString("DIRC")
+	binary.Write(&index, binary.BigEndian, []uint32{2, 1})
+	binary.Write(&index, binary.BigEndian, []uint32{0, 0, 0, 0, 0, 0, uint32(ModeBlob), 0, 0, 3})
+	index.Write(blob.Bytes())
+	binary.Write(&index, binary.BigEndian, uint16(1))
+	index.WriteString("a")
+	index.Write(make([]byte, 5))
+	sum := sha256.Sum256(index.Bytes())
+	index.Write(sum[:])
+	if err := c.GitDir.WriteFile("index", index.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a"), []byte("hi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gitdir := filepath.Join(dir, ".git")
+	if _, err := NewClient(gitdir, dir); err == nil || !strings.Contains(err.Error(), "Unsupported object format: sha256") {
+		t.Errorf("Unexpected error from NewClient for a SHA-256 repository: %v", err)
+	}
+	if _, err := OpenRepository(dir); err == nil || !strings.Contains(err.Error(), "Unsupported object format: sha256") {
+		t.Errorf("Unexpected error from OpenRepository for a SHA-256 repository: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Returns whether conflict resolutions should be reused. If rerere.enabled
+// isn't set, it's enabled if the rr-cache directory exists, like git.
+func rerereEnabled(c *Client) bool {
+	switch c.GetConfig("rerere.enabled") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return c.GitDir.File("rr-cache").Exists()
+}
+
+// Returns true if line is a conflict marker made of the character m. The
+// "=======" marker can't have anything after it, while the others may be
+// followed by a label.
+func isConflictMarker(line string, m byte) bool {
+	if len(line) < defaultConflictMarkerSize || strings.Count(line[:defaultConflictMarkerSize], string(m)) != defaultConflictMarkerSize {
+		return false
+	}
+	rest := strings.TrimRight(line[defaultConflictMarkerSize:], "\r\n")
+	if m == '=' {
+		return rest == ""
+	}
+	return rest == "" || rest[0] == ' '
+}
+
+// Normalizes the conflicts in content so that the same conflict has the
+// same signature no matter which side of the merge the changes came from,
+// what the conflict markers were labelled, or whether the base was shown.
+//
+// The sides of each conflict are put in sorted order and the labels and
+// base are removed. The returned signature is the SHA1 of the sides of
+// each conflict, each terminated by a NUL, which is the same as git's
+// conflict ID. The number of conflicts is also returned.
+func rerereNormalize(content []byte) ([]byte, Sha1, int, error) {
+	const (
+		outside = iota
+		inOurs
+		inBase
+		inTheirs
+	)
+	var out, ours, theirs bytes.Buffer
+	h := sha1.New()
+	state, conflicts := outside, 0
+	for _, line := range splitMergeLines(content) {
+		switch {
+		case state == outside && isConflictMarker(line, '<'):
+			state = inOurs
+		case state == inOurs && isConflictMarker(line, '|'):
+			state = inBase
+		case (state == inOurs || state == inBase) && isConflictMarker(line, '='):
+			state = inTheirs
+		case state == inTheirs && isConflictMarker(line, '>'):
+			one, two := ours.Bytes(), theirs.Bytes()
+			if bytes.Compare(one, two) > 0 {
+				one, two = two, one
+			}
+			fmt.Fprintf(&out, "<<<<<<<\n%s=======\n%s>>>>>>>\n", one, two)
+			h.Write(one)
+			h.Write([]byte{0})
+			h.Write(two)
+			h.Write([]byte{0})
+			ours.Reset()
+			theirs.Reset()
+			state = outside
+			conflicts++
+		case state == outside:
+			out.WriteString(line)
+		case state == inOurs:
+			ours.WriteString(line)
+		case state == inTheirs:
+			theirs.WriteString(line)
+		}
+	}
+	if state != outside {
+		return nil, Sha1{}, 0, fmt.Errorf("Unterminated conflict")
+	}
+	var id Sha1
+	copy(id[:], h.Sum(nil))
+	return out.Bytes(), id, conflicts, nil
+}
+
+// A path with a conflict that rerere is waiting to record the resolution
+// of, as listed in $GIT_DIR/MERGE_RR.
+type rerereConflict struct {
+	id   Sha1
+	path IndexPath
+}
+
+// Reads the conflicts from MERGE_RR, which has an entry for each path of
+// the form "<id>\t<path>\0".
+func readMergeRR(c *Client) ([]rerereConflict, error) {
+	data, err := ioutil.ReadFile(c.GitDir.File("MERGE_RR").String())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var conflicts []rerereConflict
+	for _, entry := range strings.Split(string(data), "\000") {
+		if entry == "" {
+			continue
+		}
+		tab := strings.IndexByte(entry, '\t')
+		if tab < 0 {
+			return nil, fmt.Errorf("Invalid MERGE_RR entry %q", entry)
+		}
+		id, err := Sha1FromString(entry[:tab])
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, rerereConflict{id, IndexPath(entry[tab+1:])})
+	}
+	return conflicts, nil
+}
+
+// Writes conflicts to MERGE_RR. If there aren't any, the file is removed.
+func writeMergeRR(c *Client, conflicts []rerereConflict) error {
+	f := c.GitDir.File("MERGE_RR")
+	if len(conflicts) == 0 {
+		if f.Exists() {
+			return os.Remove(f.String())
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, conflict := range conflicts {
+		fmt.Fprintf(&buf, "%v\t%v\000", conflict.id, conflict.path)
+	}
+	return ioutil.WriteFile(f.String(), buf.Bytes(), 0644)
+}
+
+// Returns the file in the rr-cache directory of the conflict id.
+func rerereFile(c *Client, id Sha1, name string) File {
+	return c.GitDir.File(File("rr-cache/" + id.String() + "/" + name))
+}
+
+// RerereResolve looks up each of the conflicted paths in the rr-cache. If
+// a resolution of the same conflict was recorded, it's applied to the file
+// in the work tree. Otherwise, the conflict is recorded so that
+// RerereRecord can save its resolution once it's resolved.
+//
+// The paths that were resolved are returned. Their entries in the index
+// are not updated.
+func RerereResolve(c *Client, paths []IndexPath) ([]IndexPath, error) {
+	pending, err := readMergeRR(c)
+	if err != nil {
+		return nil, err
+	}
+	var resolved []IndexPath
+	for _, path := range paths {
+		fp, err := path.FilePath(c)
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadFile(fp.String())
+		if err != nil {
+			return nil, err
+		}
+		normalized, id, conflicts, err := rerereNormalize(content)
+		if err != nil || conflicts == 0 {
+			// Files with conflicts that can't be parsed are
+			// skipped, like git.
+			continue
+		}
+		pending = append(pending, rerereConflict{id, path})
+
+		preimage, postimage := rerereFile(c, id, "preimage"), rerereFile(c, id, "postimage")
+		if !postimage.Exists() {
+			if !preimage.Exists() {
+				if err := os.MkdirAll(c.GitDir.File(File("rr-cache/"+id.String())).String(), 0755); err != nil {
+					return nil, err
+				}
+				if err := ioutil.WriteFile(preimage.String(), normalized, 0644); err != nil {
+					return nil, err
+				}
+				fmt.Fprintf(os.Stderr, "Recorded preimage for '%v'\n", fp)
+			}
+			continue
+		}
+
+		// The resolution is the change from the preimage to the
+		// postimage, which is merged into this conflict in case
+		// anything outside of the conflicts is different.
+		base, err := os.Open(preimage.String())
+		if err != nil {
+			return nil, err
+		}
+		other, err := os.Open(postimage.String())
+		if err != nil {
+			base.Close()
+			return nil, err
+		}
+		merged, conflicts, err := MergeFile(bytes.NewReader(normalized), base, other, MergeFileOptions{})
+		base.Close()
+		other.Close()
+		if err != nil {
+			return nil, err
+		}
+		if conflicts > 0 {
+			continue
+		}
+		if err := ioutil.WriteFile(fp.String(), merged, 0644); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Resolved '%v' using previous resolution.\n", fp)
+		resolved = append(resolved, path)
+	}
+	return resolved, writeMergeRR(c, pending)
+}
+
+// RerereRecord records the resolution of each conflict from RerereResolve
+// which no longer has conflict markers in the work tree, so that the next
+// time that the same conflict happens it can be resolved automatically.
+//
+// The paths whose resolutions were recorded are returned.
+func RerereRecord(c *Client) ([]IndexPath, error) {
+	pending, err := readMergeRR(c)
+	if err != nil {
+		return nil, err
+	}
+	var recorded []IndexPath
+	remaining := pending[:0]
+	for _, conflict := range pending {
+		fp, err := conflict.path.FilePath(c)
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadFile(fp.String())
+		if err != nil {
+			if os.IsNotExist(err) {
+				// The file was removed, so there's no resolution
+				// to record.
+				continue
+			}
+			return nil, err
+		}
+		if _, _, conflicts, err := rerereNormalize(content); err != nil || conflicts > 0 {
+			remaining = append(remaining, conflict)
+			continue
+		}
+		postimage := rerereFile(c, conflict.id, "postimage")
+		if !postimage.Exists() && rerereFile(c, conflict.id, "preimage").Exists() {
+			if err := ioutil.WriteFile(postimage.String(), content, 0644); err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(os.Stderr, "Recorded resolution for '%v'.\n", fp)
+			recorded = append(recorded, conflict.path)
+		}
+	}
+	return recorded, writeMergeRR(c, remaining)
+}
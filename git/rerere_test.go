@@ -0,0 +1,101 @@
+package git
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestRerere(t *testing.T) {
+	c, cleanup := testRepo(t, "gitrerere")
+	defer cleanup()
+	c.SetCachedConfig("rerere.enabled", "true")
+
+	base := testCommitFile(t, c, "foo.txt", "a\nb\nc\n", "Initial commit")
+	if err := c.CreateBranch("other", base); err != nil {
+		t.Fatal(err)
+	}
+	ours := testCommitFile(t, c, "foo.txt", "a\nours\nc\n", "Our change")
+	if _, err := Checkout(c, CheckoutOptions{}, "other", nil); err != nil {
+		t.Fatal(err)
+	}
+	theirs := testCommitFile(t, c, "foo.txt", "a\ntheirs\nc\n", "Their change")
+	if _, err := Checkout(c, CheckoutOptions{}, "master", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Merge(c, MergeOptions{}, []Commitish{Branch("refs/heads/other")}); err == nil {
+		t.Fatal("Expected conflict merging other")
+	}
+	pending, err := readMergeRR(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].path != "foo.txt" {
+		t.Fatalf("Unexpected MERGE_RR after conflict: %v", pending)
+	}
+	if !rerereFile(c, pending[0].id, "preimage").Exists() {
+		t.Fatal("Preimage was not recorded")
+	}
+
+	// Resolve the conflict and commit, which records the resolution.
+	const resolution = "a\nresolved\nc\n"
+	if err := ioutil.WriteFile("foo.txt", []byte(resolution), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Commit(c, CommitOptions{}, "Resolved", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !rerereFile(c, pending[0].id, "postimage").Exists() {
+		t.Fatal("Resolution was not recorded")
+	}
+	if pending, err := readMergeRR(c); err != nil || len(pending) != 0 {
+		t.Errorf("Unexpected MERGE_RR after resolution: %v (%v)", pending, err)
+	}
+
+	// Redoing the same merge, and the same merge in the other direction,
+	// should reuse the resolution.
+	tests := []struct {
+		head, other CommitID
+	}{
+		{ours, theirs},
+		{theirs, ours},
+	}
+	for _, tc := range tests {
+		if _, err := ResetMode(c, ResetOptions{Hard: true}, tc.head); err != nil {
+			t.Fatal(err)
+		}
+		if err := Merge(c, MergeOptions{}, []Commitish{tc.other}); err == nil {
+			t.Errorf("Merging %v: rerere resolutions should still need to be added", tc.other)
+		}
+		content, err := ioutil.ReadFile("foo.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != resolution {
+			t.Errorf("Merging %v: got %q want %q", tc.other, content, resolution)
+		}
+	}
+}
+
+func TestRerereNormalize(t *testing.T) {
+	_, id1, n, err := rerereNormalize([]byte("x\n<<<<<<< HEAD\nB\n||||||| base\nb\n=======\nX\n>>>>>>> other\ny\n"))
+	if err != nil || n != 1 {
+		t.Fatalf("Unexpected result: %v conflicts (%v)", n, err)
+	}
+	normalized, id2, n, err := rerereNormalize([]byte("x\n<<<<<<< other\nX\n=======\nB\n>>>>>>> HEAD\ny\n"))
+	if err != nil || n != 1 {
+		t.Fatalf("Unexpected result: %v conflicts (%v)", n, err)
+	}
+	if id1 != id2 {
+		t.Errorf("Same conflict had different ids %v and %v", id1, id2)
+	}
+	if want := "x\n<<<<<<<\nB\n=======\nX\n>>>>>>>\ny\n"; string(normalized) != want {
+		t.Errorf("got %q want %q", normalized, want)
+	}
+	if _, _, _, err := rerereNormalize([]byte("<<<<<<< HEAD\nB\n")); err == nil {
+		t.Error("Expected error for unterminated conflict")
+	}
+}
@@ -8,6 +8,10 @@ type ResetOptions struct {
 	Quiet bool
 
 	Soft, Mixed, Hard, Merge, Keep bool
+
+	// Only work out what would change, without touching HEAD, the
+	// index or the working tree.
+	DryRun bool
 }
 
 // Reset implementes the "git reset" command and delegates to the appropriate
@@ -24,9 +28,12 @@ type ResetOptions struct {
 // an error and a message to disambiguate based on the presence of "--" on
 // the command line. (This is not an option here, because this is a library
 // function, not a command line.)
-func Reset(c *Client, opts ResetOptions, files []File) error {
+//
+// The paths which were changed in the index or working tree are returned,
+// or the ones which would have been if opts.DryRun is set.
+func Reset(c *Client, opts ResetOptions, files []File) ([]PlannedChange, error) {
 	if opts.Soft && len(files) > 1 {
-		return fmt.Errorf("Cannot do soft reset with paths")
+		return nil, fmt.Errorf("Cannot do soft reset with paths")
 	}
 	if opts.Mixed {
 		switch {
@@ -41,31 +48,31 @@ func Reset(c *Client, opts ResetOptions, files []File) error {
 		case len(files) == 0:
 			cmt, err := c.GetHeadCommit()
 			if err != nil {
-				return err
+				return nil, err
 			}
 			return ResetMode(c, opts, cmt)
 		}
 	}
 	if opts.Hard && len(files) > 1 {
-		return fmt.Errorf("Cannot do hard reset with paths")
+		return nil, fmt.Errorf("Cannot do hard reset with paths")
 	}
 	if opts.Merge && len(files) > 1 {
-		return fmt.Errorf("Cannot do merge reset with paths")
+		return nil, fmt.Errorf("Cannot do merge reset with paths")
 	}
 	if opts.Keep && len(files) > 1 {
-		return fmt.Errorf("Cannot do keep reset with paths")
+		return nil, fmt.Errorf("Cannot do keep reset with paths")
 	}
 	if opts.Soft || opts.Mixed || opts.Hard || opts.Merge || opts.Keep {
 		if len(files) == 0 {
 			head, err := c.GetHeadCommit()
 			if err != nil {
-				return err
+				return nil, err
 			}
 			return ResetMode(c, opts, head)
 		} else if len(files) == 1 {
 			cmt, err := RevParseCommitish(c, &RevParseOptions{}, files[0].String())
 			if err != nil {
-				return err
+				return nil, err
 			}
 			return ResetMode(c, opts, cmt)
 		}
@@ -77,7 +84,7 @@ unstage:
 	if len(files) == 0 {
 		head, err := c.GetHeadCommit()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		return ResetUnstage(c, opts, head, files)
 	}
@@ -85,7 +92,7 @@ unstage:
 	if err != nil {
 		treeish, err = c.GetHeadCommit()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else {
 		files = files[1:]
@@ -94,7 +101,7 @@ unstage:
 }
 
 // ResetMode implements "git reset [--soft | --mixed | --hard | --merge | --keep]" <commit>
-func ResetMode(c *Client, opts ResetOptions, cmt Commitish) error {
+func ResetMode(c *Client, opts ResetOptions, cmt Commitish) ([]PlannedChange, error) {
 	if !opts.Soft && !opts.Mixed && !opts.Hard && !opts.Merge && !opts.Keep {
 		// The default mode is mixed if none were specified.
 		opts.Mixed = true
@@ -107,75 +114,105 @@ func ResetMode(c *Client, opts ResetOptions, cmt Commitish) error {
 	// if merge | keep-- read man page more carefully, for now return an error
 
 	if opts.Merge {
-		return fmt.Errorf("ResetMode --merge Not implemented")
+		return nil, fmt.Errorf("ResetMode --merge Not implemented")
 	}
 	if opts.Keep {
-		return fmt.Errorf("ResetMode --keep Not implemented")
+		return nil, fmt.Errorf("ResetMode --keep Not implemented")
 	}
 
 	comm, err := cmt.CommitID(c)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var changes []PlannedChange
+	switch {
+	case opts.Hard:
+		// The changes in the work tree are thrown away too.
+		if changes, err = plannedResetChanges(c, comm); err != nil {
+			return nil, err
+		}
+	case opts.Mixed:
+		diffs, err := DiffIndex(c, DiffIndexOptions{Cached: true}, nil, comm, nil)
+		if err != nil {
+			return nil, err
+		}
+		if changes, err = plannedTreeChanges(c, diffs, true); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DryRun {
+		return changes, nil
 	}
 	if err := UpdateRef(c, UpdateRefOptions{}, "HEAD", comm, fmt.Sprintf("reset: moving to %v", comm)); err != nil {
-		return err
+		return nil, err
 	}
-	if opts.Mixed || opts.Hard {
-		idx, err := ReadTree(c, ReadTreeOptions{Reset: true, Update: true}, comm)
-		if err != nil {
-			return err
+	switch {
+	case opts.Hard:
+		if err := resetToTree(c, comm); err != nil {
+			return nil, err
 		}
-		if opts.Hard {
-			if err := CheckoutIndexUncommited(c, idx, CheckoutIndexOptions{All: true, Force: true, UpdateStat: true}, nil); err != nil {
-				return err
-			}
+	case opts.Mixed:
+		if _, err := ReadTree(c, ReadTreeOptions{Reset: true, Update: true}, comm); err != nil {
+			return nil, err
 		}
 	}
-	return nil
+	return changes, nil
 }
 
 // ResetUnstage implements "git reset [<treeish>] -- paths
-func ResetUnstage(c *Client, opts ResetOptions, tree Treeish, files []File) error {
-	index, _ := c.GitDir.ReadIndex()
+func ResetUnstage(c *Client, opts ResetOptions, tree Treeish, files []File) ([]PlannedChange, error) {
+	index, lock, err := c.GitDir.LockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Rollback()
 	diffs, err := DiffIndex(c, DiffIndexOptions{Cached: true}, index, tree, files)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	var changes []PlannedChange
 	for _, entry := range diffs {
 		f, err := entry.Name.FilePath(c)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		mtime, err := f.MTime()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if entry.Src == (TreeEntry{}) {
 			// The file wasn't in HEAD. Remove it from the index,
 			// but only do it for files that were explicitly removed.
 			for _, unstage := range files {
 				if f == unstage {
+					changes = append(changes, PlannedChange{Action: ChangeRemove, Path: f, IndexOnly: true})
 					index.RemoveFile(entry.Name)
 				}
 			}
-		} else if err := index.AddStage(c, entry.Name, entry.Src.FileMode, entry.Src.Sha1, Stage0, uint32(entry.SrcSize), mtime, UpdateIndexOptions{Add: true}); err != nil {
-			return err
+			continue
+		}
+		change := PlannedChange{Action: ChangeUpdate, Path: f, IndexOnly: true}
+		if entry.Dst == (TreeEntry{}) {
+			change.Action = ChangeAdd
+		}
+		changes = append(changes, change)
+		if err := index.AddStage(c, entry.Name, entry.Src.FileMode, entry.Src.Sha1, Stage0, uint32(entry.SrcSize), mtime, UpdateIndexOptions{Add: true}); err != nil {
+			return nil, err
 		}
 	}
-
-	f, err := c.GitDir.Create(File("index"))
-	if err != nil {
-		return err
+	if opts.DryRun {
+		return changes, nil
 	}
-	defer f.Close()
-	if err := index.WriteIndex(f); err != nil {
-		return err
+
+	index.UseConfiguredVersion(c)
+	if err := index.CommitLocked(lock); err != nil {
+		return nil, err
 	}
 
 	if !opts.Quiet {
 		newdiff, err := DiffFiles(c, DiffFilesOptions{}, nil)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if len(newdiff) > 0 {
 			fmt.Printf("Unstaged changes after reset:\n")
@@ -191,5 +228,5 @@ func ResetUnstage(c *Client, opts ResetOptions, tree Treeish, files []File) erro
 		}
 
 	}
-	return nil
+	return changes, nil
 }
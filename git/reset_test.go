@@ -3,6 +3,7 @@ package git
 import (
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -45,7 +46,7 @@ func TestResetFiles(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := Reset(c, ResetOptions{}, []File{"foo.txt"}); err != nil {
+	if _, err := Reset(c, ResetOptions{}, []File{"foo.txt"}); err != nil {
 		t.Error(err)
 	}
 
@@ -66,7 +67,7 @@ func TestResetFiles(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := Reset(c, ResetOptions{}, []File{"bar.txt"}); err != nil {
+	if _, err := Reset(c, ResetOptions{}, []File{"bar.txt"}); err != nil {
 		t.Error(err)
 	}
 
@@ -84,7 +85,7 @@ func TestResetFiles(t *testing.T) {
 	}
 
 	// Get into a detached HEAD state and try again.
-	if err := CheckoutCommit(c, CheckoutOptions{Force: true}, cmt); err != nil {
+	if _, err := CheckoutCommit(c, CheckoutOptions{Force: true}, cmt); err != nil {
 		t.Fatal(err)
 	}
 	if _, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD"); err != DetachedHead {
@@ -98,7 +99,7 @@ func TestResetFiles(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := Reset(c, ResetOptions{}, []File{"foo.txt"}); err != nil {
+	if _, err := Reset(c, ResetOptions{}, []File{"foo.txt"}); err != nil {
 		t.Error(err)
 	}
 
@@ -114,7 +115,7 @@ func TestResetFiles(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := Reset(c, ResetOptions{}, []File{"bar.txt"}); err != nil {
+	if _, err := Reset(c, ResetOptions{}, []File{"bar.txt"}); err != nil {
 		t.Error(err)
 	}
 
@@ -131,3 +132,36 @@ func TestResetFiles(t *testing.T) {
 		t.Error("Unstaged the wrong file.")
 	}
 }
+
+// Tests that a hard reset's dry run lists the changes to the work tree
+// which would be thrown away, and doesn't make them.
+func TestResetHardDryRun(t *testing.T) {
+	c, cleanup := testRepo(t, "gitresetdryrun")
+	defer cleanup()
+	testCommitFile(t, c, "a.txt", "a\n", "Add a")
+	cmt := testCommitFile(t, c, "b.txt", "b\n", "Add b")
+	if err := ioutil.WriteFile("a.txt", []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := ResetMode(c, ResetOptions{Hard: true, DryRun: true}, cmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PlannedChange{
+		{Action: ChangeUpdate, Path: "a.txt"},
+		{Action: ChangeAdd, Path: "b.txt"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Unexpected changes: got %v want %v", changes, want)
+	}
+	if content, err := ioutil.ReadFile("a.txt"); err != nil || string(content) != "changed\n" {
+		t.Errorf("Dry run changed a.txt: %q %v", content, err)
+	}
+	if File("b.txt").Exists() {
+		t.Error("Dry run restored b.txt")
+	}
+}
@@ -80,7 +80,8 @@ var loadLine = func(r io.Reader) string {
 	if err != nil {
 		return ""
 	}
-	if val == 0 {
+	if val < 4 {
+		// A flush, delimiter or response-end packet.
 		return ""
 	}
 	line := make([]byte, val-4)
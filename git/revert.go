@@ -31,13 +31,9 @@ func Revert(c *Client, opts RevertOptions, commits []Commitish) error {
 	}
 
 	// Ensure that the tree is clean before doing anything.
-	worktree, err := DiffFiles(c, DiffFilesOptions{}, nil)
-	if err != nil {
+	if err := requireClean(c, "revert"); err != nil {
 		return err
 	}
-	if len(worktree) > 0 {
-		return fmt.Errorf("Untracked changes to files would be modified. Aborting")
-	}
 	head, err := c.GetHeadCommit()
 	if err != nil {
 		return err
@@ -3,12 +3,24 @@ package git
 import (
 	"fmt"
 	"io"
+	"strings"
 )
 
 // List of command line options that may be passed to RevList
 type RevListOptions struct {
 	Quiet, Objects bool
 	MaxCount       *uint
+
+	// Only list the commits which changed one of Paths, or anything
+	// under it if it's a directory. As in git, when a merge has the same
+	// version of the paths as one of its parents, the history of the
+	// other parents isn't walked.
+	Paths []IndexPath
+
+	// Walk the history of every parent of a merge which is limited to
+	// Paths. The merges which have a different version of the paths than
+	// any of their parents are listed too.
+	FullHistory bool
 }
 
 var maxCountError = fmt.Errorf("Maximum number of objects has been reached")
@@ -54,7 +66,11 @@ func RevListCallback(c *Client, opt RevListOptions, includes, excludes []Commiti
 			}
 			cIDs = append(cIDs, cmt)
 		}
-		if err := revListCallback(c, opt, cIDs, excludeList, buildExcludeList); err != nil {
+		// Everything reachable from the excludes is excluded, whether
+		// or not it changed the paths.
+		excludeOpt := opt
+		excludeOpt.Paths = nil
+		if err := revListCallback(c, excludeOpt, cIDs, excludeList, buildExcludeList); err != nil {
 			return err
 		}
 	}
@@ -91,29 +107,108 @@ func revListCallback(c *Client, opt RevListOptions, commits []CommitID, excludeL
 			continue
 		}
 
-		if err := callback(Sha1(cmt)); err != nil {
+		parents, err := cmt.Parents(c)
+		if err != nil {
 			return err
 		}
-		excludeList[Sha1(cmt)] = struct{}{}
-
-		if opt.Objects {
-			objs, err := cmt.GetAllObjectsExcept(c, excludeList)
+		changed := true
+		if len(opt.Paths) > 0 {
+			parents, changed, err = simplifyParents(c, cmt, parents, opt.Paths, opt.FullHistory)
 			if err != nil {
 				return err
 			}
-			for _, o := range objs {
-				if err := callback(o); err != nil {
+		}
+
+		if changed {
+			if err := callback(Sha1(cmt)); err != nil {
+				return err
+			}
+		}
+		excludeList[Sha1(cmt)] = struct{}{}
+
+		if changed {
+			if opt.Objects {
+				objs, err := cmt.GetAllObjectsExcept(c, excludeList)
+				if err != nil {
 					return err
 				}
+				for _, o := range objs {
+					if err := callback(o); err != nil {
+						return err
+					}
+				}
 			}
 		}
-		parents, err := cmt.Parents(c)
-		if err != nil {
-			return err
-		}
 		if err := revListCallback(c, opt, parents, excludeList, callback); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// Returns the parents of cmt whose history is walked when it's limited to
+// paths, and whether cmt changed any of them. This is git's default history
+// simplification: a commit which has the same version of the paths as one
+// of its parents (is TREESAME to it) doesn't change them, and only that
+// parent is walked. With fullHistory every parent is walked, and a merge
+// changes the paths unless it's TREESAME to all of its parents.
+func simplifyParents(c *Client, cmt CommitID, parents []CommitID, paths []IndexPath, fullHistory bool) ([]CommitID, bool, error) {
+	tree, err := cmt.TreeID(c)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(parents) == 0 {
+		// A root commit changes the paths which it has.
+		same, err := treesame(c, tree, nil, paths)
+		return nil, !same, err
+	}
+
+	changed := false
+	for _, p := range parents {
+		ptree, err := p.TreeID(c)
+		if err != nil {
+			return nil, false, err
+		}
+		same, err := treesame(c, tree, &ptree, paths)
+		if err != nil {
+			return nil, false, err
+		}
+		if same && !fullHistory {
+			return []CommitID{p}, false, nil
+		}
+		if !same {
+			changed = true
+		}
+	}
+	return parents, changed, nil
+}
+
+// Reports whether tree and other have the same version of each of paths.
+// A nil other is the empty tree.
+func treesame(c *Client, tree TreeID, other *TreeID, paths []IndexPath) (bool, error) {
+	for _, path := range paths {
+		path = IndexPath(strings.TrimSuffix(path.String(), "/"))
+		if path == "" {
+			if other == nil || tree != *other {
+				return false, nil
+			}
+			continue
+		}
+		entry, ok, err := tree.lookupPath(c, path)
+		if err != nil {
+			return false, err
+		}
+		var oentry TreeEntry
+		var ook bool
+		if other != nil {
+			oentry, ook, err = other.lookupPath(c, path)
+			if err != nil {
+				return false, err
+			}
+		}
+		if ok != ook || entry != oentry {
+			return false, nil
+		}
+	}
+	return true, nil
+}
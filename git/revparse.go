@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -194,6 +195,33 @@ func RevParseTreeish(c *Client, opt *RevParseOptions, arg string) (Treeish, erro
 
 // RevParse will parse a single revision into a Commitish object.
 func RevParseCommitish(c *Client, opt *RevParseOptions, arg string) (cmt Commitish, err error) {
+	if strings.HasPrefix(arg, "@{-") {
+		// @{-n} is the nth previous checkout, which may be followed
+		// by other modifiers.
+		if end := strings.IndexByte(arg, '}'); end > 0 {
+			if n, err := strconv.Atoi(arg[3:end]); err == nil {
+				prev, err := PreviousBranch(c, n)
+				if err != nil {
+					return nil, err
+				}
+				return RevParseCommitish(c, opt, prev+arg[end+1:])
+			}
+		}
+	}
+	if at := strings.Index(arg, "@{"); at >= 0 {
+		// ref@{n} is the value of ref before its nth most recent
+		// change, according to its reflog.
+		if end := strings.IndexByte(arg[at:], '}'); end > 0 {
+			if n, err := strconv.Atoi(arg[at+2 : at+end]); err == nil && n >= 0 {
+				cmt, err := reflogValue(c, arg[:at], n)
+				if err != nil {
+					return nil, err
+				}
+				return RevParseCommitish(c, opt, cmt.String()+arg[at+end+1:])
+			}
+		}
+	}
+
 	var cmtbase string
 	if pos := strings.IndexAny(arg, "@^"); pos >= 0 {
 		cmtbase = arg[:pos]
@@ -266,6 +294,10 @@ func RevParseCommitish(c *Client, opt *RevParseOptions, arg string) (cmt Commiti
 	if b, err := GetBranch(c, cmtbase); err == nil {
 		return b, nil
 	}
+	// It might also be a ref directly under refs/, like refs/stash.
+	if rs := c.GitDir.File("refs/" + File(cmtbase)); cmtbase != "" && rs.Exists() {
+		return RefSpec("refs/" + cmtbase), nil
+	}
 
 	// Try seeing if it's an abbreviation of a commit as a last
 	// resort. We require a length of at least 3, so that we only
@@ -491,3 +523,34 @@ func RevParse(c *Client, opt RevParseOptions, args []string) (commits []ParsedRe
 	}
 	return
 }
+
+// Returns the value of the nth entry in name's reflog, counting back from
+// the most recent one, as for "name@{n}". The name is looked up in the same
+// places as a ref, and an empty name refers to the current branch.
+func reflogValue(c *Client, name string, n int) (CommitID, error) {
+	var ref string
+	if name == "" {
+		ref = "HEAD"
+		if b := c.GetHeadBranch(); b != "" {
+			ref = b.String()
+		}
+	} else {
+		for _, candidate := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name} {
+			if c.GitDir.File(File("logs/" + candidate)).Exists() {
+				ref = candidate
+				break
+			}
+		}
+		if ref == "" {
+			return CommitID{}, fmt.Errorf("No reflog for '%v'", name)
+		}
+	}
+	entries, err := readReflog(c, ref)
+	if err != nil {
+		return CommitID{}, err
+	}
+	if n >= len(entries) {
+		return CommitID{}, fmt.Errorf("Log for '%v' only has %d entries.", name, len(entries))
+	}
+	return entries[len(entries)-1-n].New, nil
+}
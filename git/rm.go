@@ -15,17 +15,27 @@ type RmOptions struct {
 	Quiet           bool
 }
 
-func Rm(c *Client, opts RmOptions, files []File) error {
+// Rm removes files from the index and the working tree, and returns the
+// files removed. If opts.DryRun is set, it only returns the files that
+// would have been removed.
+func Rm(c *Client, opts RmOptions, files []File) ([]PlannedChange, error) {
 	if !opts.Recursive {
 		for _, f := range files {
 			if f.IsDir() {
-				return fmt.Errorf("Not removing %v recursively without -r", f)
+				return nil, fmt.Errorf("Not removing %v recursively without -r", f)
 			}
 		}
 	}
-	idx, err := c.GitDir.ReadIndex()
+	idx, lock, err := c.GitDir.LockIndex()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer lock.Rollback()
+
+	// The files being removed may be in sparse directories, which
+	// are collapsed again when the index is written.
+	if err := idx.EnsureFull(c); err != nil {
+		return nil, err
 	}
 	if !opts.IgnoreUnmatched {
 		im := idx.GetMap()
@@ -33,7 +43,7 @@ func Rm(c *Client, opts RmOptions, files []File) error {
 		for _, f := range files {
 			ip, err := f.IndexPath(c)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			var found bool
@@ -44,20 +54,20 @@ func Rm(c *Client, opts RmOptions, files []File) error {
 			}
 
 			if !found {
-				return fmt.Errorf("pathspec %v did not match any files", f)
+				return nil, fmt.Errorf("pathspec %v did not match any files", f)
 			}
 		}
 	}
 	if !opts.Force {
 		modified, err := LsFiles(c, LsFilesOptions{Modified: true}, files)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		errors := ""
 		for _, ip := range modified {
 			f, err := ip.PathName.FilePath(c)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			if errors == "" {
@@ -67,23 +77,25 @@ func Rm(c *Client, opts RmOptions, files []File) error {
 			}
 		}
 		if errors != "" {
-			return fmt.Errorf("%s", errors)
+			return nil, fmt.Errorf("%s", errors)
 		}
 	}
 
 	deleted, err := LsFiles(c, LsFilesOptions{Cached: true}, files)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	changes := make([]PlannedChange, 0, len(deleted))
 	for _, ip := range deleted {
 		f, err := ip.PathName.FilePath(c)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if !opts.Quiet {
 			fmt.Printf("rm '%v'\n", f)
 		}
+		changes = append(changes, PlannedChange{Action: ChangeRemove, Path: f, IndexOnly: opts.Cached})
 		if opts.DryRun {
 			continue
 		}
@@ -92,16 +104,15 @@ func Rm(c *Client, opts RmOptions, files []File) error {
 			continue
 		}
 		if err := f.Remove(); err != nil {
-			return err
-		}
-		if !opts.DryRun {
-			f, err := c.GitDir.Create(File("index"))
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-			return idx.WriteIndex(f)
+			return nil, err
 		}
 	}
-	return nil
+	if opts.DryRun || len(changes) == 0 {
+		return changes, nil
+	}
+	idx.UseConfiguredVersion(c)
+	if err := idx.ConvertToSparse(c); err != nil {
+		return nil, err
+	}
+	return changes, idx.CommitLocked(lock)
 }
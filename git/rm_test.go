@@ -0,0 +1,52 @@
+package git
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Tests that a DryRun Rm returns the files it would remove without
+// touching the index or the working tree.
+func TestRmDryRun(t *testing.T) {
+	c, cleanup := testRepo(t, "gitrmdryrun")
+	defer cleanup()
+	testCommitFile(t, c, "foo.txt", "foo\n", "Add foo")
+	testCommitFile(t, c, "bar.txt", "bar\n", "Add bar")
+
+	index, err := c.GitDir.ReadFile("index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := Rm(c, RmOptions{DryRun: true, Quiet: true}, []File{"foo.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0] != (PlannedChange{Action: ChangeRemove, Path: "foo.txt"}) {
+		t.Errorf("Unexpected planned changes: %v", changes)
+	}
+	if !File("foo.txt").Exists() {
+		t.Error("foo.txt was removed by a dry run")
+	}
+	if after, err := c.GitDir.ReadFile("index"); err != nil || !bytes.Equal(index, after) {
+		t.Errorf("The index was changed by a dry run (%v)", err)
+	}
+
+	// Without DryRun, the same changes are made.
+	real, err := Rm(c, RmOptions{Quiet: true}, []File{"foo.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(real) != 1 || real[0] != changes[0] {
+		t.Errorf("Rm made different changes than planned: got %v want %v", real, changes)
+	}
+	if File("foo.txt").Exists() {
+		t.Error("foo.txt was not removed")
+	}
+	idx, err := LsFiles(c, LsFilesOptions{Cached: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx) != 1 || idx[0].PathName != "bar.txt" {
+		t.Errorf("Unexpected index after rm: %v", idx)
+	}
+}
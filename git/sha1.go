@@ -103,9 +103,10 @@ func (id Sha1) PackEntryType(c *Client) PackEntryType {
 	}
 }
 
-// Returns the git type of the object this Sha1 represents
+// Returns the git type of the object this Sha1 represents. Only the header
+// of the object is read, and the type is cached by c.
 func (id Sha1) Type(c *Client) string {
-	t, _, err := c.GetObjectMetadata(id)
+	t, err := c.ObjectType(id)
 	if err != nil {
 		panic(err)
 		return ""
@@ -114,7 +115,20 @@ func (id Sha1) Type(c *Client) string {
 }
 
 // Returns all direct parents of commit c.
+//
+// If the commit is a shallow boundary or has a graft, the parents from
+// .git/shallow or .git/info/grafts are returned instead of the ones in the
+// commit object. If the commit is in the commit graph, the commit object
+// isn't read.
 func (cmt CommitID) Parents(c *Client) ([]CommitID, error) {
+	if grafted, ok, err := c.graftedParents(cmt); err != nil {
+		return nil, err
+	} else if ok {
+		return grafted, nil
+	}
+	if parents, ok := c.graphParents(cmt); ok {
+		return parents, nil
+	}
 	obj, err := c.GetObject(Sha1(cmt))
 	if err != nil {
 		return nil, err
@@ -144,6 +158,9 @@ func (child CommitID) IsAncestor(c *Client, parent Commitish) bool {
 	if err != nil {
 		return false
 	}
+	if gen, ok := c.graphGeneration(child); ok {
+		return child.isAncestorByGeneration(c, p, gen)
+	}
 
 	ancestorMap, err := p.AncestorMap(c)
 	if err != nil {
@@ -154,6 +171,36 @@ func (child CommitID) IsAncestor(c *Client, parent Commitish) bool {
 	return ok
 }
 
+// Walks the history of descendant looking for ancestor, using the generation
+// numbers from the commit graph to avoid walking any history older than
+// ancestor. gen is the generation number of ancestor.
+func (ancestor CommitID) isAncestorByGeneration(c *Client, descendant CommitID, gen uint32) bool {
+	seen := make(map[CommitID]struct{})
+	stack := []CommitID{descendant}
+	for len(stack) > 0 {
+		cmt := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if cmt == ancestor {
+			return true
+		}
+		if _, ok := seen[cmt]; ok {
+			continue
+		}
+		seen[cmt] = struct{}{}
+		// Commits which aren't in the graph are newer than it, so
+		// they can't be pruned.
+		if g, ok := c.graphGeneration(cmt); ok && g <= gen {
+			continue
+		}
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return false
+		}
+		stack = append(stack, parents...)
+	}
+	return false
+}
+
 var ancestorMapCache map[CommitID]map[CommitID]struct{}
 
 // AncestorMap returns a map of empty structs (which can be interpreted as a set)
@@ -555,13 +602,21 @@ func (c CommitID) FormatMedium(cl *Client) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	output = output + fmt.Sprintf("Author: %v\nDate:   %v\n\n", author, date.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	authorStr, err := c.logReencode(cl, author.String())
+	if err != nil {
+		return "", err
+	}
+	output = output + fmt.Sprintf("Author: %v\nDate:   %v\n\n", authorStr, date.Format("Mon Jan 2 15:04:05 2006 -0700"))
 
 	msg, err := c.GetCommitMessage(cl)
 	if err != nil {
 		return "", err
 	}
-	lines := strings.Split(strings.TrimSpace(msg.String()), "\n")
+	msgStr, err := c.logReencode(cl, msg.String())
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSpace(msgStr), "\n")
 	for _, l := range lines {
 		output = output + fmt.Sprintf("    %v\n", l)
 	}
@@ -630,84 +685,25 @@ func (t TreeID) GetAllObjectsExcept(cl *Client, excludeList map[Sha1]struct{}, p
 	if excludeList != nil {
 		excludeList[Sha1(t)] = struct{}{}
 	}
-	o, err := cl.GetObject(Sha1(t))
-	if err != nil {
-		return nil, err
-	}
-
-	if o.GetType() != "tree" {
-		return nil, fmt.Errorf("%s is not a tree object", t)
-	}
-
-	treecontent := o.GetContent()
-	entryStart := 0
-	var sha Sha1
 	val := make(map[IndexPath]TreeEntry)
-
-	for i := 0; i < len(treecontent); i++ {
-		// The format of each tree entry is:
-		// 	[permission] [name] \0 [20 bytes of Sha1]
-		// so if we find a \0, it means the next 20 bytes are the sha,
-		// and we need to keep track of the previous entry start to figure
-		// out the name and perm mode.
-		if treecontent[i] == 0 {
-			// Add 1 when converting the value of the sha1 because
-			// because i is currently set to the nil
-			sha, err = Sha1FromSlice(treecontent[i+1 : i+21])
-			if err != nil {
-				return nil, err
-			}
-			if excludeList != nil {
-				if _, ok := excludeList[sha]; ok {
-					i += 20
-					entryStart = i + 1
-					continue
+	err := walkTree(cl, t, "", func(path string, entry TreeEntry) error {
+		if excludeList != nil {
+			if _, ok := excludeList[entry.Sha1]; ok {
+				if entry.FileMode == ModeTree {
+					return SkipTree
 				}
+				return nil
 			}
-
-			// Split up the perm from the name based on the whitespace
-			split := bytes.SplitN(treecontent[entryStart:i], []byte{' '}, 2)
-			perm := split[0]
-			name := split[1]
-
-			var mode EntryMode
-			switch string(perm) {
-			case "40000":
-				mode = ModeTree
-				if recurse {
-					childTree := TreeID(sha)
-					children, err := childTree.GetAllObjectsExcept(cl, excludeList, "", recurse, excludeself)
-					if err != nil {
-						return nil, err
-					}
-					for child, childval := range children {
-						val[IndexPath(name)+"/"+child] = childval
-					}
-				}
-			case "100644":
-				mode = ModeBlob
-			case "100755":
-				mode = ModeExec
-			case "120000":
-				mode = ModeSymlink
-			case "160000":
-				mode = ModeCommit
-			default:
-				panic(fmt.Sprintf("Unsupported mode %v in tree %s", string(perm), t))
-			}
-			val[IndexPath(name)] = TreeEntry{
-				Sha1:     sha,
-				FileMode: mode,
-			}
-			i += 20
-			entryStart = i + 1
-			if excludeList != nil {
-				excludeList[sha] = struct{}{}
-			}
+			excludeList[entry.Sha1] = struct{}{}
 		}
-	}
-	if excludeList != nil {
-		excludeList[Sha1(t)] = struct{}{}
+		val[IndexPath(path)] = entry
+		if entry.FileMode == ModeTree && !recurse {
+			return SkipTree
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return val, nil
 }
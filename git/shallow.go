@@ -0,0 +1,150 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// The depth which git uses to ask for the complete history when
+// unshallowing a repository.
+const infiniteDepth = 0x7fffffff
+
+// Returns the commits at the shallow boundary of c, as listed in
+// .git/shallow. A repository which isn't shallow has none.
+func (c *Client) shallowCommits() ([]CommitID, error) {
+	// The grafts include the shallow commits, but also any from
+	// info/grafts, so the file is read on its own.
+	grafts := make(map[CommitID][]CommitID)
+	if err := c.readGraftFile("shallow", grafts); err != nil {
+		return nil, err
+	}
+	var shallow []CommitID
+	for cmt := range grafts {
+		shallow = append(shallow, cmt)
+	}
+	return shallow, nil
+}
+
+// Returns true if c is a shallow repository.
+func (c *Client) IsShallow() bool {
+	shallow, err := c.shallowCommits()
+	return err == nil && len(shallow) > 0
+}
+
+// Adds the commits in shallow to the shallow boundary of c and removes
+// the ones in unshallow, as sent by the server during a fetch. If nothing
+// is left, .git/shallow is removed and c is no longer shallow.
+func (c *Client) updateShallow(shallow, unshallow []Sha1) error {
+	if len(shallow) == 0 && len(unshallow) == 0 {
+		return nil
+	}
+	cur, err := c.shallowCommits()
+	if err != nil {
+		return err
+	}
+	boundary := make(map[CommitID]struct{})
+	for _, cmt := range cur {
+		boundary[cmt] = struct{}{}
+	}
+	for _, s := range shallow {
+		boundary[CommitID(s)] = struct{}{}
+	}
+	for _, s := range unshallow {
+		delete(boundary, CommitID(s))
+	}
+
+	// The grafts and commit graph depend on the boundary, so they need
+	// to be reloaded.
+	c.objectsMu.Lock()
+	c.grafts = nil
+	c.commitGraph, c.commitGraphLoaded = nil, false
+	c.objectsMu.Unlock()
+
+	if len(boundary) == 0 {
+		if err := os.Remove(c.GitDir.File("shallow").String()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	lines := make([]string, 0, len(boundary))
+	for cmt := range boundary {
+		lines = append(lines, cmt.String())
+	}
+	sort.Strings(lines)
+	return c.GitDir.WriteLocked("shallow", func(w io.Writer) error {
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Returns true if opts asks for the shallow boundary to change.
+func (opts FetchPackOptions) deepening() bool {
+	return opts.Depth > 0 || !opts.ShallowSince.IsZero() || len(opts.ShallowExclude) > 0 || opts.Unshallow
+}
+
+// Writes the lines which tell the server about the shallow boundary of c
+// and how opts wants it changed. They're the same for every protocol
+// version, except that deepen-relative is a capability rather than an
+// argument before version 2.
+func writeShallowRequest(c *Client, w io.Writer, opts FetchPackOptions, version uint8) error {
+	shallow, err := c.shallowCommits()
+	if err != nil {
+		return err
+	}
+	for _, cmt := range shallow {
+		fmt.Fprintf(w, "shallow %v\n", cmt)
+	}
+	depth := opts.Depth
+	if opts.Unshallow {
+		depth = infiniteDepth
+	}
+	if depth > 0 {
+		fmt.Fprintf(w, "deepen %d\n", depth)
+		if opts.DeepenRelative && !opts.Unshallow && version == 2 {
+			fmt.Fprintf(w, "deepen-relative\n")
+		}
+	}
+	if !opts.ShallowSince.IsZero() {
+		fmt.Fprintf(w, "deepen-since %d\n", opts.ShallowSince.Unix())
+	}
+	for _, ref := range opts.ShallowExclude {
+		fmt.Fprintf(w, "deepen-not %v\n", ref)
+	}
+	return nil
+}
+
+// Reads the shallow-update section of a protocol version 1 response, which
+// the server sends after the wants if the fetch was deepening.
+func readShallowUpdate(r io.Reader) (shallow, unshallow []Sha1, err error) {
+	buf := make([]byte, 65536)
+	for {
+		n, err := r.Read(buf)
+		if err == flushPkt {
+			return shallow, unshallow, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		var cmd, id string
+		if _, err := fmt.Sscanf(string(buf[:n]), "%s %s", &cmd, &id); err != nil {
+			return nil, nil, fmt.Errorf("Invalid shallow-update line: %s", buf[:n])
+		}
+		sha, err := Sha1FromString(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch cmd {
+		case "shallow":
+			shallow = append(shallow, sha)
+		case "unshallow":
+			unshallow = append(unshallow, sha)
+		default:
+			return nil, nil, fmt.Errorf("Invalid shallow-update line: %s", buf[:n])
+		}
+	}
+}
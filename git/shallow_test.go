@@ -0,0 +1,96 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Tests that a clone at depth 1 only has the tip commit, and that it can be
+// deepened and unshallowed by fetching.
+func TestShallowClone(t *testing.T) {
+	c, cleanup := testRepo(t, "gitshallow")
+	defer cleanup()
+	first := testCommitFile(t, c, "foo.txt", "one\n", "First commit")
+	second := testCommitFile(t, c, "foo.txt", "two\n", "Second commit")
+	tip := testCommitFile(t, c, "foo.txt", "three\n", "Third commit")
+	src := c.WorkDir.String()
+
+	dir, err := ioutil.TempDir("", "gitshallowdst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst := File(filepath.Join(dir, "shallow.git"))
+	opts := CloneOptions{InitOptions: InitOptions{Quiet: true, Bare: true}}
+	opts.Depth = 1
+	if err := Clone(opts, Remote(src), dst); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := NewClient(dst.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHistory := func(want ...CommitID) {
+		t.Helper()
+		shallow, err := sc.GitDir.ReadFile("shallow")
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		// The shallow boundary is the oldest commit, unless the
+		// whole history is present.
+		if oldest := want[len(want)-1]; oldest == first {
+			if len(shallow) != 0 {
+				t.Errorf("Unexpected shallow file for complete history: %q", shallow)
+			}
+		} else if strings.TrimSpace(string(shallow)) != oldest.String() {
+			t.Errorf("Unexpected shallow file: got %q want %v", shallow, oldest)
+		}
+		for _, cmt := range []CommitID{tip, second, first} {
+			have, _, err := sc.HaveObject(Sha1(cmt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := false
+			for _, w := range want {
+				expected = expected || w == cmt
+			}
+			if have != expected {
+				t.Errorf("Unexpected presence of %v: got %v want %v", cmt, have, expected)
+			}
+		}
+		// History walks stop at the boundary.
+		cmts, err := RevList(sc, RevListOptions{Quiet: true}, nil, []Commitish{tip}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cmts) != len(want) {
+			t.Errorf("Unexpected history: got %v want %v", cmts, want)
+		}
+	}
+	expectHistory(tip)
+
+	if err := Fetch(sc, FetchOptions{FetchPackOptions: FetchPackOptions{Depth: 1, DeepenRelative: true}}, "origin", nil); err != nil {
+		t.Fatal(err)
+	}
+	sc, err = NewClient(dst.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHistory(tip, second)
+
+	if err := Fetch(sc, FetchOptions{FetchPackOptions: FetchPackOptions{Unshallow: true}}, "origin", nil); err != nil {
+		t.Fatal(err)
+	}
+	sc, err = NewClient(dst.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHistory(tip, second, first)
+	if err := Fetch(sc, FetchOptions{FetchPackOptions: FetchPackOptions{Unshallow: true}}, "origin", nil); err == nil {
+		t.Error("Unshallowed a complete repository")
+	}
+}
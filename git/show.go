@@ -2,6 +2,9 @@ package git
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sort"
 	"strings"
 )
 
@@ -30,34 +33,211 @@ func (f FormatString) FormatCommit(c *Client, cmt CommitID) (string, error) {
 type ShowOptions struct {
 	DiffOptions
 	Format FormatString
+
+	// Show the diff of a merge commit against each parent in turn,
+	// (ie. "-m") rather than the default for merges.
+	PerParentDiffs bool
+
+	// Do not show notes attached to commits.
+	NoNotes bool
+
+	// The notes shown with commits, which are read once by show.
+	notes Notes
 }
 
 // Show implementes the "git show" command.
 func Show(c *Client, opts ShowOptions, objects []string) error {
+	return show(c, opts, objects, os.Stdout)
+}
+
+func show(c *Client, opts ShowOptions, objects []string, w io.Writer) error {
 	if len(objects) < 1 {
-		return fmt.Errorf("Provide at least one commit.")
+		return fmt.Errorf("Provide at least one object.")
 	}
 
-	commitIds := []CommitID{}
-
+	var shas []Sha1
 	for _, object := range objects {
-		// Commits only for now
-		commit, err := RevParseCommit(c, &RevParseOptions{}, object)
+		revs, err := RevParse(c, RevParseOptions{}, []string{object})
+		if err != nil {
+			return err
+		}
+		if len(revs) != 1 {
+			return fmt.Errorf("Could not resolve %v", object)
+		}
+		shas = append(shas, revs[0].Id)
+	}
+
+	if !opts.NoNotes {
+		notes, err := ReadNotes(c, NotesOptions{})
 		if err != nil {
 			return err
 		}
+		opts.notes = notes
+	}
+	for i, sha := range shas {
+		if err := showObject(c, opts, objects[i], sha, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		commitIds = append(commitIds, commit)
+// Shows a single object, dispatching based on the type of the object. name
+// is the name that the user used to refer to the object, which is used as the
+// header when showing trees.
+func showObject(c *Client, opts ShowOptions, name string, sha Sha1, w io.Writer) error {
+	obj, err := c.GetObject(sha)
+	if err != nil {
+		return err
+	}
+	switch obj.GetType() {
+	case "commit":
+		return showCommit(c, opts, CommitID(sha), w)
+	case "tag":
+		target, err := showTagHeader(obj.GetContent(), w)
+		if err != nil {
+			return err
+		}
+		return showObject(c, opts, target.String(), target, w)
+	case "tree":
+		return showTree(c, name, TreeID(sha), w)
+	case "blob":
+		_, err := w.Write(obj.GetContent())
+		return err
+	default:
+		return fmt.Errorf("Unknown object type %v", obj.GetType())
 	}
+}
 
-	for _, commit := range commitIds {
-		output, err := opts.Format.FormatCommit(c, commit)
+func showCommit(c *Client, opts ShowOptions, cmt CommitID, w io.Writer) error {
+	output, err := opts.Format.FormatCommit(c, cmt)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%v", output)
+	if !opts.NoNotes {
+		notes, err := cmt.FormatNotes(c, opts.notes)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("%v", output)
+		fmt.Fprintf(w, "%v", notes)
+	}
+	if !opts.Patch && !opts.Raw {
+		return nil
 	}
 
+	parents, err := cmt.Parents(c)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(parents) == 0:
+		diffs, err := diffRootCommit(c, cmt)
+		if err != nil {
+			return err
+		}
+		return GeneratePatch(c, opts.DiffCommonOptions, diffs, w)
+	case len(parents) == 1 || opts.PerParentDiffs:
+		for _, p := range parents {
+			diffs, err := diffCommits(c, p, cmt)
+			if err != nil {
+				return err
+			}
+			if len(parents) > 1 {
+				fmt.Fprintf(w, "(from %v)\n", p)
+			}
+			if err := GeneratePatch(c, opts.DiffCommonOptions, diffs, w); err != nil {
+				return err
+			}
+		}
+	default:
+		hunks, err := DiffTreeCombined(c, cmt)
+		if err != nil {
+			return err
+		}
+		return WriteCombinedDiff(hunks, w)
+	}
+	return nil
+}
+
+// Returns the files which differ between the commits old and new, excluding
+// the subtrees themselves. A nil old is the empty tree.
+func diffCommits(c *Client, old Treeish, new CommitID) ([]HashDiff, error) {
+	diffs, err := DiffTree(c, &DiffTreeOptions{Recurse: true}, old, new, nil)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]HashDiff, 0, len(diffs))
+	for _, d := range diffs {
+		if d.Src.FileMode == ModeTree || d.Dst.FileMode == ModeTree {
+			continue
+		}
+		files = append(files, d)
+	}
+	return files, nil
+}
+
+// Returns every file in a root commit as an added file, which is what
+// diffing against the empty tree would produce.
+func diffRootCommit(c *Client, cmt CommitID) ([]HashDiff, error) {
+	tree, err := cmt.TreeID(c)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := tree.GetAllObjects(c, "", true, true)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []HashDiff
+	for name, entry := range entries {
+		if entry.FileMode == ModeTree {
+			continue
+		}
+		diffs = append(diffs, HashDiff{Name: name, Dst: entry})
+	}
+	sort.Sort(ByName(diffs))
+	return diffs, nil
+}
+
+// Prints the header of the tag object with the raw content
+// content in the same format as "git show", and returns
+// the object that the tag points to.
+func showTagHeader(content []byte, w io.Writer) (Sha1, error) {
+	tag, err := parseTag(content)
+	if err != nil {
+		return Sha1{}, err
+	}
+
+	fmt.Fprintf(w, "tag %v\n", tag.Name)
+	if tag.Tagger.Time != nil {
+		fmt.Fprintf(w, "Tagger: %v <%v>\nDate:   %v\n", tag.Tagger.Name, tag.Tagger.Email, tag.Tagger.Time.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	}
+	fmt.Fprintf(w, "\n%s%s\n", tag.Message, tag.Signature)
+	return tag.Object, nil
+}
+
+// Shows a tree in the same format as "git show", which is a header
+// followed by the names of the entries, with trees suffixed by
+// a "/"
+func showTree(c *Client, name string, tree TreeID, w io.Writer) error {
+	entries, err := tree.GetAllObjects(c, "", false, false)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for path, entry := range entries {
+		if entry.FileMode == ModeTree {
+			names = append(names, path.String()+"/")
+		} else {
+			names = append(names, path.String())
+		}
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "tree %v\n\n", name)
+	for _, n := range names {
+		fmt.Fprintf(w, "%v\n", n)
+	}
+	fmt.Fprintf(w, "\n")
 	return nil
 }
 
@@ -76,12 +256,20 @@ func formatCommitMedium(cmt CommitID, c *Client) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	authorStr, err := cmt.logReencode(c, author.String())
+	if err != nil {
+		return "", err
+	}
+	msgStr, err := cmt.logReencode(c, msg.String())
+	if err != nil {
+		return "", err
+	}
 
 	// Headers
-	output := fmt.Sprintf("commit %v\nAuthor: %s\nDate: %v\n\n", cmt, author, date.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	output := fmt.Sprintf("commit %v\nAuthor: %s\nDate: %v\n\n", cmt, authorStr, date.Format("Mon Jan 2 15:04:05 2006 -0700"))
 
 	// Commit message body
-	lines := strings.Split(strings.TrimSpace(msg.String()), "\n")
+	lines := strings.Split(strings.TrimSpace(msgStr), "\n")
 	for _, l := range lines {
 		output = fmt.Sprintf("%v    %v\n", output, l)
 	}
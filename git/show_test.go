@@ -0,0 +1,43 @@
+package git
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestShowCommitAndBlob(t *testing.T) {
+	c, cleanup := testRepo(t, "gitshow")
+	defer cleanup()
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	cid := testCommitFile(t, c, "foo.txt", "bar\n", "Changed foo to bar")
+
+	var out bytes.Buffer
+	opts := ShowOptions{}
+	opts.Patch = true
+	opts.NumContextLines = 3
+	if err := show(c, opts, []string{"HEAD"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	s := out.String()
+	for _, want := range []string{
+		"commit " + cid.String() + "\n",
+		"    Changed foo to bar\n",
+		"diff --git a/foo.txt b/foo.txt\n",
+		"-foo\n",
+		"+bar\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Show commit: output does not contain %q. Got %v", want, s)
+		}
+	}
+
+	out.Reset()
+	if err := show(c, opts, []string{"HEAD:foo.txt"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "bar\n" {
+		t.Errorf("Show blob: got %q want %q", got, "bar\n")
+	}
+}
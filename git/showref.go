@@ -298,11 +298,11 @@ func getDeref(c *Client, opts ShowRefOptions, ref Ref) (*Ref, error) {
 		return nil, nil
 	}
 	if ref.Value.Type(c) == "tag" {
-		deref, err := RevParse(c, RevParseOptions{}, []string{ref.Name + "^0"})
+		deref, err := Peel(c, ref.Value)
 		if err != nil {
 			return nil, err
 		}
-		return &Ref{ref.Name + "^{}", deref[0].Id}, nil
+		return &Ref{ref.Name + "^{}", deref}, nil
 	}
 	return nil, nil
 }
@@ -0,0 +1,275 @@
+package git
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A sparse index stores a directory outside of the sparse checkout cone as
+// a single entry, instead of an entry for every file in it. The entry has
+// the name of the directory with a trailing slash, the mode of a tree, the
+// tree of the directory as its Sha1 and the skip-worktree bit set. An index
+// with such entries has the "sdir" extension, so that versions of git which
+// don't know about them refuse to read it.
+
+// Returns true if ie is a sparse directory entry.
+func (ie IndexEntry) IsSparseDir() bool {
+	return ie.Mode == ModeTree && strings.HasSuffix(ie.PathName.String(), "/")
+}
+
+// Returns true if the index is a sparse index, which may contain sparse
+// directory entries.
+func (g *Index) IsSparse() bool {
+	return g.sparse
+}
+
+// Returns a sparse directory entry for the directory dir, which must have a
+// trailing slash, with the tree t.
+func newSparseDirEntry(dir IndexPath, t TreeID) *IndexEntry {
+	entry := &IndexEntry{PathName: dir}
+	entry.Mode = ModeTree
+	entry.Sha1 = Sha1(t)
+	entry.FixedIndexEntry.Flags = nameLengthFlags(dir)
+	entry.SetSkipWorktree(true)
+	return entry
+}
+
+// EnsureFull replaces every sparse directory entry in the index with the
+// entries for the files in its tree, which keep the skip-worktree bit, so
+// that the index is a full index. This is the same as git's
+// ensure_full_index, for operations which need every file.
+func (g *Index) EnsureFull(c *Client) error {
+	if !g.sparse {
+		return nil
+	}
+	var objects []*IndexEntry
+	for _, entry := range g.Objects {
+		if !entry.IsSparseDir() {
+			objects = append(objects, entry)
+			continue
+		}
+		files, err := entry.sparseFiles(c)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, files...)
+	}
+	g.replaceSparseObjects(objects)
+	g.sparse = false
+
+	// The sparse directories were leaves of the cache-tree, which
+	// doesn't describe the expanded index.
+	g.cacheTree = nil
+	return nil
+}
+
+// ExpandToPath expands the sparse directory entries which contain path, one
+// directory at a time, so that path can be looked up or added to the index
+// without expanding the rest of it. The entries of the subdirectories that
+// path isn't in stay collapsed.
+func (g *Index) ExpandToPath(c *Client, path IndexPath) error {
+	if !g.sparse {
+		return nil
+	}
+	for {
+		i := -1
+		for j, entry := range g.Objects {
+			if entry.IsSparseDir() && strings.HasPrefix(path.String(), entry.PathName.String()) {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return nil
+		}
+		dir := g.Objects[i]
+		entries, err := TreeID(dir.Sha1).entries(c)
+		if err != nil {
+			return err
+		}
+		var expanded []*IndexEntry
+		for _, e := range entries {
+			name := dir.PathName + IndexPath(e.Name)
+			if e.FileMode == ModeTree {
+				expanded = append(expanded, newSparseDirEntry(name+"/", TreeID(e.Sha1)))
+				continue
+			}
+			file := &IndexEntry{PathName: name}
+			file.Mode = e.FileMode
+			file.Sha1 = e.Sha1
+			if e.FileMode != ModeGitlink {
+				obj, err := c.GetObject(e.Sha1)
+				if err != nil {
+					return err
+				}
+				file.Fsize = uint32(obj.GetSize())
+			}
+			file.FixedIndexEntry.Flags = nameLengthFlags(name)
+			file.SetSkipWorktree(true)
+			expanded = append(expanded, file)
+		}
+		g.invalidateCacheTree(dir.PathName)
+		objects := append([]*IndexEntry(nil), g.Objects[:i]...)
+		objects = append(objects, expanded...)
+		g.replaceSparseObjects(append(objects, g.Objects[i+1:]...))
+	}
+}
+
+// ConvertToSparse collapses every directory whose entries are all merged and
+// have the skip-worktree bit set, which means that it's outside of the
+// sparse checkout cone, into a sparse directory entry. The trees of the
+// directories are written if they don't exist.
+//
+// As in git, the index is only made sparse if index.sparse and
+// core.sparseCheckoutCone are both true.
+func (g *Index) ConvertToSparse(c *Client) error {
+	if c.GetConfig("index.sparse") != "true" || c.GetConfig("core.sparseCheckoutCone") != "true" {
+		return nil
+	}
+
+	// Find whether each directory can be collapsed. A directory can't be
+	// if any entry in it or its subdirectories can't.
+	collapsible := make(map[IndexPath]bool)
+	for _, entry := range g.Objects {
+		canCollapse := entry.Stage() == Stage0 && entry.SkipWorktree() && entry.Mode != ModeGitlink
+		name := strings.TrimSuffix(entry.PathName.String(), "/")
+		for slash := strings.IndexByte(name, '/'); slash >= 0; {
+			dir := IndexPath(name[:slash+1])
+			if ok, seen := collapsible[dir]; !seen || ok {
+				collapsible[dir] = canCollapse
+			}
+			next := strings.IndexByte(name[slash+1:], '/')
+			if next < 0 {
+				break
+			}
+			slash += next + 1
+		}
+	}
+
+	var objects []*IndexEntry
+	changed := false
+	for i := 0; i < len(g.Objects); {
+		entry := g.Objects[i]
+
+		// Find the outermost directory of entry which can be
+		// collapsed.
+		var dir IndexPath
+		name := entry.PathName.String()
+		for slash := strings.IndexByte(name, '/'); slash >= 0 && slash < len(name)-1; {
+			if collapsible[IndexPath(name[:slash+1])] {
+				dir = IndexPath(name[:slash+1])
+				break
+			}
+			next := strings.IndexByte(name[slash+1:], '/')
+			if next < 0 {
+				break
+			}
+			slash += next + 1
+		}
+		if dir == "" {
+			objects = append(objects, entry)
+			i++
+			continue
+		}
+
+		// The entries of the directory are contiguous, since the
+		// index is sorted.
+		j := i + 1
+		for j < len(g.Objects) && strings.HasPrefix(g.Objects[j].PathName.String(), dir.String()) {
+			j++
+		}
+		tree, err := writeTree(c, strings.TrimSuffix(dir.String(), "/"), g.Objects[i:j])
+		if err != nil {
+			return err
+		}
+		objects = append(objects, newSparseDirEntry(dir, tree))
+		i = j
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	g.replaceSparseObjects(objects)
+	g.sparse = true
+	g.cacheTree = nil
+	if g.Version < 3 {
+		g.Version = 3
+	}
+	return nil
+}
+
+// Replaces the entries of the index with objects, which are sorted again,
+// after sparse directories were expanded or collapsed.
+func (g *Index) replaceSparseObjects(objects []*IndexEntry) {
+	sort.Sort(ByPath(objects))
+	g.Objects = objects
+	g.NumberIndexEntries = uint32(len(objects))
+	g.untracked = nil
+	g.fsmonitor = nil
+}
+
+// Returns the entries of the sparse directory entry dir, expanded into the
+// files in its tree, with the skip-worktree bit set.
+func (dir IndexEntry) sparseFiles(c *Client) ([]*IndexEntry, error) {
+	if !dir.IsSparseDir() {
+		return nil, fmt.Errorf("%v is not a sparse directory", dir.PathName)
+	}
+	files, err := expandGitTreeIntoIndexes(c, TreeID(dir.Sha1), true, false, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		file.PathName = dir.PathName + file.PathName
+		file.FixedIndexEntry.Flags = nameLengthFlags(file.PathName)
+		// The mtime was for a path relative to the directory, and the
+		// files aren't in the work tree anyway.
+		file.Mtime = 0
+		file.SetSkipWorktree(true)
+	}
+	return files, nil
+}
+
+// Replaces the sparse directory entries in entries with the files in them,
+// so that they can be compared to the files in the tree t one at a time.
+// The directories which are the same in t are left out, since none of their
+// files are different. If t is nil, every directory is expanded.
+func expandSparseDirs(c *Client, entries []*IndexEntry, t Treeish) ([]*IndexEntry, error) {
+	var root TreeID
+	if t != nil {
+		var err error
+		if root, err = t.TreeID(c); err != nil {
+			return nil, err
+		}
+	}
+	var expanded []*IndexEntry
+	for _, entry := range entries {
+		if !entry.IsSparseDir() {
+			expanded = append(expanded, entry)
+			continue
+		}
+		if t != nil {
+			dir := IndexPath(strings.TrimSuffix(entry.PathName.String(), "/"))
+			if te, ok, err := root.lookupPath(c, dir); err != nil {
+				return nil, err
+			} else if ok && te.Sha1 == entry.Sha1 {
+				continue
+			}
+		}
+		files, err := entry.sparseFiles(c)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, files...)
+	}
+	return expanded, nil
+}
+
+// Returns the flags of a merged index entry for a path called name, which
+// only have the length of the name.
+func nameLengthFlags(name IndexPath) uint16 {
+	if len(name) >= 0xFFF {
+		return 0xFFF
+	}
+	return uint16(len(name))
+}
@@ -0,0 +1,132 @@
+package git
+
+import (
+	"testing"
+)
+
+// Tests that directories outside of the sparse checkout cone are collapsed
+// into sparse directory entries, which are expanded again when a path in
+// them is needed.
+func TestSparseIndex(t *testing.T) {
+	c, cleanup := testRepo(t, "gitsparseindex")
+	defer cleanup()
+
+	testCommitFile(t, c, "a/f", "f\n", "Add a/f")
+	testCommitFile(t, c, "out/g", "g\n", "Add out/g")
+	testCommitFile(t, c, "out/deep/h", "h\n", "Add out/deep/h")
+	head := testCommitFile(t, c, "root.txt", "root\n", "Add root.txt")
+	c.SetCachedConfig("index.sparse", "true")
+	c.SetCachedConfig("core.sparseCheckoutCone", "true")
+
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []IndexPath{"out/g", "out/deep/h"} {
+		if err := idx.SetSkipWorktree(c, path, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idx.ConvertToSparse(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GitDir.WriteLocked("index", idx.WriteIndex); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sparse directory survives writing and reading the index.
+	idx, err = c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !idx.IsSparse() {
+		t.Fatal("Index is not sparse after being read again")
+	}
+	paths := func() []IndexPath {
+		var paths []IndexPath
+		for _, entry := range idx.Objects {
+			paths = append(paths, entry.PathName)
+		}
+		return paths
+	}
+	if got, want := paths(), []IndexPath{"a/f", "out/", "root.txt"}; !equalIndexPaths(got, want) {
+		t.Fatalf("Unexpected sparse index: got %v want %v", got, want)
+	}
+	if dir := idx.Objects[1]; !dir.IsSparseDir() || !dir.SkipWorktree() {
+		t.Errorf("out/ is not a sparse directory entry: mode %v", dir.Mode)
+	}
+
+	// The tree and the diff against HEAD don't change.
+	if got, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{}); err != nil {
+		t.Fatal(err)
+	} else if got != tree {
+		t.Errorf("Unexpected tree for sparse index: got %v want %v", got, tree)
+	}
+	diffs, err := DiffIndex(c, DiffIndexOptions{Cached: true}, idx, head, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Unexpected diff between sparse index and HEAD: %v", diffs)
+	}
+
+	// Only the directories containing the path are expanded.
+	if err := idx.ExpandToPath(c, "out/g"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := paths(), []IndexPath{"a/f", "out/deep/", "out/g", "root.txt"}; !equalIndexPaths(got, want) {
+		t.Fatalf("Unexpected index after expanding out/g: got %v want %v", got, want)
+	}
+	if g := idx.Objects[2]; g.Sha1 != hashString("g\n") || !g.SkipWorktree() || g.Fsize != 2 {
+		t.Errorf("Unexpected entry for out/g: %v %v %v", g.Sha1, g.SkipWorktree(), g.Fsize)
+	}
+
+	// Adding a file in a collapsed directory expands it.
+	if err := idx.AddStage(c, "out/deep/i", ModeBlob, hashString("h\n"), Stage0, 2, 0, UpdateIndexOptions{Add: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := paths(), []IndexPath{"a/f", "out/deep/h", "out/deep/i", "out/g", "root.txt"}; !equalIndexPaths(got, want) {
+		t.Fatalf("Unexpected index after adding out/deep/i: got %v want %v", got, want)
+	}
+
+	// A full index has every file, with the skip-worktree bit still set.
+	if err := idx.SetSkipWorktree(c, "out/deep/i", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.ConvertToSparse(c); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := paths(), []IndexPath{"a/f", "out/", "root.txt"}; !equalIndexPaths(got, want) {
+		t.Fatalf("Unexpected index after collapsing it again: got %v want %v", got, want)
+	}
+	if err := idx.EnsureFull(c); err != nil {
+		t.Fatal(err)
+	}
+	if idx.IsSparse() {
+		t.Error("Index is still sparse after EnsureFull")
+	}
+	if got, want := paths(), []IndexPath{"a/f", "out/deep/h", "out/deep/i", "out/g", "root.txt"}; !equalIndexPaths(got, want) {
+		t.Fatalf("Unexpected full index: got %v want %v", got, want)
+	}
+	for _, entry := range idx.Objects[1:4] {
+		if !entry.SkipWorktree() {
+			t.Errorf("%v lost its skip-worktree bit", entry.PathName)
+		}
+	}
+}
+
+func equalIndexPaths(a, b []IndexPath) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
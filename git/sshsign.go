@@ -0,0 +1,139 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Writes data to a new temporary file and returns its name. The caller
+// is responsible for removing it.
+func writeTempFile(prefix string, data []byte) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Signs payload with ssh-keygen. key is either the path to a private key
+// file, or a literal public key (optionally prefixed by "key::") whose
+// private key is in the ssh-agent.
+func sshSignBuffer(c *Client, key GPGKeyId, payload []byte) ([]byte, error) {
+	args := []string{"-Y", "sign", "-n", "git", "-f"}
+	keystr := string(key)
+	if strings.HasPrefix(keystr, "key::") || strings.HasPrefix(keystr, "ssh-") {
+		keyfile, err := writeTempFile(".git_signing_key_tmp", []byte(strings.TrimPrefix(keystr, "key::")+"\n"))
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(keyfile)
+		args = append(args, keyfile, "-U")
+	} else {
+		if strings.HasPrefix(keystr, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			keystr = filepath.Join(home, keystr[2:])
+		}
+		args = append(args, keystr)
+	}
+
+	bufferfile, err := writeTempFile(".git_signing_buffer_tmp", payload)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(bufferfile)
+	defer os.Remove(bufferfile + ".sig")
+	args = append(args, bufferfile)
+
+	var output bytes.Buffer
+	cmd := exec.Command(gpgProgram(c, "ssh"), args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ssh-keygen failed to sign the data: %v\n%s", err, output.Bytes())
+	}
+	sig, err := ioutil.ReadFile(bufferfile + ".sig")
+	if err != nil {
+		return nil, fmt.Errorf("ssh-keygen failed to sign the data: %v", err)
+	}
+	if len(sig) > 0 && sig[len(sig)-1] != '\n' {
+		sig = append(sig, '\n')
+	}
+	return sig, nil
+}
+
+// Verifies the SSH signature sig of payload against the principals in the
+// gpg.ssh.allowedSignersFile config.
+func sshVerifySignature(c *Client, payload, sig []byte) (Signature, error) {
+	allowed := c.GetConfig("gpg.ssh.allowedSignersFile")
+	if allowed == "" {
+		return Signature{Status: 'E'}, fmt.Errorf("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+	}
+	sigfile, err := writeTempFile(".git_vtag_tmp", sig)
+	if err != nil {
+		return Signature{}, err
+	}
+	defer os.Remove(sigfile)
+	program := gpgProgram(c, "ssh")
+
+	var principals, output bytes.Buffer
+	find := exec.Command(program, "-Y", "find-principals", "-f", allowed, "-s", sigfile)
+	find.Stdout = &principals
+	find.Stderr = &output
+	if err := find.Run(); err != nil || strings.TrimSpace(principals.String()) == "" {
+		// The signer isn't trusted, but the signature might still be
+		// valid.
+		output.Reset()
+		check := exec.Command(program, "-Y", "check-novalidate", "-n", "git", "-s", sigfile)
+		check.Stdin = bytes.NewReader(payload)
+		check.Stdout = &output
+		check.Stderr = &output
+		result := Signature{Status: 'U'}
+		if err := check.Run(); err != nil {
+			result.Status = 'B'
+		}
+		result.Output = output.String() + "No principal matched.\n"
+		return result, nil
+	}
+
+	revocation := c.GetConfig("gpg.ssh.revocationFile")
+	for _, principal := range strings.Split(strings.TrimSpace(principals.String()), "\n") {
+		output.Reset()
+		args := []string{"-Y", "verify", "-n", "git", "-f", allowed, "-I", principal, "-s", sigfile}
+		if revocation != "" {
+			args = append(args, "-r", revocation)
+		}
+		verify := exec.Command(program, args...)
+		verify.Stdin = bytes.NewReader(payload)
+		verify.Stdout = &output
+		verify.Stderr = &output
+		if err := verify.Run(); err != nil {
+			continue
+		}
+		result := Signature{Status: 'G', Signer: principal, Output: output.String()}
+		// The output is of the form:
+		// Good "git" signature for <principal> with <type> key <fingerprint>
+		if idx := strings.LastIndex(output.String(), " key "); idx >= 0 {
+			result.Fingerprint = strings.TrimSpace(output.String()[idx+len(" key "):])
+			result.Key = result.Fingerprint
+		}
+		return result, nil
+	}
+	return Signature{Status: 'B', Output: output.String()}, nil
+}
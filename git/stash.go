@@ -0,0 +1,509 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StashPushOptions are the options which may be passed to "git stash push".
+type StashPushOptions struct {
+	// The message to describe the stash with. If empty, the message says
+	// which commit the stash is based on.
+	Message string
+
+	// Put the changes which were staged back into the index and the work
+	// tree once they're saved, so that what's staged can be tested on its
+	// own.
+	KeepIndex bool
+
+	// Also save the untracked files that aren't ignored, in a third
+	// parent of the stash commit, and remove them from the work tree.
+	IncludeUntracked bool
+}
+
+// StashApplyOptions are the options which may be passed to
+// "git stash apply" and "git stash pop".
+type StashApplyOptions struct {
+	// Restore the changes which were staged when the stash was created
+	// to the index, rather than only restoring them to the work tree.
+	Index bool
+}
+
+// StashShowOptions are the options which may be passed to "git stash show".
+type StashShowOptions struct {
+	// Show the changes in the stash as a patch rather than a diffstat.
+	Patch bool
+}
+
+// A StashEntry is a single stash in the stash list.
+type StashEntry struct {
+	// The name of the stash, in the form stash@{n}.
+	Name string
+
+	// The stash commit. Its first parent is the commit that the stash
+	// was based on, and its second parent is a commit of the index when
+	// the stash was created. If it has a third parent, that's a commit
+	// of the untracked files.
+	Commit CommitID
+
+	Message string
+}
+
+// Stashes are stored as the reflog of this ref, with the most recent stash
+// as its value.
+const stashRef = "refs/stash"
+
+// Returns the message that stash commits based on head are described
+// with, starting from the name of the branch.
+func stashBaseMessage(c *Client, head CommitID) (string, error) {
+	branch := c.GetHeadBranch().BranchName()
+	if branch == "" {
+		branch = "(no branch)"
+	}
+	msg, err := head.GetCommitMessage(c)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%v: %v %v", branch, head.String()[:7], msg.Subject()), nil
+}
+
+// StashPush saves the changes in the index and work tree to a new stash,
+// and then resets them to HEAD. Untracked files are not stashed unless
+// opts.IncludeUntracked is set. If there are no changes to save, no stash
+// is created and the zero CommitID is returned.
+//
+// With opts.KeepIndex, the index and work tree are reset to the staged
+// changes rather than to HEAD. The untracked files are still removed.
+func StashPush(c *Client, opts StashPushOptions) (CommitID, error) {
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return CommitID{}, fmt.Errorf("You do not have the initial commit yet")
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return CommitID{}, err
+	}
+	if len(idx.GetUnmerged()) > 0 {
+		return CommitID{}, fmt.Errorf("Cannot save the current state while there are unmerged paths")
+	}
+
+	staged, err := DiffIndex(c, DiffIndexOptions{Cached: true}, idx, head, nil)
+	if err != nil {
+		return CommitID{}, err
+	}
+	unstaged, err := DiffFiles(c, DiffFilesOptions{}, nil)
+	if err != nil {
+		return CommitID{}, err
+	}
+	var untracked []File
+	if opts.IncludeUntracked {
+		others, err := LsFiles(c, LsFilesOptions{Others: true, ExcludeStandard: true}, nil)
+		if err != nil {
+			return CommitID{}, err
+		}
+		for _, entry := range others {
+			f, err := entry.PathName.FilePath(c)
+			if err != nil {
+				return CommitID{}, err
+			}
+			untracked = append(untracked, f)
+		}
+	}
+	if len(staged) == 0 && len(unstaged) == 0 && len(untracked) == 0 {
+		return CommitID{}, nil
+	}
+
+	base, err := stashBaseMessage(c, head)
+	if err != nil {
+		return CommitID{}, err
+	}
+	itree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return CommitID{}, err
+	}
+	icmt, err := CommitTree(c, CommitTreeOptions{}, itree, []CommitID{head}, "index on "+base+"\n")
+	if err != nil && err != NoGlobalConfig {
+		return CommitID{}, err
+	}
+
+	parents := []CommitID{head, icmt}
+	if len(untracked) > 0 {
+		// The untracked files are saved in a commit of their own with
+		// no parents.
+		uidx := NewIndex()
+		for _, f := range untracked {
+			if err := uidx.AddFile(c, f, UpdateIndexOptions{Add: true}); err != nil {
+				return CommitID{}, err
+			}
+		}
+		utree, err := WriteTreeFromIndex(c, uidx, WriteTreeOptions{})
+		if err != nil {
+			return CommitID{}, err
+		}
+		ucmt, err := CommitTree(c, CommitTreeOptions{}, utree, nil, "untracked files on "+base+"\n")
+		if err != nil && err != NoGlobalConfig {
+			return CommitID{}, err
+		}
+		parents = append(parents, ucmt)
+	}
+
+	// The work tree is saved by updating a copy of the index with the
+	// tracked files which changed.
+	var files []File
+	for _, d := range unstaged {
+		f, err := d.Name.FilePath(c)
+		if err != nil {
+			return CommitID{}, err
+		}
+		files = append(files, f)
+	}
+	if _, err := UpdateIndex(c, idx, UpdateIndexOptions{Remove: true}, files); err != nil {
+		return CommitID{}, err
+	}
+	wtree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return CommitID{}, err
+	}
+	msg := "WIP on " + base
+	if opts.Message != "" {
+		msg = fmt.Sprintf("On %v: %v", strings.SplitN(base, ":", 2)[0], opts.Message)
+	}
+	wcmt, err := CommitTree(c, CommitTreeOptions{}, wtree, parents, msg+"\n")
+	if err != nil && err != NoGlobalConfig {
+		return CommitID{}, err
+	}
+	// The previous stash is passed as the old value so that it's in the
+	// reflog, which is the stash list.
+	var prev CommitID
+	if ref := RefSpec(stashRef); ref.File(c).Exists() {
+		if prev, err = ref.CommitID(c); err != nil {
+			return CommitID{}, err
+		}
+	}
+	if err := UpdateRefSpec(c, UpdateRefOptions{OldValue: prev, CreateReflog: true}, stashRef, wcmt, msg); err != nil {
+		return CommitID{}, err
+	}
+
+	// Now that it's saved, reset the index and work tree without
+	// moving HEAD.
+	if len(untracked) > 0 {
+		if _, err := Clean(c, CleanOptions{Directory: true, Quiet: true}, nil); err != nil {
+			return CommitID{}, err
+		}
+	}
+	var target Treeish = head
+	if opts.KeepIndex {
+		target = itree
+	}
+	if err := resetToTree(c, target); err != nil {
+		return CommitID{}, err
+	}
+	return wcmt, nil
+}
+
+// StashList returns the stashes, most recent first.
+func StashList(c *Client) ([]StashEntry, error) {
+	entries, err := readReflog(c, stashRef)
+	if err != nil {
+		return nil, err
+	}
+	stashes := make([]StashEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		stashes = append(stashes, StashEntry{
+			Name:    fmt.Sprintf("stash@{%d}", len(stashes)),
+			Commit:  entries[i].New,
+			Message: entries[i].Message,
+		})
+	}
+	return stashes, nil
+}
+
+// Returns the index of the stash named by name in the stash list. The
+// name may be of the form "stash@{n}" or just "n". An empty name refers
+// to the most recent stash.
+func stashIndex(c *Client, name string) (int, []StashEntry, error) {
+	stashes, err := StashList(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(stashes) == 0 {
+		return 0, nil, fmt.Errorf("No stash entries found.")
+	}
+	if name == "" {
+		return 0, stashes, nil
+	}
+	n := strings.TrimPrefix(name, "refs/")
+	switch {
+	case n == "stash":
+		return 0, stashes, nil
+	case strings.HasPrefix(n, "stash@{") && strings.HasSuffix(n, "}"):
+		n = n[len("stash@{") : len(n)-1]
+	}
+	i, err := strconv.Atoi(n)
+	if err != nil || i < 0 || i >= len(stashes) {
+		return 0, nil, fmt.Errorf("%v is not a valid reference", name)
+	}
+	return i, stashes, nil
+}
+
+// Returns the stash named by name, and the commits that it's based on
+// and that the index was saved in.
+func stashCommits(c *Client, name string) (stash StashEntry, base, index CommitID, err error) {
+	i, stashes, err := stashIndex(c, name)
+	if err != nil {
+		return StashEntry{}, CommitID{}, CommitID{}, err
+	}
+	stash = stashes[i]
+	parents, err := stash.Commit.Parents(c)
+	if err != nil {
+		return StashEntry{}, CommitID{}, CommitID{}, err
+	}
+	if len(parents) < 2 {
+		return StashEntry{}, CommitID{}, CommitID{}, fmt.Errorf("%v is not a stash-like commit", stash.Commit)
+	}
+	return stash, parents[0], parents[1], nil
+}
+
+// StashShow shows the changes recorded in a stash, relative to the commit
+// that it was based on, as a diffstat or patch.
+func StashShow(c *Client, opts StashShowOptions, stash string) error {
+	return stashShow(c, opts, stash, os.Stdout)
+}
+
+func stashShow(c *Client, opts StashShowOptions, stash string, w io.Writer) error {
+	s, base, _, err := stashCommits(c, stash)
+	if err != nil {
+		return err
+	}
+	diffs, err := diffCommits(c, base, s.Commit)
+	if err != nil {
+		return err
+	}
+	if opts.Patch {
+		return GeneratePatch(c, DiffCommonOptions{Patch: true, NumContextLines: 3}, diffs, w)
+	}
+	return writeDiffStat(c, diffs, w)
+}
+
+// StashApply applies the changes in a stash on top of the index and work
+// tree, using a three-way merge with the commit that the stash was based
+// on. The stash is not removed from the stash list.
+//
+// Unless opts.Index is set, changes which were staged when the stash was
+// created are only restored to the work tree. New files are always added
+// to the index. Untracked files which were saved in the stash are restored
+// to the work tree only, and it's an error if any of them already exist.
+func StashApply(c *Client, opts StashApplyOptions, stash string) error {
+	s, base, index, err := stashCommits(c, stash)
+	if err != nil {
+		return err
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return err
+	}
+	if len(idx.GetUnmerged()) > 0 {
+		return fmt.Errorf("Cannot apply a stash in the middle of a merge")
+	}
+
+	btree, err := base.TreeID(c)
+	if err != nil {
+		return err
+	}
+	itree, err := index.TreeID(c)
+	if err != nil {
+		return err
+	}
+	wtree, err := s.Commit.TreeID(c)
+	if err != nil {
+		return err
+	}
+	ctree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return err
+	}
+
+	if itree == btree || itree == ctree {
+		// Nothing was staged that isn't already, so the index only
+		// needs the same update as it gets without opts.Index.
+		opts.Index = false
+	}
+	var indextree TreeID
+	if opts.Index {
+		// Merge the staged changes into the index first, so that
+		// nothing changes if they can't be.
+		iidx, err := ReadTreeThreeWay(c, ReadTreeOptions{Merge: true, DryRun: true}, btree, ctree, itree)
+		if err != nil {
+			return err
+		}
+		if len(iidx.GetUnmerged()) > 0 {
+			return fmt.Errorf("Conflicts in index. Try without --index.")
+		}
+		if indextree, err = WriteTreeFromIndex(c, iidx, WriteTreeOptions{}); err != nil {
+			return err
+		}
+	}
+
+	if err := stashRestoreUntracked(c, s); err != nil {
+		return err
+	}
+
+	midx, err := ReadTreeThreeWay(c, ReadTreeOptions{Merge: true, Update: true}, btree, ctree, wtree)
+	if err != nil {
+		return err
+	}
+	if len(midx.GetUnmerged()) > 0 {
+		conflicted, err := mergeUnmergedFiles(c, midx, MergeFileOptions{
+			CurrentLabel: "Updated upstream",
+			BaseLabel:    "Stash base",
+			OtherLabel:   "Stashed changes",
+		})
+		if err != nil {
+			return err
+		}
+		if err := stageCleanMerges(c, midx, conflicted); err != nil {
+			return err
+		}
+		if len(conflicted) > 0 {
+			// Leave the conflicts in the index for the user to
+			// resolve.
+			if err := c.GitDir.WriteLocked("index", midx.WriteIndex); err != nil {
+				return err
+			}
+			return fmt.Errorf("%vThe stash entry is kept in case you need it again.", conflictMessage(c, conflicted))
+		}
+	}
+
+	if opts.Index {
+		if err := idx.ResetIndex(c, indextree); err != nil {
+			return err
+		}
+	} else {
+		// The index stays as it was, except that files which are new
+		// in the stash are added so that they're not lost track of.
+		current := idx.GetMap()
+		for _, entry := range midx.Objects {
+			if current.Contains(entry.PathName) {
+				continue
+			}
+			if err := idx.AddStage(c, entry.PathName, entry.Mode, entry.Sha1, Stage0, entry.Fsize, time.Now().UnixNano(), UpdateIndexOptions{Add: true}); err != nil {
+				return err
+			}
+		}
+	}
+	// Refresh the stat information of the entries which match the work
+	// tree, so that the ones that don't are still seen as being modified.
+	for _, entry := range idx.Objects {
+		f, err := entry.PathName.FilePath(c)
+		if err != nil {
+			return err
+		}
+		if !f.Exists() {
+			continue
+		}
+		if hash, _, err := HashFile("blob", f.String()); err == nil && hash == entry.Sha1 {
+			if err := entry.RefreshStat(c); err != nil {
+				return err
+			}
+		}
+	}
+	idx.UseConfiguredVersion(c)
+	return c.GitDir.WriteLocked("index", idx.WriteIndex)
+}
+
+// Restores the untracked files saved in stash to the work tree, without
+// adding them to the index. None of them may exist already.
+func stashRestoreUntracked(c *Client, stash StashEntry) error {
+	parents, err := stash.Commit.Parents(c)
+	if err != nil {
+		return err
+	}
+	if len(parents) < 3 {
+		return nil
+	}
+	uidx := NewIndex()
+	if err := uidx.ResetIndex(c, parents[2]); err != nil {
+		return err
+	}
+	for _, entry := range uidx.Objects {
+		f, err := entry.PathName.FilePath(c)
+		if err != nil {
+			return err
+		}
+		if f.Exists() {
+			return fmt.Errorf("%v already exists, no checkout\nCould not restore untracked files from stash", entry.PathName)
+		}
+	}
+	return CheckoutIndexUncommited(c, uidx, CheckoutIndexOptions{All: true, Force: true}, nil)
+}
+
+// StashDrop removes a stash from the stash list. The stashes which are
+// older than it are renumbered to fill the gap. The dropped stash is
+// returned.
+func StashDrop(c *Client, stash string) (StashEntry, error) {
+	i, stashes, err := stashIndex(c, stash)
+	if err != nil {
+		return StashEntry{}, err
+	}
+	entries, err := readReflog(c, stashRef)
+	if err != nil {
+		return StashEntry{}, err
+	}
+	// The stash list is in the opposite order of the reflog.
+	drop := len(entries) - 1 - i
+	if drop+1 < len(entries) {
+		// The next stash now follows the one before the dropped
+		// one.
+		entries[drop+1].Old = entries[drop].Old
+	}
+	entries = append(entries[:drop], entries[drop+1:]...)
+
+	lock, err := c.GitDir.Lock(stashRef)
+	if err != nil {
+		return StashEntry{}, err
+	}
+	defer lock.Rollback()
+	if len(entries) == 0 {
+		if err := os.Remove(c.GitDir.File("logs/" + stashRef).String()); err != nil {
+			return StashEntry{}, err
+		}
+		if err := os.Remove(c.GitDir.File(stashRef).String()); err != nil {
+			return StashEntry{}, err
+		}
+		return stashes[i], nil
+	}
+	if err := writeReflog(c, stashRef, entries); err != nil {
+		return StashEntry{}, err
+	}
+	if _, err := fmt.Fprintf(lock, "%v\n", entries[len(entries)-1].New); err != nil {
+		return StashEntry{}, err
+	}
+	if err := lock.Commit(); err != nil {
+		return StashEntry{}, err
+	}
+	return stashes[i], nil
+}
+
+// StashBranch creates a new branch starting from the commit that a stash
+// was based on, checks it out, and applies the stash to it with its index.
+// If that succeeds, the stash is dropped and returned.
+func StashBranch(c *Client, branch string, stash string) (StashEntry, error) {
+	_, base, _, err := stashCommits(c, stash)
+	if err != nil {
+		return StashEntry{}, err
+	}
+	if Branch("refs/heads/" + branch).Exists(c) {
+		return StashEntry{}, fmt.Errorf("A branch named '%v' already exists.", branch)
+	}
+	if _, err := Checkout(c, CheckoutOptions{Branch: branch}, base.String(), nil); err != nil {
+		return StashEntry{}, err
+	}
+	if err := StashApply(c, StashApplyOptions{Index: true}, stash); err != nil {
+		return StashEntry{}, err
+	}
+	return StashDrop(c, stash)
+}
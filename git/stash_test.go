@@ -0,0 +1,265 @@
+package git
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestStashApplyIndex(t *testing.T) {
+	c, cleanup := testRepo(t, "gitstashapplyindex")
+	defer cleanup()
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+
+	// Stage one change, and make a different one on top of it in the work
+	// tree.
+	if err := ioutil.WriteFile("foo.txt", []byte("staged\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("new.txt", []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt", "new.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("foo.txt", []byte("unstaged\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	staged, err := c.WriteObject("blob", []byte("staged\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stash, err := StashPush(c, StashPushOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stash == (CommitID{}) {
+		t.Fatal("No stash was created")
+	}
+	if content, err := ioutil.ReadFile("foo.txt"); err != nil || string(content) != "foo\n" {
+		t.Errorf("Work tree was not reset: got %q (%v)", content, err)
+	}
+	if File("new.txt").Exists() {
+		t.Error("Staged new file was not removed")
+	}
+
+	expectIndex := func(desc string, path IndexPath, want Sha1) {
+		t.Helper()
+		idx, err := c.GitDir.ReadIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := idx.GetSha1(path); got != want {
+			t.Errorf("%v: unexpected index entry for %v: got %v want %v", desc, path, got, want)
+		}
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headIdx, err := GetIndexMap(c, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unchanged := headIdx["foo.txt"].Sha1
+
+	// Without --index, the staged change is only in the work tree,
+	// except for the new file.
+	if err := StashApply(c, StashApplyOptions{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile("foo.txt"); err != nil || string(content) != "unstaged\n" {
+		t.Errorf("Unexpected work tree after apply: got %q (%v)", content, err)
+	}
+	expectIndex("Apply", "foo.txt", unchanged)
+	newblob, err := c.WriteObject("blob", []byte("new\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIndex("Apply", "new.txt", newblob)
+
+	if _, err := ResetMode(c, ResetOptions{Hard: true}, head); err != nil {
+		t.Fatal(err)
+	}
+
+	// With --index, the staged and unstaged changes are restored
+	// separately.
+	if err := StashApply(c, StashApplyOptions{Index: true}, "stash@{0}"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile("foo.txt"); err != nil || string(content) != "unstaged\n" {
+		t.Errorf("Unexpected work tree after apply --index: got %q (%v)", content, err)
+	}
+	expectIndex("Apply --index", "foo.txt", staged)
+	expectIndex("Apply --index", "new.txt", newblob)
+	unstaged, err := DiffFiles(c, DiffFilesOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unstaged) != 1 || unstaged[0].Name != "foo.txt" {
+		t.Errorf("Unexpected unstaged changes after apply --index: %v", unstaged)
+	}
+
+	// Applying doesn't drop the stash.
+	if stashes, err := StashList(c); err != nil || len(stashes) != 1 || stashes[0].Commit != stash {
+		t.Errorf("Unexpected stash list after apply: %v (%v)", stashes, err)
+	}
+}
+
+func TestStashDrop(t *testing.T) {
+	c, cleanup := testRepo(t, "gitstashdrop")
+	defer cleanup()
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	var pushed []CommitID
+	for _, content := range []string{"one\n", "two\n", "three\n"} {
+		if err := ioutil.WriteFile("foo.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stash, err := StashPush(c, StashPushOptions{Message: content[:len(content)-1]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pushed = append(pushed, stash)
+	}
+
+	dropped, err := StashDrop(c, "stash@{1}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped.Commit != pushed[1] {
+		t.Errorf("Dropped the wrong stash: got %v want %v", dropped.Commit, pushed[1])
+	}
+
+	// The older stash is renumbered to take the dropped one's place.
+	stashes, err := StashList(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StashEntry{
+		{Name: "stash@{0}", Commit: pushed[2], Message: "On master: three"},
+		{Name: "stash@{1}", Commit: pushed[0], Message: "On master: one"},
+	}
+	if len(stashes) != len(want) {
+		t.Fatalf("Unexpected stash list: got %v want %v", stashes, want)
+	}
+	for i := range want {
+		if stashes[i] != want[i] {
+			t.Errorf("Unexpected stash %d: got %v want %v", i, stashes[i], want[i])
+		}
+	}
+	if cmt, err := RevParseCommitish(c, &RevParseOptions{}, "stash@{1}"); err != nil {
+		t.Error(err)
+	} else if id, err := cmt.CommitID(c); err != nil || id != pushed[0] {
+		t.Errorf("Unexpected stash@{1}: got %v want %v (%v)", id, pushed[0], err)
+	}
+
+	// Dropping the most recent stash moves refs/stash.
+	if _, err := StashDrop(c, ""); err != nil {
+		t.Fatal(err)
+	}
+	if cmt, err := RefSpec("refs/stash").CommitID(c); err != nil || cmt != pushed[0] {
+		t.Errorf("Unexpected refs/stash: got %v want %v (%v)", cmt, pushed[0], err)
+	}
+	if _, err := StashDrop(c, "stash@{1}"); err == nil {
+		t.Error("Dropped a stash which doesn't exist")
+	}
+	if _, err := StashDrop(c, ""); err != nil {
+		t.Fatal(err)
+	}
+	if RefSpec("refs/stash").File(c).Exists() {
+		t.Error("refs/stash exists after dropping every stash")
+	}
+}
+
+func TestStashKeepIndex(t *testing.T) {
+	c, cleanup := testRepo(t, "gitstashkeepindex")
+	defer cleanup()
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	testCommitFile(t, c, "bar.txt", "bar\n", "Add bar")
+
+	// Stage a change to foo.txt with another on top of it, change bar.txt
+	// without staging it, and leave an untracked file.
+	if err := ioutil.WriteFile("foo.txt", []byte("staged\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"foo.txt": "unstaged\n", "bar.txt": "changed\n", "untracked.txt": "untracked\n"} {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	staged, err := c.WriteObject("blob", []byte("staged\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stash, err := StashPush(c, StashPushOptions{KeepIndex: true, IncludeUntracked: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stash == (CommitID{}) {
+		t.Fatal("No stash was created")
+	}
+
+	// The staged change is kept in the index and the work tree, and
+	// everything else is reverted.
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := idx.GetSha1("foo.txt"); got != staged {
+		t.Errorf("Staged change was not kept in the index: got %v want %v", got, staged)
+	}
+	for name, want := range map[string]string{"foo.txt": "staged\n", "bar.txt": "bar\n"} {
+		if content, err := ioutil.ReadFile(name); err != nil || string(content) != want {
+			t.Errorf("Unexpected content of %v: got %q (%v) want %q", name, content, err, want)
+		}
+	}
+	if File("untracked.txt").Exists() {
+		t.Error("Untracked file was not removed")
+	}
+	unstaged, err := DiffFiles(c, DiffFilesOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unstaged) != 0 {
+		t.Errorf("Unexpected unstaged changes after stash: %v", unstaged)
+	}
+
+	// The untracked file is saved in the third parent, and restored by
+	// applying the stash.
+	parents, err := stash.Parents(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) != 3 {
+		t.Fatalf("Unexpected parents of the stash: %v", parents)
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResetMode(c, ResetOptions{Hard: true}, head); err != nil {
+		t.Fatal(err)
+	}
+	if err := StashApply(c, StashApplyOptions{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"foo.txt": "unstaged\n", "bar.txt": "changed\n", "untracked.txt": "untracked\n"} {
+		if content, err := ioutil.ReadFile(name); err != nil || string(content) != want {
+			t.Errorf("Unexpected content of %v after apply: got %q (%v) want %q", name, content, err, want)
+		}
+	}
+	idx, err = c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.GetMap().Contains("untracked.txt") {
+		t.Error("Untracked file was added to the index")
+	}
+}
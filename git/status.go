@@ -1,8 +1,11 @@
 package git
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 )
 
 type StatusUntrackedMode uint8
@@ -39,26 +42,33 @@ type StatusOptions struct {
 
 	IgnoreSubmodules StatusIgnoreSubmodules
 	Column           StatusColumnOptions
+
+	// The directory of the work tree that paths are displayed relative
+	// to, with a trailing slash. If empty, paths are displayed relative
+	// to the root of the work tree.
+	PathPrefix IndexPath
+
+	// Color the output according to the color.status config. Porcelain
+	// output is never colored.
+	Color bool
+}
+
+// Returns the format that the short status is rendered in for opts.
+func (opts StatusOptions) OutputFormat() OutputFormat {
+	switch {
+	case opts.NullTerminate:
+		return OutputNullTerminated
+	case opts.Porcelain > 0:
+		return OutputPorcelain
+	default:
+		return OutputHuman
+	}
 }
 
 // Helper to run update-index --refresh
 func refreshIndex(c *Client) error {
-	idx, err := c.GitDir.ReadIndex()
-	if err != nil {
-		return err
-	}
-	nidx, err := UpdateIndex(c, idx, UpdateIndexOptions{Refresh: true}, nil)
-	if err != nil {
-		return err
-	}
-	f, err := c.GitDir.Create("index")
-	if err != nil {
-		return err
-	}
-	if err := nidx.WriteIndex(f); err != nil {
-		return err
-	}
-	return nil
+	_, err := RefreshIndex(c, RefreshOptions{Quiet: true}, nil)
+	return err
 }
 func Status(c *Client, opts StatusOptions, files []File) (string, error) {
 	// This doesn't feel right, but seems to be required to match the behaviour
@@ -67,13 +77,31 @@ func Status(c *Client, opts StatusOptions, files []File) (string, error) {
 	if err := refreshIndex(c); err != nil {
 		return "", err
 	}
-	if opts.Porcelain > 1 || opts.ShowStash || opts.Verbose || opts.Ignored || (opts.Column != "default" && opts.Column != "") {
+	if opts.Porcelain > 2 || opts.ShowStash || opts.Verbose || opts.Ignored || (opts.Column != "default" && opts.Column != "") {
 		return "", fmt.Errorf("Unsupported option for Status")
 	}
 	if opts.Column == "" {
 		opts.Column = "column"
 	}
 	var ret string
+	if opts.Short || opts.Porcelain > 0 || opts.NullTerminate {
+		opts.Short = true // If porcelain is set, ensure short=true too..
+		result, err := ShortStatus(c, opts, files)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := result.Render(&buf, opts.OutputFormat()); err != nil {
+			return "", err
+		}
+		status := buf.String()
+		if opts.Color && opts.OutputFormat() == OutputHuman {
+			if status, err = colorStatusShort(c, status); err != nil {
+				return "", err
+			}
+		}
+		return status, nil
+	}
 	if opts.Branch || opts.Long {
 		branch, err := StatusBranch(c, opts, "")
 		if err != nil {
@@ -83,21 +111,15 @@ func Status(c *Client, opts StatusOptions, files []File) (string, error) {
 			ret += branch + "\n"
 		}
 	}
-	if opts.Short || opts.Porcelain == 1 || opts.NullTerminate {
-		opts.Short = true // If porcelain=1, ensure short=true too..
-		lineending := "\n"
-		if opts.NullTerminate {
-			lineending = "\000"
-		}
-		status, err := StatusShort(c, files, opts.UntrackedMode, "", lineending)
+	if opts.Long {
+		status, err := StatusLong(c, files, opts.UntrackedMode, opts.PathPrefix, "")
 		if err != nil {
 			return "", err
 		}
-		ret += status
-	} else if opts.Long {
-		status, err := StatusLong(c, files, opts.UntrackedMode, "")
-		if err != nil {
-			return "", err
+		if opts.Color {
+			if status, err = colorStatusLong(c, status); err != nil {
+				return "", err
+			}
 		}
 		ret += status
 	}
@@ -111,22 +133,22 @@ func StatusBranch(c *Client, opts StatusOptions, lineprefix string) (string, err
 			return "", nil
 		}
 	}
-	h, herr := c.GetHeadCommit()
+	_, herr := c.GetHeadCommit()
 
-	switch branch, err := SymbolicRefGet(c, SymbolicRefOptions{Short: true}, "HEAD"); err {
+	switch branch, err := c.HeadBranch(); e := err.(type) {
 	case nil:
 		if opts.Short {
 			if herr != nil {
-				return "## No commits yet on " + branch.String(), nil
+				return "## No commits yet on " + branch.BranchName(), nil
 			}
-			return "## " + branch.String(), nil
+			return "## " + branch.BranchName(), nil
 		}
-		ret = fmt.Sprintf("On branch %v", branch)
-	case DetachedHead:
+		ret = fmt.Sprintf("On branch %v", branch.BranchName())
+	case DetachedHeadError:
 		if opts.Short {
 			return "## HEAD (no branch)", nil
 		}
-		ret = fmt.Sprintf("HEAD detached at %v", h.String())
+		ret = fmt.Sprintf("HEAD detached at %v", e.Commit)
 	default:
 		return "", err
 	}
@@ -138,8 +160,27 @@ func StatusBranch(c *Client, opts StatusOptions, lineprefix string) (string, err
 
 }
 
+// Returns the branch header of git status --porcelain=v2, which has a line
+// for the commit and one for the branch of HEAD.
+func statusBranchV2(c *Client) (string, error) {
+	oid := "(initial)"
+	if head, err := c.GetHeadCommit(); err == nil {
+		oid = head.String()
+	}
+	switch branch, err := c.HeadBranch(); err.(type) {
+	case nil:
+		return fmt.Sprintf("# branch.oid %v\n# branch.head %v", oid, branch.BranchName()), nil
+	case DetachedHeadError:
+		return fmt.Sprintf("# branch.oid %v\n# branch.head (detached)", oid), nil
+	default:
+		return "", err
+	}
+}
+
 // Return a string of the status
-func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, lineprefix string) (string, error) {
+// Implements git status --long. Paths are displayed relative to the directory
+// pathprefix of the work tree.
+func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, pathprefix IndexPath, lineprefix string) (string, error) {
 	// If no head commit: "no changes yet", else branch info
 	// Changes to be committed: dgit diff-index --cached HEAD
 	// Unmerged: git ls-files -u
@@ -187,12 +228,13 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 				if err != nil {
 					return "", err
 				}
+				dname := f.PathName.RelativeTo(pathprefix)
 
 				if _, ok := unmergedMap[fname]; ok {
 					// There's a merge conflict, it'l show up in "Unmerged"
 					continue
 				}
-				stagedMsg += fmt.Sprintf("%v\tnew file:\t%v\n", lineprefix, fname)
+				stagedMsg += fmt.Sprintf("%v\tnew file:\t%v\n", lineprefix, dname)
 			}
 		}
 
@@ -221,6 +263,7 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 			if err != nil {
 				return "", err
 			}
+			dname := f.Name.RelativeTo(pathprefix)
 
 			if _, ok := unmergedMap[fname]; ok {
 				// There's a merge conflict, it'l show up in "Unmerged"
@@ -228,11 +271,11 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 			}
 
 			if f.Src == (TreeEntry{}) {
-				stagedMsg += fmt.Sprintf("%v\tnew file:\t%v\n", lineprefix, fname)
+				stagedMsg += fmt.Sprintf("%v\tnew file:\t%v\n", lineprefix, dname)
 			} else if f.Dst == (TreeEntry{}) {
-				stagedMsg += fmt.Sprintf("%v\tdeleted:\t%v\n", lineprefix, fname)
+				stagedMsg += fmt.Sprintf("%v\tdeleted:\t%v\n", lineprefix, dname)
 			} else {
-				stagedMsg += fmt.Sprintf("%v\tmodified:\t%v\n", lineprefix, fname)
+				stagedMsg += fmt.Sprintf("%v\tmodified:\t%v\n", lineprefix, dname)
 			}
 		}
 		if stagedMsg != "" {
@@ -253,32 +296,29 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 		ret += fmt.Sprintf("%v\n", lineprefix)
 
 		for i, f := range unmerged {
-			fname, err := f.PathName.FilePath(c)
-			if err != nil {
-				return "", err
-			}
+			dname := f.PathName.RelativeTo(pathprefix)
 			switch f.Stage() {
 			case Stage1:
 				switch unmerged[i+1].Stage() {
 				case Stage2:
 					if i >= len(unmerged)-2 {
 						// Stage3 is missing, we've reached the end of the index.
-						ret += fmt.Sprintf("%v\tdeleted by them:\t%v\n", lineprefix, fname)
+						ret += fmt.Sprintf("%v\tdeleted by them:\t%v\n", lineprefix, dname)
 						continue
 					}
 					switch unmerged[i+2].Stage() {
 					case Stage3:
 						// There's a stage1, stage2, and stage3. If they weren't all different, read-tree would
 						// have resolved it as a trivial stage0 merge.
-						ret += fmt.Sprintf("%v\tboth modified:\t%v\n", lineprefix, fname)
+						ret += fmt.Sprintf("%v\tboth modified:\t%v\n", lineprefix, dname)
 					default:
 						// Stage3 is missing, but we haven't reached the end of the index.
-						ret += fmt.Sprintf("%v\tdeleted by them:\t%v\n", lineprefix, fname)
+						ret += fmt.Sprintf("%v\tdeleted by them:\t%v\n", lineprefix, dname)
 					}
 					continue
 				case Stage3:
 					// Stage2 is missing
-					ret += fmt.Sprintf("%v\tdeleted by us:\t%v\n", lineprefix, fname)
+					ret += fmt.Sprintf("%v\tdeleted by us:\t%v\n", lineprefix, dname)
 					continue
 				default:
 					panic("Unhandled index")
@@ -288,7 +328,7 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 					// If this is a Stage2, and the previous wasn't Stage1,
 					// then we know the next one must be Stage3 or read-tree
 					// would have handled it as a trivial merge.
-					ret += fmt.Sprintf("%v\tboth added:\t%v\n", lineprefix, fname)
+					ret += fmt.Sprintf("%v\tboth added:\t%v\n", lineprefix, dname)
 				}
 				// If the previous was Stage1, it was handled by the previous
 				// loop iteration.
@@ -321,6 +361,7 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 			if err != nil {
 				return "", err
 			}
+			dname := f.Name.RelativeTo(pathprefix)
 
 			if _, ok := unmergedMap[fname]; ok {
 				// There's a merge conflict, it'l show up in "Unmerged"
@@ -328,11 +369,11 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 			}
 
 			if f.Src == (TreeEntry{}) {
-				notStagedMsg += fmt.Sprintf("%v\tnew file:\t%v\n", lineprefix, fname)
+				notStagedMsg += fmt.Sprintf("%v\tnew file:\t%v\n", lineprefix, dname)
 			} else if f.Dst == (TreeEntry{}) {
-				notStagedMsg += fmt.Sprintf("%v\tdeleted:\t%v\n", lineprefix, fname)
+				notStagedMsg += fmt.Sprintf("%v\tdeleted:\t%v\n", lineprefix, dname)
 			} else {
-				notStagedMsg += fmt.Sprintf("%v\tmodified:\t%v\n", lineprefix, fname)
+				notStagedMsg += fmt.Sprintf("%v\tmodified:\t%v\n", lineprefix, dname)
 			}
 		}
 		if notStagedMsg != "" {
@@ -372,10 +413,11 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 				if err != nil {
 					return "", err
 				}
+				dname := f.PathName.RelativeTo(pathprefix)
 				if fname.IsDir() {
-					ret += fmt.Sprintf("%v\t%v/\n", lineprefix, fname)
+					ret += fmt.Sprintf("%v\t%v/\n", lineprefix, dname)
 				} else {
-					ret += fmt.Sprintf("%v\t%v\n", lineprefix, fname)
+					ret += fmt.Sprintf("%v\t%v\n", lineprefix, dname)
 				}
 			}
 			ret += fmt.Sprintf("%v\n", lineprefix)
@@ -413,8 +455,160 @@ func StatusLong(c *Client, files []File, untracked StatusUntrackedMode, linepref
 	return ret, nil
 }
 
+// A StatusEntry is a path with changes, as listed by git status --short.
+type StatusEntry struct {
+	// The status of the path in the index and in the work tree. These
+	// are the codes printed by git status --short, such as 'M' for
+	// modified, "UU" for both sides of a conflict, or "??" for
+	// untracked.
+	Index, WorkTree byte
+
+	Path IndexPath
+
+	// The path in HEAD, and its mode in the work tree, or 0 if it isn't
+	// in them. These are only used by git status --porcelain=v2.
+	Head         TreeEntry
+	WorkTreeMode EntryMode
+
+	// The path in the index. For an unmerged path, these are stages 1,
+	// 2 and 3, otherwise only the first is used.
+	Stages [3]TreeEntry
+}
+
+// A StatusResult is the result of a status of the work tree. It renders
+// as git status --short with OutputHuman, and as git status --porcelain
+// or -z with the machine readable formats.
+type StatusResult struct {
+	// The "## branch" header line, or "" if it isn't shown.
+	Branch string
+
+	Entries []StatusEntry
+
+	// The directory of the work tree that paths are displayed relative
+	// to with OutputHuman. The machine readable formats are always
+	// relative to the root of the work tree.
+	PathPrefix IndexPath
+
+	// Render the machine readable formats as git status --porcelain=v2,
+	// instead of v1.
+	PorcelainV2 bool
+}
+
+// Renders the status in the given format.
+func (s StatusResult) Render(w io.Writer, format OutputFormat) error {
+	if s.Branch != "" {
+		// The v2 branch header has a line for each field.
+		for _, line := range strings.Split(s.Branch, "\n") {
+			if _, err := fmt.Fprint(w, line, format.Terminator()); err != nil {
+				return err
+			}
+		}
+	}
+	if s.PorcelainV2 && format != OutputHuman {
+		return s.renderV2(w, format)
+	}
+	for _, e := range s.Entries {
+		name := e.Path.String()
+		if format == OutputHuman {
+			name = e.Path.RelativeTo(s.PathPrefix).String()
+			if strings.HasSuffix(e.Path.String(), "/") && name != "." {
+				// Keep the slash on untracked directories.
+				name += "/"
+			}
+		}
+		if name == "." {
+			name = "./"
+		}
+		if _, err := fmt.Fprintf(w, "%c%c %v%v", e.Index, e.WorkTree, format.statusPath(name), format.Terminator()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Renders the entries as git status --porcelain=v2 does. Submodules aren't
+// inspected, so they're rendered as unchanged.
+func (s StatusResult) renderV2(w io.Writer, format OutputFormat) error {
+	for _, e := range s.Entries {
+		name := format.Path(e.Path.String())
+		xy := []byte{e.Index, e.WorkTree}
+		for i, b := range xy {
+			if b == ' ' {
+				xy[i] = '.'
+			}
+		}
+		sub := "N..."
+		if e.Head.FileMode == ModeGitlink || e.Stages[0].FileMode == ModeGitlink {
+			sub = "S..."
+		}
+		var err error
+		switch {
+		case e.Index == '?':
+			_, err = fmt.Fprintf(w, "? %v%v", name, format.Terminator())
+		case e.Index == 'U' || e.WorkTree == 'U' || (e.Index == 'A' && e.WorkTree == 'A') || (e.Index == 'D' && e.WorkTree == 'D'):
+			st := e.Stages
+			_, err = fmt.Fprintf(w, "u %s %v %06o %06o %06o %06o %v %v %v %v%v", xy, sub,
+				st[0].FileMode, st[1].FileMode, st[2].FileMode, e.WorkTreeMode,
+				st[0].Sha1, st[1].Sha1, st[2].Sha1, name, format.Terminator())
+		default:
+			_, err = fmt.Fprintf(w, "1 %s %v %06o %06o %06o %v %v %v%v", xy, sub,
+				e.Head.FileMode, e.Stages[0].FileMode, e.WorkTreeMode,
+				e.Head.Sha1, e.Stages[0].Sha1, name, format.Terminator())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ShortStatus returns the structured status of the work tree used by git
+// status --short and --porcelain. If opts.Branch is set, it includes the
+// branch header line.
+func ShortStatus(c *Client, opts StatusOptions, files []File) (StatusResult, error) {
+	result := StatusResult{PathPrefix: opts.PathPrefix, PorcelainV2: opts.Porcelain == 2}
+	if opts.Branch && result.PorcelainV2 {
+		branch, err := statusBranchV2(c)
+		if err != nil {
+			return StatusResult{}, err
+		}
+		result.Branch = branch
+	} else if opts.Branch {
+		opts.Short = true
+		branch, err := StatusBranch(c, opts, "")
+		if err != nil {
+			return StatusResult{}, err
+		}
+		result.Branch = branch
+	}
+	entries, err := statusEntries(c, files, opts.UntrackedMode)
+	if err != nil {
+		return StatusResult{}, err
+	}
+	result.Entries = entries
+	return result, nil
+}
+
 // Implements git status --short
-func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, lineprefix, lineending string) (string, error) {
+func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, pathprefix IndexPath, lineprefix, lineending string) (string, error) {
+	entries, err := statusEntries(c, files, untracked)
+	if err != nil {
+		return "", err
+	}
+	format := OutputHuman
+	if lineending == "\000" {
+		format = OutputNullTerminated
+	}
+	var buf bytes.Buffer
+	if err := (StatusResult{Entries: entries, PathPrefix: pathprefix}).Render(&buf, format); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Returns the paths with changes in the index or work tree, in the order
+// that git status --short lists them.
+func statusEntries(c *Client, files []File, untracked StatusUntrackedMode) ([]StatusEntry, error) {
 	var lsfiles []File
 	if len(files) == 0 {
 		lsfiles = []File{File(c.WorkDir)}
@@ -422,17 +616,21 @@ func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, linepre
 		lsfiles = files
 	}
 
-	cfiles, err := LsFiles(c, LsFilesOptions{Cached: true}, lsfiles)
+	// The sparse directories are expanded below, leaving out the ones
+	// which are the same as in HEAD.
+	cfiles, err := LsFiles(c, LsFilesOptions{Cached: true, Sparse: true}, lsfiles)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	tree := make(map[IndexPath]*IndexEntry)
 	// It's not an error to use "git status" before the first commit,
 	// so discard the error
+	var headTree Treeish
 	if head, err := c.GetHeadCommit(); err == nil {
+		headTree = head
 		i, err := LsTree(c, LsTreeOptions{FullTree: true, Recurse: true}, head, files)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		// this should probably be an LsTreeMap library function, it would be
@@ -441,14 +639,32 @@ func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, linepre
 			tree[e.PathName] = e
 		}
 	}
-	var ret string
-	var wtst, ist rune
+	// The sparse directories of a sparse index are compared to HEAD by
+	// the files in them.
+	var expanded []LsFilesResult
+	for _, f := range cfiles {
+		if !f.IsSparseDir() {
+			expanded = append(expanded, f)
+			continue
+		}
+		sparseFiles, err := expandSparseDirs(c, []*IndexEntry{f.IndexEntry}, headTree)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range sparseFiles {
+			expanded = append(expanded, LsFilesResult{file, 'S'})
+		}
+	}
+	cfiles = expanded
+
+	var entries []StatusEntry
+	var wtst, ist byte
 	for i, f := range cfiles {
 		wtst = ' '
 		ist = ' '
 		fname, err := f.PathName.FilePath(c)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		switch f.Stage() {
 		case Stage0:
@@ -463,12 +679,15 @@ func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, linepre
 			}
 
 			stat, err := fname.Stat()
-			if os.IsNotExist(err) {
+			if f.SkipWorktree() {
+				// The file isn't expected to be in the work
+				// tree.
+			} else if os.IsNotExist(err) {
 				wtst = 'D'
 			} else {
 				mtime, err := fname.MTime()
 				if err != nil {
-					return "", err
+					return nil, err
 				}
 				if mtime != f.Mtime || stat.Size() != int64(f.Fsize) {
 					wtst = 'M'
@@ -477,29 +696,40 @@ func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, linepre
 				}
 			}
 			if ist != ' ' || wtst != ' ' {
-				ret += fmt.Sprintf("%c%c %v%v", ist, wtst, fname, lineending)
+				e := StatusEntry{Index: ist, WorkTree: wtst, Path: f.PathName}
+				if head, ok := tree[f.PathName]; ok {
+					e.Head = TreeEntry{head.Sha1, head.Mode}
+				}
+				e.Stages[0] = TreeEntry{f.Sha1, f.Mode}
+				switch wtst {
+				case ' ':
+					e.WorkTreeMode = f.Mode
+				case 'M':
+					e.WorkTreeMode = workTreeMode(fname)
+				}
+				entries = append(entries, e)
 			}
 		case Stage1:
 			switch cfiles[i+1].Stage() {
 			case Stage2:
 				if i >= len(cfiles)-2 {
 					// Stage3 is missing, we've reached the end of the index.
-					ret += fmt.Sprintf("MD %v%v", fname, lineending)
+					entries = append(entries, unmergedStatusEntry('U', 'D', cfiles, i, fname))
 					continue
 				}
 				switch cfiles[i+2].Stage() {
 				case Stage3:
 					// There's a stage1, stage2, and stage3. If they weren't all different, read-tree would
 					// have resolved it as a trivial stage0 merge.
-					ret += fmt.Sprintf("UU %v%v", fname, lineending)
+					entries = append(entries, unmergedStatusEntry('U', 'U', cfiles, i, fname))
 				default:
 					// Stage3 is missing, but we haven't reached the end of the index.
-					ret += fmt.Sprintf("MD%v%v", fname, lineending)
+					entries = append(entries, unmergedStatusEntry('U', 'D', cfiles, i, fname))
 				}
 				continue
 			case Stage3:
 				// Stage2 is missing
-				ret += fmt.Sprintf("DM %v%v", fname, lineending)
+				entries = append(entries, unmergedStatusEntry('D', 'U', cfiles, i, fname))
 				continue
 			default:
 				panic("Unhandled index")
@@ -509,7 +739,7 @@ func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, linepre
 				// If this is a Stage2, and the previous wasn't Stage1,
 				// then we know the next one must be Stage3 or read-tree
 				// would have handled it as a trivial merge.
-				ret += fmt.Sprintf("AA %v%v", fname, lineending)
+				entries = append(entries, unmergedStatusEntry('A', 'A', cfiles, i, fname))
 			}
 			// If the previous was Stage1, it was handled by the previous
 			// loop iteration.
@@ -531,20 +761,117 @@ func StatusShort(c *Client, files []File, untracked StatusUntrackedMode, linepre
 
 		untracked, err := LsFiles(c, lsfilesopts, lsfiles)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		for _, f := range untracked {
-			fname, err := f.PathName.FilePath(c)
-			if err != nil {
-				return "", err
-			}
-			if name := fname.String(); name == "." {
-				ret += "?? ./" + lineending
-			} else {
-				ret += "?? " + name + lineending
-			}
+			entries = append(entries, StatusEntry{Index: '?', WorkTree: '?', Path: f.PathName})
 		}
 	}
-	return ret, nil
+	return entries, nil
 
 }
+
+// Returns the status entry with the codes x and y for the unmerged path
+// whose first stage is cfiles[i], which is the file f in the work tree.
+func unmergedStatusEntry(x, y byte, cfiles []LsFilesResult, i int, f File) StatusEntry {
+	e := StatusEntry{Index: x, WorkTree: y, Path: cfiles[i].PathName, WorkTreeMode: workTreeMode(f)}
+	for j := i; j < len(cfiles) && cfiles[j].PathName == e.Path; j++ {
+		if st := cfiles[j].Stage(); st != Stage0 {
+			e.Stages[st-1] = TreeEntry{cfiles[j].Sha1, cfiles[j].Mode}
+		}
+	}
+	return e
+}
+
+// Returns the mode of the file f in the work tree, or 0 if it doesn't
+// exist.
+func workTreeMode(f File) EntryMode {
+	stat, err := f.Lstat()
+	switch {
+	case err != nil || stat.IsDir():
+		return 0
+	case stat.Mode()&os.ModeSymlink != 0:
+		return ModeSymlink
+	case stat.Mode().Perm()&0100 != 0:
+		return ModeExec
+	default:
+		return ModeBlob
+	}
+}
+
+// CleanCheckOptions are the options for checking whether the index and
+// work tree are clean with IsClean.
+type CleanCheckOptions struct {
+	// Don't treat untracked files as making the work tree dirty. Files
+	// which are ignored never do.
+	IgnoreUntracked bool
+}
+
+// IsClean returns whether the index and work tree are clean, which means
+// that nothing is staged relative to HEAD and nothing in the work tree has
+// been modified relative to the index. The changes which make them dirty
+// are returned, with the staged changes first. Untracked files are returned
+// as a HashDiff with only a Name.
+//
+// If there's no HEAD commit yet, anything in the index is a staged change.
+//
+// It's used by the commands which replace the index and work tree wholesale,
+// such as pull, merge, rebase and revert. Checkout only refuses to overwrite
+// the files that it would change, so it doesn't require a clean tree.
+func (c *Client) IsClean(opts CleanCheckOptions) (bool, []HashDiff, error) {
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return false, nil, err
+	}
+	var dirty []HashDiff
+	if head, err := c.GetHeadCommit(); err == nil {
+		staged, err := DiffIndex(c, DiffIndexOptions{Cached: true}, idx, head, nil)
+		if err != nil {
+			return false, nil, err
+		}
+		dirty = append(dirty, staged...)
+	} else {
+		for _, entry := range idx.Objects {
+			dirty = append(dirty, HashDiff{
+				Name:    entry.PathName,
+				Dst:     TreeEntry{entry.Sha1, entry.Mode},
+				DstSize: uint(entry.Fsize),
+			})
+		}
+	}
+
+	unstaged, err := DiffFiles(c, DiffFilesOptions{}, nil)
+	if err != nil {
+		return false, nil, err
+	}
+	dirty = append(dirty, unstaged...)
+
+	if !opts.IgnoreUntracked {
+		untracked, err := LsFiles(c, LsFilesOptions{Others: true, ExcludeStandard: true}, []File{File(c.WorkDir)})
+		if err != nil {
+			return false, nil, err
+		}
+		for _, f := range untracked {
+			dirty = append(dirty, HashDiff{Name: f.PathName})
+		}
+	}
+	return len(dirty) == 0, dirty, nil
+}
+
+// Returns an error listing the local changes if the index or work tree
+// isn't clean, ignoring untracked files, for a command that would overwrite
+// them.
+func requireClean(c *Client, command string) error {
+	clean, dirty, err := c.IsClean(CleanCheckOptions{IgnoreUntracked: true})
+	if err != nil {
+		return err
+	}
+	if clean {
+		return nil
+	}
+	msg := "Your local changes would be overwritten by " + command + ":\n"
+	for _, d := range dirty {
+		msg += "\t" + d.Name.String() + "\n"
+	}
+	return fmt.Errorf("%vPlease commit your changes or stash them to proceed.\nAborting", msg)
+}
@@ -1,9 +1,11 @@
 package git
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -239,7 +241,7 @@ nothing to commit, working tree clean
 		t.Error(err)
 	}
 
-	if err := CheckoutCommit(c, CheckoutOptions{}, initial); err != nil {
+	if _, err := CheckoutCommit(c, CheckoutOptions{}, initial); err != nil {
 		t.Fatal(err)
 	}
 
@@ -470,3 +472,203 @@ UU ../foo.txt
 		t.Error(err)
 	}
 }
+
+// Tests that status and diffs display paths relative to PathPrefix,
+// regardless of the current working directory.
+func TestPathPrefix(t *testing.T) {
+	c, cleanup := testRepo(t, "gitpathprefix")
+	defer cleanup()
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "first")
+	testCommitFile(t, c, "sub/bar.txt", "bar\n", "second")
+	if err := ioutil.WriteFile("foo.txt", []byte("changed foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("sub/bar.txt", []byte("changed bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile("new.txt", []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("sub/new.txt", []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Status refreshes the index first, which mustn't make the modified
+	// files look clean, either then or the next time.
+	for i := 0; i < 2; i++ {
+		short, err := Status(c, StatusOptions{Short: true, UntrackedMode: StatusUntrackedAll, PathPrefix: "sub/"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := " M ../foo.txt\n M bar.txt\n?? ../new.txt\n?? new.txt\n"; short != want {
+			t.Errorf("Unexpected short status %d: got %q want %q", i, short, want)
+		}
+	}
+
+	long, err := StatusLong(c, nil, StatusUntrackedNo, "sub/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(long, "\tmodified:\t../foo.txt\n\tmodified:\tbar.txt\n") {
+		t.Errorf("Unexpected long status paths: got %q", long)
+	}
+
+	opts := DiffCommonOptions{Raw: true, PathPrefix: "sub/"}
+	files, err := DiffFiles(c, DiffFilesOptions{opts}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := DiffIndex(c, DiffIndexOptions{DiffCommonOptions: opts}, nil, head, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, diffs := range [][]HashDiff{files, index} {
+		var out bytes.Buffer
+		if err := GeneratePatch(c, opts, diffs, &out); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 2 || !strings.HasSuffix(lines[0], "\t../foo.txt") || !strings.HasSuffix(lines[1], "\tbar.txt") {
+			t.Errorf("Unexpected diff paths: got %q", out.String())
+		}
+	}
+}
+
+func TestIsClean(t *testing.T) {
+	c, cleanup := testRepo(t, "gitisclean")
+	defer cleanup()
+
+	testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+
+	expectClean := func(desc string, opts CleanCheckOptions, want []IndexPath) {
+		t.Helper()
+		clean, dirty, err := c.IsClean(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clean != (len(want) == 0) {
+			t.Errorf("%v: unexpected clean %v", desc, clean)
+		}
+		if len(dirty) != len(want) {
+			t.Errorf("%v: unexpected dirty files: got %v want %v", desc, dirty, want)
+			return
+		}
+		for i := range want {
+			if dirty[i].Name != want[i] {
+				t.Errorf("%v: unexpected dirty file %d: got %v want %v", desc, i, dirty[i].Name, want[i])
+			}
+		}
+	}
+	expectClean("Clean", CleanCheckOptions{}, nil)
+
+	// Ignored files never make it dirty, but untracked ones do unless
+	// they're ignored.
+	if err := os.MkdirAll(c.GitDir.File("info").String(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(c.GitDir.File("info/exclude").String(), []byte("*.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("debug.log", []byte("log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectClean("Ignored file", CleanCheckOptions{}, nil)
+	if err := ioutil.WriteFile("untracked.txt", []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectClean("Untracked file", CleanCheckOptions{}, []IndexPath{"untracked.txt"})
+	expectClean("Ignoring untracked", CleanCheckOptions{IgnoreUntracked: true}, nil)
+	if err := os.Remove("untracked.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Modified in the work tree, but not staged.
+	if err := ioutil.WriteFile("foo.txt", []byte("bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectClean("Worktree dirty", CleanCheckOptions{}, []IndexPath{"foo.txt"})
+
+	// Staged, so the work tree matches the index but not HEAD.
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	expectClean("Staged only", CleanCheckOptions{}, []IndexPath{"foo.txt"})
+	_, dirty, err := c.IsClean(CleanCheckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirty[0].Src.Sha1 == (Sha1{}) || dirty[0].Dst.Sha1 == (Sha1{}) {
+		t.Errorf("Staged change is not a modification: %v", dirty[0])
+	}
+}
+
+// Tests that the commands which replace the index and work tree refuse to
+// run when there are local changes that they would lose.
+func TestDirtyTreeRefused(t *testing.T) {
+	c, cleanup := testRepo(t, "gitdirtyrefused")
+	defer cleanup()
+
+	base := testCommitFile(t, c, "foo.txt", "foo\n", "Initial commit")
+	other := testCommitFile(t, c, "bar.txt", "bar\n", "Add bar")
+	if err := UpdateRefCAS(c, "refs/heads/other", Sha1{}, Sha1(other), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResetMode(c, ResetOptions{Hard: true}, base); err != nil {
+		t.Fatal(err)
+	}
+	local := testCommitFile(t, c, "baz.txt", "baz\n", "Add baz")
+
+	ops := []struct {
+		name string
+		run  func() error
+	}{
+		{"merge", func() error { return Merge(c, MergeOptions{}, []Commitish{Branch("refs/heads/other")}) }},
+		{"rebase", func() error { return Rebase(c, RebaseOptions{}, Branch("refs/heads/other")) }},
+		{"rebase", func() error { return RebaseInteractive(c, []RebaseStep{{Action: RebasePick, Commit: local}}) }},
+		{"revert", func() error { return Revert(c, RevertOptions{}, []Commitish{local}) }},
+	}
+	expectRefused := func(desc, content string) {
+		t.Helper()
+		for _, op := range ops {
+			err := op.run()
+			if err == nil || !strings.Contains(err.Error(), "would be overwritten by "+op.name) {
+				t.Errorf("%v: unexpected error for %v: %v", desc, op.name, err)
+			}
+			if head, err := c.GetHeadCommit(); err != nil || head != local {
+				t.Errorf("%v: %v moved HEAD to %v (%v)", desc, op.name, head, err)
+			}
+			if got, err := ioutil.ReadFile("foo.txt"); err != nil || string(got) != content {
+				t.Errorf("%v: %v changed foo.txt to %q (%v)", desc, op.name, got, err)
+			}
+		}
+	}
+
+	if err := ioutil.WriteFile("foo.txt", []byte("dirty\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectRefused("Worktree dirty", "dirty\n")
+	if _, err := Add(c, AddOptions{}, []File{"foo.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	expectRefused("Staged", "dirty\n")
+
+	// Untracked files don't stop a merge.
+	if _, err := ResetMode(c, ResetOptions{Hard: true}, local); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("untracked.txt", []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Merge(c, MergeOptions{}, []Commitish{Branch("refs/heads/other")}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile("bar.txt"); err != nil || string(got) != "bar\n" {
+		t.Errorf("Merge with a clean tree did not merge bar.txt: %q (%v)", got, err)
+	}
+}
@@ -0,0 +1,268 @@
+package git
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A Submodule is a submodule as described by the .gitmodules file in the
+// root of the work tree.
+type Submodule struct {
+	Name   string
+	Path   IndexPath
+	URL    string
+	Update string
+	Branch string
+}
+
+// ParseGitmodules parses the .gitmodules file in the root of the work tree
+// of c. If there is no .gitmodules file, there are no submodules.
+func ParseGitmodules(c *Client) ([]Submodule, error) {
+	f, err := os.Open(filepath.Join(c.WorkDir.String(), ".gitmodules"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	config := ParseConfig(f)
+	var submodules []Submodule
+	for _, sect := range config.GetConfigSections("submodule", "") {
+		if sect.subsection == "" {
+			continue
+		}
+		sm := Submodule{
+			Name:   sect.subsection,
+			Path:   IndexPath(sect.values["path"]),
+			URL:    sect.values["url"],
+			Update: sect.values["update"],
+			Branch: sect.values["branch"],
+		}
+		if sm.Path == "" {
+			return nil, fmt.Errorf("No path configured for submodule '%v' in .gitmodules", sm.Name)
+		}
+		submodules = append(submodules, sm)
+	}
+	return submodules, nil
+}
+
+// A SubmoduleState is the state of a submodule in the work tree, as
+// reported by "git submodule status".
+type SubmoduleState struct {
+	Submodule
+
+	// The commit recorded for the submodule in the superproject's
+	// index.
+	Recorded CommitID
+
+	// The commit that is checked out in the submodule, or the zero
+	// CommitID if the submodule isn't checked out.
+	CheckedOut CommitID
+
+	// True if the submodule has been initialized in the config with
+	// SubmoduleInit.
+	Initialized bool
+
+	// True if the submodule has merge conflicts in the index.
+	Unmerged bool
+}
+
+// Prefix returns the character that "git submodule status" prints before
+// the submodule to describe its state.
+func (s SubmoduleState) Prefix() rune {
+	switch {
+	case s.Unmerged:
+		return 'U'
+	case s.CheckedOut == (CommitID{}):
+		return '-'
+	case s.CheckedOut != s.Recorded:
+		return '+'
+	default:
+		return ' '
+	}
+}
+
+// Returns the submodule state in the format of "git submodule status".
+func (s SubmoduleState) String() string {
+	cmt := s.CheckedOut
+	if cmt == (CommitID{}) {
+		cmt = s.Recorded
+	}
+	return fmt.Sprintf("%c%v %v", s.Prefix(), cmt, s.Path)
+}
+
+// Returns the git directory for the submodule checked out at dir, which is
+// either a .git directory or a .git file pointing to a directory elsewhere.
+// Returns the empty string if the submodule is not checked out.
+func submoduleGitDir(dir File) (GitDir, error) {
+	dotgit := File(filepath.Join(dir.String(), ".git"))
+	if dotgit.IsDir() {
+		return GitDir(dotgit), nil
+	}
+	if !dotgit.Exists() {
+		return "", nil
+	}
+	gitdir, err := readGitFile(dotgit)
+	if err != nil {
+		return "", err
+	}
+	return GitDir(gitdir), nil
+}
+
+// Returns the commit checked out in the submodule at path in the work tree
+// of c, or the zero CommitID if the submodule is not checked out.
+func gitlinkHead(c *Client, path IndexPath) (CommitID, error) {
+	dir := File(filepath.Join(c.WorkDir.String(), path.String()))
+	gitdir, err := submoduleGitDir(dir)
+	if err != nil {
+		return CommitID{}, err
+	}
+	if gitdir == "" || !gitdir.Exists() {
+		return CommitID{}, nil
+	}
+	subc, err := NewClient(gitdir.String(), dir.String())
+	if err != nil {
+		return CommitID{}, err
+	}
+	head, err := subc.GetHeadCommit()
+	if err != nil {
+		// A submodule without any commits isn't checked out
+		// either.
+		return CommitID{}, nil
+	}
+	return head, nil
+}
+
+// SubmoduleStatus returns the state of every submodule in the index of c,
+// comparing the gitlink recorded in the index to the commit checked out in
+// the submodule.
+func SubmoduleStatus(c *Client) ([]SubmoduleState, error) {
+	submodules, err := ParseGitmodules(c)
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[IndexPath]Submodule, len(submodules))
+	for _, sm := range submodules {
+		byPath[sm.Path] = sm
+	}
+
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	var states []SubmoduleState
+	for _, entry := range idx.Objects {
+		if entry.Mode != ModeGitlink {
+			continue
+		}
+		if n := len(states); n > 0 && states[n-1].Path == entry.PathName {
+			// Multiple stages of an unmerged gitlink
+			states[n-1].Unmerged = true
+			continue
+		}
+		sm, ok := byPath[entry.PathName]
+		if !ok {
+			return nil, fmt.Errorf("no submodule mapping found in .gitmodules for path '%v'", entry.PathName)
+		}
+		state := SubmoduleState{
+			Submodule:   sm,
+			Recorded:    CommitID(entry.Sha1),
+			Initialized: c.GetConfig("submodule."+sm.Name+".url") != "",
+			Unmerged:    entry.Stage() != Stage0,
+		}
+
+		head, err := gitlinkHead(c, entry.PathName)
+		if err != nil {
+			return nil, err
+		}
+		state.CheckedOut = head
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Resolves a relative submodule URL (one starting with ./ or ../) against
+// the URL of the superproject's default remote, or the superproject itself
+// if it doesn't have one.
+func resolveSubmoduleURL(c *Client, rel string) (string, error) {
+	if !strings.HasPrefix(rel, "./") && !strings.HasPrefix(rel, "../") {
+		return rel, nil
+	}
+	base := c.GetConfig("remote.origin.url")
+	if base == "" {
+		base = c.WorkDir.String()
+	}
+	if u, err := url.Parse(base); err == nil && u.Scheme != "" && u.Host != "" {
+		u.Path = path.Join(u.Path, rel)
+		return u.String(), nil
+	}
+	if filepath.IsAbs(base) {
+		return filepath.Join(base, rel), nil
+	}
+	// An scp-like ssh url, like host:path
+	if pieces := strings.SplitN(base, ":", 2); len(pieces) == 2 {
+		return pieces[0] + ":" + path.Join(pieces[1], rel), nil
+	}
+	return path.Join(base, rel), nil
+}
+
+// SubmoduleInit registers the submodules in .gitmodules in the local config
+// of c, so that they can be updated. If paths is not empty, only the
+// submodules at those paths are initialized. Submodules which are already
+// registered are left alone. It returns the submodules which were newly
+// registered.
+func SubmoduleInit(c *Client, paths []File) ([]Submodule, error) {
+	submodules, err := ParseGitmodules(c)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[IndexPath]bool, len(paths))
+	for _, p := range paths {
+		ip, err := p.IndexPath(c)
+		if err != nil {
+			return nil, err
+		}
+		wanted[IndexPath(strings.TrimSuffix(ip.String(), "/"))] = true
+	}
+
+	config, err := LoadLocalConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	var registered []Submodule
+	for _, sm := range submodules {
+		if len(wanted) > 0 && !wanted[sm.Path] {
+			continue
+		}
+		key := "submodule." + sm.Name + ".url"
+		if val, _ := config.GetConfig(key); val != "" {
+			continue
+		}
+		if sm.URL == "" {
+			return nil, fmt.Errorf("No url found for submodule path '%v' in .gitmodules", sm.Path)
+		}
+		u, err := resolveSubmoduleURL(c, sm.URL)
+		if err != nil {
+			return nil, err
+		}
+		config.SetConfig(key, u)
+		c.SetCachedConfig(key, u)
+		// Like the official client, update commands aren't copied
+		// from .gitmodules since they would be run without asking.
+		if sm.Update != "" && !strings.HasPrefix(sm.Update, "!") {
+			config.SetConfig("submodule."+sm.Name+".update", sm.Update)
+		}
+		sm.URL = u
+		registered = append(registered, sm)
+	}
+	if len(registered) == 0 {
+		return nil, nil
+	}
+	return registered, config.WriteConfig()
+}
@@ -0,0 +1,116 @@
+package git
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that the state of submodules with gitlinks in the index is
+// reported, and that initializing them registers them in the config.
+func TestSubmoduleStatusAndInit(t *testing.T) {
+	c, cleanup := testRepo(t, "gitsubmodule")
+	defer cleanup()
+
+	gitmodules := `[submodule "sub"]
+	path = sub
+	url = ../sub.git
+[submodule "other"]
+	path = lib/other
+	url = https://example.com/other.git
+`
+	if err := ioutil.WriteFile(".gitmodules", []byte(gitmodules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A checked out submodule with a single empty commit.
+	subdir := filepath.Join(c.WorkDir.String(), "sub")
+	subc, err := Init(nil, InitOptions{Quiet: true}, subdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := subc.WriteObject("tree", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subhead, err := CommitTree(subc, CommitTreeOptions{}, TreeID(tree), nil, "sub")
+	if err != nil && err != NoGlobalConfig {
+		t.Fatal(err)
+	}
+	if err := UpdateRefSpec(subc, UpdateRefOptions{}, RefSpec("refs/heads/master"), subhead, "test"); err != nil {
+		t.Fatal(err)
+	}
+	other, err := CommitTree(subc, CommitTreeOptions{}, TreeID(tree), []CommitID{subhead}, "other")
+	if err != nil && err != NoGlobalConfig {
+		t.Fatal(err)
+	}
+
+	writeGitlinks := func(sub CommitID) {
+		t.Helper()
+		idx := NewIndex()
+		if err := idx.AddStage(c, "lib/other", ModeGitlink, Sha1(other), Stage0, 0, 0, UpdateIndexOptions{Add: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.AddStage(c, "sub", ModeGitlink, Sha1(sub), Stage0, 0, 0, UpdateIndexOptions{Add: true}); err != nil {
+			t.Fatal(err)
+		}
+		f, err := c.GitDir.Create("index")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := idx.WriteIndex(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := func() []SubmoduleState {
+		t.Helper()
+		states, err := SubmoduleStatus(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(states) != 2 {
+			t.Fatalf("Unexpected number of submodules: got %v want 2", len(states))
+		}
+		return states
+	}
+
+	writeGitlinks(subhead)
+	states := status()
+	if got, want := states[0].String(), "-"+other.String()+" lib/other"; got != want {
+		t.Errorf("Unexpected status for uninitialized submodule: got %q want %q", got, want)
+	}
+	if got, want := states[1].String(), " "+subhead.String()+" sub"; got != want {
+		t.Errorf("Unexpected status for submodule: got %q want %q", got, want)
+	}
+	if states[1].Initialized {
+		t.Error("Submodule was initialized before SubmoduleInit")
+	}
+
+	writeGitlinks(other)
+	if states := status(); states[1].Prefix() != '+' || states[1].CheckedOut != subhead || states[1].Recorded != other {
+		t.Errorf("Unexpected status for modified submodule: %v", states[1])
+	}
+
+	registered, err := SubmoduleInit(c, []File{"sub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantURL := filepath.Join(filepath.Dir(c.WorkDir.String()), "sub.git")
+	if len(registered) != 1 || registered[0].Name != "sub" || registered[0].URL != wantURL {
+		t.Errorf("Unexpected registered submodules: got %v want sub with url %v", registered, wantURL)
+	}
+	config, err := LoadLocalConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url, _ := config.GetConfig("submodule.sub.url"); url != wantURL {
+		t.Errorf("Unexpected submodule url in config: got %q want %q", url, wantURL)
+	}
+	if url, _ := config.GetConfig("submodule.other.url"); url != "" {
+		t.Errorf("Submodule which was not requested was initialized with url %q", url)
+	}
+	if states := status(); !states[1].Initialized || states[0].Initialized {
+		t.Errorf("Unexpected initialized state after SubmoduleInit: %v", states)
+	}
+}
@@ -0,0 +1,360 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Options for SubtreeSplit
+type SubtreeSplitOptions struct {
+	// The commit whose history is split. If nil, HEAD is used.
+	Commit Commitish
+
+	// If set, the branch is created or fast-forwarded to the split
+	// history.
+	Branch string
+}
+
+// Options for SubtreeAdd and SubtreeMerge
+type SubtreeMergeOptions struct {
+	CommitTreeOptions
+
+	// The message of the merge commit. If empty, a message like the one
+	// used by git-subtree is generated.
+	Message string
+}
+
+// Returns the tree at the directory prefix of tree, or false if there's
+// no such directory.
+func subtreeAt(c *Client, tree TreeID, prefix string) (TreeID, bool, error) {
+	for _, name := range strings.Split(prefix, "/") {
+		entries, err := tree.GetAllObjects(c, "", false, false)
+		if err != nil {
+			return TreeID{}, false, err
+		}
+		entry, ok := entries[IndexPath(name)]
+		if !ok || entry.FileMode != ModeTree {
+			return TreeID{}, false, nil
+		}
+		tree = TreeID(entry.Sha1)
+	}
+	return tree, true, nil
+}
+
+// Returns a copy of tree with the directory prefix replaced by sub,
+// creating any directories which don't exist. If tree is nil, it's
+// treated as an empty tree.
+func graftTree(c *Client, tree *TreeID, prefix string, sub TreeID) (TreeID, error) {
+	name, rest := prefix, ""
+	if slash := strings.IndexByte(prefix, '/'); slash >= 0 {
+		name, rest = prefix[:slash], prefix[slash+1:]
+	}
+
+	var entries []MkTreeEntry
+	var child *TreeID
+	if tree != nil {
+		objs, err := tree.GetAllObjects(c, "", false, false)
+		if err != nil {
+			return TreeID{}, err
+		}
+		for path, entry := range objs {
+			if path.String() == name {
+				if entry.FileMode != ModeTree {
+					return TreeID{}, fmt.Errorf("%v is not a directory", name)
+				}
+				t := TreeID(entry.Sha1)
+				child = &t
+				continue
+			}
+			entries = append(entries, MkTreeEntry{TreeEntry: entry, Name: path.String()})
+		}
+	}
+	if rest != "" {
+		var err error
+		if sub, err = graftTree(c, child, rest, sub); err != nil {
+			return TreeID{}, err
+		}
+	}
+	entries = append(entries, MkTreeEntry{TreeEntry: TreeEntry{Sha1(sub), ModeTree}, Name: name})
+	return MkTreeEntries(c, MkTreeOptions{}, entries)
+}
+
+// Writes a copy of the commit cmt with a different tree and parents. The
+// author, committer and message are the same, so splitting the same
+// history again gives the same commits. Any signature is dropped, since
+// it wouldn't be valid for the new commit.
+func rewriteCommit(c *Client, cmt CommitID, tree TreeID, parents []CommitID) (CommitID, error) {
+	pc, err := ParseCommit(c, cmt)
+	if err != nil {
+		return CommitID{}, err
+	}
+	pc.Tree, pc.Parents = tree, parents
+	headers := pc.Headers[:0]
+	for _, h := range pc.Headers {
+		if h.Name != "gpgsig" {
+			headers = append(headers, h)
+		}
+	}
+	pc.Headers = headers
+	id, err := c.WriteObject("commit", pc.Bytes())
+	return CommitID(id), err
+}
+
+// SubtreeSplit synthesizes a history which only contains the directory
+// prefix, as "git subtree split". Every commit which changed prefix is
+// rewritten to have the tree of prefix as its tree, so that the result can
+// be pushed to its own repository. Commits which didn't change prefix, or
+// didn't have it, are left out.
+//
+// Since the rewritten commits keep the author, committer and message of
+// the originals, splitting a history which has new commits gives the same
+// commits for the part that was split before.
+func SubtreeSplit(c *Client, prefix string, opts SubtreeSplitOptions) (CommitID, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return CommitID{}, fmt.Errorf("Must provide a prefix to split")
+	}
+	var head CommitID
+	var err error
+	if opts.Commit == nil {
+		head, err = c.GetHeadCommit()
+	} else {
+		head, err = opts.Commit.CommitID(c)
+	}
+	if err != nil {
+		return CommitID{}, err
+	}
+
+	// The rewritten commit for each commit that was visited, or nil if
+	// none of its history has prefix.
+	split := make(map[CommitID]*CommitID)
+	trees := make(map[CommitID]TreeID)
+	var visit func(cmt CommitID) error
+	visit = func(cmt CommitID) error {
+		if _, ok := split[cmt]; ok {
+			return nil
+		}
+		parents, err := cmt.Parents(c)
+		if err != nil {
+			return err
+		}
+		var newparents []CommitID
+		seen := make(map[CommitID]bool)
+		for _, p := range parents {
+			if err := visit(p); err != nil {
+				return err
+			}
+			if np := split[p]; np != nil && !seen[*np] {
+				seen[*np] = true
+				newparents = append(newparents, *np)
+			}
+		}
+
+		tree, err := cmt.TreeID(c)
+		if err != nil {
+			return err
+		}
+		sub, ok, err := subtreeAt(c, tree, prefix)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if len(newparents) > 0 {
+				split[cmt] = &newparents[0]
+			} else {
+				split[cmt] = nil
+			}
+			return nil
+		}
+
+		// If prefix is the same as it was in one of the parents,
+		// and the other parents are already in its history, the
+		// commit didn't change anything in prefix.
+		for _, np := range newparents {
+			if trees[np] != sub {
+				continue
+			}
+			unchanged := true
+			for _, other := range newparents {
+				if other != np && !other.IsAncestor(c, np) {
+					unchanged = false
+					break
+				}
+			}
+			if unchanged {
+				split[cmt] = &np
+				return nil
+			}
+		}
+		newcmt, err := rewriteCommit(c, cmt, sub, newparents)
+		if err != nil {
+			return err
+		}
+		split[cmt] = &newcmt
+		trees[newcmt] = sub
+		return nil
+	}
+	if err := visit(head); err != nil {
+		return CommitID{}, err
+	}
+	result := split[head]
+	if result == nil {
+		return CommitID{}, fmt.Errorf("prefix %v not found in the history of %v", prefix, head)
+	}
+
+	if opts.Branch != "" {
+		ref := "refs/heads/" + opts.Branch
+		old, err := RefSpec(ref).CommitID(c)
+		if err == nil && !old.IsAncestor(c, *result) {
+			return CommitID{}, fmt.Errorf("Branch %v is not an ancestor of the split commit %v", opts.Branch, *result)
+		}
+		if err := UpdateRef(c, UpdateRefOptions{CreateReflog: true}, ref, *result, "subtree split"); err != nil {
+			return CommitID{}, err
+		}
+	}
+	return *result, nil
+}
+
+// SubtreeAdd adds the history of cmt to HEAD under the directory prefix,
+// which must not exist, as "git subtree add". The tree of cmt is read into
+// the index and work tree under prefix, and a merge commit of HEAD and cmt
+// is created with the result.
+func SubtreeAdd(c *Client, prefix string, opts SubtreeMergeOptions, cmt Commitish) (CommitID, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return CommitID{}, fmt.Errorf("Must provide a prefix to add")
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return CommitID{}, err
+	}
+	other, err := cmt.CommitID(c)
+	if err != nil {
+		return CommitID{}, err
+	}
+	headtree, err := head.TreeID(c)
+	if err != nil {
+		return CommitID{}, err
+	}
+	if _, ok, err := subtreeAt(c, headtree, prefix); err != nil {
+		return CommitID{}, err
+	} else if ok {
+		return CommitID{}, fmt.Errorf("prefix '%v' already exists", prefix)
+	}
+	othertree, err := other.TreeID(c)
+	if err != nil {
+		return CommitID{}, err
+	}
+	tree, err := graftTree(c, &headtree, prefix, othertree)
+	if err != nil {
+		return CommitID{}, err
+	}
+	if _, err := ReadTreeFastForward(c, ReadTreeOptions{Merge: true, Update: true}, head, tree); err != nil {
+		return CommitID{}, err
+	}
+
+	msg := opts.Message
+	if msg == "" {
+		msg = fmt.Sprintf("Add '%v/' from commit '%v'\n", prefix, other)
+	}
+	return subtreeCommit(c, opts, tree, head, other, msg)
+}
+
+// SubtreeMerge merges the history of cmt, which was added to HEAD under
+// the directory prefix by SubtreeAdd, as "git subtree merge". The trees of
+// cmt and its merge base with HEAD are moved under prefix for a three-way
+// merge with HEAD, and a merge commit is created with the result. If there
+// are conflicts, they're left in the index and work tree and an error is
+// returned.
+//
+// If cmt is already merged, HEAD is returned.
+func SubtreeMerge(c *Client, prefix string, opts SubtreeMergeOptions, cmt Commitish) (CommitID, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return CommitID{}, fmt.Errorf("Must provide a prefix to merge")
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		return CommitID{}, err
+	}
+	other, err := cmt.CommitID(c)
+	if err != nil {
+		return CommitID{}, err
+	}
+	if other.IsAncestor(c, head) {
+		return head, nil
+	}
+	headtree, err := head.TreeID(c)
+	if err != nil {
+		return CommitID{}, err
+	}
+	if _, ok, err := subtreeAt(c, headtree, prefix); err != nil {
+		return CommitID{}, err
+	} else if !ok {
+		return CommitID{}, fmt.Errorf("prefix '%v' does not exist; use SubtreeAdd", prefix)
+	}
+	base, err := MergeBase(c, MergeBaseOptions{}, []Commitish{head, other})
+	if err != nil {
+		return CommitID{}, err
+	}
+
+	// Everything outside of prefix is the same as HEAD in the base and
+	// other trees, so only prefix can be changed by the merge.
+	var shifted [2]TreeID
+	for i, cmt := range []CommitID{base, other} {
+		t, err := cmt.TreeID(c)
+		if err != nil {
+			return CommitID{}, err
+		}
+		if shifted[i], err = graftTree(c, &headtree, prefix, t); err != nil {
+			return CommitID{}, err
+		}
+	}
+	idx, err := ReadTreeThreeWay(c, ReadTreeOptions{Merge: true, Update: true}, shifted[0], head, shifted[1])
+	if err != nil {
+		return CommitID{}, err
+	}
+	if len(idx.GetUnmerged()) > 0 {
+		conflicted, err := mergeUnmergedFiles(c, idx, MergeFileOptions{
+			CurrentLabel: "HEAD",
+			BaseLabel:    "merged common ancestors",
+			OtherLabel:   other.String(),
+		})
+		if err != nil {
+			return CommitID{}, err
+		}
+		if err := stageCleanMerges(c, idx, conflicted); err != nil {
+			return CommitID{}, err
+		}
+		if err := c.GitDir.WriteLocked("index", idx.WriteIndex); err != nil {
+			return CommitID{}, err
+		}
+		if len(conflicted) > 0 {
+			return CommitID{}, fmt.Errorf("%vAutomatic merge failed; fix conflicts and then commit the result.", conflictMessage(c, conflicted))
+		}
+	}
+	tree, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		return CommitID{}, err
+	}
+
+	msg := opts.Message
+	if msg == "" {
+		msg = fmt.Sprintf("Merge commit '%v'\n", other)
+	}
+	return subtreeCommit(c, opts, tree, head, other, msg)
+}
+
+// Creates the merge commit of head and other with tree for SubtreeAdd and
+// SubtreeMerge, and moves HEAD to it.
+func subtreeCommit(c *Client, opts SubtreeMergeOptions, tree TreeID, head, other CommitID, msg string) (CommitID, error) {
+	cmt, err := CommitTree(c, opts.CommitTreeOptions, tree, []CommitID{head, other}, msg)
+	if err != nil {
+		return CommitID{}, err
+	}
+	refmsg := fmt.Sprintf("subtree: %v", strings.SplitN(msg, "\n", 2)[0])
+	if err := UpdateRef(c, UpdateRefOptions{OldValue: head, CreateReflog: true}, "HEAD", cmt, refmsg); err != nil {
+		return CommitID{}, err
+	}
+	return cmt, nil
+}
@@ -0,0 +1,126 @@
+package git
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestSubtreeSplit(t *testing.T) {
+	c, cleanup := testRepo(t, "gitsubtreesplit")
+	defer cleanup()
+
+	first := testCommitFile(t, c, "lib/a.txt", "a\n", "Add a")
+	testCommitFile(t, c, "main.txt", "main\n", "Add main")
+	third := testCommitFile(t, c, "lib/b.txt", "b\n", "Add b")
+
+	split, err := SubtreeSplit(c, "lib", SubtreeSplitOptions{Branch: "lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch, err := RefSpec("refs/heads/lib").CommitID(c); err != nil || branch != split {
+		t.Errorf("Unexpected lib branch: got %v (%v) want %v", branch, err, split)
+	}
+
+	// Only the two commits which changed lib are in the split history,
+	// with the tree of lib at each and the original messages.
+	parents, err := split.Parents(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) != 1 {
+		t.Fatalf("Unexpected parents of split commit: %v", parents)
+	}
+	if grandparents, err := parents[0].Parents(c); err != nil || len(grandparents) != 0 {
+		t.Errorf("Unexpected parents of first split commit: %v (%v)", grandparents, err)
+	}
+	for orig, cmt := range map[CommitID]CommitID{third: split, first: parents[0]} {
+		origtree, err := orig.TreeID(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, ok, err := subtreeAt(c, origtree, "lib")
+		if err != nil || !ok {
+			t.Fatalf("Could not find lib in %v: %v", orig, err)
+		}
+		if tree, err := cmt.TreeID(c); err != nil || tree != want {
+			t.Errorf("Unexpected tree for split of %v: got %v (%v) want %v", orig, tree, err, want)
+		}
+		origmsg, _ := orig.GetCommitMessage(c)
+		if msg, err := cmt.GetCommitMessage(c); err != nil || msg != origmsg {
+			t.Errorf("Unexpected message for split of %v: got %q (%v) want %q", orig, msg, err, origmsg)
+		}
+		origauthor, _ := orig.GetAuthor(c)
+		if author, err := cmt.GetAuthor(c); err != nil || author.String() != origauthor.String() {
+			t.Errorf("Unexpected author for split of %v: got %v (%v) want %v", orig, author, err, origauthor)
+		}
+	}
+
+	// Splitting again after a commit outside of lib gives the same
+	// history.
+	testCommitFile(t, c, "main.txt", "changed\n", "Change main")
+	if again, err := SubtreeSplit(c, "lib/", SubtreeSplitOptions{Branch: "lib"}); err != nil || again != split {
+		t.Errorf("Unexpected result of splitting again: got %v (%v) want %v", again, err, split)
+	}
+
+	if _, err := SubtreeSplit(c, "nonexistent", SubtreeSplitOptions{}); err == nil {
+		t.Error("Expected an error splitting a prefix which isn't in the history")
+	}
+}
+
+func TestSubtreeAddMerge(t *testing.T) {
+	c, cleanup := testRepo(t, "gitsubtreemerge")
+	defer cleanup()
+
+	testCommitFile(t, c, "lib/a.txt", "a\n", "Add a")
+	testCommitFile(t, c, "main.txt", "main\n", "Add main")
+	lib, err := SubtreeSplit(c, "lib", SubtreeSplitOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := SubtreeAdd(c, "vendor/lib", SubtreeMergeOptions{}, lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parents, err := added.Parents(c); err != nil || len(parents) != 2 || parents[0] != head || parents[1] != lib {
+		t.Errorf("Unexpected parents of subtree add: got %v (%v) want [%v %v]", parents, err, head, lib)
+	}
+	if content, err := ioutil.ReadFile("vendor/lib/a.txt"); err != nil || string(content) != "a\n" {
+		t.Errorf("Unexpected content of added file: got %q (%v)", content, err)
+	}
+	if _, err := SubtreeAdd(c, "vendor/lib", SubtreeMergeOptions{}, lib); err == nil {
+		t.Error("Expected an error adding a prefix which already exists")
+	}
+
+	// Change the original library and merge the new split history.
+	testCommitFile(t, c, "lib/a.txt", "changed\n", "Change a")
+	lib2, err := SubtreeSplit(c, "lib", SubtreeSplitOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := SubtreeMerge(c, "vendor/lib", SubtreeMergeOptions{}, lib2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile("vendor/lib/a.txt"); err != nil || string(content) != "changed\n" {
+		t.Errorf("Unexpected content of merged file: got %q (%v)", content, err)
+	}
+	tree, err := merged.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	libtree, err := lib2.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub, ok, err := subtreeAt(c, tree, "vendor/lib"); err != nil || !ok || sub != libtree {
+		t.Errorf("Unexpected vendor/lib after merge: got %v (%v) want %v", sub, err, libtree)
+	}
+	if again, err := SubtreeMerge(c, "vendor/lib", SubtreeMergeOptions{}, lib2); err != nil || again != merged {
+		t.Errorf("Unexpected result of merging again: got %v (%v) want %v", again, err, merged)
+	}
+}
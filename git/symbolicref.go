@@ -3,11 +3,26 @@ package git
 import (
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 )
 
 var DetachedHead error = errors.New("In Detached HEAD state")
 
+// A DetachedHeadError is returned by HeadBranch when HEAD points directly
+// at a commit instead of a branch. It matches DetachedHead with errors.Is.
+type DetachedHeadError struct {
+	Commit CommitID
+}
+
+func (e DetachedHeadError) Error() string {
+	return fmt.Sprintf("HEAD detached at %v", e.Commit)
+}
+
+func (e DetachedHeadError) Is(target error) bool {
+	return target == DetachedHead
+}
+
 // A SymbolicRef is generally "HEAD". It's a fake symlink used by git
 // to support operating systems that don't have symlinks
 type SymbolicRef string
@@ -67,19 +82,26 @@ func SymbolicRefUpdate(c *Client, opts SymbolicRefOptions, symname SymbolicRef,
 	}
 
 	if reason != "" {
-		if reflog := c.GitDir.File(File("logs/" + symname.String())); reflog.Exists() {
-			if err := updateReflog(c, false, reflog, symname, refvalue, reason); err != nil {
+		// HEAD's reflog is created once HEAD points to a commit, so
+		// that previous checkouts can be found for @{-n}.
+		create := false
+		if symname == "HEAD" {
+			_, err := c.GetHeadCommit()
+			create = err == nil
+		}
+		if reflog := c.GitDir.File(File("logs/" + symname.String())); reflog.Exists() || create {
+			if err := updateReflog(c, true, reflog, symname, refvalue, reason); err != nil {
 				return fmt.Errorf("Error updating reflog: %v", err)
 			}
 		}
 	}
 
-	file, err := c.GitDir.Create(File(symname))
+	err := c.GitDir.WriteLocked(File(symname), func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "ref: %s", refvalue)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("Error creating SymbolicRef: %v", err)
 	}
-	defer file.Close()
-
-	fmt.Fprintf(file, "ref: %s", refvalue)
 	return nil
 }
@@ -22,6 +22,11 @@ type TagOptions struct {
 
 	// Delete the given tag
 	Delete bool
+
+	// Create a signed tag with LocalUser, or the default key if it's
+	// empty. Either implies Annotated.
+	Sign      bool
+	LocalUser GPGKeyId
 }
 
 // List tags, if tagnames is specified only list tags which match one
@@ -79,7 +84,8 @@ func TagCommit(c *Client, opts TagOptions, tagname string, cmt Commitish, msg st
 	if refspec.File(c).Exists() && !opts.Force {
 		return fmt.Errorf("tag '%v' already exists", tagname)
 	}
-	if opts.Annotated {
+	sign := opts.Sign || opts.LocalUser != "" || (opts.Annotated && c.GetConfig("tag.gpgSign") == "true")
+	if opts.Annotated || sign {
 		t := time.Now()
 		tagger, err := c.GetCommitter(&t)
 		tagstdin := fmt.Sprintf(`object %v
@@ -88,6 +94,13 @@ tag %v
 tagger %v
 
 %v`, comm, tagname, tagger, msg)
+		if sign {
+			sig, err := SignBuffer(c, opts.LocalUser, []byte(tagstdin))
+			if err != nil {
+				return err
+			}
+			tagstdin += string(sig)
+		}
 		tagid, err := Mktag(c, strings.NewReader(tagstdin))
 		if err != nil {
 			return err
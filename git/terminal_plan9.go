@@ -0,0 +1,11 @@
+package git
+
+import (
+	"io"
+)
+
+// Plan 9 terminals don't interpret escape codes, so output is never
+// considered to be going to a terminal.
+func isTerminal(w io.Writer) bool {
+	return false
+}
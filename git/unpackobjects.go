@@ -61,7 +61,12 @@ func UnpackObjects(c *Client, opts UnpackObjectsOptions, r io.ReadSeeker) ([]Sha
 			}
 			return objects, err
 		}
-		t, s, ref, offset, _ := p.ReadHeaderSize(r)
+		t, s, ref, offset, _, err := p.ReadHeaderSize(r)
+		if err != nil {
+			// The rest of the stream can't be found without
+			// the header, so this can't be recovered from.
+			return objects, err
+		}
 		rawdata := p.readEntryDataStream1(r)
 		switch t {
 		case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB:
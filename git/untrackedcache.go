@@ -0,0 +1,551 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The flag of the untracked cache from git's dir_struct which records that
+// untracked directories were listed instead of their contents.
+const untrackedShowOtherDirectories = 1 << 1
+
+// The stat information that the untracked cache records for directories
+// and exclude files, in the same layout as git's on disk stat_data.
+type untrackedStat struct {
+	Ctime     uint32
+	Ctimenano uint32
+	Mtime     int64
+	Dev       uint32
+	Ino       uint32
+	Uid       uint32
+	Gid       uint32
+	Size      uint32
+}
+
+// Returns the stat information of f, or the zero value if it doesn't
+// exist.
+func statUntracked(f File) untrackedStat {
+	stat, err := f.Lstat()
+	if err != nil {
+		return untrackedStat{}
+	}
+	mtime, _ := f.MTime()
+	ctime, ctimenano := f.CTime()
+	return untrackedStat{
+		Ctime:     ctime,
+		Ctimenano: ctimenano,
+		Mtime:     mtime,
+		Ino:       f.INode(),
+		Size:      uint32(stat.Size()),
+	}
+}
+
+// Returns the blob hash of the exclude file f, or the zero hash if it doesn't
+// exist.
+func hashExcludeFile(f File) Sha1 {
+	if !f.Exists() {
+		return Sha1{}
+	}
+	sha, _, err := HashFile("blob", f.String())
+	if err != nil {
+		return Sha1{}
+	}
+	return sha
+}
+
+// An untrackedCache is the untracked cache ("UNTR") index extension, which
+// records the untracked files of each directory of the work tree along
+// with the directory's stat information, so that only the directories which
+// have changed need to be read again to find untracked files.
+type untrackedCache struct {
+	// NUL terminated strings describing the work tree and system that
+	// the cache was written for.
+	ident []byte
+
+	infoExcludeStat  untrackedStat
+	excludesFileStat untrackedStat
+	dirFlags         uint32
+
+	// The hashes of .git/info/exclude and core.excludesFile, or the zero
+	// hash if they don't exist.
+	infoExclude  Sha1
+	excludesFile Sha1
+
+	// The name of the per-directory exclude file, usually ".gitignore".
+	excludePerDir string
+
+	root *untrackedDir
+}
+
+// The untracked cache of a single directory.
+type untrackedDir struct {
+	// The name of the directory relative to its parent.
+	name string
+
+	// Whether untracked has the untracked files of the directory, as of
+	// when it had the stat information in stat.
+	valid bool
+	stat  untrackedStat
+
+	// Set by git when it only checked whether the directory has any
+	// untracked files, in which case untracked may be incomplete.
+	checkOnly bool
+
+	// The hash of the per-directory exclude file of this directory, or
+	// the zero hash if it doesn't have one.
+	exclude Sha1
+
+	// The untracked files in the directory. Untracked directories which
+	// are listed instead of their contents have a trailing slash.
+	untracked []string
+
+	// The subdirectories which were recursed into.
+	dirs []*untrackedDir
+}
+
+// Returns the cached subdirectory of d named name, or nil.
+func (d *untrackedDir) subdir(name string) *untrackedDir {
+	if d == nil {
+		return nil
+	}
+	for _, sub := range d.dirs {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// Returns true if the untracked files of d can be used for a directory
+// which currently has the stat information stat.
+func (d *untrackedDir) usable(stat untrackedStat) bool {
+	return d != nil && d.valid && !d.checkOnly && d.stat == stat
+}
+
+// Invalidates the directory containing path and every directory above
+// it, since adding or removing path from the index changes what's
+// untracked in them.
+func (uc *untrackedCache) invalidate(path IndexPath) {
+	if uc == nil {
+		return
+	}
+	d := uc.root
+	names := strings.Split(path.String(), "/")
+	for _, name := range names[:len(names)-1] {
+		if d == nil {
+			return
+		}
+		d.valid = false
+		d = d.subdir(name)
+	}
+	if d != nil {
+		d.valid = false
+	}
+}
+
+// Returns the name of the operating system in the same format as the
+// sysname of uname(2), which is what git uses in the ident of the
+// untracked cache.
+func untrackedSystemName() string {
+	switch runtime.GOOS {
+	case "linux":
+		return "Linux"
+	case "darwin":
+		return "Darwin"
+	case "freebsd":
+		return "FreeBSD"
+	case "netbsd":
+		return "NetBSD"
+	case "openbsd":
+		return "OpenBSD"
+	case "dragonfly":
+		return "DragonFly"
+	case "solaris":
+		return "SunOS"
+	case "windows":
+		return "Windows_NT"
+	default:
+		return runtime.GOOS
+	}
+}
+
+// Returns the untracked cache that should be used for finding untracked
+// files in the index idx in the mode described by dirFlags, or nil if it
+// shouldn't be used. The untracked cache is used if core.untrackedCache
+// is true, or if it isn't set and the index already has one.
+//
+// The returned cache is a copy that can be updated without modifying idx,
+// and only has the directories from idx if they're still valid for the
+// current exclude files.
+func loadUntrackedCache(c *Client, idx *Index, dirFlags uint32) *untrackedCache {
+	switch c.GetConfig("core.untrackedcache") {
+	case "false":
+		return nil
+	case "true":
+	default:
+		if idx.untracked == nil {
+			return nil
+		}
+	}
+	uc := &untrackedCache{
+		ident:         []byte(fmt.Sprintf("Location %s, system %s\x00", c.WorkDir, untrackedSystemName())),
+		dirFlags:      dirFlags,
+		excludePerDir: ".gitignore",
+	}
+	infoExclude := c.GitDir.File("info/exclude")
+	uc.infoExcludeStat, uc.infoExclude = statUntracked(infoExclude), hashExcludeFile(infoExclude)
+	if excludes := c.GetConfig("core.excludesfile"); excludes != "" {
+		uc.excludesFileStat, uc.excludesFile = statUntracked(File(excludes)), hashExcludeFile(File(excludes))
+	}
+
+	old := idx.untracked
+	if old != nil && bytes.Equal(old.ident, uc.ident) && old.dirFlags == uc.dirFlags && old.excludePerDir == uc.excludePerDir && old.infoExclude == uc.infoExclude && old.excludesFile == uc.excludesFile {
+		uc.root = old.root
+	}
+	return uc
+}
+
+// Writes uc to the untracked cache of the index of c, if it's changed from
+// the one in idx. Since the cache is only an optimization, it isn't an
+// error if the index can't be written.
+func saveUntrackedCache(c *Client, idx *Index, uc *untrackedCache) {
+	if idx.untracked != nil {
+		var old, updated bytes.Buffer
+		idx.untracked.writeTo(&old)
+		uc.writeTo(&updated)
+		if bytes.Equal(old.Bytes(), updated.Bytes()) {
+			return
+		}
+	}
+	// idx may be shared with other callers, so read a copy to write.
+	updated, lock, err := c.GitDir.LockIndex()
+	if err != nil {
+		log.Printf("Could not update untracked cache: %v\n", err)
+		return
+	}
+	updated.untracked = uc
+	if err := updated.CommitLocked(lock); err != nil {
+		log.Printf("Could not update untracked cache: %v\n", err)
+	}
+}
+
+// Reads a variable length integer in the format used by the untracked
+// cache, which is the same as the path prefix of a version 4 index entry.
+func readUntrackedVarint(r *bytes.Reader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	val := uint64(b & 127)
+	for b&128 != 0 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		val = ((val + 1) << 7) + uint64(b&127)
+	}
+	return val, nil
+}
+
+// Reads a NUL terminated string.
+func readUntrackedString(r *bytes.Reader) (string, error) {
+	var s []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return string(s), nil
+		}
+		s = append(s, b)
+	}
+}
+
+// Reads an EWAH compressed bitmap, returning the bits that it has set.
+func readEWAH(r *bytes.Reader) ([]bool, error) {
+	var header struct {
+		BitSize   uint32
+		WordCount uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if uint64(header.WordCount)*8 > uint64(r.Len()) {
+		return nil, fmt.Errorf("Invalid EWAH bitmap size")
+	}
+	words := make([]uint64, header.WordCount)
+	if err := binary.Read(r, binary.BigEndian, words); err != nil {
+		return nil, err
+	}
+	var rlwPos uint32
+	if err := binary.Read(r, binary.BigEndian, &rlwPos); err != nil {
+		return nil, err
+	}
+
+	bits := make([]bool, header.BitSize)
+	bit := 0
+	for i := 0; i < len(words); {
+		// Each marker word has a run of words which are all 0 or 1,
+		// followed by a number of literal words.
+		marker := words[i]
+		i++
+		running := marker&1 != 0
+		runLength := (marker >> 1) & 0xffffffff
+		literals := int(marker >> 33)
+		if runLength*64 > uint64(len(bits)-bit) {
+			return nil, fmt.Errorf("Invalid EWAH bitmap run")
+		}
+		if running {
+			for j := uint64(0); j < runLength*64; j++ {
+				bits[bit+int(j)] = true
+			}
+		}
+		bit += int(runLength * 64)
+		if literals > len(words)-i {
+			return nil, fmt.Errorf("Invalid EWAH bitmap literals")
+		}
+		for _, word := range words[i : i+literals] {
+			for j := 0; j < 64 && bit+j < len(bits); j++ {
+				bits[bit+j] = word&(1<<uint(j)) != 0
+			}
+			bit += 64
+		}
+		i += literals
+	}
+	return bits, nil
+}
+
+// Writes bits as an EWAH compressed bitmap. The bitmap is written as a
+// single marker followed by every word as a literal, which is valid if not
+// as compressed as it could be.
+func writeEWAH(w *bytes.Buffer, bits []bool) {
+	words := make([]uint64, (len(bits)+63)/64)
+	for i, set := range bits {
+		if set {
+			words[i/64] |= 1 << uint(i%64)
+		}
+	}
+	binary.Write(w, binary.BigEndian, uint32(len(bits)))
+	binary.Write(w, binary.BigEndian, uint32(len(words)+1))
+	binary.Write(w, binary.BigEndian, uint64(len(words))<<33)
+	binary.Write(w, binary.BigEndian, words)
+	// The position of the last marker word.
+	binary.Write(w, binary.BigEndian, uint32(0))
+}
+
+// Parses the content of an UNTR index extension.
+func parseUntrackedCache(data []byte) (*untrackedCache, error) {
+	r := bytes.NewReader(data)
+	identLen, err := readUntrackedVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if identLen > uint64(r.Len()) {
+		return nil, fmt.Errorf("Invalid untracked cache ident")
+	}
+	uc := &untrackedCache{ident: make([]byte, identLen)}
+	if _, err := io.ReadFull(r, uc.ident); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &uc.infoExcludeStat); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &uc.excludesFileStat); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &uc.dirFlags); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, uc.infoExclude[:]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, uc.excludesFile[:]); err != nil {
+		return nil, err
+	}
+	if uc.excludePerDir, err = readUntrackedString(r); err != nil {
+		return nil, err
+	}
+	ndirs, err := readUntrackedVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if ndirs == 0 {
+		return uc, nil
+	}
+
+	// The directories are stored depth first, followed by the bitmaps
+	// and data for all of them.
+	var dirs []*untrackedDir
+	var readDir func() (*untrackedDir, error)
+	readDir = func() (*untrackedDir, error) {
+		nuntracked, err := readUntrackedVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		nsub, err := readUntrackedVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if nuntracked > uint64(r.Len()) || nsub > uint64(r.Len()) {
+			return nil, fmt.Errorf("Invalid untracked cache directory")
+		}
+		d := &untrackedDir{}
+		if d.name, err = readUntrackedString(r); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, d)
+		for i := uint64(0); i < nuntracked; i++ {
+			name, err := readUntrackedString(r)
+			if err != nil {
+				return nil, err
+			}
+			d.untracked = append(d.untracked, name)
+		}
+		for i := uint64(0); i < nsub; i++ {
+			sub, err := readDir()
+			if err != nil {
+				return nil, err
+			}
+			d.dirs = append(d.dirs, sub)
+		}
+		return d, nil
+	}
+	if uc.root, err = readDir(); err != nil {
+		return nil, err
+	}
+	if uint64(len(dirs)) != ndirs {
+		return nil, fmt.Errorf("Untracked cache directory count does not match")
+	}
+
+	valid, err := readEWAH(r)
+	if err != nil {
+		return nil, err
+	}
+	checkOnly, err := readEWAH(r)
+	if err != nil {
+		return nil, err
+	}
+	hashValid, err := readEWAH(r)
+	if err != nil {
+		return nil, err
+	}
+	for i, d := range dirs {
+		if i < len(checkOnly) {
+			d.checkOnly = checkOnly[i]
+		}
+		if i < len(valid) && valid[i] {
+			d.valid = true
+			if err := binary.Read(r, binary.BigEndian, &d.stat); err != nil {
+				return nil, err
+			}
+		} else {
+			// The untracked files are only meaningful for a
+			// valid directory.
+			d.untracked = nil
+		}
+	}
+	for i, d := range dirs {
+		if i < len(hashValid) && hashValid[i] {
+			if _, err := io.ReadFull(r, d.exclude[:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return uc, nil
+}
+
+// Writes uc in the format of the UNTR index extension.
+func (uc *untrackedCache) writeTo(w *bytes.Buffer) {
+	w.Write(encodeIndexVarint(uint64(len(uc.ident))))
+	w.Write(uc.ident)
+	binary.Write(w, binary.BigEndian, uc.infoExcludeStat)
+	binary.Write(w, binary.BigEndian, uc.excludesFileStat)
+	binary.Write(w, binary.BigEndian, uc.dirFlags)
+	w.Write(uc.infoExclude[:])
+	w.Write(uc.excludesFile[:])
+	w.WriteString(uc.excludePerDir + "\x00")
+	if uc.root == nil {
+		w.Write(encodeIndexVarint(0))
+		return
+	}
+
+	var dirs []*untrackedDir
+	var blocks bytes.Buffer
+	var writeDir func(d *untrackedDir)
+	writeDir = func(d *untrackedDir) {
+		dirs = append(dirs, d)
+		var untracked []string
+		if d.valid {
+			untracked = d.untracked
+		}
+		blocks.Write(encodeIndexVarint(uint64(len(untracked))))
+		blocks.Write(encodeIndexVarint(uint64(len(d.dirs))))
+		blocks.WriteString(d.name + "\x00")
+		for _, name := range untracked {
+			blocks.WriteString(name + "\x00")
+		}
+		for _, sub := range d.dirs {
+			writeDir(sub)
+		}
+	}
+	writeDir(uc.root)
+
+	valid := make([]bool, len(dirs))
+	checkOnly := make([]bool, len(dirs))
+	hashValid := make([]bool, len(dirs))
+	var stats, hashes bytes.Buffer
+	for i, d := range dirs {
+		if d.valid {
+			valid[i] = true
+			checkOnly[i] = d.checkOnly
+			binary.Write(&stats, binary.BigEndian, d.stat)
+		}
+		if d.exclude != (Sha1{}) {
+			hashValid[i] = true
+			hashes.Write(d.exclude[:])
+		}
+	}
+	w.Write(encodeIndexVarint(uint64(len(dirs))))
+	w.Write(blocks.Bytes())
+	writeEWAH(w, valid)
+	writeEWAH(w, checkOnly)
+	writeEWAH(w, hashValid)
+	w.Write(stats.Bytes())
+	w.Write(hashes.Bytes())
+	w.WriteByte(0)
+}
+
+// Returns true if a directory with the stat information stat is old
+// enough that any change to it will change its mtime.
+func untrackedStatRacy(stat untrackedStat) bool {
+	mtime := time.Unix(stat.Mtime>>32, stat.Mtime&0xffffffff)
+	return time.Since(mtime) < racyIndexWindow
+}
+
+// Reads the untracked files of the directory dir from the untracked cache
+// directory old, which must be usable, recursing into the cached
+// subdirectories. The directories are copied into node.
+func findCachedUntrackedFiles(c *Client, opts LsFilesOptions, root, parent, dir File, tracked map[IndexPath]bool, recursedir bool, ignorePatterns []IgnorePattern, old, node *untrackedDir) (untracked []*IndexEntry) {
+	prefix := strings.TrimPrefix(parent.String()+"/", root.String())
+	node.valid, node.untracked, node.checkOnly = true, old.untracked, old.checkOnly
+	for _, name := range old.untracked {
+		indexPath := IndexPath(prefix + name)
+		if !strings.HasSuffix(name, "/") && tracked[indexPath] {
+			continue
+		}
+		untracked = append(untracked, &IndexEntry{PathName: indexPath})
+	}
+	for _, sub := range old.dirs {
+		newnode := &untrackedDir{name: sub.name}
+		node.dirs = append(node.dirs, newnode)
+		recurseFiles := findUntrackedFilesFromDir(c, opts, root, parent+"/"+File(sub.name), dir+"/"+File(sub.name), tracked, recursedir, ignorePatterns, sub, newnode)
+		untracked = append(untracked, recurseFiles...)
+	}
+	return
+}
@@ -0,0 +1,91 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that status records the untracked files in the untracked cache,
+// and that the second status only reads the directories which changed.
+func TestUntrackedCache(t *testing.T) {
+	c, cleanup := testRepo(t, "gituntrackedcache")
+	defer cleanup()
+	c.SetCachedConfig("core.untrackedcache", "true")
+
+	testCommitFile(t, c, "a/one.txt", "one\n", "First commit")
+	testCommitFile(t, c, "a/.gitignore", "*.log\n", "Ignore logs")
+	testCommitFile(t, c, "b/two.txt", "two\n", "Second commit")
+	for _, f := range []string{"a/new.txt", "a/ignored.log", "b/new.txt"} {
+		if err := ioutil.WriteFile(f, []byte("new\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Directories that were modified too recently can't be cached, since
+	// another change might not update their mtime.
+	past := time.Now().Add(-time.Hour)
+	for _, dir := range []string{".", "a", "b"} {
+		if err := os.Chtimes(dir, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Finds the untracked files the same way that the long status does.
+	status := func() string {
+		t.Helper()
+		others, err := LsFiles(c, LsFilesOptions{Others: true, ExcludeStandard: true, Directory: true}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range others {
+			names = append(names, f.PathName.String())
+		}
+		return strings.Join(names, " ")
+	}
+	if got, want := status(), "a/new.txt b/new.txt"; got != want {
+		t.Fatalf("Unexpected untracked files: got %q want %q", got, want)
+	}
+
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.untracked == nil {
+		t.Fatal("Untracked cache was not written")
+	}
+	cached := idx.untracked.root.subdir("a")
+	if !cached.valid || strings.Join(cached.untracked, " ") != "new.txt" {
+		t.Fatalf("Unexpected untracked cache for a: %+v", cached)
+	}
+
+	// Replace the cached contents of a. If it's read again instead of
+	// using the cache, they'll be lost.
+	cached.untracked = []string{"cached.txt"}
+	f, err := c.GitDir.Create(File("index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.WriteIndex(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := ioutil.WriteFile("b/another.txt", []byte("another\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status(), "a/cached.txt b/another.txt b/new.txt"; got != want {
+		t.Errorf("Unexpected untracked files from cache: got %q want %q", got, want)
+	}
+
+	// Changing a .gitignore doesn't change the mtime of its directory,
+	// but it still needs to be read again.
+	if err := ioutil.WriteFile("a/.gitignore", []byte("*.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := status(), "a/ignored.log b/another.txt b/new.txt"; got != want {
+		t.Errorf("Unexpected untracked files after changing .gitignore: got %q want %q", got, want)
+	}
+}
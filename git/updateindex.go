@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"os"
 	"strings"
 )
 
@@ -84,6 +85,9 @@ func UpdateIndex(c *Client, idx *Index, opts UpdateIndexOptions, files []File) (
 					return nil, err
 				}
 				for _, i := range entries {
+					if err := idx.ExpandToPath(c, i.PathName); err != nil {
+						return nil, err
+					}
 					idx.RemoveFile(i.PathName)
 				}
 				continue
@@ -93,6 +97,9 @@ func UpdateIndex(c *Client, idx *Index, opts UpdateIndexOptions, files []File) (
 		}
 		if !file.Exists() || opts.ForceRemove {
 			if opts.Remove || opts.ForceRemove {
+				if err := idx.ExpandToPath(c, ipath); err != nil {
+					return nil, err
+				}
 				idx.RemoveFile(ipath)
 				if opts.Verbose {
 					if opts.correctRemoveMsg {
@@ -213,19 +220,211 @@ func UpdateIndexFromReader(c *Client, opts UpdateIndexOptions, r io.Reader) (*In
 	return idx, nil
 }
 
+// Options for RefreshIndex, which are the same as the options of "git
+// update-index --refresh".
+type RefreshOptions struct {
+	// Don't print the files which need to be updated.
+	Quiet bool
+
+	// Don't report the files which are missing from the work tree.
+	IgnoreMissing bool
+
+	// Don't report unmerged entries as needing a merge.
+	Unmerged bool
+
+	// Don't check whether submodules have a different commit checked
+	// out.
+	IgnoreSubmodules bool
+}
+
+// RefreshIndex implements "git update-index --refresh". It re-stats the
+// files in the work tree for the entries in the index which match paths,
+// or every entry if there are no paths, and updates the stat information
+// of the entries whose content hasn't changed so that they don't need to
+// be hashed again. The index is written back to disk.
+//
+// The files which need to be updated, because their content or mode has
+// changed, and the files which need to be merged are returned, and printed
+// unless opts.Quiet is set.
+func RefreshIndex(c *Client, opts RefreshOptions, paths []File) ([]File, error) {
+	mtime, err := indexMTime(c)
+	if err != nil {
+		return nil, err
+	}
+	idx, lock, err := c.GitDir.LockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Rollback()
+	files, err := idx.refresh(c, opts, paths, mtime)
+	if err != nil {
+		return nil, err
+	}
+	idx.UseConfiguredVersion(c)
+	if err := idx.CommitLocked(lock); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// UpdateIndexRefresh refreshes the stat information of the entries in idx,
+// which was read from the index file, the same way as RefreshIndex, and
+// returns it without writing it.
 func UpdateIndexRefresh(c *Client, idx *Index, opts UpdateIndexOptions) (*Index, error) {
-	for _, entry := range idx.Objects {
+	mtime, err := indexMTime(c)
+	if err != nil {
+		return nil, err
+	}
+	ropts := RefreshOptions{
+		Quiet:            opts.Quiet,
+		IgnoreMissing:    opts.IgnoreMissing,
+		Unmerged:         opts.Unmerged,
+		IgnoreSubmodules: opts.IgnoreSubmodules,
+	}
+	if _, err := idx.refresh(c, ropts, nil, mtime); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Returns the mtime of the index file, in the same format as the mtime of
+// an index entry, or 0 if there's no index file.
+func indexMTime(c *Client) (int64, error) {
+	f := c.GitDir.File("index")
+	if !f.Exists() {
+		return 0, nil
+	}
+	return f.MTime()
+}
+
+// Refreshes the stat information of the entries in g which match paths, and
+// returns the files which need to be updated or merged. indexMtime is the
+// mtime of the index file that g was read from.
+//
+// As in git, the content of racily clean entries is always checked, and if
+// it changed, the size of the entry is set to 0 so that the stat
+// information won't match once the index is written again and the content
+// is checked the next time too.
+//
+// If there's an fsmonitor, only the entries which it reports as changed are
+// refreshed, and the entries which are found to match the work tree are
+// recorded so that the fsmonitor can be trusted for them next time.
+func (g *Index) refresh(c *Client, opts RefreshOptions, paths []File, indexMtime int64) ([]File, error) {
+	var match map[IndexPath]bool
+	if len(paths) > 0 {
+		entries, err := LsFiles(c, LsFilesOptions{Cached: true}, paths)
+		if err != nil {
+			return nil, err
+		}
+		match = make(map[IndexPath]bool)
+		for _, entry := range entries {
+			match[entry.PathName] = true
+		}
+	}
+
+	fsmonitor := queryFSMonitor(c, g)
+	var files []File
+	report := func(f File, msg string) {
+		if !opts.Quiet {
+			fmt.Printf("%v: %v\n", f, msg)
+		}
+		files = append(files, f)
+	}
+	var lastUnmerged IndexPath
+	for _, entry := range g.Objects {
+		if match != nil && !match[entry.PathName] {
+			continue
+		}
+		if fsmonitor.isValid(entry.PathName) || entry.SkipWorktree() {
+			continue
+		}
 		f, err := entry.PathName.FilePath(c)
 		if err != nil {
 			return nil, err
 		}
+		if entry.Stage() != Stage0 {
+			if !opts.Unmerged && entry.PathName != lastUnmerged {
+				report(f, "needs merge")
+			}
+			lastUnmerged = entry.PathName
+			continue
+		}
+		if entry.Mode == ModeGitlink {
+			if opts.IgnoreSubmodules {
+				continue
+			}
+			head, err := gitlinkHead(c, entry.PathName)
+			if err != nil {
+				return nil, err
+			}
+			if head != (CommitID{}) && Sha1(head) != entry.Sha1 {
+				report(f, "needs update")
+			}
+			continue
+		}
 		if !f.Exists() {
-			// Nothing to refresh
+			if !opts.IgnoreMissing {
+				report(f, "needs update")
+			}
+			continue
+		}
+
+		if entry.CompareStat(f) == nil && !entry.needsContentCheck(indexMtime) {
+			if fsmonitor != nil {
+				fsmonitor.markValid(entry.PathName)
+			}
+			continue
+		}
+		modified, err := entry.modifiedInWorkTree(f)
+		if err != nil {
+			return nil, err
+		}
+		if modified {
+			if entry.isRacy(indexMtime) {
+				entry.Fsize = 0
+			}
+			report(f, "needs update")
 			continue
 		}
 		if err := entry.RefreshStat(c); err != nil {
 			return nil, err
 		}
+		if fsmonitor != nil {
+			fsmonitor.markValid(entry.PathName)
+		}
 	}
-	return idx, nil
+	if fsmonitor != nil {
+		g.fsmonitor = fsmonitor
+	}
+	return files, nil
+}
+
+// Returns true if the content or the mode of the file f in the work tree is
+// different from the entry.
+func (ie *IndexEntry) modifiedInWorkTree(f File) (bool, error) {
+	stat, err := f.Lstat()
+	if err != nil {
+		return false, err
+	}
+	var mode EntryMode
+	switch {
+	case stat.Mode()&os.ModeSymlink != 0:
+		mode = ModeSymlink
+	case !stat.Mode().IsRegular():
+		// A directory, or something else which can't be in the
+		// index, replaced the file.
+		return true, nil
+	case stat.Mode().Perm()&0100 != 0:
+		mode = ModeExec
+	default:
+		mode = ModeBlob
+	}
+	if mode != ie.Mode {
+		return true, nil
+	}
+	hash, _, err := HashFile("blob", f.String())
+	if err != nil {
+		return false, err
+	}
+	return hash != ie.Sha1, nil
 }
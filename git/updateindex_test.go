@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"testing"
 )
@@ -262,3 +263,94 @@ func TestUpdateIndexIndexInfo(t *testing.T) {
 		}
 	}
 }
+
+func TestRefreshIndex(t *testing.T) {
+	c, cleanup := testRepo(t, "gitrefreshindex")
+	defer cleanup()
+
+	testCommitFile(t, c, "a.txt", "a\n", "Add a.txt")
+	testCommitFile(t, c, "b.txt", "b\n", "Add b.txt")
+	entry := func(path IndexPath) *IndexEntry {
+		t.Helper()
+		idx, err := c.GitDir.ReadIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range idx.Objects {
+			if e.PathName == path {
+				return e
+			}
+		}
+		t.Fatalf("%v is not in the index", path)
+		return nil
+	}
+
+	// A file whose stat information changed without its content changing
+	// is refreshed, and doesn't need to be updated.
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes("a.txt", past, past); err != nil {
+		t.Fatal(err)
+	}
+	files, err := RefreshIndex(c, RefreshOptions{Quiet: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Unexpected files needing update: %v", files)
+	}
+	if err := entry("a.txt").CompareStat("a.txt"); err != nil {
+		t.Errorf("Stat information of a.txt was not refreshed: %v", err)
+	}
+
+	// Change b.txt without changing its size, and write an index with
+	// its new stat information but the old content, the same as if the
+	// file was changed again right after the index was written. The
+	// index file has the same mtime as b.txt, so b.txt is racily clean.
+	if err := ioutil.WriteFile("b.txt", []byte("c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range idx.Objects {
+		if e.PathName == "b.txt" {
+			if err := e.RefreshStat(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := c.GitDir.WriteLocked("index", idx.WriteIndex); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(c.GitDir.File("index").String(), stat.ModTime(), stat.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry("b.txt").CompareStat("b.txt"); err != nil {
+		t.Fatalf("Stat information of b.txt doesn't match: %v", err)
+	}
+
+	// The content of the racily clean file is checked, and its size is
+	// zeroed so that it's checked again once the index is newer than it.
+	files, err = RefreshIndex(c, RefreshOptions{Quiet: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "b.txt" {
+		t.Errorf("Unexpected files needing update: got %v want [b.txt]", files)
+	}
+	if e := entry("b.txt"); e.Fsize != 0 {
+		t.Errorf("Size of racily clean entry was not zeroed: got %v", e.Fsize)
+	}
+	files, err = RefreshIndex(c, RefreshOptions{Quiet: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "b.txt" {
+		t.Errorf("Unexpected files needing update after refreshing again: got %v want [b.txt]", files)
+	}
+}
@@ -3,6 +3,8 @@ package git
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"strings"
 	"time"
 )
@@ -15,7 +17,9 @@ type UpdateRefOptions struct {
 	CreateReflog bool
 	OldValue     Commitish
 
-	// Not implemented
+	// Read the updates from Stdin in batch mode, as UpdateRefBatch.
+	// If NullTerminate is set, they're read in the NUL terminated
+	// format of "git update-ref --stdin -z".
 	Stdin         io.Reader
 	NullTerminate bool
 }
@@ -62,9 +66,38 @@ func updateReflog(c *Client, create bool, file File, oldvalue, newvalue Commitis
 	return file.Append(toAppend)
 }
 
+// A RefChangedError is returned when a ref can't be updated because it
+// doesn't have the expected old value.
+type RefChangedError struct {
+	Ref RefSpec
+
+	// The value that the ref was expected to have, and the value that
+	// it actually has. The zero value means that the ref doesn't exist.
+	Old, Current Sha1
+}
+
+func (e RefChangedError) Error() string {
+	return fmt.Sprintf("%s is not equal to %s (is %s)", e.Ref, e.Old, e.Current)
+}
+
 // Safely updates ref to point to cmt under the client c, logging reason in the reflog.
 // If opts.OldValue is set, it will return an error if the current value is not OldValue.
 func UpdateRefSpec(c *Client, opts UpdateRefOptions, ref RefSpec, cmt CommitID, reason string) error {
+	if opts.Delete {
+		return fmt.Errorf("Delete RefSpec not implemented")
+	}
+
+	// The RefSpec Stringer method strips out trailing newlines and junk.
+	filename := File(ref.String())
+
+	// Lock the ref before checking the old value, so that nothing else
+	// can change it before it's updated.
+	lock, err := c.GitDir.Lock(filename)
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+
 	if opts.OldValue != nil {
 		oldval, err := opts.OldValue.CommitID(c)
 		if err != nil {
@@ -75,26 +108,18 @@ func UpdateRefSpec(c *Client, opts UpdateRefOptions, ref RefSpec, cmt CommitID,
 			return err
 		}
 		if curval != oldval {
-			return fmt.Errorf("%s is not equal to %s (is %s)", ref, oldval, curval)
+			return RefChangedError{ref, Sha1(oldval), Sha1(curval)}
 		}
 	}
-	if opts.Delete {
-		return fmt.Errorf("Delete RefSpec not implemented")
-	}
-
-	// The RefSpec Stringer method strips out trailing newlines and junk.
-	filename := File(ref.String())
-	if err := updateReflog(c, opts.CreateReflog, File(c.GitDir)+"/logs/"+filename, opts.OldValue, cmt, reason); err != nil {
+	if _, err := fmt.Fprintf(lock, "%s\n", cmt); err != nil {
 		return err
 	}
-
-	file, err := c.GitDir.Create(filename)
-	if err != nil {
+	// The reflog is only appended to once the ref has been updated, so
+	// that it doesn't record an update that didn't happen.
+	if err := lock.Commit(); err != nil {
 		return err
 	}
-	defer file.Close()
-	fmt.Fprintf(file, "%s\n", cmt)
-	return nil
+	return updateReflog(c, opts.CreateReflog, File(c.GitDir)+"/logs/"+filename, opts.OldValue, cmt, reason)
 }
 
 // Handles "git update-ref" command line. ref is what's passed on the command-line
@@ -102,7 +127,7 @@ func UpdateRefSpec(c *Client, opts UpdateRefOptions, ref RefSpec, cmt CommitID,
 // Go doesn't support sum types.
 func UpdateRef(c *Client, opts UpdateRefOptions, ref string, cmt CommitID, reason string) error {
 	if opts.Stdin != nil {
-		return fmt.Errorf("UpdateRef batch mode not implemented")
+		return updateRefBatch(c, opts.Stdin, opts, reason)
 	}
 
 	// It's not a symbolic ref, it's a real ref. Just directly call UpdateRefSpec
@@ -133,7 +158,7 @@ func UpdateRef(c *Client, opts UpdateRefOptions, ref string, cmt CommitID, reaso
 				return err
 			}
 			if curval != oldval {
-				return fmt.Errorf("%s is not equal to %s (is %s)", ref, oldval, curval)
+				return RefChangedError{RefSpec(ref), Sha1(oldval), Sha1(curval)}
 			}
 		}
 
@@ -151,11 +176,319 @@ noderef:
 		return err
 	}
 
-	f, err := c.GitDir.Create(File(ref))
-	if err != nil {
+	return c.GitDir.WriteLocked(File(ref), func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%s", cmt)
 		return err
+	})
+}
+
+// A refUpdate is a change to a ref that's part of a transaction.
+type refUpdate struct {
+	ref    RefSpec
+	symref SymbolicRef
+
+	// The value that the ref must have, if checkOld is set, and the
+	// value to update it to, if update is set. The zero value means
+	// that the ref doesn't exist. If checkOld isn't set, old is set to
+	// the value that the ref had once it was locked, for the reflog.
+	old, new         Sha1
+	checkOld, update bool
+
+	lock *LockFile
+}
+
+// Returns the ref that should be updated for the ref named ref, and the
+// symbolic ref that points to it, if any. Symbolic refs are only followed if
+// deref is set.
+func resolveUpdateRef(c *Client, ref string, deref bool) (RefSpec, SymbolicRef, error) {
+	ref = strings.TrimSpace(ref)
+	if !deref || strings.HasPrefix(ref, "refs/") {
+		return RefSpec(ref), "", nil
+	}
+	switch target, err := SymbolicRefGet(c, SymbolicRefOptions{}, SymbolicRef(ref)); err {
+	case nil:
+		return target, SymbolicRef(ref), nil
+	case DetachedHead:
+		return RefSpec(ref), "", nil
+	default:
+		if os.IsNotExist(err) {
+			return RefSpec(ref), "", nil
+		}
+		return "", "", err
+	}
+}
+
+// Applies updates as a single transaction. The locks for every ref are
+// acquired and the old values are verified before anything is changed, so
+// if any ref can't be locked or doesn't have its expected value, none of
+// them are updated.
+func updateRefs(c *Client, updates []*refUpdate, reason string) error {
+	seen := make(map[RefSpec]bool)
+	for _, u := range updates {
+		if seen[u.ref] {
+			return fmt.Errorf("Multiple updates for ref '%v' not allowed", u.ref)
+		}
+		seen[u.ref] = true
+	}
+
+	// Lock every ref before checking the old values, so that nothing
+	// else can change them between checking and updating them. Any
+	// locks that aren't committed are released when returning.
+	for _, u := range updates {
+		lock, err := c.GitDir.Lock(File(u.ref.String()))
+		if err != nil {
+			return err
+		}
+		defer lock.Rollback()
+		u.lock = lock
+	}
+	for _, u := range updates {
+		var cur Sha1
+		if u.ref.File(c).Exists() {
+			var err error
+			if cur, err = u.ref.Sha1(c); err != nil {
+				return err
+			}
+		}
+		if !u.checkOld {
+			u.old = cur
+		} else if cur != u.old {
+			return RefChangedError{u.ref, u.old, cur}
+		}
+	}
+	for _, u := range updates {
+		if u.update && u.new != (Sha1{}) {
+			if _, err := fmt.Fprintf(u.lock, "%s\n", u.new); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, u := range updates {
+		if !u.update {
+			continue
+		}
+		filename := File(u.ref.String())
+		if u.new == (Sha1{}) {
+			// Remove the ref while holding its lock. The reflog
+			// goes with it, like git.
+			if err := os.Remove(u.ref.File(c).String()); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if reflog := c.GitDir.File("logs/" + filename); reflog.Exists() {
+				if err := reflog.Remove(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if u.symref != "" {
+			if err := updateReflog(c, true, c.GitDir.File(File("logs/"+u.symref.String())), CommitID(u.old), CommitID(u.new), reason); err != nil {
+				return err
+			}
+		}
+		if err := updateReflog(c, false, c.GitDir.File("logs/"+filename), CommitID(u.old), CommitID(u.new), reason); err != nil {
+			return err
+		}
+		if err := u.lock.Commit(); err != nil {
+			return err
+		}
 	}
-	defer f.Close()
-	fmt.Fprintf(f, "%s", cmt)
 	return nil
 }
+
+// UpdateRefCAS atomically changes ref from old to new, logging reason in
+// the reflog. If ref doesn't currently have the value old, a
+// RefChangedError is returned and ref isn't changed. The zero Sha1 as old
+// means that ref must not exist, and as new means that ref should be
+// deleted.
+//
+// If ref is a symbolic ref, the ref that it points to is updated.
+func UpdateRefCAS(c *Client, ref string, old, new Sha1, reason string) error {
+	refspec, symref, err := resolveUpdateRef(c, ref, true)
+	if err != nil {
+		return err
+	}
+	return updateRefs(c, []*refUpdate{{
+		ref:      refspec,
+		symref:   symref,
+		old:      old,
+		new:      new,
+		checkOld: true,
+		update:   true,
+	}}, reason)
+}
+
+// UpdateRefBatch implements "git update-ref --stdin". It reads the
+// commands from r, and applies all of the updates that they describe as
+// a single transaction, so that either all of them are applied or
+// none of them are.
+//
+// The commands are update, create, delete, verify and option, formatted
+// like git. They're terminated by newlines; UpdateRef with Stdin and
+// NullTerminate set reads the NUL terminated format of "-z".
+func UpdateRefBatch(c *Client, r io.Reader) error {
+	return updateRefBatch(c, r, UpdateRefOptions{}, "")
+}
+
+// Parses the value of a ref in an update-ref batch, which is a Sha1 or the
+// name of any object. As in git, tags aren't peeled to what they point to.
+func parseBatchValue(c *Client, val string) (Sha1, error) {
+	if sha, err := Sha1FromString(val); err == nil {
+		return sha, nil
+	}
+	if strings.HasPrefix(val, "-") {
+		return Sha1{}, fmt.Errorf("invalid value '%v'", val)
+	}
+	revs, err := RevParse(c, RevParseOptions{}, []string{val})
+	if err != nil || len(revs) != 1 {
+		return Sha1{}, fmt.Errorf("invalid value '%v'", val)
+	}
+	return revs[0].Id, nil
+}
+
+func updateRefBatch(c *Client, r io.Reader, opts UpdateRefOptions, reason string) error {
+	input, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	// Split the input into the commands and their arguments. With -z,
+	// the command and ref are separated by a space and terminated by a
+	// NUL, and every value that the command takes follows as a NUL
+	// terminated field, which is empty if the value is omitted.
+	// Otherwise, a command and its arguments are separated by spaces on
+	// a line, and an empty value is the zero value.
+	type command struct {
+		name string
+		args []string
+	}
+	zero := Sha1{}.String()
+	var commands []command
+	if opts.NullTerminate {
+		fields := strings.Split(string(input), "\000")
+		if fields[len(fields)-1] != "" {
+			return fmt.Errorf("update-ref: missing NUL at the end of the input")
+		}
+		fields = fields[:len(fields)-1]
+		for i := 0; i < len(fields); {
+			sp := strings.IndexByte(fields[i], ' ')
+			if sp < 0 {
+				return fmt.Errorf("update-ref: invalid command: %v", fields[i])
+			}
+			cmd := command{name: fields[i][:sp], args: []string{fields[i][sp+1:]}}
+			i++
+			nvals := map[string]int{"update": 2, "create": 1, "delete": 1, "verify": 1}[cmd.name]
+			for j := 0; j < nvals; j++ {
+				if i >= len(fields) {
+					return fmt.Errorf("update-ref: %v %v: unexpected end of input", cmd.name, cmd.args[0])
+				}
+				val := fields[i]
+				i++
+				if val == "" {
+					if cmd.name == "update" && j == 0 {
+						// An empty new value is the zero value,
+						// so that the ref is deleted.
+						val = zero
+					} else {
+						continue
+					}
+				}
+				cmd.args = append(cmd.args, val)
+			}
+			commands = append(commands, cmd)
+		}
+	} else {
+		for _, line := range strings.Split(string(input), "\n") {
+			if line == "" {
+				continue
+			}
+			if strings.IndexByte(line, 0) >= 0 {
+				return fmt.Errorf("update-ref: unexpected NUL in input without -z")
+			}
+			fields := strings.Split(line, " ")
+			for i := 2; i < len(fields); i++ {
+				if fields[i] == "" {
+					fields[i] = zero
+				}
+			}
+			commands = append(commands, command{fields[0], fields[1:]})
+		}
+	}
+
+	deref := !opts.NoDeref
+	var updates []*refUpdate
+	for _, cmd := range commands {
+		if cmd.name == "option" {
+			if len(cmd.args) != 1 || cmd.args[0] != "no-deref" {
+				return fmt.Errorf("update-ref: option unknown: %v", strings.Join(cmd.args, " "))
+			}
+			deref = false
+			continue
+		}
+
+		// The number of values that the command requires and allows
+		// after the ref.
+		var min, max int
+		switch cmd.name {
+		case "update":
+			min, max = 1, 2
+		case "create":
+			min, max = 1, 1
+		case "delete", "verify":
+			min, max = 0, 1
+		default:
+			return fmt.Errorf("update-ref: unknown command: %v", cmd.name)
+		}
+		if len(cmd.args) == 0 || cmd.args[0] == "" {
+			return fmt.Errorf("update-ref: %v: missing <ref>", cmd.name)
+		}
+		name, vals := cmd.args[0], cmd.args[1:]
+		if len(vals) < min || len(vals) > max {
+			return fmt.Errorf("update-ref: %v %v: invalid number of arguments", cmd.name, name)
+		}
+		var shas []Sha1
+		for _, val := range vals {
+			sha, err := parseBatchValue(c, val)
+			if err != nil {
+				return fmt.Errorf("update-ref: %v %v: %v", cmd.name, name, err)
+			}
+			shas = append(shas, sha)
+		}
+
+		ref, symref, err := resolveUpdateRef(c, name, deref)
+		if err != nil {
+			return err
+		}
+		u := &refUpdate{ref: ref, symref: symref}
+		switch cmd.name {
+		case "update":
+			u.update, u.new = true, shas[0]
+			if len(shas) == 2 {
+				u.checkOld, u.old = true, shas[1]
+			}
+		case "create":
+			if shas[0] == (Sha1{}) {
+				return fmt.Errorf("update-ref: create %v: zero <newvalue>", name)
+			}
+			u.update, u.new, u.checkOld = true, shas[0], true
+		case "delete":
+			u.update = true
+			if len(shas) == 1 {
+				if shas[0] == (Sha1{}) {
+					return fmt.Errorf("update-ref: delete %v: zero <oldvalue>", name)
+				}
+				u.checkOld, u.old = true, shas[0]
+			}
+		case "verify":
+			// A missing value means the ref must not exist.
+			u.checkOld = true
+			if len(shas) == 1 {
+				u.old = shas[0]
+			}
+		}
+		updates = append(updates, u)
+		deref = !opts.NoDeref
+	}
+	return updateRefs(c, updates, reason)
+}
@@ -0,0 +1,207 @@
+package git
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUpdateRefCAS(t *testing.T) {
+	c, cleanup := testRepo(t, "gitupdaterefcas")
+	defer cleanup()
+
+	a := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+	b := testCommitFile(t, c, "foo.txt", "bar\n", "Second commit")
+
+	if err := UpdateRefCAS(c, "refs/heads/master", Sha1(b), Sha1(a), "reset"); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := c.GetHeadCommit(); err != nil || head != a {
+		t.Errorf("Unexpected HEAD: got %v want %v (%v)", head, a, err)
+	}
+
+	// Something else moves the branch, so updating it from the value
+	// that it used to have must fail.
+	if err := UpdateRef(c, UpdateRefOptions{}, "refs/heads/master", b, ""); err != nil {
+		t.Fatal(err)
+	}
+	err := UpdateRefCAS(c, "HEAD", Sha1(a), Sha1(a), "stale")
+	if cerr, ok := err.(RefChangedError); !ok {
+		t.Errorf("Unexpected error for a changed ref: %v", err)
+	} else if cerr.Ref != "refs/heads/master" || cerr.Old != Sha1(a) || cerr.Current != Sha1(b) {
+		t.Errorf("Unexpected RefChangedError: %+v", cerr)
+	}
+	if head, err := c.GetHeadCommit(); err != nil || head != b {
+		t.Errorf("A failed CAS changed HEAD: got %v want %v (%v)", head, b, err)
+	}
+
+	// The zero value creates a ref only if it doesn't exist, and
+	// deletes it.
+	if err := UpdateRefCAS(c, "refs/heads/new", Sha1{}, Sha1(a), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRefCAS(c, "refs/heads/new", Sha1{}, Sha1(b), ""); err == nil {
+		t.Error("Created a ref which already exists")
+	}
+	if err := UpdateRefCAS(c, "refs/heads/new", Sha1(a), Sha1{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if Branch("refs/heads/new").Exists(c) {
+		t.Error("Ref was not deleted")
+	}
+
+	// When racing to update from the same value, only one update can
+	// win.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := UpdateRefCAS(c, "refs/heads/master", Sha1(b), Sha1(a), "")
+			switch err.(type) {
+			case nil:
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			case RefChangedError, LockError:
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Errorf("%d concurrent updates succeeded", succeeded)
+	}
+}
+
+func TestUpdateRefBatch(t *testing.T) {
+	c, cleanup := testRepo(t, "gitupdaterefbatch")
+	defer cleanup()
+
+	a := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+	b := testCommitFile(t, c, "foo.txt", "bar\n", "Second commit")
+	if err := UpdateRefCAS(c, "refs/heads/old", Sha1{}, Sha1(a), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	expectRefs := func(desc string, want map[string]CommitID) {
+		t.Helper()
+		for ref, cmt := range want {
+			b := Branch(ref)
+			if cmt == (CommitID{}) {
+				if b.Exists(c) {
+					t.Errorf("%v: %v exists", desc, ref)
+				}
+				continue
+			}
+			if got, err := b.CommitID(c); err != nil || got != cmt {
+				t.Errorf("%v: %v is %v want %v (%v)", desc, ref, got, cmt, err)
+			}
+		}
+	}
+
+	// A verify that fails after updates which would succeed must not
+	// change anything.
+	batch := "update refs/heads/master " + a.String() + " " + b.String() + "\n" +
+		"create refs/heads/new " + b.String() + "\n" +
+		"delete refs/heads/old\n" +
+		"verify refs/heads/other " + a.String() + "\n"
+	err := UpdateRefBatch(c, strings.NewReader(batch))
+	if _, ok := err.(RefChangedError); !ok {
+		t.Errorf("Unexpected error for a failed verify: %v", err)
+	}
+	unchanged := map[string]CommitID{"refs/heads/master": b, "refs/heads/new": {}, "refs/heads/old": a}
+	expectRefs("Failed verify", unchanged)
+	for _, ref := range []string{"master", "new", "old"} {
+		if c.GitDir.File(File("refs/heads/" + ref + ".lock")).Exists() {
+			t.Errorf("Lock for %v was not released", ref)
+		}
+	}
+
+	// Invalid commands are rejected before anything is locked.
+	if err := UpdateRefBatch(c, strings.NewReader("update refs/heads/master "+a.String()+"\nbogus refs/heads/new\n")); err == nil {
+		t.Error("Unknown command did not fail")
+	}
+	expectRefs("Unknown command", unchanged)
+
+	batch = "update refs/heads/master " + a.String() + " " + b.String() + "\n" +
+		"create refs/heads/new " + b.String() + "\n" +
+		"delete refs/heads/old " + a.String() + "\n" +
+		"verify refs/heads/missing\n"
+	if err := UpdateRefBatch(c, strings.NewReader(batch)); err != nil {
+		t.Fatal(err)
+	}
+	expectRefs("Newline batch", map[string]CommitID{"refs/heads/master": a, "refs/heads/new": b, "refs/heads/old": {}})
+
+	// A ref that's already locked stops the whole batch, and the locks
+	// that were taken for the others are released.
+	locked, err := c.GitDir.Lock("refs/heads/locked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch = "update refs/heads/master " + b.String() + "\n" +
+		"create refs/heads/locked " + b.String() + "\n"
+	if err := UpdateRefBatch(c, strings.NewReader(batch)); err == nil {
+		t.Error("Updating a locked ref did not fail")
+	}
+	locked.Rollback()
+	expectRefs("Locked ref", map[string]CommitID{"refs/heads/master": a, "refs/heads/locked": {}})
+	if c.GitDir.File("refs/heads/master.lock").Exists() {
+		t.Error("Lock for master was not released after a failed lock")
+	}
+
+	batch = "update refs/heads/master\000" + b.String() + "\000\000" +
+		"delete refs/heads/new\000" + b.String() + "\000"
+	// NUL terminated commands are only read with -z.
+	if err := UpdateRefBatch(c, strings.NewReader(batch)); err == nil {
+		t.Error("NUL terminated batch was accepted without -z")
+	}
+	expectRefs("NUL batch without -z", map[string]CommitID{"refs/heads/master": a, "refs/heads/new": b})
+	zopts := UpdateRefOptions{Stdin: strings.NewReader(batch), NullTerminate: true}
+	if err := UpdateRef(c, zopts, "", CommitID{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	expectRefs("NUL batch", map[string]CommitID{"refs/heads/master": b, "refs/heads/new": {}})
+
+	// With -z, every value must be present, and an empty new value
+	// deletes the ref.
+	zopts.Stdin = strings.NewReader("update refs/heads/master\000" + a.String() + "\000")
+	if err := UpdateRef(c, zopts, "", CommitID{}, ""); err == nil {
+		t.Error("-z update without an old value field did not fail")
+	}
+	zopts.Stdin = strings.NewReader("create refs/heads/gone\000" + a.String() + "\000" +
+		"update refs/heads/gone\000\000" + a.String() + "\000")
+	if err := UpdateRef(c, zopts, "", CommitID{}, ""); err == nil {
+		t.Error("Multiple updates for a ref did not fail")
+	}
+	if err := UpdateRefCAS(c, "refs/heads/gone", Sha1{}, Sha1(a), ""); err != nil {
+		t.Fatal(err)
+	}
+	zopts.Stdin = strings.NewReader("update refs/heads/gone\000\000" + a.String() + "\000")
+	if err := UpdateRef(c, zopts, "", CommitID{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	expectRefs("Empty -z new value", map[string]CommitID{"refs/heads/gone": {}})
+
+	// An update without an old value logs the value that the ref had.
+	log, err := ioutil.ReadFile(c.GitDir.File("logs/refs/heads/master").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(log)), "\n")
+	if want := a.String() + " " + b.String() + " "; !strings.HasPrefix(lines[len(lines)-1], want) {
+		t.Errorf("Unexpected reflog entry for update: got %q want prefix %q", lines[len(lines)-1], want)
+	}
+
+	// Values may name any object, not just commits.
+	if err := UpdateRefBatch(c, strings.NewReader("create refs/tags/blob HEAD:foo.txt\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := RefSpec("refs/tags/blob").Sha1(c); err != nil || got != hashString("bar\n") {
+		t.Errorf("Unexpected value for ref to a blob: got %v (%v) want %v", got, err, hashString("bar\n"))
+	}
+}
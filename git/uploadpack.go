@@ -0,0 +1,689 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The capabilities that UploadPack advertises for version 0 of the
+// protocol.
+const uploadPackCapabilities = "multi_ack side-band side-band-64k no-progress multi_ack_detailed no-done agent=dgit/0.0.2"
+
+// The maximum size of the pkt-lines of the side-band and side-band-64k
+// capabilities.
+const (
+	sidebandMax    = 1000
+	sideband64kMax = 65520
+)
+
+// UploadPackOptions are the options for UploadPack, corresponding to the
+// options of "git upload-pack".
+type UploadPackOptions struct {
+	// Only advertise the refs and capabilities without reading any
+	// requests, as the response to the info/refs request of the smart
+	// HTTP protocol.
+	AdvertiseRefs bool
+
+	// Handle a single request without advertising the refs first,
+	// since they were advertised by an earlier request of the smart
+	// HTTP protocol.
+	StatelessRPC bool
+
+	// The protocol parameters sent by the client, separated by colons
+	// as in the GIT_PROTOCOL environment variable. Version 2 of the
+	// protocol is used if the client asked for "version=2", and version
+	// 0 otherwise.
+	GitProtocol string
+}
+
+// UploadPack implements the server side of a fetch, as "git upload-pack"
+// does, reading the requests of the client from in and writing the
+// responses to out.
+//
+// The refs of c are advertised, and then the objects that the client
+// wants are negotiated with the commits that it already has, and sent to
+// it in a packfile. Only the tips of the refs which were advertised can be
+// wanted. Shallow and partial clones aren't supported.
+func UploadPack(c *Client, in io.Reader, out io.Writer, opts UploadPackOptions) error {
+	for _, param := range strings.Split(opts.GitProtocol, ":") {
+		if param == "version=2" {
+			return uploadPackV2(c, in, out, opts)
+		}
+	}
+	return uploadPackV0(c, in, out, opts)
+}
+
+// The refs which are advertised by UploadPack.
+type uploadPackRefs struct {
+	// The refs, starting with HEAD if it's valid.
+	refs []Ref
+
+	// The value of each annotated tag in refs with the tags peeled.
+	peeled map[string]Sha1
+
+	// The ref which HEAD is a symbolic ref to, if any.
+	head string
+
+	// The objects which the client may want.
+	tips map[Sha1]struct{}
+}
+
+// Returns the refs of c which UploadPack advertises.
+func loadUploadPackRefs(c *Client) (*uploadPackRefs, error) {
+	r := &uploadPackRefs{
+		peeled: make(map[string]Sha1),
+		tips:   make(map[Sha1]struct{}),
+	}
+	if head, err := SymbolicRefGet(c, SymbolicRefOptions{}, "HEAD"); err == nil {
+		r.head = head.String()
+	}
+	if cmt, err := c.GetHeadCommit(); err == nil {
+		r.refs = append(r.refs, Ref{"HEAD", Sha1(cmt)})
+	}
+	err := ForEachRefCallback(c, "refs/", func(c *Client, ref Ref) error {
+		r.refs = append(r.refs, ref)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range r.refs {
+		r.tips[ref.Value] = struct{}{}
+		peeled, _, err := peelUploadPackObject(c, ref.Value)
+		if err != nil {
+			return nil, err
+		}
+		if peeled != ref.Value {
+			r.peeled[ref.Name] = peeled
+			r.tips[peeled] = struct{}{}
+		}
+	}
+	return r, nil
+}
+
+// Returns the object that id refers to once any tags have been peeled,
+// and the tag objects that were peeled to get to it.
+func peelUploadPackObject(c *Client, id Sha1) (Sha1, []Sha1, error) {
+	var tags []Sha1
+	for {
+		obj, err := c.GetObject(id)
+		if err != nil {
+			return Sha1{}, nil, err
+		}
+		if obj.GetType() != "tag" {
+			return id, tags, nil
+		}
+		tags = append(tags, id)
+		line := obj.GetContent()
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i]
+		}
+		if !bytes.HasPrefix(line, []byte("object ")) {
+			return Sha1{}, nil, fmt.Errorf("Invalid tag %v", id)
+		}
+		id, err = Sha1FromString(string(line[len("object "):]))
+		if err != nil {
+			return Sha1{}, nil, err
+		}
+	}
+}
+
+// Serves a fetch with version 0 of the protocol.
+func uploadPackV0(c *Client, in io.Reader, out io.Writer, opts UploadPackOptions) error {
+	refs, err := loadUploadPackRefs(c)
+	if err != nil {
+		return err
+	}
+	if !opts.StatelessRPC {
+		if err := advertiseUploadPackV0(c, out, refs); err != nil {
+			return err
+		}
+	}
+	if opts.AdvertiseRefs {
+		return nil
+	}
+
+	conn := &packProtocolReader{conn: in, state: PktLineMode}
+	line := make([]byte, 65536)
+	var wants []Sha1
+	caps := make(map[string]struct{})
+	for {
+		n, err := conn.Read(line)
+		if err == flushPkt {
+			break
+		} else if err == io.EOF && len(wants) == 0 {
+			// The client only wanted the refs.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fields := strings.Fields(string(line[:n]))
+		if len(fields) < 2 || fields[0] != "want" {
+			return fmt.Errorf("protocol error: expected want, got '%s'", bytes.TrimSpace(line[:n]))
+		}
+		want, err := Sha1FromString(fields[1])
+		if err != nil {
+			return err
+		}
+		if _, ok := refs.tips[want]; !ok {
+			writePktLine(out, fmt.Sprintf("ERR upload-pack: not our ref %v", want))
+			return fmt.Errorf("not our ref %v", want)
+		}
+		wants = append(wants, want)
+		for _, c := range fields[2:] {
+			if i := strings.IndexByte(c, '='); i >= 0 {
+				c = c[:i]
+			}
+			caps[c] = struct{}{}
+		}
+	}
+	if len(wants) == 0 {
+		return nil
+	}
+
+	// The haves are acknowledged in the same way as git. With
+	// multi_ack_detailed, each common commit is acknowledged, and the
+	// client is told when there are enough to send a pack. Otherwise,
+	// with multi_ack each one is acknowledged, and without it only the
+	// first is, as soon as it's found. In every case the client is told
+	// when it hasn't sent any.
+	_, multiAck := caps["multi_ack"]
+	_, detailed := caps["multi_ack_detailed"]
+	_, noDone := caps["no-done"]
+	multiAck = multiAck || detailed
+	var common []Sha1
+	var gotCommon, gotOther, sentReady bool
+	ack := func(format string, id Sha1) error {
+		return writePktLine(out, fmt.Sprintf(format, id))
+	}
+negotiate:
+	for {
+		n, err := conn.Read(line)
+		if err == flushPkt {
+			if detailed && gotCommon && !gotOther && uploadPackReady(c, wants, common) {
+				sentReady = true
+				if err := ack("ACK %v ready\n", common[len(common)-1]); err != nil {
+					return err
+				}
+			}
+			if len(common) == 0 || multiAck {
+				if err := writePktLine(out, "NAK\n"); err != nil {
+					return err
+				}
+			}
+			if noDone && sentReady {
+				// The client won't send done, so the pack is sent
+				// straight away.
+				if err := ack("ACK %v\n", common[len(common)-1]); err != nil {
+					return err
+				}
+				break negotiate
+			}
+			if opts.StatelessRPC {
+				// The client sends the haves again with
+				// its next request.
+				return nil
+			}
+			gotCommon, gotOther = false, false
+			continue
+		} else if err == io.EOF {
+			// The client gave up.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fields := strings.Fields(string(line[:n]))
+		switch {
+		case len(fields) == 1 && fields[0] == "done":
+			if len(common) == 0 {
+				if err := writePktLine(out, "NAK\n"); err != nil {
+					return err
+				}
+			} else if multiAck {
+				if err := ack("ACK %v\n", common[len(common)-1]); err != nil {
+					return err
+				}
+			}
+			break negotiate
+		case len(fields) == 2 && fields[0] == "have":
+			have, err := Sha1FromString(fields[1])
+			if err != nil {
+				return err
+			}
+			if !uploadPackHasCommit(c, have) {
+				gotOther = true
+				if multiAck && uploadPackReady(c, wants, common) {
+					if detailed {
+						sentReady = true
+						err = ack("ACK %v ready\n", have)
+					} else {
+						err = ack("ACK %v continue\n", have)
+					}
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			gotCommon = true
+			common = append(common, have)
+			switch {
+			case detailed:
+				err = ack("ACK %v common\n", have)
+			case multiAck:
+				err = ack("ACK %v continue\n", have)
+			case len(common) == 1:
+				err = ack("ACK %v\n", have)
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("protocol error: expected have or done, got '%s'", bytes.TrimSpace(line[:n]))
+		}
+	}
+
+	_, sb := caps["side-band"]
+	_, sb64k := caps["side-band-64k"]
+	switch {
+	case sb64k:
+		return sendUploadPack(c, out, sideband64kMax, wants, common)
+	case sb:
+		return sendUploadPack(c, out, sidebandMax, wants, common)
+	default:
+		return sendUploadPack(c, out, 0, wants, common)
+	}
+}
+
+// Returns true if the common commits are enough for the client to stop
+// sending haves, which is when every commit that it wants has one of them
+// as an ancestor. Wants which aren't commits once they're peeled can't be
+// checked, so they never stop it.
+func uploadPackReady(c *Client, wants, common []Sha1) bool {
+	if len(common) == 0 {
+		return false
+	}
+want:
+	for _, want := range wants {
+		peeled, _, err := peelUploadPackObject(c, want)
+		if err != nil {
+			return false
+		}
+		if typ, err := c.ObjectType(peeled); err != nil || typ != "commit" {
+			continue
+		}
+		for _, have := range common {
+			if CommitID(have).IsAncestor(c, CommitID(peeled)) {
+				continue want
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Advertises refs and the capabilities of version 0 of the protocol to out,
+// followed by a flush packet.
+func advertiseUploadPackV0(c *Client, out io.Writer, refs *uploadPackRefs) error {
+	caps := uploadPackCapabilities + " object-format=" + c.HashAlgorithm().String()
+	if refs.head != "" {
+		caps += " symref=HEAD:" + refs.head
+	}
+	if len(refs.refs) == 0 {
+		// There are no refs to send the capabilities with.
+		if err := writePktLine(out, fmt.Sprintf("%v capabilities^{}\000%v\n", Sha1{}, caps)); err != nil {
+			return err
+		}
+	}
+	for i, ref := range refs.refs {
+		line := fmt.Sprintf("%v %v", ref.Value, ref.Name)
+		if i == 0 {
+			line += "\000" + caps
+		}
+		if err := writePktLine(out, line+"\n"); err != nil {
+			return err
+		}
+		if peeled, ok := refs.peeled[ref.Name]; ok {
+			if err := writePktLine(out, fmt.Sprintf("%v %v^{}\n", peeled, ref.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(out, "0000")
+	return err
+}
+
+// Returns true if id is a commit in c, which can be used as a common
+// commit with the client.
+func uploadPackHasCommit(c *Client, id Sha1) bool {
+	if have, _, err := c.HaveObject(id); err != nil || !have {
+		return false
+	}
+	typ, err := c.ObjectType(id)
+	return err == nil && typ == "commit"
+}
+
+// Serves a fetch with version 2 of the protocol, handling the ls-refs and
+// fetch commands.
+func uploadPackV2(c *Client, in io.Reader, out io.Writer, opts UploadPackOptions) error {
+	if !opts.StatelessRPC {
+		for _, line := range []string{"version 2\n", "agent=dgit/0.0.2\n", "ls-refs\n", "fetch=ref-in-want\n", "object-format=" + c.HashAlgorithm().String() + "\n"} {
+			if err := writePktLine(out, line); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(out, "0000"); err != nil {
+			return err
+		}
+	}
+	if opts.AdvertiseRefs {
+		return nil
+	}
+
+	conn := &packProtocolReader{conn: in, state: PktLineMode}
+	for {
+		cmd, args, err := readUploadPackCommandV2(conn)
+		if err != nil {
+			return err
+		}
+		switch cmd {
+		case "":
+			// The client is done.
+			return nil
+		case "ls-refs":
+			err = lsRefsV2(c, out, args)
+		case "fetch":
+			err = fetchV2(c, out, args)
+		default:
+			err = fmt.Errorf("unknown command '%v'", cmd)
+		}
+		if err != nil {
+			return err
+		}
+		if opts.StatelessRPC {
+			return nil
+		}
+	}
+}
+
+// Reads a protocol version 2 command from conn, returning its name and
+// arguments. The capabilities sent with the command are ignored. If the
+// client hung up or sent a flush packet instead of a command, the command
+// is empty.
+func readUploadPackCommandV2(conn *packProtocolReader) (string, []string, error) {
+	line := make([]byte, 65536)
+	n, err := conn.Read(line)
+	if err == flushPkt || err == io.EOF {
+		return "", nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+	cmd := strings.TrimSuffix(string(line[:n]), "\n")
+	if !strings.HasPrefix(cmd, "command=") {
+		return "", nil, fmt.Errorf("protocol error: expected command, got '%v'", cmd)
+	}
+	cmd = strings.TrimPrefix(cmd, "command=")
+
+	// Skip the capabilities up to the delimiter, or to the flush if
+	// there are no arguments.
+	for {
+		_, err := conn.Read(line)
+		if err == delimPkt {
+			break
+		} else if err == flushPkt {
+			return cmd, nil, nil
+		} else if err != nil {
+			return "", nil, err
+		}
+	}
+	var args []string
+	for {
+		n, err := conn.Read(line)
+		if err == flushPkt {
+			return cmd, args, nil
+		} else if err != nil {
+			return "", nil, err
+		}
+		args = append(args, strings.TrimSuffix(string(line[:n]), "\n"))
+	}
+}
+
+// Responds to the ls-refs command with the arguments args.
+func lsRefsV2(c *Client, out io.Writer, args []string) error {
+	refs, err := loadUploadPackRefs(c)
+	if err != nil {
+		return err
+	}
+	var peel, symrefs bool
+	var prefixes []string
+	for _, arg := range args {
+		switch {
+		case arg == "peel":
+			peel = true
+		case arg == "symrefs":
+			symrefs = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			prefixes = append(prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		}
+	}
+refs:
+	for _, ref := range refs.refs {
+		if len(prefixes) > 0 {
+			matched := false
+			for _, p := range prefixes {
+				matched = matched || strings.HasPrefix(ref.Name, p)
+			}
+			if !matched {
+				continue refs
+			}
+		}
+		line := fmt.Sprintf("%v %v", ref.Value, ref.Name)
+		if symrefs && ref.Name == "HEAD" && refs.head != "" {
+			line += " symref-target:" + refs.head
+		}
+		if peeled, ok := refs.peeled[ref.Name]; ok && peel {
+			line += " peeled:" + peeled.String()
+		}
+		if err := writePktLine(out, line+"\n"); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(out, "0000")
+	return err
+}
+
+// Responds to the fetch command with the arguments args.
+func fetchV2(c *Client, out io.Writer, args []string) error {
+	refs, err := loadUploadPackRefs(c)
+	if err != nil {
+		return err
+	}
+	var wants, common []Sha1
+	var wantedRefs []Ref
+	done := false
+	for _, arg := range args {
+		fields := strings.Fields(arg)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "want", "have":
+			if len(fields) != 2 {
+				return fmt.Errorf("protocol error: invalid %v line '%v'", fields[0], arg)
+			}
+			id, err := Sha1FromString(fields[1])
+			if err != nil {
+				return err
+			}
+			if fields[0] == "have" {
+				if uploadPackHasCommit(c, id) {
+					common = append(common, id)
+				}
+				continue
+			}
+			if _, ok := refs.tips[id]; !ok {
+				writePktLine(out, fmt.Sprintf("ERR upload-pack: not our ref %v", id))
+				return fmt.Errorf("not our ref %v", id)
+			}
+			wants = append(wants, id)
+		case "want-ref":
+			if len(fields) != 2 {
+				return fmt.Errorf("protocol error: invalid want-ref line '%v'", arg)
+			}
+			found := false
+			for _, ref := range refs.refs {
+				if ref.Name == fields[1] {
+					wants = append(wants, ref.Value)
+					wantedRefs = append(wantedRefs, ref)
+					found = true
+					break
+				}
+			}
+			if !found {
+				writePktLine(out, fmt.Sprintf("ERR unknown ref %v", fields[1]))
+				return fmt.Errorf("unknown ref %v", fields[1])
+			}
+		case "done":
+			done = true
+		}
+		// Other arguments, such as ofs-delta and no-progress, don't
+		// change what's sent.
+	}
+
+	if !done {
+		if err := writePktLine(out, "acknowledgments\n"); err != nil {
+			return err
+		}
+		if len(common) == 0 {
+			// Keep negotiating until there's something in
+			// common.
+			if err := writePktLine(out, "NAK\n"); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintf(out, "0000")
+			return err
+		}
+		for _, id := range common {
+			if err := writePktLine(out, fmt.Sprintf("ACK %v\n", id)); err != nil {
+				return err
+			}
+		}
+		if err := writePktLine(out, "ready\n"); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "0001"); err != nil {
+			return err
+		}
+	}
+	if len(wantedRefs) > 0 {
+		if err := writePktLine(out, "wanted-refs\n"); err != nil {
+			return err
+		}
+		for _, ref := range wantedRefs {
+			if err := writePktLine(out, fmt.Sprintf("%v %v\n", ref.Value, ref.Name)); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(out, "0001"); err != nil {
+			return err
+		}
+	}
+	if err := writePktLine(out, "packfile\n"); err != nil {
+		return err
+	}
+	// Version 2 always uses side-band-64k.
+	return sendUploadPack(c, out, sideband64kMax, wants, common)
+}
+
+// Writes a packfile with the objects needed by a client which has the
+// commits common to out. If sideband is non-zero, the pack is sent on the
+// data channel of the sideband in pkt-lines of at most sideband bytes and
+// followed by a flush packet.
+func sendUploadPack(c *Client, out io.Writer, sideband int, wants, common []Sha1) error {
+	var includes, excludes []Commitish
+	var objects []Sha1
+	seen := make(map[Sha1]struct{})
+	for _, want := range wants {
+		peeled, tags, err := peelUploadPackObject(c, want)
+		if err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			if _, ok := seen[tag]; !ok {
+				seen[tag] = struct{}{}
+				objects = append(objects, tag)
+			}
+		}
+		switch typ, err := c.ObjectType(peeled); {
+		case err != nil:
+			return err
+		case typ == "commit":
+			includes = append(includes, CommitID(peeled))
+		case typ == "blob":
+			if _, ok := seen[peeled]; !ok {
+				seen[peeled] = struct{}{}
+				objects = append(objects, peeled)
+			}
+		default:
+			return fmt.Errorf("upload-pack: can not send %v %v", typ, peeled)
+		}
+	}
+	for _, id := range common {
+		excludes = append(excludes, CommitID(id))
+	}
+	if len(includes) > 0 {
+		revs, err := RevList(c, RevListOptions{Quiet: true, Objects: true}, nil, includes, excludes)
+		if err != nil {
+			return err
+		}
+		for _, id := range revs {
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				objects = append(objects, id)
+			}
+		}
+	}
+
+	if sideband == 0 {
+		return SendPackfile(c, out, objects)
+	}
+	w := bufio.NewWriterSize(sidebandWriter{out, sidebandDataChannel, sideband}, sideband-5)
+	if err := SendPackfile(c, w, objects); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "0000")
+	return err
+}
+
+// A sidebandWriter writes the data written to it to w as pkt-lines on the
+// sideband channel band, in pkt-lines of at most max bytes.
+type sidebandWriter struct {
+	w    io.Writer
+	band byte
+	max  int
+}
+
+func (s sidebandWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > s.max-5 {
+			n = s.max - 5
+		}
+		if _, err := fmt.Fprintf(s.w, "%04x%c", n+5, s.band); err != nil {
+			return written, err
+		}
+		if _, err := s.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,188 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// One end of a pair of pipes, which acts as a connection.
+type testPipeConn struct {
+	*os.File
+	w *os.File
+}
+
+func (p testPipeConn) Write(buf []byte) (int, error) {
+	return p.w.Write(buf)
+}
+
+func (p testPipeConn) Close() error {
+	p.w.Close()
+	return p.File.Close()
+}
+
+// Returns a connection to UploadPack serving c over a pair of pipes. The
+// client speaks the git:// protocol, so the request line which the daemon
+// would handle is skipped. The error returned by UploadPack is sent on
+// the channel once the connection is closed.
+func testUploadPackConn(t *testing.T, c *Client, opts UploadPackOptions) (*gitConn, chan error) {
+	t.Helper()
+	sr, cw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr, sw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		server := testPipeConn{sr, sw}
+		defer server.Close()
+		loadLine(server)
+		err := UploadPack(c, server, server, opts)
+		io.Copy(ioutil.Discard, server)
+		errc <- err
+	}()
+
+	uri, err := url.Parse("git://localhost/repo.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &gitConn{sharedRemoteConn: &sharedRemoteConn{uri: uri}}
+	if err := conn.openConn(testPipeConn{cr, cw}); err != nil {
+		t.Fatal(err)
+	}
+	return conn, errc
+}
+
+// Tests that the fetch client can clone from UploadPack, and then fetch
+// only what's new, with both versions of the protocol.
+func TestUploadPack(t *testing.T) {
+	c, cleanup := testRepo(t, "gituploadpack")
+	defer cleanup()
+	testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+
+	dir, err := ioutil.TempDir("", "gituploadpackdst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, protocol := range []string{"", "version=2"} {
+		dstdir, err := ioutil.TempDir(dir, "dst")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := Init(nil, InitOptions{Quiet: true, Bare: true}, filepath.Join(dstdir, "dst.git"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fetch := func(want CommitID, haves map[Sha1]struct{}) {
+			t.Helper()
+			conn, errc := testUploadPackConn(t, c, UploadPackOptions{GitProtocol: protocol})
+			if v := conn.ProtocolVersion(); (protocol == "") != (v == 1) {
+				t.Errorf("Unexpected protocol version %v for %q", v, protocol)
+			}
+			refs, err := fetchPackDone(dst, FetchPackOptions{All: true}, conn, []Refname{"refs/heads/master"}, haves)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, ref := range refs {
+				if ref.Name == "refs/heads/master" {
+					found = ref.Value == Sha1(want)
+				}
+			}
+			if !found {
+				t.Errorf("Fetch with %q did not return refs/heads/master at %v: %v", protocol, want, refs)
+			}
+			objs, err := RevList(c, RevListOptions{Quiet: true, Objects: true}, nil, []Commitish{want}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, obj := range objs {
+				if have, _, err := dst.HaveObject(obj); err != nil || !have {
+					t.Errorf("Object %v missing after fetch with %q (%v)", obj, protocol, err)
+				}
+			}
+		}
+		head, err := c.GetHeadCommit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fetch(head, map[Sha1]struct{}{})
+		next := testCommitFile(t, c, "foo.txt", "foo"+protocol+"\n", "Commit for "+protocol)
+		fetch(next, map[Sha1]struct{}{Sha1(head): {}})
+	}
+}
+
+// Tests the negotiation of version 0 of the protocol over stateless RPC
+// with multi_ack_detailed, with and without no-done.
+func TestUploadPackStatelessMultiAck(t *testing.T) {
+	c, cleanup := testRepo(t, "gituploadpackstateless")
+	defer cleanup()
+	base := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+	head := testCommitFile(t, c, "foo.txt", "bar\n", "Second commit")
+	unknown := hashString("not a commit in the repository\n")
+
+	request := func(caps string, lines ...string) string {
+		t.Helper()
+		var in, out bytes.Buffer
+		writePktLine(&in, fmt.Sprintf("want %v %v\n", head, caps))
+		in.WriteString("0000")
+		for _, l := range lines {
+			if l == "" {
+				in.WriteString("0000")
+			} else if err := writePktLine(&in, l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := UploadPack(c, &in, &out, UploadPackOptions{StatelessRPC: true}); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	pkt := func(s string) string {
+		return fmt.Sprintf("%04x%v", len(s)+4, s)
+	}
+
+	// Without no-done, the client is told that the server is ready, and
+	// sends done with its next request.
+	got := request("multi_ack_detailed", "have "+base.String()+"\n", "")
+	if want := pkt("ACK "+base.String()+" common\n") + pkt("ACK "+base.String()+" ready\n") + pkt("NAK\n"); got != want {
+		t.Errorf("Unexpected response without no-done: got %q want %q", got, want)
+	}
+	// As in git, a have that the server doesn't have after it's ready
+	// is acknowledged as ready instead.
+	got = request("multi_ack_detailed", "have "+base.String()+"\n", "have "+unknown.String()+"\n", "")
+	if want := pkt("ACK "+base.String()+" common\n") + pkt("ACK "+unknown.String()+" ready\n") + pkt("NAK\n"); got != want {
+		t.Errorf("Unexpected response for an unknown have: got %q want %q", got, want)
+	}
+	got = request("multi_ack_detailed", "have "+base.String()+"\n", "done\n")
+	if want := pkt("ACK "+base.String()+" common\n") + pkt("ACK "+base.String()+"\n"); !strings.HasPrefix(got, want+"PACK") {
+		t.Errorf("Unexpected response to done: got %q want prefix %q", got, want+"PACK")
+	}
+
+	// With no-done, the pack is sent as soon as the server is ready.
+	got = request("multi_ack_detailed no-done", "have "+base.String()+"\n", "")
+	if want := pkt("ACK "+base.String()+" common\n") + pkt("ACK "+base.String()+" ready\n") + pkt("NAK\n") + pkt("ACK "+base.String()+"\n"); !strings.HasPrefix(got, want+"PACK") {
+		t.Errorf("Unexpected response with no-done: got %q want prefix %q", got, want+"PACK")
+	}
+
+	// Until there's a common commit, only NAK is sent.
+	got = request("multi_ack_detailed no-done", "have "+unknown.String()+"\n", "")
+	if want := pkt("NAK\n"); got != want {
+		t.Errorf("Unexpected response without a common commit: got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,127 @@
+package git
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Returns the port of u, or the default port for its scheme.
+func urlPort(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
+
+// Returns how specifically the URL pattern from a config subsection such as
+// [http "https://example.com/path"] matches target, or -1 if it doesn't match
+// at all. A longer matching path is more specific than a matching user name,
+// as described for http.<url>.* in git-config(1).
+func urlMatchScore(pattern string, target *url.URL) int {
+	p, err := url.Parse(pattern)
+	if err != nil || p.Host == "" {
+		return -1
+	}
+	if !strings.EqualFold(p.Scheme, target.Scheme) || urlPort(p) != urlPort(target) {
+		return -1
+	}
+	// A label of "*" in the pattern's host matches any one label.
+	plabels := strings.Split(strings.ToLower(p.Hostname()), ".")
+	tlabels := strings.Split(strings.ToLower(target.Hostname()), ".")
+	if len(plabels) != len(tlabels) {
+		return -1
+	}
+	for i := range plabels {
+		if plabels[i] != "*" && plabels[i] != tlabels[i] {
+			return -1
+		}
+	}
+	score := 1
+	if user := p.User.Username(); user != "" {
+		if user != target.User.Username() {
+			return -1
+		}
+		score++
+	}
+	ppath := strings.TrimSuffix(p.Path, "/")
+	if ppath != "" {
+		if target.Path != ppath && !strings.HasPrefix(target.Path, ppath+"/") {
+			return -1
+		}
+		score += 2 * len(ppath)
+	}
+	return score
+}
+
+// GetURLConfig loads the config variable section.<url>.key whose URL
+// pattern most specifically matches rawurl, for variables such as
+// http.<url>.sslVerify or credential.<url>.helper. If no URL pattern
+// matches, section.key is loaded instead.
+func (c *Client) GetURLConfig(section, key, rawurl string) string {
+	target, err := url.Parse(rawurl)
+	if err != nil {
+		return c.GetConfig(section + "." + key)
+	}
+	c.configMu.Lock()
+	c.loadConfigs()
+	var val string
+	best := 0
+	// Local config is considered last, so that it takes precedence over
+	// global config that's equally specific.
+	for _, config := range []*GitConfig{c.globalConfig, c.localConfig} {
+		for _, sect := range config.GetConfigSections(section, "") {
+			if sect.subsection == "" {
+				continue
+			}
+			v, ok := sect.values[key]
+			if !ok {
+				continue
+			}
+			if score := urlMatchScore(sect.subsection, target); score > 0 && score >= best {
+				val, best = v, score
+			}
+		}
+	}
+	c.configMu.Unlock()
+	if best == 0 {
+		return c.GetConfig(section + "." + key)
+	}
+	return val
+}
+
+// GetURLConfigAll loads every value of the multi-valued config variable
+// section.key from the sections which apply to rawurl, both section.key
+// itself and section.<url>.key for any URL pattern which matches, in the
+// order that they're set in. As with credential.helper in git, an empty
+// value clears the values before it.
+func (c *Client) GetURLConfigAll(section, key, rawurl string) []string {
+	target, err := url.Parse(rawurl)
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	c.loadConfigs()
+	var values []string
+	for _, config := range []*GitConfig{c.globalConfig, c.localConfig} {
+		for _, sect := range config.GetConfigSections(section, "") {
+			if sect.subsection != "" && (err != nil || urlMatchScore(sect.subsection, target) <= 0) {
+				continue
+			}
+			for _, e := range sect.entries {
+				if !strings.EqualFold(e.key, key) {
+					continue
+				}
+				if e.value == "" {
+					values = nil
+					continue
+				}
+				values = append(values, e.value)
+			}
+		}
+	}
+	return values
+}
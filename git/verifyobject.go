@@ -0,0 +1,256 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var HashMismatch error = errors.New("Hash mismatch")
+
+// A HashMismatchError is returned by VerifyObject when the content of an
+// object doesn't hash to its name. It matches HashMismatch with errors.Is.
+type HashMismatchError struct {
+	Object, Computed Sha1
+}
+
+func (e HashMismatchError) Error() string {
+	return fmt.Sprintf("hash mismatch for object %v: content hashes to %v", e.Object, e.Computed)
+}
+
+func (e HashMismatchError) Is(target error) bool {
+	return target == HashMismatch
+}
+
+// VerifyObject checks the integrity of the object id, whether it's loose
+// or packed. The object is read from disk and inflated, bypassing the
+// object cache, and its content must hash to id. Commits, trees and tags
+// must also be well formed, although the objects that they refer to
+// aren't looked up.
+func (c *Client) VerifyObject(id Sha1) error {
+	found, packfile, err := c.HaveObject(id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%v: object not found", id)
+	}
+
+	var typ string
+	var content []byte
+	if packfile != "" {
+		obj, err := c.getPackedObject(packfile, id, false)
+		if err != nil {
+			return err
+		}
+		typ, content = obj.GetType(), obj.GetContent()
+	} else {
+		if typ, content, err = readLooseObject(c.looseObjectFile(id)); err != nil {
+			return fmt.Errorf("%v: %v", id, err)
+		}
+	}
+
+	computed, _, err := HashSlice(typ, content)
+	if err != nil {
+		return err
+	}
+	if computed != id {
+		return HashMismatchError{Object: id, Computed: computed}
+	}
+
+	switch typ {
+	case "commit":
+		err = verifyCommitObject(content)
+	case "tree":
+		err = verifyTreeObject(content)
+	case "tag":
+		err = verifyTagObject(content)
+	}
+	if err != nil {
+		return fmt.Errorf("%v %v: %v", typ, id, err)
+	}
+	return nil
+}
+
+// Reads and inflates the loose object f, checking that the size in its
+// header matches its content.
+func readLooseObject(f File) (string, []byte, error) {
+	file, err := os.Open(f.String())
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+	z, err := zlib.NewReader(file)
+	if err != nil {
+		return "", nil, err
+	}
+	defer z.Close()
+	raw, err := ioutil.ReadAll(z)
+	if err != nil {
+		return "", nil, err
+	}
+
+	nul := bytes.IndexByte(raw, 0)
+	if nul < 0 {
+		return "", nil, fmt.Errorf("invalid object header")
+	}
+	header := strings.Fields(string(raw[:nul]))
+	if len(header) != 2 {
+		return "", nil, fmt.Errorf("invalid object header %q", raw[:nul])
+	}
+	switch header[0] {
+	case "commit", "tree", "blob", "tag":
+	default:
+		return "", nil, fmt.Errorf("invalid object type %q", header[0])
+	}
+	content := raw[nul+1:]
+	if sz, err := strconv.Atoi(header[1]); err != nil || sz != len(content) {
+		return "", nil, fmt.Errorf("object size %q does not match content size %d", header[1], len(content))
+	}
+	return header[0], content, nil
+}
+
+// Checks that the header line of an object has the form
+// "<name> <value>\n", returning the value and the remaining content.
+func verifyObjectHeader(content []byte, name string) (string, []byte, error) {
+	if !bytes.HasPrefix(content, []byte(name+" ")) {
+		return "", nil, fmt.Errorf("missing %v", name)
+	}
+	nl := bytes.IndexByte(content, '\n')
+	if nl < 0 {
+		return "", nil, fmt.Errorf("unterminated %v", name)
+	}
+	return string(content[len(name)+1 : nl]), content[nl+1:], nil
+}
+
+// Checks that ident has the form "Name <email> timestamp timezone".
+func verifyIdent(ident string) error {
+	lt := strings.IndexByte(ident, '<')
+	gt := strings.IndexByte(ident, '>')
+	if lt < 0 || gt < lt {
+		return fmt.Errorf("bad email in %q", ident)
+	}
+	date := strings.Fields(ident[gt+1:])
+	if len(date) != 2 {
+		return fmt.Errorf("bad date in %q", ident)
+	}
+	if _, err := strconv.ParseUint(date[0], 10, 64); err != nil {
+		return fmt.Errorf("bad date in %q", ident)
+	}
+	if tz := date[1]; len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return fmt.Errorf("bad timezone in %q", ident)
+	} else if _, err := strconv.ParseUint(tz[1:], 10, 16); err != nil {
+		return fmt.Errorf("bad timezone in %q", ident)
+	}
+	return nil
+}
+
+// Checks that a commit has a tree, valid parents, an author and a
+// committer, in that order.
+func verifyCommitObject(content []byte) error {
+	tree, content, err := verifyObjectHeader(content, "tree")
+	if err != nil {
+		return err
+	}
+	if _, err := Sha1FromString(tree); err != nil {
+		return fmt.Errorf("bad tree %q", tree)
+	}
+	for bytes.HasPrefix(content, []byte("parent ")) {
+		var parent string
+		if parent, content, err = verifyObjectHeader(content, "parent"); err != nil {
+			return err
+		}
+		if _, err := Sha1FromString(parent); err != nil {
+			return fmt.Errorf("bad parent %q", parent)
+		}
+	}
+	for _, name := range []string{"author", "committer"} {
+		var ident string
+		if ident, content, err = verifyObjectHeader(content, name); err != nil {
+			return err
+		}
+		if err := verifyIdent(ident); err != nil {
+			return fmt.Errorf("bad %v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Checks that each entry of a tree has a valid mode and name, and that
+// the entries are sorted in the order that git requires.
+func verifyTreeObject(content []byte) error {
+	var last string
+	for len(content) > 0 {
+		sp := bytes.IndexByte(content, ' ')
+		nul := bytes.IndexByte(content, 0)
+		if sp < 0 || nul < sp || len(content) < nul+21 {
+			return fmt.Errorf("truncated tree entry")
+		}
+		mode, name := string(content[:sp]), string(content[sp+1:nul])
+		content = content[nul+21:]
+
+		switch mode {
+		case "40000", "100644", "100755", "120000", "160000":
+		default:
+			return fmt.Errorf("bad mode %v for %q", mode, name)
+		}
+		if name == "" || name == "." || name == ".." || name == ".git" || strings.ContainsRune(name, '/') {
+			return fmt.Errorf("bad entry name %q", name)
+		}
+		// Trees are sorted as if their names end with a slash.
+		if mode == "40000" {
+			name += "/"
+		}
+		if last != "" && strings.TrimSuffix(last, "/") == strings.TrimSuffix(name, "/") {
+			return fmt.Errorf("duplicate entry %q", strings.TrimSuffix(name, "/"))
+		}
+		if name < last {
+			return fmt.Errorf("entries not sorted at %q", strings.TrimSuffix(name, "/"))
+		}
+		last = name
+	}
+	return nil
+}
+
+// Checks that a tag has an object, a type, a name and, if it has one, a
+// valid tagger.
+func verifyTagObject(content []byte) error {
+	obj, content, err := verifyObjectHeader(content, "object")
+	if err != nil {
+		return err
+	}
+	if _, err := Sha1FromString(obj); err != nil {
+		return fmt.Errorf("bad object %q", obj)
+	}
+	typ, content, err := verifyObjectHeader(content, "type")
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case "commit", "tree", "blob", "tag":
+	default:
+		return fmt.Errorf("bad type %q", typ)
+	}
+	name, content, err := verifyObjectHeader(content, "tag")
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("empty tag name")
+	}
+	if bytes.HasPrefix(content, []byte("tagger ")) {
+		tagger, _, err := verifyObjectHeader(content, "tagger")
+		if err != nil {
+			return err
+		}
+		if err := verifyIdent(tagger); err != nil {
+			return fmt.Errorf("bad tagger: %v", err)
+		}
+	}
+	return nil
+}
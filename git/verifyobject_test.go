@@ -0,0 +1,69 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestVerifyObject(t *testing.T) {
+	c, cleanup := testRepo(t, "gitverifyobject")
+	defer cleanup()
+	cmt := testCommitFile(t, c, "foo.txt", "foo\n", "First commit")
+	if err := TagCommit(c, TagOptions{Annotated: true}, "v1", nil, "Tag\n"); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := RefSpec("refs/tags/v1").Sha1(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := cmt.TreeID(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, _, err := HashSlice("blob", []byte("foo\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, delta := testDeltaPack(t, c, bytes.Repeat([]byte("base\n"), 100), "delta\n")
+	for _, id := range []Sha1{Sha1(cmt), Sha1(tree), blob, tag, base, delta} {
+		if err := c.VerifyObject(id); err != nil {
+			t.Errorf("Valid object %v failed verification: %v", id, err)
+		}
+	}
+
+	// Replace the content of the loose blob, as bit-rot would.
+	var corrupt bytes.Buffer
+	z := zlib.NewWriter(&corrupt)
+	z.Write([]byte("blob 4\000fob\n"))
+	z.Close()
+	f := c.looseObjectFile(blob)
+	if err := f.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(f.String(), corrupt.Bytes(), 0444); err != nil {
+		t.Fatal(err)
+	}
+	err = c.VerifyObject(blob)
+	if !errors.Is(err, HashMismatch) {
+		t.Errorf("Corrupted object %v did not fail with a hash mismatch: %v", blob, err)
+	}
+
+	for _, tc := range []struct {
+		typ, content string
+	}{
+		{"commit", "tree nothex\nauthor A <a@b> 0 +0000\ncommitter A <a@b> 0 +0000\n\nBad tree\n"},
+		{"commit", "tree " + tree.String() + "\ncommitter A <a@b> 0 +0000\n\nNo author\n"},
+		{"tree", "100644 b\000" + string(blob[:]) + "100644 a\000" + string(blob[:])},
+	} {
+		id, err := c.WriteObject(tc.typ, []byte(tc.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.VerifyObject(id); err == nil || errors.Is(err, HashMismatch) {
+			t.Errorf("Malformed %v %q did not fail verification: %v", tc.typ, tc.content, err)
+		}
+	}
+}
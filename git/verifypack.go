@@ -0,0 +1,317 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// VerifyPackOptions are the options which may be passed to VerifyPack.
+type VerifyPackOptions struct {
+	// Print the information about each object in the pack, followed
+	// by a histogram of delta chain lengths.
+	Verbose bool
+
+	// Only print the histogram of delta chain lengths.
+	StatOnly bool
+
+	// Where to print the output of Verbose or StatOnly. If nil,
+	// os.Stdout is used.
+	Output io.Writer
+}
+
+// PackObjectInfo describes an object stored in a packfile as reported by
+// VerifyPack.
+type PackObjectInfo struct {
+	Sha1 Sha1
+
+	// The type of the object after resolving any deltas.
+	Type PackEntryType
+
+	// The size of the object's data as stored in the pack, which is the
+	// size of the delta instructions for deltified objects.
+	Size uint64
+
+	// The number of bytes the object uses in the packfile, including
+	// its header.
+	PackedSize uint64
+
+	// The offset of the object in the packfile.
+	Offset int64
+
+	// The length of the delta chain, or 0 if the object isn't a delta.
+	Depth int
+
+	// The object that this object is a delta against, if Depth > 0.
+	Base Sha1
+}
+
+func (o PackObjectInfo) String() string {
+	s := fmt.Sprintf("%v %-6v %d %d %d", o.Sha1, o.Type, o.Size, o.PackedSize, o.Offset)
+	if o.Depth > 0 {
+		s += fmt.Sprintf(" %d %v", o.Depth, o.Base)
+	}
+	return s
+}
+
+// Reads a complete version 2 pack index, including its trailer.
+func readPackIndexV2(r io.Reader) (PackfileIndexV2, error) {
+	var pack PackfileIndexV2
+	if err := binary.Read(r, binary.BigEndian, &pack.magic); err != nil {
+		return pack, err
+	}
+	if pack.magic != [4]byte{0377, 't', 'O', 'c'} {
+		return pack, fmt.Errorf("Unsupported pack index format")
+	}
+	if err := binary.Read(r, binary.BigEndian, &pack.Version); err != nil {
+		return pack, err
+	}
+	if pack.Version != 2 {
+		return pack, fmt.Errorf("Unsupported pack index version: %d", pack.Version)
+	}
+	if err := binary.Read(r, binary.BigEndian, &pack.Fanout); err != nil {
+		return pack, err
+	}
+	pack.Sha1Table = make([]Sha1, pack.Fanout[255])
+	pack.CRC32 = make([]uint32, pack.Fanout[255])
+	pack.FourByteOffsets = make([]uint32, pack.Fanout[255])
+	if err := binary.Read(r, binary.BigEndian, pack.Sha1Table); err != nil {
+		return pack, err
+	}
+	if err := binary.Read(r, binary.BigEndian, pack.CRC32); err != nil {
+		return pack, err
+	}
+	if err := binary.Read(r, binary.BigEndian, pack.FourByteOffsets); err != nil {
+		return pack, err
+	}
+	for _, offset := range pack.FourByteOffsets {
+		if offset&(1<<31) != 0 {
+			var val uint64
+			if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+				return pack, err
+			}
+			pack.EightByteOffsets = append(pack.EightByteOffsets, val)
+		}
+	}
+	if err := binary.Read(r, binary.BigEndian, &pack.Packfile); err != nil {
+		return pack, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &pack.IdxFile); err != nil {
+		return pack, err
+	}
+	return pack, nil
+}
+
+// Returns the offset in the packfile of the i'th object in the index.
+func (idx PackfileIndexV2) objectOffset(i int) int64 {
+	if idx.FourByteOffsets[i]&(1<<31) != 0 {
+		return int64(idx.EightByteOffsets[idx.FourByteOffsets[i]^(1<<31)])
+	}
+	return int64(idx.FourByteOffsets[i])
+}
+
+// Calculates the SHA1 of the first size bytes of r, to compare against
+// the trailer of a pack or index file.
+func checksumPrefix(r io.ReaderAt, size int64) (Sha1, error) {
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return Sha1{}, err
+	}
+	return Sha1FromSlice(h.Sum(nil))
+}
+
+// Opens the pack index idx and its corresponding packfile, validates them,
+// and returns information about each object in the pack ordered by its
+// offset in the packfile.
+//
+// The checksums of both files are verified, as well as the CRC of each
+// object's packed data and that every delta's base can be found in the
+// pack.
+func VerifyPack(c *Client, opts VerifyPackOptions, idx File) ([]PackObjectInfo, error) {
+	if !strings.HasSuffix(idx.String(), ".idx") && !strings.HasSuffix(idx.String(), ".pack") {
+		return nil, fmt.Errorf("packfile name '%v' does not end with '.idx' or '.pack'", idx)
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(idx.String(), ".idx"), ".pack")
+	packname := base + ".pack"
+
+	idxfile, err := os.Open(base + ".idx")
+	if err != nil {
+		return nil, err
+	}
+	defer idxfile.Close()
+	index, err := readPackIndexV2(idxfile)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", idx, err)
+	}
+	istat, err := idxfile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if sum, err := checksumPrefix(idxfile, istat.Size()-20); err != nil {
+		return nil, err
+	} else if sum != index.IdxFile {
+		return nil, fmt.Errorf("%v: index checksum mismatch", base+".idx")
+	}
+
+	pack, err := os.Open(packname)
+	if err != nil {
+		return nil, err
+	}
+	defer pack.Close()
+	pstat, err := pack.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var header PackfileHeader
+	if err := binary.Read(pack, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Signature != [4]byte{'P', 'A', 'C', 'K'} {
+		return nil, fmt.Errorf("%v: invalid packfile signature", packname)
+	}
+	if int(header.Size) != len(index.Sha1Table) {
+		return nil, fmt.Errorf("%v: packfile has %d objects but index has %d", packname, header.Size, len(index.Sha1Table))
+	}
+	packEnd := pstat.Size() - 20
+	if packEnd < 12 {
+		return nil, fmt.Errorf("%v: packfile is truncated", packname)
+	}
+	if sum, err := checksumPrefix(pack, packEnd); err != nil {
+		return nil, err
+	} else if sum != index.Packfile {
+		return nil, fmt.Errorf("%v: pack checksum mismatch", packname)
+	}
+
+	objects := make([]PackObjectInfo, len(index.Sha1Table))
+	for i, sha := range index.Sha1Table {
+		objects[i] = PackObjectInfo{Sha1: sha, Offset: index.objectOffset(i)}
+	}
+	crcs := make(map[int64]uint32, len(objects))
+	for i := range objects {
+		crcs[objects[i].Offset] = index.CRC32[i]
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Offset < objects[j].Offset })
+
+	byOffset := make(map[int64]int, len(objects))
+	bySha := make(map[Sha1]int, len(objects))
+	for i, obj := range objects {
+		byOffset[obj.Offset] = i
+		bySha[obj.Sha1] = i
+	}
+
+	// The raw type of each object, before resolving deltas, and the
+	// index of its base in objects.
+	rawtypes := make([]PackEntryType, len(objects))
+	bases := make([]int, len(objects))
+	for i := range objects {
+		obj := &objects[i]
+		end := packEnd
+		if i+1 < len(objects) {
+			end = objects[i+1].Offset
+		}
+		obj.PackedSize = uint64(end - obj.Offset)
+
+		data := make([]byte, obj.PackedSize)
+		if _, err := pack.ReadAt(data, obj.Offset); err != nil {
+			return nil, err
+		}
+		if crc := crc32.ChecksumIEEE(data); crc != crcs[obj.Offset] {
+			return nil, fmt.Errorf("%v: CRC mismatch for object %v", packname, obj.Sha1)
+		}
+
+		t, sz, ref, refoffset, _, err := header.ReadHeaderSize(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", packname, err)
+		}
+		rawtypes[i] = t
+		obj.Size = uint64(sz)
+		bases[i] = -1
+		switch t {
+		case OBJ_OFS_DELTA:
+			b, ok := byOffset[obj.Offset-int64(refoffset)]
+			if !ok {
+				return nil, fmt.Errorf("%v: delta base offset for %v is not an object", packname, obj.Sha1)
+			}
+			bases[i] = b
+		case OBJ_REF_DELTA:
+			b, ok := bySha[ref]
+			if !ok {
+				return nil, fmt.Errorf("%v: delta base %v for %v is not in the pack", packname, ref, obj.Sha1)
+			}
+			bases[i] = b
+		case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+		default:
+			return nil, fmt.Errorf("%v: unknown object type %v for %v", packname, t, obj.Sha1)
+		}
+	}
+
+	// Resolve the type and depth of each object by following its delta
+	// chain.
+	for i := range objects {
+		depth := 0
+		for cur := i; bases[cur] != -1; cur = bases[cur] {
+			depth++
+			if depth > len(objects) {
+				return nil, fmt.Errorf("%v: delta chain for %v is a cycle", packname, objects[i].Sha1)
+			}
+			objects[i].Type = rawtypes[bases[cur]]
+		}
+		if depth == 0 {
+			objects[i].Type = rawtypes[i]
+		} else {
+			objects[i].Base = objects[bases[i]].Sha1
+		}
+		objects[i].Depth = depth
+	}
+
+	if opts.Verbose || opts.StatOnly {
+		w := opts.Output
+		if w == nil {
+			w = os.Stdout
+		}
+		if opts.Verbose {
+			for _, obj := range objects {
+				fmt.Fprintln(w, obj)
+			}
+		}
+		hist := PackChainHistogram(objects)
+		if hist[0] > 0 {
+			fmt.Fprintf(w, "non delta: %d %v\n", hist[0], pluralObjects(hist[0]))
+		}
+		for depth := 1; depth < len(hist); depth++ {
+			if hist[depth] > 0 {
+				fmt.Fprintf(w, "chain length = %d: %d %v\n", depth, hist[depth], pluralObjects(hist[depth]))
+			}
+		}
+		fmt.Fprintf(w, "%v: ok\n", packname)
+	}
+	return objects, nil
+}
+
+func pluralObjects(n int) string {
+	if n == 1 {
+		return "object"
+	}
+	return "objects"
+}
+
+// PackChainHistogram counts the objects by the length of their delta
+// chain, so that the value at index n is the number of objects with a
+// chain of length n. Index 0 is the number of objects which are not
+// deltas.
+func PackChainHistogram(objects []PackObjectInfo) []int {
+	var hist []int
+	for _, obj := range objects {
+		for len(hist) <= obj.Depth {
+			hist = append(hist, 0)
+		}
+		hist[obj.Depth]++
+	}
+	return hist
+}
@@ -0,0 +1,75 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyPack(t *testing.T) {
+	// The same pack as BenchmarkIndexPackFromFile, 3 blobs in an
+	// OFS_DELTA chain with a length of 2.
+	data := []byte{0x50, 0x41, 0x43, 0x4b, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0xbc, 0x08, 0x78, 0x9c,
+		0x73, 0xe4, 0x72, 0xc4, 0x09, 0x9d, 0xb8, 0x9c, 0xb9, 0x5c, 0xb8, 0x5c, 0xe9, 0x46, 0x03, 0x00,
+		0xcc, 0xc9, 0x15, 0x0f, 0x65, 0x18, 0x78, 0x9c, 0xeb, 0x61, 0x2c, 0x9a, 0x50, 0x04, 0x00, 0x05,
+		0xad, 0x02, 0x02, 0x65, 0x0f, 0x78, 0x9c, 0x2b, 0x4a, 0x9a, 0x28, 0x90, 0x04, 0x00, 0x05, 0xfc,
+		0x01, 0xd8, 0x75, 0xcc, 0x90, 0x92, 0xc3, 0xd9, 0x93, 0xba, 0xcf, 0xe4, 0x1d, 0x7c, 0xed, 0x5d,
+		0x8f, 0x46, 0xdf, 0xc2, 0x19, 0x0f,
+	}
+	gitdir, err := ioutil.TempDir("", "gitverifypack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitdir)
+	c, err := Init(nil, InitOptions{Quiet: true, Bare: true}, gitdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IndexAndCopyPack(c, IndexPackOptions{}, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	idxs, err := filepath.Glob(c.GitDir.File("objects/pack/*.idx").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idxs) != 1 {
+		t.Fatalf("Unexpected index files: %v", idxs)
+	}
+
+	var out bytes.Buffer
+	objs, err := VerifyPack(c, VerifyPackOptions{StatOnly: true, Output: &out}, File(idxs[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 3 {
+		t.Fatalf("Unexpected number of objects: got %v want 3", len(objs))
+	}
+	for _, obj := range objs {
+		if obj.Type != OBJ_BLOB {
+			t.Errorf("Unexpected type for %v: got %v want blob", obj.Sha1, obj.Type)
+		}
+	}
+	if objs[0].Depth != 0 || objs[1].Depth != 1 || objs[2].Depth != 2 {
+		t.Errorf("Unexpected delta chain: %v", objs)
+	}
+	if objs[2].Base != objs[1].Sha1 {
+		t.Errorf("Unexpected delta base: got %v want %v", objs[2].Base, objs[1].Sha1)
+	}
+	expected := "non delta: 1 object\nchain length = 1: 1 object\nchain length = 2: 1 object\n"
+	if got := out.String(); !strings.HasPrefix(got, expected) || !strings.HasSuffix(got, ".pack: ok\n") {
+		t.Errorf("Unexpected stat output: got %q want %q", got, expected)
+	}
+
+	// Corrupt the pack and make sure it's caught.
+	pack := strings.TrimSuffix(idxs[0], ".idx") + ".pack"
+	data[len(data)-25] ^= 0xff
+	if err := ioutil.WriteFile(pack, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyPack(c, VerifyPackOptions{}, File(pack)); err == nil {
+		t.Error("Expected error verifying corrupt pack")
+	}
+}
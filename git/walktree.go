@@ -0,0 +1,151 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SkipTree can be returned by the function passed to WalkTree to skip the
+// entries of the tree that it was called for. If it's returned for an
+// entry which isn't a tree, the remaining entries of the tree which
+// contains it are skipped.
+var SkipTree = errors.New("skip this tree")
+
+// A namedTreeEntry is a TreeEntry along with its name in the tree which
+// contains it.
+type namedTreeEntry struct {
+	Name string
+	TreeEntry
+}
+
+// Returns the name of e as it's compared for git's canonical tree order,
+// in which the entries are sorted as if the names of trees had a trailing
+// slash.
+func (e namedTreeEntry) sortName() string {
+	if e.FileMode == ModeTree {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+// Returns the entries of the tree t, in git's canonical order.
+func (t TreeID) entries(c *Client) ([]namedTreeEntry, error) {
+	o, err := c.GetObject(Sha1(t))
+	if err != nil {
+		return nil, err
+	}
+	if o.GetType() != "tree" {
+		return nil, fmt.Errorf("%s is not a tree object", t)
+	}
+	content := o.GetContent()
+	var entries []namedTreeEntry
+	for len(content) > 0 {
+		// The format of each tree entry is:
+		// 	[permission] [name] \0 [20 bytes of Sha1]
+		nul := bytes.IndexByte(content, 0)
+		if nul < 0 || nul+21 > len(content) {
+			return nil, fmt.Errorf("Invalid tree %v", t)
+		}
+		split := bytes.SplitN(content[:nul], []byte{' '}, 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("Invalid tree %v", t)
+		}
+		sha, err := Sha1FromSlice(content[nul+1 : nul+21])
+		if err != nil {
+			return nil, err
+		}
+		var mode EntryMode
+		switch string(split[0]) {
+		case "40000":
+			mode = ModeTree
+		case "100644":
+			mode = ModeBlob
+		case "100755":
+			mode = ModeExec
+		case "120000":
+			mode = ModeSymlink
+		case "160000":
+			mode = ModeGitlink
+		default:
+			return nil, fmt.Errorf("Unsupported mode %s in tree %v", split[0], t)
+		}
+		entries = append(entries, namedTreeEntry{string(split[1]), TreeEntry{sha, mode}})
+		content = content[nul+21:]
+	}
+	// Trees written by git are already in this order, but trees written
+	// by other tools may not be.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].sortName() < entries[j].sortName()
+	})
+	return entries, nil
+}
+
+// WalkTree calls fn for each entry of tree, recursing depth-first into its
+// subtrees in git's canonical order. The path passed to fn is relative to
+// the root of tree, and a tree is passed to fn before its entries.
+//
+// If fn returns SkipTree, the walk continues without the entries of the
+// tree (see SkipTree). Any other error stops the walk and is returned.
+func WalkTree(c *Client, tree Treeish, fn func(path string, entry TreeEntry) error) error {
+	t, err := tree.TreeID(c)
+	if err != nil {
+		return err
+	}
+	return walkTree(c, t, "", fn)
+}
+
+func walkTree(c *Client, t TreeID, prefix string, fn func(path string, entry TreeEntry) error) error {
+	entries, err := t.entries(c)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		path := prefix + e.Name
+		switch err := fn(path, e.TreeEntry); {
+		case err == SkipTree && e.FileMode == ModeTree:
+			continue
+		case err == SkipTree:
+			return nil
+		case err != nil:
+			return err
+		}
+		if e.FileMode == ModeTree {
+			if err := walkTree(c, TreeID(e.Sha1), path+"/", fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the entry for path in the tree t, and whether it was found.
+func (t TreeID) lookupPath(c *Client, path IndexPath) (TreeEntry, bool, error) {
+	components := strings.Split(path.String(), "/")
+	for i, name := range components {
+		entries, err := t.entries(c)
+		if err != nil {
+			return TreeEntry{}, false, err
+		}
+		var found *namedTreeEntry
+		for j := range entries {
+			if entries[j].Name == name {
+				found = &entries[j]
+				break
+			}
+		}
+		if found == nil {
+			return TreeEntry{}, false, nil
+		}
+		if i == len(components)-1 {
+			return found.TreeEntry, true, nil
+		}
+		if found.FileMode != ModeTree {
+			return TreeEntry{}, false, nil
+		}
+		t = TreeID(found.Sha1)
+	}
+	return TreeEntry{}, false, nil
+}
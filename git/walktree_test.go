@@ -0,0 +1,75 @@
+package git
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Tests that WalkTree visits a nested tree depth-first in git's canonical
+// order, where trees sort as if their names had a trailing slash, and that
+// SkipTree skips the rest of a tree.
+func TestWalkTree(t *testing.T) {
+	c, cleanup := testRepo(t, "gitwalktree")
+	defer cleanup()
+	for _, name := range []string{"b", "a0", "a/y/z", "a/x", "a.txt", "a-b"} {
+		testCommitFile(t, c, name, name+"\n", "Add "+name)
+	}
+	head, err := c.GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walk := func(skip string) []string {
+		t.Helper()
+		var visited []string
+		err := WalkTree(c, head, func(path string, entry TreeEntry) error {
+			visited = append(visited, path)
+			if path == skip {
+				return SkipTree
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return visited
+	}
+
+	want := []string{"a-b", "a.txt", "a", "a/x", "a/y", "a/y/z", "a0", "b"}
+	if got := walk(""); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected visit order: got %v want %v", got, want)
+	}
+	// Skipping a tree skips its entries.
+	want = []string{"a-b", "a.txt", "a", "a/x", "a/y", "a0", "b"}
+	if got := walk("a/y"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected visit order skipping a/y: got %v want %v", got, want)
+	}
+	// Skipping a file skips the rest of the tree which contains it.
+	want = []string{"a-b", "a.txt", "a", "a/x", "a0", "b"}
+	if got := walk("a/x"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected visit order skipping a/x: got %v want %v", got, want)
+	}
+
+	// ls-tree lists the entries in the same order.
+	entries, err := LsTree(c, LsTreeOptions{Recurse: true, ShowTrees: true}, head, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, entry := range entries {
+		listed = append(listed, entry.PathName.String())
+	}
+	if want := walk(""); !reflect.DeepEqual(listed, want) {
+		t.Errorf("Unexpected ls-tree order: got %v want %v", listed, want)
+	}
+
+	// Matches in a tree are prefixed by its name as it was given.
+	var out bytes.Buffer
+	if err := Grep(c, GrepOptions{Stdout: &out, TreeName: "HEAD~0"}, "z", head, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "HEAD~0:a/y/z: a/y/z\n"; got != want {
+		t.Errorf("Unexpected grep output for tree: got %q want %q", got, want)
+	}
+}
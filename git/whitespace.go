@@ -0,0 +1,486 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A WhitespaceRule is the set of whitespace problems which are errors in
+// the lines added by a patch, as configured by core.whitespace.
+type WhitespaceRule struct {
+	// Whitespace at the end of a line.
+	BlankAtEOL bool
+	// A space before a tab in the indentation of a line.
+	SpaceBeforeTab bool
+	// Indentation with TabWidth or more spaces, which could be tabs.
+	IndentWithNonTab bool
+	// A tab in the indentation of a line.
+	TabInIndent bool
+	// Blank lines added at the end of a file.
+	BlankAtEOF bool
+
+	// Don't treat a carriage return at the end of a line as trailing
+	// whitespace.
+	CRAtEOL bool
+
+	// The width of a tab for IndentWithNonTab. The 0 value is 8.
+	TabWidth int
+}
+
+// DefaultWhitespaceRule is the rule used when core.whitespace isn't set.
+var DefaultWhitespaceRule = WhitespaceRule{BlankAtEOL: true, SpaceBeforeTab: true, BlankAtEOF: true}
+
+// A WhitespaceError is the set of whitespace errors found in a line.
+type WhitespaceError uint8
+
+const (
+	WhitespaceBlankAtEOL = WhitespaceError(1 << iota)
+	WhitespaceSpaceBeforeTab
+	WhitespaceIndentWithNonTab
+	WhitespaceTabInIndent
+	WhitespaceBlankAtEOF
+)
+
+// Returns the errors in e as git describes them, separated by commas.
+func (e WhitespaceError) String() string {
+	var errs []string
+	if e&WhitespaceBlankAtEOL != 0 {
+		errs = append(errs, "trailing whitespace")
+	}
+	if e&WhitespaceSpaceBeforeTab != 0 {
+		errs = append(errs, "space before tab in indent")
+	}
+	if e&WhitespaceIndentWithNonTab != 0 {
+		errs = append(errs, "indent with spaces")
+	}
+	if e&WhitespaceTabInIndent != 0 {
+		errs = append(errs, "tab in indent")
+	}
+	if e&WhitespaceBlankAtEOF != 0 {
+		errs = append(errs, "new blank line at EOF")
+	}
+	return strings.Join(errs, ", ")
+}
+
+// ParseWhitespaceRule parses the value of core.whitespace, a comma
+// separated list of the problems to treat as errors. A problem prefixed
+// with "-" isn't an error, and the problems which aren't mentioned keep
+// their default. "trailing-space" is both blank-at-eol and blank-at-eof.
+// As in git, names which aren't known are ignored.
+func ParseWhitespaceRule(value string) (WhitespaceRule, error) {
+	rule := DefaultWhitespaceRule
+	for _, word := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		enable := !strings.HasPrefix(word, "-")
+		switch name := strings.TrimPrefix(word, "-"); name {
+		case "trailing-space":
+			rule.BlankAtEOL, rule.BlankAtEOF = enable, enable
+		case "blank-at-eol":
+			rule.BlankAtEOL = enable
+		case "blank-at-eof":
+			rule.BlankAtEOF = enable
+		case "space-before-tab":
+			rule.SpaceBeforeTab = enable
+		case "indent-with-non-tab":
+			rule.IndentWithNonTab = enable
+		case "tab-in-indent":
+			rule.TabInIndent = enable
+		case "cr-at-eol":
+			rule.CRAtEOL = enable
+		default:
+			if !strings.HasPrefix(name, "tabwidth=") {
+				continue
+			}
+			n, err := strconv.Atoi(strings.TrimPrefix(name, "tabwidth="))
+			if err != nil || n < 1 || n > 63 {
+				return WhitespaceRule{}, fmt.Errorf("tabwidth %v out of range", strings.TrimPrefix(name, "tabwidth="))
+			}
+			rule.TabWidth = n
+		}
+	}
+	if rule.TabInIndent && rule.IndentWithNonTab {
+		return WhitespaceRule{}, fmt.Errorf("cannot enforce both tab-in-indent and indent-with-non-tab")
+	}
+	return rule, nil
+}
+
+// Returns the whitespace rule from the core.whitespace config.
+func whitespaceRule(c *Client) (WhitespaceRule, error) {
+	return ParseWhitespaceRule(c.GetConfig("core.whitespace"))
+}
+
+func (r WhitespaceRule) tabWidth() int {
+	if r.TabWidth == 0 {
+		return 8
+	}
+	return r.TabWidth
+}
+
+// Returns whether b is whitespace, using git's definition.
+func isWhitespace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// Returns whether line is made up of only whitespace.
+func isBlankLine(line string) bool {
+	for i := 0; i < len(line); i++ {
+		if !isWhitespace(line[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check returns the whitespace errors in line, which is a line added by a
+// patch without its "+". It doesn't check for BlankAtEOF, which depends on
+// the lines after it.
+func (r WhitespaceRule) Check(line string) WhitespaceError {
+	return r.check(line, nil, "", "")
+}
+
+// Checks line the same way as Check, and if w isn't nil writes it to w
+// with the errors colored with wsColor and the rest with color, the same
+// way as git highlights them in a colored diff.
+func (r WhitespaceRule) check(line string, w io.Writer, color, wsColor string) WhitespaceError {
+	var result WhitespaceError
+	var eol string
+	length := len(line)
+	if length > 0 && line[length-1] == '\n' {
+		length--
+	}
+	if r.CRAtEOL && length > 0 && line[length-1] == '\r' {
+		length--
+	}
+	eol = line[length:]
+
+	trailing := length
+	if r.BlankAtEOL {
+		for trailing > 0 && isWhitespace(line[trailing-1]) {
+			trailing--
+		}
+		if trailing != length {
+			result |= WhitespaceBlankAtEOL
+		}
+	}
+
+	// written is the end of the part of the line which has already been
+	// written to w.
+	written := 0
+	i := 0
+	for ; i < trailing; i++ {
+		if line[i] == ' ' {
+			continue
+		}
+		if line[i] != '\t' {
+			break
+		}
+		switch {
+		case r.SpaceBeforeTab && written < i:
+			result |= WhitespaceSpaceBeforeTab
+			if w != nil {
+				io.WriteString(w, colorize(wsColor, line[written:i])+line[i:i+1])
+			}
+		case r.TabInIndent:
+			result |= WhitespaceTabInIndent
+			if w != nil {
+				io.WriteString(w, line[written:i]+colorize(wsColor, line[i:i+1]))
+			}
+		case w != nil:
+			io.WriteString(w, line[written:i+1])
+		}
+		written = i + 1
+	}
+	if r.IndentWithNonTab && i-written >= r.tabWidth() {
+		result |= WhitespaceIndentWithNonTab
+		if w != nil {
+			io.WriteString(w, colorize(wsColor, line[written:i]))
+		}
+		written = i
+	}
+	if w != nil {
+		if trailing > written {
+			io.WriteString(w, colorize(color, line[written:trailing]))
+		}
+		if trailing < length {
+			io.WriteString(w, colorize(wsColor, line[trailing:length]))
+		}
+		io.WriteString(w, eol)
+	}
+	return result
+}
+
+// Fix returns line with the whitespace errors that r detects removed, the
+// same way as "git apply --whitespace=fix". The indentation is rewritten
+// with tabs for SpaceBeforeTab or IndentWithNonTab, or with spaces for
+// TabInIndent, and trailing whitespace is removed.
+func (r WhitespaceRule) Fix(line string) string {
+	var eol string
+	src := line
+	if strings.HasSuffix(src, "\n") {
+		src, eol = src[:len(src)-1], "\n"
+	}
+	if r.BlankAtEOL {
+		if eol != "" && strings.HasSuffix(src, "\r") {
+			src = src[:len(src)-1]
+			if r.CRAtEOL {
+				eol = "\r\n"
+			}
+		}
+		src = strings.TrimRight(src, " \t\n\r")
+	}
+
+	lastTab, lastSpace := -1, -1
+	fixLeading := false
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\t' {
+			lastTab = i
+			if r.SpaceBeforeTab && lastSpace >= 0 {
+				fixLeading = true
+			}
+		} else if src[i] == ' ' {
+			lastSpace = i
+			if r.IndentWithNonTab && r.tabWidth() <= i-lastTab {
+				fixLeading = true
+			}
+		} else {
+			break
+		}
+	}
+
+	var dst strings.Builder
+	switch {
+	case fixLeading:
+		// The spaces before tabs are dropped, and with
+		// IndentWithNonTab each run of tabWidth spaces becomes a tab.
+		last := lastTab + 1
+		if r.IndentWithNonTab && lastTab < lastSpace {
+			last = lastSpace + 1
+		}
+		spaces := 0
+		for i := 0; i < last; i++ {
+			if src[i] != ' ' {
+				spaces = 0
+				dst.WriteByte(src[i])
+				continue
+			}
+			spaces++
+			if spaces == r.tabWidth() {
+				dst.WriteByte('\t')
+				spaces = 0
+			}
+		}
+		dst.WriteString(strings.Repeat(" ", spaces))
+		src = src[last:]
+	case r.TabInIndent && lastTab >= 0:
+		// Tabs in the indentation are expanded into spaces.
+		for i := 0; i <= lastTab; i++ {
+			if src[i] != '\t' {
+				dst.WriteByte(src[i])
+				continue
+			}
+			dst.WriteByte(' ')
+			for dst.Len()%r.tabWidth() != 0 {
+				dst.WriteByte(' ')
+			}
+		}
+		src = src[lastTab+1:]
+	}
+	dst.WriteString(src)
+	dst.WriteString(eol)
+	return dst.String()
+}
+
+var hunkHeaderRE = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@(.*)$`)
+
+// Parses the line counts from a hunk header line, which is assumed to
+// match hunkHeaderRE.
+func hunkCounts(m []string) (oldStart, oldCount, newStart, newCount int) {
+	count := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	oldStart, _ = strconv.Atoi(m[1])
+	newStart, _ = strconv.Atoi(m[3])
+	return oldStart, count(m[2]), newStart, count(m[4])
+}
+
+// A whitespaceChecker checks the lines added by patches for whitespace
+// errors, and reports them the way git apply does.
+type whitespaceChecker struct {
+	Rule WhitespaceRule
+
+	// Fix the errors in the patches, and remove blank lines added at
+	// the end of a file.
+	Fix bool
+
+	// Check the patches as they would be applied in reverse, so the
+	// lines which they remove are checked instead.
+	Reverse bool
+
+	// Where the errors are reported, and the number of them to report.
+	// If Max is 0 they're all reported.
+	W   io.Writer
+	Max int
+
+	// The number of lines with errors, and the number which were fixed.
+	Errors, Fixed int
+}
+
+func (ws *whitespaceChecker) report(name string, lineno int, err WhitespaceError, line string) {
+	ws.Errors++
+	if ws.Max == 0 || ws.Errors <= ws.Max {
+		fmt.Fprintf(ws.W, "%v:%d: %v.\n%v", name, lineno, err, line)
+		if !strings.HasSuffix(line, "\n") {
+			fmt.Fprintln(ws.W)
+		}
+	}
+}
+
+// Reports the number of errors which weren't reported because of Max.
+func (ws *whitespaceChecker) printSquelched() {
+	if ws.Max > 0 && ws.Errors > ws.Max {
+		n := ws.Errors - ws.Max
+		fmt.Fprintf(ws.W, "warning: squelched %d whitespace %v\n", n, plural(n, "error", "errors"))
+	}
+}
+
+// Returns singular if n is 1, and otherwise plural.
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
+
+// Checks the lines which patch, a unified diff named name, adds, and
+// returns the patch with the errors fixed if ws.Fix is set.
+func (ws *whitespaceChecker) checkPatch(name, patch string) string {
+	rule := ws.Rule
+	add := byte('+')
+	if ws.Reverse {
+		add = '-'
+	}
+	lines := strings.SplitAfter(patch, "\n")
+	var out strings.Builder
+	for i := 0; i < len(lines); i++ {
+		m := hunkHeaderRE.FindStringSubmatch(strings.TrimRight(lines[i], "\n"))
+		if m == nil {
+			out.WriteString(lines[i])
+			continue
+		}
+		oldStart, oldCount, newStart, newCount := hunkCounts(m)
+		remainingOld, remainingNew := oldCount, newCount
+		// The lines of the hunk after its header, and the index of the
+		// first blank line which it adds after its last context line.
+		var hunk []string
+		blankStart := -1
+		trailingContext := 0
+		j := i + 1
+	hunkLines:
+		for ; j < len(lines) && (remainingOld > 0 || remainingNew > 0 || strings.HasPrefix(lines[j], "\\")); j++ {
+			line := lines[j]
+			if line == "" {
+				break
+			}
+			switch line[0] {
+			case ' ', '\n':
+				remainingOld--
+				remainingNew--
+				trailingContext++
+				blankStart = -1
+			case '+', '-':
+				if line[0] == '+' {
+					remainingNew--
+				} else {
+					remainingOld--
+				}
+				if line[0] != add {
+					break
+				}
+				trailingContext = 0
+				content := line[1:]
+				if isBlankLine(content) {
+					if blankStart < 0 {
+						blankStart = len(hunk)
+					}
+				} else {
+					blankStart = -1
+				}
+				if err := rule.Check(content); err != 0 {
+					ws.report(name, j+1, err, content)
+					if ws.Fix {
+						line = string(add) + rule.Fix(content)
+						ws.Fixed++
+					}
+				}
+			case '\\':
+				// "\ No newline at end of file"
+			default:
+				// The hunk is shorter than its header says.
+				break hunkLines
+			}
+			hunk = append(hunk, line)
+		}
+		if rule.BlankAtEOF && blankStart >= 0 && trailingContext == 0 {
+			// The first blank line is reported with its "+", since
+			// it's otherwise empty.
+			lineno := i + 2 + blankStart
+			ws.report(name, lineno, WhitespaceBlankAtEOF, hunk[blankStart])
+			if ws.Fix {
+				removed := len(hunk) - blankStart
+				hunk = hunk[:blankStart]
+				if add == '+' {
+					newCount -= removed
+				} else {
+					oldCount -= removed
+				}
+				lines[i] = fmt.Sprintf("@@ -%d,%d +%d,%d @@%s\n", oldStart, oldCount, newStart, newCount, m[5])
+			}
+		}
+		out.WriteString(lines[i])
+		for _, line := range hunk {
+			out.WriteString(line)
+		}
+		i = j - 1
+	}
+	return out.String()
+}
+
+// Returns the indexes of the lines of a patch, split after each newline,
+// which are blank lines added at the end of a file. They're the blank
+// lines which a hunk adds after its last line of context.
+func blankLinesAtEOF(lines []string) map[int]bool {
+	blank := make(map[int]bool)
+	var run []int
+	inHunk := false
+	endHunk := func() {
+		for _, i := range run {
+			blank[i] = true
+		}
+		run = nil
+	}
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			endHunk()
+			inHunk = true
+		case !inHunk || strings.HasPrefix(line, "\\"):
+		case strings.HasPrefix(line, "+") && isBlankLine(line[1:]):
+			run = append(run, i)
+		case strings.HasPrefix(line, "+"), strings.HasPrefix(line, "-"), strings.HasPrefix(line, " "):
+			run = nil
+		default:
+			endHunk()
+			inHunk = false
+		}
+	}
+	endHunk()
+	return blank
+}
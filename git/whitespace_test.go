@@ -0,0 +1,47 @@
+package git
+
+import (
+	"testing"
+)
+
+// Tests that whitespace errors are detected according to the rule, and
+// that Fix removes them.
+func TestWhitespaceRule(t *testing.T) {
+	indent, err := ParseWhitespaceRule("indent-with-non-tab,-space-before-tab,tabwidth=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rule WhitespaceRule
+		line string
+		want WhitespaceError
+		fix  string
+	}{
+		{DefaultWhitespaceRule, "foo\n", 0, "foo\n"},
+		{DefaultWhitespaceRule, "foo  \n", WhitespaceBlankAtEOL, "foo\n"},
+		{DefaultWhitespaceRule, "foo\t", WhitespaceBlankAtEOL, "foo"},
+		{DefaultWhitespaceRule, "foo\r\n", WhitespaceBlankAtEOL, "foo\n"},
+		{WhitespaceRule{BlankAtEOL: true, CRAtEOL: true}, "foo\r\n", 0, "foo\r\n"},
+		{DefaultWhitespaceRule, "  \tfoo\n", WhitespaceSpaceBeforeTab, "\tfoo\n"},
+		{DefaultWhitespaceRule, " \tfoo \n", WhitespaceSpaceBeforeTab | WhitespaceBlankAtEOL, "\tfoo\n"},
+		{DefaultWhitespaceRule, "        foo\n", 0, "        foo\n"},
+		{indent, "     foo\n", WhitespaceIndentWithNonTab, "\t foo\n"},
+		{indent, " \tfoo\n", 0, " \tfoo\n"},
+		{WhitespaceRule{TabInIndent: true}, "\t foo\n", WhitespaceTabInIndent, "         foo\n"},
+	}
+	for i, tc := range tests {
+		if got := tc.rule.Check(tc.line); got != tc.want {
+			t.Errorf("Case %d: got %q want %q", i, got, tc.want)
+		}
+		if got := tc.rule.Fix(tc.line); got != tc.fix {
+			t.Errorf("Case %d: got fix %q want %q", i, got, tc.fix)
+		}
+	}
+
+	if _, err := ParseWhitespaceRule("tab-in-indent,indent-with-non-tab"); err == nil {
+		t.Error("Conflicting whitespace rules were accepted")
+	}
+	if rule, err := ParseWhitespaceRule("-trailing-space"); err != nil || rule.BlankAtEOL || rule.BlankAtEOF || !rule.SpaceBeforeTab {
+		t.Errorf("Unexpected rule for -trailing-space: %+v (%v)", rule, err)
+	}
+}
@@ -0,0 +1,194 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WorktreePruneOptions are the options which may be passed to
+// "git worktree prune".
+type WorktreePruneOptions struct {
+	// Report what would be pruned without removing anything.
+	DryRun bool
+
+	// Only prune worktrees whose administrative files haven't been
+	// modified since Expire. The zero value prunes them regardless of
+	// age.
+	Expire time.Time
+}
+
+// Returns the administrative directory for the linked worktree id.
+func (gd GitDir) worktreeDir(id string) File {
+	return File(filepath.Join(gd.String(), "worktrees", id))
+}
+
+// Returns the reason that the administrative files for the linked worktree
+// id should be pruned, or the empty string if they should be kept.
+func worktreePruneReason(c *Client, opts WorktreePruneOptions, id string) (string, error) {
+	dir := c.GitDir.worktreeDir(id)
+	if !dir.IsDir() {
+		return "not a valid directory", nil
+	}
+	if File(filepath.Join(dir.String(), "locked")).Exists() {
+		return "", nil
+	}
+	gitdir := File(filepath.Join(dir.String(), "gitdir"))
+	info, err := os.Stat(gitdir.String())
+	if os.IsNotExist(err) {
+		return "gitdir file does not exist", nil
+	} else if err != nil {
+		return "", err
+	}
+	path, err := gitdir.ReadFirstLine()
+	if err != nil {
+		return "", err
+	}
+	if path = strings.TrimSpace(path); path == "" {
+		return "invalid gitdir file", nil
+	}
+	if File(path).Exists() {
+		return "", nil
+	}
+	if !opts.Expire.IsZero() && info.ModTime().After(opts.Expire) {
+		return "", nil
+	}
+	return "gitdir file points to non-existent location", nil
+}
+
+// WorktreePrune removes the administrative files under $GIT_DIR/worktrees
+// for linked worktrees whose directory is gone. Worktrees which are locked
+// are never pruned. It returns a message for each worktree pruned in the
+// same format as "git worktree prune --verbose".
+func WorktreePrune(c *Client, opts WorktreePruneOptions) ([]string, error) {
+	worktrees := filepath.Join(c.GitDir.String(), "worktrees")
+	entries, err := ioutil.ReadDir(worktrees)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pruned []string
+	for _, e := range entries {
+		reason, err := worktreePruneReason(c, opts, e.Name())
+		if err != nil {
+			return pruned, err
+		}
+		if reason == "" {
+			continue
+		}
+		pruned = append(pruned, fmt.Sprintf("Removing worktrees/%v: %v", e.Name(), reason))
+		if opts.DryRun {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(worktrees, e.Name())); err != nil {
+			return pruned, err
+		}
+	}
+	if !opts.DryRun && len(pruned) == len(entries) {
+		// Like git, don't leave an empty worktrees directory behind.
+		if err := os.Remove(worktrees); err != nil {
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
+
+// Reads the directory that the .git file at dotgit points to.
+func readGitFile(dotgit File) (string, error) {
+	line, err := dotgit.ReadFirstLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "gitdir: ") {
+		return "", fmt.Errorf("Invalid gitfile format: %v", dotgit)
+	}
+	gitdir := strings.TrimSpace(strings.TrimPrefix(line, "gitdir: "))
+	if !filepath.IsAbs(gitdir) {
+		gitdir = filepath.Join(filepath.Dir(dotgit.String()), gitdir)
+	}
+	return gitdir, nil
+}
+
+// WorktreeRepair repairs the links between the repository and its linked
+// worktrees, for instance after either of them were moved. The .git file of
+// each linked worktree that the repository knows about is pointed back at
+// the repository, and the repository's record of each worktree in paths is
+// updated to its current location. It returns a message describing each
+// repair.
+func WorktreeRepair(c *Client, paths []File) ([]string, error) {
+	var repairs []string
+	worktrees := filepath.Join(c.GitDir.String(), "worktrees")
+	absworktrees, err := filepath.Abs(worktrees)
+	if err != nil {
+		return nil, err
+	}
+
+	// Repair the .git files of the worktrees whose location is known.
+	entries, err := ioutil.ReadDir(worktrees)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		admin := filepath.Join(absworktrees, e.Name())
+		path, err := File(filepath.Join(admin, "gitdir")).ReadFirstLine()
+		if err != nil {
+			continue
+		}
+		dotgit := File(strings.TrimSpace(path))
+		if dotgit.IsDir() || !File(filepath.Dir(dotgit.String())).IsDir() {
+			// It's not a linked worktree, or it's gone.
+			continue
+		}
+		if target, err := readGitFile(dotgit); err == nil && filepath.Clean(target) == admin {
+			continue
+		} else if err != nil {
+			repairs = append(repairs, fmt.Sprintf("repair: .git file broken: %v", filepath.Dir(dotgit.String())))
+		} else {
+			repairs = append(repairs, fmt.Sprintf("repair: .git file incorrect: %v", filepath.Dir(dotgit.String())))
+		}
+		if err := ioutil.WriteFile(dotgit.String(), []byte("gitdir: "+admin+"\n"), 0644); err != nil {
+			return repairs, err
+		}
+	}
+
+	// Point the repository at the worktrees which were moved.
+	for _, p := range paths {
+		abs, err := filepath.Abs(p.String())
+		if err != nil {
+			return repairs, err
+		}
+		dotgit := File(filepath.Join(abs, ".git"))
+		target, err := readGitFile(dotgit)
+		if err != nil {
+			return repairs, fmt.Errorf("%v: not a valid worktree: %v", p, err)
+		}
+		// If the repository moved, the .git file points to where it
+		// used to be, but the worktree's id is still the last
+		// element.
+		id := filepath.Base(target)
+		admin := filepath.Join(absworktrees, id)
+		if !File(admin).IsDir() {
+			return repairs, fmt.Errorf("%v: not a worktree of this repository", p)
+		}
+		if filepath.Clean(target) != admin {
+			repairs = append(repairs, fmt.Sprintf("repair: .git file incorrect: %v", abs))
+			if err := ioutil.WriteFile(dotgit.String(), []byte("gitdir: "+admin+"\n"), 0644); err != nil {
+				return repairs, err
+			}
+		}
+		gitdir := File(filepath.Join(admin, "gitdir"))
+		if old, err := gitdir.ReadFirstLine(); err == nil && strings.TrimSpace(old) == dotgit.String() {
+			continue
+		}
+		repairs = append(repairs, fmt.Sprintf("repair: gitdir incorrect: %v", gitdir))
+		if err := ioutil.WriteFile(gitdir.String(), []byte(dotgit.String()+"\n"), 0644); err != nil {
+			return repairs, err
+		}
+	}
+	return repairs, nil
+}
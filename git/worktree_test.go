@@ -0,0 +1,109 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorktreePruneRepair(t *testing.T) {
+	c, cleanup := testRepo(t, "gitworktree")
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "gitworktreelinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gitdir, err := filepath.Abs(c.GitDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sets up a linked worktree the same way as "git worktree add".
+	addWorktree := func(id string) string {
+		t.Helper()
+		wt := filepath.Join(dir, id)
+		admin := filepath.Join(gitdir, "worktrees", id)
+		if err := os.MkdirAll(wt, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(admin, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(wt, ".git"), []byte("gitdir: "+admin+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(admin, "gitdir"), []byte(filepath.Join(wt, ".git")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return wt
+	}
+	deleted := addWorktree("deleted")
+	moved := addWorktree("moved")
+	locked := addWorktree("locked")
+	addWorktree("kept")
+	if err := ioutil.WriteFile(filepath.Join(gitdir, "worktrees", "locked", "locked"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, wt := range []string{deleted, locked} {
+		if err := os.RemoveAll(wt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newpath := filepath.Join(dir, "newlocation")
+	if err := os.Rename(moved, newpath); err != nil {
+		t.Fatal(err)
+	}
+
+	// The moved worktree is repaired before pruning, so that it's not
+	// mistaken for one that was deleted.
+	repairs, err := WorktreeRepair(c, []File{File(newpath)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repairs) != 1 {
+		t.Errorf("Unexpected repairs: %v", repairs)
+	}
+	if got, err := File(filepath.Join(gitdir, "worktrees", "moved", "gitdir")).ReadFirstLine(); err != nil || got != filepath.Join(newpath, ".git") {
+		t.Errorf("Moved worktree was not repaired: got %v (%v)", got, err)
+	}
+
+	// Entries aren't pruned until they're older than the expiry time.
+	if pruned, err := WorktreePrune(c, WorktreePruneOptions{Expire: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)}); err != nil || len(pruned) != 0 {
+		t.Errorf("Pruned worktrees newer than the expiry: %v (%v)", pruned, err)
+	}
+	pruned, err := WorktreePrune(c, WorktreePruneOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || !strings.HasPrefix(pruned[0], "Removing worktrees/deleted:") {
+		t.Errorf("Unexpected worktrees to prune: %v", pruned)
+	}
+	if !File(filepath.Join(gitdir, "worktrees", "deleted")).Exists() {
+		t.Error("Dry run removed a worktree")
+	}
+	if _, err := WorktreePrune(c, WorktreePruneOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]bool{"deleted": false, "moved": true, "locked": true, "kept": true} {
+		if got := File(filepath.Join(gitdir, "worktrees", id)).Exists(); got != want {
+			t.Errorf("Unexpected existence of worktree %v: got %v want %v", id, got, want)
+		}
+	}
+
+	// If the repository moves, the worktrees' .git files are repaired
+	// from the repository.
+	if err := ioutil.WriteFile(filepath.Join(newpath, ".git"), []byte("gitdir: /nonexistent/.git/worktrees/moved\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WorktreeRepair(c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := readGitFile(File(filepath.Join(newpath, ".git"))); err != nil || target != filepath.Join(gitdir, "worktrees", "moved") {
+		t.Errorf("Worktree .git file was not repaired: got %v (%v)", target, err)
+	}
+}
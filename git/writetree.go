@@ -1,7 +1,6 @@
 package git
 
 import (
-	"bytes"
 	"fmt"
 	"log"
 	"strings"
@@ -12,174 +11,153 @@ type WriteTreeOptions struct {
 	Prefix    string
 }
 
+// WriteTree writes a tree from the index of c. The trees written are saved
+// in the cache-tree extension of the index, so that the ones that haven't
+// changed don't need to be written again the next time.
 func WriteTree(c *Client, opts WriteTreeOptions) (TreeID, error) {
-	idx, err := c.GitDir.ReadIndex()
+	// The updated cache-tree is only an optimization, so if the index
+	// can't be locked the tree is still written without saving it.
+	idx, lock, err := c.GitDir.LockIndex()
+	if _, ok := err.(LockError); ok {
+		log.Printf("Could not update cache-tree: %v\n", err)
+		idx, err = c.GitDir.ReadIndex()
+	}
+	if err != nil {
+		return TreeID{}, err
+	}
+	defer lock.Rollback()
+	valid := idx.cacheTree.valid()
+	tree, err := WriteTreeFromIndex(c, idx, opts)
 	if err != nil {
 		return TreeID{}, err
 	}
-	return WriteTreeFromIndex(c, idx, opts)
+	if lock != nil && !valid && idx.cacheTree.valid() {
+		if err := idx.CommitLocked(lock); err != nil {
+			log.Printf("Could not update cache-tree: %v\n", err)
+		}
+	}
+	return tree, nil
 }
 
+// WriteTreeID is the same as WriteTree, but returns the ID of the tree in
+// the hash algorithm of the repository, which may be SHA-256. Index files
+// with SHA-256 IDs can't be read yet, so in a SHA-256 repository the index
+// must not have any SHA-1 entries.
+func WriteTreeID(c *Client, opts WriteTreeOptions) (ObjectID, error) {
+	if c.HashAlgorithm() == HashSHA1 {
+		tree, err := WriteTree(c, opts)
+		return Sha1(tree).ObjectID(), err
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		return ObjectID{}, err
+	}
+	return WriteTreeIDFromIndex(c, idx, opts)
+}
+
+// WriteTreeFromIndex writes a tree from idx. If the cache-tree extension of
+// idx has valid trees for the parts of the index being written, they're used
+// instead of writing the trees again. When writing the whole index, the
+// cache-tree of idx is updated with the trees that were written.
 func WriteTreeFromIndex(c *Client, idx *Index, opts WriteTreeOptions) (TreeID, error) {
+	id, err := WriteTreeIDFromIndex(c, idx, opts)
+	if err != nil {
+		return TreeID{}, err
+	}
+	tree, err := id.Sha1()
+	return TreeID(tree), err
+}
+
+// WriteTreeIDFromIndex is the same as WriteTreeFromIndex, but writes the
+// trees with the hash algorithm of the repository. The cache-tree extension
+// only has SHA-1 trees, so it's neither used nor updated with other
+// algorithms.
+func WriteTreeIDFromIndex(c *Client, idx *Index, opts WriteTreeOptions) (ObjectID, error) {
+	sha1 := c.HashAlgorithm() == HashSHA1
 	objs := idx.Objects
 	if opts.Prefix != "" {
 		opts.Prefix = strings.TrimRight(opts.Prefix, "/")
-		// If there's a prefix, take the slice of the index which
-		// starts with that prefix.
-		prefixStart := -1
+		// If there's a prefix, take the slice of the index which is
+		// under that directory. The index is sorted, so the entries
+		// are contiguous.
+		dir := opts.Prefix + "/"
+		prefixStart, prefixEnd := -1, len(idx.Objects)
 		for i, obj := range idx.Objects {
-			path := obj.PathName.String()
-			if strings.HasPrefix(path, opts.Prefix) {
+			if strings.HasPrefix(obj.PathName.String(), dir) {
 				if prefixStart < 0 {
 					prefixStart = i
 				}
-			} else {
-				if prefixStart >= 0 {
-					objs = idx.Objects[prefixStart:i]
-					break
-				}
+			} else if prefixStart >= 0 {
+				prefixEnd = i
+				break
 			}
 		}
 		if prefixStart == -1 {
-			return TreeID{}, fmt.Errorf("prefix %v not found", opts.Prefix)
+			return ObjectID{}, fmt.Errorf("prefix %v not found", opts.Prefix)
+		}
+		objs = idx.Objects[prefixStart:prefixEnd]
+	}
+	if cached := idx.cacheTree.lookup(opts.Prefix); sha1 && cached.valid() && cached.entryCount == len(objs) {
+		if ok, err := c.haveObjectID(cached.tree); err == nil && ok {
+			return cached.tree, nil
 		}
 	}
 	if !opts.MissingOk {
 		// Verify that every blob being referenced exists unless "missing-ok" was
 		// specified
 		for _, obj := range objs {
-			ok, _, err := c.HaveObject(obj.Sha1)
+			if obj.Mode == ModeGitlink {
+				// The commit is in the submodule's repository,
+				// not ours.
+				continue
+			}
+			ok, err := c.haveObjectID(obj.ObjectID())
 			if err != nil {
-				return TreeID{}, err
+				return ObjectID{}, err
 			}
 			if !ok {
-				return TreeID{}, fmt.Errorf("invalid object %o %v for '%v'", obj.Mode, obj.Sha1, obj.PathName)
+				return ObjectID{}, fmt.Errorf("invalid object %o %v for '%v'", obj.Mode, obj.ObjectID(), obj.PathName)
 			}
 		}
 	}
-	return writeTree(c, opts.Prefix, objs)
+	if opts.Prefix != "" || !sha1 {
+		return writeTreeID(c, opts.Prefix, objs)
+	}
+	node, err := writeCacheTree(c, idx.cacheTree, "", objs)
+	if err != nil {
+		return ObjectID{}, err
+	}
+	idx.cacheTree = node
+	return node.tree, nil
 }
 
+// Writes the tree for the entries under prefix, without using or updating
+// a cache-tree.
 func writeTree(c *Client, prefix string, entries []*IndexEntry) (TreeID, error) {
-	content := bytes.NewBuffer(nil)
-	// [mode] [file/folder name]\0[SHA-1 of referencing blob or tree as [20]byte]
-
-	lastname := ""
-	firstIdxForTree := -1
-
-	log.Printf("Prefix: %v\n", prefix)
-	for idx, obj := range entries {
-		if !strings.HasPrefix(string(obj.PathName), prefix) {
-			continue
-		}
-		if obj.Stage() != Stage0 {
-			return TreeID{}, fmt.Errorf("Could not write index with unmerged entries")
-		}
-		relativename := strings.TrimPrefix(obj.PathName.String(), prefix+"/")
-		nameBits := strings.Split(relativename, "/")
-
-		if len(nameBits) == 0 {
-			panic("did not reach base case for subtree recursion")
-		} else if len(nameBits) == 1 {
-			// Base case: we've recursed all the way down to there being no prefix.
-			// write the blob to the tree.
-			if firstIdxForTree >= 0 {
-				var newPrefix string
-				if prefix == "" {
-					newPrefix = lastname
-				} else {
-					newPrefix = prefix + "/" + lastname
-				}
-
-				// Get the index entries which compose this tree
-				var islice []*IndexEntry
-				islice = entries[firstIdxForTree:idx]
-
-				// Get the sha1 for the tree with the prefix stripped.
-				subsha1, err := writeTree(c, newPrefix, islice)
-				if err != nil {
-					panic(err)
-				}
-
-				// Write the object
-				fmt.Fprintf(content, "%o %s\x00", 0040000, lastname)
-				content.Write(subsha1[:])
-
-			}
-			fmt.Fprintf(content, "%o %s\x00", obj.Mode, nameBits[0])
-			content.Write(obj.Sha1[:])
-			lastname = ""
-			firstIdxForTree = -1
-		} else if (nameBits[0] != lastname && lastname != "") || idx == len(entries)-1 {
-			// Either the name of the first part of the directory changed or we've reached
-			// the end. Either way, we've found the end of this tree, so write it out.
-			var newPrefix string
-			if prefix == "" {
-				newPrefix = lastname
-			} else {
-				newPrefix = prefix + "/" + lastname
-			}
-
-			// Get the index entries which compose this tree
-			var islice []*IndexEntry
-			if firstIdxForTree == -1 {
-				// There was only 1 entry.
-				islice = entries[idx : idx+1]
-				if prefix == "" {
-					newPrefix = nameBits[0]
-				} else {
-					newPrefix = prefix + "/" + nameBits[0]
-				}
-				lastname = nameBits[0]
-			} else if idx == len(entries)-1 && nameBits[0] == lastname {
-				// We've reached the end, so the slice goes from the first index
-				// to the end
-				islice = entries[firstIdxForTree:]
-			} else {
-				// the name changed, so the slice goes from the first index until
-				// this index.
-				islice = entries[firstIdxForTree:idx]
-			}
-
-			// Get the sha1 for the tree with the prefix stripped.
-			subsha1, err := writeTree(c, newPrefix, islice)
-			if err != nil {
-				panic(err)
-			}
-
-			// Write the object
-			fmt.Fprintf(content, "%o %s\x00", 0040000, lastname)
-			content.Write(subsha1[:])
-
-			if idx == len(entries)-1 && lastname != nameBits[0] {
-				var newPrefix string
-				if prefix == "" {
-					newPrefix = nameBits[0]
-				} else {
-					newPrefix = prefix + "/" + nameBits[0]
-				}
-				subsha1, err := writeTree(c, newPrefix, entries[len(entries)-1:])
-				if err != nil {
-					panic(err)
-				}
+	id, err := writeTreeID(c, prefix, entries)
+	if err != nil {
+		return TreeID{}, err
+	}
+	tree, err := id.Sha1()
+	return TreeID(tree), err
+}
 
-				// Write the object
-				fmt.Fprintf(content, "%o %s\x00", 0040000, nameBits[0])
-				content.Write(subsha1[:])
-			}
-			// Reset the data keeping track of what this tree is.
-			lastname = nameBits[0]
-			firstIdxForTree = idx
-		} else {
-			// Keep track of the last thing we saw for the next loop iteration.
-			lastname = nameBits[0]
-			if firstIdxForTree == -1 {
-				// This is the first thing we saw since the last tree.
-				firstIdxForTree = idx
-			}
+// Writes the tree for the entries under prefix in the hash algorithm of the
+// repository, which is returned, without using or updating a cache-tree.
+func writeTreeID(c *Client, prefix string, entries []*IndexEntry) (ObjectID, error) {
+	dir := ""
+	if prefix != "" {
+		dir = prefix + "/"
+	}
+	objs := make([]*IndexEntry, 0, len(entries))
+	for _, obj := range entries {
+		if strings.HasPrefix(obj.PathName.String(), dir) {
+			objs = append(objs, obj)
 		}
 	}
-
-	sha1, err := c.WriteObject("tree", content.Bytes())
-	return TreeID(sha1), err
+	node, err := writeCacheTree(c, nil, dir, objs)
+	if err != nil {
+		return ObjectID{}, err
+	}
+	return node.tree, nil
 }
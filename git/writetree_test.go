@@ -3,6 +3,7 @@ package git
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -328,6 +329,29 @@ func TestWriteIndex(t *testing.T) {
 			false,
 			"",
 		},
+		// A file and a gitlink to a submodule commit in a subdirectory.
+		{
+			[]*IndexEntry{
+				&IndexEntry{
+					PathName: IndexPath("bar"),
+					FixedIndexEntry: FixedIndexEntry{
+						Mode:  ModeBlob,
+						Fsize: 4,
+						Sha1:  hashString("bar\n"),
+					},
+				},
+				&IndexEntry{
+					PathName: IndexPath("lib/sub"),
+					FixedIndexEntry: FixedIndexEntry{
+						Mode: ModeGitlink,
+						Sha1: unsafeSha1FromString("8476ca7ecd5f56b5f7016740fc00f2c0e8479f65"),
+					},
+				},
+			},
+			"d57e2f310847f7cc056c82705684103a33ab79e4",
+			false,
+			"",
+		},
 		// An index with any non-stage0 entry should produce an error
 		{
 			[]*IndexEntry{
@@ -494,4 +518,268 @@ func TestWriteIndex(t *testing.T) {
 			t.Errorf("Unexpected hash for test case %d: got %v want %v", i, treeid, expected)
 		}
 	}
+
+	// The commit for a gitlink is in the submodule, so it shouldn't
+	// need to exist in the repository even without MissingOk.
+	idx := NewIndex()
+	idx.Objects = []*IndexEntry{
+		&IndexEntry{
+			PathName: IndexPath("lib/sub"),
+			FixedIndexEntry: FixedIndexEntry{
+				Mode: ModeGitlink,
+				Sha1: unsafeSha1FromString("8476ca7ecd5f56b5f7016740fc00f2c0e8479f65"),
+			},
+		},
+	}
+	treeid, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := unsafeSha1FromString("d5d3901ae0ec61165f007cca75aa91be3711940a"); treeid != TreeID(expected) {
+		t.Errorf("Unexpected hash for gitlink tree: got %v want %v", treeid, expected)
+	}
+}
+
+// Tests that write-tree records the trees in the cache-tree index extension
+// and reuses them while the directories are unchanged.
+func TestWriteTreeCacheTree(t *testing.T) {
+	c, cleanup := testRepo(t, "gitcachetree")
+	defer cleanup()
+
+	testCommitFile(t, c, "a/b/one.txt", "one\n", "First commit")
+	testCommitFile(t, c, "a/two.txt", "two\n", "Second commit")
+	testCommitFile(t, c, "c/three.txt", "three\n", "Third commit")
+
+	first, err := WriteTree(c, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"", "a", "a/b", "c"} {
+		if node := idx.cacheTree.lookup(dir); !node.valid() {
+			t.Errorf("Cache-tree for %q is not valid after write-tree", dir)
+		}
+	}
+	sub := idx.cacheTree.lookup("a/b")
+	if sub == nil {
+		t.Fatal("Cache-tree for a/b was not written")
+	}
+
+	// Remove one of the subtrees, so that it would have to be written
+	// again by anything that didn't use the cache.
+	subtree := sub.tree.String()
+	subtreeFile := c.GitDir.File(File("objects/" + subtree[:2] + "/" + subtree[2:]))
+	if err := os.Remove(subtreeFile.String()); err != nil {
+		t.Fatal(err)
+	}
+	// Use a new client so that the removed object isn't in its cache.
+	c, err = NewClient(c.GitDir.String(), c.WorkDir.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := WriteTree(c, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Errorf("Unexpected tree for unchanged index: got %v want %v", second, first)
+	}
+	if subtreeFile.Exists() {
+		t.Error("Unchanged subtree was written again instead of using the cache-tree")
+	}
+
+	// Changing a file only invalidates the directories above it.
+	if err := ioutil.WriteFile("c/three.txt", []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(c, AddOptions{}, []File{"c/three.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	idx, err = c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, expected := range map[string]bool{"": false, "c": false, "a": true, "a/b": true} {
+		if valid := idx.cacheTree.lookup(dir).valid(); valid != expected {
+			t.Errorf("Unexpected validity of cache-tree for %q after add: got %v want %v", dir, valid, expected)
+		}
+	}
+	third, err := WriteTree(c, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first {
+		t.Error("Tree did not change after modifying a file")
+	}
+
+	// Writing everything from scratch gives the same tree.
+	idx, err = c.GitDir.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.cacheTree = nil
+	fresh, err := WriteTreeFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh != third {
+		t.Errorf("Unexpected tree from cache-tree: got %v want %v", third, fresh)
+	}
+}
+
+func TestWriteTreePrefix(t *testing.T) {
+	c, cleanup := testRepo(t, "gitwritetreeprefix")
+	defer cleanup()
+
+	testCommitFile(t, c, "a/b/one.txt", "one\n", "First commit")
+	testCommitFile(t, c, "a/two.txt", "two\n", "Second commit")
+	testCommitFile(t, c, "a.txt", "a\n", "Third commit")
+	testCommitFile(t, c, "ab/three.txt", "three\n", "Fourth commit")
+
+	full, err := WriteTree(c, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := full.GetAllObjects(c, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, ok := top["a"]
+	if !ok {
+		t.Fatal("Tree a is missing from the full tree")
+	}
+	sub, err := TreeID(a.Sha1).GetAllObjects(c, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for prefix, expected := range map[string]Sha1{
+		"a":   a.Sha1,
+		"a/":  a.Sha1,
+		"a/b": sub["b"].Sha1,
+		"ab":  top["ab"].Sha1,
+	} {
+		// Use a new index without a cache-tree, so that the trees
+		// are written rather than looked up.
+		idx, err := c.GitDir.ReadIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx.cacheTree = nil
+		for _, tree := range []func() (TreeID, error){
+			func() (TreeID, error) { return WriteTree(c, WriteTreeOptions{Prefix: prefix}) },
+			func() (TreeID, error) { return WriteTreeFromIndex(c, idx, WriteTreeOptions{Prefix: prefix}) },
+		} {
+			tree, err := tree()
+			if err != nil {
+				t.Errorf("Could not write tree for prefix %q: %v", prefix, err)
+			} else if Sha1(tree) != expected {
+				t.Errorf("Unexpected tree for prefix %q: got %v want %v", prefix, tree, expected)
+			}
+		}
+	}
+
+	// Prefixes which aren't directories in the index are errors, even if
+	// some paths start with them.
+	for _, prefix := range []string{"nonexistent", "a/b/one", "a.txt", "a/b/one.txt"} {
+		if tree, err := WriteTree(c, WriteTreeOptions{Prefix: prefix}); err == nil {
+			t.Errorf("Expected an error for prefix %q, got tree %v", prefix, tree)
+		}
+	}
+}
+
+func TestWriteTreeSHA256(t *testing.T) {
+	c, cleanup := testRepo(t, "gitwritetreesha256")
+	defer cleanup()
+
+	// The empty tree in a SHA-1 repository.
+	tree, err := WriteTreeID(c, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tree.String(), "4b825dc642cb6eb9a060e54bf8d69288fbee4904"; got != want {
+		t.Errorf("Unexpected SHA-1 empty tree: got %v want %v", got, want)
+	}
+
+	c.SetCachedConfig("core.repositoryformatversion", "1")
+	c.SetCachedConfig("extensions.objectformat", "sha256")
+	if h := c.HashAlgorithm(); h != HashSHA256 {
+		t.Fatalf("Unexpected hash algorithm: got %v", h)
+	}
+	tree, err = WriteTreeID(c, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const emptyTree = "6ef19b41225c5369f1c104d45d8d85efa9b057b53b14b4b9b939dd74decc5321"
+	if tree.String() != emptyTree || tree.Algorithm != HashSHA256 {
+		t.Errorf("Unexpected SHA-256 empty tree: got %v %v want %v", tree.Algorithm, tree, emptyTree)
+	}
+	if !c.GitDir.File(File("objects/6e/" + emptyTree[2:])).Exists() {
+		t.Error("SHA-256 tree was not written as a loose object")
+	}
+
+	// The ID of a ref can be read with either algorithm, but a SHA-256
+	// ID isn't a Sha1.
+	if err := c.GitDir.WriteFile("refs/heads/tree", []byte(emptyTree+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := RefSpec("refs/heads/tree").ObjectID(c); err != nil || id != tree {
+		t.Errorf("Unexpected ref value: got %v %v want %v", id, err, tree)
+	}
+	if _, err := RefSpec("refs/heads/tree").Sha1(c); err == nil {
+		t.Error("Expected an error for a SHA-256 ref as a Sha1")
+	}
+	if _, err := c.WriteObject("blob", nil); err == nil {
+		t.Error("Expected an error writing a SHA-1 object in a SHA-256 repository")
+	}
+
+	// Trees of SHA-256 entries are written with the same IDs as git's,
+	// including the subtrees.
+	idx := NewIndex()
+	for _, file := range []struct {
+		path    IndexPath
+		mode    EntryMode
+		content string
+	}{{"a/b.txt", ModeBlob, "hi\n"}, {"c", ModeExec, "x\n"}} {
+		blob, err := c.WriteObjectID("blob", []byte(file.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := &IndexEntry{PathName: file.path}
+		entry.Mode = file.mode
+		entry.SetObjectID(blob)
+		if entry.ObjectID() != blob {
+			t.Fatalf("Unexpected entry ID: got %v want %v", entry.ObjectID(), blob)
+		}
+		idx.Objects = append(idx.Objects, entry)
+	}
+	tree, err = WriteTreeIDFromIndex(c, idx, WriteTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tree.String(), "617045175bcd0696eb3200fa8c1c1506818d45256423b7d32583d7893790cd81"; got != want {
+		t.Errorf("Unexpected SHA-256 tree: got %v want %v", got, want)
+	}
+	const subtree = "00ef94cd27bf7858fec927bdee034255ff57a97011bf00dba0bbeb36a438a237"
+	if !c.GitDir.File(File("objects/00/" + subtree[2:])).Exists() {
+		t.Error("SHA-256 subtree was not written")
+	}
+	if sub, err := WriteTreeIDFromIndex(c, idx, WriteTreeOptions{Prefix: "a"}); err != nil || sub.String() != subtree {
+		t.Errorf("Unexpected SHA-256 prefix tree: got %v (%v) want %v", sub, err, subtree)
+	}
+	if idx.cacheTree != nil {
+		t.Error("SHA-256 trees were saved in the SHA-1 cache-tree")
+	}
+	if tmp, _ := filepath.Glob(c.GitDir.File("objects/*/tmp_obj_*").String()); len(tmp) != 0 {
+		t.Errorf("Temporary object files were left behind: %v", tmp)
+	}
+
+	// SHA-1 entries, from an index file, can't be in a SHA-256 tree.
+	idx.Objects[0].SetObjectID(hashString("hi\n").ObjectID())
+	if _, err := WriteTreeIDFromIndex(c, idx, WriteTreeOptions{MissingOk: true}); err == nil {
+		t.Error("Expected an error writing a SHA-1 entry in a SHA-256 tree")
+	}
 }
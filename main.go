@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
 
 	"github.com/driusan/dgit/cmd"
@@ -17,13 +18,36 @@ var InvalidArgument error = errors.New("Invalid argument to function")
 
 func requiresGitDir(cmd string) bool {
 	switch cmd {
-	case "init", "clone", "ls-remote":
+	case "init", "clone", "ls-remote", "receive-pack", "upload-pack", "daemon":
 		return false
 	default:
 		return true
 	}
 }
 
+// Starts a pager for the output of the subcommand and redirects os.Stdout to
+// it, unless nopager is set or the output isn't a terminal. The returned
+// function must be called once the subcommand is done to wait for the
+// pager to exit.
+func startPager(c *git.Client, nopager bool) func() {
+	if c == nil {
+		return func() {}
+	}
+	stdout := os.Stdout
+	p, err := git.NewPager(c, git.PagerOptions{NoPager: nopager}, stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not start pager: %v\n", err)
+		return func() {}
+	}
+	if pipe := p.Stdin(); pipe != nil {
+		os.Stdout = pipe
+	}
+	return func() {
+		os.Stdout = stdout
+		p.Close()
+	}
+}
+
 var subcommand, subcommandUsage string
 var globalOptsInUsage bool = true
 
@@ -49,6 +73,16 @@ func main() {
 		log.SetOutput(logfile)
 	}
 
+	// Release any locks that are held if dgit is interrupted, so that
+	// the repository isn't left locked.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		git.RollbackLockFiles()
+		os.Exit(130)
+	}()
+
 	wd, _ := os.Getwd()
 
 	log.Printf("Dgit started: (%v) %v\n", wd, os.Args)
@@ -66,6 +100,8 @@ func main() {
 	gitdir := flag.String("git-dir", "", "specify the repository of git")
 	dir := flag.String("C", "", "chdir before starting git")
 	superprefix := flag.String("super-prefix", "", "useless option used internally by git test suite")
+	noreplace := flag.Bool("no-replace-objects", false, "do not use replacement refs to replace git objects")
+	nopager := flag.Bool("no-pager", false, "do not pipe output into a pager")
 	configs := []string{}
 	flag.Var(cmd.NewMultiStringValue(&configs), "c", "configuration parameter var.name=value")
 
@@ -128,6 +164,9 @@ func main() {
 	if *superprefix != "" {
 		c.SuperPrefix = *superprefix
 	}
+	if *noreplace && c != nil {
+		c.NoReplaceObjects = true
+	}
 
 	switch subcommand {
 	case "init":
@@ -198,7 +237,9 @@ func main() {
 		}
 	case "log":
 		subcommandUsage = "[commitish]"
+		closePager := startPager(c, *nopager)
 		err := cmd.Log(c, args)
+		closePager()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(4)
@@ -297,6 +338,12 @@ func main() {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
+	case "mv":
+		subcommandUsage = "<source>... <destination>"
+		if err := cmd.Mv(c, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
 	case "hash-object":
 		subcommandUsage = "[<file>...]"
 		cmd.HashObject(c, args)
@@ -322,6 +369,23 @@ func main() {
 		cmd.PackObjects(c, os.Stdin, args)
 	case "send-pack":
 		cmd.SendPack(c, args)
+	case "receive-pack":
+		subcommandUsage = "<directory>"
+		if err := cmd.ReceivePack(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(128)
+		}
+	case "upload-pack":
+		subcommandUsage = "<directory>"
+		if err := cmd.UploadPack(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(128)
+		}
+	case "daemon":
+		if err := cmd.Daemon(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(128)
+		}
 	case "read-tree":
 		if err := cmd.ReadTree(c, args); err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
@@ -329,7 +393,10 @@ func main() {
 		}
 	case "diff":
 		subcommandUsage = "[<path>...]"
-		if err := cmd.Diff(c, args); err != nil {
+		closePager := startPager(c, *nopager)
+		err := cmd.Diff(c, args)
+		closePager()
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(4)
 		}
@@ -359,6 +426,42 @@ func main() {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(4)
 		}
+	case "commit-graph":
+		subcommandUsage = "write"
+		if err := cmd.CommitGraph(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "gc":
+		subcommandUsage = "[--auto] [--quiet]"
+		if err := cmd.GC(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "multi-pack-index":
+		subcommandUsage = "write"
+		if err := cmd.MultiPackIndex(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "verify-commit":
+		subcommandUsage = "<commit>..."
+		if err := cmd.VerifyCommit(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "verify-tag":
+		subcommandUsage = "<tag>..."
+		if err := cmd.VerifyTag(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "verify-pack":
+		subcommandUsage = "<pack>.idx..."
+		if err := cmd.VerifyPack(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	case "update-index":
 		if err := cmd.UpdateIndex(c, args); err != nil {
 			fmt.Fprintln(os.Stderr, err)
@@ -375,6 +478,24 @@ func main() {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(4)
 		}
+	case "blame":
+		subcommandUsage = "[<rev>] [--] <file>"
+		if err := cmd.Blame(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(128)
+		}
+	case "cherry":
+		subcommandUsage = "[-v] [<upstream> [<head> [<limit>]]]"
+		if err := cmd.Cherry(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(128)
+		}
+	case "range-diff":
+		subcommandUsage = "<base1>..<tip1> <base2>..<tip2>"
+		if err := cmd.RangeDiff(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(128)
+		}
 	case "apply":
 		subcommandUsage = "[<patch>...]"
 		if err := cmd.Apply(c, args); err != nil {
@@ -388,11 +509,44 @@ func main() {
 			os.Exit(4)
 		}
 	case "show":
-		subcommandUsage = "<commit>..."
-		if err := cmd.Show(c, args); err != nil {
+		subcommandUsage = "<object>..."
+		closePager := startPager(c, *nopager)
+		err := cmd.Show(c, args)
+		closePager()
+		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(4)
 		}
+	case "notes":
+		subcommandUsage = "[list | add | show | remove] [<object>]"
+		if err := cmd.Notes(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "bundle":
+		subcommandUsage = "create <file> <git-rev-list-args> | verify <file> | list-heads <file> | unbundle <file>"
+		if err := cmd.Bundle(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "fast-export":
+		subcommandUsage = "[--no-data] <refs>..."
+		if err := cmd.FastExport(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "fast-import":
+		subcommandUsage = "[--force]"
+		if err := cmd.FastImport(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "replace":
+		subcommandUsage = "[-f] <object> <replacement> | -d <object>... | -l"
+		if err := cmd.Replace(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	case "mktag":
 		tagid, err := cmd.Mktag(c, args)
 		if err != nil {
@@ -423,7 +577,7 @@ func main() {
 			os.Exit(128)
 		}
 	case "submodule":
-		subcommandUsage = "update"
+		subcommandUsage = "[--quiet] [status | init [<path>...] | update]"
 		if err := cmd.Submodule(c, args); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(4)
@@ -468,6 +622,24 @@ func main() {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
+	case "subtree":
+		subcommandUsage = "split --prefix=<prefix> [-b <branch>] [<commit>] | add --prefix=<prefix> [-m <message>] <commit> | merge --prefix=<prefix> [-m <message>] <commit>"
+		if err := cmd.Subtree(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "worktree":
+		subcommandUsage = "prune [-n] [-v] [--expire <expire>] | repair [<path>...]"
+		if err := cmd.Worktree(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "stash":
+		subcommandUsage = "[push [-m <message>] | list | show [-p] [<stash>] | apply [--index] [<stash>] | pop [--index] [<stash>] | drop [<stash>] | branch <branchname> [<stash>]]"
+		if err := cmd.Stash(c, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	case "help":
 		flag.CommandLine.SetOutput(os.Stdout)
 		flag.Usage()
@@ -502,6 +674,9 @@ func main() {
    push
    pack-objects
    send-pack
+   receive-pack     Receive what is pushed into the repository
+   upload-pack      Send objects packed back to git-fetch-pack
+   daemon           A really simple server for Git repositories
    read-tree
    diff
    diff-files
@@ -512,6 +687,9 @@ func main() {
    update-index
    unpack-objects
    grep
+   blame
+   range-diff
+   cherry
    apply
    revert
    help
@@ -519,6 +697,11 @@ func main() {
    var              Show a Git logical variable
    submodule        Initialize, update or inspect submodules
    showref          List references in a local repository
+   stash            Stash the changes in a dirty working directory away
+   subtree          Merge subtrees together and split a repository into subtrees
+   worktree         Manage multiple working trees
+   gc               Cleanup unnecessary files and optimize the local repository
+   mv               Move or rename a file, a directory, or a symlink
    archive
 `)
 